@@ -7,26 +7,31 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 // Attachment represents a file attachment
 type Attachment struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
-	MimeType    string            `json:"mime_type"`
-	Size        int64             `json:"size"`
-	Checksum    string            `json:"checksum"`
-	CreatedAt   int64             `json:"created_at"`
-	Data        []byte            `json:"data,omitempty"`        // For small attachments
-	URL         string            `json:"url,omitempty"`         // For large attachments
-	Chunks      []*AttachmentChunk `json:"chunks,omitempty"`     // For chunked attachments
-	Metadata    map[string]any    `json:"metadata,omitempty"`
-	Encrypted   bool              `json:"encrypted,omitempty"`
-	Compression string            `json:"compression,omitempty"` // gzip, etc.
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	MimeType    string             `json:"mime_type"`
+	Size        int64              `json:"size"`
+	Checksum    string             `json:"checksum"`
+	CreatedAt   int64              `json:"created_at"`
+	Data        []byte             `json:"data,omitempty"`   // For small attachments
+	URL         string             `json:"url,omitempty"`    // For large attachments
+	Chunks      []*AttachmentChunk `json:"chunks,omitempty"` // For chunked attachments
+	Metadata    map[string]any     `json:"metadata,omitempty"`
+	Encrypted   bool               `json:"encrypted,omitempty"`
+	Compression string             `json:"compression,omitempty"` // gzip, etc.
+	Media       *MediaInfo         `json:"media,omitempty"`       // Image/audio/video metadata
 }
 
 // AttachmentChunk represents a chunk of a large attachment
@@ -39,34 +44,68 @@ type AttachmentChunk struct {
 
 // AttachmentManager manages file attachments
 type AttachmentManager struct {
-	maxFileSize   int64
-	maxChunkSize  int64
-	allowedTypes  map[string]bool
-	storageDir    string
-	enableChunking bool
+	maxFileSize        int64
+	maxChunkSize       int64
+	inlineLimit        int64
+	allowedTypes       map[string]bool
+	storageDir         string
+	enableChunking     bool
+	httpClient         *http.Client
+	stripImageMetadata bool
+	extractMediaInfo   bool
+	adaptiveChunking   bool
+	minChunkSize       int64
+	hashedFileNames    bool
+	tempDir            string
+	tempFileTTL        time.Duration
+	tempFiles          *tempFiles
+	tempMutex          sync.Mutex
+	scanner            ScanFunc
+	idGenerator        utils.IDGenerator
+	hooks              *EventHooks
+	quarantined        map[string]*QuarantineError // Attachments the scanner rejected, by ID
+	eventMutex         sync.RWMutex
 }
 
 // AttachmentConfig holds configuration for attachment handling
 type AttachmentConfig struct {
-	MaxFileSize    int64             // Maximum file size in bytes
-	MaxChunkSize   int64             // Maximum chunk size for large files
-	AllowedTypes   []string          // Allowed MIME types (empty = all allowed)
-	StorageDir     string            // Directory for storing large attachments
-	EnableChunking bool              // Enable chunking for large files
-	EnableInline   bool              // Enable inline attachments for small files
-	InlineLimit    int64             // Maximum size for inline attachments
+	MaxFileSize        int64             // Maximum file size in bytes
+	MaxChunkSize       int64             // Maximum chunk size for large files
+	AllowedTypes       []string          // Allowed MIME types (empty = all allowed)
+	StorageDir         string            // Directory for storing large attachments
+	EnableChunking     bool              // Enable chunking for large files
+	EnableInline       bool              // Enable inline attachments for small files
+	InlineLimit        int64             // Maximum size for inline attachments
+	HTTPClient         *http.Client      // HTTP client for downloading URL attachments (nil = default)
+	StripImageMetadata bool              // Remove EXIF/GPS and text metadata from JPEG and PNG images (opt-in: rewrites the file, changing its hash and size, and drops the EXIF Orientation, so photos may show rotated)
+	ExtractMediaInfo   bool              // Record image dimensions and audio/video duration
+	AdaptiveChunking   bool              // Size remote transfer chunks from measured throughput and errors
+	MinChunkSize       int64             // Smallest adaptive chunk (0 = DefaultMinChunkSize)
+	HashedFileNames    bool              // Name stored files by the SHA-256 of the attachment ID; the ID and name are kept only in metadata
+	Scanner            ScanFunc          // Inspects received data on validation and download; rejected attachments are quarantined
+	IDGenerator        utils.IDGenerator // Generates attachment IDs (nil = from the current time)
+
+	// Materialized temporary files
+	TempDir     string        // Directory for materialized attachments ("" = a new directory under the system temp dir)
+	TempFileTTL time.Duration // Materialized attachments are removed after this long (0 = only on release or close)
 }
 
 // DefaultAttachmentConfig returns a default attachment configuration
 func DefaultAttachmentConfig() *AttachmentConfig {
 	return &AttachmentConfig{
-		MaxFileSize:    50 * 1024 * 1024, // 50MB
-		MaxChunkSize:   1024 * 1024,      // 1MB chunks
-		AllowedTypes:   []string{},       // Allow all types
-		StorageDir:     "./attachments",
-		EnableChunking: true,
-		EnableInline:   true,
-		InlineLimit:    1024 * 1024, // 1MB inline limit
+		MaxFileSize:        50 * 1024 * 1024, // 50MB
+		MaxChunkSize:       1024 * 1024,      // 1MB chunks
+		AllowedTypes:       []string{},       // Allow all types
+		StorageDir:         "./attachments",
+		EnableChunking:     true,
+		EnableInline:       true,
+		InlineLimit:        1024 * 1024, // 1MB inline limit
+		StripImageMetadata: false,       // Opt-in: stripping rewrites the user's files
+		ExtractMediaInfo:   true,
+		AdaptiveChunking:   true,
+		MinChunkSize:       DefaultMinChunkSize,
+
+		TempFileTTL: time.Hour,
 	}
 }
 
@@ -89,12 +128,29 @@ func NewAttachmentManager(config *AttachmentConfig) (*AttachmentManager, error)
 		allowedTypes[mimeType] = true
 	}
 
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+
 	return &AttachmentManager{
-		maxFileSize:    config.MaxFileSize,
-		maxChunkSize:   config.MaxChunkSize,
-		allowedTypes:   allowedTypes,
-		storageDir:     config.StorageDir,
-		enableChunking: config.EnableChunking,
+		maxFileSize:        config.MaxFileSize,
+		maxChunkSize:       config.MaxChunkSize,
+		inlineLimit:        config.InlineLimit,
+		allowedTypes:       allowedTypes,
+		storageDir:         config.StorageDir,
+		enableChunking:     config.EnableChunking,
+		httpClient:         httpClient,
+		stripImageMetadata: config.StripImageMetadata,
+		extractMediaInfo:   config.ExtractMediaInfo,
+		adaptiveChunking:   config.AdaptiveChunking,
+		minChunkSize:       config.MinChunkSize,
+		hashedFileNames:    config.HashedFileNames,
+		tempDir:            config.TempDir,
+		tempFileTTL:        config.TempFileTTL,
+		scanner:            config.Scanner,
+		idGenerator:        config.IDGenerator,
+		quarantined:        make(map[string]*QuarantineError),
 	}, nil
 }
 
@@ -135,6 +191,9 @@ func (am *AttachmentManager) CreateAttachmentFromFile(filePath string) (*Attachm
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	// Strip metadata and record media information
+	data, mediaInfo := am.processMedia(data, mimeType)
+
 	// Calculate checksum
 	checksum := am.calculateChecksum(data)
 
@@ -143,10 +202,11 @@ func (am *AttachmentManager) CreateAttachmentFromFile(filePath string) (*Attachm
 		ID:        am.generateID(),
 		Name:      filepath.Base(filePath),
 		MimeType:  mimeType,
-		Size:      fileInfo.Size(),
+		Size:      int64(len(data)),
 		Checksum:  checksum,
 		CreatedAt: time.Now().Unix(),
 		Metadata:  make(map[string]any),
+		Media:     mediaInfo,
 	}
 
 	// Add file metadata
@@ -154,7 +214,7 @@ func (am *AttachmentManager) CreateAttachmentFromFile(filePath string) (*Attachm
 	attachment.Metadata["extension"] = filepath.Ext(filePath)
 
 	// Handle based on size
-	if fileInfo.Size() <= am.maxChunkSize || !am.enableChunking {
+	if am.fitsInline(int64(len(data))) {
 		// Store inline
 		attachment.Data = data
 	} else {
@@ -169,7 +229,8 @@ func (am *AttachmentManager) CreateAttachmentFromFile(filePath string) (*Attachm
 	return attachment, nil
 }
 
-// CreateAttachmentFromData creates an attachment from raw data
+// CreateAttachmentFromData creates an attachment from raw data. The name is passed
+// through SanitizeFileName so it is safe to use as a file name on receipt.
 func (am *AttachmentManager) CreateAttachmentFromData(name string, data []byte, mimeType string) (*Attachment, error) {
 	// Check file size
 	if int64(len(data)) > am.maxFileSize {
@@ -186,22 +247,26 @@ func (am *AttachmentManager) CreateAttachmentFromData(name string, data []byte,
 		return nil, fmt.Errorf("MIME type %s not allowed", mimeType)
 	}
 
+	// Strip metadata and record media information
+	data, mediaInfo := am.processMedia(data, mimeType)
+
 	// Calculate checksum
 	checksum := am.calculateChecksum(data)
 
 	// Create attachment
 	attachment := &Attachment{
 		ID:        am.generateID(),
-		Name:      name,
+		Name:      SanitizeFileName(name),
 		MimeType:  mimeType,
 		Size:      int64(len(data)),
 		Checksum:  checksum,
 		CreatedAt: time.Now().Unix(),
 		Metadata:  make(map[string]any),
+		Media:     mediaInfo,
 	}
 
 	// Handle based on size
-	if int64(len(data)) <= am.maxChunkSize || !am.enableChunking {
+	if am.fitsInline(int64(len(data))) {
 		// Store inline
 		attachment.Data = data
 	} else {
@@ -223,17 +288,14 @@ func (am *AttachmentManager) SaveAttachment(attachment *Attachment) error {
 	}
 
 	// Create attachment file path
-	filePath := filepath.Join(am.storageDir, attachment.ID)
-
-	// Save attachment metadata
-	metadataPath := filePath + ".meta"
-	metadataData, err := json.Marshal(attachment)
+	filePath, err := am.storagePath(attachment.ID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal attachment metadata: %w", err)
+		return err
 	}
 
-	if err := os.WriteFile(metadataPath, metadataData, 0644); err != nil {
-		return fmt.Errorf("failed to save attachment metadata: %w", err)
+	// Save attachment metadata
+	if err := writeMetadata(filePath, attachment); err != nil {
+		return err
 	}
 
 	// Save attachment data if inline
@@ -262,9 +324,13 @@ func (am *AttachmentManager) LoadAttachment(attachmentID string) (*Attachment, e
 		return nil, fmt.Errorf("no storage directory configured")
 	}
 
+	filePath, err := am.storagePath(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load attachment metadata
-	metadataPath := filepath.Join(am.storageDir, attachmentID+".meta")
-	metadataData, err := os.ReadFile(metadataPath)
+	metadataData, err := os.ReadFile(filePath + ".meta")
 	if err != nil {
 		return nil, fmt.Errorf("failed to load attachment metadata: %w", err)
 	}
@@ -273,9 +339,12 @@ func (am *AttachmentManager) LoadAttachment(attachmentID string) (*Attachment, e
 	if err := json.Unmarshal(metadataData, &attachment); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal attachment metadata: %w", err)
 	}
+	if attachment.ID != attachmentID {
+		return nil, fmt.Errorf("attachment metadata is for %q, not %q", attachment.ID, attachmentID)
+	}
+	attachment.Name = SanitizeFileName(attachment.Name)
 
 	// Load attachment data if inline
-	filePath := filepath.Join(am.storageDir, attachmentID)
 	if _, err := os.Stat(filePath); err == nil {
 		data, err := os.ReadFile(filePath)
 		if err != nil {
@@ -299,16 +368,33 @@ func (am *AttachmentManager) LoadAttachment(attachmentID string) (*Attachment, e
 	return &attachment, nil
 }
 
-// ValidateAttachment validates an attachment's integrity
+// ValidateAttachment validates an attachment's integrity and runs the configured
+// scanner over its data, returning a *QuarantineError if the scanner rejects it
 func (am *AttachmentManager) ValidateAttachment(attachment *Attachment) error {
+	if err := attachment.Verify(); err != nil {
+		return am.validationFailed(attachment, err)
+	}
+	if am.scanner == nil {
+		return nil
+	}
+
+	data, err := am.GetAttachmentData(attachment)
+	if err != nil {
+		return err
+	}
+	return am.scan(attachment, data)
+}
+
+// Verify checks the attachment's data against its recorded size and checksum
+func (a *Attachment) Verify() error {
 	var data []byte
 
 	// Get data based on storage type
-	if len(attachment.Data) > 0 {
-		data = attachment.Data
-	} else if len(attachment.Chunks) > 0 {
+	if len(a.Data) > 0 {
+		data = a.Data
+	} else if len(a.Chunks) > 0 {
 		// Reassemble chunks
-		for _, chunk := range attachment.Chunks {
+		for _, chunk := range a.Chunks {
 			data = append(data, chunk.Data...)
 		}
 	} else {
@@ -316,14 +402,13 @@ func (am *AttachmentManager) ValidateAttachment(attachment *Attachment) error {
 	}
 
 	// Validate size
-	if int64(len(data)) != attachment.Size {
-		return fmt.Errorf("size mismatch: expected %d, got %d", attachment.Size, len(data))
+	if int64(len(data)) != a.Size {
+		return fmt.Errorf("size mismatch: expected %d, got %d", a.Size, len(data))
 	}
 
 	// Validate checksum
-	checksum := am.calculateChecksum(data)
-	if checksum != attachment.Checksum {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", attachment.Checksum, checksum)
+	if sum := checksum(data); sum != a.Checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", a.Checksum, sum)
 	}
 
 	return nil
@@ -331,6 +416,10 @@ func (am *AttachmentManager) ValidateAttachment(attachment *Attachment) error {
 
 // GetAttachmentData returns the complete data of an attachment
 func (am *AttachmentManager) GetAttachmentData(attachment *Attachment) ([]byte, error) {
+	if err := am.quarantineError(attachment.ID); err != nil {
+		return nil, err
+	}
+
 	if len(attachment.Data) > 0 {
 		return attachment.Data, nil
 	}
@@ -346,38 +435,65 @@ func (am *AttachmentManager) GetAttachmentData(attachment *Attachment) ([]byte,
 	return nil, fmt.Errorf("attachment has no data")
 }
 
+// fitsInline reports whether data of the given size is stored inline rather than
+// chunked. Without chunking everything is inline; otherwise the smaller of the
+// chunk size and InlineLimit applies.
+func (am *AttachmentManager) fitsInline(size int64) bool {
+	if !am.enableChunking {
+		return true
+	}
+	limit := am.maxChunkSize
+	if am.inlineLimit > 0 && am.inlineLimit < limit {
+		limit = am.inlineLimit
+	}
+	return size <= limit
+}
+
 // createChunks splits data into chunks
 func (am *AttachmentManager) createChunks(data []byte) ([]*AttachmentChunk, error) {
+	return splitChunks(data, am.maxChunkSize), nil
+}
+
+// splitChunks splits data into chunks of at most chunkSize bytes
+func splitChunks(data []byte, chunkSize int64) []*AttachmentChunk {
 	var chunks []*AttachmentChunk
-	
-	for i := 0; i < len(data); i += int(am.maxChunkSize) {
-		end := i + int(am.maxChunkSize)
+
+	for i := 0; i < len(data); i += int(chunkSize) {
+		end := i + int(chunkSize)
 		if end > len(data) {
 			end = len(data)
 		}
-		
+
 		chunkData := data[i:end]
 		chunk := &AttachmentChunk{
 			Index:    len(chunks),
 			Size:     len(chunkData),
-			Checksum: am.calculateChecksum(chunkData),
+			Checksum: checksum(chunkData),
 			Data:     chunkData,
 		}
-		
+
 		chunks = append(chunks, chunk)
 	}
-	
-	return chunks, nil
+
+	return chunks
 }
 
 // calculateChecksum calculates SHA256 checksum of data
 func (am *AttachmentManager) calculateChecksum(data []byte) string {
+	return checksum(data)
+}
+
+// checksum calculates the base64 SHA256 checksum of data
+func checksum(data []byte) string {
 	hash := sha256.Sum256(data)
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
 // generateID generates a unique ID for an attachment
 func (am *AttachmentManager) generateID() string {
+	if am.idGenerator != nil {
+		return am.idGenerator.NewID()
+	}
 	// Simple ID generation - in production, use UUID or similar
 	return fmt.Sprintf("att_%d", time.Now().UnixNano())
 }
@@ -438,12 +554,12 @@ func (a *Attachment) IsDocument() bool {
 		"text/plain",
 		"text/csv",
 	}
-	
+
 	for _, docType := range docTypes {
 		if a.MimeType == docType {
 			return true
 		}
 	}
-	
+
 	return false
 }
@@ -0,0 +1,156 @@
+package attachments
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinChunkSize is the smallest chunk an adaptive transfer shrinks to
+	// when the server does not advertise a minimum
+	DefaultMinChunkSize = 64 * 1024 // 64KB
+
+	// chunkTargetDuration is how long each chunk should take at the measured throughput
+	chunkTargetDuration = 2 * time.Second
+	// chunkSmoothing weights the newest throughput sample in the moving average
+	chunkSmoothing = 0.3
+	// chunkErrorWindow is the number of recent chunks used to compute the error rate
+	chunkErrorWindow = 10
+	// chunkHighErrorRate stops chunk growth while the link is unreliable
+	chunkHighErrorRate = 0.2
+)
+
+// ChunkSizer picks the size of the next chunk in a transfer from the measured
+// throughput and error rate. Chunks grow towards what can be moved in about two
+// seconds, at most doubling per step, and halve after a failure. Sizes always
+// stay within the configured bounds.
+type ChunkSizer struct {
+	minSize    int64
+	maxSize    int64
+	size       int64
+	throughput float64 // Smoothed bytes per second, zero until the first success
+	outcomes   []bool  // Recent chunk results, true for failures
+	mutex      sync.Mutex
+}
+
+// NewChunkSizer creates a sizer starting at initial bytes. A minSize of zero or
+// less uses DefaultMinChunkSize; a maxSize below minSize is raised to it.
+func NewChunkSizer(minSize, maxSize, initial int64) *ChunkSizer {
+	cs := &ChunkSizer{size: initial}
+	cs.SetBounds(minSize, maxSize)
+	return cs
+}
+
+// SetBounds changes the allowed chunk sizes, e.g. to those advertised by a server
+func (cs *ChunkSizer) SetBounds(minSize, maxSize int64) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if minSize <= 0 {
+		minSize = DefaultMinChunkSize
+		if maxSize > 0 && maxSize < minSize {
+			minSize = maxSize
+		}
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+
+	cs.minSize, cs.maxSize = minSize, maxSize
+	cs.size = cs.clamp(cs.size)
+}
+
+// Bounds returns the minimum and maximum chunk sizes
+func (cs *ChunkSizer) Bounds() (int64, int64) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.minSize, cs.maxSize
+}
+
+// Size returns the size to use for the next chunk
+func (cs *ChunkSizer) Size() int64 {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.size
+}
+
+// Throughput returns the smoothed transfer rate in bytes per second
+func (cs *ChunkSizer) Throughput() float64 {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.throughput
+}
+
+// ErrorRate returns the fraction of recent chunks that failed
+func (cs *ChunkSizer) ErrorRate() float64 {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.errorRate()
+}
+
+// RecordSuccess reports a chunk of n bytes transferred in elapsed time
+func (cs *ChunkSizer) RecordSuccess(n int64, elapsed time.Duration) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.recordOutcome(false)
+	if n <= 0 || elapsed <= 0 {
+		return
+	}
+
+	sample := float64(n) / elapsed.Seconds()
+	if cs.throughput == 0 {
+		cs.throughput = sample
+	} else {
+		cs.throughput = chunkSmoothing*sample + (1-chunkSmoothing)*cs.throughput
+	}
+
+	target := int64(cs.throughput * chunkTargetDuration.Seconds())
+	if target > 2*cs.size {
+		target = 2 * cs.size
+	}
+	// Hold the current size on an unreliable link rather than risking larger retries
+	if target > cs.size && cs.errorRate() > chunkHighErrorRate {
+		target = cs.size
+	}
+	cs.size = cs.clamp(target)
+}
+
+// RecordFailure reports a chunk that failed to transfer and shrinks the next one
+func (cs *ChunkSizer) RecordFailure() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.recordOutcome(true)
+	cs.size = cs.clamp(cs.size / 2)
+}
+
+func (cs *ChunkSizer) recordOutcome(failed bool) {
+	cs.outcomes = append(cs.outcomes, failed)
+	if len(cs.outcomes) > chunkErrorWindow {
+		cs.outcomes = cs.outcomes[len(cs.outcomes)-chunkErrorWindow:]
+	}
+}
+
+func (cs *ChunkSizer) errorRate() float64 {
+	if len(cs.outcomes) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range cs.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(cs.outcomes))
+}
+
+func (cs *ChunkSizer) clamp(size int64) int64 {
+	if size < cs.minSize {
+		return cs.minSize
+	}
+	if size > cs.maxSize {
+		return cs.maxSize
+	}
+	return size
+}
@@ -0,0 +1,141 @@
+package attachments
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// progressStepMin is the least number of bytes between download progress reports
+const progressStepMin = 64 * 1024
+
+// ErrQuarantined is matched by errors for attachments the scanner rejected
+var ErrQuarantined = errors.New("attachment quarantined")
+
+// ScanFunc inspects received attachment data before it is handed to the
+// application. Returning an error, e.g. one naming the detected threat,
+// quarantines the attachment.
+type ScanFunc func(attachment *Attachment, data []byte) error
+
+// QuarantineError is returned for an attachment the scanner rejected, and for
+// any later attempt to read it
+type QuarantineError struct {
+	AttachmentID string
+	Name         string
+	Reason       error
+}
+
+func (e *QuarantineError) Error() string {
+	return fmt.Sprintf("attachment %s (%s) quarantined: %v", e.AttachmentID, e.Name, e.Reason)
+}
+
+// Unwrap lets errors.Is match ErrQuarantined and the scanner's reason
+func (e *QuarantineError) Unwrap() []error {
+	return []error{ErrQuarantined, e.Reason}
+}
+
+// EventHooks receive attachment activity as it happens, so applications can show
+// progress and security warnings without polling. Nil hooks are skipped.
+type EventHooks struct {
+	DownloadProgress func(attachment *Attachment, received, total int64) // total is 0 when unknown
+	ValidationFailed func(attachment *Attachment, err error)
+	Quarantined      func(attachment *Attachment, reason error)
+}
+
+// SetEventHooks sets the hooks that receive attachment activity (nil removes them)
+func (am *AttachmentManager) SetEventHooks(hooks *EventHooks) {
+	am.eventMutex.Lock()
+	defer am.eventMutex.Unlock()
+	am.hooks = hooks
+}
+
+// IsQuarantined returns true if the scanner rejected the attachment
+func (am *AttachmentManager) IsQuarantined(attachmentID string) bool {
+	return am.quarantineError(attachmentID) != nil
+}
+
+// ReleaseQuarantine lets a quarantined attachment be read again, e.g. once the
+// user has chosen to trust it
+func (am *AttachmentManager) ReleaseQuarantine(attachmentID string) {
+	am.eventMutex.Lock()
+	defer am.eventMutex.Unlock()
+	delete(am.quarantined, attachmentID)
+}
+
+// quarantineError returns the error recorded for a quarantined attachment, or nil
+func (am *AttachmentManager) quarantineError(attachmentID string) error {
+	am.eventMutex.RLock()
+	defer am.eventMutex.RUnlock()
+	if err, exists := am.quarantined[attachmentID]; exists {
+		return err
+	}
+	return nil
+}
+
+// getHooks returns the current event hooks, or nil
+func (am *AttachmentManager) getHooks() *EventHooks {
+	am.eventMutex.RLock()
+	defer am.eventMutex.RUnlock()
+	return am.hooks
+}
+
+// scan runs the scanner over received data, quarantining the attachment if it is rejected
+func (am *AttachmentManager) scan(attachment *Attachment, data []byte) error {
+	if am.scanner == nil {
+		return nil
+	}
+	reason := am.scanner(attachment, data)
+	if reason == nil {
+		return nil
+	}
+
+	quarantineErr := &QuarantineError{AttachmentID: attachment.ID, Name: attachment.Name, Reason: reason}
+	am.eventMutex.Lock()
+	am.quarantined[attachment.ID] = quarantineErr
+	am.eventMutex.Unlock()
+
+	if hooks := am.getHooks(); hooks != nil && hooks.Quarantined != nil {
+		hooks.Quarantined(attachment, reason)
+	}
+	return quarantineErr
+}
+
+// validationFailed reports a failed integrity check and returns err
+func (am *AttachmentManager) validationFailed(attachment *Attachment, err error) error {
+	if hooks := am.getHooks(); hooks != nil && hooks.ValidationFailed != nil {
+		hooks.ValidationFailed(attachment, err)
+	}
+	return err
+}
+
+// progressReader reports download progress at most once per step, and once at the end
+type progressReader struct {
+	reader     io.Reader
+	attachment *Attachment
+	report     func(attachment *Attachment, received, total int64)
+	received   int64
+	reported   int64
+	total      int64
+	step       int64
+}
+
+// withProgress wraps r to report progress towards total bytes if a progress hook is set
+func (am *AttachmentManager) withProgress(r io.Reader, attachment *Attachment, total int64) io.Reader {
+	hooks := am.getHooks()
+	if hooks == nil || hooks.DownloadProgress == nil {
+		return r
+	}
+	return &progressReader{reader: r, attachment: attachment, report: hooks.DownloadProgress, total: total, step: max(total/100, progressStepMin)}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.received += int64(n)
+
+	finished := p.received == p.total || errors.Is(err, io.EOF)
+	if p.received > p.reported && (p.received-p.reported >= p.step || finished) {
+		p.reported = p.received
+		p.report(p.attachment, p.received, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,66 @@
+package attachments
+
+import "fmt"
+
+// DeliveryMode describes how an attachment's data travels with a message
+type DeliveryMode string
+
+const (
+	DeliveryInline  DeliveryMode = "inline"  // Data embedded in the message
+	DeliveryChunked DeliveryMode = "chunked" // Data embedded as a sequence of chunks
+	DeliveryURL     DeliveryMode = "url"     // Only a URL reference is sent
+)
+
+// Mode returns how the attachment is currently laid out
+func (a *Attachment) Mode() DeliveryMode {
+	switch {
+	case len(a.Chunks) > 0:
+		return DeliveryChunked
+	case len(a.Data) > 0:
+		return DeliveryInline
+	default:
+		return DeliveryURL
+	}
+}
+
+// ToInline reassembles a chunked attachment so its data is stored inline
+func (a *Attachment) ToInline() error {
+	if len(a.Chunks) == 0 {
+		if len(a.Data) == 0 {
+			return fmt.Errorf("attachment %s has no local data", a.ID)
+		}
+		return nil
+	}
+
+	var data []byte
+	for _, chunk := range a.Chunks {
+		data = append(data, chunk.Data...)
+	}
+	a.Data = data
+	a.Chunks = nil
+	return nil
+}
+
+// ToChunked splits the attachment data into chunks of at most chunkSize bytes
+func (a *Attachment) ToChunked(chunkSize int64) error {
+	if chunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive")
+	}
+	if err := a.ToInline(); err != nil {
+		return err
+	}
+
+	a.Chunks = splitChunks(a.Data, chunkSize)
+	a.Data = nil
+	return nil
+}
+
+// ToURLReference drops local data so only the URL reference is sent
+func (a *Attachment) ToURLReference() error {
+	if a.URL == "" {
+		return fmt.Errorf("attachment %s has no URL", a.ID)
+	}
+	a.Data = nil
+	a.Chunks = nil
+	return nil
+}
@@ -0,0 +1,224 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/gif"  // Register GIF decoder for dimension detection
+	_ "image/jpeg" // Register JPEG decoder for dimension detection
+	_ "image/png"  // Register PNG decoder for dimension detection
+	"time"
+)
+
+// MediaInfo holds standardized metadata for image, audio and video attachments
+type MediaInfo struct {
+	Width            int           `json:"width,omitempty"`
+	Height           int           `json:"height,omitempty"`
+	Duration         time.Duration `json:"duration,omitempty"`
+	MetadataStripped bool          `json:"metadata_stripped,omitempty"` // EXIF/GPS and text metadata was removed
+}
+
+// GetMediaInfo returns the structured media metadata, or nil if none was recorded
+func (a *Attachment) GetMediaInfo() *MediaInfo {
+	return a.Media
+}
+
+// processMedia strips privacy-sensitive metadata and records media information
+// according to the manager's configuration. It returns the (possibly modified)
+// data and the extracted media information.
+func (am *AttachmentManager) processMedia(data []byte, mimeType string) ([]byte, *MediaInfo) {
+	if !am.stripImageMetadata && !am.extractMediaInfo {
+		return data, nil
+	}
+
+	info := &MediaInfo{}
+
+	if am.stripImageMetadata {
+		switch mimeType {
+		case "image/jpeg":
+			if stripped, ok := stripJPEGMetadata(data); ok {
+				data = stripped
+				info.MetadataStripped = true
+			}
+		case "image/png":
+			if stripped, ok := stripPNGMetadata(data); ok {
+				data = stripped
+				info.MetadataStripped = true
+			}
+		}
+	}
+
+	if am.extractMediaInfo {
+		switch {
+		case len(mimeType) > 6 && mimeType[:6] == "image/":
+			if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				info.Width = config.Width
+				info.Height = config.Height
+			}
+		case mimeType == "audio/wav" || mimeType == "audio/x-wav" || mimeType == "audio/wave":
+			info.Duration = wavDuration(data)
+		case mimeType == "video/mp4" || mimeType == "audio/mp4" || mimeType == "video/quicktime":
+			info.Duration = mp4Duration(data)
+		}
+	}
+
+	if *info == (MediaInfo{}) {
+		return data, nil
+	}
+	return data, info
+}
+
+// stripJPEGMetadata removes APP1 (EXIF/XMP), APP13 (IPTC) and comment segments from a JPEG
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, false
+		}
+		marker := data[pos+1]
+
+		// Start of scan: the rest is entropy-coded image data
+		if marker == 0xDA {
+			out = append(out, data[pos:]...)
+			return out, true
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, false
+		}
+
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	return nil, false
+}
+
+// stripPNGMetadata removes eXIf and textual chunks from a PNG
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	signature := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+	if !bytes.HasPrefix(data, signature) {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+
+	pos := len(signature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf", "tEXt", "iTXt", "zTXt", "tIME":
+			// Drop metadata chunk
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	return out, pos == len(data)
+}
+
+// wavDuration computes the duration of a PCM WAV file from its header
+func wavDuration(data []byte) time.Duration {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return 0
+	}
+
+	var byteRate uint32
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkID := string(data[pos : pos+4])
+		size := binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+		body := pos + 8
+
+		switch chunkID {
+		case "fmt ":
+			if body+12 <= len(data) {
+				byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+			}
+		case "data":
+			if byteRate == 0 {
+				return 0
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second))
+		}
+
+		pos = body + int(size) + int(size%2)
+	}
+
+	return 0
+}
+
+// mp4Duration reads the movie duration from the mvhd box of an MP4/QuickTime file
+func mp4Duration(data []byte) time.Duration {
+	moov := findMP4Box(data, "moov")
+	if moov == nil {
+		return 0
+	}
+	mvhd := findMP4Box(moov, "mvhd")
+	if len(mvhd) < 20 {
+		return 0
+	}
+
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// findMP4Box returns the payload of the first box of the given type at this level
+func findMP4Box(data []byte, boxType string) []byte {
+	pos := 0
+	for pos+8 <= len(data) {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		header := uint64(8)
+		if size == 1 {
+			if pos+16 > len(data) {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data) - pos)
+		}
+
+		if size < header || uint64(pos)+size > uint64(len(data)) {
+			return nil
+		}
+
+		if string(data[pos+4:pos+8]) == boxType {
+			return data[uint64(pos)+header : uint64(pos)+size]
+		}
+		pos += int(size)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package attachments
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultReadAhead is the minimum number of bytes fetched per range request by RemoteReader
+const defaultReadAhead = 256 * 1024
+
+// maxChunkRetries is how many times an adaptive reader retries a failed range request with a smaller chunk
+const maxChunkRetries = 3
+
+// DownloadAttachment downloads a URL-referenced attachment. A positive length
+// requests only the bytes [offset, offset+length), while a length of zero or
+// less downloads everything from offset to the end of the file. A complete
+// download is verified against the attachment's recorded size and checksum and
+// passed to the configured scanner.
+func (am *AttachmentManager) DownloadAttachment(attachment *Attachment, offset, length int64) ([]byte, error) {
+	if err := am.quarantineError(attachment.ID); err != nil {
+		return nil, err
+	}
+
+	data, err := am.downloadRange(attachment, offset, length)
+	if err != nil || offset > 0 || length > 0 {
+		return data, err
+	}
+
+	if attachment.Size > 0 && int64(len(data)) != attachment.Size {
+		return nil, am.validationFailed(attachment, fmt.Errorf("size mismatch: expected %d, got %d", attachment.Size, len(data)))
+	}
+	if attachment.Checksum != "" {
+		if sum := checksum(data); sum != attachment.Checksum {
+			return nil, am.validationFailed(attachment, fmt.Errorf("checksum mismatch: expected %s, got %s", attachment.Checksum, sum))
+		}
+	}
+	if err := am.scan(attachment, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// downloadRange fetches the requested bytes of a URL-referenced attachment without verifying them
+func (am *AttachmentManager) downloadRange(attachment *Attachment, offset, length int64) ([]byte, error) {
+	if attachment.URL == "" {
+		return nil, fmt.Errorf("attachment has no URL")
+	}
+
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+
+	if attachment.Size > 0 && offset >= attachment.Size {
+		return nil, io.EOF
+	}
+
+	req, err := http.NewRequest("GET", attachment.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	ranged := offset > 0 || length > 0
+	if ranged {
+		if length > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+
+	resp, err := am.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if err := checkContentRange(resp.Header.Get("Content-Range"), offset, length); err != nil {
+			return nil, err
+		}
+		return am.readBody(attachment, resp.Body, offset, length, false)
+	case http.StatusOK:
+		// Server ignored the range; skip to the requested window ourselves
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, io.EOF
+				}
+				return nil, fmt.Errorf("failed to skip to offset %d: %w", offset, err)
+			}
+		}
+		return am.readBody(attachment, resp.Body, offset, length, true)
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, io.EOF
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+// checkContentRange verifies that a partial response holds the requested range:
+// it must start at offset and, for a bounded request, end within it
+func checkContentRange(contentRange string, offset, length int64) error {
+	var start, end int64
+	var size string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &size); err != nil || start < 0 || end < start {
+		return fmt.Errorf("invalid Content-Range in partial response: %q", contentRange)
+	}
+	if start != offset {
+		return fmt.Errorf("server returned range starting at %d, requested %d", start, offset)
+	}
+	if length > 0 && end > offset+length-1 {
+		return fmt.Errorf("server returned range ending at %d, requested up to %d", end, offset+length-1)
+	}
+	return nil
+}
+
+// readBody reads up to length bytes (or everything if length <= 0) while enforcing
+// the maximum file size, reporting download progress. A body running past the
+// requested length is cut off when truncate is set, i.e. the server ignored the
+// range, and is an error otherwise.
+func (am *AttachmentManager) readBody(attachment *Attachment, body io.Reader, offset, length int64, truncate bool) ([]byte, error) {
+	limit := am.maxFileSize
+	windowed := length > 0 && length < limit
+	if windowed {
+		limit = length
+	}
+
+	total := length
+	if total <= 0 && attachment.Size > 0 {
+		total = attachment.Size - offset
+	}
+	// One byte past the limit tells an oversized body from one that fits exactly
+	data, err := io.ReadAll(am.withProgress(io.LimitReader(body, limit+1), attachment, total))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment data: %w", err)
+	}
+	if int64(len(data)) > limit {
+		switch {
+		case windowed && truncate:
+			data = data[:limit]
+		case windowed:
+			return nil, fmt.Errorf("server returned more than the %d bytes requested", length)
+		default:
+			return nil, fmt.Errorf("attachment exceeds maximum size of %d bytes", am.maxFileSize)
+		}
+	}
+	return data, nil
+}
+
+// RemoteReader is an io.ReadSeeker over a URL-referenced attachment. Reads are
+// served by HTTP range requests, so seeking does not download skipped bytes.
+type RemoteReader struct {
+	manager    *AttachmentManager
+	attachment *Attachment
+	offset     int64
+	buffer     []byte
+	bufferPos  int64
+	readAhead  int64
+	sizer      *ChunkSizer // Adapts the request size when set; otherwise readAhead is used
+}
+
+// NewRemoteReader creates a seekable reader over a URL-referenced attachment.
+// The attachment's Size must be set so that io.SeekEnd can be supported.
+func (am *AttachmentManager) NewRemoteReader(attachment *Attachment) (*RemoteReader, error) {
+	if attachment.URL == "" {
+		return nil, fmt.Errorf("attachment has no URL")
+	}
+	if attachment.Size <= 0 {
+		return nil, fmt.Errorf("attachment size is unknown")
+	}
+
+	reader := &RemoteReader{
+		manager:    am,
+		attachment: attachment,
+		readAhead:  defaultReadAhead,
+	}
+	if am.adaptiveChunking {
+		reader.sizer = NewChunkSizer(am.minChunkSize, am.maxChunkSize, defaultReadAhead)
+	}
+	return reader, nil
+}
+
+// SetReadAhead sets the minimum number of bytes fetched per range request.
+// It disables adaptive chunk sizing.
+func (r *RemoteReader) SetReadAhead(size int64) {
+	if size > 0 {
+		r.readAhead = size
+		r.sizer = nil
+	}
+}
+
+// SetChunkSizer enables adaptive range request sizing with the given sizer
+func (r *RemoteReader) SetChunkSizer(sizer *ChunkSizer) {
+	r.sizer = sizer
+}
+
+// ChunkSizer returns the reader's adaptive sizer, or nil if sizing is fixed
+func (r *RemoteReader) ChunkSizer() *ChunkSizer {
+	return r.sizer
+}
+
+// Read implements io.Reader
+func (r *RemoteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.offset >= r.attachment.Size {
+		return 0, io.EOF
+	}
+
+	// Serve from the buffer when the current offset falls inside it
+	if r.offset < r.bufferPos || r.offset >= r.bufferPos+int64(len(r.buffer)) {
+		data, err := r.fetch(int64(len(p)))
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.EOF
+		}
+
+		r.buffer = data
+		r.bufferPos = r.offset
+	}
+
+	n := copy(p, r.buffer[r.offset-r.bufferPos:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// fetch downloads the next window starting at the current offset. Adaptive
+// readers time each request and retry failures with smaller chunks.
+func (r *RemoteReader) fetch(requested int64) ([]byte, error) {
+	if r.sizer == nil {
+		size := requested
+		if size < r.readAhead {
+			size = r.readAhead
+		}
+		return r.manager.downloadRange(r.attachment, r.offset, size)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= maxChunkRetries; attempt++ {
+		start := time.Now()
+		data, err := r.manager.downloadRange(r.attachment, r.offset, r.sizer.Size())
+		if err == nil {
+			r.sizer.RecordSuccess(int64(len(data)), time.Since(start))
+			return data, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		r.sizer.RecordFailure()
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Seek implements io.Seeker
+func (r *RemoteReader) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.attachment.Size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+
+	if target < 0 {
+		return 0, fmt.Errorf("negative position: %d", target)
+	}
+
+	r.offset = target
+	return target, nil
+}
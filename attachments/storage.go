@@ -0,0 +1,45 @@
+package attachments
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+)
+
+// MaxAttachmentIDLength is the longest attachment ID accepted for storage
+const MaxAttachmentIDLength = 128
+
+// ValidateAttachmentID checks that an attachment ID is safe to use as a file name:
+// 1 to MaxAttachmentIDLength ASCII letters, digits, '-' or '_'. Dots are excluded
+// so an ID can neither traverse directories nor collide with the ".meta" and
+// ".chunk.N" files of another attachment.
+func ValidateAttachmentID(id string) error {
+	if id == "" || len(id) > MaxAttachmentIDLength {
+		return fmt.Errorf("invalid attachment ID %q: must be 1 to %d characters", id, MaxAttachmentIDLength)
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("invalid attachment ID %q: only letters, digits, '-' and '_' are allowed", id)
+		}
+	}
+	return nil
+}
+
+// storagePath returns the base path of an attachment's files in the storage
+// directory. With hashed file names any non-empty ID is accepted, since only its
+// hash reaches the file system; otherwise the ID itself must be valid.
+func (am *AttachmentManager) storagePath(id string) (string, error) {
+	if am.hashedFileNames {
+		if id == "" {
+			return "", fmt.Errorf("attachment ID is required")
+		}
+		hash := sha256.Sum256([]byte(id))
+		return filepath.Join(am.storageDir, hex.EncodeToString(hash[:])), nil
+	}
+
+	if err := ValidateAttachmentID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(am.storageDir, id), nil
+}
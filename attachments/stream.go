@@ -0,0 +1,194 @@
+package attachments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checksum returns the base64 SHA-256 checksum recorded in Attachment.Checksum
+func Checksum(data []byte) string {
+	return checksum(data)
+}
+
+// CreateAttachmentFromReader creates an attachment by streaming r into the storage
+// directory, so memory use stays constant whatever the attachment's size. The
+// returned attachment carries no data; read it with OpenAttachment or
+// StreamAttachmentData, or upload it and send only its URL. JPEG and PNG images
+// are read into memory instead when their metadata must be stripped, since that
+// needs the whole image. Media information is not extracted from streamed data.
+func (am *AttachmentManager) CreateAttachmentFromReader(name string, r io.Reader, mimeType string) (*Attachment, error) {
+	if am.storageDir == "" {
+		return nil, fmt.Errorf("no storage directory configured")
+	}
+
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	if len(am.allowedTypes) > 0 && !am.allowedTypes[mimeType] {
+		return nil, fmt.Errorf("MIME type %s not allowed", mimeType)
+	}
+
+	// Read one byte past the limit to tell a full-size attachment from an oversized one
+	limited := io.LimitReader(r, am.maxFileSize+1)
+
+	if am.stripImageMetadata && (mimeType == "image/jpeg" || mimeType == "image/png") {
+		data, err := io.ReadAll(limited)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment data: %w", err)
+		}
+		if int64(len(data)) > am.maxFileSize {
+			return nil, fmt.Errorf("data size exceeds maximum %d", am.maxFileSize)
+		}
+		return am.CreateAttachmentFromData(name, data, mimeType)
+	}
+
+	attachment := &Attachment{
+		ID:        am.generateID(),
+		Name:      SanitizeFileName(name),
+		MimeType:  mimeType,
+		CreatedAt: time.Now().Unix(),
+		Metadata:  make(map[string]any),
+	}
+	filePath, err := am.storagePath(attachment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream into a temporary file first so a failed copy never leaves a partial attachment
+	file, err := os.CreateTemp(am.storageDir, ".stream-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), limited)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write attachment data: %w", err)
+	}
+	if size > am.maxFileSize {
+		return nil, fmt.Errorf("data size exceeds maximum %d", am.maxFileSize)
+	}
+
+	attachment.Size = size
+	attachment.Checksum = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	if err := writeMetadata(filePath, attachment); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		os.Remove(filePath + ".meta")
+		return nil, fmt.Errorf("failed to store attachment data: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// OpenAttachment returns a reader over an attachment's data, wherever it is held:
+// inline, in chunks, in the storage directory, or at its URL. The data is not
+// verified; use StreamAttachmentData for that. Quarantined attachments cannot be opened.
+func (am *AttachmentManager) OpenAttachment(attachment *Attachment) (io.ReadCloser, error) {
+	if err := am.quarantineError(attachment.ID); err != nil {
+		return nil, err
+	}
+
+	if len(attachment.Data) > 0 {
+		return io.NopCloser(bytes.NewReader(attachment.Data)), nil
+	}
+
+	if len(attachment.Chunks) > 0 {
+		readers := make([]io.Reader, len(attachment.Chunks))
+		for i, chunk := range attachment.Chunks {
+			readers[i] = bytes.NewReader(chunk.Data)
+		}
+		return io.NopCloser(io.MultiReader(readers...)), nil
+	}
+
+	if am.storageDir != "" {
+		if filePath, err := am.storagePath(attachment.ID); err == nil {
+			file, err := os.Open(filePath)
+			if err == nil {
+				return file, nil
+			}
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to open attachment data: %w", err)
+			}
+		}
+	}
+
+	if attachment.URL != "" {
+		return am.openURL(attachment)
+	}
+
+	return nil, fmt.Errorf("attachment has no data")
+}
+
+// StreamAttachmentData copies an attachment's data to w and verifies it against the
+// recorded size and checksum. The data has been written by the time a mismatch is
+// detected, so w's content must not be trusted when an error is returned.
+func (am *AttachmentManager) StreamAttachmentData(w io.Writer, attachment *Attachment) (int64, error) {
+	reader, err := am.OpenAttachment(attachment)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), io.LimitReader(reader, am.maxFileSize+1))
+	if err != nil {
+		return n, fmt.Errorf("failed to stream attachment data: %w", err)
+	}
+	if n > am.maxFileSize {
+		return n, fmt.Errorf("attachment data exceeds maximum %d", am.maxFileSize)
+	}
+
+	if attachment.Size > 0 && n != attachment.Size {
+		return n, fmt.Errorf("size mismatch: expected %d, got %d", attachment.Size, n)
+	}
+	if attachment.Checksum != "" && base64.StdEncoding.EncodeToString(hash.Sum(nil)) != attachment.Checksum {
+		return n, fmt.Errorf("checksum mismatch")
+	}
+	return n, nil
+}
+
+// openURL starts downloading a URL-referenced attachment
+func (am *AttachmentManager) openURL(attachment *Attachment) (io.ReadCloser, error) {
+	resp, err := am.httpClient.Get(attachment.URL)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return resp.Body, nil
+}
+
+// writeMetadata saves an attachment's metadata next to its data file
+func writeMetadata(filePath string, attachment *Attachment) error {
+	metadataData, err := json.Marshal(attachment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attachment metadata: %w", err)
+	}
+
+	if err := os.WriteFile(filePath+".meta", metadataData, 0644); err != nil {
+		return fmt.Errorf("failed to save attachment metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package attachments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxFileNameLength is the longest materialized file name in bytes, the limit of common file systems
+const maxFileNameLength = 255
+
+// TempFile is an attachment written to disk for previews or hand-off to other applications
+type TempFile struct {
+	Path         string
+	MessageID    string
+	AttachmentID string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time // Zero when the file is only removed on release
+}
+
+// tempFiles tracks materialized attachments so they can be removed with their
+// message, on expiry, or when the manager is closed
+type tempFiles struct {
+	dir     string
+	ownsDir bool // The directory was created by us and is removed on close
+	ttl     time.Duration
+	files   map[string]*TempFile // Keyed by path
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+	mutex   sync.Mutex
+}
+
+// SanitizeFileName turns an untrusted attachment name into a single safe path
+// component. Directory parts, control characters and reserved names are removed;
+// an empty result becomes "attachment".
+func SanitizeFileName(name string) string {
+	// Treat both separators as directories regardless of the platform the name came from
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == ':' || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	// Leading dots would hide the file or form "." and ".."
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxFileNameLength-len(ext)], "") + ext
+	}
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+// MaterializeAttachment writes an attachment's data to a private temporary file and
+// returns it. The data is streamed from wherever OpenAttachment finds it and
+// verified on the way. The file is only readable by the current user, is named
+// after the sanitized attachment name, and is removed by ReleaseTempFiles for its
+// message, after the configured TempFileTTL, or by CloseTempFiles.
+func (am *AttachmentManager) MaterializeAttachment(messageID string, attachment *Attachment) (*TempFile, error) {
+	tf, err := am.getTempFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+	if tf.closed {
+		return nil, fmt.Errorf("temporary files are closed")
+	}
+	tf.removeExpiredLocked(time.Now())
+
+	// A private directory per file keeps the original name without collisions
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate temporary directory name: %w", err)
+	}
+	fileDir := filepath.Join(tf.dir, hex.EncodeToString(suffix))
+	if err := os.Mkdir(fileDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	path := filepath.Join(fileDir, SanitizeFileName(attachment.Name))
+	if err := am.writeExclusive(path, attachment); err != nil {
+		os.RemoveAll(fileDir)
+		return nil, err
+	}
+
+	now := time.Now()
+	file := &TempFile{
+		Path:         path,
+		MessageID:    messageID,
+		AttachmentID: attachment.ID,
+		CreatedAt:    now,
+	}
+	if tf.ttl > 0 {
+		file.ExpiresAt = now.Add(tf.ttl)
+	}
+	tf.files[path] = file
+
+	fileCopy := *file
+	return &fileCopy, nil
+}
+
+// TempFiles returns the materialized files of a message, oldest first. An empty
+// messageID returns every file.
+func (am *AttachmentManager) TempFiles(messageID string) []*TempFile {
+	tf := am.existingTempFiles()
+	if tf == nil {
+		return nil
+	}
+
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+
+	var files []*TempFile
+	for _, file := range tf.files {
+		if messageID == "" || file.MessageID == messageID {
+			fileCopy := *file
+			files = append(files, &fileCopy)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.Before(files[j].CreatedAt)
+	})
+	return files
+}
+
+// ReleaseTempFiles removes the materialized files of a message, e.g. when it is deleted
+func (am *AttachmentManager) ReleaseTempFiles(messageID string) error {
+	tf := am.existingTempFiles()
+	if tf == nil {
+		return nil
+	}
+
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+
+	var firstErr error
+	for path, file := range tf.files {
+		if file.MessageID != messageID {
+			continue
+		}
+		if err := tf.removeLocked(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CleanupTempFiles removes expired materialized files and returns how many were removed
+func (am *AttachmentManager) CleanupTempFiles() int {
+	tf := am.existingTempFiles()
+	if tf == nil {
+		return 0
+	}
+
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+	return tf.removeExpiredLocked(time.Now())
+}
+
+// CloseTempFiles removes every materialized file and stops expiry cleanup
+func (am *AttachmentManager) CloseTempFiles() error {
+	tf := am.existingTempFiles()
+	if tf == nil {
+		return nil
+	}
+
+	tf.mutex.Lock()
+	if tf.closed {
+		tf.mutex.Unlock()
+		return nil
+	}
+	tf.closed = true
+	tf.mutex.Unlock()
+
+	if tf.stop != nil {
+		close(tf.stop)
+		<-tf.done
+	}
+
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+
+	var firstErr error
+	for path := range tf.files {
+		if err := tf.removeLocked(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if tf.ownsDir {
+		if err := os.RemoveAll(tf.dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// getTempFiles creates the temporary file directory on first use
+func (am *AttachmentManager) getTempFiles() (*tempFiles, error) {
+	am.tempMutex.Lock()
+	defer am.tempMutex.Unlock()
+
+	if am.tempFiles != nil {
+		return am.tempFiles, nil
+	}
+
+	tf := &tempFiles{
+		ttl:   am.tempFileTTL,
+		files: make(map[string]*TempFile),
+	}
+	if am.tempDir == "" {
+		dir, err := os.MkdirTemp("", "emsg-attachments-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		tf.dir, tf.ownsDir = dir, true
+	} else {
+		if err := os.MkdirAll(am.tempDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		// MkdirAll leaves an existing directory's permissions alone
+		if err := os.Chmod(am.tempDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to restrict temporary directory: %w", err)
+		}
+		tf.dir = am.tempDir
+	}
+
+	if tf.ttl > 0 {
+		tf.stop = make(chan struct{})
+		tf.done = make(chan struct{})
+		go tf.janitor()
+	}
+
+	am.tempFiles = tf
+	return tf, nil
+}
+
+// existingTempFiles returns the temporary files if any were ever materialized
+func (am *AttachmentManager) existingTempFiles() *tempFiles {
+	am.tempMutex.Lock()
+	defer am.tempMutex.Unlock()
+	return am.tempFiles
+}
+
+// janitor removes expired files in the background
+func (tf *tempFiles) janitor() {
+	defer close(tf.done)
+
+	interval := tf.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tf.stop:
+			return
+		case now := <-ticker.C:
+			tf.mutex.Lock()
+			tf.removeExpiredLocked(now)
+			tf.mutex.Unlock()
+		}
+	}
+}
+
+// removeExpiredLocked removes files past their expiry. The caller holds the mutex.
+func (tf *tempFiles) removeExpiredLocked(now time.Time) int {
+	removed := 0
+	for path, file := range tf.files {
+		if !file.ExpiresAt.IsZero() && now.After(file.ExpiresAt) {
+			if tf.removeLocked(path) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// removeLocked deletes a file and its private directory. The caller holds the mutex.
+func (tf *tempFiles) removeLocked(path string) error {
+	if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to remove temporary file: %w", err)
+	}
+	delete(tf.files, path)
+	return nil
+}
+
+// writeExclusive streams an attachment into a new owner-only file, failing if
+// anything already exists at path
+func (am *AttachmentManager) writeExclusive(path string, attachment *Attachment) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if _, err := am.StreamAttachmentData(file, attachment); err != nil {
+		file.Close()
+		return fmt.Errorf("refusing to materialize attachment: %w", err)
+	}
+	return file.Close()
+}
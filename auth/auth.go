@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -13,12 +14,29 @@ import (
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 )
 
+// DefaultAuthHeaderTTL is how long a generated auth header is valid for
+const DefaultAuthHeaderTTL = 5 * time.Minute
+
+// DefaultMaxAuthHeaderTTL is the longest validity a verifier accepts by default
+const DefaultMaxAuthHeaderTTL = time.Hour
+
+// DefaultMaxClockSkew is how far a header's timestamp may be from the verifier's clock
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// ErrAuthHeaderReplayed is returned when a verifier has already accepted an auth
+// header with the same key and nonce
+var ErrAuthHeaderReplayed = errors.New("auth header nonce already used")
+
+// ErrAuthHeaderExpired is returned for auth headers past their expiry
+var ErrAuthHeaderExpired = errors.New("auth header expired")
+
 // AuthPayload represents the authentication payload structure
 type AuthPayload struct {
 	Method    string
 	Path      string
 	Timestamp int64
 	Nonce     string
+	Expires   int64 // Unix time the header stops being valid (0 = timestamp window only)
 }
 
 // GenerateNonce creates a random nonce for authentication
@@ -47,25 +65,64 @@ func NewAuthPayload(method, path string) (*AuthPayload, error) {
 }
 
 // String returns the string representation of the auth payload for signing
-// Format: METHOD:PATH:TIMESTAMP:NONCE
+// Format: METHOD:PATH:TIMESTAMP:NONCE, followed by :EXPIRES when set
 func (ap *AuthPayload) String() string {
-	return fmt.Sprintf("%s:%s:%d:%s", ap.Method, ap.Path, ap.Timestamp, ap.Nonce)
+	value := fmt.Sprintf("%s:%s:%d:%s", ap.Method, ap.Path, ap.Timestamp, ap.Nonce)
+	if ap.Expires != 0 {
+		value += fmt.Sprintf(":%d", ap.Expires)
+	}
+	return value
 }
 
 // AuthHeader represents an authorization header
 type AuthHeader struct {
-	PublicKey string
-	Signature string
-	Timestamp int64
-	Nonce     string
+	PublicKey  string
+	Signature  string
+	Timestamp  int64
+	Nonce      string
+	Expires    int64  // Unix time the header stops being valid; 0 for headers from older clients
+	KeyID      string // Identifier of the signing key; set when signing with a key ring
+	Delegation string // Encoded delegation token; set when a delegate key signs on an account's behalf
+}
+
+// HeaderOptions adjusts how auth headers are generated
+type HeaderOptions struct {
+	TTL         time.Duration // How long the header is valid (0 = DefaultAuthHeaderTTL)
+	ClockOffset time.Duration // Added to the local clock, e.g. the server's measured offset, to timestamp the header
+	OmitExpiry  bool          // Sign the baseline METHOD:PATH:TIMESTAMP:NONCE payload without an expiry, for servers that do not accept one; TTL is ignored
 }
 
-// GenerateAuthHeader creates a signed authorization header
+// GenerateAuthHeader creates a signed authorization header with a fresh nonce,
+// valid for DefaultAuthHeaderTTL
 func GenerateAuthHeader(keyPair *keymgmt.KeyPair, method, path string) (*AuthHeader, error) {
+	return GenerateAuthHeaderWithOptions(keyPair, method, path, nil)
+}
+
+// GenerateAuthHeaderWithOptions creates a signed authorization header with a
+// fresh nonce, timestamped and expiring as options set. Headers with an expiry
+// sign METHOD:PATH:TIMESTAMP:NONCE:EXPIRES and carry an expires field, which
+// servers verifying only the baseline payload reject; see OmitExpiry.
+func GenerateAuthHeaderWithOptions(keyPair *keymgmt.KeyPair, method, path string, options *HeaderOptions) (*AuthHeader, error) {
+	if options == nil {
+		options = &HeaderOptions{}
+	}
+	if options.TTL < 0 {
+		return nil, fmt.Errorf("auth header TTL must not be negative")
+	}
+	ttl := options.TTL
+	if ttl == 0 {
+		ttl = DefaultAuthHeaderTTL
+	}
+
 	payload, err := NewAuthPayload(method, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth payload: %w", err)
 	}
+	now := time.Now().Add(options.ClockOffset)
+	payload.Timestamp = now.Unix()
+	if !options.OmitExpiry {
+		payload.Expires = now.Add(ttl).Unix()
+	}
 
 	// Sign the payload
 	payloadBytes := []byte(payload.String())
@@ -76,13 +133,36 @@ func GenerateAuthHeader(keyPair *keymgmt.KeyPair, method, path string) (*AuthHea
 		Signature: base64.StdEncoding.EncodeToString(signature),
 		Timestamp: payload.Timestamp,
 		Nonce:     payload.Nonce,
+		Expires:   payload.Expires,
 	}, nil
 }
 
+// GenerateKeyRingAuthHeader creates an authorization header signed with the key
+// ring's current key, carrying the key's identifier
+func GenerateKeyRingAuthHeader(keyRing *keymgmt.KeyRing, method, path string) (*AuthHeader, error) {
+	current := keyRing.Current()
+	authHeader, err := GenerateAuthHeader(current, method, path)
+	if err != nil {
+		return nil, err
+	}
+	authHeader.KeyID = current.KeyID()
+	return authHeader, nil
+}
+
 // ToHeaderValue converts the auth header to a string suitable for HTTP Authorization header
 func (ah *AuthHeader) ToHeaderValue() string {
-	return fmt.Sprintf("EMSG pubkey=%s,signature=%s,timestamp=%d,nonce=%s",
+	value := fmt.Sprintf("EMSG pubkey=%s,signature=%s,timestamp=%d,nonce=%s",
 		ah.PublicKey, ah.Signature, ah.Timestamp, ah.Nonce)
+	if ah.Expires != 0 {
+		value += fmt.Sprintf(",expires=%d", ah.Expires)
+	}
+	if ah.KeyID != "" {
+		value += ",keyid=" + ah.KeyID
+	}
+	if ah.Delegation != "" {
+		value += ",delegation=" + ah.Delegation
+	}
+	return value
 }
 
 // ParseAuthHeader parses an authorization header value
@@ -120,6 +200,16 @@ func ParseAuthHeader(headerValue string) (*AuthHeader, error) {
 			authHeader.Timestamp = timestamp
 		case "nonce":
 			authHeader.Nonce = value
+		case "expires":
+			expires, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiry: %w", err)
+			}
+			authHeader.Expires = expires
+		case "keyid":
+			authHeader.KeyID = value
+		case "delegation":
+			authHeader.Delegation = value
 		}
 	}
 
@@ -132,13 +222,51 @@ func ParseAuthHeader(headerValue string) (*AuthHeader, error) {
 	return authHeader, nil
 }
 
-// VerifyAuthHeader verifies an authorization header against a method and path
+// VerifyOptions adjusts how auth headers are verified
+type VerifyOptions struct {
+	MaxClockSkew time.Duration    // How far the header's timestamp and expiry may be off (0 = DefaultMaxClockSkew)
+	MaxTTL       time.Duration    // Longest validity accepted from a header's expiry (0 = DefaultMaxAuthHeaderTTL)
+	Nonces       NonceCache       // Rejects nonces seen before (nil = no replay protection)
+	Now          func() time.Time // Clock to verify against (nil = time.Now)
+}
+
+// VerifyAuthHeader verifies an authorization header against a method and path,
+// without replay protection. See VerifyAuthHeaderWithOptions.
 func VerifyAuthHeader(authHeader *AuthHeader, method, path string) error {
+	return VerifyAuthHeaderWithOptions(authHeader, method, path, nil)
+}
+
+// VerifyAuthHeaderWithOptions verifies an authorization header against a method
+// and path, e.g. in server middleware. The timestamp must be within the clock
+// skew of now and the expiry, if any, not past; with a nonce cache, each key's
+// nonces are accepted once. A delegated header must carry a token issued to its
+// key; checking the token's issuer against the account is left to the caller
+// (see DelegationToken.Verify).
+func VerifyAuthHeaderWithOptions(authHeader *AuthHeader, method, path string, options *VerifyOptions) error {
+	if options == nil {
+		options = &VerifyOptions{}
+	}
+	maxSkew := options.MaxClockSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	maxTTL := options.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxAuthHeaderTTL
+	}
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+
 	// Load public key
 	publicKey, err := keymgmt.LoadPublicKeyFromBase64(authHeader.PublicKey)
 	if err != nil {
 		return fmt.Errorf("failed to load public key: %w", err)
 	}
+	if authHeader.KeyID != "" && authHeader.KeyID != keymgmt.KeyID(publicKey) {
+		return fmt.Errorf("key ID %s does not match public key", authHeader.KeyID)
+	}
 
 	// Reconstruct the payload
 	payload := &AuthPayload{
@@ -146,6 +274,7 @@ func VerifyAuthHeader(authHeader *AuthHeader, method, path string) error {
 		Path:      path,
 		Timestamp: authHeader.Timestamp,
 		Nonce:     authHeader.Nonce,
+		Expires:   authHeader.Expires,
 	}
 
 	// Decode signature
@@ -160,19 +289,61 @@ func VerifyAuthHeader(authHeader *AuthHeader, method, path string) error {
 		return fmt.Errorf("signature verification failed")
 	}
 
-	// Check timestamp (allow 5 minute window)
-	now := time.Now().Unix()
-	if abs(now-authHeader.Timestamp) > 300 {
-		return fmt.Errorf("timestamp too old or too far in future")
+	// Check timestamp and expiry, allowing for clock skew. Headers without an
+	// expiry are valid for the skew either side of their timestamp.
+	skew := int64(maxSkew / time.Second)
+	if authHeader.Timestamp-now.Unix() > skew {
+		return fmt.Errorf("timestamp too far in future")
+	}
+	validUntil := authHeader.Timestamp + skew
+	if authHeader.Expires != 0 {
+		if authHeader.Expires <= authHeader.Timestamp {
+			return fmt.Errorf("auth header expires before it was issued")
+		}
+		if authHeader.Expires-authHeader.Timestamp > int64(maxTTL/time.Second) {
+			return fmt.Errorf("auth header valid for longer than %v", maxTTL)
+		}
+		validUntil = authHeader.Expires + skew
+		if now.Unix() > validUntil {
+			return ErrAuthHeaderExpired
+		}
+	} else if now.Unix() > validUntil {
+		return fmt.Errorf("timestamp too old")
+	}
+
+	if authHeader.Delegation != "" {
+		token, err := ParseDelegationToken(authHeader.Delegation)
+		if err != nil {
+			return err
+		}
+		if token.Delegate != authHeader.PublicKey {
+			return fmt.Errorf("delegation token was issued to a different key")
+		}
+		if err := token.Verify(token.Issuer); err != nil {
+			return err
+		}
+		if token.IsExpired() {
+			return fmt.Errorf("delegation token expired")
+		}
+	}
+
+	// Remember the nonce until the header could no longer be accepted anyway
+	if options.Nonces != nil && !options.Nonces.Use(authHeader.PublicKey, authHeader.Nonce, time.Unix(validUntil, 0)) {
+		return ErrAuthHeaderReplayed
 	}
 
 	return nil
 }
 
-// abs returns the absolute value of x
-func abs(x int64) int64 {
-	if x < 0 {
-		return -x
+// VerifyAuthHeaderWithKeyRing verifies an authorization header and checks that it
+// was signed by a key in the ring, current or previous
+func VerifyAuthHeaderWithKeyRing(authHeader *AuthHeader, keyRing *keymgmt.KeyRing, method, path string) error {
+	publicKey, err := keymgmt.LoadPublicKeyFromBase64(authHeader.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to load public key: %w", err)
+	}
+	if _, known := keyRing.Lookup(keymgmt.KeyID(publicKey)); !known {
+		return fmt.Errorf("auth header signed by unknown key %s", keymgmt.KeyID(publicKey))
 	}
-	return x
+	return VerifyAuthHeader(authHeader, method, path)
 }
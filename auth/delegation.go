@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// DelegationScope restricts what the holder of a delegation token may do
+type DelegationScope struct {
+	Address   string        // The only address the delegate may send as (required)
+	Domains   []string      // Recipient domains the delegate may send to (empty = any)
+	ExpiresIn time.Duration // How long the token is valid (0 = until the account key is rotated)
+}
+
+// DelegationToken lets a secondary key send on behalf of an account within a
+// limited scope. It is signed by the account key and presented alongside
+// requests and messages signed by the delegate key.
+type DelegationToken struct {
+	Issuer    string   `json:"iss"`               // Account public key that signed the token
+	Delegate  string   `json:"sub"`               // Delegate public key allowed to sign
+	Address   string   `json:"addr"`              // Address the delegate may send as
+	Domains   []string `json:"domains,omitempty"` // Recipient domains the delegate may send to
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	Signature string   `json:"sig,omitempty"`
+}
+
+// IssueDelegationToken mints a token signed by accountKey that lets the holder of
+// delegatePublicKey (base64 Ed25519) send within scope
+func IssueDelegationToken(accountKey *keymgmt.KeyPair, delegatePublicKey string, scope *DelegationScope) (*DelegationToken, error) {
+	if accountKey == nil {
+		return nil, fmt.Errorf("account key is required")
+	}
+	if scope == nil {
+		return nil, fmt.Errorf("delegation scope is required")
+	}
+	if _, err := keymgmt.LoadPublicKeyFromBase64(delegatePublicKey); err != nil {
+		return nil, fmt.Errorf("invalid delegate public key: %w", err)
+	}
+	if _, err := utils.ParseEMSGAddress(scope.Address); err != nil {
+		return nil, fmt.Errorf("invalid delegated address: %w", err)
+	}
+	if scope.ExpiresIn < 0 {
+		return nil, fmt.Errorf("expiry must not be negative")
+	}
+
+	domains := make([]string, 0, len(scope.Domains))
+	for _, domain := range scope.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			return nil, fmt.Errorf("delegated domain cannot be empty")
+		}
+		domains = append(domains, domain)
+	}
+
+	now := time.Now()
+	token := &DelegationToken{
+		Issuer:   accountKey.PublicKeyBase64(),
+		Delegate: delegatePublicKey,
+		Address:  utils.NormalizeEMSGAddress(scope.Address),
+		Domains:  domains,
+		IssuedAt: now.Unix(),
+	}
+	if scope.ExpiresIn > 0 {
+		token.ExpiresAt = now.Add(scope.ExpiresIn).Unix()
+	}
+
+	payload, err := token.signingPayload()
+	if err != nil {
+		return nil, err
+	}
+	token.Signature = base64.StdEncoding.EncodeToString(accountKey.Sign(payload))
+	return token, nil
+}
+
+// signingPayload returns the token's JSON without its signature
+func (t *DelegationToken) signingPayload() ([]byte, error) {
+	unsigned := *t
+	unsigned.Signature = ""
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize delegation token: %w", err)
+	}
+	return payload, nil
+}
+
+// Verify checks that the token was signed by issuerPublicKey, the account's
+// signing key. Expiry is checked separately with IsExpired or ValidAt.
+func (t *DelegationToken) Verify(issuerPublicKey string) error {
+	if t.Issuer != issuerPublicKey {
+		return fmt.Errorf("delegation token not issued by the account key")
+	}
+	publicKey, err := keymgmt.LoadPublicKeyFromBase64(t.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to load issuer key: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(t.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode delegation signature: %w", err)
+	}
+	payload, err := t.signingPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return fmt.Errorf("delegation token signature verification failed")
+	}
+	return nil
+}
+
+// ValidAt returns true if the token was issued at or before t and had not yet expired
+func (t *DelegationToken) ValidAt(at time.Time) bool {
+	unix := at.Unix()
+	return unix >= t.IssuedAt && (t.ExpiresAt == 0 || unix <= t.ExpiresAt)
+}
+
+// IsExpired returns true if the token has an expiry and it has passed
+func (t *DelegationToken) IsExpired() bool {
+	return t.ExpiresAt != 0 && time.Now().Unix() > t.ExpiresAt
+}
+
+// Permits checks that the token's scope allows sending as from to every recipient
+func (t *DelegationToken) Permits(from string, recipients []string) error {
+	if utils.NormalizeEMSGAddress(from) != t.Address {
+		return fmt.Errorf("delegation token does not permit sending as %s", from)
+	}
+	if len(t.Domains) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(t.Domains))
+	for _, domain := range t.Domains {
+		allowed[domain] = true
+	}
+	for _, recipient := range recipients {
+		addr, err := utils.ParseEMSGAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %s: %w", recipient, err)
+		}
+		if !allowed[strings.ToLower(addr.Domain)] {
+			return fmt.Errorf("delegation token does not permit sending to %s", addr.Domain)
+		}
+	}
+	return nil
+}
+
+// Encode returns the token as a compact string suitable for headers and files
+func (t *DelegationToken) Encode() (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize delegation token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseDelegationToken decodes a token produced by Encode. The signature is not
+// checked; use Verify.
+func ParseDelegationToken(encoded string) (*DelegationToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delegation token: %w", err)
+	}
+	var token DelegationToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse delegation token: %w", err)
+	}
+	if token.Issuer == "" || token.Delegate == "" || token.Address == "" || token.Signature == "" {
+		return nil, fmt.Errorf("delegation token missing required fields")
+	}
+	return &token, nil
+}
+
+// GenerateDelegatedAuthHeader creates an authorization header signed by the
+// delegate key and carrying the delegation token
+func GenerateDelegatedAuthHeader(delegateKey *keymgmt.KeyPair, token *DelegationToken, method, path string) (*AuthHeader, error) {
+	return GenerateDelegatedAuthHeaderWithOptions(delegateKey, token, method, path, nil)
+}
+
+// GenerateDelegatedAuthHeaderWithOptions is GenerateDelegatedAuthHeader with
+// the timestamp and expiry set as options set
+func GenerateDelegatedAuthHeaderWithOptions(delegateKey *keymgmt.KeyPair, token *DelegationToken, method, path string, options *HeaderOptions) (*AuthHeader, error) {
+	if token.Delegate != delegateKey.PublicKeyBase64() {
+		return nil, fmt.Errorf("delegation token was issued to a different key")
+	}
+	encoded, err := token.Encode()
+	if err != nil {
+		return nil, err
+	}
+	authHeader, err := GenerateAuthHeaderWithOptions(delegateKey, method, path, options)
+	if err != nil {
+		return nil, err
+	}
+	authHeader.Delegation = encoded
+	return authHeader, nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// NonceCache remembers the nonces of accepted auth headers so they cannot be replayed
+type NonceCache interface {
+	// Use records a key's nonce until expiresAt. It returns false if the nonce
+	// was already recorded and has not expired.
+	Use(publicKey, nonce string, expiresAt time.Time) bool
+}
+
+// DefaultNonceCacheSize is the number of nonces a MemoryNonceCache holds when created with none
+const DefaultNonceCacheSize = 100000
+
+// DefaultNonceCacheKeyShare is the fraction of a MemoryNonceCache one public key
+// may fill when created without a per-key limit
+const DefaultNonceCacheKeyShare = 10
+
+// MemoryNonceCache is an in-memory NonceCache for a single verifier. Servers
+// behind a load balancer need a shared cache instead.
+type MemoryNonceCache struct {
+	nonces     map[string]*nonceEntry // Recorded nonces by key and nonce
+	expiries   nonceHeap              // The same entries, soonest expiry first
+	perKey     map[string]int         // Number of unexpired nonces recorded per public key
+	maxEntries int
+	maxPerKey  int
+	mutex      sync.Mutex
+}
+
+// nonceEntry is a recorded nonce with its position in the expiry heap
+type nonceEntry struct {
+	key       string
+	publicKey string
+	expiresAt time.Time
+	index     int
+}
+
+// NewMemoryNonceCache creates a nonce cache holding up to maxEntries nonces
+// (0 = DefaultNonceCacheSize), of which one public key may record a tenth.
+// See NewMemoryNonceCacheWithKeyLimit.
+func NewMemoryNonceCache(maxEntries int) *MemoryNonceCache {
+	return NewMemoryNonceCacheWithKeyLimit(maxEntries, 0)
+}
+
+// NewMemoryNonceCacheWithKeyLimit creates a nonce cache holding up to maxEntries
+// nonces (0 = DefaultNonceCacheSize) and up to maxPerKey unexpired nonces of
+// one public key (0 = maxEntries/DefaultNonceCacheKeyShare). A key over its
+// limit has its new nonces refused, so it cannot crowd out other keys. When the
+// whole cache is full, the nonce expiring soonest is forgotten to make room;
+// until it expires, its header could be replayed once more.
+func NewMemoryNonceCacheWithKeyLimit(maxEntries, maxPerKey int) *MemoryNonceCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultNonceCacheSize
+	}
+	if maxPerKey <= 0 {
+		maxPerKey = max(maxEntries/DefaultNonceCacheKeyShare, 1)
+	}
+	return &MemoryNonceCache{
+		nonces:     make(map[string]*nonceEntry),
+		perKey:     make(map[string]int),
+		maxEntries: maxEntries,
+		maxPerKey:  maxPerKey,
+	}
+}
+
+// Use records a key's nonce until expiresAt, returning false if it was seen
+// before or the key has reached its limit of unexpired nonces
+func (nc *MemoryNonceCache) Use(publicKey, nonce string, expiresAt time.Time) bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	now := time.Now()
+	nc.pruneLocked(now)
+	key := publicKey + ":" + nonce
+	if _, seen := nc.nonces[key]; seen {
+		return false
+	}
+	if nc.perKey[publicKey] >= nc.maxPerKey {
+		return false
+	}
+
+	if len(nc.nonces) >= nc.maxEntries {
+		nc.removeLocked(nc.expiries[0])
+	}
+	entry := &nonceEntry{key: key, publicKey: publicKey, expiresAt: expiresAt}
+	heap.Push(&nc.expiries, entry)
+	nc.nonces[key] = entry
+	nc.perKey[publicKey]++
+	return true
+}
+
+// Len returns the number of nonces recorded, including expired ones not yet pruned
+func (nc *MemoryNonceCache) Len() int {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	return len(nc.nonces)
+}
+
+// pruneLocked forgets expired nonces
+func (nc *MemoryNonceCache) pruneLocked(now time.Time) {
+	for len(nc.expiries) > 0 && !now.Before(nc.expiries[0].expiresAt) {
+		nc.removeLocked(nc.expiries[0])
+	}
+}
+
+// removeLocked forgets a recorded nonce
+func (nc *MemoryNonceCache) removeLocked(entry *nonceEntry) {
+	heap.Remove(&nc.expiries, entry.index)
+	delete(nc.nonces, entry.key)
+	if nc.perKey[entry.publicKey]--; nc.perKey[entry.publicKey] <= 0 {
+		delete(nc.perKey, entry.publicKey)
+	}
+}
+
+// nonceHeap orders recorded nonces by expiry for heap
+type nonceHeap []*nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h nonceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nonceHeap) Push(x any) {
+	entry := x.(*nonceEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *nonceHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
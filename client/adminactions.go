@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+)
+
+// adminActionsRequest is the body of a request handing a signed batch to a group's server
+type adminActionsRequest struct {
+	Actions string `json:"actions"` // Encoded groups.SignedAdminActions
+}
+
+// SignGroupAdminActions signs a batch of actions on a group with the client's
+// key, for another client or the group's server to carry out later as
+// adminAddress, e.g. member removals scheduled with AdminAction.NotBefore. The
+// batch can be carried out until ttl has passed.
+func (c *Client) SignGroupAdminActions(adminAddress, groupID string, actions []*groups.AdminAction, ttl time.Duration) (*groups.SignedAdminActions, error) {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	return groups.SignAdminActions(keyPair, adminAddress, groupID, actions, ttl)
+}
+
+// ExecuteGroupAdminActions carries out the due actions of a batch signed by a
+// group admin on the local copy of the group, recording them in its audit log
+// under executor. The batch's key must be the admin's resolved signing key, and
+// each action is checked against the admin's role when it runs. Actions that
+// applied are sent to the group's server when group sync is enabled; call again
+// later for actions not due yet.
+func (c *Client) ExecuteGroupAdminActions(ctx context.Context, batch *groups.SignedAdminActions, executor string) (*groups.AdminExecution, error) {
+	group, err := c.getManagedGroup(batch.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := c.signingKeys.lookup(ctx, batch.Admin)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve admin key: %w", err)
+	}
+
+	result, err := group.ExecuteAdminActions(batch, key, executor, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, entry := range result.Executed {
+		if entry.Error != "" {
+			c.logger.Warn("pre-signed admin action refused", "group_id", batch.GroupID, "batch_id", batch.ID, "operation", entry.Operation.Type, "error", entry.Error)
+			continue
+		}
+		if err := c.pushGroupOperation(entry.Operation); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// SubmitAdminActions hands a signed batch to the group's server to carry out.
+// See SubmitAdminActionsContext.
+func (gs *GroupSyncClient) SubmitAdminActions(batch *groups.SignedAdminActions) (*groups.Group, error) {
+	return gs.SubmitAdminActionsContext(context.Background(), batch)
+}
+
+// SubmitAdminActionsContext hands a signed batch to the group's server, which
+// carries out each action when it is due with the admin's permissions at that
+// time and records it in the group's audit log. The group state the server
+// returns is applied to the local copy.
+func (gs *GroupSyncClient) SubmitAdminActionsContext(ctx context.Context, batch *groups.SignedAdminActions) (*groups.Group, error) {
+	encoded, err := batch.Encode()
+	if err != nil {
+		return nil, err
+	}
+	domain, endpoint, err := gs.groupEndpoint(ctx, batch.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(&adminActionsRequest{Actions: encoded})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize admin actions request: %w", err)
+	}
+	resp, err := gs.client.sendHTTPRequestWithResponse(ctx, domain, "POST", endpoint+"/admin-actions", payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit admin actions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return gs.importResponse(batch.GroupID, resp)
+}
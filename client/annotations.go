@@ -0,0 +1,194 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// annotationStore returns the message store's annotation layer
+func (c *Client) annotationStore() (store.AnnotationStore, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+	annotations, ok := c.messageStore.(store.AnnotationStore)
+	if !ok {
+		return nil, fmt.Errorf("message store does not support annotations")
+	}
+	return annotations, nil
+}
+
+// GetAnnotation returns a stored message's annotation, empty if it has none
+func (c *Client) GetAnnotation(messageID string) (*store.Annotation, error) {
+	annotations, err := c.annotationStore()
+	if err != nil {
+		return nil, err
+	}
+	annotation, err := annotations.GetAnnotation(messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return &store.Annotation{}, nil
+	}
+	return annotation, err
+}
+
+// AnnotateMessage changes a stored message's annotation with update and saves
+// it, removing it once it records nothing. Annotations stay on this device; the
+// message itself is not modified.
+func (c *Client) AnnotateMessage(messageID string, update func(*store.Annotation)) (*store.Annotation, error) {
+	annotations, err := c.annotationStore()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.messageStore.Get(messageID); err != nil {
+		return nil, fmt.Errorf("failed to annotate message %s: %w", messageID, err)
+	}
+
+	c.annotationMutex.Lock()
+	defer c.annotationMutex.Unlock()
+
+	annotation, err := annotations.GetAnnotation(messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		annotation = &store.Annotation{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	update(annotation)
+	annotation.Tags = normalizeTags(annotation.Tags)
+	for key, value := range annotation.Values {
+		if value == "" {
+			delete(annotation.Values, key)
+		}
+	}
+	annotation.UpdatedAt = time.Now()
+
+	if annotation.IsEmpty() {
+		if err := annotations.DeleteAnnotation(messageID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		return annotation, nil
+	}
+	if err := annotations.PutAnnotation(messageID, annotation); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// StarMessage stars or unstars a stored message
+func (c *Client) StarMessage(messageID string, starred bool) error {
+	_, err := c.AnnotateMessage(messageID, func(annotation *store.Annotation) {
+		annotation.Starred = starred
+	})
+	return err
+}
+
+// ArchiveMessage archives or unarchives a stored message locally. Unlike
+// LabelArchive, the server is not told.
+func (c *Client) ArchiveMessage(messageID string, archived bool) error {
+	_, err := c.AnnotateMessage(messageID, func(annotation *store.Annotation) {
+		annotation.Archived = archived
+	})
+	return err
+}
+
+// TagMessage adds a local tag to a stored message
+func (c *Client) TagMessage(messageID, tag string) error {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return fmt.Errorf("tag cannot be empty")
+	}
+	_, err := c.AnnotateMessage(messageID, func(annotation *store.Annotation) {
+		annotation.Tags = append(annotation.Tags, tag)
+	})
+	return err
+}
+
+// UntagMessage removes a local tag from a stored message
+func (c *Client) UntagMessage(messageID, tag string) error {
+	tag = strings.TrimSpace(tag)
+	_, err := c.AnnotateMessage(messageID, func(annotation *store.Annotation) {
+		annotation.Tags = slices.DeleteFunc(annotation.Tags, func(t string) bool { return t == tag })
+	})
+	return err
+}
+
+// SetAnnotationValue sets an app-defined value on a stored message's
+// annotation; an empty value removes it
+func (c *Client) SetAnnotationValue(messageID, key, value string) error {
+	if key == "" {
+		return fmt.Errorf("annotation key cannot be empty")
+	}
+	_, err := c.AnnotateMessage(messageID, func(annotation *store.Annotation) {
+		if annotation.Values == nil {
+			annotation.Values = make(map[string]string)
+		}
+		annotation.Values[key] = value
+	})
+	return err
+}
+
+// FindAnnotatedMessages returns the stored messages whose annotation matches, in conversation order
+func (c *Client) FindAnnotatedMessages(match func(*store.Annotation) bool) ([]*message.Message, error) {
+	annotations, err := c.annotationStore()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := store.FindAnnotated(annotations, match)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*message.Message, 0, len(ids))
+	for _, id := range ids {
+		msg, err := c.messageStore.Get(id)
+		if errors.Is(err, store.ErrNotFound) {
+			// Quarantined, or deleted by a store that keeps annotations
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored message %s: %w", id, err)
+		}
+		messages = append(messages, msg)
+	}
+	message.SortMessages(messages)
+	return messages, nil
+}
+
+// GetStarredMessages returns the starred messages, in conversation order
+func (c *Client) GetStarredMessages() ([]*message.Message, error) {
+	return c.FindAnnotatedMessages(func(annotation *store.Annotation) bool {
+		return annotation.Starred
+	})
+}
+
+// GetArchivedMessages returns the locally archived messages, in conversation order
+func (c *Client) GetArchivedMessages() ([]*message.Message, error) {
+	return c.FindAnnotatedMessages(func(annotation *store.Annotation) bool {
+		return annotation.Archived
+	})
+}
+
+// GetTaggedMessages returns the messages carrying a local tag, in conversation order
+func (c *Client) GetTaggedMessages(tag string) ([]*message.Message, error) {
+	tag = strings.TrimSpace(tag)
+	return c.FindAnnotatedMessages(func(annotation *store.Annotation) bool {
+		return annotation.HasTag(tag)
+	})
+}
+
+// normalizeTags trims, sorts and deduplicates tags, dropping empty ones
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
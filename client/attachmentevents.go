@@ -0,0 +1,68 @@
+package client
+
+import (
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+)
+
+// IsAttachmentQuarantined returns true if the attachment scanner rejected the attachment
+func (c *Client) IsAttachmentQuarantined(attachmentID string) (bool, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return false, err
+	}
+	return attachmentManager.IsQuarantined(attachmentID), nil
+}
+
+// ReleaseAttachmentQuarantine lets a quarantined attachment be read again, e.g.
+// once the user has chosen to trust it
+func (c *Client) ReleaseAttachmentQuarantine(attachmentID string) error {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return err
+	}
+	attachmentManager.ReleaseQuarantine(attachmentID)
+	return nil
+}
+
+// attachmentEventHooks forwards attachment manager activity to the notification manager
+func (c *Client) attachmentEventHooks() *attachments.EventHooks {
+	return &attachments.EventHooks{
+		DownloadProgress: func(attachment *attachments.Attachment, received, total int64) {
+			if err := c.notificationManager.NotifyAttachmentDownloadProgress(attachment, received, total); err != nil {
+				c.logger.Warn("failed to notify attachment download progress", "attachment", attachment.ID, "error", err)
+			}
+		},
+		ValidationFailed: func(attachment *attachments.Attachment, validationErr error) {
+			if err := c.notificationManager.NotifyAttachmentValidationFailed(attachment, validationErr); err != nil {
+				c.logger.Warn("failed to notify attachment validation failure", "attachment", attachment.ID, "error", err)
+			}
+		},
+		Quarantined: func(attachment *attachments.Attachment, reason error) {
+			c.logger.Warn("attachment quarantined", "attachment", attachment.ID, "reason", reason)
+			if err := c.notificationManager.NotifyAttachmentQuarantined(attachment, reason); err != nil {
+				c.logger.Warn("failed to notify attachment quarantine", "attachment", attachment.ID, "error", err)
+			}
+		},
+	}
+}
+
+// notifyAttachmentUpload raises an attachment upload event if notifications are enabled
+func (c *Client) notifyAttachmentUpload(event notifications.NotificationEvent, attachment *attachments.Attachment, domain string, uploadErr error) {
+	if c.notificationManager == nil {
+		return
+	}
+
+	var err error
+	switch event {
+	case notifications.EventAttachmentUploadStarted:
+		err = c.notificationManager.NotifyAttachmentUploadStarted(attachment, domain)
+	case notifications.EventAttachmentUploadCompleted:
+		err = c.notificationManager.NotifyAttachmentUploadCompleted(attachment, domain)
+	case notifications.EventAttachmentUploadFailed:
+		err = c.notificationManager.NotifyAttachmentUploadFailed(attachment, domain, uploadErr)
+	}
+	if err != nil {
+		c.logger.Warn("failed to notify attachment upload", "event", event, "attachment", attachment.ID, "error", err)
+	}
+}
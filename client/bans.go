@@ -0,0 +1,160 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// BanGroupMember bans an address from a group
+func (c *Client) BanGroupMember(groupID, memberAddress, requesterAddress, reason string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.BanMember(memberAddress, requesterAddress, reason)
+}
+
+// UnbanGroupMember lifts a ban from a group
+func (c *Client) UnbanGroupMember(groupID, memberAddress, requesterAddress string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.UnbanMember(memberAddress, requesterAddress)
+}
+
+// MuteGroupMember stops a member from sending to a group for duration
+func (c *Client) MuteGroupMember(groupID, memberAddress, requesterAddress string, duration time.Duration) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.MuteMember(memberAddress, requesterAddress, duration)
+}
+
+// UnmuteGroupMember ends a member's mute early
+func (c *Client) UnmuteGroupMember(groupID, memberAddress, requesterAddress string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.UnmuteMember(memberAddress, requesterAddress)
+}
+
+// GetGroupBans returns a group's bans, oldest first
+func (c *Client) GetGroupBans(groupID string) ([]*groups.GroupBan, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return group.GetBans(), nil
+}
+
+// BanGroupMemberWithMessage bans an address and announces it to the group,
+// pushing the change first with Config.SyncGroups
+func (c *Client) BanGroupMemberWithMessage(groupID, memberAddress, requesterAddress, reason string) error {
+	if err := c.BanGroupMember(groupID, memberAddress, requesterAddress, reason); err != nil {
+		return err
+	}
+
+	op := groups.NewGroupOperation(groups.OpBanMember, groupID, requesterAddress)
+	op.Member, op.Reason = memberAddress, reason
+	data := map[string]any{
+		"member": memberAddress,
+		"reason": reason,
+		"action": "member_banned",
+	}
+	return c.finishModerationAction(op, data)
+}
+
+// UnbanGroupMemberWithMessage lifts a ban and announces it to the group,
+// pushing the change first with Config.SyncGroups
+func (c *Client) UnbanGroupMemberWithMessage(groupID, memberAddress, requesterAddress string) error {
+	if err := c.UnbanGroupMember(groupID, memberAddress, requesterAddress); err != nil {
+		return err
+	}
+
+	op := groups.NewGroupOperation(groups.OpUnbanMember, groupID, requesterAddress)
+	op.Member = memberAddress
+	data := map[string]any{
+		"member": memberAddress,
+		"action": "member_unbanned",
+	}
+	return c.finishModerationAction(op, data)
+}
+
+// MuteGroupMemberWithMessage mutes a member and announces it to the group,
+// pushing the change first with Config.SyncGroups
+func (c *Client) MuteGroupMemberWithMessage(groupID, memberAddress, requesterAddress string, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("mute duration must be positive")
+	}
+	until := time.Now().Add(duration)
+
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	if err := group.MuteMemberUntil(memberAddress, requesterAddress, until); err != nil {
+		return err
+	}
+
+	op := groups.NewGroupOperation(groups.OpMuteMember, groupID, requesterAddress)
+	op.Member, op.Until = memberAddress, until.Unix()
+	data := map[string]any{
+		"member":      memberAddress,
+		"muted_until": until.Unix(),
+		"action":      "member_muted",
+	}
+	return c.finishModerationAction(op, data)
+}
+
+// UnmuteGroupMemberWithMessage ends a member's mute and announces it to the
+// group, pushing the change first with Config.SyncGroups
+func (c *Client) UnmuteGroupMemberWithMessage(groupID, memberAddress, requesterAddress string) error {
+	if err := c.UnmuteGroupMember(groupID, memberAddress, requesterAddress); err != nil {
+		return err
+	}
+
+	op := groups.NewGroupOperation(groups.OpUnmuteMember, groupID, requesterAddress)
+	op.Member = memberAddress
+	data := map[string]any{
+		"member": memberAddress,
+		"action": "member_unmuted",
+	}
+	return c.finishModerationAction(op, data)
+}
+
+// finishModerationAction pushes a ban or mute change and sends its system message
+func (c *Client) finishModerationAction(op *groups.GroupOperation, data map[string]any) error {
+	if err := c.pushGroupOperation(op); err != nil {
+		return err
+	}
+
+	action := data["action"].(string)
+	if err := c.SendGroupManagementMessage(op.GroupID, action, op.Actor, data); err != nil {
+		c.logger.Warn("failed to send group moderation message", "group_id", op.GroupID, "action", action, "error", err)
+	}
+	return nil
+}
+
+// checkGroupRestrictions stops banned and muted members sending to a locally
+// known group, and attachments its policy does not allow. System messages are
+// never blocked.
+func (c *Client) checkGroupRestrictions(msg *message.Message) error {
+	if c.groupManager == nil || msg.GroupID == "" || msg.Type != "" {
+		return nil
+	}
+
+	group, err := c.groupManager.GetGroup(msg.GroupID)
+	if err != nil {
+		return nil
+	}
+	if err := group.CheckCanSend(msg.From); err != nil {
+		return err
+	}
+	return c.checkGroupAttachments(group, msg)
+}
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// Server feature names advertised in ServerCapabilities.Features
+const (
+	FeatureChunkedAttachments = "attachments.chunked"
+	FeatureAttachmentURLs     = "attachments.url"
+	FeatureEncryption         = "encryption"
+	FeatureWebSocket          = "websocket"
+	FeatureAttachments        = "attachments"
+	FeatureGroups             = "groups"
+)
+
+// ServerCapabilities describes the limits and features of a recipient domain's server.
+// Zero limits mean the server did not advertise one.
+type ServerCapabilities struct {
+	Version           string   `json:"version,omitempty"`
+	MaxMessageSize    int64    `json:"max_message_size,omitempty"`
+	MaxAttachmentSize int64    `json:"max_attachment_size,omitempty"`
+	MaxInlineSize     int64    `json:"max_inline_size,omitempty"`
+	MaxChunkSize      int64    `json:"max_chunk_size,omitempty"`
+	MinChunkSize      int64    `json:"min_chunk_size,omitempty"`
+	Features          []string `json:"features,omitempty"`
+}
+
+// HasFeature returns true if the server advertises the named feature
+func (sc *ServerCapabilities) HasFeature(feature string) bool {
+	for _, f := range sc.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedPayloadError is returned when a message's attachments cannot be
+// delivered to a recipient domain in any supported form
+type UnsupportedPayloadError struct {
+	Domain       string
+	AttachmentID string // Empty when the whole message exceeds the limit
+	Size         int64
+	Limit        int64
+	Guidance     string
+}
+
+// Error implements the error interface
+func (e *UnsupportedPayloadError) Error() string {
+	subject := "message"
+	if e.AttachmentID != "" {
+		subject = fmt.Sprintf("attachment %s", e.AttachmentID)
+	}
+	return fmt.Sprintf("%s (%d bytes) exceeds limit of %d bytes for %s: %s", subject, e.Size, e.Limit, e.Domain, e.Guidance)
+}
+
+// HTTPStatusCode reports the error as 413 so delivery tracking classifies it as payload too large
+func (e *UnsupportedPayloadError) HTTPStatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// capabilityCache holds probed server capabilities per domain
+type capabilityCache struct {
+	entries map[string]*capabilityEntry
+	ttl     time.Duration
+	mutex   sync.RWMutex
+}
+
+type capabilityEntry struct {
+	capabilities *ServerCapabilities
+	expiresAt    time.Time
+}
+
+// GetServerCapabilities returns the capabilities of a domain's server, probing it if not cached.
+// Servers without a capabilities endpoint are reported with no limits and no optional features.
+func (c *Client) GetServerCapabilities(domain string) (*ServerCapabilities, error) {
+	return c.serverCapabilities(context.Background(), domain)
+}
+
+// serverCapabilities returns cached capabilities or probes the server within ctx
+func (c *Client) serverCapabilities(ctx context.Context, domain string) (*ServerCapabilities, error) {
+	c.capabilities.mutex.RLock()
+	entry, ok := c.capabilities.entries[domain]
+	c.capabilities.mutex.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.capabilities, nil
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return nil, &ResolveError{Domain: domain, Err: err}
+	}
+
+	capabilities, err := c.probeCapabilities(ctx, domain, serverInfo.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.capabilities.mutex.Lock()
+	c.capabilities.entries[domain] = &capabilityEntry{
+		capabilities: capabilities,
+		expiresAt:    time.Now().Add(c.capabilities.ttl),
+	}
+	c.capabilities.mutex.Unlock()
+
+	return capabilities, nil
+}
+
+// probeCapabilities fetches the capabilities document from a server
+func (c *Client) probeCapabilities(ctx context.Context, domain, serverURL string) (*ServerCapabilities, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/capabilities", serverURL)
+	resp, err := c.sendHTTPRequestWithResponse(ctx, domain, "GET", endpoint, nil)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == 404 {
+			return &ServerCapabilities{}, nil
+		}
+		return nil, fmt.Errorf("failed to probe capabilities: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capabilities: %w", err)
+	}
+
+	var capabilities ServerCapabilities
+	if err := json.Unmarshal(body, &capabilities); err != nil {
+		return nil, fmt.Errorf("failed to parse capabilities: %w", err)
+	}
+
+	return &capabilities, nil
+}
+
+// prepareAttachmentDelivery probes recipient domains and lays out attachments to fit them
+func (c *Client) prepareAttachmentDelivery(ctx context.Context, msg *message.Message) error {
+	if !c.capabilityProbing || !msg.HasAttachments() || msg.GetTotalAttachmentSize() < c.capabilityProbeThreshold {
+		return nil
+	}
+
+	capabilities := make(map[string]*ServerCapabilities)
+	for domain := range c.getDomainsFromMessage(msg) {
+		caps, err := c.serverCapabilities(ctx, domain)
+		if err != nil {
+			// An unreachable server will fail the send itself; don't mask that error here
+			c.logger.Warn("failed to probe server capabilities", "domain", domain, "error", err)
+			return nil
+		}
+		capabilities[domain] = caps
+	}
+
+	if c.autoUploadAttachments {
+		if err := c.uploadOversizedAttachments(ctx, msg, capabilities); err != nil {
+			return err
+		}
+	}
+
+	plan, err := PlanAttachmentDelivery(msg, capabilities)
+	if err != nil {
+		return err
+	}
+	return ApplyAttachmentPlan(msg, plan, capabilities)
+}
+
+// deliveryLimit is the most restrictive value of a limit and the domain imposing it
+type deliveryLimit struct {
+	value  int64
+	domain string
+}
+
+// deliveryConstraints combines the capabilities of every recipient domain
+type deliveryConstraints struct {
+	maxMessage    deliveryLimit
+	maxAttachment deliveryLimit
+	maxInline     deliveryLimit
+	maxChunk      deliveryLimit
+	minChunk      deliveryLimit
+	chunked       bool
+	urls          bool
+}
+
+func combineCapabilities(capabilities map[string]*ServerCapabilities) *deliveryConstraints {
+	constraints := &deliveryConstraints{chunked: true, urls: true}
+
+	// Iterate in a fixed order so the reported domain is deterministic
+	domains := make([]string, 0, len(capabilities))
+	for domain := range capabilities {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	tighten := func(limit *deliveryLimit, value int64, domain string) {
+		if value > 0 && (limit.value == 0 || value < limit.value) {
+			*limit = deliveryLimit{value: value, domain: domain}
+		}
+	}
+
+	for _, domain := range domains {
+		caps := capabilities[domain]
+		tighten(&constraints.maxMessage, caps.MaxMessageSize, domain)
+		tighten(&constraints.maxAttachment, caps.MaxAttachmentSize, domain)
+		tighten(&constraints.maxInline, caps.MaxInlineSize, domain)
+		tighten(&constraints.maxChunk, caps.MaxChunkSize, domain)
+		if caps.MinChunkSize > constraints.minChunk.value {
+			constraints.minChunk = deliveryLimit{value: caps.MinChunkSize, domain: domain}
+		}
+		constraints.chunked = constraints.chunked && caps.HasFeature(FeatureChunkedAttachments)
+		constraints.urls = constraints.urls && caps.HasFeature(FeatureAttachmentURLs)
+	}
+
+	return constraints
+}
+
+// PlanAttachmentDelivery chooses inline, chunked or URL delivery for each attachment so the
+// message fits every recipient domain's capabilities. The plan is keyed by attachment ID.
+func PlanAttachmentDelivery(msg *message.Message, capabilities map[string]*ServerCapabilities) (map[string]attachments.DeliveryMode, error) {
+	constraints := combineCapabilities(capabilities)
+	plan := make(map[string]attachments.DeliveryMode, len(msg.Attachments))
+
+	for _, att := range msg.Attachments {
+		hasURL := att.URL != "" && constraints.urls
+
+		if limit := constraints.maxAttachment; limit.value > 0 && att.Size > limit.value {
+			if !hasURL {
+				return nil, &UnsupportedPayloadError{
+					Domain:       limit.domain,
+					AttachmentID: att.ID,
+					Size:         att.Size,
+					Limit:        limit.value,
+					Guidance:     urlGuidance(constraints),
+				}
+			}
+			plan[att.ID] = attachments.DeliveryURL
+			continue
+		}
+
+		current := att.Mode()
+		if current == attachments.DeliveryURL {
+			if att.URL == "" {
+				return nil, fmt.Errorf("attachment %s has no data or URL", att.ID)
+			}
+			plan[att.ID] = current
+			continue
+		}
+
+		fitsInline := constraints.maxInline.value == 0 || att.Size <= constraints.maxInline.value
+		switch {
+		case fitsInline && (current == attachments.DeliveryInline || !constraints.chunked):
+			plan[att.ID] = attachments.DeliveryInline
+		case constraints.chunked:
+			plan[att.ID] = attachments.DeliveryChunked
+		case hasURL:
+			plan[att.ID] = attachments.DeliveryURL
+		default:
+			return nil, &UnsupportedPayloadError{
+				Domain:       constraints.maxInline.domain,
+				AttachmentID: att.ID,
+				Size:         att.Size,
+				Limit:        constraints.maxInline.value,
+				Guidance:     "recipient server does not accept chunked attachments; " + urlGuidance(constraints),
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyAttachmentPlan converts each attachment to its planned delivery mode and checks
+// the resulting message against the recipients' maximum message size
+func ApplyAttachmentPlan(msg *message.Message, plan map[string]attachments.DeliveryMode, capabilities map[string]*ServerCapabilities) error {
+	constraints := combineCapabilities(capabilities)
+
+	chunkSize := constraints.maxChunk.value
+	if chunkSize <= 0 {
+		chunkSize = attachments.DefaultAttachmentConfig().MaxChunkSize
+	}
+	if constraints.minChunk.value > chunkSize {
+		chunkSize = constraints.minChunk.value
+	}
+
+	for _, att := range msg.Attachments {
+		var err error
+		switch plan[att.ID] {
+		case attachments.DeliveryInline:
+			err = att.ToInline()
+		case attachments.DeliveryChunked:
+			if att.Mode() != attachments.DeliveryChunked || chunkSizeExceeded(att, chunkSize) {
+				err = att.ToChunked(chunkSize)
+			}
+		case attachments.DeliveryURL:
+			err = att.ToURLReference()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to prepare attachment %s: %w", att.ID, err)
+		}
+	}
+
+	limit := constraints.maxMessage
+	if limit.value <= 0 {
+		return nil
+	}
+
+	payload, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+	if size := int64(len(payload)); size > limit.value {
+		return &UnsupportedPayloadError{
+			Domain:   limit.domain,
+			Size:     size,
+			Limit:    limit.value,
+			Guidance: "split the attachments across several messages or " + urlGuidance(constraints),
+		}
+	}
+
+	return nil
+}
+
+func chunkSizeExceeded(att *attachments.Attachment, chunkSize int64) bool {
+	for _, chunk := range att.Chunks {
+		if int64(chunk.Size) > chunkSize {
+			return true
+		}
+	}
+	return false
+}
+
+func urlGuidance(constraints *deliveryConstraints) string {
+	if constraints.urls {
+		return "host the file and send it as a URL attachment"
+	}
+	return "recipient server does not accept URL attachments; reduce the file size or share it out of band"
+}
+
+// OpenAttachmentReaderForDomain returns a seekable reader over a URL-referenced
+// attachment whose chunk sizes adapt within the bounds advertised by domain's server
+func (c *Client) OpenAttachmentReaderForDomain(attachment *attachments.Attachment, domain string) (*attachments.RemoteReader, error) {
+	reader, err := c.OpenAttachmentReader(attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	sizer := reader.ChunkSizer()
+	if sizer == nil {
+		return reader, nil
+	}
+
+	caps, err := c.GetServerCapabilities(domain)
+	if err != nil {
+		c.logger.Warn("failed to probe server capabilities", "domain", domain, "error", err)
+		return reader, nil
+	}
+
+	minSize, maxSize := sizer.Bounds()
+	if caps.MinChunkSize > 0 {
+		minSize = caps.MinChunkSize
+	}
+	if caps.MaxChunkSize > 0 {
+		maxSize = caps.MaxChunkSize
+	}
+	sizer.SetBounds(minSize, maxSize)
+
+	return reader, nil
+}
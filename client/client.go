@@ -2,13 +2,16 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/attachments"
@@ -19,7 +22,10 @@ import (
 	"github.com/emsg-protocol/emsg-client-sdk/groups"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/metrics"
 	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/tracing"
 	"github.com/emsg-protocol/emsg-client-sdk/utils"
 	"github.com/emsg-protocol/emsg-client-sdk/websocket"
 )
@@ -49,31 +55,113 @@ func DefaultRetryStrategy() *RetryStrategy {
 // Client represents the EMSG client SDK
 type Client struct {
 	keyPair             *keymgmt.KeyPair
-	resolver            *dns.CachedResolver
-	httpClient          *http.Client
+	keyRing             *keymgmt.KeyRing      // Tracks previous key pairs when signing keys are rotated (nil = single key)
+	delegation          *auth.DelegationToken // Set when keyPair is a delegate key sending on an account's behalf
+	keyMutex            sync.RWMutex
+	rotationMutex       sync.RWMutex // Held for reading by in-flight sends, for writing during key rotation
+	rotationHooks       []KeyRotationHook
+	resolver            ContextResolver
+	httpClient          HTTPDoer
+	middleware          []Middleware // Wraps each HTTP round trip, outermost first
+	middlewareMutex     sync.RWMutex
 	userAgent           string
 	retryStrategy       *RetryStrategy
-	beforeSend          func(*message.Message) error
-	afterSend           func(*message.Message, *http.Response) error
+	beforeSend          func(context.Context, *message.Message) error
+	afterSend           func(context.Context, *message.Message, *http.Response) error
+	onRequest           func(context.Context, *RequestInfo) error
+	onResponse          func(context.Context, *ResponseInfo)
 	encryptionManager   *encryption.EncryptionManager
+	keyDiscovery        *encryption.KeyDiscovery
+	keyStoreWriteBehind *encryption.WriteBehindConfig
+	keyWriter           *encryption.WriteBehindKeyStore // Wraps the key store when writes are persisted in the background
 	notificationManager *notifications.NotificationManager
 	messagePoller       *notifications.MessagePoller
+	pollInterval        time.Duration // How often pollers, including Stream's fallback, fetch messages
 	webSocketClient     *websocket.WebSocketClient
+	webSocketAddress    string
+	webSocketConfig     *websocket.ReconnectStrategy
+	webSocketFrames     *websocket.FrameOptions
+	transportSelector   *TransportSelector
 	deliveryTracker     *delivery.DeliveryTracker
+	retryWorker         *retryWorker         // Resends failed deliveries (nil = retry worker not enabled)
+	wakeDetector        *wakeDetector        // Resyncs after the system slept (nil = wake detection not enabled)
+	connectivity        *connectivityMonitor // Online state and checks for coming back online (nil = offline mode not enabled)
+	loopback            *LoopbackNetwork     // In-process delivery (nil = loopback not enabled)
+	serverClock         serverClock
+	compensateClock     bool              // Auth headers are timestamped by the server's clock
+	entropy             io.Reader         // Source of generated keys and nonces (nil = crypto/rand)
+	idGenerator         utils.IDGenerator // Generates composed message IDs (nil = derived from the content)
+	clock               utils.Clock       // Timestamps composed messages (nil = system time)
+	metrics             metrics.Recorder  // Receives counters and histograms (nil = not recorded)
+	tracer              tracing.Tracer    // Starts spans around sends, resolution, uploads and WebSocket connects (nil = not traced)
+	authHeaderTTL       time.Duration     // Validity of signed auth headers (0 = auth.DefaultAuthHeaderTTL)
+	omitAuthExpiry      bool              // Auth headers are signed in the baseline format without an expiry
 	attachmentManager   *attachments.AttachmentManager
+	attachmentConfig    *attachments.AttachmentConfig
+	attachmentInit      sync.Once
+	attachmentInitErr   error
 	groupManager        *groups.GroupManager
+	groupSync           *GroupSyncClient // Pushes group changes to group servers (nil = groups stay local)
+	membershipProofs    *membershipProofs
+	emojiAssets         *emojiAssets
+	inbox               *inboxDedup // Drops copies of a message received for several identities
+	pushFormatter       *notifications.PushFormatter
+	domainOverrides     map[string]*domainSettings
+	messageStore        store.MessageStore
+	annotationMutex     sync.Mutex // Serializes read-modify-write of message annotations
+	capabilities        *capabilityCache
+	undecryptable       *undecryptableInbox
+	deliveryProofs      *proofRecorder
+	outbox              *outboxSender
+	partialDelivery     bool      // Unresolvable recipient domains are retried instead of failing the send
+	drafts              *draftBox // Encrypted drafts (nil = drafts not enabled)
+	secureMemory        bool      // Close zeroes key material
+	maintenance         *maintenanceScheduler
+	requests            *pendingRequests
+	supervisor          *Supervisor // Restarts failed subsystems (nil = not supervised)
+	restartPolicies     map[Subsystem]*RestartPolicy
+	sequence            *message.SequenceClock // Orders outgoing messages after everything sent or received
+	logger              utils.Logger
+	panicHandler        utils.PanicHandler
+	signingKeys         *signingKeyCache
+	keyBundleFlights    utils.FlightGroup[*encryption.KeyBundle] // Coalesces concurrent key bundle fetches per address
+	verifyIncomingMode  IncomingVerification
+
+	memoryLimits *MemoryLimits
+
+	capabilityProbing        bool
+	capabilityProbeThreshold int64
+	autoUploadAttachments    bool
+
+	distributeKeyBundles bool
+	contactedRecipients  map[string]bool
+	contactMutex         sync.Mutex
+
+	advertiseClientInfo bool
+	clientInfo          *message.ClientInfo
+	peerClientInfo      map[string]*message.ClientInfo
+	renderContentTypes  []string
+	peerMutex           sync.RWMutex
 }
 
 // Config holds configuration for the EMSG client
 type Config struct {
-	KeyPair                *keymgmt.KeyPair
-	Timeout                time.Duration
-	UserAgent              string
-	DNSConfig              *dns.ResolverConfig
-	DNSTTL                 time.Duration
-	RetryStrategy          *RetryStrategy
-	BeforeSend             func(*message.Message) error
+	KeyPair       *keymgmt.KeyPair
+	KeyRing       *keymgmt.KeyRing // Current and previous signing keys; its current key is used instead of KeyPair
+	Timeout       time.Duration
+	UserAgent     string
+	DNSConfig     *dns.ResolverConfig
+	DNSTTL        time.Duration
+	Resolver      Resolver // Finds the server of a domain (nil = DNS lookups configured by DNSConfig, cached for DNSTTL)
+	RetryStrategy *RetryStrategy
+	// Deprecated: use BeforeSendContext.
+	BeforeSend func(*message.Message) error
+	// Deprecated: use AfterSendContext.
 	AfterSend              func(*message.Message, *http.Response) error
+	BeforeSendContext      func(context.Context, *message.Message) error                 // Called with the send's context before signing; an error aborts the send
+	AfterSendContext       func(context.Context, *message.Message, *http.Response) error // Called with the send's context after the server accepts the message
+	OnRequest              func(context.Context, *RequestInfo) error                     // Called before each HTTP attempt to a server, including retries; an error aborts the request
+	OnResponse             func(context.Context, *ResponseInfo)                          // Called after each HTTP attempt with its outcome and what happens next
 	EncryptionConfig       *encryption.EncryptionConfig
 	EnableNotifications    bool
 	NotificationHandlers   map[notifications.NotificationEvent][]notifications.NotificationHandler
@@ -81,10 +169,98 @@ type Config struct {
 	PollInterval           time.Duration
 	EnableWebSocket        bool
 	WebSocketConfig        *websocket.ReconnectStrategy
+	WebSocketFrames        *websocket.FrameOptions // Frame compression and encryption offered to the server (nil = plain frames)
 	EnableDeliveryTracking bool
 	DeliveryRetryStrategy  *delivery.RetryStrategy
 	AttachmentConfig       *attachments.AttachmentConfig
 	EnableGroupManagement  bool
+	PushConfig             *notifications.PushConfig
+	DomainOverrides        map[string]*DomainOverride // Keyed by domain pattern, e.g. "partner.org" or "*.internal.example.com"
+	DistributeKeyBundles   bool                       // Include our public key bundle when first messaging a recipient (opt-in)
+	TransportSelection     *TransportSelectionConfig  // Adaptive HTTP/WebSocket selection settings
+	MessageStore           store.MessageStore         // Local store for sent, fetched and pushed messages, queried with SearchMessages (nil = not persisted)
+	HTTPClient             HTTPDoer                   // Sends all HTTP requests (nil = *http.Client using Timeout)
+	Middleware             []Middleware               // Wraps each HTTP round trip to servers, outermost first; more can be added with Use
+	Logger                 utils.Logger               // Receives retries, reconnects and warnings (nil = discarded; *slog.Logger works directly)
+	PanicHandler           utils.PanicHandler         // Receives panics recovered from handlers and callbacks, with stack traces (nil = logged only)
+	// Capability probing before attachment-heavy sends
+	ProbeCapabilities        bool          // Probe recipient servers and fit attachments to their limits
+	CapabilityProbeThreshold int64         // Only probe when total attachment size is at least this many bytes
+	CapabilityTTL            time.Duration // How long probed capabilities are cached
+	AutoUploadAttachments    bool          // Upload attachments too large for a recipient server to it and send them as URLs
+	// Retention of undecryptable messages for re-decryption after key changes
+	RetainUndecryptable bool // Keep messages that fail to decrypt and retry them when keys change
+	MaxUndecryptable    int  // Maximum retained messages; the oldest is dropped beyond this (0 = unlimited)
+	// Automatic discovery of recipient encryption keys
+	EnableKeyDiscovery      bool          // Fetch missing recipient keys from their domain's server
+	KeyDiscoveryTTL         time.Duration // How long a discovered key is used before it is fetched again
+	KeyDiscoveryNegativeTTL time.Duration // How long an address whose key lookup failed is not looked up again (0 = retry every time)
+	// Client-info envelope advertising our SDK and features to correspondents
+	AdvertiseClientInfo bool                // Attach client info to outgoing messages (disable for privacy)
+	ClientInfo          *message.ClientInfo // Advertised info (nil = SDK name, version and enabled features)
+	// Rich content types the application renders besides text/plain, advertised in client info
+	// and used by NegotiateContentType, e.g. message.ContentTypeMarkdown (nil = plain text only)
+	RenderContentTypes []string
+	// Retention of sent messages for proof-of-delivery bundles
+	RecordDeliveryProofs bool // Keep signed messages, server responses and signed receipts of sent messages
+	MaxDeliveryProofs    int  // Maximum recorded messages; the oldest is dropped beyond this (0 = unlimited)
+	// Durable outbox drained by a background sender
+	Outbox         store.OutboxStore // Queue for outgoing messages (nil = no outbox)
+	QueueOutgoing  bool              // SendMessage adds messages to the outbox instead of sending immediately
+	OutboxInterval time.Duration     // How often the background sender retries queued messages
+	// Deliver to the recipient domains that resolve when others fail DNS resolution,
+	// retrying the rest from the outbox (requires Outbox)
+	PartialDelivery bool
+	// Signature verification of fetched messages
+	VerifyIncoming IncomingVerification // Check fetched messages against their sender's signing key (default: off)
+	KeyResolver    KeyResolver          // Resolves sender signing keys (nil = key bundle published on the sender's domain)
+	SigningKeyTTL  time.Duration        // How long a resolved sender key is used before it is resolved again (0 = KeyDiscoveryTTL)
+	// Memory limits for constrained devices
+	MemoryProfile MemoryProfile // Caps DNS cache, notification queues, WebSocket buffers, receipts and inline attachments (default: standard)
+	// Group state synchronization with group servers
+	SyncGroups bool // Push changes made through the *WithMessage group methods to the group's server (requires EnableGroupManagement)
+	// Delegated sending on an account's behalf
+	DelegationToken *auth.DelegationToken // Issued by the account to KeyPair; limits who the client sends as and to, and until when
+	// Request/response messaging
+	RequestPollInterval time.Duration // How often SendRequest polls for replies when neither WebSocket nor polling delivers them
+	// Supervised restarts of the WebSocket connection and message poller
+	SuperviseSubsystems bool                         // Restart subsystems that stop on an unrecoverable error
+	RestartPolicies     map[Subsystem]*RestartPolicy // Per-subsystem policies (missing = WebSocketConfig for the WebSocket, DefaultRestartPolicy otherwise)
+	OnSubsystemFailure  func(*SubsystemFailure)      // Called when a subsystem keeps failing and is left stopped
+	// Encrypted drafts and handling of sensitive data in memory
+	DraftStore   store.DraftStore // Stores drafts encrypted at rest (nil = drafts not enabled)
+	DraftKey     *[32]byte        // Key drafts are encrypted with (nil = a random key stored wrapped with the signing key)
+	SecureMemory bool             // Zero private keys and the draft key on Close
+	// Store compaction
+	MaintenanceSchedule *MaintenanceSchedule     // When StartMaintenance compacts stores (nil = only on Maintain)
+	OnMaintenance       func(*MaintenanceReport) // Called after each maintenance run with the space reclaimed
+	// Addresses and aliases of the local user. A message fetched for several of them
+	// is returned once, with AddressedIdentities naming each one it was addressed to.
+	Identities []string
+	// Delivery receipt persistence
+	ReceiptStore delivery.ReceiptStore // Keeps tracked receipts and pending retries across restarts (requires EnableDeliveryTracking; nil = memory only)
+	// Automatic resending of failed deliveries
+	RetryInterval time.Duration // How often StartRetryWorker resends deliveries due for a retry (0 = retry worker not enabled; requires EnableDeliveryTracking)
+	// Catching up after the system slept
+	WakeCheckInterval time.Duration // How often StartWakeDetector checks whether the system slept, calling Resync when it did (0 = wake detection not enabled)
+	// Offline mode: queue sends while the network is down and send them once it is back
+	OfflineMode          bool                            // Go offline when a send fails with a network error, queueing it and later sends in the outbox (requires Outbox)
+	ConnectivityInterval time.Duration                   // How often StartConnectivityMonitor checks whether an offline client is back online
+	ConnectivityCheck    func(ctx context.Context) error // Reports whether the network is reachable (nil = ping the server of the WebSocket or polled address, or of the first queued recipient)
+	// In-process delivery between clients sharing a network
+	Loopback *LoopbackNetwork // Delivers to addresses attached with AttachLoopback in memory, skipping DNS and HTTP (nil = loopback not enabled)
+	// Auth header timestamps and expiry
+	CompensateClockSkew bool          // Timestamp auth headers by the server's clock, as measured from the Date header of responses
+	AuthHeaderTTL       time.Duration // How long signed auth headers are valid (0 = auth.DefaultAuthHeaderTTL)
+	OmitAuthExpiry      bool          // Sign auth headers without an expiry, as servers verifying only METHOD:PATH:TIMESTAMP:NONCE require; AuthHeaderTTL is ignored
+	// Injected randomness, IDs and time for reproducible tests and simulations
+	Entropy     io.Reader         // Source of generated keys, recovery codes and encryption nonces (nil = crypto/rand)
+	IDGenerator utils.IDGenerator // Generates IDs of messages built with ComposeMessage (nil = derived from the content)
+	Clock       utils.Clock       // Timestamps messages built with ComposeMessage (nil = system time)
+	// Metrics
+	Metrics metrics.Recorder // Receives message, retry, latency, reconnect, poll and attachment metrics, e.g. a metrics.Registry (nil = not recorded)
+	// Tracing
+	Tracer tracing.Tracer // Starts spans around sends, DNS resolution, attachment uploads and WebSocket connects, e.g. an OpenTelemetry adapter (nil = not traced)
 }
 
 // DefaultConfig returns a default client configuration
@@ -106,47 +282,209 @@ func DefaultConfig() *Config {
 		DeliveryRetryStrategy:  delivery.DefaultRetryStrategy(),
 		AttachmentConfig:       attachments.DefaultAttachmentConfig(),
 		EnableGroupManagement:  true,
+		DistributeKeyBundles:   false, // Opt-in: recipients pin bundles only after checking them against our published signing key
+		TransportSelection:     DefaultTransportSelectionConfig(),
+
+		ProbeCapabilities:        true,
+		CapabilityProbeThreshold: 256 * 1024, // 256KB
+		CapabilityTTL:            15 * time.Minute,
+
+		RetainUndecryptable: true,
+		MaxUndecryptable:    1000,
+
+		AdvertiseClientInfo: true,
+
+		CompensateClockSkew: true,
+
+		EnableKeyDiscovery:      false,
+		KeyDiscoveryTTL:         24 * time.Hour,
+		KeyDiscoveryNegativeTTL: time.Minute,
+
+		RecordDeliveryProofs: false,
+		MaxDeliveryProofs:    1000,
+
+		QueueOutgoing:  false,
+		OutboxInterval: 10 * time.Second,
+
+		ConnectivityInterval: 30 * time.Second,
+
+		VerifyIncoming: VerifyOff,
+
+		RequestPollInterval: time.Second,
+
+		SuperviseSubsystems: true,
 	}
 }
 
-// New creates a new EMSG client with the given configuration
-func New(config *Config) *Client {
+// New creates a new EMSG client with the given configuration.
+// Invalid configuration is reported as ConfigErrors.
+func New(config *Config) (*Client, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
 
-	httpClient := &http.Client{
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	var httpClient HTTPDoer = &http.Client{
 		Timeout: config.Timeout,
 	}
+	if config.HTTPClient != nil {
+		httpClient = config.HTTPClient
+	}
 
-	resolver := dns.NewCachedResolver(config.DNSConfig, config.DNSTTL)
+	resolver := newClientResolver(config)
 
 	retryStrategy := config.RetryStrategy
 	if retryStrategy == nil {
 		retryStrategy = DefaultRetryStrategy()
 	}
 
+	keyPair := config.KeyPair
+	if config.KeyRing != nil {
+		keyPair = config.KeyRing.Current()
+	}
+
 	client := &Client{
-		keyPair:       config.KeyPair,
+		keyPair:       keyPair,
+		keyRing:       config.KeyRing,
+		delegation:    config.DelegationToken,
 		resolver:      resolver,
 		httpClient:    httpClient,
 		userAgent:     config.UserAgent,
 		retryStrategy: retryStrategy,
-		beforeSend:    config.BeforeSend,
-		afterSend:     config.AfterSend,
+		beforeSend:    config.BeforeSendContext,
+		afterSend:     config.AfterSendContext,
+		onRequest:     config.OnRequest,
+		onResponse:    config.OnResponse,
+		logger:        utils.LoggerOrNop(config.Logger),
+		panicHandler:  config.PanicHandler,
+
+		webSocketConfig: config.WebSocketConfig,
+		webSocketFrames: config.WebSocketFrames,
+		compensateClock: config.CompensateClockSkew,
+		entropy:         config.Entropy,
+		idGenerator:     config.IDGenerator,
+		clock:           config.Clock,
+		metrics:         config.Metrics,
+		tracer:          config.Tracer,
+		authHeaderTTL:   config.AuthHeaderTTL,
+		omitAuthExpiry:  config.OmitAuthExpiry,
+		restartPolicies: config.RestartPolicies,
+
+		distributeKeyBundles: config.DistributeKeyBundles,
+		contactedRecipients:  make(map[string]bool),
+		transportSelector:    NewTransportSelector(config.TransportSelection),
+		messageStore:         config.MessageStore,
+		capabilities: &capabilityCache{
+			entries: make(map[string]*capabilityEntry),
+			ttl:     config.CapabilityTTL,
+		},
+		undecryptable: &undecryptableInbox{
+			entries: make(map[string]*PendingDecryption),
+			limit:   config.MaxUndecryptable,
+			enabled: config.RetainUndecryptable,
+		},
+		deliveryProofs: &proofRecorder{
+			records: make(map[string]*proofRecord),
+			limit:   config.MaxDeliveryProofs,
+			enabled: config.RecordDeliveryProofs,
+		},
+		membershipProofs: &membershipProofs{
+			proofs: make(map[string]*groups.MembershipProof),
+		},
+		emojiAssets: &emojiAssets{
+			assets: make(map[string]*attachments.Attachment),
+		},
+		inbox: &inboxDedup{
+			identities: make(map[string]bool),
+			seen:       make(map[string]map[string]bool),
+			limit:      defaultInboxDedupEntries,
+		},
+		sequence:     message.NewSequenceClock(),
+		secureMemory: config.SecureMemory,
+		maintenance: &maintenanceScheduler{
+			schedule: config.MaintenanceSchedule,
+			onReport: config.OnMaintenance,
+		},
+		requests: &pendingRequests{
+			waiters:         make(map[string]*requestWaiter),
+			pollInterval:    config.RequestPollInterval,
+			requireVerified: config.VerifyIncoming != VerifyOff,
+		},
+
+		capabilityProbing:        config.ProbeCapabilities,
+		capabilityProbeThreshold: config.CapabilityProbeThreshold,
+		autoUploadAttachments:    config.AutoUploadAttachments,
+		partialDelivery:          config.PartialDelivery,
+
+		advertiseClientInfo: config.AdvertiseClientInfo,
+		clientInfo:          config.ClientInfo,
+		peerClientInfo:      make(map[string]*message.ClientInfo),
+		renderContentTypes:  config.RenderContentTypes,
+	}
+
+	// Adapt the deprecated context-free hooks
+	if hook := config.BeforeSend; hook != nil {
+		client.beforeSend = func(ctx context.Context, msg *message.Message) error { return hook(msg) }
+	}
+	if hook := config.AfterSend; hook != nil {
+		client.afterSend = func(ctx context.Context, msg *message.Message, resp *http.Response) error { return hook(msg, resp) }
+	}
+
+	// Resolve sender keys for signature verification, by default from their published key bundles
+	client.verifyIncomingMode = config.VerifyIncoming
+	keyResolver := config.KeyResolver
+	if keyResolver == nil {
+		keyResolver = KeyResolverFunc(client.FetchSigningKeyContext)
+	}
+	if config.Loopback != nil {
+		client.loopback = config.Loopback
+		keyResolver = config.Loopback.keyResolver(keyResolver)
+	}
+	signingKeyTTL := config.SigningKeyTTL
+	if signingKeyTTL == 0 {
+		signingKeyTTL = config.KeyDiscoveryTTL
+	}
+	client.signingKeys = &signingKeyCache{
+		resolver:    keyResolver,
+		ttl:         signingKeyTTL,
+		negativeTTL: config.KeyDiscoveryNegativeTTL,
+		entries:     make(map[string]*signingKeyEntry),
+		revoked:     make(map[string]map[string]bool),
+	}
+
+	// Build per-domain HTTP settings
+	client.initDomainOverrides(config.DomainOverrides)
+	client.Use(config.Middleware...)
+
+	// Report when transport selection starts or stops avoiding a failing path
+	client.transportSelector.SetDiagnosticsHandler(client.reportTransportDiagnostics)
+
+	// Fetch recipient keys on demand instead of requiring RegisterPublicKey
+	if config.EnableKeyDiscovery {
+		client.keyDiscovery = client.newKeyDiscovery(config.KeyDiscoveryTTL, config.KeyDiscoveryNegativeTTL)
 	}
 
 	// Initialize encryption manager if encryption is enabled
-	if config.EncryptionConfig != nil && config.EncryptionConfig.Enabled && config.EncryptionConfig.KeyPair != nil {
+	if config.EncryptionConfig != nil {
+		client.keyStoreWriteBehind = config.EncryptionConfig.WriteBehind
+	}
+	if config.EncryptionConfig != nil && config.EncryptionConfig.Enabled {
 		client.encryptionManager = encryption.NewEncryptionManager(
 			config.EncryptionConfig.KeyPair,
-			config.EncryptionConfig.KeyStore,
+			client.wrapKeyStore(config.EncryptionConfig.KeyStore),
 		)
+		client.encryptionManager.SetKeyDiscovery(client.keyDiscovery)
+		client.encryptionManager.SetEntropy(config.Entropy)
 	}
 
 	// Initialize notification manager if notifications are enabled
 	if config.EnableNotifications {
 		client.notificationManager = notifications.NewNotificationManager(10) // Max 10 concurrent handlers
+		client.notificationManager.SetLogger(client.logger)
+		client.notificationManager.SetPanicHandler(client.panicHandler)
 
 		// Register handlers from config
 		for event, handlers := range config.NotificationHandlers {
@@ -164,129 +502,387 @@ func New(config *Config) *Client {
 		// Initialize message poller
 		client.messagePoller = notifications.NewMessagePoller(client, client.notificationManager, config.PollInterval)
 	}
+	client.pollInterval = config.PollInterval
+
+	// Initialize push formatter if configured
+	if config.PushConfig != nil {
+		client.pushFormatter = notifications.NewPushFormatter(config.PushConfig)
+	}
 
 	// Initialize delivery tracker if enabled
 	if config.EnableDeliveryTracking {
 		client.deliveryTracker = delivery.NewDeliveryTracker(config.DeliveryRetryStrategy)
+		client.deliveryTracker.SetPanicHandler(client.panicHandler)
+		client.deliveryTracker.SetLogger(client.logger)
+		if config.RetryInterval > 0 {
+			client.retryWorker = newRetryWorker(config.RetryInterval, config.DeliveryRetryStrategy)
+		}
 	}
 
-	// Initialize attachment manager
-	if config.AttachmentConfig != nil {
-		attachmentManager, err := attachments.NewAttachmentManager(config.AttachmentConfig)
-		if err != nil {
-			log.Printf("Warning: failed to initialize attachment manager: %v", err)
-		} else {
-			client.attachmentManager = attachmentManager
+	// Keep drafts encrypted at rest when a draft store is configured
+	if config.DraftStore != nil {
+		client.drafts = &draftBox{store: config.DraftStore}
+		if config.DraftKey != nil {
+			key := *config.DraftKey
+			client.drafts.key = &key
+			client.drafts.fixed = true
 		}
 	}
 
+	if config.WakeCheckInterval > 0 {
+		client.wakeDetector = &wakeDetector{interval: config.WakeCheckInterval}
+	}
+
+	// Queue outgoing messages durably when an outbox store is configured
+	if config.Outbox != nil {
+		client.outbox = newOutboxSender(config.Outbox, config.QueueOutgoing, config.OutboxInterval, config.DeliveryRetryStrategy)
+	}
+	if config.OfflineMode {
+		client.connectivity = newConnectivityMonitor(config.ConnectivityInterval, config.ConnectivityCheck)
+	}
+
+	// Attachment storage is initialized on first use so unused clients never touch the filesystem
+	client.attachmentConfig = config.AttachmentConfig
+	if hc, ok := config.HTTPClient.(*http.Client); ok && client.attachmentConfig != nil && client.attachmentConfig.HTTPClient == nil {
+		// Route attachment downloads through the same proxy or transport as the rest of the client
+		attachmentConfig := *client.attachmentConfig
+		attachmentConfig.HTTPClient = hc
+		client.attachmentConfig = &attachmentConfig
+	}
+
 	// Initialize group manager if enabled
 	if config.EnableGroupManagement {
 		client.groupManager = groups.NewGroupManager()
+		if config.SyncGroups {
+			client.groupSync = &GroupSyncClient{client: client, manager: client.groupManager}
+		}
+	}
+
+	if config.SuperviseSubsystems {
+		client.supervisor = NewSupervisor(client.logger, config.OnSubsystemFailure)
+	}
+
+	for _, identity := range config.Identities {
+		client.inbox.identities[utils.NormalizeEMSGAddress(identity)] = true
 	}
 
-	return client
+	client.memoryLimits = config.MemoryProfile.Limits()
+	client.applyMemoryLimits()
+
+	// Recover receipts tracked before a restart, capped by the memory limits
+	if config.ReceiptStore != nil {
+		if err := client.deliveryTracker.SetReceiptStore(config.ReceiptStore); err != nil {
+			return nil, fmt.Errorf("failed to restore delivery receipts: %w", err)
+		}
+	}
+
+	return client, nil
 }
 
 // NewWithKeyPair creates a new EMSG client with a key pair
-func NewWithKeyPair(keyPair *keymgmt.KeyPair) *Client {
+func NewWithKeyPair(keyPair *keymgmt.KeyPair) (*Client, error) {
 	config := DefaultConfig()
 	config.KeyPair = keyPair
 	return New(config)
 }
 
-// SetKeyPair sets the key pair for the client
+// SetKeyPair sets the key pair for the client without coordinating with
+// active subsystems. Use RotateKeyPair to replace the key of a live client.
 func (c *Client) SetKeyPair(keyPair *keymgmt.KeyPair) {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	// Keep the replaced key in the ring so its signatures still verify
+	if c.keyRing != nil && keyPair != nil && c.keyRing.CurrentKeyID() != keyPair.KeyID() {
+		c.keyRing.Rotate(keyPair)
+	}
 	c.keyPair = keyPair
 }
 
+// GetKeyRing returns the key ring tracking previous key pairs, or nil if none is used
+func (c *Client) GetKeyRing() *keymgmt.KeyRing {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
+	return c.keyRing
+}
+
 // GetKeyPair returns the current key pair
 func (c *Client) GetKeyPair() *keymgmt.KeyPair {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
 	return c.keyPair
 }
 
 // ComposeMessage creates a new message builder
 func (c *Client) ComposeMessage() *message.MessageBuilder {
 	builder := message.NewMessageBuilder()
+	if c.idGenerator != nil {
+		builder.WithIDGenerator(c.idGenerator)
+	}
+	if c.clock != nil {
+		builder.WithClock(c.clock)
+	}
 	if c.encryptionManager != nil {
 		builder.WithEncryption(c.encryptionManager)
 	}
-	if c.attachmentManager != nil {
-		builder.WithAttachmentManager(c.attachmentManager)
+	if c.attachmentConfig != nil {
+		if attachmentManager, err := c.getAttachmentManager(); err == nil {
+			builder.WithAttachmentManager(attachmentManager)
+		}
 	}
 	return builder
 }
 
 // SendMessage sends an EMSG message
 func (c *Client) SendMessage(msg *message.Message) error {
-	if c.keyPair == nil {
-		return fmt.Errorf("no key pair configured")
-	}
+	return c.SendMessageContext(context.Background(), msg)
+}
 
-	// Start delivery tracking if enabled
-	var receipt *delivery.DeliveryReceipt
-	if c.deliveryTracker != nil {
-		receipt = c.deliveryTracker.TrackMessage(msg)
-	}
+// SendMessageContext sends an EMSG message, aborting resolution, retries and
+// in-flight requests when ctx is cancelled or its deadline passes. Guest
+// messages to groups that moderate guests are held for approval, and with
+// QueueOutgoing set, or OfflineMode while offline, the message is added to the
+// outbox instead of being sent.
+func (c *Client) SendMessageContext(ctx context.Context, msg *message.Message) error {
+	_, err := c.SendMessageWithResult(ctx, msg)
+	return err
+}
 
-	// Call BeforeSend hook if configured
-	if c.beforeSend != nil {
-		if err := c.beforeSend(msg); err != nil {
-			if receipt != nil {
-				c.deliveryTracker.UpdateDeliveryStatus(msg.MessageID, delivery.StatusFailed, err.Error())
-			}
-			return fmt.Errorf("before send hook failed: %w", err)
-		}
-	}
+// sendMessage signs and delivers a message to every recipient domain. Delivery
+// tracking is left to the caller when track is false. Recipients left out of a
+// partial delivery are queued for retry.
+func (c *Client) sendMessage(ctx context.Context, msg *message.Message, track bool) error {
+	_, err := c.sendMessageResult(ctx, msg, track)
+	return err
+}
 
-	// Validate the message
-	if err := msg.Validate(); err != nil {
-		return fmt.Errorf("invalid message: %w", err)
-	}
+// deliverMessage signs and delivers a message to its recipient domains, or only
+// to the given domains when the list is not empty. With partial delivery enabled,
+// domains that fail to resolve are recorded in the result instead of failing the
+// send, as long as at least one domain accepted the message.
+func (c *Client) deliverMessage(ctx context.Context, msg *message.Message, track bool, only []string) (*SendResult, error) {
+	// Block key rotation until this send has completed
+	c.rotationMutex.RLock()
+	defer c.rotationMutex.RUnlock()
 
-	// Sign the message
-	if err := msg.Sign(c.keyPair); err != nil {
-		return fmt.Errorf("failed to sign message: %w", err)
+	send, err := c.prepareSend(ctx, msg, track)
+	if err != nil {
+		return nil, err
 	}
+	receipt, note := send.receipt, send.note
 
 	// Get all unique domains from recipients
 	domains := c.getDomainsFromMessage(msg)
+	if len(only) > 0 {
+		domains = make(map[string]bool, len(only))
+		for _, domain := range only {
+			domains[domain] = true
+		}
+	}
 
 	// Send to each domain
+	result := &SendResult{MessageID: msg.MessageID, Failed: make(map[string]error)}
+	partial := c.partialDelivery && !note
 	var lastResp *http.Response
 	var sendErr error
 	for domain := range domains {
-		resp, err := c.sendMessageToDomainWithResponse(msg, domain)
+		resp, err := c.sendMessageToDomainWithResponse(ctx, msg, domain)
+		c.recordSendMetrics(msg, domain, err)
 		if err != nil {
 			sendErr = fmt.Errorf("failed to send message to domain %s: %w", domain, err)
+			if partial && errors.Is(err, ErrDomainResolution) {
+				result.addUnresolved(domain, recipientsInDomain(msg, domain), sendErr)
+				continue
+			}
 			if receipt != nil {
-				c.deliveryTracker.UpdateDeliveryStatus(msg.MessageID, delivery.StatusFailed, sendErr.Error())
+				c.recordSendFailure(ctx, msg, delivery.ClassifyError(err), sendErr.Error())
 			}
-			return sendErr
+			return nil, sendErr
 		}
+		if !note {
+			c.recordAcceptance(msg, domain, resp)
+		}
+		if receipt != nil {
+			c.deliveryTracker.UpdateDomainStatusContext(ctx, msg.MessageID, domain, delivery.StatusSent, "")
+		}
+		result.Delivered = append(result.Delivered, recipientsInDomain(msg, domain)...)
 		lastResp = resp
 	}
 
+	// Nothing was delivered if every domain failed to resolve
+	if len(result.unresolved) > 0 {
+		if len(result.Delivered) == 0 {
+			if receipt != nil {
+				c.recordSendFailure(ctx, msg, delivery.FailureDNS, sendErr.Error())
+			}
+			return nil, sendErr
+		}
+		c.logger.Warn("delivered to some recipients only", "message_id", msg.MessageID, "unresolved", result.unresolved, "error", sendErr)
+	}
+	c.recordRecipientDelivery(msg, result)
+
 	// Update delivery status to sent
 	if receipt != nil {
-		c.deliveryTracker.UpdateDeliveryStatus(msg.MessageID, delivery.StatusSent, "")
+		c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusSent, "")
+	}
+
+	c.completeSend(ctx, msg, send, lastResp)
+	return result, nil
+}
+
+// preparedSend is a message that passed the checks before sending and was signed
+type preparedSend struct {
+	receipt       *delivery.DeliveryReceipt // Tracked receipt (nil = not tracked)
+	note          bool                      // A note to self, kept out of tracking and delivery bookkeeping
+	slowModeGroup *groups.Group             // Group whose slow mode the send counts against
+}
+
+// prepareSend runs everything a message goes through before the network,
+// whichever transport carries it: delivery tracking, the before-send hook,
+// validation, expiry, group restrictions, attachment fitting, key bundle,
+// client info, sequence, delegation and membership proof, and finally signing.
+// The caller must hold rotationMutex for reading. Failures after tracking
+// started are recorded on the receipt.
+func (c *Client) prepareSend(ctx context.Context, msg *message.Message, track bool) (*preparedSend, error) {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	// Notes to self are persisted locally and kept out of delivery tracking
+	note := msg.IsNoteToSelf()
+
+	// Start delivery tracking if enabled
+	var receipt *delivery.DeliveryReceipt
+	if c.deliveryTracker != nil && track && !note {
+		receipt = c.deliveryTracker.TrackMessage(msg)
+	}
+
+	// Call the before-send hook if configured
+	if c.beforeSend != nil {
+		if err := c.beforeSend(ctx, msg); err != nil {
+			if receipt != nil {
+				c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusFailed, err.Error())
+			}
+			return nil, fmt.Errorf("before send hook failed: %w", err)
+		}
+	}
+
+	// Validate the message
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if err := msg.CheckExpiry(time.Now()); err != nil {
+		if receipt != nil {
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusExpired, err.Error())
+		}
+		return nil, err
+	}
+
+	// Enforce bans, mutes and the group's slow mode before anything is sent
+	if err := c.checkGroupRestrictions(msg); err != nil {
+		if receipt != nil {
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusFailed, err.Error())
+		}
+		return nil, err
+	}
+	slowModeGroup := c.slowModeGroup(ctx, msg)
+	if slowModeGroup != nil {
+		if err := slowModeGroup.CheckSlowMode(msg.From); err != nil {
+			if receipt != nil {
+				c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusFailed, err.Error())
+			}
+			return nil, err
+		}
+	}
+
+	// Fit attachments to what the recipient servers accept
+	if err := c.prepareAttachmentDelivery(ctx, msg); err != nil {
+		if receipt != nil {
+			c.deliveryTracker.UpdateDeliveryFailureContext(ctx, msg.MessageID, delivery.StatusFailed, delivery.ClassifyError(err), err.Error())
+		}
+		return nil, err
+	}
+
+	// Include our key bundle on first contact so recipients can reply encrypted
+	if !note {
+		c.attachKeyBundle(msg)
+	}
+
+	// Advertise our SDK and features so recipients can degrade gracefully
+	c.attachClientInfo(msg)
+
+	// Order the message after everything this client has sent or seen. Retries keep
+	// the sequence they were first signed with.
+	if msg.Sequence == 0 {
+		msg.Sequence = c.sequence.Next()
+	}
+
+	// Keep a delegate within its token's scope
+	if err := c.applyDelegation(msg); err != nil {
+		if receipt != nil {
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusFailed, err.Error())
+		}
+		return nil, err
+	}
+
+	// Let servers and members on other domains check we belong to the group
+	if err := c.attachMembershipProof(msg); err != nil {
+		if receipt != nil {
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusFailed, err.Error())
+		}
+		return nil, err
+	}
+
+	// Sign the message, naming the key when signing keys are rotated through a key ring
+	if c.GetKeyRing() != nil {
+		msg.KeyID = keyPair.KeyID()
+	}
+	if err := msg.Sign(keyPair); err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	// Save notes before sending so they survive an unreachable server
+	if note {
+		if err := c.persistNote(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return &preparedSend{receipt: receipt, note: note, slowModeGroup: slowModeGroup}, nil
+}
+
+// completeSend does the bookkeeping after a message was handed over: it stores
+// the sent message, counts it against slow mode, remembers the recipients got
+// our key bundle, and runs the after-send hook (when there is an HTTP response)
+// and the message sent notification
+func (c *Client) completeSend(ctx context.Context, msg *message.Message, send *preparedSend, resp *http.Response) {
+	// Keep sent messages beside received ones in the local store
+	if !send.note {
+		c.storeMessages([]*message.Message{msg})
+	}
+
+	if send.slowModeGroup != nil {
+		send.slowModeGroup.RecordMessageSent(msg.From)
 	}
 
-	// Call AfterSend hook if configured
-	if c.afterSend != nil && lastResp != nil {
-		if err := c.afterSend(msg, lastResp); err != nil {
-			log.Printf("Warning: after send hook failed: %v", err)
+	if !send.note {
+		c.markContacted(msg)
+	}
+
+	// Call the after-send hook if configured
+	if c.afterSend != nil && resp != nil {
+		if err := c.afterSend(ctx, msg, resp); err != nil {
+			c.logger.Warn("after send hook failed", "message_id", msg.MessageID, "error", err)
 		}
 	}
 
 	// Trigger message sent notification
 	if c.notificationManager != nil {
-		if err := c.notificationManager.NotifyMessageSent(msg); err != nil {
-			log.Printf("Warning: failed to notify message sent: %v", err)
+		if err := c.notificationManager.NotifyMessageSentContext(ctx, msg); err != nil {
+			c.logger.Warn("failed to notify message sent", "message_id", msg.MessageID, "error", err)
 		}
 	}
-
-	return nil
 }
 
 // getDomainsFromMessage extracts unique domains from message recipients
@@ -304,17 +900,22 @@ func (c *Client) getDomainsFromMessage(msg *message.Message) map[string]bool {
 }
 
 // sendMessageToDomain sends a message to a specific domain
-func (c *Client) sendMessageToDomain(msg *message.Message, domain string) error {
-	_, err := c.sendMessageToDomainWithResponse(msg, domain)
+func (c *Client) sendMessageToDomain(ctx context.Context, msg *message.Message, domain string) error {
+	_, err := c.sendMessageToDomainWithResponse(ctx, msg, domain)
 	return err
 }
 
 // sendMessageToDomainWithResponse sends a message to a specific domain and returns the response
-func (c *Client) sendMessageToDomainWithResponse(msg *message.Message, domain string) (*http.Response, error) {
+func (c *Client) sendMessageToDomainWithResponse(ctx context.Context, msg *message.Message, domain string) (*http.Response, error) {
+	// Hand the message straight to clients in this process when they hold every recipient
+	if c.loopback.covers(msg, domain) {
+		return c.loopback.deliver(msg, domain)
+	}
+
 	// Resolve the domain to get server information
-	serverInfo, err := c.resolver.ResolveDomain(domain)
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, domain)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve domain %s: %w", domain, err)
+		return nil, &ResolveError{Domain: domain, Err: err}
 	}
 
 	// Prepare the message payload
@@ -325,82 +926,32 @@ func (c *Client) sendMessageToDomainWithResponse(msg *message.Message, domain st
 
 	// Send HTTP request
 	endpoint := fmt.Sprintf("%s/api/v1/messages", serverInfo.URL)
-	return c.sendHTTPRequestWithResponse("POST", endpoint, payload)
+	return c.sendHTTPRequestWithResponse(withRequestMessage(ctx, msg.MessageID), domain, "POST", endpoint, payload)
 }
 
 // sendHTTPRequest sends an authenticated HTTP request with retry logic
-func (c *Client) sendHTTPRequest(method, url string, payload []byte) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= c.retryStrategy.MaxRetries; attempt++ {
-		// Create HTTP request
-		req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
-		if err != nil {
-			return fmt.Errorf("failed to create HTTP request: %w", err)
-		}
-
-		// Set headers
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", c.userAgent)
-
-		// Generate authentication header
-		authHeader, err := auth.GenerateAuthHeader(c.keyPair, method, req.URL.Path)
-		if err != nil {
-			return fmt.Errorf("failed to generate auth header: %w", err)
-		}
-
-		req.Header.Set("Authorization", authHeader.ToHeaderValue())
-
-		// Send request
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			lastErr = fmt.Errorf("HTTP request failed: %w", err)
-			if c.shouldRetry(err, 0, attempt) {
-				c.waitBeforeRetry(attempt)
-				continue
-			}
-			return lastErr
-		}
-		defer resp.Body.Close()
-
-		// Check response status
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			body, _ := io.ReadAll(resp.Body)
-			lastErr = fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
-
-			if c.shouldRetry(nil, resp.StatusCode, attempt) {
-				if attempt < c.retryStrategy.MaxRetries {
-					// Log retry attempt
-					if resp.StatusCode == 429 {
-						fmt.Printf("Rate limited (429), retrying in %v (attempt %d/%d)\n",
-							c.calculateDelay(attempt), attempt+1, c.retryStrategy.MaxRetries+1)
-					}
-					c.waitBeforeRetry(attempt)
-					continue
-				}
-			}
-			return lastErr
-		}
-
-		return nil
+func (c *Client) sendHTTPRequest(ctx context.Context, domain, method, url string, payload []byte) error {
+	resp, err := c.sendHTTPRequestWithResponse(ctx, domain, method, url, payload)
+	if err != nil {
+		return err
 	}
-
-	return lastErr
+	resp.Body.Close()
+	return nil
 }
 
 // shouldRetry determines if a request should be retried
-func (c *Client) shouldRetry(err error, statusCode, attempt int) bool {
-	if attempt >= c.retryStrategy.MaxRetries {
+func (c *Client) shouldRetry(strategy *RetryStrategy, err error, statusCode, attempt int) bool {
+	if attempt >= strategy.MaxRetries {
 		return false
 	}
 
 	// Retry on 429 (rate limit) if enabled
-	if statusCode == 429 && c.retryStrategy.RetryOn429 {
+	if statusCode == 429 && strategy.RetryOn429 {
 		return true
 	}
 
 	// Retry on timeout errors if enabled
-	if err != nil && c.retryStrategy.RetryOnTimeout {
+	if err != nil && strategy.RetryOnTimeout {
 		errStr := err.Error()
 		if strings.Contains(errStr, "timeout") || strings.Contains(errStr, "deadline exceeded") {
 			return true
@@ -411,28 +962,43 @@ func (c *Client) shouldRetry(err error, statusCode, attempt int) bool {
 }
 
 // calculateDelay calculates the delay before the next retry
-func (c *Client) calculateDelay(attempt int) time.Duration {
-	delay := time.Duration(float64(c.retryStrategy.InitialDelay) * math.Pow(c.retryStrategy.BackoffFactor, float64(attempt)))
-	if delay > c.retryStrategy.MaxDelay {
-		delay = c.retryStrategy.MaxDelay
+func (c *Client) calculateDelay(strategy *RetryStrategy, attempt int) time.Duration {
+	delay := time.Duration(float64(strategy.InitialDelay) * math.Pow(strategy.BackoffFactor, float64(attempt)))
+	if delay > strategy.MaxDelay {
+		delay = strategy.MaxDelay
 	}
 	return delay
 }
 
-// waitBeforeRetry waits before retrying a request
-func (c *Client) waitBeforeRetry(attempt int) {
-	delay := c.calculateDelay(attempt)
-	time.Sleep(delay)
+// waitBeforeRetry logs the retry and waits before retrying a request, returning early if ctx is done
+func (c *Client) waitBeforeRetry(ctx context.Context, strategy *RetryStrategy, attempt int, url string, cause error) error {
+	delay := c.calculateDelay(strategy, attempt)
+	c.logger.Info("retrying request", "url", url, "attempt", attempt+1, "max_attempts", strategy.MaxRetries+1, "delay", delay, "error", cause)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // sendHTTPRequestWithResponse sends an authenticated HTTP request with retry logic and returns the response
-func (c *Client) sendHTTPRequestWithResponse(method, url string, payload []byte) (*http.Response, error) {
+func (c *Client) sendHTTPRequestWithResponse(ctx context.Context, domain, method, url string, payload []byte) (*http.Response, error) {
+	settings := c.settingsForDomain(domain)
+	strategy := settings.retryStrategy
 	var lastErr error
 	var lastResp *http.Response
 
-	for attempt := 0; attempt <= c.retryStrategy.MaxRetries; attempt++ {
+	// Scheduled maintenance waits until the client stops making requests
+	c.recordActivity()
+
+	for attempt := 0; attempt <= strategy.MaxRetries; attempt++ {
 		// Create HTTP request
-		req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(payload))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 		}
@@ -442,46 +1008,62 @@ func (c *Client) sendHTTPRequestWithResponse(method, url string, payload []byte)
 		req.Header.Set("User-Agent", c.userAgent)
 
 		// Generate authentication header
-		authHeader, err := auth.GenerateAuthHeader(c.keyPair, method, req.URL.Path)
+		authHeader, err := c.newAuthHeader(c.GetKeyPair(), method, req.URL.Path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate auth header: %w", err)
 		}
 
 		req.Header.Set("Authorization", authHeader.ToHeaderValue())
 
+		// Let the OnRequest hook inspect or veto each attempt
+		info := c.newRequestInfo(ctx, domain, method, url, payload, authHeader, attempt, strategy.MaxRetries+1)
+		if c.onRequest != nil {
+			if err := c.onRequest(ctx, info); err != nil {
+				return nil, fmt.Errorf("request hook failed: %w", err)
+			}
+		}
+
 		// Send request
-		resp, err := c.httpClient.Do(req)
+		start := time.Now()
+		resp, err := c.do(settings.httpClient, req)
 		if err != nil {
 			lastErr = fmt.Errorf("HTTP request failed: %w", err)
-			if c.shouldRetry(err, 0, attempt) {
-				c.waitBeforeRetry(attempt)
+			if c.shouldRetry(strategy, err, 0, attempt) {
+				c.reportResponse(ctx, info, 0, lastErr, start, DispositionRetrying)
+				if err := c.waitBeforeRetry(ctx, strategy, attempt, url, lastErr); err != nil {
+					c.reportResponse(ctx, info, 0, err, start, DispositionCancelled)
+					return nil, err
+				}
 				continue
 			}
+			c.reportResponse(ctx, info, 0, lastErr, start, attemptDisposition(ctx))
 			return nil, lastErr
 		}
 
 		lastResp = resp
+		c.observeServerClock(resp, start)
 
 		// Check response status
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			lastErr = fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
-
-			if c.shouldRetry(nil, resp.StatusCode, attempt) {
-				if attempt < c.retryStrategy.MaxRetries {
-					// Log retry attempt
-					if resp.StatusCode == 429 {
-						log.Printf("Rate limited (429), retrying in %v (attempt %d/%d)",
-							c.calculateDelay(attempt), attempt+1, c.retryStrategy.MaxRetries+1)
+			lastErr = newHTTPError(resp, body)
+
+			if c.shouldRetry(strategy, nil, resp.StatusCode, attempt) {
+				if attempt < strategy.MaxRetries {
+					c.reportResponse(ctx, info, resp.StatusCode, lastErr, start, DispositionRetrying)
+					if err := c.waitBeforeRetry(ctx, strategy, attempt, url, lastErr); err != nil {
+						c.reportResponse(ctx, info, resp.StatusCode, err, start, DispositionCancelled)
+						return nil, err
 					}
-					c.waitBeforeRetry(attempt)
 					continue
 				}
 			}
+			c.reportResponse(ctx, info, resp.StatusCode, lastErr, start, DispositionFailed)
 			return nil, lastErr
 		}
 
+		c.reportResponse(ctx, info, resp.StatusCode, nil, start, DispositionSucceeded)
 		return resp, nil
 	}
 
@@ -490,26 +1072,38 @@ func (c *Client) sendHTTPRequestWithResponse(method, url string, payload []byte)
 
 // RegisterUser registers a user with an EMSG server
 func (c *Client) RegisterUser(address string) error {
-	if c.keyPair == nil {
-		return fmt.Errorf("no key pair configured")
-	}
+	return c.RegisterUserContext(context.Background(), address)
+}
 
-	// Parse the address to get the domain
-	addr, err := utils.ParseEMSGAddress(address)
+// RegisterUserContext registers a user with an EMSG server, honouring ctx cancellation and deadlines
+func (c *Client) RegisterUserContext(ctx context.Context, address string) error {
+	addr, err := utils.ParseAddress(address)
 	if err != nil {
-		return fmt.Errorf("invalid address: %w", err)
+		return invalidAddress("address", err)
+	}
+	return c.RegisterUserAddress(ctx, addr)
+}
+
+// RegisterUserAddress registers an already parsed address with its EMSG server
+func (c *Client) RegisterUserAddress(ctx context.Context, addr utils.Address) error {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return fmt.Errorf("no key pair configured")
+	}
+	if addr.IsZero() {
+		return invalidAddress("address", fmt.Errorf("address cannot be empty"))
 	}
 
 	// Resolve the domain
-	serverInfo, err := c.resolver.ResolveDomain(addr.Domain)
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain())
 	if err != nil {
 		return fmt.Errorf("failed to resolve domain: %w", err)
 	}
 
 	// Prepare registration payload
 	registrationData := map[string]any{
-		"address":    address,
-		"public_key": c.keyPair.PublicKeyBase64(),
+		"address":    addr.String(),
+		"public_key": keyPair.PublicKeyBase64(),
 	}
 
 	payload, err := json.Marshal(registrationData)
@@ -519,69 +1113,148 @@ func (c *Client) RegisterUser(address string) error {
 
 	// Send registration request
 	endpoint := fmt.Sprintf("%s/api/v1/users", serverInfo.URL)
-	return c.sendHTTPRequest("POST", endpoint, payload)
+	return c.sendHTTPRequest(ctx, addr.Domain(), "POST", endpoint, payload)
 }
 
 // GetMessages retrieves messages for the authenticated user
 func (c *Client) GetMessages(address string) ([]*message.Message, error) {
-	if c.keyPair == nil {
-		return nil, fmt.Errorf("no key pair configured")
-	}
+	return c.GetMessagesContext(context.Background(), address)
+}
 
-	// Parse the address to get the domain
-	addr, err := utils.ParseEMSGAddress(address)
+// GetMessagesContext retrieves messages for the authenticated user, honouring ctx cancellation and deadlines
+func (c *Client) GetMessagesContext(ctx context.Context, address string) ([]*message.Message, error) {
+	addr, err := utils.ParseAddress(address)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address: %w", err)
+		return nil, invalidAddress("address", err)
+	}
+	return c.GetMessagesForAddress(ctx, addr)
+}
+
+// GetMessagesForAddress retrieves messages for an already parsed address
+func (c *Client) GetMessagesForAddress(ctx context.Context, addr utils.Address) ([]*message.Message, error) {
+	messages, _, err := c.fetchMessages(ctx, addr, nil)
+	return messages, err
+}
+
+// fetchMessages retrieves messages with optional query parameters and returns the
+// next-page cursor advertised by the server, if any
+func (c *Client) fetchMessages(ctx context.Context, addr utils.Address, query url.Values) ([]*message.Message, string, error) {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, "", fmt.Errorf("no key pair configured")
+	}
+	if addr.IsZero() {
+		return nil, "", invalidAddress("address", fmt.Errorf("address cannot be empty"))
+	}
+
+	// Addresses attached to a loopback network have no server to ask
+	if c.loopback.isAttached(c, addr.String()) {
+		messages, err := c.receiveMessages(ctx, c.loopback.drain(addr.String()), addr.String())
+		return messages, "", err
 	}
 
 	// Resolve the domain
-	serverInfo, err := c.resolver.ResolveDomain(addr.Domain)
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain())
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve domain: %w", err)
+		return nil, "", fmt.Errorf("failed to resolve domain: %w", err)
 	}
 
 	// Create HTTP request
 	endpoint := fmt.Sprintf("%s/api/v1/messages", serverInfo.URL)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, "", fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("User-Agent", c.userAgent)
 
 	// Generate authentication header
-	authHeader, err := auth.GenerateAuthHeader(c.keyPair, "GET", req.URL.Path)
+	authHeader, err := c.newAuthHeader(keyPair, "GET", req.URL.Path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate auth header: %w", err)
+		return nil, "", fmt.Errorf("failed to generate auth header: %w", err)
 	}
 
 	req.Header.Set("Authorization", authHeader.ToHeaderValue())
 
 	// Send request
-	resp, err := c.httpClient.Do(req)
+	start := time.Now()
+	resp, err := c.do(c.settingsForDomain(addr.Domain()).httpClient, req)
 	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
+		if ctx.Err() == nil {
+			c.recordLatency(addr.Domain(), "GET", 0, time.Since(start))
+		}
+		c.recordPollOutcome(ctx, err)
+		return nil, "", fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	c.recordLatency(addr.Domain(), "GET", resp.StatusCode, time.Since(start))
 
 	// Check response status
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
+		httpErr := newHTTPError(resp, body)
+		c.recordPollOutcome(ctx, httpErr)
+		return nil, "", httpErr
 	}
+	c.recordPollOutcome(ctx, nil)
 
 	// Parse response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var messages []*message.Message
 	if err := json.Unmarshal(body, &messages); err != nil {
-		return nil, fmt.Errorf("failed to parse messages: %w", err)
+		return nil, "", fmt.Errorf("failed to parse messages: %w", err)
+	}
+
+	messages, err = c.receiveMessages(ctx, messages, addr.String())
+	if err != nil {
+		return nil, "", err
+	}
+	return messages, resp.Header.Get(nextCursorHeader), nil
+}
+
+// receiveMessages runs messages fetched for address through verification,
+// deduplication, key bundle pinning, storage and reply dispatch
+func (c *Client) receiveMessages(ctx context.Context, messages []*message.Message, address string) ([]*message.Message, error) {
+	// Self-destructed messages are never shown
+	messages = c.dropExpired(messages)
+
+	// Check signatures before anything from the messages is trusted
+	messages, err := c.verifyIncoming(ctx, messages)
+	if err != nil {
+		return nil, err
 	}
 
+	// Return a message addressed to several of our identities only once
+	messages = c.dedupInbox(messages, address)
+
+	// Pin key bundles from first-contact messages
+	c.captureKeyBundles(ctx, messages)
+
+	// Count fetched attachment bytes
+	c.recordReceiveMetrics(messages)
+
+	// Keep our sequence clock ahead of every message we have seen
+	c.observeSequences(messages)
+
+	// Remember which features each sender's client supports
+	c.recordPeerClientInfo(messages)
+
+	// Retain messages we cannot decrypt yet so they can be retried after key changes
+	c.trackUndecryptable(messages, address)
+
+	c.storeMessages(messages)
+
+	// Hand replies to callers waiting in SendRequest
+	c.requests.dispatch(messages)
+
 	return messages, nil
 }
 
@@ -590,18 +1263,33 @@ func (c *Client) ResolveDomain(domain string) (*dns.EMSGServerInfo, error) {
 	return c.resolver.ResolveDomain(domain)
 }
 
+// ResolveDomainContext resolves an EMSG domain to server information, honouring ctx cancellation and deadlines
+func (c *Client) ResolveDomainContext(ctx context.Context, domain string) (*dns.EMSGServerInfo, error) {
+	return c.resolver.ResolveDomainContext(ctx, domain)
+}
+
 // ComposeSystemMessage creates a new system message builder
 func (c *Client) ComposeSystemMessage() *message.SystemMessageBuilder {
 	return message.NewSystemMessageBuilder()
 }
 
-// EnableEncryption enables encryption with the provided key pair and key store
+// EnableEncryption enables encryption with the provided key pair and key store.
+// Pending writes to a previous write-behind key store are persisted first.
 func (c *Client) EnableEncryption(keyPair *encryption.EncryptionKeyPair, keyStore encryption.KeyStore) {
-	c.encryptionManager = encryption.NewEncryptionManager(keyPair, keyStore)
+	if err := c.closeKeyWriter(); err != nil {
+		c.logger.Error("failed to persist pending key store writes", "error", err)
+	}
+	c.encryptionManager = encryption.NewEncryptionManager(keyPair, c.wrapKeyStore(keyStore))
+	c.encryptionManager.SetKeyDiscovery(c.keyDiscovery)
+	c.encryptionManager.SetEntropy(c.entropy)
+	c.retryUndecryptableAfterKeyChange()
 }
 
-// DisableEncryption disables encryption
+// DisableEncryption disables encryption, persisting pending key store writes
 func (c *Client) DisableEncryption() {
+	if err := c.closeKeyWriter(); err != nil {
+		c.logger.Error("failed to persist pending key store writes", "error", err)
+	}
 	c.encryptionManager = nil
 }
 
@@ -613,9 +1301,13 @@ func (c *Client) IsEncryptionEnabled() bool {
 // RegisterPublicKey registers a public key for an address (for encryption)
 func (c *Client) RegisterPublicKey(address, publicKeyBase64 string) error {
 	if c.encryptionManager == nil {
-		return fmt.Errorf("encryption not enabled")
+		return ErrEncryptionUnavailable
 	}
-	return c.encryptionManager.RegisterPublicKey(address, publicKeyBase64)
+	if err := c.encryptionManager.RegisterPublicKey(address, publicKeyBase64); err != nil {
+		return err
+	}
+	c.retryUndecryptableAfterKeyChange()
+	return nil
 }
 
 // CanEncryptFor checks if we can encrypt for a recipient
@@ -637,6 +1329,16 @@ func (c *Client) RegisterNotificationHandler(event notifications.NotificationEve
 	return nil
 }
 
+// RegisterNotificationHandlerContext registers a synchronous notification handler
+// that receives the context of the operation raising the notification
+func (c *Client) RegisterNotificationHandlerContext(event notifications.NotificationEvent, handler notifications.ContextNotificationHandler) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	c.notificationManager.RegisterContextHandler(event, handler)
+	return nil
+}
+
 // RegisterAsyncNotificationHandler registers an asynchronous notification handler
 func (c *Client) RegisterAsyncNotificationHandler(event notifications.NotificationEvent, handler notifications.AsyncNotificationHandler) error {
 	if c.notificationManager == nil {
@@ -646,6 +1348,34 @@ func (c *Client) RegisterAsyncNotificationHandler(event notifications.Notificati
 	return nil
 }
 
+// RegisterAsyncNotificationHandlerContext registers an asynchronous notification
+// handler that receives the raising operation's context values
+func (c *Client) RegisterAsyncNotificationHandlerContext(event notifications.NotificationEvent, handler notifications.ContextAsyncNotificationHandler) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	c.notificationManager.RegisterContextAsyncHandler(event, handler)
+	return nil
+}
+
+// RegisterBatchNotificationHandler registers a handler that receives notifications in batches
+func (c *Client) RegisterBatchNotificationHandler(event notifications.NotificationEvent, config *notifications.BatchConfig, handler notifications.BatchHandler) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	c.notificationManager.RegisterBatchHandler(event, config, handler)
+	return nil
+}
+
+// EnableNotificationDigest coalesces repetitive notifications for an event into digest notifications
+func (c *Client) EnableNotificationDigest(event notifications.NotificationEvent, config *notifications.DigestConfig) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	c.notificationManager.EnableDigest(event, config)
+	return nil
+}
+
 // UnregisterNotificationHandlers removes all handlers for a specific event
 func (c *Client) UnregisterNotificationHandlers(event notifications.NotificationEvent) error {
 	if c.notificationManager == nil {
@@ -660,12 +1390,17 @@ func (c *Client) StartMessagePolling(userAddress string) error {
 	if c.messagePoller == nil {
 		return fmt.Errorf("notifications not enabled")
 	}
-	return c.messagePoller.Start(userAddress)
+	if err := c.messagePoller.Start(userAddress); err != nil {
+		return err
+	}
+	c.superviseMessagePoller()
+	return nil
 }
 
 // StopMessagePolling stops polling for new messages
 func (c *Client) StopMessagePolling() {
 	if c.messagePoller != nil {
+		c.unsupervise(SubsystemMessagePoller)
 		c.messagePoller.Stop()
 	}
 }
@@ -691,10 +1426,28 @@ func (c *Client) GetNotificationHandlerCount(event notifications.NotificationEve
 	return c.notificationManager.GetHandlerCount(event)
 }
 
+// FormatPushNotification converts a notification into a push payload using the configured preview policy
+func (c *Client) FormatPushNotification(notification *notifications.Notification) (*notifications.PushPayload, error) {
+	formatter := c.pushFormatter
+	if formatter == nil {
+		formatter = notifications.NewPushFormatter(nil)
+	}
+	return formatter.Format(notification)
+}
+
 // WebSocket methods
 
 // ConnectWebSocket establishes a WebSocket connection for real-time updates
 func (c *Client) ConnectWebSocket(userAddress string) error {
+	return c.ConnectWebSocketContext(context.Background(), userAddress)
+}
+
+// ConnectWebSocketContext establishes a WebSocket connection, aborting resolution and the
+// handshake if ctx is done. The connection itself outlives ctx; use DisconnectWebSocket to close it.
+func (c *Client) ConnectWebSocketContext(ctx context.Context, userAddress string) (err error) {
+	ctx, span := tracing.Start(c.tracer, ctx, tracing.SpanConnectWebSocket, tracing.String(tracing.AttrAddress, userAddress))
+	defer func() { tracing.End(span, err) }()
+
 	if c.webSocketClient != nil && c.webSocketClient.IsConnected() {
 		return fmt.Errorf("WebSocket already connected")
 	}
@@ -702,23 +1455,76 @@ func (c *Client) ConnectWebSocket(userAddress string) error {
 	// Get server URL from domain
 	addr, err := utils.ParseEMSGAddress(userAddress)
 	if err != nil {
-		return fmt.Errorf("invalid user address: %w", err)
+		return &message.ValidationError{Field: "user_address", Err: fmt.Errorf("invalid user address: %w", err)}
 	}
 
-	serverInfo, err := c.resolver.ResolveDomain(addr.Domain)
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
 	if err != nil {
 		return fmt.Errorf("failed to resolve domain: %w", err)
 	}
+	span.SetAttributes(tracing.String(tracing.AttrServerURL, serverInfo.URL))
 
 	// Create WebSocket client
-	c.webSocketClient = websocket.NewWebSocketClient(serverInfo.URL, c.keyPair, c.notificationManager)
+	c.webSocketClient = websocket.NewWebSocketClient(serverInfo.URL, c.GetKeyPair(), c.notificationManager)
+	c.webSocketClient.SetLogger(c.logger)
+	c.webSocketClient.SetPanicHandler(c.panicHandler)
+	c.webSocketClient.SetDelegationToken(c.GetDelegationToken())
+	c.webSocketClient.SetAuthHeaderOptions(c.authHeaderOptions())
+	if limits := c.webSocketBufferLimits(); limits != nil {
+		c.webSocketClient.SetBufferLimits(limits)
+	}
+	if c.webSocketFrames != nil {
+		c.webSocketClient.SetFrameOptions(c.webSocketFrames)
+	}
 
 	// Set reconnect strategy if configured
 	if c.webSocketClient != nil {
 		c.webSocketClient.SetReconnectStrategy(c.getWebSocketConfig())
 	}
 
-	return c.webSocketClient.Connect(userAddress)
+	// Acked sends are marked sent, rejected and unacknowledged ones failed
+	if c.deliveryTracker != nil {
+		c.webSocketClient.SetDeliveryTracker(c.deliveryTracker)
+	}
+
+	// Feed ack latency into transport selection
+	c.webSocketClient.RegisterEventHandler(websocket.EventAck, c.recordWebSocketAck)
+
+	// Compare WebSocket delivery failures against polling for the error budget
+	c.webSocketClient.RegisterEventHandler(websocket.EventError, c.recordWebSocketError)
+	c.webSocketClient.RegisterEventHandler(websocket.EventMessage, c.recordWebSocketDelivery)
+
+	// Store messages pushed over the WebSocket like fetched ones
+	c.webSocketClient.RegisterEventHandler(websocket.EventMessage, c.storeWebSocketMessage)
+
+	// Count reconnection attempts
+	c.webSocketClient.RegisterEventHandler(websocket.EventReconnecting, c.recordWebSocketReconnect)
+
+	// Collect signed recipient receipts for delivery proofs
+	c.webSocketClient.RegisterEventHandler(websocket.EventSignedReceipt, c.recordWebSocketReceipt)
+
+	// Hand replies pushed over the WebSocket to callers waiting in SendRequest
+	c.webSocketClient.RegisterEventHandler(websocket.EventMessage, c.dispatchWebSocketReply)
+	c.webSocketClient.RegisterEventHandler(websocket.EventMessage, c.observeWebSocketSequence)
+
+	// Drop cached sender keys the server reports changed or revoked
+	c.webSocketClient.RegisterEventHandler(websocket.EventKeyChanged, c.handleKeyChanged)
+	c.webSocketClient.RegisterEventHandler(websocket.EventKeyRevoked, c.handleKeyRevoked)
+
+	// Mirror label changes made elsewhere into the message store
+	c.webSocketClient.RegisterEventHandler(websocket.EventLabelsChanged, c.handleLabelsChanged)
+
+	// A lost connection takes the client offline and reconnecting brings it back
+	if c.connectivity != nil {
+		c.watchWebSocketConnectivity(c.webSocketClient)
+	}
+
+	c.webSocketAddress = userAddress
+	if err := c.webSocketClient.ConnectContext(ctx, userAddress); err != nil {
+		return err
+	}
+	c.superviseWebSocket()
+	return nil
 }
 
 // DisconnectWebSocket closes the WebSocket connection
@@ -726,6 +1532,7 @@ func (c *Client) DisconnectWebSocket() error {
 	if c.webSocketClient == nil {
 		return fmt.Errorf("WebSocket not initialized")
 	}
+	c.unsupervise(SubsystemWebSocket)
 	return c.webSocketClient.Disconnect()
 }
 
@@ -734,13 +1541,10 @@ func (c *Client) IsWebSocketConnected() bool {
 	return c.webSocketClient != nil && c.webSocketClient.IsConnected()
 }
 
-// SendWebSocketMessage sends a message via WebSocket if connected, otherwise falls back to HTTP
+// SendWebSocketMessage sends a message via WebSocket or HTTP, choosing the
+// transport adaptively. Falls back to HTTP when WebSocket is not connected.
 func (c *Client) SendWebSocketMessage(msg *message.Message) error {
-	if c.IsWebSocketConnected() {
-		return c.webSocketClient.SendMessage(msg)
-	}
-	// Fallback to HTTP
-	return c.SendMessage(msg)
+	return c.SendMessageVia(msg, TransportAuto)
 }
 
 // RegisterWebSocketEventHandler registers a WebSocket event handler
@@ -752,15 +1556,36 @@ func (c *Client) RegisterWebSocketEventHandler(event websocket.WebSocketEvent, h
 	return nil
 }
 
+// SendCustomEvent sends an application-defined event with a JSON payload over the WebSocket.
+// Event types must be namespaced, e.g. "editor.cursor".
+func (c *Client) SendCustomEvent(eventType string, payload any) error {
+	if !c.IsWebSocketConnected() {
+		return fmt.Errorf("WebSocket not connected")
+	}
+	return c.webSocketClient.SendCustomEvent(eventType, payload)
+}
+
+// RegisterCustomEventHandler registers a handler for custom WebSocket events matching
+// an event type or a "namespace.*" pattern
+func (c *Client) RegisterCustomEventHandler(pattern string, handler websocket.CustomEventHandler) error {
+	if c.webSocketClient == nil {
+		return fmt.Errorf("WebSocket not initialized")
+	}
+	return c.webSocketClient.RegisterCustomEventHandler(pattern, handler)
+}
+
 // getWebSocketConfig returns the WebSocket configuration
 func (c *Client) getWebSocketConfig() *websocket.ReconnectStrategy {
-	// This would be set from the client config
+	if c.webSocketConfig != nil {
+		return c.webSocketConfig
+	}
 	return websocket.DefaultReconnectStrategy()
 }
 
 // Delivery tracking methods
 
-// GetDeliveryReceipt returns the delivery receipt for a message
+// GetDeliveryReceipt returns the delivery receipt for a message. Recipients
+// breaks its status down per To and CC recipient, and DomainStatuses per domain.
 func (c *Client) GetDeliveryReceipt(messageID string) (*delivery.DeliveryReceipt, error) {
 	if c.deliveryTracker == nil {
 		return nil, fmt.Errorf("delivery tracking not enabled")
@@ -776,6 +1601,30 @@ func (c *Client) GetDeliveryStats() map[delivery.DeliveryStatus]int {
 	return c.deliveryTracker.GetDeliveryStats()
 }
 
+// GetDeliveryFailureStats returns the number of failed deliveries per failure reason
+func (c *Client) GetDeliveryFailureStats() map[delivery.FailureReason]int {
+	if c.deliveryTracker == nil {
+		return make(map[delivery.FailureReason]int)
+	}
+	return c.deliveryTracker.GetFailureStats()
+}
+
+// GetEncryptionStats returns how tracked outgoing messages were encrypted
+func (c *Client) GetEncryptionStats() *delivery.EncryptionStats {
+	if c.deliveryTracker == nil {
+		return &delivery.EncryptionStats{FallbackReasons: make(map[message.EncryptionFallback]int)}
+	}
+	return c.deliveryTracker.GetEncryptionStats()
+}
+
+// GetFailedDeliveries returns failed deliveries with a specific failure reason
+func (c *Client) GetFailedDeliveries(reason delivery.FailureReason) []*delivery.DeliveryReceipt {
+	if c.deliveryTracker == nil {
+		return nil
+	}
+	return c.deliveryTracker.GetReceiptsByFailureReason(reason)
+}
+
 // RegisterDeliveryCallback registers a callback for delivery status changes
 func (c *Client) RegisterDeliveryCallback(messageID string, callback delivery.DeliveryCallback) error {
 	if c.deliveryTracker == nil {
@@ -785,6 +1634,16 @@ func (c *Client) RegisterDeliveryCallback(messageID string, callback delivery.De
 	return nil
 }
 
+// RegisterDeliveryCallbackContext registers a callback for delivery status changes
+// that receives the context of the operation changing the status
+func (c *Client) RegisterDeliveryCallbackContext(messageID string, callback delivery.ContextDeliveryCallback) error {
+	if c.deliveryTracker == nil {
+		return fmt.Errorf("delivery tracking not enabled")
+	}
+	c.deliveryTracker.RegisterCallbackContext(messageID, callback)
+	return nil
+}
+
 // RegisterGlobalDeliveryCallback registers a callback for all delivery status changes
 func (c *Client) RegisterGlobalDeliveryCallback(callback delivery.DeliveryCallback) error {
 	if c.deliveryTracker == nil {
@@ -794,6 +1653,16 @@ func (c *Client) RegisterGlobalDeliveryCallback(callback delivery.DeliveryCallba
 	return nil
 }
 
+// RegisterGlobalDeliveryCallbackContext registers a callback for all delivery status
+// changes that receives the context of the operation changing the status
+func (c *Client) RegisterGlobalDeliveryCallbackContext(callback delivery.ContextDeliveryCallback) error {
+	if c.deliveryTracker == nil {
+		return fmt.Errorf("delivery tracking not enabled")
+	}
+	c.deliveryTracker.RegisterGlobalCallbackContext(callback)
+	return nil
+}
+
 // GetPendingRetries returns messages that need to be retried
 func (c *Client) GetPendingRetries() []*delivery.DeliveryReceipt {
 	if c.deliveryTracker == nil {
@@ -819,55 +1688,128 @@ func (c *Client) CleanupExpiredReceipts() int {
 
 // CreateAttachmentFromFile creates an attachment from a file
 func (c *Client) CreateAttachmentFromFile(filePath string) (*attachments.Attachment, error) {
-	if c.attachmentManager == nil {
-		return nil, fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
 	}
-	return c.attachmentManager.CreateAttachmentFromFile(filePath)
+	return attachmentManager.CreateAttachmentFromFile(filePath)
 }
 
 // CreateAttachmentFromData creates an attachment from raw data
 func (c *Client) CreateAttachmentFromData(name string, data []byte, mimeType string) (*attachments.Attachment, error) {
-	if c.attachmentManager == nil {
-		return nil, fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return attachmentManager.CreateAttachmentFromData(name, data, mimeType)
+}
+
+// CreateAttachmentFromReader creates an attachment by streaming r into attachment storage
+func (c *Client) CreateAttachmentFromReader(name string, r io.Reader, mimeType string) (*attachments.Attachment, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
 	}
-	return c.attachmentManager.CreateAttachmentFromData(name, data, mimeType)
+	return attachmentManager.CreateAttachmentFromReader(name, r, mimeType)
+}
+
+// OpenAttachment returns a reader over an attachment's data, wherever it is held
+func (c *Client) OpenAttachment(attachment *attachments.Attachment) (io.ReadCloser, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return attachmentManager.OpenAttachment(attachment)
+}
+
+// StreamAttachmentData copies an attachment's data to w, verifying its size and checksum
+func (c *Client) StreamAttachmentData(w io.Writer, attachment *attachments.Attachment) (int64, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return 0, err
+	}
+	return attachmentManager.StreamAttachmentData(w, attachment)
 }
 
 // SaveAttachment saves an attachment to storage
 func (c *Client) SaveAttachment(attachment *attachments.Attachment) error {
-	if c.attachmentManager == nil {
-		return fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return err
 	}
-	return c.attachmentManager.SaveAttachment(attachment)
+	return attachmentManager.SaveAttachment(attachment)
 }
 
 // LoadAttachment loads an attachment from storage
 func (c *Client) LoadAttachment(attachmentID string) (*attachments.Attachment, error) {
-	if c.attachmentManager == nil {
-		return nil, fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
 	}
-	return c.attachmentManager.LoadAttachment(attachmentID)
+	return attachmentManager.LoadAttachment(attachmentID)
 }
 
 // ValidateAttachment validates an attachment's integrity
 func (c *Client) ValidateAttachment(attachment *attachments.Attachment) error {
-	if c.attachmentManager == nil {
-		return fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return err
 	}
-	return c.attachmentManager.ValidateAttachment(attachment)
+	return attachmentManager.ValidateAttachment(attachment)
 }
 
 // GetAttachmentData returns the complete data of an attachment
 func (c *Client) GetAttachmentData(attachment *attachments.Attachment) ([]byte, error) {
-	if c.attachmentManager == nil {
-		return nil, fmt.Errorf("attachment manager not initialized")
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return attachmentManager.GetAttachmentData(attachment)
+}
+
+// DownloadAttachment downloads a URL-referenced attachment, optionally limited to a
+// byte range. Complete downloads are verified against the attachment's checksum.
+func (c *Client) DownloadAttachment(attachment *attachments.Attachment, offset, length int64) ([]byte, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return attachmentManager.DownloadAttachment(attachment, offset, length)
+}
+
+// OpenAttachmentReader returns a seekable reader over a URL-referenced attachment for streaming playback
+func (c *Client) OpenAttachmentReader(attachment *attachments.Attachment) (*attachments.RemoteReader, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
 	}
-	return c.attachmentManager.GetAttachmentData(attachment)
+	return attachmentManager.NewRemoteReader(attachment)
 }
 
-// IsAttachmentManagerEnabled returns true if attachment manager is enabled
+// MaterializeAttachment writes an attachment of a message to a private temporary file,
+// e.g. to open it in another application. The file is removed when the message is
+// deleted with DeleteMessage, after AttachmentConfig.TempFileTTL, or on Close.
+func (c *Client) MaterializeAttachment(messageID string, attachment *attachments.Attachment) (*attachments.TempFile, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return attachmentManager.MaterializeAttachment(messageID, attachment)
+}
+
+// ReleaseAttachmentFiles removes the temporary files materialized for a message
+func (c *Client) ReleaseAttachmentFiles(messageID string) error {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return err
+	}
+	return attachmentManager.ReleaseTempFiles(messageID)
+}
+
+// IsAttachmentManagerEnabled returns true if attachment support is configured.
+// The manager itself is created on first use; call InitAttachments to surface setup errors early.
 func (c *Client) IsAttachmentManagerEnabled() bool {
-	return c.attachmentManager != nil
+	return c.attachmentConfig != nil
 }
 
 // Group management methods
@@ -946,6 +1888,20 @@ func (c *Client) ChangeGroupMemberRole(groupID, memberAddress, requesterAddress
 	return group.ChangeRole(memberAddress, requesterAddress, newRole)
 }
 
+// TransferGroupOwnership makes newOwner an owner of a group and demotes the requesting owner to admin
+func (c *Client) TransferGroupOwnership(groupID, newOwner, requesterAddress string) error {
+	if c.groupManager == nil {
+		return fmt.Errorf("group management not enabled")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group.TransferOwnership(newOwner, requesterAddress)
+}
+
 // GetGroupMembers returns all members of a group
 func (c *Client) GetGroupMembers(groupID string) ([]*groups.GroupMember, error) {
 	if c.groupManager == nil {
@@ -960,6 +1916,20 @@ func (c *Client) GetGroupMembers(groupID string) ([]*groups.GroupMember, error)
 	return group.GetMembers(), nil
 }
 
+// GetGroupMembersPage returns a page of group members matching the filter
+func (c *Client) GetGroupMembersPage(groupID string, offset, limit int, filter *groups.MemberFilter) (*groups.MemberPage, error) {
+	if c.groupManager == nil {
+		return nil, fmt.Errorf("group management not enabled")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group.GetMembersPage(offset, limit, filter)
+}
+
 // GetGroupMember returns a specific member of a group
 func (c *Client) GetGroupMember(groupID, memberAddress string) (*groups.GroupMember, error) {
 	if c.groupManager == nil {
@@ -1007,29 +1977,9 @@ func (c *Client) IsGroupManagementEnabled() bool {
 	return c.groupManager != nil
 }
 
-// SendGroupMessage sends a message to a group
-func (c *Client) SendGroupMessage(groupID, from, body string) error {
-	if c.keyPair == nil {
-		return fmt.Errorf("no key pair configured")
-	}
-
-	// Create group message
-	msg, err := c.ComposeMessage().
-		From(from).
-		To(groupID).
-		GroupID(groupID).
-		Body(body).
-		Build()
-	if err != nil {
-		return fmt.Errorf("failed to build group message: %w", err)
-	}
-
-	return c.SendMessage(msg)
-}
-
 // SendGroupManagementMessage sends a group management system message
 func (c *Client) SendGroupManagementMessage(groupID, action, actor string, data map[string]any) error {
-	if c.keyPair == nil {
+	if c.GetKeyPair() == nil {
 		return fmt.Errorf("no key pair configured")
 	}
 
@@ -1072,6 +2022,16 @@ func (c *Client) SendGroupRoleChangedMessage(groupID, actor, member string, oldR
 	return c.SendGroupManagementMessage(groupID, "role_changed", actor, data)
 }
 
+// SendGroupOwnershipTransferredMessage sends a system message when group ownership is transferred
+func (c *Client) SendGroupOwnershipTransferredMessage(groupID, previousOwner, newOwner string) error {
+	data := map[string]any{
+		"previous_owner": previousOwner,
+		"new_owner":      newOwner,
+		"action":         "ownership_transferred",
+	}
+	return c.SendGroupManagementMessage(groupID, "ownership_transferred", previousOwner, data)
+}
+
 // SendGroupCreatedMessage sends a system message when a group is created
 func (c *Client) SendGroupCreatedMessage(groupID, creator string) error {
 	data := map[string]any{
@@ -1081,7 +2041,9 @@ func (c *Client) SendGroupCreatedMessage(groupID, creator string) error {
 	return c.SendGroupManagementMessage(groupID, "group_created", creator, data)
 }
 
-// CreateGroupWithMessage creates a group and sends a creation message
+// CreateGroupWithMessage creates a group and sends a creation message. With
+// Config.SyncGroups the group is registered with its server first and is not
+// kept locally if that fails.
 func (c *Client) CreateGroupWithMessage(groupID, name, createdBy string, settings *groups.GroupSettings) (*groups.Group, error) {
 	// Create the group
 	group, err := c.CreateGroup(groupID, name, createdBy, settings)
@@ -1089,16 +2051,26 @@ func (c *Client) CreateGroupWithMessage(groupID, name, createdBy string, setting
 		return nil, err
 	}
 
+	if c.groupSync != nil {
+		if err := c.groupSync.PushCreate(groupID); err != nil {
+			c.groupManager.RemoveGroup(groupID)
+			return nil, fmt.Errorf("failed to sync group creation: %w", err)
+		}
+	}
+
 	// Send group creation message
 	err = c.SendGroupCreatedMessage(groupID, createdBy)
 	if err != nil {
-		log.Printf("Warning: failed to send group creation message: %v", err)
+		c.logger.Warn("failed to send group creation message", "group_id", groupID, "error", err)
 	}
 
 	return group, nil
 }
 
-// AddGroupMemberWithMessage adds a member to a group and sends a notification message
+// AddGroupMemberWithMessage adds a member to a group and sends a notification
+// message. With Config.SyncGroups the change is pushed to the group's server
+// first; if that fails the local change is kept and SyncGroup restores the
+// server's state.
 func (c *Client) AddGroupMemberWithMessage(groupID, memberAddress, invitedBy string, role groups.GroupRole) error {
 	// Add the member
 	err := c.AddGroupMember(groupID, memberAddress, invitedBy, role)
@@ -1106,16 +2078,23 @@ func (c *Client) AddGroupMemberWithMessage(groupID, memberAddress, invitedBy str
 		return err
 	}
 
+	op := groups.NewGroupOperation(groups.OpAddMember, groupID, invitedBy)
+	op.Member, op.Role = memberAddress, role
+	if err := c.pushGroupOperation(op); err != nil {
+		return err
+	}
+
 	// Send member added message
 	err = c.SendGroupMemberAddedMessage(groupID, invitedBy, memberAddress, role)
 	if err != nil {
-		log.Printf("Warning: failed to send member added message: %v", err)
+		c.logger.Warn("failed to send member added message", "group_id", groupID, "error", err)
 	}
 
 	return nil
 }
 
-// RemoveGroupMemberWithMessage removes a member from a group and sends a notification message
+// RemoveGroupMemberWithMessage removes a member from a group and sends a
+// notification message, pushing the change first with Config.SyncGroups
 func (c *Client) RemoveGroupMemberWithMessage(groupID, memberAddress, requesterAddress string) error {
 	// Remove the member
 	err := c.RemoveGroupMember(groupID, memberAddress, requesterAddress)
@@ -1123,16 +2102,23 @@ func (c *Client) RemoveGroupMemberWithMessage(groupID, memberAddress, requesterA
 		return err
 	}
 
+	op := groups.NewGroupOperation(groups.OpRemoveMember, groupID, requesterAddress)
+	op.Member = memberAddress
+	if err := c.pushGroupOperation(op); err != nil {
+		return err
+	}
+
 	// Send member removed message
 	err = c.SendGroupMemberRemovedMessage(groupID, requesterAddress, memberAddress)
 	if err != nil {
-		log.Printf("Warning: failed to send member removed message: %v", err)
+		c.logger.Warn("failed to send member removed message", "group_id", groupID, "error", err)
 	}
 
 	return nil
 }
 
-// ChangeGroupMemberRoleWithMessage changes a member's role and sends a notification message
+// ChangeGroupMemberRoleWithMessage changes a member's role and sends a
+// notification message, pushing the change first with Config.SyncGroups
 func (c *Client) ChangeGroupMemberRoleWithMessage(groupID, memberAddress, requesterAddress string, newRole groups.GroupRole) error {
 	// Get current role for the message
 	member, err := c.GetGroupMember(groupID, memberAddress)
@@ -1147,10 +2133,38 @@ func (c *Client) ChangeGroupMemberRoleWithMessage(groupID, memberAddress, reques
 		return err
 	}
 
+	op := groups.NewGroupOperation(groups.OpChangeRole, groupID, requesterAddress)
+	op.Member, op.Role = memberAddress, newRole
+	if err := c.pushGroupOperation(op); err != nil {
+		return err
+	}
+
 	// Send role changed message
 	err = c.SendGroupRoleChangedMessage(groupID, requesterAddress, memberAddress, oldRole, newRole)
 	if err != nil {
-		log.Printf("Warning: failed to send role changed message: %v", err)
+		c.logger.Warn("failed to send role changed message", "group_id", groupID, "error", err)
+	}
+
+	return nil
+}
+
+// TransferGroupOwnershipWithMessage transfers group ownership and sends a
+// notification message, pushing the change first with Config.SyncGroups
+func (c *Client) TransferGroupOwnershipWithMessage(groupID, newOwner, requesterAddress string) error {
+	err := c.TransferGroupOwnership(groupID, newOwner, requesterAddress)
+	if err != nil {
+		return err
+	}
+
+	op := groups.NewGroupOperation(groups.OpTransferOwnership, groupID, requesterAddress)
+	op.Member = newOwner
+	if err := c.pushGroupOperation(op); err != nil {
+		return err
+	}
+
+	err = c.SendGroupOwnershipTransferredMessage(groupID, requesterAddress, newOwner)
+	if err != nil {
+		c.logger.Warn("failed to send ownership transferred message", "group_id", groupID, "error", err)
 	}
 
 	return nil
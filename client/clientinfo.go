@@ -0,0 +1,104 @@
+package client
+
+import (
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// SDK identification advertised in outgoing messages
+const (
+	SDKName    = "emsg-client-sdk"
+	SDKVersion = "1.0"
+)
+
+// GetClientInfo returns the client information advertised in outgoing messages,
+// or nil if advertisement is disabled
+func (c *Client) GetClientInfo() *message.ClientInfo {
+	if !c.advertiseClientInfo {
+		return nil
+	}
+	if c.clientInfo != nil {
+		return c.clientInfo
+	}
+
+	info := &message.ClientInfo{Name: SDKName, Version: SDKVersion}
+	if c.encryptionManager != nil {
+		info.Features = append(info.Features, message.FeatureEncryption)
+		if c.distributeKeyBundles {
+			info.Features = append(info.Features, message.FeatureKeyBundles)
+		}
+	}
+	if c.attachmentConfig != nil {
+		info.Features = append(info.Features, message.FeatureAttachments)
+		if c.attachmentConfig.EnableChunking {
+			info.Features = append(info.Features, message.FeatureChunkedAttachments)
+		}
+	}
+	if c.groupManager != nil {
+		info.Features = append(info.Features, message.FeatureGroups)
+	}
+	for _, contentType := range c.renderContentTypes {
+		if feature, ok := message.ContentTypeFeature(contentType); ok {
+			info.Features = append(info.Features, feature)
+		}
+	}
+	return info
+}
+
+// attachClientInfo adds our client information to a message unless the caller set its own
+func (c *Client) attachClientInfo(msg *message.Message) {
+	if msg.ClientInfo == nil {
+		msg.ClientInfo = c.GetClientInfo()
+	}
+}
+
+// recordPeerClientInfo remembers the client information advertised by message senders
+func (c *Client) recordPeerClientInfo(messages []*message.Message) {
+	c.peerMutex.Lock()
+	defer c.peerMutex.Unlock()
+
+	for _, msg := range messages {
+		if msg.ClientInfo == nil || msg.From == "" {
+			continue
+		}
+		c.peerClientInfo[utils.NormalizeEMSGAddress(msg.From)] = msg.ClientInfo
+	}
+}
+
+// GetPeerClientInfo returns the client information last advertised by an address
+func (c *Client) GetPeerClientInfo(address string) (*message.ClientInfo, bool) {
+	c.peerMutex.RLock()
+	defer c.peerMutex.RUnlock()
+
+	info, ok := c.peerClientInfo[utils.NormalizeEMSGAddress(address)]
+	return info, ok
+}
+
+// PeerSupports reports whether a correspondent's client supports a feature. The second
+// result is false when the correspondent has not advertised its client information,
+// in which case applications should decide their own fallback.
+func (c *Client) PeerSupports(address, feature string) (supported bool, known bool) {
+	info, ok := c.GetPeerClientInfo(address)
+	if !ok {
+		return false, false
+	}
+	return info.Supports(feature), true
+}
+
+// NegotiateContentType returns the first of the preferred content types a
+// correspondent renders, or text/plain if it renders none of them. A
+// correspondent that has not advertised its client information gets the first
+// preferred type, relying on the plain text fallback the builder adds.
+func (c *Client) NegotiateContentType(address string, preferred ...string) string {
+	info, known := c.GetPeerClientInfo(address)
+	for _, contentType := range preferred {
+		feature, ok := message.ContentTypeFeature(contentType)
+		if !ok {
+			continue
+		}
+		if !known || info.Supports(feature) {
+			return contentType
+		}
+	}
+	return message.ContentTypePlain
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+)
+
+// serverClock tracks how far the servers' clocks are from the local clock,
+// measured from the Date header of HTTP responses
+type serverClock struct {
+	mutex    sync.Mutex
+	offset   time.Duration
+	measured bool
+}
+
+// ClockOffset returns how far ahead of the local clock the server's clock was
+// at the last HTTP response, to within a second. It returns false if no
+// response carried a date since the client was created or last resynced.
+func (c *Client) ClockOffset() (time.Duration, bool) {
+	c.serverClock.mutex.Lock()
+	defer c.serverClock.mutex.Unlock()
+	return c.serverClock.offset, c.serverClock.measured
+}
+
+// observeServerClock records the clock offset from a response's Date header,
+// taking the middle of the request as the local time it was sent at
+func (c *Client) observeServerClock(resp *http.Response, start time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	local := start.Round(0).Add(time.Since(start) / 2)
+
+	c.serverClock.mutex.Lock()
+	defer c.serverClock.mutex.Unlock()
+	c.serverClock.offset = date.Sub(local).Round(time.Second)
+	c.serverClock.measured = true
+}
+
+// resetClockOffset forgets the measured offset, which the local clock may have
+// drifted from while the system slept
+func (c *Client) resetClockOffset() {
+	c.serverClock.mutex.Lock()
+	defer c.serverClock.mutex.Unlock()
+	c.serverClock.offset = 0
+	c.serverClock.measured = false
+}
+
+// authHeaderOptions returns the validity of auth headers, or none in the
+// baseline format, and, with clock skew
+// compensation, the measured server clock offset to timestamp them by
+func (c *Client) authHeaderOptions() *auth.HeaderOptions {
+	options := &auth.HeaderOptions{TTL: c.authHeaderTTL, OmitExpiry: c.omitAuthExpiry}
+	if c.compensateClock {
+		options.ClockOffset, _ = c.ClockOffset()
+	}
+	return options
+}
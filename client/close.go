@@ -0,0 +1,48 @@
+package client
+
+import "errors"
+
+// Close stops the client's background work: subsystem supervision, message
+// polling, the outbox sender, the retry worker, wake detection, the
+// connectivity monitor, store maintenance and the WebSocket connection. Pending key store writes are
+// persisted, materialized attachment files removed and loopback addresses
+// detached before it returns. The client must not be used afterwards. With
+// SecureMemory, private keys and the draft key are zeroed as well.
+func (c *Client) Close() error {
+	var errs []error
+
+	if c.supervisor != nil {
+		c.supervisor.Stop()
+	}
+	c.StopMessagePolling()
+	c.StopOutboxSender()
+	c.StopRetryWorker()
+	c.StopWakeDetector()
+	c.StopConnectivityMonitor()
+	c.StopMaintenance()
+	c.loopback.detachAll(c)
+
+	if c.IsWebSocketConnected() {
+		if err := c.DisconnectWebSocket(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.closeKeyWriter(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.attachmentConfig != nil {
+		if attachmentManager, err := c.getAttachmentManager(); err == nil {
+			if err := attachmentManager.CloseTempFiles(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if c.secureMemory {
+		c.wipeKeys()
+	}
+
+	return errors.Join(errs...)
+}
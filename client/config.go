@@ -0,0 +1,321 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// Validate checks the configuration for invalid values and conflicting options.
+// All problems are reported together as ConfigErrors.
+func (config *Config) Validate() error {
+	var errs ConfigErrors
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, &ConfigError{Field: field, Err: fmt.Errorf(format, args...)})
+	}
+
+	if kp := config.KeyPair; kp != nil {
+		if len(kp.PrivateKey) != ed25519.PrivateKeySize {
+			add("KeyPair", "private key must be %d bytes, got %d", ed25519.PrivateKeySize, len(kp.PrivateKey))
+		}
+		if len(kp.PublicKey) != ed25519.PublicKeySize {
+			add("KeyPair", "public key must be %d bytes, got %d", ed25519.PublicKeySize, len(kp.PublicKey))
+		}
+	}
+	if config.KeyRing != nil && config.KeyPair != nil && config.KeyRing.CurrentKeyID() != config.KeyPair.KeyID() {
+		add("KeyPair", "conflicts with the current key of KeyRing; set only one")
+	}
+	if token := config.DelegationToken; token != nil {
+		switch {
+		case config.KeyRing != nil:
+			add("DelegationToken", "cannot be combined with KeyRing")
+		case config.KeyPair == nil:
+			add("DelegationToken", "requires the delegate KeyPair")
+		case token.Delegate != config.KeyPair.PublicKeyBase64():
+			add("DelegationToken", "was issued to a different key than KeyPair")
+		}
+	}
+
+	if config.BeforeSend != nil && config.BeforeSendContext != nil {
+		add("BeforeSend", "conflicts with BeforeSendContext; set only one")
+	}
+	if config.AfterSend != nil && config.AfterSendContext != nil {
+		add("AfterSend", "conflicts with AfterSendContext; set only one")
+	}
+
+	if config.VerifyIncoming < VerifyOff || config.VerifyIncoming > VerifyReject {
+		add("VerifyIncoming", "unknown verification mode %d", config.VerifyIncoming)
+	}
+
+	if config.Timeout < 0 {
+		add("Timeout", "must not be negative")
+	}
+	if config.DNSTTL < 0 {
+		add("DNSTTL", "must not be negative")
+	}
+	if config.DNSConfig != nil {
+		if err := config.DNSConfig.Validate(); err != nil {
+			add("DNSConfig", "%w", err)
+		}
+	}
+	if config.AuthHeaderTTL < 0 {
+		add("AuthHeaderTTL", "must not be negative")
+	}
+	if frames := config.WebSocketFrames; frames != nil {
+		if frames.RequireEncryption && !frames.Encrypt {
+			add("WebSocketFrames.RequireEncryption", "requires Encrypt")
+		}
+		if frames.Encrypt && len(frames.ServerKey) != ed25519.PublicKeySize {
+			add("WebSocketFrames.ServerKey", "must be the server's Ed25519 identity key to encrypt")
+		}
+		if frames.MinCompressSize < 0 {
+			add("WebSocketFrames.MinCompressSize", "must not be negative")
+		}
+	}
+
+	if rs := config.RetryStrategy; rs != nil {
+		if rs.MaxRetries < 0 {
+			add("RetryStrategy.MaxRetries", "must not be negative")
+		}
+		if rs.MaxDelay > 0 && rs.InitialDelay > rs.MaxDelay {
+			add("RetryStrategy", "initial delay %v exceeds max delay %v", rs.InitialDelay, rs.MaxDelay)
+		}
+	}
+
+	if ec := config.EncryptionConfig; ec != nil && ec.Enabled {
+		if ec.KeyPair == nil {
+			add("EncryptionConfig.KeyPair", "required when encryption is enabled")
+		}
+		if ec.KeyStore == nil {
+			add("EncryptionConfig.KeyStore", "required when encryption is enabled")
+		}
+	}
+	if ec := config.EncryptionConfig; ec != nil && ec.WriteBehind != nil {
+		if ec.WriteBehind.FlushInterval < 0 {
+			add("EncryptionConfig.WriteBehind.FlushInterval", "must not be negative")
+		}
+		if ec.WriteBehind.MaxBatchSize < 0 {
+			add("EncryptionConfig.WriteBehind.MaxBatchSize", "must not be negative")
+		}
+		if wb := ec.WriteBehind; wb.MaxRetryDelay > 0 && wb.InitialRetryDelay > wb.MaxRetryDelay {
+			add("EncryptionConfig.WriteBehind", "initial retry delay %v exceeds max retry delay %v", wb.InitialRetryDelay, wb.MaxRetryDelay)
+		}
+	}
+
+	if config.EnableNotifications && config.PollInterval <= 0 {
+		add("PollInterval", "must be positive when notifications are enabled")
+	}
+	if !config.EnableNotifications && (len(config.NotificationHandlers) > 0 || len(config.AsyncHandlers) > 0) {
+		add("NotificationHandlers", "handlers are configured but notifications are disabled")
+	}
+
+	if ac := config.AttachmentConfig; ac != nil {
+		if ac.MaxFileSize <= 0 {
+			add("AttachmentConfig.MaxFileSize", "must be positive")
+		}
+		if ac.EnableChunking && ac.MaxChunkSize <= 0 {
+			add("AttachmentConfig.MaxChunkSize", "must be positive when chunking is enabled")
+		}
+		if ac.MinChunkSize < 0 {
+			add("AttachmentConfig.MinChunkSize", "must not be negative")
+		} else if ac.AdaptiveChunking && ac.MaxChunkSize > 0 && ac.MinChunkSize > ac.MaxChunkSize {
+			add("AttachmentConfig.MinChunkSize", "must not exceed MaxChunkSize")
+		}
+		if ac.TempFileTTL < 0 {
+			add("AttachmentConfig.TempFileTTL", "must not be negative")
+		}
+		if ac.TempDir != "" {
+			if err := checkStorageDir(ac.TempDir); err != nil {
+				add("AttachmentConfig.TempDir", "%w", err)
+			}
+		}
+		if ac.StorageDir != "" {
+			if err := checkStorageDir(ac.StorageDir); err != nil {
+				add("AttachmentConfig.StorageDir", "%w", err)
+			}
+		}
+	}
+
+	for pattern, override := range config.DomainOverrides {
+		field := fmt.Sprintf("DomainOverrides[%q]", pattern)
+		if pattern == "" {
+			add(field, "pattern must not be empty")
+		}
+		if override == nil {
+			add(field, "override must not be nil")
+		} else if override.Timeout < 0 {
+			add(field+".Timeout", "must not be negative")
+		} else if err := checkOverrideHTTPClient(config.HTTPClient, override); err != nil {
+			add(field, "%w", err)
+		}
+	}
+
+	if ts := config.TransportSelection; ts != nil {
+		if ts.CongestionThreshold <= 0 || ts.CongestionThreshold > 1 {
+			add("TransportSelection.CongestionThreshold", "must be in (0, 1], got %v", ts.CongestionThreshold)
+		}
+		if ts.SmoothingFactor <= 0 || ts.SmoothingFactor > 1 {
+			add("TransportSelection.SmoothingFactor", "must be in (0, 1], got %v", ts.SmoothingFactor)
+		}
+		if ts.ErrorRateThreshold < 0 || ts.ErrorRateThreshold > 1 {
+			add("TransportSelection.ErrorRateThreshold", "must be in [0, 1], got %v", ts.ErrorRateThreshold)
+		}
+		if ts.ErrorWindow < 0 {
+			add("TransportSelection.ErrorWindow", "must not be negative")
+		}
+	}
+
+	if config.CapabilityProbeThreshold < 0 {
+		add("CapabilityProbeThreshold", "must not be negative")
+	}
+	if config.CapabilityTTL < 0 {
+		add("CapabilityTTL", "must not be negative")
+	}
+	if config.AutoUploadAttachments && !config.ProbeCapabilities {
+		add("AutoUploadAttachments", "requires ProbeCapabilities")
+	}
+
+	if config.EnableKeyDiscovery && config.KeyDiscoveryTTL <= 0 {
+		add("KeyDiscoveryTTL", "must be positive when key discovery is enabled")
+	}
+	if config.KeyDiscoveryNegativeTTL < 0 {
+		add("KeyDiscoveryNegativeTTL", "must not be negative")
+	}
+	if config.SigningKeyTTL < 0 {
+		add("SigningKeyTTL", "must not be negative")
+	}
+
+	if ci := config.ClientInfo; ci != nil && ci.Name == "" {
+		add("ClientInfo.Name", "must not be empty")
+	}
+	for _, contentType := range config.RenderContentTypes {
+		if _, ok := message.ContentTypeFeature(contentType); !ok {
+			add("RenderContentTypes", "unsupported content type %q", contentType)
+		}
+	}
+
+	if config.MaxUndecryptable < 0 {
+		add("MaxUndecryptable", "must not be negative")
+	}
+	if config.MaxDeliveryProofs < 0 {
+		add("MaxDeliveryProofs", "must not be negative")
+	}
+
+	switch config.MemoryProfile {
+	case MemoryProfileStandard, MemoryProfileLow:
+	default:
+		add("MemoryProfile", "unknown profile %q", config.MemoryProfile)
+	}
+
+	if config.QueueOutgoing && config.Outbox == nil {
+		add("QueueOutgoing", "requires an Outbox store")
+	}
+	for i, identity := range config.Identities {
+		if _, err := utils.ParseEMSGAddress(identity); err != nil {
+			add(fmt.Sprintf("Identities[%d]", i), "%v", err)
+		}
+	}
+	if config.PartialDelivery && config.Outbox == nil {
+		add("PartialDelivery", "requires an Outbox store to retry unresolved recipients")
+	}
+	if config.Outbox != nil && config.OutboxInterval <= 0 {
+		add("OutboxInterval", "must be positive when an outbox is configured")
+	}
+
+	if config.ReceiptStore != nil && !config.EnableDeliveryTracking {
+		add("ReceiptStore", "requires EnableDeliveryTracking")
+	}
+	if config.RetryInterval < 0 {
+		add("RetryInterval", "must not be negative")
+	} else if config.RetryInterval > 0 && !config.EnableDeliveryTracking {
+		add("RetryInterval", "requires EnableDeliveryTracking")
+	}
+	if config.OfflineMode {
+		if config.Outbox == nil {
+			add("OfflineMode", "requires an Outbox store to queue messages while offline")
+		}
+		if config.ConnectivityInterval <= 0 {
+			add("ConnectivityInterval", "must be positive when OfflineMode is enabled")
+		}
+	}
+	if config.WakeCheckInterval < 0 {
+		add("WakeCheckInterval", "must not be negative")
+	}
+	if config.SyncGroups && !config.EnableGroupManagement {
+		add("SyncGroups", "requires EnableGroupManagement")
+	}
+
+	if config.RequestPollInterval < 0 {
+		add("RequestPollInterval", "must not be negative")
+	}
+
+	for name := range config.RestartPolicies {
+		if name != SubsystemWebSocket && name != SubsystemMessagePoller {
+			add("RestartPolicies", "unknown subsystem %q", name)
+		}
+	}
+	for _, name := range []Subsystem{SubsystemWebSocket, SubsystemMessagePoller} {
+		if policy := config.RestartPolicies[name]; policy != nil {
+			if err := policy.validate(); err != nil {
+				add("RestartPolicies["+string(name)+"]", "%v", err)
+			}
+		}
+	}
+
+	if schedule := config.MaintenanceSchedule; schedule != nil {
+		if err := schedule.validate(); err != nil {
+			add("MaintenanceSchedule", "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkOverrideHTTPClient reports overrides that cannot be applied to a custom HTTPDoer
+func checkOverrideHTTPClient(httpClient HTTPDoer, override *DomainOverride) error {
+	if httpClient == nil || (override.Timeout == 0 && override.TLSConfig == nil) {
+		return nil
+	}
+
+	base, ok := httpClient.(*http.Client)
+	if !ok {
+		return fmt.Errorf("timeout and TLS overrides require HTTPClient to be an *http.Client, got %T", httpClient)
+	}
+	if _, ok := base.Transport.(*http.Transport); override.TLSConfig != nil && base.Transport != nil && !ok {
+		return fmt.Errorf("TLS overrides require HTTPClient.Transport to be an *http.Transport, got %T", base.Transport)
+	}
+	return nil
+}
+
+// checkStorageDir reports storage paths that can never be created, without creating anything.
+// The nearest existing ancestor must be a directory.
+func checkStorageDir(dir string) error {
+	path := filepath.Clean(dir)
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", path)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("storage directory unreachable: %w", err)
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,471 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// ConformanceScenario is a group of server behaviours RunConformance checks
+type ConformanceScenario string
+
+const (
+	ConformanceDiscovery    ConformanceScenario = "discovery"    // The domain's DNS record resolves to a server
+	ConformanceRegistration ConformanceScenario = "registration" // New users can register
+	ConformanceSend         ConformanceScenario = "send"         // The server accepts a signed message
+	ConformanceFetch        ConformanceScenario = "fetch"        // The recipient fetches the sent message
+	ConformanceWebSocket    ConformanceScenario = "websocket"    // A message is pushed to the recipient's WebSocket
+	ConformanceGroups       ConformanceScenario = "groups"       // A group message reaches a member
+	ConformanceAttachments  ConformanceScenario = "attachments"  // An attachment arrives intact
+)
+
+// conformanceScenarios lists every scenario in the order they run
+var conformanceScenarios = []ConformanceScenario{
+	ConformanceDiscovery,
+	ConformanceRegistration,
+	ConformanceSend,
+	ConformanceFetch,
+	ConformanceWebSocket,
+	ConformanceGroups,
+	ConformanceAttachments,
+}
+
+// conformanceDependencies lists the scenarios each scenario needs to have passed
+var conformanceDependencies = map[ConformanceScenario][]ConformanceScenario{
+	ConformanceRegistration: {ConformanceDiscovery},
+	ConformanceSend:         {ConformanceRegistration},
+	ConformanceFetch:        {ConformanceSend},
+	ConformanceWebSocket:    {ConformanceRegistration},
+	ConformanceGroups:       {ConformanceRegistration},
+	ConformanceAttachments:  {ConformanceRegistration},
+}
+
+// ConformanceStatus is the outcome of a conformance scenario
+type ConformanceStatus string
+
+const (
+	ConformancePass ConformanceStatus = "pass"
+	ConformanceFail ConformanceStatus = "fail"
+	ConformanceSkip ConformanceStatus = "skip" // A scenario it depends on did not pass
+)
+
+// ConformanceOptions configures a conformance run. Zero fields keep the defaults.
+type ConformanceOptions struct {
+	Config       *Config               // Base configuration of the test users' clients (nil = DefaultConfig); each gets its own key pair
+	Scenarios    []ConformanceScenario // Scenarios to run (nil = all); the scenarios they depend on run as well
+	UserPrefix   string                // Prefix of the test users' names (default "conformance")
+	Timeout      time.Duration         // Time limit of each scenario (default 30s)
+	PollInterval time.Duration         // How often the recipient's mailbox is fetched while waiting for a message (default 1s)
+}
+
+// ConformanceResult is the outcome of one scenario
+type ConformanceResult struct {
+	Scenario ConformanceScenario `json:"scenario"`
+	Status   ConformanceStatus   `json:"status"`
+	Duration time.Duration       `json:"duration"`
+	Detail   string              `json:"detail,omitempty"` // What was checked, e.g. the message ID
+	Error    string              `json:"error,omitempty"`  // Why the scenario failed or was skipped
+}
+
+// ConformanceReport is the outcome of a conformance run against a domain's server
+type ConformanceReport struct {
+	Domain    string               `json:"domain"`
+	ServerURL string               `json:"server_url,omitempty"`
+	StartedAt time.Time            `json:"started_at"`
+	Duration  time.Duration        `json:"duration"`
+	Results   []*ConformanceResult `json:"results"`
+}
+
+// Passed returns true if no scenario failed or was skipped
+func (r *ConformanceReport) Passed() bool {
+	for _, result := range r.Results {
+		if result.Status != ConformancePass {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts returns how many scenarios passed, failed and were skipped
+func (r *ConformanceReport) Counts() (passed, failed, skipped int) {
+	for _, result := range r.Results {
+		switch result.Status {
+		case ConformancePass:
+			passed++
+		case ConformanceFail:
+			failed++
+		case ConformanceSkip:
+			skipped++
+		}
+	}
+	return passed, failed, skipped
+}
+
+// Result returns the result of a scenario, or nil if it was not run
+func (r *ConformanceReport) Result(scenario ConformanceScenario) *ConformanceResult {
+	for _, result := range r.Results {
+		if result.Scenario == scenario {
+			return result
+		}
+	}
+	return nil
+}
+
+// conformanceRun holds the state scenarios share during a run
+type conformanceRun struct {
+	domain  string
+	opts    ConformanceOptions
+	runID   string
+	report  *ConformanceReport
+	status  map[ConformanceScenario]ConformanceStatus
+	sender  *Client
+	rcpt    *Client
+	from    string
+	to      string
+	sentID  string // Message sent by the send scenario, fetched by the fetch scenario
+	subject string
+}
+
+// RunConformance checks that a domain's server implements the EMSG behaviours the
+// SDK relies on: it registers two fresh test users on the domain and exercises
+// registration, sending, fetching, WebSocket push, group messages and attachments
+// between them. Scenario failures are recorded in the report rather than returned;
+// the error is only for invalid options or clients that cannot be created. Test
+// users and their messages are left on the server.
+func RunConformance(ctx context.Context, domain string, opts *ConformanceOptions) (*ConformanceReport, error) {
+	run, err := newConformanceRun(domain, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer run.sender.Close()
+	defer run.rcpt.Close()
+
+	selected := conformanceSelection(run.opts.Scenarios)
+	for _, scenario := range conformanceScenarios {
+		if selected[scenario] {
+			run.runScenario(ctx, scenario)
+		}
+	}
+
+	run.report.Duration = time.Since(run.report.StartedAt)
+	return run.report, nil
+}
+
+// newConformanceRun validates the options and creates the test users' clients
+func newConformanceRun(domain string, opts *ConformanceOptions) (*conformanceRun, error) {
+	if domain == "" {
+		return nil, fmt.Errorf("domain cannot be empty")
+	}
+
+	run := &conformanceRun{domain: domain}
+	if opts != nil {
+		run.opts = *opts
+	}
+	for _, scenario := range run.opts.Scenarios {
+		if !slices.Contains(conformanceScenarios, scenario) {
+			return nil, fmt.Errorf("unknown conformance scenario %q", scenario)
+		}
+	}
+	if run.opts.UserPrefix == "" {
+		run.opts.UserPrefix = "conformance"
+	}
+	if run.opts.Timeout <= 0 {
+		run.opts.Timeout = 30 * time.Second
+	}
+	if run.opts.PollInterval <= 0 {
+		run.opts.PollInterval = time.Second
+	}
+
+	run.runID = fmt.Sprintf("%x", time.Now().UnixNano())
+	run.from = fmt.Sprintf("%s_%s_sender#%s", run.opts.UserPrefix, run.runID, domain)
+	run.to = fmt.Sprintf("%s_%s_recipient#%s", run.opts.UserPrefix, run.runID, domain)
+	run.report = &ConformanceReport{Domain: domain, StartedAt: time.Now()}
+	run.status = make(map[ConformanceScenario]ConformanceStatus)
+
+	var err error
+	if run.sender, err = run.newClient(); err != nil {
+		return nil, fmt.Errorf("failed to create sender client: %w", err)
+	}
+	if run.rcpt, err = run.newClient(); err != nil {
+		run.sender.Close()
+		return nil, fmt.Errorf("failed to create recipient client: %w", err)
+	}
+	return run, nil
+}
+
+// newClient creates a test user's client with a fresh key pair
+func (r *conformanceRun) newClient() (*Client, error) {
+	config := DefaultConfig()
+	if r.opts.Config != nil {
+		base := *r.opts.Config
+		config = &base
+	}
+
+	keyPair, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	config.KeyPair = keyPair
+	return New(config)
+}
+
+// conformanceSelection returns the scenarios to run: those selected and everything
+// they depend on
+func conformanceSelection(scenarios []ConformanceScenario) map[ConformanceScenario]bool {
+	if len(scenarios) == 0 {
+		scenarios = conformanceScenarios
+	}
+
+	selected := make(map[ConformanceScenario]bool)
+	var add func(ConformanceScenario)
+	add = func(scenario ConformanceScenario) {
+		if selected[scenario] {
+			return
+		}
+		selected[scenario] = true
+		for _, dependency := range conformanceDependencies[scenario] {
+			add(dependency)
+		}
+	}
+	for _, scenario := range scenarios {
+		add(scenario)
+	}
+	return selected
+}
+
+// runScenario runs a scenario within its time limit and records the outcome,
+// skipping it if a scenario it depends on did not pass
+func (r *conformanceRun) runScenario(ctx context.Context, scenario ConformanceScenario) {
+	result := &ConformanceResult{Scenario: scenario}
+	r.report.Results = append(r.report.Results, result)
+
+	for _, dependency := range conformanceDependencies[scenario] {
+		if r.status[dependency] != ConformancePass {
+			result.Status = ConformanceSkip
+			result.Error = fmt.Sprintf("requires %s", dependency)
+			r.status[scenario] = result.Status
+			return
+		}
+	}
+
+	scenarioCtx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := r.scenarioFunc(scenario)(scenarioCtx)
+	result.Duration = time.Since(start)
+	result.Detail = detail
+	if err != nil {
+		result.Status = ConformanceFail
+		result.Error = err.Error()
+	} else {
+		result.Status = ConformancePass
+	}
+	r.status[scenario] = result.Status
+}
+
+// scenarioFunc returns the check of a scenario, which returns what it verified
+func (r *conformanceRun) scenarioFunc(scenario ConformanceScenario) func(context.Context) (string, error) {
+	switch scenario {
+	case ConformanceDiscovery:
+		return r.checkDiscovery
+	case ConformanceRegistration:
+		return r.checkRegistration
+	case ConformanceSend:
+		return r.checkSend
+	case ConformanceFetch:
+		return r.checkFetch
+	case ConformanceWebSocket:
+		return r.checkWebSocket
+	case ConformanceGroups:
+		return r.checkGroups
+	default:
+		return r.checkAttachments
+	}
+}
+
+// checkDiscovery resolves the domain to its server
+func (r *conformanceRun) checkDiscovery(ctx context.Context) (string, error) {
+	serverInfo, err := r.sender.ResolveDomainContext(ctx, r.domain)
+	if err != nil {
+		return "", err
+	}
+	r.report.ServerURL = serverInfo.URL
+	return serverInfo.URL, nil
+}
+
+// checkRegistration registers the sender and recipient
+func (r *conformanceRun) checkRegistration(ctx context.Context) (string, error) {
+	if err := r.sender.RegisterUserContext(ctx, r.from); err != nil {
+		return "", fmt.Errorf("failed to register %s: %w", r.from, err)
+	}
+	if err := r.rcpt.RegisterUserContext(ctx, r.to); err != nil {
+		return "", fmt.Errorf("failed to register %s: %w", r.to, err)
+	}
+	return fmt.Sprintf("registered %s and %s", r.from, r.to), nil
+}
+
+// checkSend sends a message from the sender to the recipient
+func (r *conformanceRun) checkSend(ctx context.Context) (string, error) {
+	r.subject = fmt.Sprintf("Conformance %s", r.runID)
+	msg, err := r.sender.ComposeMessage().
+		From(r.from).
+		To(r.to).
+		Subject(r.subject).
+		Body("EMSG conformance test message").
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build message: %w", err)
+	}
+	if err := r.sender.SendMessageContext(ctx, msg); err != nil {
+		return "", err
+	}
+	r.sentID = msg.MessageID
+	return "message " + msg.MessageID, nil
+}
+
+// checkFetch waits for the sent message to appear in the recipient's mailbox
+func (r *conformanceRun) checkFetch(ctx context.Context) (string, error) {
+	msg, err := r.awaitMessage(ctx, func(m *message.Message) bool { return m.MessageID == r.sentID })
+	if err != nil {
+		return "", err
+	}
+	if msg.Subject != r.subject {
+		return "", fmt.Errorf("message %s arrived with subject %q, want %q", msg.MessageID, msg.Subject, r.subject)
+	}
+	return "message " + msg.MessageID, nil
+}
+
+// checkWebSocket connects the recipient's WebSocket and waits for a message sent
+// to it to be pushed
+func (r *conformanceRun) checkWebSocket(ctx context.Context) (string, error) {
+	if err := r.rcpt.ConnectWebSocketContext(ctx, r.to); err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer r.rcpt.DisconnectWebSocket()
+
+	pushed := make(chan string, 16)
+	err := r.rcpt.RegisterWebSocketEventHandler(websocket.EventMessage, func(data interface{}) {
+		if msg, ok := data.(*message.Message); ok && msg != nil {
+			select {
+			case pushed <- msg.MessageID:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := r.sender.ComposeMessage().
+		From(r.from).
+		To(r.to).
+		Subject(fmt.Sprintf("Conformance WebSocket %s", r.runID)).
+		Body("EMSG conformance WebSocket push").
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build message: %w", err)
+	}
+	if err := r.sender.SendMessageContext(ctx, msg); err != nil {
+		return "", fmt.Errorf("failed to send: %w", err)
+	}
+
+	for {
+		select {
+		case id := <-pushed:
+			if id == msg.MessageID {
+				return "message " + id, nil
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("message %s was not pushed: %w", msg.MessageID, ctx.Err())
+		}
+	}
+}
+
+// checkGroups sends a message to a group of the sender and recipient and waits
+// for the recipient to receive it
+func (r *conformanceRun) checkGroups(ctx context.Context) (string, error) {
+	groupID := fmt.Sprintf("%s_%s_group#%s", r.opts.UserPrefix, r.runID, r.domain)
+	if _, err := r.sender.CreateGroup(groupID, "Conformance", r.from, nil); err != nil {
+		return "", fmt.Errorf("failed to create group: %w", err)
+	}
+	defer r.sender.DeleteGroup(groupID, r.from)
+	if err := r.sender.AddGroupMember(groupID, r.to, r.from, groups.RoleMember); err != nil {
+		return "", fmt.Errorf("failed to add member: %w", err)
+	}
+
+	if _, err := r.sender.SendGroupMessageContext(ctx, groupID, r.from, "EMSG conformance group message"); err != nil {
+		return "", fmt.Errorf("failed to send: %w", err)
+	}
+
+	msg, err := r.awaitMessage(ctx, func(m *message.Message) bool { return m.GroupID == groupID })
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("message %s in %s", msg.MessageID, groupID), nil
+}
+
+// checkAttachments sends a message with an inline attachment and checks that the
+// recipient receives it with a matching checksum
+func (r *conformanceRun) checkAttachments(ctx context.Context) (string, error) {
+	data := []byte("EMSG conformance attachment " + r.runID)
+	msg, err := r.sender.ComposeMessage().
+		From(r.from).
+		To(r.to).
+		Subject(fmt.Sprintf("Conformance attachment %s", r.runID)).
+		Body("EMSG conformance attachment").
+		AttachData("conformance.txt", data, "text/plain").
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build message: %w", err)
+	}
+	if err := r.sender.SendMessageContext(ctx, msg); err != nil {
+		return "", fmt.Errorf("failed to send: %w", err)
+	}
+
+	received, err := r.awaitMessage(ctx, func(m *message.Message) bool { return m.MessageID == msg.MessageID })
+	if err != nil {
+		return "", err
+	}
+	if len(received.Attachments) != 1 {
+		return "", fmt.Errorf("message %s arrived with %d attachments, want 1", received.MessageID, len(received.Attachments))
+	}
+	if err := received.Attachments[0].Verify(); err != nil {
+		return "", fmt.Errorf("attachment arrived corrupted: %w", err)
+	}
+	return fmt.Sprintf("%d byte attachment on message %s", len(data), received.MessageID), nil
+}
+
+// awaitMessage fetches the recipient's mailbox until a message matches or ctx is done
+func (r *conformanceRun) awaitMessage(ctx context.Context, match func(*message.Message) bool) (*message.Message, error) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		messages, err := r.rcpt.GetMessagesContext(ctx, r.to)
+		if err == nil {
+			for _, msg := range messages {
+				if match(msg) {
+					return msg, nil
+				}
+			}
+		}
+		lastErr = err
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if lastErr != nil {
+				return nil, fmt.Errorf("message not received: %w", lastErr)
+			}
+			return nil, fmt.Errorf("message not received: %w", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// ConversationExportVersion is the format version written by ExportConversation
+const ConversationExportVersion = 1
+
+// ConversationExport is a conversation written by ExportConversation: its
+// messages as received, signatures intact, and their local annotations
+type ConversationExport struct {
+	Version     int                          `json:"version"`
+	Address     string                       `json:"address"`
+	Peer        string                       `json:"peer"`
+	ExportedAt  time.Time                    `json:"exported_at"`
+	Messages    []*message.Message           `json:"messages"`
+	Annotations map[string]*store.Annotation `json:"annotations,omitempty"` // Keyed by message ID
+}
+
+// ExportConversation writes the stored direct messages between address and
+// peer to w as JSON, with their annotations if the store keeps any
+func (c *Client) ExportConversation(address, peer string, w io.Writer) error {
+	messages, err := c.GetConversation(address, peer)
+	if err != nil {
+		return err
+	}
+
+	export := &ConversationExport{
+		Version:    ConversationExportVersion,
+		Address:    address,
+		Peer:       peer,
+		ExportedAt: time.Now().UTC(),
+		Messages:   messages,
+	}
+	if annotations, ok := c.messageStore.(store.AnnotationStore); ok {
+		for _, msg := range messages {
+			annotation, err := annotations.GetAnnotation(msg.MessageID)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read annotation of %s: %w", msg.MessageID, err)
+			}
+			if export.Annotations == nil {
+				export.Annotations = make(map[string]*store.Annotation)
+			}
+			export.Annotations[msg.MessageID] = annotation
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return fmt.Errorf("failed to write conversation export: %w", err)
+	}
+	return nil
+}
+
+// ImportConversation reads a conversation written by ExportConversation into
+// the local message store. Messages already stored are skipped, as with
+// ImportMessages. An annotation is restored unless the message has a more
+// recently updated one; annotations are skipped if the store keeps none.
+func (c *Client) ImportConversation(ctx context.Context, r io.Reader) (*store.ImportResult, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	var export ConversationExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("failed to read conversation export: %w", err)
+	}
+	if export.Version != ConversationExportVersion {
+		return nil, fmt.Errorf("unsupported conversation export version %d", export.Version)
+	}
+
+	result, err := store.Import(ctx, c.messageStore, store.SliceSource(export.Messages), nil)
+	if err != nil {
+		return result, err
+	}
+
+	annotations, ok := c.messageStore.(store.AnnotationStore)
+	if !ok {
+		return result, nil
+	}
+	c.annotationMutex.Lock()
+	defer c.annotationMutex.Unlock()
+	for messageID, annotation := range export.Annotations {
+		if annotation == nil || annotation.IsEmpty() {
+			continue
+		}
+		if _, err := c.messageStore.Get(messageID); err != nil {
+			continue
+		}
+		existing, err := annotations.GetAnnotation(messageID)
+		if err == nil && existing.UpdatedAt.After(annotation.UpdatedAt) {
+			continue
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return result, fmt.Errorf("failed to read annotation of %s: %w", messageID, err)
+		}
+		annotation.Tags = normalizeTags(annotation.Tags)
+		if err := annotations.PutAnnotation(messageID, annotation); err != nil {
+			return result, fmt.Errorf("failed to restore annotation of %s: %w", messageID, err)
+		}
+	}
+	return result, nil
+}
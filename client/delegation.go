@@ -0,0 +1,102 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// ErrDelegationDenied is returned when a delegate client sends outside its token's scope
+var ErrDelegationDenied = errors.New("not permitted by delegation token")
+
+// NewDelegated creates a client that sends on an account's behalf with a delegate
+// key and the encoded delegation token the account issued to it
+func NewDelegated(delegateKey *keymgmt.KeyPair, encodedToken string) (*Client, error) {
+	token, err := auth.ParseDelegationToken(encodedToken)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultConfig()
+	config.KeyPair = delegateKey
+	config.DelegationToken = token
+	return New(config)
+}
+
+// IssueDelegationToken mints a token signed by the client's account key that lets
+// the holder of delegatePublicKey send within scope. Hand the delegate the result
+// of the token's Encode.
+func (c *Client) IssueDelegationToken(delegatePublicKey string, scope *auth.DelegationScope) (*auth.DelegationToken, error) {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	if c.GetDelegationToken() != nil {
+		return nil, fmt.Errorf("a delegate client cannot issue delegation tokens")
+	}
+	return auth.IssueDelegationToken(keyPair, delegatePublicKey, scope)
+}
+
+// LoadDelegationToken makes the client send as a delegate using an encoded token
+// issued to its key pair. Requests and messages are then signed with the key
+// pair and carry the token.
+func (c *Client) LoadDelegationToken(encodedToken string) error {
+	token, err := auth.ParseDelegationToken(encodedToken)
+	if err != nil {
+		return err
+	}
+	return c.SetDelegationToken(token)
+}
+
+// SetDelegationToken makes the client send as a delegate with a token issued to
+// its key pair, or as the account itself when token is nil
+func (c *Client) SetDelegationToken(token *auth.DelegationToken) error {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	if token != nil {
+		if c.keyPair == nil {
+			return fmt.Errorf("no key pair configured")
+		}
+		if token.Delegate != c.keyPair.PublicKeyBase64() {
+			return fmt.Errorf("delegation token was issued to a different key")
+		}
+	}
+	c.delegation = token
+	if c.webSocketClient != nil {
+		c.webSocketClient.SetDelegationToken(token)
+	}
+	return nil
+}
+
+// GetDelegationToken returns the token the client sends under, or nil if it sends as the account itself
+func (c *Client) GetDelegationToken() *auth.DelegationToken {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
+	return c.delegation
+}
+
+// applyDelegation checks an outgoing message against the delegation token and
+// attaches the token so recipients can verify the delegate's signature
+func (c *Client) applyDelegation(msg *message.Message) error {
+	token := c.GetDelegationToken()
+	if token == nil {
+		msg.Delegation = ""
+		return nil
+	}
+	if token.IsExpired() {
+		return fmt.Errorf("%w: token expired", ErrDelegationDenied)
+	}
+	if err := token.Permits(msg.From, msg.GetRecipients()); err != nil {
+		return fmt.Errorf("%w: %v", ErrDelegationDenied, err)
+	}
+
+	encoded, err := token.Encode()
+	if err != nil {
+		return err
+	}
+	msg.Delegation = encoded
+	return nil
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/emsg-protocol/emsg-client-sdk/discovery"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// DiscoverContacts finds which local identifiers (emails, phone numbers)
+// belong to registered EMSG users on the given domain. Only salted hashes of
+// the identifiers are sent to the server. Matches for addresses on other
+// domains are skipped and logged: a server only speaks for its own users.
+func (c *Client) DiscoverContacts(domain string, identifiers []string, opts *discovery.Options) ([]*discovery.Contact, error) {
+	if c.GetKeyPair() == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	if opts == nil {
+		opts = discovery.DefaultOptions()
+	}
+
+	serverInfo, err := c.resolver.ResolveDomain(domain)
+	if err != nil {
+		return nil, &ResolveError{Domain: domain, Err: err}
+	}
+
+	hashes := discovery.HashIdentifiers(identifiers, opts.Salt)
+	if len(hashes) == 0 {
+		return []*discovery.Contact{}, nil
+	}
+
+	var candidates []string
+	switch opts.Mode {
+	case discovery.ModeBloomFilter:
+		filter, err := c.fetchDiscoveryFilter(domain, serverInfo.URL)
+		if err != nil {
+			return nil, err
+		}
+		candidates = filter.Candidates(hashes)
+	case discovery.ModeDirect, "":
+		candidates = make([]string, 0, len(hashes))
+		for hash := range hashes {
+			candidates = append(candidates, hash)
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s", opts.Mode)
+	}
+
+	// Sort so that batches are deterministic
+	sort.Strings(candidates)
+
+	batchSize := opts.MaxPerQuery
+	if batchSize <= 0 {
+		batchSize = len(candidates)
+	}
+
+	contacts := make([]*discovery.Contact, 0)
+	endpoint := fmt.Sprintf("%s/api/v1/discovery", serverInfo.URL)
+
+	for start := 0; start < len(candidates); start += batchSize {
+		end := start + batchSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		matches, err := c.queryDiscovery(domain, endpoint, candidates[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			identifier, ok := hashes[match.Hash]
+			if !ok {
+				continue // Ignore matches for hashes we did not ask about
+			}
+			if !addressOnDomain(match.Address, domain) {
+				c.logger.Warn("ignoring discovery match for an address on another domain", "domain", domain, "address", match.Address)
+				continue
+			}
+
+			contact := &discovery.Contact{
+				Identifier: identifier,
+				Hash:       match.Hash,
+				Address:    match.Address,
+				KeyBundle:  match.KeyBundle,
+			}
+			contacts = append(contacts, contact)
+
+			if opts.PinKeys && match.KeyBundle != nil && c.encryptionManager != nil {
+				if utils.NormalizeEMSGAddress(match.KeyBundle.Address) != utils.NormalizeEMSGAddress(match.Address) {
+					c.logger.Warn("ignoring key bundle with mismatched address", "address", match.Address, "bundle_address", match.KeyBundle.Address)
+					continue
+				}
+				if _, err := c.encryptionManager.PinKeyBundle(match.KeyBundle); err != nil {
+					c.logger.Warn("failed to pin key bundle", "address", match.Address, "error", err)
+				}
+			}
+		}
+	}
+
+	return contacts, nil
+}
+
+// fetchDiscoveryFilter downloads the server's Bloom filter of registered identifier hashes
+func (c *Client) fetchDiscoveryFilter(domain, serverURL string) (*discovery.BloomFilter, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/discovery/filter", serverURL)
+	resp, err := c.sendHTTPRequestWithResponse(context.Background(), domain, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery filter: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Base64 of the largest filter accepted, plus room for the other fields
+	const maxFilterResponse = discovery.MaxBloomFilterBits/8*4/3 + 1024
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFilterResponse+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discovery filter: %w", err)
+	}
+	if len(body) > maxFilterResponse {
+		return nil, fmt.Errorf("discovery filter exceeds %d bytes", maxFilterResponse)
+	}
+
+	var filter discovery.BloomFilter
+	if err := json.Unmarshal(body, &filter); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery filter: %w", err)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid discovery filter: %w", err)
+	}
+
+	return &filter, nil
+}
+
+// queryDiscovery asks the server which of the given hashes are registered
+func (c *Client) queryDiscovery(domain, endpoint string, hashes []string) ([]*discovery.Match, error) {
+	payload, err := json.Marshal(&discovery.Request{Hashes: hashes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize discovery request: %w", err)
+	}
+
+	resp, err := c.sendHTTPRequestWithResponse(context.Background(), domain, "POST", endpoint, payload)
+	if err != nil {
+		return nil, fmt.Errorf("discovery request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discovery response: %w", err)
+	}
+
+	var response discovery.Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery response: %w", err)
+	}
+
+	return response.Matches, nil
+}
+
+// addressOnDomain reports whether address is a valid EMSG address on domain
+func addressOnDomain(address, domain string) bool {
+	addr, err := utils.ParseEMSGAddress(address)
+	return err == nil && strings.EqualFold(addr.Domain, domain)
+}
@@ -0,0 +1,383 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// draftKeyInfo derived the draft key from the signing key before draft keys
+// were stored; stores holding drafts from then keep that key
+const draftKeyInfo = "emsg drafts v1"
+
+// draftWrapInfo derives the key wrapping the stored draft key from the signing key
+const draftWrapInfo = "emsg draft key wrap v1"
+
+// wrappedDraftKeyName is the draft store entry holding the wrapped draft key.
+// Drafts are stored under hex hashes, so it cannot collide with one.
+const wrappedDraftKeyName = "draft-key"
+
+// Draft is an unsent message saved for a conversation
+type Draft struct {
+	Conversation string    `json:"conversation"` // Recipient address, group ID or any other conversation identifier
+	From         string    `json:"from,omitempty"`
+	To           []string  `json:"to,omitempty"`
+	CC           []string  `json:"cc,omitempty"`
+	Subject      string    `json:"subject,omitempty"`
+	Body         string    `json:"body,omitempty"`
+	GroupID      string    `json:"group_id,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// draftBox seals drafts with a symmetric key before they reach the draft store
+type draftBox struct {
+	store store.DraftStore
+	key   *[32]byte // Set from Config.DraftKey or unwrapped from the store on first use
+	fixed bool      // The key was set from Config.DraftKey and is not stored
+	mutex sync.Mutex
+}
+
+// SaveDraft encrypts and stores a draft, replacing any earlier draft for its conversation
+func (c *Client) SaveDraft(draft *Draft) error {
+	if c.drafts == nil {
+		return fmt.Errorf("drafts not enabled")
+	}
+	if draft == nil || draft.Conversation == "" {
+		return fmt.Errorf("draft conversation is required")
+	}
+
+	saved := *draft
+	saved.UpdatedAt = time.Now()
+	plaintext, err := json.Marshal(&saved)
+	if err != nil {
+		return fmt.Errorf("failed to encode draft: %w", err)
+	}
+	defer utils.Wipe(plaintext)
+
+	sealed, err := c.sealDraft(plaintext)
+	if err != nil {
+		return err
+	}
+	if err := c.drafts.store.Put(draftStoreKey(draft.Conversation), sealed); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	draft.UpdatedAt = saved.UpdatedAt
+	return nil
+}
+
+// LoadDraft returns the draft saved for a conversation
+func (c *Client) LoadDraft(conversation string) (*Draft, error) {
+	if c.drafts == nil {
+		return nil, fmt.Errorf("drafts not enabled")
+	}
+
+	sealed, err := c.drafts.store.Get(draftStoreKey(conversation))
+	if err != nil {
+		return nil, err
+	}
+	return c.openDraft(sealed)
+}
+
+// DeleteDraft removes the draft saved for a conversation, e.g. once it is sent
+func (c *Client) DeleteDraft(conversation string) error {
+	if c.drafts == nil {
+		return fmt.Errorf("drafts not enabled")
+	}
+	return c.drafts.store.Delete(draftStoreKey(conversation))
+}
+
+// ListDrafts returns all saved drafts. Drafts that cannot be decrypted, e.g.
+// because they were saved under a different key, are skipped, as are builder
+// drafts saved through BuilderDraftStore.
+func (c *Client) ListDrafts() ([]*Draft, error) {
+	if c.drafts == nil {
+		return nil, fmt.Errorf("drafts not enabled")
+	}
+
+	keys, err := c.drafts.store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drafts: %w", err)
+	}
+
+	drafts := make([]*Draft, 0, len(keys))
+	for _, key := range keys {
+		if key == wrappedDraftKeyName {
+			continue
+		}
+		sealed, err := c.drafts.store.Get(key)
+		if errors.Is(err, store.ErrDraftNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		draft, err := c.openDraft(sealed)
+		if err != nil {
+			c.logger.Warn("skipping unreadable draft", "key", key, "error", err)
+			continue
+		}
+		if draft.Conversation == "" {
+			continue
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, nil
+}
+
+// ComposeDraft creates a message builder prefilled from a draft
+func (c *Client) ComposeDraft(draft *Draft) *message.MessageBuilder {
+	builder := c.ComposeMessage().
+		From(draft.From).
+		To(draft.To...).
+		CC(draft.CC...).
+		Subject(draft.Subject).
+		Body(draft.Body)
+	if draft.GroupID != "" {
+		builder.GroupID(draft.GroupID)
+	}
+	return builder
+}
+
+// BuilderDraftStore returns a store for MessageBuilder.SaveDraft and LoadDraft
+// that encrypts builder drafts with the draft key before they reach the
+// configured DraftStore, beside the conversation drafts of SaveDraft
+func (c *Client) BuilderDraftStore() (message.DraftStore, error) {
+	if c.drafts == nil {
+		return nil, fmt.Errorf("drafts not enabled")
+	}
+	return &sealedBuilderDrafts{client: c}, nil
+}
+
+// sealedBuilderDrafts seals builder drafts like conversation drafts, under keys
+// hashed apart from conversation identifiers
+type sealedBuilderDrafts struct {
+	client *Client
+}
+
+// Put encrypts and stores a serialized builder draft
+func (s *sealedBuilderDrafts) Put(key string, data []byte) error {
+	sealed, err := s.client.sealDraft(data)
+	if err != nil {
+		return err
+	}
+	return s.client.drafts.store.Put(draftStoreKey("builder:"+key), sealed)
+}
+
+// Get retrieves and decrypts a serialized builder draft
+func (s *sealedBuilderDrafts) Get(key string) ([]byte, error) {
+	sealed, err := s.client.drafts.store.Get(draftStoreKey("builder:" + key))
+	if err != nil {
+		return nil, err
+	}
+	return s.client.openSealedDraft(sealed)
+}
+
+// sealDraft encrypts a serialized draft with the draft key
+func (c *Client) sealDraft(plaintext []byte) ([]byte, error) {
+	key, err := c.draftKey()
+	if err != nil {
+		return nil, err
+	}
+	return sealWithKey(plaintext, key)
+}
+
+// openSealedDraft decrypts a draft sealed with sealDraft
+func (c *Client) openSealedDraft(sealed []byte) ([]byte, error) {
+	key, err := c.draftKey()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openWithKey(sealed, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt draft: %w", err)
+	}
+	return plaintext, nil
+}
+
+// sealWithKey encrypts plaintext with a symmetric key under a fresh nonce
+func sealWithKey(plaintext []byte, key *[32]byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return secretbox.Seal(nonce[:], plaintext, &nonce, key), nil
+}
+
+// openWithKey decrypts what sealWithKey sealed
+func openWithKey(sealed []byte, key *[32]byte) ([]byte, error) {
+	if len(sealed) < 24+secretbox.Overhead {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	plaintext, ok := secretbox.Open(nil, sealed[24:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+// openDraft decrypts a sealed conversation draft
+func (c *Client) openDraft(sealed []byte) (*Draft, error) {
+	plaintext, err := c.openSealedDraft(sealed)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Wipe(plaintext)
+
+	var draft Draft
+	if err := json.Unmarshal(plaintext, &draft); err != nil {
+		return nil, fmt.Errorf("failed to decode draft: %w", err)
+	}
+	return &draft, nil
+}
+
+// draftKey returns the draft encryption key, unwrapping it from the draft
+// store with the signing key when none was configured
+func (c *Client) draftKey() (*[32]byte, error) {
+	c.drafts.mutex.Lock()
+	defer c.drafts.mutex.Unlock()
+
+	if c.drafts.key != nil {
+		return c.drafts.key, nil
+	}
+
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no draft key or key pair configured")
+	}
+	key, err := c.drafts.loadKey(keyPair, c.entropy)
+	if err != nil {
+		return nil, err
+	}
+	c.drafts.key = key
+	return key, nil
+}
+
+// rewrapDraftKey stores the draft key wrapped for a new signing key, so drafts
+// stay readable after rotating to it. It is called before the new key is made
+// current.
+func (c *Client) rewrapDraftKey(newKeyPair *keymgmt.KeyPair) error {
+	if c.drafts == nil || c.drafts.fixed {
+		return nil
+	}
+	key, err := c.draftKey()
+	if err != nil {
+		// Drafts already unreadable under the old key need not hold up rotation
+		c.logger.Warn("not carrying drafts over to the new key", "error", err)
+		return nil
+	}
+
+	c.drafts.mutex.Lock()
+	defer c.drafts.mutex.Unlock()
+	return c.drafts.storeKey(key, newKeyPair)
+}
+
+// loadKey unwraps the stored draft key with the signing key, creating a random
+// one on first use. A store already holding drafts from before draft keys were
+// stored keeps the key derived from the signing key they were sealed with.
+func (d *draftBox) loadKey(keyPair *keymgmt.KeyPair, entropy io.Reader) (*[32]byte, error) {
+	wrapped, err := d.store.Get(wrappedDraftKeyName)
+	if err == nil {
+		wrapKey, err := deriveDraftKey(keyPair, draftWrapInfo)
+		if err != nil {
+			return nil, err
+		}
+		defer utils.Wipe(wrapKey[:])
+
+		plaintext, err := openWithKey(wrapped, wrapKey)
+		if err != nil || len(plaintext) != 32 {
+			return nil, fmt.Errorf("failed to unwrap draft key: not wrapped for this signing key")
+		}
+		defer utils.Wipe(plaintext)
+		key := new([32]byte)
+		copy(key[:], plaintext)
+		return key, nil
+	}
+	if !errors.Is(err, store.ErrDraftNotFound) {
+		return nil, fmt.Errorf("failed to load draft key: %w", err)
+	}
+
+	existing, err := d.store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drafts: %w", err)
+	}
+	var key *[32]byte
+	if len(existing) > 0 {
+		key, err = deriveDraftKey(keyPair, draftKeyInfo)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		key = new([32]byte)
+		if _, err := io.ReadFull(utils.Entropy(entropy), key[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate draft key: %w", err)
+		}
+	}
+	if err := d.storeKey(key, keyPair); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// storeKey wraps the draft key with a key derived from the signing key and
+// stores it beside the drafts
+func (d *draftBox) storeKey(key *[32]byte, keyPair *keymgmt.KeyPair) error {
+	wrapKey, err := deriveDraftKey(keyPair, draftWrapInfo)
+	if err != nil {
+		return err
+	}
+	defer utils.Wipe(wrapKey[:])
+
+	wrapped, err := sealWithKey(key[:], wrapKey)
+	if err != nil {
+		return err
+	}
+	if err := d.store.Put(wrappedDraftKeyName, wrapped); err != nil {
+		return fmt.Errorf("failed to store draft key: %w", err)
+	}
+	return nil
+}
+
+// deriveDraftKey derives a symmetric key from the signing key seed
+func deriveDraftKey(keyPair *keymgmt.KeyPair, info string) (*[32]byte, error) {
+	seed := keyPair.PrivateKey.Seed()
+	defer utils.Wipe(seed)
+
+	key := new([32]byte)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, nil, []byte(info)), key[:]); err != nil {
+		return nil, fmt.Errorf("failed to derive draft key: %w", err)
+	}
+	return key, nil
+}
+
+// wipeKey zeroes and forgets the draft key
+func (d *draftBox) wipeKey() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.key != nil {
+		utils.Wipe(d.key[:])
+		d.key = nil
+	}
+}
+
+// draftStoreKey hides conversation identifiers from the draft store
+func draftStoreKey(conversation string) string {
+	sum := sha256.Sum256([]byte(conversation))
+	return hex.EncodeToString(sum[:])
+}
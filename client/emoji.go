@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// emojiAssets caches the images of group custom emoji with their data, keyed by
+// group ID and shortcode
+type emojiAssets struct {
+	assets map[string]*attachments.Attachment
+	mutex  sync.RWMutex
+}
+
+// emojiAssetKey returns the cache key of a group's shortcode
+func emojiAssetKey(groupID, shortcode string) string {
+	return groupID + "\x00" + shortcode
+}
+
+// AddGroupEmoji adds a custom emoji or sticker to a group, replacing any with the
+// same shortcode. The asset must be a small image; inline images are cached right away.
+func (c *Client) AddGroupEmoji(groupID, requesterAddress, shortcode string, asset *attachments.Attachment, sticker bool) (*groups.CustomEmoji, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	emoji, err := group.AddCustomEmoji(shortcode, asset, sticker, requesterAddress)
+	if err != nil {
+		return nil, err
+	}
+	if len(asset.Data) > 0 {
+		c.cacheEmojiAsset(groupID, shortcode, asset)
+	}
+	return emoji, nil
+}
+
+// RemoveGroupEmoji removes a custom emoji or sticker from a group
+func (c *Client) RemoveGroupEmoji(groupID, requesterAddress, shortcode string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	if err := group.RemoveCustomEmoji(shortcode, requesterAddress); err != nil {
+		return err
+	}
+
+	c.emojiAssets.mutex.Lock()
+	delete(c.emojiAssets.assets, emojiAssetKey(groupID, shortcode))
+	c.emojiAssets.mutex.Unlock()
+	return nil
+}
+
+// ListGroupEmoji returns a group's custom emoji and stickers ordered by shortcode
+func (c *Client) ListGroupEmoji(groupID string) ([]*groups.CustomEmoji, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return group.ListCustomEmoji(), nil
+}
+
+// ResolveGroupEmoji returns the image of a group's custom emoji or sticker with its
+// data. Images are cached in memory and, with attachment storage configured, on
+// disk; an image referenced by URL is downloaded the first time it is resolved and
+// verified against its checksum.
+func (c *Client) ResolveGroupEmoji(groupID, shortcode string) (*attachments.Attachment, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	emoji, exists := group.GetCustomEmoji(shortcode)
+	if !exists {
+		return nil, fmt.Errorf("group %s has no custom emoji %s", groupID, shortcode)
+	}
+
+	if cached := c.cachedEmojiAsset(groupID, emoji); cached != nil {
+		return cached, nil
+	}
+
+	asset, err := c.loadEmojiAsset(emoji)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load custom emoji %s: %w", shortcode, err)
+	}
+	c.cacheEmojiAsset(groupID, shortcode, asset)
+	return asset, nil
+}
+
+// ResolveMessageEmoji resolves the group sticker and :shortcode: references of a
+// received group message to their images, keyed by shortcode. Shortcodes the group
+// does not define are left out, since they may be ordinary text. Images that could
+// not be loaded are reported in the error alongside those that could.
+func (c *Client) ResolveMessageEmoji(msg *message.Message) (map[string]*attachments.Attachment, error) {
+	resolved := make(map[string]*attachments.Attachment)
+	if msg.GroupID == "" {
+		return resolved, nil
+	}
+	group, err := c.getManagedGroup(msg.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, shortcode := range msg.Shortcodes() {
+		if _, exists := group.GetCustomEmoji(shortcode); !exists {
+			continue
+		}
+		asset, err := c.ResolveGroupEmoji(msg.GroupID, shortcode)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resolved[shortcode] = asset
+	}
+	return resolved, errors.Join(errs...)
+}
+
+// SendGroupSticker sends one of a group's stickers from a member to every other
+// member, like SendGroupMessageContext. Recipients resolve the sticker from the
+// group's registry with ResolveMessageEmoji.
+func (c *Client) SendGroupSticker(ctx context.Context, groupID, from, shortcode string) (*GroupSendResult, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if emoji, exists := group.GetCustomEmoji(shortcode); !exists || !emoji.Sticker {
+		return nil, fmt.Errorf("group %s has no sticker %s", groupID, shortcode)
+	}
+
+	return c.sendGroupMessage(ctx, groupID, from, func(mb *message.MessageBuilder) *message.MessageBuilder {
+		return mb.Sticker(shortcode)
+	})
+}
+
+// cachedEmojiAsset returns the cached image of an emoji, or nil if it is not cached
+// or the group has since changed the image
+func (c *Client) cachedEmojiAsset(groupID string, emoji *groups.CustomEmoji) *attachments.Attachment {
+	c.emojiAssets.mutex.RLock()
+	defer c.emojiAssets.mutex.RUnlock()
+
+	cached, exists := c.emojiAssets.assets[emojiAssetKey(groupID, emoji.Shortcode)]
+	if !exists || cached.Checksum != emoji.Asset.Checksum {
+		return nil
+	}
+	return cached
+}
+
+// cacheEmojiAsset keeps an emoji image with its data in memory
+func (c *Client) cacheEmojiAsset(groupID, shortcode string, asset *attachments.Attachment) {
+	c.emojiAssets.mutex.Lock()
+	defer c.emojiAssets.mutex.Unlock()
+	c.emojiAssets.assets[emojiAssetKey(groupID, shortcode)] = asset
+}
+
+// loadEmojiAsset returns an emoji's image with verified data, from the registry,
+// attachment storage or its URL. Downloaded images are saved to attachment storage.
+func (c *Client) loadEmojiAsset(emoji *groups.CustomEmoji) (*attachments.Attachment, error) {
+	asset := *emoji.Asset
+	if len(asset.Data) > 0 {
+		if err := asset.Verify(); err != nil {
+			return nil, err
+		}
+		return &asset, nil
+	}
+
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	if stored, err := attachmentManager.LoadAttachment(asset.ID); err == nil && stored.Checksum == asset.Checksum && len(stored.Data) > 0 {
+		return stored, nil
+	}
+
+	data, err := attachmentManager.DownloadAttachment(&asset, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	asset.Data = data
+	if err := attachmentManager.SaveAttachment(&asset); err != nil {
+		c.logger.Debug("custom emoji not saved to attachment storage", "attachment_id", asset.ID, "error", err)
+	}
+	return &asset, nil
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// Sentinel errors for use with errors.Is. Errors returned by the client wrap
+// these so callers never need to match on error strings.
+var (
+	// ErrRateLimited matches responses the server throttled; see RetryAfter for the requested wait
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnauthorized matches requests the server rejected as unauthenticated or forbidden
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrEncryptionUnavailable is returned by encryption operations when encryption is not enabled
+	ErrEncryptionUnavailable = errors.New("encryption not enabled")
+	// ErrDomainResolution matches failures to resolve a recipient domain's server
+	ErrDomainResolution = dns.ErrDomainResolution
+	// ErrValidation matches invalid messages, addresses and configuration
+	ErrValidation = message.ErrValidation
+	// ErrFeatureUnsupported is returned when an operation needs a feature the server does not advertise
+	ErrFeatureUnsupported = errors.New("feature not supported by server")
+)
+
+// RetryAfter returns the wait requested by the server for a throttled or locked
+// request. The boolean is false if err carries no such delay.
+func RetryAfter(err error) (time.Duration, bool) {
+	var delayed interface{ RetryAfterDelay() time.Duration }
+	if errors.As(err, &delayed) {
+		if delay := delayed.RetryAfterDelay(); delay > 0 {
+			return delay, true
+		}
+	}
+	return 0, false
+}
+
+// HTTPError is returned when a server responds with a non-2xx status
+type HTTPError struct {
+	StatusCode  int
+	Body        string
+	RetryAfter  time.Duration // Wait requested by the server's Retry-After header, if any
+	ServerError *ServerError  // Error the server reported in the body, nil if the body held none
+}
+
+// newHTTPError creates an HTTPError from a failed response and its body
+func newHTTPError(resp *http.Response, body []byte) *HTTPError {
+	return &HTTPError{
+		StatusCode:  resp.StatusCode,
+		Body:        string(body),
+		RetryAfter:  parseRetryAfter(resp.Header.Get("Retry-After")),
+		ServerError: parseServerError(body),
+	}
+}
+
+// Error implements the error interface, preferring the server's error to the raw body
+func (e *HTTPError) Error() string {
+	if e.ServerError != nil {
+		return fmt.Sprintf("HTTP request failed with status %d: %v", e.StatusCode, e.ServerError)
+	}
+	return fmt.Sprintf("HTTP request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the server's error so errors.As finds the *ServerError
+func (e *HTTPError) Unwrap() error {
+	if e.ServerError == nil {
+		return nil
+	}
+	return e.ServerError
+}
+
+// HTTPStatusCode returns the HTTP status code of the failed response
+func (e *HTTPError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// RetryAfterDelay returns the wait requested by the server, or zero if none was given
+func (e *HTTPError) RetryAfterDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// Is maps status codes onto ErrRateLimited and ErrUnauthorized
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// ResolveError is returned when a recipient domain cannot be resolved
+type ResolveError struct {
+	Domain string
+	Err    error
+}
+
+// Error implements the error interface
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("failed to resolve domain %s: %v", e.Domain, e.Err)
+}
+
+// Unwrap returns the underlying resolution error
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// DNSFailure marks the error as a DNS resolution failure for delivery classification
+func (e *ResolveError) DNSFailure() bool {
+	return true
+}
+
+// Is reports whether target is ErrDomainResolution
+func (e *ResolveError) Is(target error) bool {
+	return target == ErrDomainResolution
+}
+
+// ConfigError describes a single invalid client configuration setting
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config %s: %v", e.Field, e.Err)
+}
+
+// Unwrap returns the underlying validation error
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrValidation
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ConfigErrors aggregates every problem found while validating a client configuration
+type ConfigErrors []*ConfigError
+
+// Error implements the error interface
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d configuration errors: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the individual configuration errors for errors.Is and errors.As
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// invalidAddress wraps an address parsing failure as a validation error
+func invalidAddress(field string, err error) error {
+	return &message.ValidationError{Field: field, Err: fmt.Errorf("invalid address: %w", err)}
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// dropExpired filters out received messages whose expiry has passed
+func (c *Client) dropExpired(messages []*message.Message) []*message.Message {
+	now := time.Now()
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.IsExpired(now) {
+			c.logger.Debug("dropping expired message", "message_id", msg.MessageID, "expired_at", msg.ExpiryTime())
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	return kept
+}
+
+// PurgeExpiredMessages deletes self-destructed messages from the local store and
+// releases their attachment files, returning how many were deleted. Maintain
+// runs it before compacting the stores.
+func (c *Client) PurgeExpiredMessages() (int, error) {
+	if c.messageStore == nil {
+		return 0, fmt.Errorf("message store not configured")
+	}
+
+	purged, err := store.PurgeExpired(c.messageStore, time.Now())
+	for _, messageID := range purged {
+		if releaseErr := c.releaseAttachmentFiles(messageID); releaseErr != nil {
+			c.logger.Warn("failed to release attachment files of expired message", "message_id", messageID, "error", releaseErr)
+		}
+	}
+	return len(purged), err
+}
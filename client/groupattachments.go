@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// SetGroupAttachmentLimits sets the largest attachment, in bytes, and the MIME
+// types members may send to a group. Zero and an empty list remove the limits.
+func (c *Client) SetGroupAttachmentLimits(groupID, requesterAddress string, maxSize int64, allowedTypes []string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.SetAttachmentLimits(maxSize, allowedTypes, requesterAddress)
+}
+
+// SendGroupAttachment sends a message with attachments from a member to every
+// other member of a group
+func (c *Client) SendGroupAttachment(groupID, from, body string, atts ...*attachments.Attachment) error {
+	_, err := c.SendGroupAttachmentContext(context.Background(), groupID, from, body, atts...)
+	return err
+}
+
+// SendGroupAttachmentContext is SendGroupMessageContext for a message with
+// attachments. On top of the send permission the sender needs
+// groups.PermissionSendAttachment, and every attachment must be within the
+// group's size and type limits; otherwise a *groups.AttachmentBlockedError is
+// returned, nothing is sent and the group's moderators and the sender are told
+// with an "attachment_blocked" system message.
+func (c *Client) SendGroupAttachmentContext(ctx context.Context, groupID, from, body string, atts ...*attachments.Attachment) (*GroupSendResult, error) {
+	if c.groupManager != nil {
+		if group, err := c.groupManager.GetGroup(groupID); err == nil {
+			if err := group.CheckAttachments(from, atts); err != nil {
+				c.announceBlockedAttachment(group, "", err)
+				return nil, err
+			}
+		}
+	}
+
+	return c.sendGroupMessage(ctx, groupID, from, func(mb *message.MessageBuilder) *message.MessageBuilder {
+		mb = mb.Body(body)
+		for _, att := range atts {
+			mb = mb.Attachment(att)
+		}
+		return mb
+	})
+}
+
+// checkGroupAttachments applies a locally managed group's attachment policy to a
+// message sent to the group, announcing blocked attachments
+func (c *Client) checkGroupAttachments(group *groups.Group, msg *message.Message) error {
+	if err := group.CheckAttachments(msg.From, msg.Attachments); err != nil {
+		c.announceBlockedAttachment(group, msg.MessageID, err)
+		return err
+	}
+	return nil
+}
+
+// announceBlockedAttachment tells the group's moderators and the sender that
+// group policy blocked an attachment
+func (c *Client) announceBlockedAttachment(group *groups.Group, messageID string, err error) {
+	var blocked *groups.AttachmentBlockedError
+	if !errors.As(err, &blocked) {
+		return
+	}
+	c.logger.Info("attachment blocked by group policy", "group_id", blocked.GroupID, "member", blocked.Member, "reason", blocked.Reason)
+
+	recipients := group.Moderators()
+	if !slices.Contains(recipients, blocked.Member) {
+		recipients = append(recipients, blocked.Member)
+	}
+	data := map[string]any{
+		"author":     blocked.Member,
+		"attachment": blocked.Attachment,
+		"reason":     string(blocked.Reason),
+		"action":     "attachment_blocked",
+	}
+	if messageID != "" {
+		data["message_id"] = messageID
+	}
+	// The sender gets the error right away rather than after the notice is delivered
+	go c.sendModerationNotice(blocked.GroupID, "attachment_blocked", blocked.Member, recipients, data)
+}
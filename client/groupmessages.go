@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// GroupSendResult reports how a group message fanned out to the group's members
+type GroupSendResult struct {
+	GroupID    string
+	Held       bool              // A guest's message awaits moderator approval; nothing was sent yet
+	MessageIDs map[string]string // ID of the copy sent to each member (or to the group address), for delivery tracking
+	Failed     map[string]error  // Recipients whose copy could not be sent
+}
+
+// Delivered returns the number of recipients a copy was sent to
+func (r *GroupSendResult) Delivered() int {
+	return len(r.MessageIDs)
+}
+
+// GroupHistoryOptions selects the messages returned by GetGroupHistory
+type GroupHistoryOptions struct {
+	Address       string    // Mailbox to fetch new messages for first; also checked for history permission ("" = local store only)
+	Sender        string    // Only messages from this address ("" = any sender)
+	Since         time.Time // Only messages sent at or after this time (zero = no lower bound)
+	Until         time.Time // Only messages sent before this time (zero = no upper bound)
+	Limit         int       // Return only the most recent messages (0 = all)
+	IncludeSystem bool      // Include group management system messages
+}
+
+// groupFanoutKey is the context key marking sends that are copies of one group message
+type groupFanoutKey struct{}
+
+// inGroupFanout reports whether ctx belongs to a group fan-out
+func inGroupFanout(ctx context.Context) bool {
+	fanout, _ := ctx.Value(groupFanoutKey{}).(bool)
+	return fanout
+}
+
+// SendGroupMessage sends a text message from a member to every other member of a group
+func (c *Client) SendGroupMessage(groupID, from, body string) error {
+	_, err := c.SendGroupMessageContext(context.Background(), groupID, from, body)
+	return err
+}
+
+// SendGroupMessageContext sends a text message from a member to every other member of
+// a group within ctx. Each member gets its own copy, so delivery of every copy is
+// tracked and encrypted separately. The sender needs the send permission, or must be
+// a guest in a group that allows guest messages; a guest's message to a group that
+// moderates guests is held once for approval instead. Copies bypass the outbox.
+// Groups not managed locally are sent a single message for their server to expand.
+func (c *Client) SendGroupMessageContext(ctx context.Context, groupID, from, body string) (*GroupSendResult, error) {
+	return c.sendGroupMessage(ctx, groupID, from, func(mb *message.MessageBuilder) *message.MessageBuilder {
+		return mb.Body(body)
+	})
+}
+
+// sendGroupMessage fans a message out to a group's members, with content setting
+// what each copy carries
+func (c *Client) sendGroupMessage(ctx context.Context, groupID, from string, content func(*message.MessageBuilder) *message.MessageBuilder) (*GroupSendResult, error) {
+	if c.GetKeyPair() == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	result := &GroupSendResult{
+		GroupID:    groupID,
+		MessageIDs: make(map[string]string),
+		Failed:     make(map[string]error),
+	}
+
+	var group *groups.Group
+	if c.groupManager != nil {
+		group, _ = c.groupManager.GetGroup(groupID)
+	}
+	if group == nil {
+		msg, err := content(c.ComposeMessage().From(from).To(groupID).GroupID(groupID)).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build group message: %w", err)
+		}
+		if err := c.SendMessageContext(ctx, msg); err != nil {
+			result.Failed[groupID] = err
+			return result, err
+		}
+		result.MessageIDs[groupID] = msg.MessageID
+		return result, nil
+	}
+
+	if err := group.CheckCanSend(from); err != nil {
+		return nil, err
+	}
+
+	recipients := groupRecipients(group, from)
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("group %s has no other members to send to", groupID)
+	}
+
+	if group.RequiresModeration(from) {
+		msg, err := content(c.ComposeMessage().From(from).To(recipients...).GroupID(groupID)).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build group message: %w", err)
+		}
+		held, err := c.holdForModeration(msg)
+		if err != nil {
+			return nil, err
+		}
+		result.Held = held
+		return result, nil
+	}
+	if !group.CanSendMessage(from) {
+		return nil, fmt.Errorf("%s is not allowed to send messages to group %s", from, groupID)
+	}
+	if err := group.CheckSlowMode(from); err != nil {
+		return nil, err
+	}
+
+	fanoutCtx := context.WithValue(ctx, groupFanoutKey{}, true)
+	for _, recipient := range recipients {
+		if err := ctx.Err(); err != nil {
+			result.Failed[recipient] = err
+			continue
+		}
+
+		msg, err := content(c.ComposeMessage().From(from).To(recipient).GroupID(groupID)).Build()
+		if err == nil {
+			err = c.sendMessage(fanoutCtx, msg, true)
+		}
+		if err != nil {
+			c.logger.Warn("failed to send group message", "group_id", groupID, "recipient", recipient, "error", err)
+			result.Failed[recipient] = err
+			continue
+		}
+		result.MessageIDs[recipient] = msg.MessageID
+	}
+
+	if result.Delivered() > 0 {
+		group.RecordMessageSent(from)
+	}
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("failed to send group message to %d of %d members", len(result.Failed), len(recipients))
+	}
+	return result, nil
+}
+
+// GetGroupHistory returns a group's messages in conversation order, oldest first
+func (c *Client) GetGroupHistory(groupID string, opts *GroupHistoryOptions) ([]*message.Message, error) {
+	return c.GetGroupHistoryContext(context.Background(), groupID, opts)
+}
+
+// GetGroupHistoryContext returns a group's messages in conversation order, oldest
+// first. With opts.Address set, new messages for that mailbox are fetched within ctx
+// before the history is read. History comes from the local message store when one is
+// configured, otherwise only the fetched messages are returned.
+func (c *Client) GetGroupHistoryContext(ctx context.Context, groupID string, opts *GroupHistoryOptions) ([]*message.Message, error) {
+	if opts == nil {
+		opts = &GroupHistoryOptions{}
+	}
+	if opts.Address == "" && c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	// A locally managed group decides whether the member may read its history
+	if opts.Address != "" && c.groupManager != nil {
+		if group, err := c.groupManager.GetGroup(groupID); err == nil && !group.HasPermission(opts.Address, groups.PermissionViewHistory) {
+			return nil, fmt.Errorf("%s is not allowed to view the history of group %s", opts.Address, groupID)
+		}
+	}
+
+	var fetched []*message.Message
+	if opts.Address != "" {
+		messages, err := c.GetMessagesContext(ctx, opts.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch messages: %w", err)
+		}
+		fetched = messages
+	}
+
+	filter := groupHistoryFilter(groupID, opts)
+	var history []*message.Message
+	if c.messageStore != nil {
+		messages, err := store.LoadOrdered(c.messageStore, filter)
+		if err != nil {
+			return nil, err
+		}
+		history = messages
+	} else {
+		for _, msg := range fetched {
+			if filter(msg) {
+				history = append(history, msg)
+			}
+		}
+		message.SortMessages(history)
+	}
+
+	if opts.Limit > 0 && len(history) > opts.Limit {
+		history = history[len(history)-opts.Limit:]
+	}
+	return history, nil
+}
+
+// groupHistoryFilter matches the messages of a group selected by opts
+func groupHistoryFilter(groupID string, opts *GroupHistoryOptions) func(*message.Message) bool {
+	sender := utils.NormalizeEMSGAddress(opts.Sender)
+	return func(msg *message.Message) bool {
+		if msg.GroupID != groupID {
+			return false
+		}
+		if msg.IsSystemMessage() && !opts.IncludeSystem {
+			return false
+		}
+		if sender != "" && utils.NormalizeEMSGAddress(msg.From) != sender {
+			return false
+		}
+		sent := time.Unix(msg.Timestamp, 0)
+		if !opts.Since.IsZero() && sent.Before(opts.Since) {
+			return false
+		}
+		return opts.Until.IsZero() || sent.Before(opts.Until)
+	}
+}
+
+// groupRecipients returns the addresses of the group's members other than the sender,
+// ordered by address. Banned members are left out.
+func groupRecipients(group *groups.Group, sender string) []string {
+	self := utils.NormalizeEMSGAddress(sender)
+
+	var recipients []string
+	for _, member := range group.GetMembers() {
+		if member.Status == "banned" || group.IsBanned(member.Address) {
+			continue
+		}
+		if utils.NormalizeEMSGAddress(member.Address) == self {
+			continue
+		}
+		recipients = append(recipients, member.Address)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
@@ -0,0 +1,30 @@
+package client
+
+import "github.com/emsg-protocol/emsg-client-sdk/groups"
+
+// DefineGroupRole adds or replaces a custom role in a group
+func (c *Client) DefineGroupRole(groupID, requesterAddress string, def *groups.RoleDefinition) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.DefineRole(def, requesterAddress)
+}
+
+// RemoveGroupRole deletes a custom role that no member of the group holds
+func (c *Client) RemoveGroupRole(groupID, requesterAddress string, name groups.GroupRole) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.RemoveRole(name, requesterAddress)
+}
+
+// GetGroupRoles returns the custom roles defined in a group
+func (c *Client) GetGroupRoles(groupID string) ([]*groups.RoleDefinition, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return group.GetRoles(), nil
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+)
+
+// maxGroupSyncConflicts is how many times a change is replayed on fresh server
+// state before giving up
+const maxGroupSyncConflicts = 3
+
+// ErrGroupConflict matches group changes the server rejected because the group
+// changed since it was last synced and the change could not be replayed
+var ErrGroupConflict = errors.New("group changed on server")
+
+// GroupSyncClient keeps groups in the client's GroupManager in step with the
+// copy held by each group's server under /api/v1/groups. Changes are sent with
+// the version they were based on; when the server reports a conflict the group is
+// pulled, the change is replayed on the server's state and sent again.
+type GroupSyncClient struct {
+	client  *Client
+	manager *groups.GroupManager
+}
+
+// groupMemberRequest is the body of member add and role change requests
+type groupMemberRequest struct {
+	Address   string           `json:"address,omitempty"`
+	Role      groups.GroupRole `json:"role"`
+	InvitedBy string           `json:"invited_by,omitempty"`
+}
+
+// groupModerationRequest is the body of ban and mute requests
+type groupModerationRequest struct {
+	Address string `json:"address,omitempty"`
+	Actor   string `json:"actor"`
+	Reason  string `json:"reason,omitempty"`
+	Until   int64  `json:"until,omitempty"`
+}
+
+// NewGroupSyncClient creates a group sync client for the client's groups. It
+// requires group management to be enabled.
+func (c *Client) NewGroupSyncClient() (*GroupSyncClient, error) {
+	if c.groupManager == nil {
+		return nil, fmt.Errorf("group management not enabled")
+	}
+	return &GroupSyncClient{client: c, manager: c.groupManager}, nil
+}
+
+// GetGroupSyncClient returns the sync client used by the group methods, or nil
+// if Config.SyncGroups is off
+func (c *Client) GetGroupSyncClient() *GroupSyncClient {
+	return c.groupSync
+}
+
+// SyncGroup pulls a group's state from its server. See GroupSyncClient.PullContext.
+func (c *Client) SyncGroup(groupID string) (*groups.Group, error) {
+	return c.SyncGroupContext(context.Background(), groupID)
+}
+
+// SyncGroupContext pulls a group's state from its server into the local group manager
+func (c *Client) SyncGroupContext(ctx context.Context, groupID string) (*groups.Group, error) {
+	gs := c.groupSync
+	if gs == nil {
+		var err error
+		if gs, err = c.NewGroupSyncClient(); err != nil {
+			return nil, err
+		}
+	}
+	return gs.PullContext(ctx, groupID)
+}
+
+// Pull fetches a group's state from its server. See PullContext.
+func (gs *GroupSyncClient) Pull(groupID string) (*groups.Group, error) {
+	return gs.PullContext(context.Background(), groupID)
+}
+
+// PullContext fetches a group's state from its server and applies it to the
+// local copy, adding the group if it is not known locally. A group the server
+// no longer has is removed locally.
+func (gs *GroupSyncClient) PullContext(ctx context.Context, groupID string) (*groups.Group, error) {
+	domain, endpoint, err := gs.groupEndpoint(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := gs.client.sendHTTPRequestWithResponse(ctx, domain, "GET", endpoint, nil)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			gs.manager.RemoveGroup(groupID)
+			return nil, fmt.Errorf("group %s not found on server", groupID)
+		}
+		return nil, fmt.Errorf("failed to fetch group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return gs.importResponse(groupID, resp)
+}
+
+// PushCreate registers a locally created group with its server. See PushCreateContext.
+func (gs *GroupSyncClient) PushCreate(groupID string) error {
+	return gs.PushCreateContext(context.Background(), groupID)
+}
+
+// PushCreateContext registers a locally created group with its server and records
+// the version the server assigns
+func (gs *GroupSyncClient) PushCreateContext(ctx context.Context, groupID string) error {
+	group, err := gs.manager.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+	domain, err := groups.GroupDomain(groupID)
+	if err != nil {
+		return err
+	}
+	serverInfo, err := gs.client.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return &ResolveError{Domain: domain, Err: err}
+	}
+
+	payload, err := group.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize group: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/groups", serverInfo.URL)
+	resp, err := gs.client.sendHTTPRequestWithResponse(ctx, domain, "POST", endpoint, payload)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: group %s already exists", ErrGroupConflict, groupID)
+		}
+		return fmt.Errorf("failed to create group on server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	_, err = gs.importResponse(groupID, resp)
+	return err
+}
+
+// Push sends a member change already applied locally to the group's server. See PushContext.
+func (gs *GroupSyncClient) Push(op *groups.GroupOperation) error {
+	return gs.PushContext(context.Background(), op)
+}
+
+// PushContext sends a member change already applied to the local group to the
+// group's server. If the server's copy changed in the meantime, the group is
+// pulled and the change is replayed on it; a change that no longer applies, e.g.
+// because the actor lost the permission, fails with ErrGroupConflict and leaves
+// the local group matching the server.
+func (gs *GroupSyncClient) PushContext(ctx context.Context, op *groups.GroupOperation) error {
+	for attempt := 0; ; attempt++ {
+		group, err := gs.manager.GetGroup(op.GroupID)
+		if err != nil {
+			return err
+		}
+
+		err = gs.pushOperation(ctx, op, group.GetVersion())
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+			return err
+		}
+		if attempt >= maxGroupSyncConflicts {
+			return fmt.Errorf("%w: gave up after %d conflicts", ErrGroupConflict, attempt+1)
+		}
+
+		gs.client.logger.Info("group changed on server, replaying change", "group_id", op.GroupID, "operation", op.Type)
+		group, err = gs.PullContext(ctx, op.GroupID)
+		if err != nil {
+			return err
+		}
+		if err := group.Apply(op); err != nil {
+			return fmt.Errorf("%w: %v", ErrGroupConflict, err)
+		}
+	}
+}
+
+// pushOperation sends one change based on the given group version
+func (gs *GroupSyncClient) pushOperation(ctx context.Context, op *groups.GroupOperation, version int64) error {
+	domain, endpoint, err := gs.groupEndpoint(ctx, op.GroupID)
+	if err != nil {
+		return err
+	}
+
+	var method string
+	var body any
+	switch op.Type {
+	case groups.OpAddMember:
+		method, endpoint = "POST", endpoint+"/members"
+		body = &groupMemberRequest{Address: op.Member, Role: op.Role, InvitedBy: op.Actor}
+	case groups.OpRemoveMember:
+		method, endpoint = "DELETE", endpoint+"/members/"+url.PathEscape(op.Member)
+	case groups.OpChangeRole:
+		method, endpoint = "PUT", endpoint+"/members/"+url.PathEscape(op.Member)
+		body = &groupMemberRequest{Role: op.Role}
+	case groups.OpTransferOwnership:
+		method, endpoint = "POST", endpoint+"/owner"
+		body = &groupMemberRequest{Address: op.Member, Role: groups.RoleOwner}
+	case groups.OpBanMember:
+		method, endpoint = "POST", endpoint+"/bans"
+		body = &groupModerationRequest{Address: op.Member, Actor: op.Actor, Reason: op.Reason}
+	case groups.OpUnbanMember:
+		method, endpoint = "DELETE", endpoint+"/bans/"+url.PathEscape(op.Member)
+	case groups.OpMuteMember:
+		method, endpoint = "PUT", endpoint+"/members/"+url.PathEscape(op.Member)+"/mute"
+		body = &groupModerationRequest{Actor: op.Actor, Until: op.Until}
+	case groups.OpUnmuteMember:
+		method, endpoint = "DELETE", endpoint+"/members/"+url.PathEscape(op.Member)+"/mute"
+	default:
+		return fmt.Errorf("unsupported group operation %s", op.Type)
+	}
+	endpoint += "?base_version=" + strconv.FormatInt(version, 10)
+
+	var payload []byte
+	if body != nil {
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to serialize group change: %w", err)
+		}
+	}
+
+	resp, err := gs.client.sendHTTPRequestWithResponse(ctx, domain, method, endpoint, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = gs.importResponse(op.GroupID, resp)
+	return err
+}
+
+// groupEndpoint resolves the server holding a group and returns its domain and the group's URL
+func (gs *GroupSyncClient) groupEndpoint(ctx context.Context, groupID string) (string, string, error) {
+	domain, err := groups.GroupDomain(groupID)
+	if err != nil {
+		return "", "", err
+	}
+	serverInfo, err := gs.client.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return "", "", &ResolveError{Domain: domain, Err: err}
+	}
+	return domain, fmt.Sprintf("%s/api/v1/groups/%s", serverInfo.URL, url.PathEscape(groupID)), nil
+}
+
+// importResponse applies the group state returned by the server to the local copy
+func (gs *GroupSyncClient) importResponse(groupID string, resp *http.Response) (*groups.Group, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group: %w", err)
+	}
+	remote, err := groups.FromJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	if remote.ID != groupID {
+		return nil, fmt.Errorf("server returned group %s instead of %s", remote.ID, groupID)
+	}
+	return gs.manager.ImportGroup(remote)
+}
+
+// pushGroupOperation sends a change made through the client's group methods to the
+// group's server when group sync is enabled
+func (c *Client) pushGroupOperation(op *groups.GroupOperation) error {
+	if c.groupSync == nil {
+		return nil
+	}
+	if err := c.groupSync.Push(op); err != nil {
+		return fmt.Errorf("failed to sync group change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ServerInfo describes a domain's server: where it is and what it supports
+type ServerInfo struct {
+	ServerCapabilities
+	Domain string
+	URL    string
+}
+
+// Ping checks that a domain's server is up. See PingContext.
+func (c *Client) Ping(domain string) (time.Duration, error) {
+	return c.PingContext(context.Background(), domain)
+}
+
+// PingContext checks that a domain's server is up through its health endpoint
+// and returns the round-trip time. Servers without a health endpoint count as
+// up if they answer at all; ones reporting themselves unhealthy fail with an
+// *HTTPError.
+func (c *Client) PingContext(ctx context.Context, domain string) (time.Duration, error) {
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return 0, &ResolveError{Domain: domain, Err: err}
+	}
+
+	start := time.Now()
+	resp, err := c.sendHTTPRequestWithResponse(ctx, domain, "GET", serverInfo.URL+"/api/v1/health", nil)
+	elapsed := time.Since(start)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return elapsed, nil
+		}
+		return 0, fmt.Errorf("server for %s is not healthy: %w", domain, err)
+	}
+	resp.Body.Close()
+	return elapsed, nil
+}
+
+// GetServerInfo returns a domain's server and its capabilities. See GetServerInfoContext.
+func (c *Client) GetServerInfo(domain string) (*ServerInfo, error) {
+	return c.GetServerInfoContext(context.Background(), domain)
+}
+
+// GetServerInfoContext returns a domain's server with its protocol version,
+// limits and features, probing its capabilities if they are not cached. The
+// version published in DNS is used when the server does not advertise one.
+func (c *Client) GetServerInfoContext(ctx context.Context, domain string) (*ServerInfo, error) {
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return nil, &ResolveError{Domain: domain, Err: err}
+	}
+	caps, err := c.serverCapabilities(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ServerInfo{ServerCapabilities: *caps, Domain: domain, URL: serverInfo.URL}
+	info.Features = slices.Clone(caps.Features)
+	if info.Version == "" {
+		info.Version = serverInfo.Version
+	}
+	return info, nil
+}
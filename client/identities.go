@@ -0,0 +1,153 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// defaultInboxDedupEntries is how many received messages the inbox remembers for deduplication
+const defaultInboxDedupEntries = 10000
+
+// inboxDedup remembers which local identities received each message, so a message
+// addressed to several of them is returned once
+type inboxDedup struct {
+	identities map[string]bool            // Normalized addresses of the local user
+	seen       map[string]map[string]bool // Mailboxes each message was fetched from, keyed by sender and message ID
+	order      []string                   // Keys of seen in arrival order, oldest first
+	limit      int
+	mutex      sync.RWMutex
+}
+
+// AddIdentity adds an address or alias of the local user. Messages fetched for
+// several identities are returned once.
+func (c *Client) AddIdentity(address string) error {
+	if _, err := utils.ParseEMSGAddress(address); err != nil {
+		return invalidAddress("address", err)
+	}
+
+	c.inbox.mutex.Lock()
+	defer c.inbox.mutex.Unlock()
+	c.inbox.identities[utils.NormalizeEMSGAddress(address)] = true
+	return nil
+}
+
+// RemoveIdentity removes an address or alias of the local user
+func (c *Client) RemoveIdentity(address string) {
+	c.inbox.mutex.Lock()
+	defer c.inbox.mutex.Unlock()
+	delete(c.inbox.identities, utils.NormalizeEMSGAddress(address))
+}
+
+// Identities returns the local user's addresses and aliases, ordered by address
+func (c *Client) Identities() []string {
+	c.inbox.mutex.RLock()
+	defer c.inbox.mutex.RUnlock()
+
+	identities := make([]string, 0, len(c.inbox.identities))
+	for identity := range c.inbox.identities {
+		identities = append(identities, identity)
+	}
+	sort.Strings(identities)
+	return identities
+}
+
+// GetAddressedIdentities returns the local identities a received message was
+// addressed to, including those whose copies were dropped as duplicates after the
+// message was returned. It returns nil for messages the inbox does not remember.
+func (c *Client) GetAddressedIdentities(msg *message.Message) []string {
+	c.inbox.mutex.RLock()
+	defer c.inbox.mutex.RUnlock()
+	received, seen := c.inbox.seen[inboxDedupKey(msg)]
+	if !seen {
+		return nil
+	}
+	return c.inbox.addressedIdentities(msg, received)
+}
+
+// dedupInbox drops messages already received for another of the local user's
+// identities and notes on the rest which identities they were addressed to.
+// Fetching the same mailbox again returns its messages as before.
+func (c *Client) dedupInbox(messages []*message.Message, address string) []*message.Message {
+	c.inbox.mutex.Lock()
+	defer c.inbox.mutex.Unlock()
+
+	fetchedFor := utils.NormalizeEMSGAddress(address)
+	if !c.inbox.identities[fetchedFor] {
+		return messages
+	}
+
+	unique := messages[:0:0]
+	for _, msg := range messages {
+		if msg.MessageID == "" {
+			unique = append(unique, msg)
+			continue
+		}
+
+		key := inboxDedupKey(msg)
+		received, seen := c.inbox.seen[key]
+		if seen && !received[fetchedFor] {
+			received[fetchedFor] = true
+			c.logger.Debug("dropped duplicate message for another identity", "message_id", msg.MessageID, "identity", fetchedFor)
+			continue
+		}
+		if !seen {
+			received = c.inbox.remember(key)
+		}
+		received[fetchedFor] = true
+		msg.AddressedIdentities = c.inbox.addressedIdentities(msg, received)
+		unique = append(unique, msg)
+	}
+	return unique
+}
+
+// remember starts recording the identities of a message, forgetting the oldest
+// message once the limit is reached (internal method without lock)
+func (d *inboxDedup) remember(key string) map[string]bool {
+	if len(d.order) >= d.limit {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+	received := make(map[string]bool)
+	d.seen[key] = received
+	d.order = append(d.order, key)
+	return received
+}
+
+// addressedIdentities returns the identities a message was addressed to: those among
+// its recipients, and the mailboxes it was fetched from, which alone reveal blind
+// copies (internal method without lock)
+func (d *inboxDedup) addressedIdentities(msg *message.Message, received map[string]bool) []string {
+	addressed := make(map[string]bool, len(received))
+	for mailbox := range received {
+		addressed[mailbox] = true
+	}
+	for _, recipient := range msg.GetRecipients() {
+		if normalized := utils.NormalizeEMSGAddress(recipient); d.identities[normalized] {
+			addressed[normalized] = true
+		}
+	}
+	return sortedKeys(addressed)
+}
+
+// inboxDedupKey identifies a message by its verified sender and ID, so a sender
+// cannot suppress another sender's message by reusing its ID
+func inboxDedupKey(msg *message.Message) string {
+	return fmt.Sprintf("%s\x00%s", utils.NormalizeEMSGAddress(msg.From), msg.MessageID)
+}
+
+// sortedKeys returns the keys of a set in order, or nil for an empty set
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// ImportMessages writes archived messages, e.g. from a backfill or an email
+// import, into the local message store in batches. See store.Import.
+func (c *Client) ImportMessages(ctx context.Context, source store.ImportSource, opts *store.ImportOptions) (*store.ImportResult, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+	return store.Import(ctx, c.messageStore, source, opts)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// attachKeyBundle adds our key bundle to a message if any recipient has not been contacted yet
+func (c *Client) attachKeyBundle(msg *message.Message) {
+	keyPair := c.GetKeyPair()
+	if !c.distributeKeyBundles || c.encryptionManager == nil || keyPair == nil || msg.KeyBundle != nil {
+		return
+	}
+
+	c.contactMutex.Lock()
+	firstContact := false
+	for _, recipient := range msg.GetRecipients() {
+		if !c.contactedRecipients[utils.NormalizeEMSGAddress(recipient)] {
+			firstContact = true
+			break
+		}
+	}
+	c.contactMutex.Unlock()
+
+	if firstContact {
+		msg.KeyBundle = c.encryptionManager.CreateKeyBundle(msg.From, keyPair.PublicKeyBase64())
+	}
+}
+
+// markContacted records that all recipients of a message have received our key bundle
+func (c *Client) markContacted(msg *message.Message) {
+	if msg.KeyBundle == nil {
+		return
+	}
+
+	c.contactMutex.Lock()
+	defer c.contactMutex.Unlock()
+
+	for _, recipient := range msg.GetRecipients() {
+		c.contactedRecipients[utils.NormalizeEMSGAddress(recipient)] = true
+	}
+}
+
+// HasContacted returns true if our key bundle has already been sent to an address
+func (c *Client) HasContacted(address string) bool {
+	c.contactMutex.Lock()
+	defer c.contactMutex.Unlock()
+	return c.contactedRecipients[utils.NormalizeEMSGAddress(address)]
+}
+
+// ProcessKeyBundle verifies the key bundle carried by a received message and
+// pins the sender's encryption key into the key store. It returns true if a
+// new key was pinned.
+func (c *Client) ProcessKeyBundle(msg *message.Message) (bool, error) {
+	return c.ProcessKeyBundleContext(context.Background(), msg)
+}
+
+// ProcessKeyBundleContext is ProcessKeyBundle with a context for resolving the
+// sender's signing key. The bundle is only trusted if it carries the key
+// resolved through Config.KeyResolver, or published on the sender's domain, and
+// the message is signed with it; a bundle cannot vouch for itself.
+func (c *Client) ProcessKeyBundleContext(ctx context.Context, msg *message.Message) (bool, error) {
+	if c.encryptionManager == nil {
+		return false, ErrEncryptionUnavailable
+	}
+	if !msg.HasKeyBundle() {
+		return false, fmt.Errorf("message has no key bundle")
+	}
+
+	signingKey, err := c.signingKeys.lookup(ctx, msg.From)
+	if err != nil {
+		return false, fmt.Errorf("cannot verify key bundle of %s: %w", msg.From, err)
+	}
+	if err := msg.VerifyKeyBundleWith(signingKey); err != nil {
+		return false, err
+	}
+
+	pinned, err := c.encryptionManager.PinKeyBundle(msg.KeyBundle)
+	if pinned {
+		c.retryUndecryptableAfterKeyChange()
+	}
+	return pinned, err
+}
+
+// captureKeyBundles pins key bundles from fetched messages, logging any that are rejected
+func (c *Client) captureKeyBundles(ctx context.Context, messages []*message.Message) {
+	if c.encryptionManager == nil {
+		return
+	}
+
+	for _, msg := range messages {
+		// A bundle is not trusted from a message that failed signature verification
+		if !msg.HasKeyBundle() || msg.VerificationStatus == message.VerificationInvalid {
+			continue
+		}
+		if _, err := c.ProcessKeyBundleContext(ctx, msg); err != nil {
+			c.logger.Warn("rejected key bundle", "from", msg.From, "error", err)
+		}
+	}
+}
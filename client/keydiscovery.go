@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// FetchPublicKey retrieves the encryption public key an address has published on its
+// domain's server. The key is not stored; see RegisterPublicKey.
+func (c *Client) FetchPublicKey(address string) (string, error) {
+	return c.FetchPublicKeyContext(context.Background(), address)
+}
+
+// FetchPublicKeyContext retrieves a published encryption key, honouring ctx cancellation and deadlines
+func (c *Client) FetchPublicKeyContext(ctx context.Context, address string) (string, error) {
+	bundle, err := c.fetchKeyBundle(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	if bundle.EncryptionKey == "" {
+		return "", fmt.Errorf("no encryption key published for %s", address)
+	}
+
+	return bundle.EncryptionKey, nil
+}
+
+// FetchSigningKeyContext retrieves the Ed25519 signing key an address has published
+// on its domain's server, honouring ctx cancellation and deadlines
+func (c *Client) FetchSigningKeyContext(ctx context.Context, address string) (string, error) {
+	bundle, err := c.fetchKeyBundle(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	if bundle.SigningKey == "" {
+		return "", fmt.Errorf("no signing key published for %s", address)
+	}
+
+	return bundle.SigningKey, nil
+}
+
+// fetchKeyBundle retrieves the key bundle an address has published on its domain's
+// server. Concurrent fetches for the same address, e.g. for its encryption and its
+// signing key, share one request.
+func (c *Client) fetchKeyBundle(ctx context.Context, address string) (*encryption.KeyBundle, error) {
+	bundle, err, _ := c.keyBundleFlights.DoContext(ctx, utils.NormalizeEMSGAddress(address), func(ctx context.Context) (*encryption.KeyBundle, error) {
+		return c.requestKeyBundle(ctx, address)
+	})
+	return bundle, err
+}
+
+// requestKeyBundle requests the key bundle an address has published on its domain's server
+func (c *Client) requestKeyBundle(ctx context.Context, address string) (*encryption.KeyBundle, error) {
+	if c.GetKeyPair() == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return nil, invalidAddress("address", err)
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return nil, &ResolveError{Domain: addr.Domain, Err: err}
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/users/%s/keys", serverInfo.URL, url.PathEscape(address))
+	resp, err := c.sendHTTPRequestWithResponse(ctx, addr.Domain, "GET", endpoint, nil)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == 404 {
+			return nil, fmt.Errorf("no public key published for %s", address)
+		}
+		return nil, fmt.Errorf("failed to fetch public key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	// The server publishes the same key bundle senders attach on first contact
+	var bundle encryption.KeyBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if bundle.Address != "" && utils.NormalizeEMSGAddress(bundle.Address) != utils.NormalizeEMSGAddress(address) {
+		return nil, fmt.Errorf("server returned key for %s instead of %s", bundle.Address, address)
+	}
+
+	return &bundle, nil
+}
+
+// newKeyDiscovery creates the key discovery subsystem backed by FetchPublicKey
+func (c *Client) newKeyDiscovery(ttl, negativeTTL time.Duration) *encryption.KeyDiscovery {
+	kd := encryption.NewKeyDiscovery(c.FetchPublicKey, ttl, negativeTTL)
+	kd.SetLogger(c.logger)
+	return kd
+}
+
+// IsKeyDiscoveryEnabled returns true if missing recipient keys are fetched automatically
+func (c *Client) IsKeyDiscoveryEnabled() bool {
+	return c.keyDiscovery != nil
+}
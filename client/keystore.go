@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+)
+
+// wrapKeyStore returns the key store the encryption manager should write to,
+// adding write-behind persistence when it is configured
+func (c *Client) wrapKeyStore(keyStore encryption.KeyStore) encryption.KeyStore {
+	if c.keyStoreWriteBehind == nil || keyStore == nil {
+		return keyStore
+	}
+
+	writer := encryption.NewWriteBehindKeyStore(keyStore, c.keyStoreWriteBehind)
+	writer.SetLogger(c.logger)
+	c.keyWriter = writer
+	return writer
+}
+
+// FlushKeyStore persists pending write-behind key store writes now
+func (c *Client) FlushKeyStore() error {
+	return c.FlushKeyStoreContext(context.Background())
+}
+
+// FlushKeyStoreContext persists pending write-behind key store writes, stopping
+// early if ctx is done. It does nothing when writes are synchronous.
+func (c *Client) FlushKeyStoreContext(ctx context.Context) error {
+	if c.keyWriter == nil {
+		return nil
+	}
+	if err := c.keyWriter.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush key store: %w", err)
+	}
+	return nil
+}
+
+// PendingKeyStoreWrites returns the number of key store writes not yet persisted
+func (c *Client) PendingKeyStoreWrites() int {
+	if c.keyWriter == nil {
+		return 0
+	}
+	return c.keyWriter.Pending()
+}
+
+// closeKeyWriter persists pending writes and stops the write-behind goroutine
+func (c *Client) closeKeyWriter() error {
+	writer := c.keyWriter
+	if writer == nil {
+		return nil
+	}
+	c.keyWriter = nil
+	return writer.Close()
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// FeatureGroupFilters is advertised by servers that filter group notifications by keyword
+const FeatureGroupFilters = "groups.filters"
+
+// groupFilter is the keyword filter registered with the user's server for a group
+type groupFilter struct {
+	Keywords []string `json:"keywords"`
+}
+
+// SubscribeGroupKeywords subscribes to keywords in a group. See SubscribeGroupKeywordsContext.
+func (c *Client) SubscribeGroupKeywords(address, groupID string, keywords ...string) error {
+	return c.SubscribeGroupKeywordsContext(context.Background(), address, groupID, keywords...)
+}
+
+// SubscribeGroupKeywordsContext subscribes address to keywords or hashtags in a
+// group. Only the group's messages containing one of them raise
+// EventMessageReceived; the rest raise EventMessageSilenced and are still stored.
+// When address's server advertises FeatureGroupFilters the filter is registered
+// with it too, so server-sent push notifications follow the same rule. The local
+// filter applies even if registering it fails.
+func (c *Client) SubscribeGroupKeywordsContext(ctx context.Context, address, groupID string, keywords ...string) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	if err := c.notificationManager.SubscribeKeywords(groupID, keywords...); err != nil {
+		return err
+	}
+	return c.syncGroupFilter(ctx, address, groupID)
+}
+
+// UnsubscribeGroupKeywords removes keyword subscriptions in a group. See UnsubscribeGroupKeywordsContext.
+func (c *Client) UnsubscribeGroupKeywords(address, groupID string, keywords ...string) error {
+	return c.UnsubscribeGroupKeywordsContext(context.Background(), address, groupID, keywords...)
+}
+
+// UnsubscribeGroupKeywordsContext removes keyword subscriptions in a group and
+// updates the filter registered with address's server. With no keywords left,
+// every message in the group notifies normally again.
+func (c *Client) UnsubscribeGroupKeywordsContext(ctx context.Context, address, groupID string, keywords ...string) error {
+	if c.notificationManager == nil {
+		return fmt.Errorf("notifications not enabled")
+	}
+	c.notificationManager.UnsubscribeKeywords(groupID, keywords...)
+	return c.syncGroupFilter(ctx, address, groupID)
+}
+
+// GetGroupKeywords returns the keywords subscribed to in a group
+func (c *Client) GetGroupKeywords(groupID string) []string {
+	if c.notificationManager == nil {
+		return nil
+	}
+	return c.notificationManager.Keywords(groupID)
+}
+
+// syncGroupFilter registers a group's current keywords with the user's server, or
+// removes the filter when none remain. Servers without FeatureGroupFilters are skipped.
+func (c *Client) syncGroupFilter(ctx context.Context, address, groupID string) error {
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return invalidAddress("address", err)
+	}
+
+	caps, err := c.serverCapabilities(ctx, addr.Domain)
+	if err != nil {
+		c.logger.Warn("failed to probe server capabilities, keeping group filter local", "domain", addr.Domain, "error", err)
+		return nil
+	}
+	if !caps.HasFeature(FeatureGroupFilters) {
+		return nil
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return fmt.Errorf("failed to resolve domain: %w", err)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/users/%s/groups/%s/filters", serverInfo.URL, url.PathEscape(address), url.PathEscape(groupID))
+
+	keywords := c.notificationManager.Keywords(groupID)
+	if len(keywords) == 0 {
+		if err := c.sendHTTPRequest(ctx, addr.Domain, "DELETE", endpoint, nil); err != nil {
+			return fmt.Errorf("failed to remove group filter: %w", err)
+		}
+		return nil
+	}
+
+	payload, err := json.Marshal(&groupFilter{Keywords: keywords})
+	if err != nil {
+		return fmt.Errorf("failed to serialize group filter: %w", err)
+	}
+	if err := c.sendHTTPRequest(ctx, addr.Domain, "PUT", endpoint, payload); err != nil {
+		return fmt.Errorf("failed to register group filter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// FeatureLabels is advertised by servers that file messages under labels
+const FeatureLabels = "labels"
+
+// Label is a folder or tag messages are filed under on the user's server
+type Label struct {
+	Name     string `json:"name"`
+	System   bool   `json:"system,omitempty"`   // Managed by the server, e.g. message.LabelSpam; cannot be deleted
+	Messages int    `json:"messages,omitempty"` // Messages filed under the label
+	Unread   int    `json:"unread,omitempty"`   // Unread messages filed under the label
+}
+
+// labelChange is the request body of a change to a message's labels
+type labelChange struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// messageLabels is the server's response listing a message's labels after a change
+type messageLabels struct {
+	Labels []string `json:"labels"`
+}
+
+// ListLabels returns the labels on address's server. See ListLabelsContext.
+func (c *Client) ListLabels(address string) ([]*Label, error) {
+	return c.ListLabelsContext(context.Background(), address)
+}
+
+// ListLabelsContext returns the system and custom labels on address's server
+// with their message counts
+func (c *Client) ListLabelsContext(ctx context.Context, address string) ([]*Label, error) {
+	domain, endpoint, err := c.labelsEndpoint(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendHTTPRequestWithResponse(ctx, domain, "GET", endpoint+"/labels", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var labels []*Label
+	if err := json.NewDecoder(resp.Body).Decode(&labels); err != nil {
+		return nil, fmt.Errorf("failed to parse labels: %w", err)
+	}
+	return labels, nil
+}
+
+// CreateLabel creates a custom label. See CreateLabelContext.
+func (c *Client) CreateLabel(address, name string) error {
+	return c.CreateLabelContext(context.Background(), address, name)
+}
+
+// CreateLabelContext creates a custom label on address's server. Applying a
+// label that does not exist yet creates it as well.
+func (c *Client) CreateLabelContext(ctx context.Context, address, name string) error {
+	if err := message.ValidateLabel(name); err != nil {
+		return err
+	}
+	domain, endpoint, err := c.labelsEndpoint(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&Label{Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to serialize label: %w", err)
+	}
+	if err := c.sendHTTPRequest(ctx, domain, "POST", endpoint+"/labels", payload); err != nil {
+		return fmt.Errorf("failed to create label: %w", err)
+	}
+	return nil
+}
+
+// DeleteLabel deletes a custom label. See DeleteLabelContext.
+func (c *Client) DeleteLabel(address, name string) error {
+	return c.DeleteLabelContext(context.Background(), address, name)
+}
+
+// DeleteLabelContext deletes a custom label on address's server, removing it
+// from the messages filed under it, locally mirrored ones included
+func (c *Client) DeleteLabelContext(ctx context.Context, address, name string) error {
+	if err := message.ValidateLabel(name); err != nil {
+		return err
+	}
+	domain, endpoint, err := c.labelsEndpoint(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	if err := c.sendHTTPRequest(ctx, domain, "DELETE", endpoint+"/labels/"+url.PathEscape(name), nil); err != nil {
+		return fmt.Errorf("failed to delete label: %w", err)
+	}
+
+	if c.messageStore != nil {
+		filter := func(msg *message.Message) bool { return msg.HasLabel(name) }
+		labelled, err := store.LoadOrdered(c.messageStore, filter)
+		if err != nil {
+			c.logger.Warn("failed to load labelled messages", "label", name, "error", err)
+		}
+		for _, msg := range labelled {
+			c.mirrorLabels(msg.MessageID, func(labels []string) []string {
+				return slices.DeleteFunc(labels, func(l string) bool { return l == name })
+			})
+		}
+	}
+	return nil
+}
+
+// ApplyLabels files a message under labels. See ApplyLabelsContext.
+func (c *Client) ApplyLabels(address, messageID string, labels ...string) error {
+	return c.ApplyLabelsContext(context.Background(), address, messageID, labels...)
+}
+
+// ApplyLabelsContext files a message in address's mailbox under labels, e.g.
+// message.LabelArchive, and updates the locally stored copy
+func (c *Client) ApplyLabelsContext(ctx context.Context, address, messageID string, labels ...string) error {
+	return c.changeLabels(ctx, address, messageID, &labelChange{Add: labels})
+}
+
+// RemoveLabels removes labels from a message. See RemoveLabelsContext.
+func (c *Client) RemoveLabels(address, messageID string, labels ...string) error {
+	return c.RemoveLabelsContext(context.Background(), address, messageID, labels...)
+}
+
+// RemoveLabelsContext removes labels from a message in address's mailbox and
+// updates the locally stored copy
+func (c *Client) RemoveLabelsContext(ctx context.Context, address, messageID string, labels ...string) error {
+	return c.changeLabels(ctx, address, messageID, &labelChange{Remove: labels})
+}
+
+// GetMessagesWithLabel retrieves the messages filed under a label in address's mailbox
+func (c *Client) GetMessagesWithLabel(ctx context.Context, address, label string) ([]*message.Message, error) {
+	if err := message.ValidateLabel(label); err != nil {
+		return nil, err
+	}
+	addr, err := utils.ParseAddress(address)
+	if err != nil {
+		return nil, invalidAddress("address", err)
+	}
+	if err := c.requireLabels(ctx, addr.Domain()); err != nil {
+		return nil, err
+	}
+
+	messages, _, err := c.fetchMessages(ctx, addr, url.Values{"label": {label}})
+	if err != nil {
+		return nil, err
+	}
+	// Drop anything else the server returned along with the labelled messages
+	return slices.DeleteFunc(messages, func(msg *message.Message) bool { return !msg.HasLabel(label) }), nil
+}
+
+// GetStoredMessagesWithLabel returns the stored messages filed under a label,
+// oldest first, for browsing folders offline
+func (c *Client) GetStoredMessagesWithLabel(label string) ([]*message.Message, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+	return store.LoadOrdered(c.messageStore, func(msg *message.Message) bool {
+		return msg.HasLabel(label)
+	})
+}
+
+// changeLabels adds and removes labels of a message on the server and mirrors
+// the result into the message store. Servers answer with the message's labels
+// after the change; without them the change is applied to the stored copy.
+func (c *Client) changeLabels(ctx context.Context, address, messageID string, change *labelChange) error {
+	if messageID == "" {
+		return &message.ValidationError{Field: "message_id", Err: fmt.Errorf("message ID is required")}
+	}
+	if len(change.Add)+len(change.Remove) == 0 {
+		return &message.ValidationError{Field: "label", Err: fmt.Errorf("no labels given")}
+	}
+	for _, label := range append(slices.Clone(change.Add), change.Remove...) {
+		if err := message.ValidateLabel(label); err != nil {
+			return err
+		}
+	}
+	domain, endpoint, err := c.labelsEndpoint(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to serialize label change: %w", err)
+	}
+	endpoint = fmt.Sprintf("%s/messages/%s/labels", endpoint, url.PathEscape(messageID))
+	resp, err := c.sendHTTPRequestWithResponse(ctx, domain, "PATCH", endpoint, payload)
+	if err != nil {
+		return fmt.Errorf("failed to change labels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read label change response: %w", err)
+	}
+	if len(body) > 0 {
+		var result messageLabels
+		if err := json.Unmarshal(body, &result); err != nil {
+			return fmt.Errorf("failed to parse label change response: %w", err)
+		}
+		c.mirrorLabels(messageID, func([]string) []string { return result.Labels })
+		return nil
+	}
+
+	c.mirrorLabels(messageID, func(labels []string) []string {
+		labels = slices.DeleteFunc(labels, func(l string) bool { return slices.Contains(change.Remove, l) })
+		for _, label := range change.Add {
+			if !slices.Contains(labels, label) {
+				labels = append(labels, label)
+			}
+		}
+		return labels
+	})
+	return nil
+}
+
+// handleLabelsChanged mirrors label changes the server pushes over the WebSocket,
+// e.g. made from another device
+func (c *Client) handleLabelsChanged(data interface{}) {
+	event, ok := data.(*websocket.LabelEvent)
+	if !ok {
+		return
+	}
+	c.mirrorLabels(event.MessageID, func([]string) []string { return event.Labels })
+}
+
+// mirrorLabels updates the labels of a message in the local store, if it is there
+func (c *Client) mirrorLabels(messageID string, update func(labels []string) []string) {
+	if c.messageStore == nil {
+		return
+	}
+
+	msg, err := c.messageStore.Get(messageID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			c.logger.Warn("failed to load message to update labels", "message_id", messageID, "error", err)
+		}
+		return
+	}
+	msg.Labels = update(slices.Clone(msg.Labels))
+	if err := c.messageStore.Save(msg); err != nil {
+		c.logger.Warn("failed to store message labels", "message_id", messageID, "error", err)
+	}
+}
+
+// labelsEndpoint returns the domain of address's server and the base URL of
+// address's mailbox on it, checking that the server supports labels
+func (c *Client) labelsEndpoint(ctx context.Context, address string) (string, string, error) {
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return "", "", invalidAddress("address", err)
+	}
+	if err := c.requireLabels(ctx, addr.Domain); err != nil {
+		return "", "", err
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return "", "", &ResolveError{Domain: addr.Domain, Err: err}
+	}
+	return addr.Domain, fmt.Sprintf("%s/api/v1/users/%s", serverInfo.URL, url.PathEscape(address)), nil
+}
+
+// requireLabels fails unless the domain's server advertises FeatureLabels
+func (c *Client) requireLabels(ctx context.Context, domain string) error {
+	caps, err := c.serverCapabilities(ctx, domain)
+	if err != nil {
+		return err
+	}
+	if !caps.HasFeature(FeatureLabels) {
+		return fmt.Errorf("server for %s does not support labels: %w", domain, ErrFeatureUnsupported)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// LoopbackNetwork connects clients in the same process, e.g. a desktop app and
+// its local bot, or the two ends of a test. Messages to addresses attached to
+// it skip DNS and HTTP and wait in an in-memory mailbox until the receiving
+// client fetches them, so signing, encryption, verification, delivery tracking
+// and notifications still run as they would against a server.
+type LoopbackNetwork struct {
+	clients   map[string]*Client            // Client receiving for each attached address
+	mailboxes map[string][]*message.Message // Messages waiting for each attached address
+	mutex     sync.Mutex
+}
+
+// NewLoopbackNetwork creates an empty loopback network
+func NewLoopbackNetwork() *LoopbackNetwork {
+	return &LoopbackNetwork{
+		clients:   make(map[string]*Client),
+		mailboxes: make(map[string][]*message.Message),
+	}
+}
+
+// AttachLoopback makes the client receive an address's messages over its
+// Config.Loopback network. Senders on the network deliver to the address in
+// memory, and fetching its messages drains the in-memory mailbox.
+func (c *Client) AttachLoopback(address string) error {
+	if c.loopback == nil {
+		return fmt.Errorf("loopback transport not enabled")
+	}
+	if _, err := utils.ParseEMSGAddress(address); err != nil {
+		return invalidAddress("address", err)
+	}
+
+	lb := c.loopback
+	address = utils.NormalizeEMSGAddress(address)
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if owner, exists := lb.clients[address]; exists && owner != c {
+		return fmt.Errorf("address %s is attached to another client", address)
+	}
+	lb.clients[address] = c
+	return nil
+}
+
+// DetachLoopback stops receiving an address's messages over the loopback
+// network, dropping any that were not fetched
+func (c *Client) DetachLoopback(address string) {
+	lb := c.loopback
+	if lb == nil {
+		return
+	}
+
+	address = utils.NormalizeEMSGAddress(address)
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if lb.clients[address] == c {
+		delete(lb.clients, address)
+		delete(lb.mailboxes, address)
+	}
+}
+
+// detachAll detaches every address a client attached, e.g. when it is closed
+func (lb *LoopbackNetwork) detachAll(c *Client) {
+	if lb == nil {
+		return
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	for address, owner := range lb.clients {
+		if owner == c {
+			delete(lb.clients, address)
+			delete(lb.mailboxes, address)
+		}
+	}
+}
+
+// isAttached returns true if c receives address's messages over the network
+func (lb *LoopbackNetwork) isAttached(c *Client, address string) bool {
+	if lb == nil {
+		return false
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.clients[utils.NormalizeEMSGAddress(address)] == c
+}
+
+// covers returns true if every recipient of msg in domain is attached, so the
+// domain's copy can be delivered without its server
+func (lb *LoopbackNetwork) covers(msg *message.Message, domain string) bool {
+	if lb == nil {
+		return false
+	}
+	recipients := recipientsInDomain(msg, domain)
+	if len(recipients) == 0 {
+		return false
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	for _, recipient := range recipients {
+		if lb.clients[utils.NormalizeEMSGAddress(recipient)] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver puts a copy of msg in the mailbox of each recipient in domain and
+// asks their clients to poll. Each copy goes through the wire format, as it
+// would through a server. The response stands in for the server accepting it.
+func (lb *LoopbackNetwork) deliver(msg *message.Message, domain string) (*http.Response, error) {
+	data, err := msg.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	var receivers []*Client
+	lb.mutex.Lock()
+	for _, recipient := range recipientsInDomain(msg, domain) {
+		address := utils.NormalizeEMSGAddress(recipient)
+		receiver := lb.clients[address]
+		if receiver == nil {
+			lb.mutex.Unlock()
+			return nil, fmt.Errorf("recipient %s left the loopback network", recipient)
+		}
+		received, err := message.FromJSON(data)
+		if err != nil {
+			lb.mutex.Unlock()
+			return nil, err
+		}
+		lb.mailboxes[address] = append(lb.mailboxes[address], received)
+		receivers = append(receivers, receiver)
+	}
+	lb.mutex.Unlock()
+
+	for _, receiver := range receivers {
+		if receiver.IsMessagePollingRunning() {
+			receiver.messagePoller.PollNow()
+		}
+	}
+
+	return &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+// drain removes and returns the messages waiting for address
+func (lb *LoopbackNetwork) drain(address string) []*message.Message {
+	address = utils.NormalizeEMSGAddress(address)
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	messages := lb.mailboxes[address]
+	delete(lb.mailboxes, address)
+	return messages
+}
+
+// keyResolver resolves the signing keys of attached addresses from their
+// clients, passing other addresses to next
+func (lb *LoopbackNetwork) keyResolver(next KeyResolver) KeyResolver {
+	return KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		lb.mutex.Lock()
+		owner := lb.clients[utils.NormalizeEMSGAddress(address)]
+		lb.mutex.Unlock()
+
+		if owner != nil {
+			if keyPair := owner.GetKeyPair(); keyPair != nil {
+				return keyPair.PublicKeyBase64(), nil
+			}
+		}
+		return next.ResolveSigningKey(ctx, address)
+	})
+}
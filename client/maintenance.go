@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// MaintenanceWindow is a daily period, in local time, when scheduled maintenance
+// may run. Start and End are offsets from midnight; a window whose End is before
+// its Start spans midnight.
+type MaintenanceWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// contains returns true if t falls inside the window
+func (w MaintenanceWindow) contains(t time.Time) bool {
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// MaintenanceSchedule controls when the background scheduler compacts stores
+type MaintenanceSchedule struct {
+	Interval time.Duration       // How often the scheduler checks whether maintenance should run
+	IdleFor  time.Duration       // Only run once the client has made no requests for this long (0 = ignore activity)
+	Windows  []MaintenanceWindow // Daily periods maintenance may run in (empty = any time)
+}
+
+// DefaultMaintenanceSchedule returns a schedule that checks hourly and runs
+// after five idle minutes at any time of day
+func DefaultMaintenanceSchedule() *MaintenanceSchedule {
+	return &MaintenanceSchedule{
+		Interval: time.Hour,
+		IdleFor:  5 * time.Minute,
+	}
+}
+
+// validate checks the schedule's values
+func (s *MaintenanceSchedule) validate() error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if s.IdleFor < 0 {
+		return fmt.Errorf("idle time must not be negative")
+	}
+	for i, w := range s.Windows {
+		if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End >= 24*time.Hour {
+			return fmt.Errorf("window %d must start and end within a day", i)
+		}
+		if w.Start == w.End {
+			return fmt.Errorf("window %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// MaintenanceReport describes one maintenance run
+type MaintenanceReport struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Scheduled bool                               // Run by the scheduler rather than Maintain
+	Stores    map[string]*store.CompactionResult // Keyed by "messages", "outbox" and "drafts"
+	Total     store.CompactionResult
+	Expired   int // Self-destructed messages purged from the message store
+}
+
+// maintenanceScheduler runs store maintenance in the background
+type maintenanceScheduler struct {
+	schedule *MaintenanceSchedule
+	onReport func(*MaintenanceReport)
+
+	lastActivity atomic.Int64 // Unix nanoseconds of the last request the client made
+	runMutex     sync.Mutex   // Keeps manual and scheduled runs from overlapping
+	mutex        sync.Mutex
+	running      bool
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+// Maintain purges expired messages from the message store and compacts every
+// configured store that supports it, reclaiming space left by deletes and
+// interrupted writes, and returns what was reclaimed
+func (c *Client) Maintain(ctx context.Context) (*MaintenanceReport, error) {
+	return c.runMaintenance(ctx, false)
+}
+
+// StartMaintenance starts running maintenance in the background according to
+// Config.MaintenanceSchedule
+func (c *Client) StartMaintenance() error {
+	m := c.maintenance
+	if m.schedule == nil {
+		return fmt.Errorf("maintenance schedule not configured")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.running {
+		return fmt.Errorf("maintenance scheduler is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.running = true
+
+	go c.maintenanceLoop(ctx, m.done)
+	return nil
+}
+
+// StopMaintenance stops the background scheduler, waiting for a run in progress to stop
+func (c *Client) StopMaintenance() {
+	m := c.maintenance
+	m.mutex.Lock()
+	if !m.running {
+		m.mutex.Unlock()
+		return
+	}
+	m.cancel()
+	m.running = false
+	done := m.done
+	m.mutex.Unlock()
+
+	<-done
+}
+
+// IsMaintenanceRunning returns true if the background scheduler is running
+func (c *Client) IsMaintenanceRunning() bool {
+	c.maintenance.mutex.Lock()
+	defer c.maintenance.mutex.Unlock()
+	return c.maintenance.running
+}
+
+// maintenanceLoop runs maintenance on each tick that falls in a window while the client is idle
+func (c *Client) maintenanceLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.maintenance.schedule.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !c.maintenanceDue(now) {
+				continue
+			}
+			if _, err := c.runMaintenance(ctx, true); err != nil && ctx.Err() == nil {
+				c.logger.Warn("scheduled maintenance failed", "error", err)
+			}
+		}
+	}
+}
+
+// maintenanceDue returns true if now is inside a maintenance window and the client is idle
+func (c *Client) maintenanceDue(now time.Time) bool {
+	schedule := c.maintenance.schedule
+	if idle := schedule.IdleFor; idle > 0 {
+		if last := c.maintenance.lastActivity.Load(); last != 0 && now.Sub(time.Unix(0, last)) < idle {
+			return false
+		}
+	}
+	if len(schedule.Windows) == 0 {
+		return true
+	}
+	for _, window := range schedule.Windows {
+		if window.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordActivity marks the client busy so scheduled maintenance waits for an idle period
+func (c *Client) recordActivity() {
+	c.maintenance.lastActivity.Store(time.Now().UnixNano())
+}
+
+// runMaintenance compacts the stores and reports the result
+func (c *Client) runMaintenance(ctx context.Context, scheduled bool) (*MaintenanceReport, error) {
+	c.maintenance.runMutex.Lock()
+	defer c.maintenance.runMutex.Unlock()
+
+	report := &MaintenanceReport{
+		StartedAt: time.Now(),
+		Scheduled: scheduled,
+		Stores:    make(map[string]*store.CompactionResult),
+	}
+
+	targets := map[string]any{"messages": c.messageStore}
+	if c.outbox != nil {
+		targets["outbox"] = c.outbox.store
+	}
+	if c.drafts != nil {
+		targets["drafts"] = c.drafts.store
+	}
+
+	var errs []error
+	if c.messageStore != nil {
+		expired, err := c.PurgeExpiredMessages()
+		report.Expired = expired
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to purge expired messages: %w", err))
+		}
+	}
+	for _, name := range []string{"messages", "outbox", "drafts"} {
+		compactor, ok := targets[name].(store.Compactor)
+		if !ok {
+			continue
+		}
+		result, err := compactor.Compact(ctx)
+		if result != nil {
+			report.Stores[name] = result
+			report.Total.Add(result)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to compact %s store: %w", name, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	report.Duration = time.Since(report.StartedAt)
+
+	c.logger.Info("store maintenance finished",
+		"scheduled", scheduled,
+		"expired_purged", report.Expired,
+		"files_removed", report.Total.FilesRemoved,
+		"bytes_reclaimed", report.Total.BytesReclaimed,
+		"duration", report.Duration)
+	if c.maintenance.onReport != nil {
+		c.maintenance.onReport(report)
+	}
+
+	return report, errors.Join(errs...)
+}
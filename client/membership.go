@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// ErrMembershipUnproven matches group messages whose sender could not be shown to
+// be a member of the group
+var ErrMembershipUnproven = errors.New("group membership not proven")
+
+// membershipProofs holds the proofs attached to our outgoing group messages, keyed by group ID
+type membershipProofs struct {
+	proofs map[string]*groups.MembershipProof
+	mutex  sync.RWMutex
+}
+
+// IssueMembershipProof signs a proof that member belongs to a group with the
+// client's key. issuerAddress is the client's address and must be an owner of
+// the local copy of the group, or the group's own address when the client holds
+// the key its server publishes for it.
+func (c *Client) IssueMembershipProof(groupID, issuerAddress, member string, ttl time.Duration) (*groups.MembershipProof, error) {
+	if c.groupManager == nil {
+		return nil, fmt.Errorf("group management not enabled")
+	}
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	return groups.IssueMembershipProof(keyPair, issuerAddress, group, member, ttl)
+}
+
+// SetMembershipProof makes the client attach proof to the group messages it sends
+// as the proof's member, replacing any earlier proof for the group
+func (c *Client) SetMembershipProof(proof *groups.MembershipProof) error {
+	if proof == nil || proof.GroupID == "" {
+		return fmt.Errorf("membership proof is required")
+	}
+	if proof.IsExpired() {
+		return fmt.Errorf("membership proof expired")
+	}
+
+	c.membershipProofs.mutex.Lock()
+	defer c.membershipProofs.mutex.Unlock()
+	c.membershipProofs.proofs[proof.GroupID] = proof
+	return nil
+}
+
+// LoadMembershipProof decodes a proof produced by MembershipProof.Encode and sets it
+func (c *Client) LoadMembershipProof(encoded string) error {
+	proof, err := groups.ParseMembershipProof(encoded)
+	if err != nil {
+		return err
+	}
+	return c.SetMembershipProof(proof)
+}
+
+// GetMembershipProof returns the proof attached to messages for a group, or nil
+func (c *Client) GetMembershipProof(groupID string) *groups.MembershipProof {
+	c.membershipProofs.mutex.RLock()
+	defer c.membershipProofs.mutex.RUnlock()
+	return c.membershipProofs.proofs[groupID]
+}
+
+// RemoveMembershipProof stops attaching a proof to messages for a group
+func (c *Client) RemoveMembershipProof(groupID string) {
+	c.membershipProofs.mutex.Lock()
+	defer c.membershipProofs.mutex.Unlock()
+	delete(c.membershipProofs.proofs, groupID)
+}
+
+// attachMembershipProof attaches the proof for a group message's sender, if one is set
+func (c *Client) attachMembershipProof(msg *message.Message) error {
+	msg.MembershipProof = ""
+	if msg.GroupID == "" {
+		return nil
+	}
+
+	proof := c.GetMembershipProof(msg.GroupID)
+	if proof == nil || !proof.Covers(msg.GroupID, msg.From) {
+		return nil
+	}
+	if proof.IsExpired() {
+		c.logger.Warn("membership proof expired; sending without it", "group_id", msg.GroupID)
+		return nil
+	}
+
+	encoded, err := proof.Encode()
+	if err != nil {
+		return err
+	}
+	msg.MembershipProof = encoded
+	return nil
+}
+
+// VerifyGroupMembership checks a received group message's membership proof. See
+// VerifyGroupMembershipContext.
+func (c *Client) VerifyGroupMembership(msg *message.Message) (*groups.MembershipProof, error) {
+	return c.VerifyGroupMembershipContext(context.Background(), msg)
+}
+
+// VerifyGroupMembershipContext checks that a received group message carries a
+// valid proof that its sender belongs to the group. The proof's issuer must be
+// an owner in the local copy of the group or the group's own address, whose key
+// its server publishes, and its signing key is resolved like a sender's. The
+// message is checked as a live delivery, so its send time must be close to now.
+// Failures match ErrMembershipUnproven.
+func (c *Client) VerifyGroupMembershipContext(ctx context.Context, msg *message.Message) (*groups.MembershipProof, error) {
+	proof, err := groups.MessageMembershipProof(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMembershipUnproven, err)
+	}
+	if !c.isMembershipIssuer(msg.GroupID, proof.IssuerAddress) {
+		return nil, fmt.Errorf("%w: %s cannot vouch for members of %s", ErrMembershipUnproven, proof.IssuerAddress, msg.GroupID)
+	}
+
+	key, err := c.signingKeys.lookup(ctx, proof.IssuerAddress)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve membership proof issuer key: %w", err)
+	}
+
+	proof, err = groups.VerifyMessageMembership(msg, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMembershipUnproven, err)
+	}
+	return proof, nil
+}
+
+// isMembershipIssuer returns true if address may issue membership proofs for a group
+func (c *Client) isMembershipIssuer(groupID, address string) bool {
+	if groups.IsGroupServerAddress(groupID, address) {
+		return true
+	}
+	if c.groupManager == nil {
+		return false
+	}
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return false
+	}
+	member, err := group.GetMember(address)
+	return err == nil && member.Role == groups.RoleOwner
+}
+
+// FetchMembershipProof asks a group's server for a proof of membership. See
+// FetchMembershipProofContext.
+func (gs *GroupSyncClient) FetchMembershipProof(groupID, member string) (*groups.MembershipProof, error) {
+	return gs.FetchMembershipProofContext(context.Background(), groupID, member)
+}
+
+// FetchMembershipProofContext asks a group's server for a proof, signed by the
+// server, that member belongs to the group. Set it with Client.SetMembershipProof
+// to attach it to outgoing group messages.
+func (gs *GroupSyncClient) FetchMembershipProofContext(ctx context.Context, groupID, member string) (*groups.MembershipProof, error) {
+	domain, endpoint, err := gs.groupEndpoint(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := gs.client.sendHTTPRequestWithResponse(ctx, domain, "GET", endpoint+"/members/"+url.PathEscape(member)+"/proof", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch membership proof: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read membership proof: %w", err)
+	}
+	var proof groups.MembershipProof
+	if err := json.Unmarshal(body, &proof); err != nil {
+		return nil, fmt.Errorf("failed to parse membership proof: %w", err)
+	}
+	if !proof.Covers(groupID, member) {
+		return nil, fmt.Errorf("server returned a proof for %s in %s", proof.Member, proof.GroupID)
+	}
+	if !groups.IsGroupServerAddress(groupID, proof.IssuerAddress) {
+		return nil, fmt.Errorf("membership proof issued by %s, not the group's server", proof.IssuerAddress)
+	}
+	return &proof, nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// MemoryProfile selects the limits the SDK places on its in-memory caches,
+// queues and buffers
+type MemoryProfile string
+
+const (
+	MemoryProfileStandard MemoryProfile = ""    // No extra limits; suited to servers, desktops and phones
+	MemoryProfileLow      MemoryProfile = "low" // Tight limits for IoT and embedded targets
+)
+
+// MemoryLimits are the limits applied by a memory profile. Zero fields leave the
+// corresponding cache, queue or buffer at its default.
+type MemoryLimits struct {
+	DNSCacheEntries       int   // Resolved domains kept in the DNS cache
+	NotificationQueue     int   // Queued async handler calls, and notifications buffered per batch or digest
+	WebSocketQueue        int   // WebSocket frames buffered in each direction
+	WebSocketMaxMessage   int64 // Largest WebSocket frame accepted
+	WebSocketBufferSize   int   // WebSocket connection read and write buffer size
+	DeliveryReceipts      int   // Delivery receipts kept in memory; finished ones are dropped first
+	AttachmentInlineLimit int64 // Attachments larger than this are chunked instead of inlined
+	AttachmentChunkSize   int64 // Largest attachment chunk held in memory
+	InboxDedupEntries     int   // Received messages remembered to drop copies addressed to other identities
+}
+
+// Limits returns the limits of a profile, or nil for MemoryProfileStandard and
+// unknown profiles
+func (p MemoryProfile) Limits() *MemoryLimits {
+	switch p {
+	case MemoryProfileLow:
+		return &MemoryLimits{
+			DNSCacheEntries:       16,
+			NotificationQueue:     32,
+			WebSocketQueue:        16,
+			WebSocketMaxMessage:   256 * 1024, // 256KB
+			WebSocketBufferSize:   1024,
+			DeliveryReceipts:      256,
+			AttachmentInlineLimit: 64 * 1024, // 64KB
+			AttachmentChunkSize:   64 * 1024, // 64KB
+			InboxDedupEntries:     256,
+		}
+	default:
+		return nil
+	}
+}
+
+// GetMemoryLimits returns the limits of the client's memory profile, or nil if none apply
+func (c *Client) GetMemoryLimits() *MemoryLimits {
+	return c.memoryLimits
+}
+
+// applyMemoryLimits caps the subsystems created by New. The WebSocket client is
+// created later and picks up its limits in ConnectWebSocket.
+func (c *Client) applyMemoryLimits() {
+	limits := c.memoryLimits
+	if limits == nil {
+		return
+	}
+
+	if cache, ok := unwrapResolver(c.resolver).(*dns.CachedResolver); ok && limits.DNSCacheEntries > 0 {
+		cache.SetMaxEntries(limits.DNSCacheEntries)
+	}
+	if c.notificationManager != nil && limits.NotificationQueue > 0 {
+		c.notificationManager.SetMaxQueued(limits.NotificationQueue)
+	}
+	if c.deliveryTracker != nil && limits.DeliveryReceipts > 0 {
+		c.deliveryTracker.SetMaxReceipts(limits.DeliveryReceipts)
+	}
+	if limits.InboxDedupEntries > 0 {
+		c.inbox.limit = limits.InboxDedupEntries
+	}
+	if c.attachmentConfig != nil {
+		c.attachmentConfig = limitAttachmentConfig(c.attachmentConfig, limits)
+	}
+}
+
+// limitAttachmentConfig returns a copy of config with its inline limit and chunk
+// sizes lowered to the memory limits
+func limitAttachmentConfig(config *attachments.AttachmentConfig, limits *MemoryLimits) *attachments.AttachmentConfig {
+	limited := *config
+	if limit := limits.AttachmentInlineLimit; limit > 0 && (limited.InlineLimit <= 0 || limited.InlineLimit > limit) {
+		limited.InlineLimit = limit
+	}
+	if limit := limits.AttachmentChunkSize; limit > 0 {
+		if limited.MaxChunkSize <= 0 || limited.MaxChunkSize > limit {
+			limited.MaxChunkSize = limit
+		}
+		if limited.MinChunkSize > limited.MaxChunkSize {
+			limited.MinChunkSize = limited.MaxChunkSize
+		}
+	}
+	return &limited
+}
+
+// webSocketBufferLimits returns the WebSocket limits of the memory profile, or nil if none apply
+func (c *Client) webSocketBufferLimits() *websocket.BufferLimits {
+	limits := c.memoryLimits
+	if limits == nil {
+		return nil
+	}
+	return &websocket.BufferLimits{
+		QueueSize:      limits.WebSocketQueue,
+		MaxMessageSize: limits.WebSocketMaxMessage,
+		IOBufferSize:   limits.WebSocketBufferSize,
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/metrics"
+)
+
+// recordAttemptMetrics records the latency of an HTTP attempt and counts it if
+// it is retried. Cancelled attempts say nothing about the server and are skipped.
+func (c *Client) recordAttemptMetrics(info *RequestInfo, statusCode int, duration time.Duration, disposition RequestDisposition) {
+	if c.metrics == nil || disposition == DispositionCancelled {
+		return
+	}
+	c.recordLatency(info.Domain, info.Method, statusCode, duration)
+	if disposition == DispositionRetrying {
+		c.metrics.AddCounter(metrics.HTTPRetries, metrics.Labels{"domain": info.Domain}, 1)
+	}
+}
+
+// recordLatency records the latency of an HTTP attempt to a domain's server
+func (c *Client) recordLatency(domain, method string, statusCode int, duration time.Duration) {
+	if c.metrics == nil {
+		return
+	}
+	labels := metrics.Labels{"domain": domain, "method": method, "code": strconv.Itoa(statusCode)}
+	c.metrics.ObserveHistogram(metrics.HTTPRequestDuration, labels, duration.Seconds())
+}
+
+// recordSendMetrics counts a message sent to a domain, and its attachment bytes if it was accepted
+func (c *Client) recordSendMetrics(msg *message.Message, domain string, err error) {
+	if c.metrics == nil {
+		return
+	}
+	if err != nil {
+		c.metrics.AddCounter(metrics.MessagesFailed, metrics.Labels{"domain": domain}, 1)
+		return
+	}
+	c.metrics.AddCounter(metrics.MessagesSent, metrics.Labels{"domain": domain}, 1)
+	c.recordAttachmentBytes(msg, "sent")
+}
+
+// recordReceiveMetrics counts the attachment bytes of fetched messages
+func (c *Client) recordReceiveMetrics(messages []*message.Message) {
+	if c.metrics == nil {
+		return
+	}
+	for _, msg := range messages {
+		c.recordAttachmentBytes(msg, "received")
+	}
+}
+
+// recordAttachmentBytes adds the size of a message's attachments to the direction's counter
+func (c *Client) recordAttachmentBytes(msg *message.Message, direction string) {
+	var size int64
+	for _, attachment := range msg.Attachments {
+		size += attachment.Size
+	}
+	if size > 0 {
+		c.metrics.AddCounter(metrics.AttachmentBytes, metrics.Labels{"direction": direction}, float64(size))
+	}
+}
+
+// recordPollMetrics counts a message fetch by its result
+func (c *Client) recordPollMetrics(err error) {
+	if c.metrics == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	c.metrics.AddCounter(metrics.PollCycles, metrics.Labels{"result": result}, 1)
+}
+
+// recordWebSocketReconnect counts a WebSocket reconnection attempt
+func (c *Client) recordWebSocketReconnect(data interface{}) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.AddCounter(metrics.WebSocketReconnects, nil, 1)
+}
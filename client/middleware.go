@@ -0,0 +1,48 @@
+package client
+
+import (
+	"net/http"
+)
+
+// Middleware wraps the round trip of each HTTP request the client sends to
+// EMSG servers, e.g. to add tracing headers, record metrics, log requests or
+// apply custom auth. It sees every attempt, retries included, after the client
+// has signed the request, and the response before the client checks its status.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip returns f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Use appends middleware to the client's chain. The first middleware added is
+// the outermost: it sees requests first and responses last.
+func (c *Client) Use(middleware ...Middleware) {
+	c.middlewareMutex.Lock()
+	defer c.middlewareMutex.Unlock()
+	for _, m := range middleware {
+		if m != nil {
+			// Copy on write so requests in flight keep the chain they started with
+			c.middleware = append(c.middleware[:len(c.middleware):len(c.middleware)], m)
+		}
+	}
+}
+
+// do sends req to httpClient through the middleware chain
+func (c *Client) do(httpClient HTTPDoer, req *http.Request) (*http.Response, error) {
+	c.middlewareMutex.RLock()
+	chain := c.middleware
+	c.middlewareMutex.RUnlock()
+
+	if len(chain) == 0 {
+		return httpClient.Do(req)
+	}
+	var next http.RoundTripper = RoundTripperFunc(httpClient.Do)
+	for i := len(chain) - 1; i >= 0; i-- {
+		next = chain[i](next)
+	}
+	return next.RoundTrip(req)
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// SetGroupGuestModeration enables or disables holding guest messages for moderator approval
+func (c *Client) SetGroupGuestModeration(groupID, requesterAddress string, enabled bool) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return group.SetGuestModeration(enabled, requesterAddress)
+}
+
+// GetPendingGuestMessages returns a group's guest messages awaiting approval, oldest first
+func (c *Client) GetPendingGuestMessages(groupID string) ([]*groups.PendingGuestMessage, error) {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return group.PendingMessages(), nil
+}
+
+// ApproveGuestMessage releases a held guest message and sends it to the group. If
+// sending fails the message is returned to the queue.
+func (c *Client) ApproveGuestMessage(groupID, messageID, moderatorAddress string) error {
+	return c.ApproveGuestMessageContext(context.Background(), groupID, messageID, moderatorAddress)
+}
+
+// ApproveGuestMessageContext releases a held guest message and sends it to the
+// group within ctx. If sending fails the message is returned to the queue.
+func (c *Client) ApproveGuestMessageContext(ctx context.Context, groupID, messageID, moderatorAddress string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	pending, err := group.ApproveMessage(messageID, moderatorAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := c.sendMessage(ctx, pending.Message, true); err != nil {
+		if requeueErr := group.SubmitForModeration(pending.Message); requeueErr != nil {
+			c.logger.Error("failed to return message to the moderation queue", "message_id", messageID, "error", requeueErr)
+		}
+		return fmt.Errorf("failed to send approved message: %w", err)
+	}
+
+	return nil
+}
+
+// RejectGuestMessage discards a held guest message and tells its author why
+func (c *Client) RejectGuestMessage(groupID, messageID, moderatorAddress, reason string) error {
+	group, err := c.getManagedGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	pending, err := group.RejectMessage(messageID, moderatorAddress)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"message_id": messageID,
+		"moderator":  moderatorAddress,
+		"reason":     reason,
+		"action":     "guest_message_rejected",
+	}
+	c.sendModerationNotice(groupID, "guest_message_rejected", moderatorAddress, []string{pending.Author}, data)
+
+	return nil
+}
+
+// holdForModeration queues a guest's message if its group moderates guests. It
+// returns true if the message was held instead of sent.
+func (c *Client) holdForModeration(msg *message.Message) (bool, error) {
+	if c.groupManager == nil || msg.GroupID == "" || msg.Type != "" {
+		return false, nil
+	}
+
+	group, err := c.groupManager.GetGroup(msg.GroupID)
+	if err != nil || !group.RequiresModeration(msg.From) {
+		return false, nil
+	}
+
+	if err := group.SubmitForModeration(msg); err != nil {
+		return false, fmt.Errorf("failed to hold message for moderation: %w", err)
+	}
+
+	moderators := group.Moderators()
+	if c.notificationManager != nil {
+		if err := c.notificationManager.NotifyModerationRequested(msg, msg.GroupID, moderators); err != nil {
+			c.logger.Warn("failed to notify moderation request", "message_id", msg.MessageID, "error", err)
+		}
+	}
+
+	data := map[string]any{
+		"message_id": msg.MessageID,
+		"author":     msg.From,
+		"action":     "moderation_requested",
+	}
+	c.sendModerationNotice(msg.GroupID, "moderation_requested", msg.From, moderators, data)
+
+	return true, nil
+}
+
+// sendModerationNotice sends a group system message to specific members, logging failures
+func (c *Client) sendModerationNotice(groupID, action, actor string, recipients []string, data map[string]any) {
+	if len(recipients) == 0 || c.GetKeyPair() == nil {
+		return
+	}
+
+	msg, err := groups.CreateGroupMessage(groupID, action, actor, data)
+	if err != nil {
+		c.logger.Warn("failed to create moderation message", "action", action, "error", err)
+		return
+	}
+	msg.To = recipients
+
+	if err := c.sendMessage(context.Background(), msg, true); err != nil {
+		c.logger.Warn("failed to send moderation message", "action", action, "error", err)
+	}
+}
+
+// getManagedGroup looks up a group in the local group manager
+func (c *Client) getManagedGroup(groupID string) (*groups.Group, error) {
+	if c.groupManager == nil {
+		return nil, fmt.Errorf("group management not enabled")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	return group, nil
+}
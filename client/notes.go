@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// SendNoteToSelf sends a note to the user's own address
+func (c *Client) SendNoteToSelf(address, body string) (*message.Message, error) {
+	return c.SendNoteToSelfContext(context.Background(), address, body)
+}
+
+// SendNoteToSelfContext sends a note to the user's own address within ctx. The
+// note is saved to the local message store before it is sent, and the user's
+// other devices receive it like any other message. If the server cannot be
+// reached the note is still kept locally and, when an outbox is configured,
+// queued so it syncs later.
+func (c *Client) SendNoteToSelfContext(ctx context.Context, address, body string) (*message.Message, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	msg, err := c.ComposeMessage().From(address).To(address).Body(body).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build note: %w", err)
+	}
+
+	sendErr := c.sendMessage(ctx, msg, false)
+	if sendErr == nil {
+		return msg, nil
+	}
+
+	if _, err := c.messageStore.Get(msg.MessageID); err != nil || c.outbox == nil {
+		return nil, sendErr
+	}
+	if err := c.EnqueueMessage(msg); err != nil {
+		c.logger.Warn("failed to queue note for sync", "message_id", msg.MessageID, "error", err)
+		return nil, sendErr
+	}
+	return msg, nil
+}
+
+// GetNotesToSelf returns the notes the address has sent to itself, oldest first.
+// Notes written on other devices are included once they have been fetched.
+func (c *Client) GetNotesToSelf(address string) ([]*message.Message, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	self := utils.NormalizeEMSGAddress(address)
+	notes, err := store.LoadOrdered(c.messageStore, func(msg *message.Message) bool {
+		return msg.IsNoteToSelf() && utils.NormalizeEMSGAddress(msg.From) == self
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// persistNote saves a signed note to the local message store
+func (c *Client) persistNote(msg *message.Message) error {
+	if c.messageStore == nil || msg.MessageID == "" {
+		return nil
+	}
+	if err := c.messageStore.Save(msg); err != nil {
+		return fmt.Errorf("failed to save note locally: %w", err)
+	}
+	return nil
+}
+
+// tracksDelivery reports whether delivery of the message should be tracked.
+// Notes to self are never tracked since there is no one else to deliver to.
+func (c *Client) tracksDelivery(msg *message.Message) bool {
+	return c.deliveryTracker != nil && !msg.IsNoteToSelf()
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// connectivityMonitor tracks whether the client is online and, while it is
+// not, checks for the network coming back
+type connectivityMonitor struct {
+	interval time.Duration
+	check    func(ctx context.Context) error // Custom reachability check (nil = ping a server)
+
+	stateMutex   sync.Mutex
+	offline      bool
+	offlineSince time.Time
+
+	mutex   sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wake    chan struct{}
+}
+
+func newConnectivityMonitor(interval time.Duration, check func(ctx context.Context) error) *connectivityMonitor {
+	return &connectivityMonitor{
+		interval: interval,
+		check:    check,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// IsOnline reports whether the client is online. It always is without
+// OfflineMode; with it, the client goes offline when a send fails with a
+// network error or NetworkChanged reports the network gone.
+func (c *Client) IsOnline() bool {
+	if c.connectivity == nil {
+		return true
+	}
+
+	c.connectivity.stateMutex.Lock()
+	defer c.connectivity.stateMutex.Unlock()
+	return !c.connectivity.offline
+}
+
+// NetworkChanged passes on a hint from the operating system that the network
+// went away or came back. Losing it takes the client offline at once; regaining
+// it makes a running connectivity monitor check now, or otherwise takes the
+// client back online and wakes the outbox sender.
+func (c *Client) NetworkChanged(available bool) {
+	if c.connectivity == nil {
+		return
+	}
+	if !available {
+		c.goOffline(errors.New("network unavailable"))
+		return
+	}
+
+	if c.IsConnectivityMonitorRunning() {
+		wakeWorker(c.connectivity.wake)
+		return
+	}
+	if c.goOnline() && c.IsOutboxSenderRunning() {
+		wakeWorker(c.outbox.wake)
+	}
+}
+
+// CheckConnectivity checks whether an offline client can reach the network
+// again, taking it back online and sending everything queued in the outbox if
+// it can. The check is Config.ConnectivityCheck, or otherwise a ping of the
+// server of the WebSocket or polled address, or of the first queued recipient.
+// It returns the error that kept or took the client offline.
+func (c *Client) CheckConnectivity(ctx context.Context) error {
+	if c.connectivity == nil {
+		return fmt.Errorf("offline mode not enabled")
+	}
+
+	if err := c.checkReachable(ctx); err != nil {
+		if ctx.Err() == nil {
+			c.goOffline(err)
+		}
+		return err
+	}
+	if !c.goOnline() {
+		return nil
+	}
+
+	// Messages queued while offline are sent now rather than at their backed-off time
+	if _, err := c.drainOutbox(ctx, true); err != nil && ctx.Err() == nil {
+		c.logger.Warn("failed to send queued messages after going online", "error", err)
+	}
+	return nil
+}
+
+// StartConnectivityMonitor starts checking every ConnectivityInterval whether an
+// offline client is back online, sending the queued messages once it is
+func (c *Client) StartConnectivityMonitor() error {
+	if c.connectivity == nil {
+		return fmt.Errorf("offline mode not enabled")
+	}
+
+	cm := c.connectivity
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cm.running {
+		return fmt.Errorf("connectivity monitor is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm.cancel = cancel
+	cm.done = make(chan struct{})
+	cm.running = true
+
+	go c.connectivityLoop(ctx, cm.done)
+	return nil
+}
+
+// StopConnectivityMonitor stops the connectivity monitor, waiting for a check in progress to finish
+func (c *Client) StopConnectivityMonitor() {
+	if c.connectivity == nil {
+		return
+	}
+
+	cm := c.connectivity
+	cm.mutex.Lock()
+	if !cm.running {
+		cm.mutex.Unlock()
+		return
+	}
+	cm.cancel()
+	cm.running = false
+	done := cm.done
+	cm.mutex.Unlock()
+
+	<-done
+}
+
+// IsConnectivityMonitorRunning returns true if the connectivity monitor is running
+func (c *Client) IsConnectivityMonitorRunning() bool {
+	if c.connectivity == nil {
+		return false
+	}
+
+	c.connectivity.mutex.Lock()
+	defer c.connectivity.mutex.Unlock()
+	return c.connectivity.running
+}
+
+// connectivityLoop checks whether the client is back online on every tick and
+// whenever woken, for as long as it is offline
+func (c *Client) connectivityLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.connectivity.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.connectivity.wake:
+		}
+
+		if c.IsOnline() {
+			continue
+		}
+		if err := c.CheckConnectivity(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Debug("still offline", "error", err)
+		}
+	}
+}
+
+// checkReachable runs the configured connectivity check, or pings a server the
+// client talks to. Any answer from the server counts, even an error status.
+func (c *Client) checkReachable(ctx context.Context) error {
+	if c.connectivity.check != nil {
+		return c.connectivity.check(ctx)
+	}
+
+	domain := c.connectivityDomain()
+	if domain == "" {
+		// Nothing to reach; sending the queue decides
+		return nil
+	}
+	if _, err := c.PingContext(ctx, domain); err != nil && isNetworkError(err) {
+		return err
+	}
+	return nil
+}
+
+// connectivityDomain returns the domain of the WebSocket or polled address, or
+// of the first recipient queued in the outbox, or "" if there is none
+func (c *Client) connectivityDomain() string {
+	address := c.webSocketAddress
+	if address == "" && c.messagePoller != nil {
+		address = c.messagePoller.Address()
+	}
+	if address == "" && c.outbox != nil {
+		if entries, err := c.outbox.store.List(); err == nil && len(entries) > 0 {
+			if recipients := entries[0].Message.GetRecipients(); len(recipients) > 0 {
+				address = recipients[0]
+			}
+		}
+	}
+
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return ""
+	}
+	return addr.Domain
+}
+
+// goOffline takes the client offline, notifying EventOffline if it was online
+func (c *Client) goOffline(cause error) {
+	cm := c.connectivity
+	cm.stateMutex.Lock()
+	if cm.offline {
+		cm.stateMutex.Unlock()
+		return
+	}
+	cm.offline = true
+	cm.offlineSince = time.Now()
+	cm.stateMutex.Unlock()
+
+	c.logger.Warn("client went offline, queueing sends", "error", cause)
+	if c.notificationManager != nil {
+		if err := c.notificationManager.NotifyOffline(cause.Error()); err != nil {
+			c.logger.Warn("failed to notify going offline", "error", err)
+		}
+	}
+}
+
+// goOnline takes the client back online, notifying EventOnline. It returns
+// false if the client was online already.
+func (c *Client) goOnline() bool {
+	cm := c.connectivity
+	cm.stateMutex.Lock()
+	if !cm.offline {
+		cm.stateMutex.Unlock()
+		return false
+	}
+	cm.offline = false
+	offlineFor := time.Since(cm.offlineSince)
+	cm.stateMutex.Unlock()
+
+	c.logger.Info("client is back online", "offline_for", offlineFor)
+	if c.notificationManager != nil {
+		if err := c.notificationManager.NotifyOnline(offlineFor); err != nil {
+			c.logger.Warn("failed to notify going online", "error", err)
+		}
+	}
+	return true
+}
+
+// sendOrQueueOffline sends a message, or queues it in the outbox when the client
+// is offline or goes offline because the send failed with a network error
+func (c *Client) sendOrQueueOffline(ctx context.Context, msg *message.Message) (*SendResult, error) {
+	if !c.IsOnline() {
+		return c.queueOffline(msg)
+	}
+
+	// Send a copy so the queued message is not signed by the failed attempt
+	queued := msg.Clone()
+	result, err := c.sendMessageResult(ctx, msg, true)
+	if err == nil || ctx.Err() != nil || !isNetworkError(err) {
+		return result, err
+	}
+
+	c.goOffline(err)
+	queued.Sequence = msg.Sequence
+	return c.queueOffline(queued)
+}
+
+// queueOffline adds a message to the outbox to be sent once the client is online
+func (c *Client) queueOffline(msg *message.Message) (*SendResult, error) {
+	if err := c.EnqueueMessage(msg); err != nil {
+		return nil, err
+	}
+	return &SendResult{MessageID: msg.MessageID, Failed: make(map[string]error), Queued: true}, nil
+}
+
+// watchWebSocketConnectivity takes the client offline when the WebSocket
+// connection is lost and checks for being back online once it reconnects
+func (c *Client) watchWebSocketConnectivity(ws *websocket.WebSocketClient) {
+	ws.RegisterEventHandler(websocket.EventDisconnected, func(data interface{}) {
+		// Disconnecting on purpose reports no error
+		if err, ok := data.(error); ok && err != nil && isNetworkError(err) {
+			c.goOffline(err)
+		}
+	})
+	ws.RegisterEventHandler(websocket.EventConnected, func(interface{}) {
+		c.NetworkChanged(true)
+	})
+}
+
+// isNetworkError reports whether err means the network, rather than a server
+// or the request, is at fault: a failed dial, a timeout or a DNS lookup that
+// did not get an answer
+func isNetworkError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
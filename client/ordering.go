@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// GetConversation returns the stored direct messages between address and peer in
+// conversation order, oldest first. Group messages are not included.
+func (c *Client) GetConversation(address, peer string) ([]*message.Message, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	self := utils.NormalizeEMSGAddress(address)
+	other := utils.NormalizeEMSGAddress(peer)
+	return store.LoadOrdered(c.messageStore, func(msg *message.Message) bool {
+		if msg.GroupID != "" {
+			return false
+		}
+		from := utils.NormalizeEMSGAddress(msg.From)
+		switch from {
+		case self:
+			return hasRecipient(msg, other)
+		case other:
+			return hasRecipient(msg, self)
+		}
+		return false
+	})
+}
+
+// GetLastSequence returns the most recent sequence number the client has assigned or received
+func (c *Client) GetLastSequence() int64 {
+	return c.sequence.Last()
+}
+
+// hasRecipient reports whether address is among the message's recipients
+func hasRecipient(msg *message.Message, address string) bool {
+	for _, recipient := range msg.GetRecipients() {
+		if utils.NormalizeEMSGAddress(recipient) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// observeSequences advances the sequence clock past received messages so the
+// client's next messages are ordered after them
+func (c *Client) observeSequences(messages []*message.Message) {
+	for _, msg := range messages {
+		c.sequence.Observe(msg)
+	}
+}
+
+// observeWebSocketSequence advances the sequence clock past a message received over the WebSocket
+func (c *Client) observeWebSocketSequence(data interface{}) {
+	if msg, ok := data.(*message.Message); ok {
+		c.sequence.Observe(msg)
+	}
+}
@@ -0,0 +1,332 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// outboxSender drains the durable outbox in the background
+type outboxSender struct {
+	store         store.OutboxStore
+	queueOutgoing bool
+	interval      time.Duration
+	retryStrategy *delivery.RetryStrategy
+
+	drainMutex sync.Mutex // Serializes drains so a queued message is never sent twice at once
+	mutex      sync.Mutex
+	running    bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	wake       chan struct{}
+}
+
+func newOutboxSender(outbox store.OutboxStore, queueOutgoing bool, interval time.Duration, retryStrategy *delivery.RetryStrategy) *outboxSender {
+	if retryStrategy == nil {
+		retryStrategy = delivery.DefaultRetryStrategy()
+	}
+	return &outboxSender{
+		store:         outbox,
+		queueOutgoing: queueOutgoing,
+		interval:      interval,
+		retryStrategy: retryStrategy,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// EnqueueMessage adds a message to the outbox. It is sent by the background
+// sender or the next FlushOutbox call, and stays queued across restarts when
+// the outbox store is durable.
+func (c *Client) EnqueueMessage(msg *message.Message) error {
+	if c.outbox == nil {
+		return fmt.Errorf("outbox not enabled")
+	}
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if msg.MessageID == "" {
+		return &message.ValidationError{Field: "message_id", Err: fmt.Errorf("invalid message: message ID is required")}
+	}
+
+	// Wall-clock times keep the schedule right after the system slept, during
+	// which the monotonic clock stops
+	now := time.Now().Round(0)
+	entry := &store.OutboxEntry{
+		Message:     msg,
+		EnqueuedAt:  now,
+		NextAttempt: now,
+		Encryption:  msg.EncryptionDecision,
+	}
+	if err := c.outbox.store.Put(entry); err != nil {
+		return fmt.Errorf("failed to enqueue message: %w", err)
+	}
+
+	if c.tracksDelivery(msg) {
+		c.deliveryTracker.TrackMessage(msg)
+	}
+
+	// Let a running sender pick the message up without waiting for the next tick
+	select {
+	case c.outbox.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// ListOutbox returns the queued messages, oldest first
+func (c *Client) ListOutbox() ([]*store.OutboxEntry, error) {
+	if c.outbox == nil {
+		return nil, fmt.Errorf("outbox not enabled")
+	}
+	return c.outbox.store.List()
+}
+
+// RemoveFromOutbox cancels a queued message that has not been sent yet
+func (c *Client) RemoveFromOutbox(messageID string) error {
+	if c.outbox == nil {
+		return fmt.Errorf("outbox not enabled")
+	}
+
+	c.outbox.drainMutex.Lock()
+	defer c.outbox.drainMutex.Unlock()
+	return c.outbox.store.Remove(messageID)
+}
+
+// FlushOutbox attempts to send every queued message now, ignoring retry backoff
+func (c *Client) FlushOutbox() (int, error) {
+	return c.FlushOutboxContext(context.Background())
+}
+
+// FlushOutboxContext attempts to send every queued message now, ignoring retry
+// backoff. It returns the number of messages sent; failed messages stay queued
+// until the retry strategy gives up on them.
+func (c *Client) FlushOutboxContext(ctx context.Context) (int, error) {
+	if c.outbox == nil {
+		return 0, fmt.Errorf("outbox not enabled")
+	}
+	return c.drainOutbox(ctx, true)
+}
+
+// StartOutboxSender starts draining the outbox in the background
+func (c *Client) StartOutboxSender() error {
+	if c.outbox == nil {
+		return fmt.Errorf("outbox not enabled")
+	}
+
+	ob := c.outbox
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if ob.running {
+		return fmt.Errorf("outbox sender is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ob.cancel = cancel
+	ob.done = make(chan struct{})
+	ob.running = true
+
+	go c.outboxLoop(ctx, ob.done)
+	return nil
+}
+
+// StopOutboxSender stops the background sender, waiting for an in-flight drain to finish
+func (c *Client) StopOutboxSender() {
+	if c.outbox == nil {
+		return
+	}
+
+	ob := c.outbox
+	ob.mutex.Lock()
+	if !ob.running {
+		ob.mutex.Unlock()
+		return
+	}
+	ob.cancel()
+	ob.running = false
+	done := ob.done
+	ob.mutex.Unlock()
+
+	<-done
+}
+
+// IsOutboxSenderRunning returns true if the background sender is running
+func (c *Client) IsOutboxSenderRunning() bool {
+	if c.outbox == nil {
+		return false
+	}
+
+	c.outbox.mutex.Lock()
+	defer c.outbox.mutex.Unlock()
+	return c.outbox.running
+}
+
+// outboxLoop drains due messages on start, on every tick and whenever a message is enqueued
+func (c *Client) outboxLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.outbox.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.drainOutbox(ctx, false); err != nil && ctx.Err() == nil {
+			c.logger.Warn("outbox drain failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.outbox.wake:
+		}
+	}
+}
+
+// drainOutbox sends queued messages whose next attempt is due, or all of them
+// when force is set. Failed messages are rescheduled with the delivery retry
+// strategy and dropped once it gives up. In offline mode nothing is sent unless
+// forced while the client is offline, and a network error takes it offline and
+// stops the drain without counting an attempt against the rest.
+func (c *Client) drainOutbox(ctx context.Context, force bool) (int, error) {
+	ob := c.outbox
+	ob.drainMutex.Lock()
+	defer ob.drainMutex.Unlock()
+
+	if !force && !c.IsOnline() {
+		return 0, nil
+	}
+
+	entries, err := ob.store.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list outbox: %w", err)
+	}
+
+	sent, failed := 0, 0
+	var firstErr error
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		if !force && time.Now().Round(0).Before(entry.NextAttempt) {
+			continue
+		}
+
+		msg := entry.Message
+		if msg.EncryptionDecision == nil {
+			msg.EncryptionDecision = entry.Encryption
+		}
+		c.trackOutboxEntry(msg)
+
+		// A message that self-destructed while queued is never sent
+		if msg.IsExpired(time.Now()) {
+			c.dropExpiredOutboxEntry(ctx, msg)
+			continue
+		}
+
+		// Send a copy so signing and envelope fields never leak into the queued entry.
+		// Entries left by a partial delivery only go to the domains that were missed.
+		result, err := c.deliverMessage(ctx, msg.Clone(), false, entry.Domains)
+		if err == nil && len(result.unresolved) > 0 {
+			entry.Domains = sortedCopy(result.unresolved)
+			err = result.failureError()
+		} else if err == nil {
+			if err := ob.store.Remove(msg.MessageID); err != nil && !errors.Is(err, store.ErrNotFound) {
+				c.logger.Warn("failed to remove sent message from outbox", "message_id", msg.MessageID, "error", err)
+			}
+			if c.tracksDelivery(msg) {
+				c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusSent, "")
+			}
+			sent++
+			continue
+		}
+		if ctx.Err() != nil {
+			// Cancellation is not the message's fault; leave the entry as it was
+			return sent, ctx.Err()
+		}
+		if c.connectivity != nil && isNetworkError(err) {
+			// Neither is losing the network; the rest waits until the client is back online
+			c.goOffline(err)
+			return sent, fmt.Errorf("went offline after sending %d queued messages: %w", sent, err)
+		}
+
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+		if storeErr := c.rescheduleOutboxEntry(ctx, entry, err); storeErr != nil {
+			return sent, storeErr
+		}
+	}
+
+	if failed > 0 {
+		return sent, fmt.Errorf("failed to send %d of %d queued messages: %w", failed, sent+failed, firstErr)
+	}
+	return sent, nil
+}
+
+// rescheduleOutboxEntry records a failed attempt, dropping the message when it should not be retried
+func (c *Client) rescheduleOutboxEntry(ctx context.Context, entry *store.OutboxEntry, sendErr error) error {
+	ob := c.outbox
+	strategy := ob.retryStrategy
+	msg := entry.Message
+	reason := delivery.ClassifyError(sendErr)
+
+	entry.Attempts++
+	entry.LastError = sendErr.Error()
+
+	status := delivery.StatusRetrying
+	switch {
+	case time.Now().Round(0).Sub(entry.EnqueuedAt) > strategy.ExpirationTime:
+		status = delivery.StatusExpired
+	case reason.IsPermanent() || entry.Attempts >= strategy.MaxRetries:
+		status = delivery.StatusFailed
+	case !strategy.RetryOnFailure && !(strategy.RetryOnTimeout && reason == delivery.FailureTimeout):
+		status = delivery.StatusFailed
+	}
+
+	if c.tracksDelivery(msg) {
+		c.deliveryTracker.UpdateDeliveryFailureContext(ctx, msg.MessageID, status, reason, entry.LastError)
+	}
+
+	if status != delivery.StatusRetrying {
+		c.logger.Error("giving up on queued message", "message_id", msg.MessageID, "attempts", entry.Attempts, "status", status, "error", sendErr)
+		if err := ob.store.Remove(msg.MessageID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("failed to remove message %s from outbox: %w", msg.MessageID, err)
+		}
+		return nil
+	}
+
+	entry.NextAttempt = time.Now().Round(0).Add(strategy.RetryDelay(entry.Attempts))
+	if err := ob.store.Put(entry); err != nil {
+		return fmt.Errorf("failed to reschedule message %s: %w", msg.MessageID, err)
+	}
+	return nil
+}
+
+// dropExpiredOutboxEntry removes a queued message whose expiry passed, marking its delivery expired
+func (c *Client) dropExpiredOutboxEntry(ctx context.Context, msg *message.Message) {
+	c.logger.Info("dropping expired queued message", "message_id", msg.MessageID, "expired_at", msg.ExpiryTime())
+	if err := c.outbox.store.Remove(msg.MessageID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.logger.Warn("failed to remove expired message from outbox", "message_id", msg.MessageID, "error", err)
+	}
+	if c.tracksDelivery(msg) {
+		c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusExpired, message.ErrMessageExpired.Error())
+	}
+}
+
+// trackOutboxEntry starts tracking a queued message that was enqueued before a restart
+func (c *Client) trackOutboxEntry(msg *message.Message) {
+	if !c.tracksDelivery(msg) {
+		return
+	}
+	if _, err := c.deliveryTracker.GetDeliveryReceipt(msg.MessageID); err != nil {
+		c.deliveryTracker.TrackMessage(msg)
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// HTTPDoer sends the client's HTTP requests. *http.Client satisfies it; custom
+// implementations can route traffic through a proxy or stub responses in tests.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DomainOverride holds settings that replace the client defaults when talking
+// to servers of a matching domain. Zero-valued fields fall back to the defaults.
+type DomainOverride struct {
+	Timeout       time.Duration  // HTTP timeout for requests to the domain
+	RetryStrategy *RetryStrategy // Retry strategy for requests to the domain
+	TLSConfig     *tls.Config    // TLS settings for connections to the domain
+}
+
+// domainSettings is the effective HTTP configuration for a single domain
+type domainSettings struct {
+	httpClient    HTTPDoer
+	retryStrategy *RetryStrategy
+}
+
+// initDomainOverrides builds a dedicated HTTP client for each override.
+// Config validation guarantees the base client is an *http.Client whenever an
+// override needs to change its timeout or TLS settings.
+func (c *Client) initDomainOverrides(overrides map[string]*DomainOverride) {
+	c.domainOverrides = make(map[string]*domainSettings)
+
+	for pattern, override := range overrides {
+		if override == nil {
+			continue
+		}
+
+		httpClient := c.httpClient
+		if base, ok := c.httpClient.(*http.Client); ok && (override.Timeout > 0 || override.TLSConfig != nil) {
+			domainClient := *base
+			if override.Timeout > 0 {
+				domainClient.Timeout = override.Timeout
+			}
+			if override.TLSConfig != nil {
+				transport := http.DefaultTransport.(*http.Transport).Clone()
+				if baseTransport, ok := base.Transport.(*http.Transport); ok {
+					// Keep the base transport's proxy and dialer settings
+					transport = baseTransport.Clone()
+				}
+				transport.TLSClientConfig = override.TLSConfig
+				domainClient.Transport = transport
+			}
+			httpClient = &domainClient
+		}
+
+		retryStrategy := c.retryStrategy
+		if override.RetryStrategy != nil {
+			retryStrategy = override.RetryStrategy
+		}
+
+		c.domainOverrides[strings.ToLower(strings.TrimSpace(pattern))] = &domainSettings{
+			httpClient:    httpClient,
+			retryStrategy: retryStrategy,
+		}
+	}
+}
+
+// settingsForDomain returns the HTTP settings for a domain. An exact pattern
+// wins over wildcards, and longer wildcard patterns win over shorter ones.
+func (c *Client) settingsForDomain(domain string) *domainSettings {
+	var best *domainSettings
+	bestPattern := ""
+
+	for pattern, settings := range c.domainOverrides {
+		if !utils.MatchDomainPattern(pattern, domain) {
+			continue
+		}
+		if best == nil || moreSpecificPattern(pattern, bestPattern) {
+			best = settings
+			bestPattern = pattern
+		}
+	}
+
+	if best != nil {
+		return best
+	}
+
+	return &domainSettings{
+		httpClient:    c.httpClient,
+		retryStrategy: c.retryStrategy,
+	}
+}
+
+// moreSpecificPattern reports whether pattern a is more specific than pattern b
+func moreSpecificPattern(a, b string) bool {
+	rank := func(pattern string) int {
+		switch {
+		case pattern == "*":
+			return 0
+		case strings.HasPrefix(pattern, "*."):
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	if rank(a) != rank(b) {
+		return rank(a) > rank(b)
+	}
+	return len(a) > len(b)
+}
+
+// GetDomainTimeout returns the effective HTTP timeout for a domain. It is zero
+// when a custom HTTPDoer manages its own timeouts.
+func (c *Client) GetDomainTimeout(domain string) time.Duration {
+	if httpClient, ok := c.settingsForDomain(domain).httpClient.(*http.Client); ok {
+		return httpClient.Timeout
+	}
+	return 0
+}
+
+// GetDomainRetryStrategy returns the effective retry strategy for a domain
+func (c *Client) GetDomainRetryStrategy(domain string) *RetryStrategy {
+	return c.settingsForDomain(domain).retryStrategy
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/pagination"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// nextCursorHeader carries the cursor of the next page of a paginated list response
+const nextCursorHeader = "X-EMSG-Next-Cursor"
+
+// defaultMemberPageSize is used by GroupMembersIterator when no page size is given
+const defaultMemberPageSize = 50
+
+// GetMessagesPage retrieves one page of messages starting at cursor (empty for the first
+// page). limit <= 0 lets the server choose the page size. Servers without pagination
+// return every message in a single page.
+func (c *Client) GetMessagesPage(ctx context.Context, address, cursor string, limit int) (*pagination.Page[*message.Message], error) {
+	query := url.Values{}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	addr, err := utils.ParseAddress(address)
+	if err != nil {
+		return nil, invalidAddress("address", err)
+	}
+
+	messages, next, err := c.fetchMessages(ctx, addr, query)
+	if err != nil {
+		return nil, err
+	}
+	return &pagination.Page[*message.Message]{Items: messages, NextCursor: next}, nil
+}
+
+// MessagesIterator iterates over all messages for an address, fetching pageSize messages at a time
+func (c *Client) MessagesIterator(address string, pageSize int) *pagination.Iterator[*message.Message] {
+	return pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[*message.Message], error) {
+		return c.GetMessagesPage(ctx, address, cursor, pageSize)
+	}, nil)
+}
+
+// GroupMembersIterator iterates over the members of a group matching filter, ordered by address
+func (c *Client) GroupMembersIterator(groupID string, filter *groups.MemberFilter, pageSize int) *pagination.Iterator[*groups.GroupMember] {
+	if pageSize <= 0 {
+		pageSize = defaultMemberPageSize
+	}
+
+	return pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[*groups.GroupMember], error) {
+		offset, err := pagination.ParseOffsetCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := c.GetGroupMembersPage(groupID, offset, pageSize, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &pagination.Page[*groups.GroupMember]{Items: page.Members}
+		if page.HasMore {
+			result.NextCursor = pagination.OffsetCursor(offset + len(page.Members))
+		}
+		return result, nil
+	}, nil)
+}
+
+// GroupsIterator iterates over all groups, ordered by ID
+func (c *Client) GroupsIterator(pageSize int) *pagination.Iterator[*groups.Group] {
+	fetch := pagination.SliceFetcher(func() []*groups.Group {
+		list := c.ListGroups()
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list
+	}, pageSize)
+
+	return pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[*groups.Group], error) {
+		if c.groupManager == nil {
+			return nil, fmt.Errorf("group management not enabled")
+		}
+		return fetch(ctx, cursor)
+	}, nil)
+}
+
+// DeliveryReceiptsIterator iterates over tracked delivery receipts, ordered by message ID
+func (c *Client) DeliveryReceiptsIterator(pageSize int) *pagination.Iterator[*delivery.DeliveryReceipt] {
+	fetch := pagination.SliceFetcher(func() []*delivery.DeliveryReceipt {
+		receipts := c.deliveryTracker.GetAllReceipts()
+		sort.Slice(receipts, func(i, j int) bool { return receipts[i].MessageID < receipts[j].MessageID })
+		return receipts
+	}, pageSize)
+
+	return pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[*delivery.DeliveryReceipt], error) {
+		if c.deliveryTracker == nil {
+			return nil, fmt.Errorf("delivery tracking not enabled")
+		}
+		return fetch(ctx, cursor)
+	}, nil)
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/tracing"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// SendResult reports what happened to each recipient of a sent message
+type SendResult struct {
+	MessageID      string
+	Delivered      []string         // Recipients whose servers accepted the message
+	Failed         map[string]error // Recipients whose domain could not be resolved, with the error
+	RetryScheduled bool             // The failed recipients were queued in the outbox for automatic retry
+	Queued         bool             // The whole message was added to the outbox instead of being sent
+	Held           bool             // The message awaits a group moderator's approval
+
+	unresolved []string // Domains that failed to resolve
+}
+
+// Partial returns true if some recipients were reached and others were not
+func (r *SendResult) Partial() bool {
+	return len(r.Delivered) > 0 && len(r.Failed) > 0
+}
+
+// addUnresolved records the recipients of a domain that failed to resolve
+func (r *SendResult) addUnresolved(domain string, recipients []string, err error) {
+	r.unresolved = append(r.unresolved, domain)
+	for _, recipient := range recipients {
+		r.Failed[recipient] = err
+	}
+}
+
+// failureError joins the distinct errors of the failed recipients, ordered by message
+func (r *SendResult) failureError() error {
+	seen := make(map[string]bool)
+	var errs []error
+	for _, err := range r.Failed {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// failureSummary describes the errors of the failed recipients on one line
+func (r *SendResult) failureSummary() string {
+	return strings.ReplaceAll(r.failureError().Error(), "\n", "; ")
+}
+
+// SendMessageWithResult sends an EMSG message like SendMessageContext and reports
+// the outcome per recipient. With Config.PartialDelivery set, recipients whose
+// domain fails to resolve do not fail the send while others were reached; they
+// are listed in Failed and retried from the outbox.
+func (c *Client) SendMessageWithResult(ctx context.Context, msg *message.Message) (result *SendResult, err error) {
+	ctx, span := tracing.Start(c.tracer, ctx, tracing.SpanSendMessage,
+		tracing.String(tracing.AttrMessageID, msg.MessageID),
+		tracing.Int64(tracing.AttrRecipients, int64(len(msg.GetRecipients()))))
+	defer func() { tracing.End(span, err) }()
+
+	// Banned and muted members cannot send, not even for moderation
+	if err := c.checkGroupRestrictions(msg); err != nil {
+		return nil, err
+	}
+
+	// Guest messages to moderated groups wait for a moderator's approval
+	if held, err := c.holdForModeration(msg); held || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return &SendResult{MessageID: msg.MessageID, Failed: make(map[string]error), Held: true}, nil
+	}
+
+	if c.outbox != nil && c.outbox.queueOutgoing {
+		if err := c.EnqueueMessage(msg); err != nil {
+			return nil, err
+		}
+		return &SendResult{MessageID: msg.MessageID, Failed: make(map[string]error), Queued: true}, nil
+	}
+	if c.connectivity != nil {
+		return c.sendOrQueueOffline(ctx, msg)
+	}
+	return c.sendMessageResult(ctx, msg, true)
+}
+
+// sendMessageResult delivers a message and queues any recipients a partial
+// delivery left out for retry
+func (c *Client) sendMessageResult(ctx context.Context, msg *message.Message, track bool) (*SendResult, error) {
+	result, err := c.deliverMessage(ctx, msg, track, nil)
+	if err != nil || len(result.unresolved) == 0 {
+		return result, err
+	}
+
+	if err := c.scheduleUnresolved(msg, result); err != nil {
+		c.logger.Error("failed to queue unresolved recipients for retry", "message_id", msg.MessageID, "error", err)
+	} else {
+		result.RetryScheduled = true
+	}
+	return result, nil
+}
+
+// scheduleUnresolved queues a message in the outbox for the domains it could not
+// be delivered to, counting the failed resolution as its first attempt
+func (c *Client) scheduleUnresolved(msg *message.Message, result *SendResult) error {
+	if c.outbox == nil {
+		return fmt.Errorf("outbox not enabled")
+	}
+
+	now := time.Now()
+	entry := &store.OutboxEntry{
+		Message:     msg.Clone(),
+		EnqueuedAt:  now,
+		Attempts:    1,
+		NextAttempt: now.Add(c.outbox.retryStrategy.RetryDelay(1)),
+		LastError:   result.failureSummary(),
+		Encryption:  msg.EncryptionDecision,
+		Domains:     sortedCopy(result.unresolved),
+	}
+
+	c.outbox.drainMutex.Lock()
+	defer c.outbox.drainMutex.Unlock()
+	return c.outbox.store.Put(entry)
+}
+
+// recordRecipientDelivery updates the delivery receipt with which recipients were reached
+func (c *Client) recordRecipientDelivery(msg *message.Message, result *SendResult) {
+	if !c.tracksDelivery(msg) {
+		return
+	}
+
+	for recipient, err := range result.Failed {
+		c.deliveryTracker.MarkRecipientsUndelivered(msg.MessageID, []string{recipient}, err.Error())
+	}
+	if len(result.Delivered) > 0 {
+		c.deliveryTracker.MarkRecipientsDelivered(msg.MessageID, result.Delivered)
+	}
+}
+
+// recipientsInDomain returns the message's recipients on a domain
+func recipientsInDomain(msg *message.Message, domain string) []string {
+	var recipients []string
+	for _, recipient := range msg.GetRecipients() {
+		if recipientDomain, err := utils.ExtractDomainFromEMSGAddress(recipient); err == nil && recipientDomain == domain {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// sortedCopy returns a sorted copy of values
+func sortedCopy(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// SendTyping tells a group's members whether the user connected over the WebSocket
+// is typing. Typing indicators only matter live, so nothing is sent while the
+// WebSocket is not connected.
+func (c *Client) SendTyping(groupID string, isTyping bool) error {
+	if !c.IsWebSocketConnected() {
+		return nil
+	}
+	return c.webSocketClient.SendTyping(c.webSocketAddress, groupID, isTyping)
+}
+
+// SetPresence announces the user's presence status. See SetPresenceContext.
+func (c *Client) SetPresence(status websocket.PresenceStatus) error {
+	return c.SetPresenceContext(context.Background(), status)
+}
+
+// SetPresenceContext announces the presence status of the user the WebSocket was
+// last connected for. The status goes over the WebSocket when it is connected and
+// to the user's server over HTTP otherwise, e.g. to appear away after disconnecting.
+func (c *Client) SetPresenceContext(ctx context.Context, status websocket.PresenceStatus) error {
+	if err := status.Validate(); err != nil {
+		return err
+	}
+	if c.webSocketAddress == "" {
+		return fmt.Errorf("no user to set presence for: connect the WebSocket first")
+	}
+
+	if c.IsWebSocketConnected() {
+		err := c.webSocketClient.SendPresence(c.webSocketAddress, status)
+		if err == nil {
+			return nil
+		}
+		c.logger.Warn("failed to send presence over WebSocket, using HTTP", "status", status, "error", err)
+	}
+	return c.setPresenceHTTP(ctx, c.webSocketAddress, status)
+}
+
+// setPresenceHTTP announces a presence status with a request to the user's server
+func (c *Client) setPresenceHTTP(ctx context.Context, address string, status websocket.PresenceStatus) error {
+	if c.GetKeyPair() == nil {
+		return fmt.Errorf("no key pair configured")
+	}
+	addr, err := utils.ParseAddress(address)
+	if err != nil {
+		return invalidAddress("address", err)
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain())
+	if err != nil {
+		return fmt.Errorf("failed to resolve domain: %w", err)
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"address": addr.String(),
+		"status":  status,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize presence: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/presence", serverInfo.URL)
+	return c.sendHTTPRequest(ctx, addr.Domain(), "PUT", endpoint, payload)
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// maxProofResponseSize caps how much of a server's send response is kept for a proof
+const maxProofResponseSize = 64 * 1024 // 64KB
+
+// proofRecord is the evidence collected for a single sent message
+type proofRecord struct {
+	message     *message.Message
+	senderKey   string
+	acceptances []*delivery.ServerAcceptance
+	receipts    map[string]*delivery.SignedReceipt // Keyed by normalized recipient address
+	recordedAt  time.Time
+}
+
+// proofRecorder holds the evidence for sent messages, keyed by message ID
+type proofRecorder struct {
+	records map[string]*proofRecord
+	limit   int
+	enabled bool
+	mutex   sync.Mutex
+}
+
+// replayBody lets an already-read response prefix be read again by AfterSend hooks
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// recordAcceptance stores a recipient server's acceptance of a signed message
+func (c *Client) recordAcceptance(msg *message.Message, domain string, resp *http.Response) {
+	recorder := c.deliveryProofs
+	if !recorder.enabled || msg.MessageID == "" || resp == nil {
+		return
+	}
+
+	var body []byte
+	if resp.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, maxProofResponseSize))
+		resp.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+	}
+
+	keyPair := c.GetKeyPair()
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	record, ok := recorder.records[msg.MessageID]
+	if !ok {
+		if dropped := recorder.evictOldest(); dropped != "" {
+			c.logger.Warn("delivery proof limit reached, dropped oldest record", "message_id", dropped)
+		}
+		record = &proofRecord{
+			message:    msg.Clone(),
+			senderKey:  keyPair.PublicKeyBase64(),
+			receipts:   make(map[string]*delivery.SignedReceipt),
+			recordedAt: time.Now(),
+		}
+		recorder.records[msg.MessageID] = record
+	}
+
+	record.acceptances = append(record.acceptances, &delivery.ServerAcceptance{
+		Domain:     domain,
+		StatusCode: resp.StatusCode,
+		Response:   string(body),
+		AcceptedAt: time.Now().Unix(),
+	})
+}
+
+// evictOldest drops the oldest record when the limit is reached, returning its
+// message ID. The caller holds the mutex.
+func (pr *proofRecorder) evictOldest() string {
+	if pr.limit <= 0 || len(pr.records) < pr.limit {
+		return ""
+	}
+
+	var oldestID string
+	var oldest time.Time
+	for id, record := range pr.records {
+		if oldestID == "" || record.recordedAt.Before(oldest) {
+			oldestID, oldest = id, record.recordedAt
+		}
+	}
+	delete(pr.records, oldestID)
+	return oldestID
+}
+
+// AddDeliveryReceipt attaches a recipient's signed receipt to the proof record of
+// a sent message. The receipt must verify and cover the message as it was sent.
+func (c *Client) AddDeliveryReceipt(receipt *delivery.SignedReceipt) error {
+	if receipt == nil {
+		return fmt.Errorf("receipt is nil")
+	}
+	if err := receipt.Verify(); err != nil {
+		return err
+	}
+
+	recorder := c.deliveryProofs
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	record, ok := recorder.records[receipt.MessageID]
+	if !ok {
+		return fmt.Errorf("no delivery proof recorded for message %s", receipt.MessageID)
+	}
+
+	isRecipient := false
+	for _, recipient := range record.message.GetRecipients() {
+		if utils.NormalizeEMSGAddress(recipient) == utils.NormalizeEMSGAddress(receipt.Recipient) {
+			isRecipient = true
+			break
+		}
+	}
+	if !isRecipient {
+		return fmt.Errorf("%s is not a recipient of message %s", receipt.Recipient, receipt.MessageID)
+	}
+
+	hash, err := delivery.MessageHash(record.message)
+	if err != nil {
+		return err
+	}
+	if receipt.MessageHash != hash {
+		return fmt.Errorf("receipt from %s covers different message content", receipt.Recipient)
+	}
+
+	record.receipts[utils.NormalizeEMSGAddress(receipt.Recipient)] = receipt
+	return nil
+}
+
+// CreateDeliveryReceipt signs a receipt for a received message on behalf of
+// recipient, for the sender to include in its delivery proof
+func (c *Client) CreateDeliveryReceipt(msg *message.Message, recipient string) (*delivery.SignedReceipt, error) {
+	return delivery.NewSignedReceipt(msg, recipient, c.GetKeyPair())
+}
+
+// GenerateDeliveryProof assembles the signed message, server acceptances and
+// signed recipient receipts recorded for a sent message into a bundle that can
+// be checked with delivery.VerifyDeliveryProof. RecordDeliveryProofs must be
+// enabled when the message is sent.
+func (c *Client) GenerateDeliveryProof(messageID string) (*delivery.DeliveryProof, error) {
+	recorder := c.deliveryProofs
+	if !recorder.enabled {
+		return nil, fmt.Errorf("delivery proofs not enabled")
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	record, ok := recorder.records[messageID]
+	if !ok {
+		return nil, fmt.Errorf("no delivery proof recorded for message %s", messageID)
+	}
+
+	hash, err := delivery.MessageHash(record.message)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &delivery.DeliveryProof{
+		Version:     delivery.DeliveryProofVersion,
+		Message:     record.message.Clone(),
+		SenderKey:   record.senderKey,
+		MessageHash: hash,
+		Acceptances: make([]*delivery.ServerAcceptance, 0, len(record.acceptances)),
+		GeneratedAt: time.Now().Unix(),
+	}
+	for _, acceptance := range record.acceptances {
+		copied := *acceptance
+		proof.Acceptances = append(proof.Acceptances, &copied)
+	}
+	for _, receipt := range record.receipts {
+		copied := *receipt
+		proof.Receipts = append(proof.Receipts, &copied)
+	}
+
+	return proof, nil
+}
+
+// recordWebSocketReceipt attaches signed receipts pushed over the WebSocket
+func (c *Client) recordWebSocketReceipt(data interface{}) {
+	receipt, ok := data.(*delivery.SignedReceipt)
+	if !ok || !c.deliveryProofs.enabled {
+		return
+	}
+	if err := c.AddDeliveryReceipt(receipt); err != nil {
+		c.logger.Warn("rejected delivery receipt", "recipient", receipt.Recipient, "message_id", receipt.MessageID, "error", err)
+	}
+}
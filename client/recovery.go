@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// DefaultRecoveryCodeCount is the number of recovery codes issued when no count is given
+const DefaultRecoveryCodeCount = 10
+
+// ErrInvalidRecoveryCode is returned when the server rejects a recovery code
+var ErrInvalidRecoveryCode = errors.New("invalid or already used recovery code")
+
+// AccountLockedError is returned when the server has locked recovery for an account
+// after too many failed attempts
+type AccountLockedError struct {
+	Address    string
+	RetryAfter time.Duration // Zero if the server did not say when to retry
+}
+
+// Error implements the error interface
+func (e *AccountLockedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("account recovery for %s is locked, retry in %v", e.Address, e.RetryAfter)
+	}
+	return fmt.Sprintf("account recovery for %s is locked", e.Address)
+}
+
+// RetryAfterDelay returns the wait requested by the server, or zero if none was given
+func (e *AccountLockedError) RetryAfterDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// Is reports whether target is ErrRateLimited
+func (e *AccountLockedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// SetupRecoveryCodes issues one-time recovery codes for an account and registers their
+// salted scrypt hashes with the server, which checks codes presented to it with
+// keymgmt.VerifyRecoveryCode, replacing any codes issued before. The plaintext codes are
+// returned once and should be shown to the user for safekeeping; count <= 0 issues
+// DefaultRecoveryCodeCount codes.
+func (c *Client) SetupRecoveryCodes(address string, count int) ([]string, error) {
+	return c.SetupRecoveryCodesContext(context.Background(), address, count)
+}
+
+// SetupRecoveryCodesContext issues recovery codes, honouring ctx cancellation and deadlines
+func (c *Client) SetupRecoveryCodesContext(ctx context.Context, address string, count int) ([]string, error) {
+	keyPair := c.GetKeyPair()
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	if count <= 0 {
+		count = DefaultRecoveryCodeCount
+	}
+
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return nil, invalidAddress("address", err)
+	}
+
+	codes, err := keymgmt.GenerateRecoveryCodesFrom(count, c.entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := keyPair.PublicKeyBase64()
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		if hashes[i], err = keymgmt.HashRecoveryCodeFrom(code, address, publicKey, c.entropy); err != nil {
+			return nil, err
+		}
+	}
+
+	// The signature binds the hashes to the identity key, so the server only accepts
+	// codes issued by the key holder
+	signature := keyPair.Sign([]byte(address + ":" + publicKey + ":" + strings.Join(hashes, ",")))
+	payload, err := json.Marshal(map[string]any{
+		"public_key":  publicKey,
+		"code_hashes": hashes,
+		"signature":   base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize recovery codes: %w", err)
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve domain: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/users/%s/recovery-codes", serverInfo.URL, url.PathEscape(address))
+	if err := c.sendHTTPRequest(ctx, addr.Domain, "POST", endpoint, payload); err != nil {
+		return nil, fmt.Errorf("failed to register recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// RecoverAccount uses a recovery code to publish a new key pair for an account whose
+// private key was lost. On success the client switches to the new key pair.
+func (c *Client) RecoverAccount(address, recoveryCode string, newKeyPair *keymgmt.KeyPair) error {
+	return c.RecoverAccountContext(context.Background(), address, recoveryCode, newKeyPair)
+}
+
+// RecoverAccountContext recovers an account, honouring ctx cancellation and deadlines.
+// The request is never retried automatically, so a rejected code counts against the
+// server's lockout limit only once.
+func (c *Client) RecoverAccountContext(ctx context.Context, address, recoveryCode string, newKeyPair *keymgmt.KeyPair) error {
+	if newKeyPair == nil {
+		return fmt.Errorf("new key pair is required")
+	}
+
+	code, err := keymgmt.NormalizeRecoveryCode(recoveryCode)
+	if err != nil {
+		return err
+	}
+
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return invalidAddress("address", err)
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return fmt.Errorf("failed to resolve domain: %w", err)
+	}
+
+	// The proof shows the server we hold the new private key
+	publicKey := newKeyPair.PublicKeyBase64()
+	proof := newKeyPair.Sign([]byte(address + ":" + publicKey + ":" + code))
+	payload, err := json.Marshal(map[string]any{
+		"recovery_code": code,
+		"public_key":    publicKey,
+		"proof":         base64.StdEncoding.EncodeToString(proof),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize recovery request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/users/%s/recover", serverInfo.URL, url.PathEscape(address))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	// The old key is gone, so the request is authenticated with the new one
+	authHeader, err := auth.GenerateAuthHeaderWithOptions(newKeyPair, "POST", req.URL.Path, c.authHeaderOptions())
+	if err != nil {
+		return fmt.Errorf("failed to generate auth header: %w", err)
+	}
+	req.Header.Set("Authorization", authHeader.ToHeaderValue())
+
+	resp, err := c.do(c.settingsForDomain(addr.Domain).httpClient, req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		httpErr := newHTTPError(resp, body)
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("%w: %v", ErrInvalidRecoveryCode, httpErr)
+		case http.StatusLocked, http.StatusTooManyRequests:
+			return &AccountLockedError{Address: address, RetryAfter: httpErr.RetryAfter}
+		}
+		return fmt.Errorf("account recovery failed: %w", httpErr)
+	}
+
+	// Switch to the new key once no send is in flight
+	c.rotationMutex.Lock()
+	c.SetKeyPair(newKeyPair)
+	c.rotationMutex.Unlock()
+
+	if err := c.reauthenticateWebSocket(newKeyPair); err != nil {
+		c.logger.Warn("failed to re-authenticate WebSocket after account recovery", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+)
+
+// RequestDisposition is what the client does after an HTTP attempt
+type RequestDisposition string
+
+const (
+	DispositionSucceeded RequestDisposition = "succeeded" // The server accepted the request
+	DispositionRetrying  RequestDisposition = "retrying"  // The attempt failed and will be retried after a backoff delay
+	DispositionFailed    RequestDisposition = "failed"    // The attempt failed and the request was given up
+	DispositionCancelled RequestDisposition = "cancelled" // The request's context ended before it completed
+)
+
+// RequestInfo describes one attempt of an authenticated HTTP request to a server
+type RequestInfo struct {
+	Domain      string // Destination domain the server was resolved for
+	Method      string
+	URL         string
+	PayloadSize int    // Request body size in bytes
+	MessageID   string // Message being delivered, or "" for other requests
+	PublicKey   string // Public key that signed the request
+	KeyID       string // Signing key identifier when keys are rotated through a key ring
+	OnBehalfOf  string // Account address when a delegate key signs, or ""
+	Attempt     int    // 1 for the first attempt, incremented for each retry
+	MaxAttempts int    // Attempts allowed by the domain's retry strategy
+}
+
+// ResponseInfo describes the outcome of one attempt
+type ResponseInfo struct {
+	Request     *RequestInfo
+	StatusCode  int   // HTTP status, or 0 if no response was received
+	Err         error // Why the attempt failed, or nil
+	Duration    time.Duration
+	Disposition RequestDisposition
+}
+
+// Final returns true if no further attempt follows
+func (r *ResponseInfo) Final() bool {
+	return r.Disposition != DispositionRetrying
+}
+
+// requestMessageKey is the context key naming the message a request delivers
+type requestMessageKey struct{}
+
+// withRequestMessage records in ctx the ID of the message requests made with it deliver
+func withRequestMessage(ctx context.Context, messageID string) context.Context {
+	return context.WithValue(ctx, requestMessageKey{}, messageID)
+}
+
+// newRequestInfo describes an attempt signed with authHeader
+func (c *Client) newRequestInfo(ctx context.Context, domain, method, url string, payload []byte, authHeader *auth.AuthHeader, attempt, maxAttempts int) *RequestInfo {
+	info := &RequestInfo{
+		Domain:      domain,
+		Method:      method,
+		URL:         url,
+		PayloadSize: len(payload),
+		PublicKey:   authHeader.PublicKey,
+		KeyID:       authHeader.KeyID,
+		Attempt:     attempt + 1,
+		MaxAttempts: maxAttempts,
+	}
+	info.MessageID, _ = ctx.Value(requestMessageKey{}).(string)
+	if token := c.GetDelegationToken(); token != nil && authHeader.Delegation != "" {
+		info.OnBehalfOf = token.Address
+	}
+	return info
+}
+
+// reportResponse passes the outcome of an attempt to the OnResponse hook
+func (c *Client) reportResponse(ctx context.Context, info *RequestInfo, statusCode int, err error, start time.Time, disposition RequestDisposition) {
+	duration := time.Since(start)
+	c.recordAttemptMetrics(info, statusCode, duration, disposition)
+	if c.onResponse == nil {
+		return
+	}
+	c.onResponse(ctx, &ResponseInfo{
+		Request:     info,
+		StatusCode:  statusCode,
+		Err:         err,
+		Duration:    duration,
+		Disposition: disposition,
+	})
+}
+
+// attemptDisposition returns the disposition of a failed attempt that is given up
+func attemptDisposition(ctx context.Context) RequestDisposition {
+	if ctx.Err() != nil {
+		return DispositionCancelled
+	}
+	return DispositionFailed
+}
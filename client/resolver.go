@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/tracing"
+)
+
+// Resolver finds the EMSG server of a domain, e.g. a mock in tests or a custom
+// discovery mechanism. *dns.Resolver and *dns.CachedResolver implement it.
+type Resolver interface {
+	ResolveDomain(domain string) (*dns.EMSGServerInfo, error)
+}
+
+// ContextResolver is a Resolver that honours cancellation and deadlines.
+// Resolvers that implement it are called with the context of the operation
+// that needs the server.
+type ContextResolver interface {
+	Resolver
+	ResolveDomainContext(ctx context.Context, domain string) (*dns.EMSGServerInfo, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(domain string) (*dns.EMSGServerInfo, error)
+
+// ResolveDomain calls f(domain)
+func (f ResolverFunc) ResolveDomain(domain string) (*dns.EMSGServerInfo, error) {
+	return f(domain)
+}
+
+// contextResolver lets the client call a Resolver without context support as a
+// ContextResolver. The lookup itself cannot be interrupted, so cancellation is
+// only noticed before it starts.
+type contextResolver struct {
+	Resolver
+}
+
+// ResolveDomainContext fails if ctx is done and resolves the domain otherwise
+func (r contextResolver) ResolveDomainContext(ctx context.Context, domain string) (*dns.EMSGServerInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.ResolveDomain(domain)
+}
+
+// tracingResolver starts a span around each resolution
+type tracingResolver struct {
+	ContextResolver
+	tracer tracing.Tracer
+}
+
+// ResolveDomain resolves the domain in a span of its own
+func (r tracingResolver) ResolveDomain(domain string) (*dns.EMSGServerInfo, error) {
+	return r.ResolveDomainContext(context.Background(), domain)
+}
+
+// ResolveDomainContext resolves the domain in a span nested in ctx's
+func (r tracingResolver) ResolveDomainContext(ctx context.Context, domain string) (info *dns.EMSGServerInfo, err error) {
+	ctx, span := tracing.Start(r.tracer, ctx, tracing.SpanResolveDomain, tracing.String(tracing.AttrDomain, domain))
+	defer func() { tracing.End(span, err) }()
+
+	info, err = r.ContextResolver.ResolveDomainContext(ctx, domain)
+	if err == nil {
+		span.SetAttributes(tracing.String(tracing.AttrServerURL, info.URL))
+	}
+	return info, err
+}
+
+// unwrapResolver returns the resolver a tracingResolver wraps
+func unwrapResolver(resolver ContextResolver) ContextResolver {
+	if traced, ok := resolver.(tracingResolver); ok {
+		return traced.ContextResolver
+	}
+	return resolver
+}
+
+// newClientResolver returns the resolver the client uses: the configured one, or
+// a cached DNS resolver built from DNSConfig and DNSTTL, traced if Tracer is set
+func newClientResolver(config *Config) ContextResolver {
+	var resolver ContextResolver
+	switch configured := config.Resolver.(type) {
+	case nil:
+		resolver = dns.NewCachedResolver(config.DNSConfig, config.DNSTTL)
+	case ContextResolver:
+		resolver = configured
+	default:
+		resolver = contextResolver{configured}
+	}
+	if config.Tracer != nil {
+		resolver = tracingResolver{ContextResolver: resolver, tracer: config.Tracer}
+	}
+	return resolver
+}
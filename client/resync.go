@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// wakeDetector calls Resync when it notices the system slept
+type wakeDetector struct {
+	interval time.Duration
+
+	mutex   sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Resync catches the client up after the system woke from sleep. See ResyncContext.
+func (c *Client) Resync() error {
+	return c.ResyncContext(context.Background())
+}
+
+// ResyncContext catches the client up after the system woke from sleep, when
+// timers misfired and connections may have silently died. It measures the
+// server's clock offset again, replaces the WebSocket connection, and makes the
+// message poller, outbox sender, retry worker and connectivity monitor run now
+// instead of at their next tick. Parts that are not enabled or not running are
+// skipped.
+func (c *Client) ResyncContext(ctx context.Context) error {
+	var errs []error
+
+	if err := c.reconcileClockOffset(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to reconcile clock offset: %w", err))
+	}
+	if err := c.refreshWebSocket(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to refresh WebSocket: %w", err))
+	}
+
+	if c.IsMessagePollingRunning() {
+		c.messagePoller.PollNow()
+	}
+	if c.IsOutboxSenderRunning() {
+		wakeWorker(c.outbox.wake)
+	}
+	if c.IsRetryWorkerRunning() {
+		wakeWorker(c.retryWorker.wake)
+	}
+	if c.IsConnectivityMonitorRunning() {
+		wakeWorker(c.connectivity.wake)
+	}
+
+	return errors.Join(errs...)
+}
+
+// wakeWorker makes a background worker run without waiting for its next tick
+func wakeWorker(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// reconcileClockOffset forgets the clock offset and measures it again against
+// the server of the WebSocket or polled address. Without either, it is measured
+// from the next HTTP response.
+func (c *Client) reconcileClockOffset(ctx context.Context) error {
+	c.resetClockOffset()
+
+	address := c.webSocketAddress
+	if address == "" && c.messagePoller != nil {
+		address = c.messagePoller.Address()
+	}
+	if address == "" {
+		return nil
+	}
+
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return invalidAddress("address", err)
+	}
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return &ResolveError{Domain: addr.Domain, Err: err}
+	}
+	_, err = c.probeCapabilities(ctx, addr.Domain, serverInfo.URL)
+	return err
+}
+
+// refreshWebSocket replaces a WebSocket connection that is up or was lost,
+// leaving one closed on purpose alone. Supervision is suspended meanwhile so a
+// pending restart does not race the new connection, and resumed afterwards to
+// keep retrying if it failed.
+func (c *Client) refreshWebSocket(ctx context.Context) error {
+	ws := c.webSocketClient
+	if ws == nil || (!ws.IsConnected() && ws.Err() == nil) {
+		return nil
+	}
+
+	c.unsupervise(SubsystemWebSocket)
+	err := ws.Refresh(ctx)
+	c.superviseWebSocket()
+	return err
+}
+
+// StartWakeDetector starts checking every WakeCheckInterval whether the system
+// slept, calling Resync once it woke. Apps that receive wake events from the
+// operating system can call Resync from them instead.
+func (c *Client) StartWakeDetector() error {
+	if c.wakeDetector == nil {
+		return fmt.Errorf("wake detection not enabled")
+	}
+
+	wd := c.wakeDetector
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	if wd.running {
+		return fmt.Errorf("wake detector is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wd.cancel = cancel
+	wd.done = make(chan struct{})
+	wd.running = true
+
+	go c.wakeLoop(ctx, wd.done)
+	return nil
+}
+
+// StopWakeDetector stops the wake detector, waiting for a resync in progress to finish
+func (c *Client) StopWakeDetector() {
+	if c.wakeDetector == nil {
+		return
+	}
+
+	wd := c.wakeDetector
+	wd.mutex.Lock()
+	if !wd.running {
+		wd.mutex.Unlock()
+		return
+	}
+	wd.cancel()
+	wd.running = false
+	done := wd.done
+	wd.mutex.Unlock()
+
+	<-done
+}
+
+// IsWakeDetectorRunning returns true if the wake detector is running
+func (c *Client) IsWakeDetectorRunning() bool {
+	if c.wakeDetector == nil {
+		return false
+	}
+
+	c.wakeDetector.mutex.Lock()
+	defer c.wakeDetector.mutex.Unlock()
+	return c.wakeDetector.running
+}
+
+// wakeLoop resyncs when the wall-clock time between two ticks exceeds twice the
+// interval. Ticks are timed by the monotonic clock, which stops while the system
+// sleeps, so the first tick after waking is late by the time slept.
+func (c *Client) wakeLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	interval := c.wakeDetector.interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now().Round(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().Round(0)
+		elapsed := now.Sub(last)
+		last = now
+		if elapsed <= 2*interval {
+			continue
+		}
+
+		c.logger.Info("system woke from sleep, resyncing", "slept", elapsed-interval)
+		if err := c.ResyncContext(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("resync after wake failed", "error", err)
+		}
+		last = time.Now().Round(0)
+	}
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// retryWorker resends failed deliveries in the background
+type retryWorker struct {
+	interval      time.Duration
+	retryStrategy *delivery.RetryStrategy
+
+	drainMutex sync.Mutex // Serializes runs so a delivery is never resent twice at once
+	mutex      sync.Mutex
+	running    bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	wake       chan struct{}
+}
+
+func newRetryWorker(interval time.Duration, retryStrategy *delivery.RetryStrategy) *retryWorker {
+	if retryStrategy == nil {
+		retryStrategy = delivery.DefaultRetryStrategy()
+	}
+	return &retryWorker{
+		interval:      interval,
+		retryStrategy: retryStrategy,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// StartRetryWorker starts resending failed deliveries in the background. Every
+// RetryInterval, messages in StatusRetrying whose next attempt is due are sent
+// again until they are delivered or the delivery retry strategy gives up on them.
+func (c *Client) StartRetryWorker() error {
+	if c.retryWorker == nil {
+		return fmt.Errorf("retry worker not enabled")
+	}
+
+	rw := c.retryWorker
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	if rw.running {
+		return fmt.Errorf("retry worker is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rw.cancel = cancel
+	rw.done = make(chan struct{})
+	rw.running = true
+
+	go c.retryLoop(ctx, rw.done)
+	return nil
+}
+
+// StopRetryWorker stops the retry worker, waiting for an in-flight run to finish
+func (c *Client) StopRetryWorker() {
+	if c.retryWorker == nil {
+		return
+	}
+
+	rw := c.retryWorker
+	rw.mutex.Lock()
+	if !rw.running {
+		rw.mutex.Unlock()
+		return
+	}
+	rw.cancel()
+	rw.running = false
+	done := rw.done
+	rw.mutex.Unlock()
+
+	<-done
+}
+
+// IsRetryWorkerRunning returns true if the retry worker is running
+func (c *Client) IsRetryWorkerRunning() bool {
+	if c.retryWorker == nil {
+		return false
+	}
+
+	c.retryWorker.mutex.Lock()
+	defer c.retryWorker.mutex.Unlock()
+	return c.retryWorker.running
+}
+
+// RetryDeliveries resends the failed deliveries whose next attempt is due now,
+// returning how many were sent
+func (c *Client) RetryDeliveries(ctx context.Context) (int, error) {
+	if c.retryWorker == nil {
+		return 0, fmt.Errorf("retry worker not enabled")
+	}
+	return c.retryDue(ctx)
+}
+
+// retryLoop resends due deliveries on start, on every tick and when woken by Resync
+func (c *Client) retryLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.retryWorker.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.retryDue(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("delivery retry failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.retryWorker.wake:
+		}
+	}
+}
+
+// retryDue resends the retained messages of deliveries due for a retry. Receipts
+// of messages queued in the outbox are left to the outbox sender.
+func (c *Client) retryDue(ctx context.Context) (int, error) {
+	rw := c.retryWorker
+	rw.drainMutex.Lock()
+	defer rw.drainMutex.Unlock()
+
+	sent, failed := 0, 0
+	var firstErr error
+	for _, receipt := range c.deliveryTracker.GetPendingRetries() {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+
+		msg, retained := c.deliveryTracker.Payload(receipt.MessageID)
+		if !retained {
+			if c.queuedInOutbox(receipt.MessageID) {
+				continue
+			}
+			c.logger.Warn("cannot retry delivery without its message", "message_id", receipt.MessageID)
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, receipt.MessageID, delivery.StatusFailed, "message not retained for retry")
+			c.notifyDeliveryGivenUp(receipt.MessageID, receipt.Recipient)
+			continue
+		}
+
+		if msg.IsExpired(time.Now()) {
+			c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusExpired, message.ErrMessageExpired.Error())
+			continue
+		}
+
+		// Retried messages keep their ID and sequence, so recipients can drop
+		// copies an earlier attempt delivered after all
+		if _, err := c.sendMessageResult(ctx, msg, false); err != nil {
+			if ctx.Err() != nil {
+				// Cancellation is not the message's fault; leave the delivery as it was
+				return sent, ctx.Err()
+			}
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			c.recordSendFailure(ctx, msg, delivery.ClassifyError(err), err.Error())
+			continue
+		}
+
+		c.deliveryTracker.UpdateDeliveryStatusContext(ctx, msg.MessageID, delivery.StatusSent, "")
+		sent++
+	}
+
+	if failed > 0 {
+		return sent, fmt.Errorf("failed to resend %d of %d deliveries: %w", failed, sent+failed, firstErr)
+	}
+	return sent, nil
+}
+
+// recordSendFailure records a failed delivery of a tracked message. With the
+// retry worker enabled, a failure the retry strategy allows another attempt for
+// is marked as retrying and the message retained to be resent; otherwise, or
+// when the send was cancelled, the delivery is marked as failed.
+func (c *Client) recordSendFailure(ctx context.Context, msg *message.Message, reason delivery.FailureReason, errorMsg string) {
+	status := delivery.StatusFailed
+	if c.retryWorker != nil && ctx.Err() == nil && c.retryWorker.allowsRetry(c.deliveryTracker, msg.MessageID, reason) {
+		if err := c.deliveryTracker.RetainPayload(msg); err != nil {
+			c.logger.Warn("failed to retain message for retry", "message_id", msg.MessageID, "error", err)
+		} else {
+			status = delivery.StatusRetrying
+		}
+	}
+
+	c.deliveryTracker.UpdateDeliveryFailureContext(ctx, msg.MessageID, status, reason, errorMsg)
+	if status == delivery.StatusFailed && c.retryWorker != nil {
+		c.notifyDeliveryGivenUp(msg.MessageID, msg.To[0])
+	}
+}
+
+// allowsRetry reports whether the retry strategy allows another attempt after a
+// failure with the given reason
+func (rw *retryWorker) allowsRetry(tracker *delivery.DeliveryTracker, messageID string, reason delivery.FailureReason) bool {
+	receipt, err := tracker.GetDeliveryReceipt(messageID)
+	if err != nil {
+		return false
+	}
+
+	strategy := rw.retryStrategy
+	switch {
+	case reason.IsPermanent() || receipt.AttemptCount+1 >= strategy.MaxRetries:
+		return false
+	case !strategy.RetryOnFailure && !(strategy.RetryOnTimeout && reason == delivery.FailureTimeout):
+		return false
+	}
+	return true
+}
+
+// queuedInOutbox reports whether a message is waiting in the outbox
+func (c *Client) queuedInOutbox(messageID string) bool {
+	if c.outbox == nil {
+		return false
+	}
+	_, err := c.outbox.store.Get(messageID)
+	return err == nil
+}
+
+// notifyDeliveryGivenUp sends a failed delivery receipt notification once a
+// delivery will not be retried again
+func (c *Client) notifyDeliveryGivenUp(messageID, recipient string) {
+	if c.notificationManager == nil {
+		return
+	}
+	if err := c.notificationManager.NotifyDeliveryReceipt(messageID, recipient, false); err != nil {
+		c.logger.Warn("failed to notify failed delivery", "message_id", messageID, "error", err)
+	}
+}
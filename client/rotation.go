@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// KeyRotationHook is called during RotateKeyPair while sends are paused, so
+// queued work signed with the old key can be re-signed with the new one.
+// Hooks must not send messages through the client.
+type KeyRotationHook func(oldKeyPair, newKeyPair *keymgmt.KeyPair) error
+
+// RegisterKeyRotationHook registers a hook that runs when the key pair is rotated
+func (c *Client) RegisterKeyRotationHook(hook KeyRotationHook) {
+	c.rotationMutex.Lock()
+	defer c.rotationMutex.Unlock()
+	c.rotationHooks = append(c.rotationHooks, hook)
+}
+
+// RotateKeyPair replaces the key pair of a live client without dropping work.
+// The new public key is published to the server (authenticated with the old
+// key), in-flight sends are drained and new ones paused while the key is
+// swapped and rotation hooks re-sign queued work, the WebSocket connection is
+// re-authenticated, and finally the old key is retired on the server.
+func (c *Client) RotateKeyPair(address string, newKeyPair *keymgmt.KeyPair) error {
+	if newKeyPair == nil {
+		return fmt.Errorf("new key pair is required")
+	}
+
+	oldKeyPair, err := c.rotate(context.Background(), address, newKeyPair)
+	if err != nil {
+		return err
+	}
+
+	// Retire the old key now that nothing depends on it
+	if err := c.retireKey(context.Background(), address, oldKeyPair); err != nil {
+		return fmt.Errorf("key rotated but failed to retire old key: %w", err)
+	}
+
+	return nil
+}
+
+// RotateKey generates a new signing key, registers it with the server and makes
+// it current. Unlike RotateKeyPair, the previous key is kept, in the client's key
+// ring and on the server, so signatures made with it still verify; use RetireKey
+// to drop it. A key ring is created on first use.
+func (c *Client) RotateKey(address string) (*keymgmt.KeyPair, error) {
+	return c.RotateKeyContext(context.Background(), address)
+}
+
+// RotateKeyContext rotates to a new signing key, honouring ctx cancellation and
+// deadlines while the key is registered
+func (c *Client) RotateKeyContext(ctx context.Context, address string) (*keymgmt.KeyPair, error) {
+	if c.GetKeyPair() == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	newKeyPair, err := keymgmt.GenerateKeyPairFrom(c.entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.keyMutex.Lock()
+	if c.keyRing == nil {
+		c.keyRing = keymgmt.NewKeyRing(c.keyPair)
+	}
+	c.keyMutex.Unlock()
+
+	if _, err := c.rotate(ctx, address, newKeyPair); err != nil {
+		return nil, err
+	}
+	return newKeyPair, nil
+}
+
+// RetireKey retires a previous key from the key ring on the server and locally,
+// so signatures made with it no longer verify
+func (c *Client) RetireKey(address, keyID string) error {
+	return c.RetireKeyContext(context.Background(), address, keyID)
+}
+
+// RetireKeyContext retires a previous key, honouring ctx cancellation and deadlines
+func (c *Client) RetireKeyContext(ctx context.Context, address, keyID string) error {
+	keyRing := c.GetKeyRing()
+	if keyRing == nil {
+		return fmt.Errorf("no key ring configured")
+	}
+	if keyRing.CurrentKeyID() == keyID {
+		return fmt.Errorf("cannot retire the current key %s", keyID)
+	}
+
+	keyPair, ok := keyRing.Lookup(keyID)
+	if !ok {
+		return fmt.Errorf("key %s not found", keyID)
+	}
+
+	return c.retireKey(ctx, address, keyPair)
+}
+
+// rotate publishes a new key and swaps it in while sends are paused. It returns the replaced key.
+func (c *Client) rotate(ctx context.Context, address string, newKeyPair *keymgmt.KeyPair) (*keymgmt.KeyPair, error) {
+	oldKeyPair := c.GetKeyPair()
+	if oldKeyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+
+	domain, keysEndpoint, err := c.keysEndpoint(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Publish the new key while the old key is still authoritative. The proof
+	// shows the server we hold the new private key.
+	proof := newKeyPair.Sign([]byte(address + ":" + newKeyPair.PublicKeyBase64()))
+	publishPayload, err := json.Marshal(map[string]any{
+		"public_key": newKeyPair.PublicKeyBase64(),
+		"key_id":     newKeyPair.KeyID(),
+		"proof":      base64.StdEncoding.EncodeToString(proof),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize key publication: %w", err)
+	}
+
+	if err := c.sendHTTPRequest(ctx, domain, "POST", keysEndpoint, publishPayload); err != nil {
+		return nil, fmt.Errorf("failed to publish new key: %w", err)
+	}
+
+	// Drain in-flight sends and pause new ones while swapping
+	c.rotationMutex.Lock()
+	for _, hook := range c.rotationHooks {
+		if err := hook(oldKeyPair, newKeyPair); err != nil {
+			c.rotationMutex.Unlock()
+			return nil, fmt.Errorf("key rotation hook failed: %w", err)
+		}
+	}
+	// Keep drafts readable under the new key
+	if err := c.rewrapDraftKey(newKeyPair); err != nil {
+		c.rotationMutex.Unlock()
+		return nil, err
+	}
+	c.SetKeyPair(newKeyPair)
+	c.rotationMutex.Unlock()
+
+	// Re-authenticate the WebSocket with the new key
+	if err := c.reauthenticateWebSocket(newKeyPair); err != nil {
+		c.logger.Warn("failed to re-authenticate WebSocket after key rotation", "error", err)
+	}
+
+	return oldKeyPair, nil
+}
+
+// retireKey retires a key on the server and drops it from the key ring
+func (c *Client) retireKey(ctx context.Context, address string, keyPair *keymgmt.KeyPair) error {
+	domain, keysEndpoint, err := c.keysEndpoint(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	retirePayload, err := json.Marshal(map[string]any{
+		"public_key": keyPair.PublicKeyBase64(),
+		"key_id":     keyPair.KeyID(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize key retirement: %w", err)
+	}
+
+	if err := c.sendHTTPRequest(ctx, domain, "DELETE", keysEndpoint, retirePayload); err != nil {
+		return err
+	}
+
+	if keyRing := c.GetKeyRing(); keyRing != nil {
+		if _, ok := keyRing.Lookup(keyPair.KeyID()); ok {
+			keyRing.Retire(keyPair.KeyID())
+		}
+	}
+	return nil
+}
+
+// keysEndpoint resolves the URL of an address's published keys
+func (c *Client) keysEndpoint(ctx context.Context, address string) (string, string, error) {
+	addr, err := utils.ParseEMSGAddress(address)
+	if err != nil {
+		return "", "", invalidAddress("address", err)
+	}
+
+	serverInfo, err := c.resolver.ResolveDomainContext(ctx, addr.Domain)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve domain: %w", err)
+	}
+
+	return addr.Domain, fmt.Sprintf("%s/api/v1/users/%s/keys", serverInfo.URL, url.PathEscape(address)), nil
+}
+
+// newAuthHeader signs an authorization header, naming the key when a key ring is in
+// use and carrying the delegation token of a delegate client
+func (c *Client) newAuthHeader(keyPair *keymgmt.KeyPair, method, path string) (*auth.AuthHeader, error) {
+	options := c.authHeaderOptions()
+	if token := c.GetDelegationToken(); token != nil {
+		return auth.GenerateDelegatedAuthHeaderWithOptions(keyPair, token, method, path, options)
+	}
+	authHeader, err := auth.GenerateAuthHeaderWithOptions(keyPair, method, path, options)
+	if err != nil {
+		return nil, err
+	}
+	if c.GetKeyRing() != nil {
+		authHeader.KeyID = keyPair.KeyID()
+	}
+	return authHeader, nil
+}
+
+// reauthenticateWebSocket reconnects an active WebSocket so it authenticates with the new key
+func (c *Client) reauthenticateWebSocket(keyPair *keymgmt.KeyPair) error {
+	if c.webSocketClient == nil {
+		return nil
+	}
+
+	c.webSocketClient.SetKeyPair(keyPair)
+
+	if !c.webSocketClient.IsConnected() || c.webSocketAddress == "" {
+		return nil
+	}
+
+	if err := c.webSocketClient.Disconnect(); err != nil {
+		return fmt.Errorf("failed to disconnect: %w", err)
+	}
+
+	return c.webSocketClient.Connect(c.webSocketAddress)
+}
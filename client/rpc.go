@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// ErrRequestTimeout is returned by SendRequest when no reply arrives in time
+var ErrRequestTimeout = errors.New("request timed out waiting for reply")
+
+// requestWaiter is a SendRequest call waiting for its reply
+type requestWaiter struct {
+	recipients map[string]bool // Normalized addresses allowed to answer the request
+	reply      chan *message.Message
+}
+
+// pendingRequests matches incoming replies to in-flight requests by correlation ID
+type pendingRequests struct {
+	mutex           sync.Mutex
+	waiters         map[string]*requestWaiter
+	pollInterval    time.Duration
+	requireVerified bool // Only replies whose signature verified are accepted
+}
+
+// register starts waiting for a reply to a request from one of its recipients
+func (pr *pendingRequests) register(correlationID string, recipients []string) (*requestWaiter, error) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	if _, exists := pr.waiters[correlationID]; exists {
+		return nil, fmt.Errorf("request with correlation ID %s already pending", correlationID)
+	}
+
+	waiter := &requestWaiter{
+		recipients: make(map[string]bool, len(recipients)),
+		reply:      make(chan *message.Message, 1),
+	}
+	for _, recipient := range recipients {
+		waiter.recipients[utils.NormalizeEMSGAddress(recipient)] = true
+	}
+	pr.waiters[correlationID] = waiter
+	return waiter, nil
+}
+
+// unregister stops waiting for a request's reply
+func (pr *pendingRequests) unregister(correlationID string) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	delete(pr.waiters, correlationID)
+}
+
+// dispatch hands replies to their waiting requests. Each request takes the first
+// reply from one of its recipients; other messages are ignored. When incoming
+// verification is on, so are replies that did not verify.
+func (pr *pendingRequests) dispatch(messages []*message.Message) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	for _, msg := range messages {
+		if msg == nil || !msg.IsReply() || msg.CorrelationID == "" {
+			continue
+		}
+		if pr.requireVerified && msg.VerificationStatus != message.VerificationVerified {
+			continue
+		}
+		waiter, ok := pr.waiters[msg.CorrelationID]
+		if !ok || !waiter.recipients[utils.NormalizeEMSGAddress(msg.From)] {
+			continue
+		}
+		delete(pr.waiters, msg.CorrelationID)
+		waiter.reply <- msg
+	}
+}
+
+// pending returns the number of requests waiting for a reply
+func (pr *pendingRequests) pending() int {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	return len(pr.waiters)
+}
+
+// newCorrelationID returns a random correlation ID for a request
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SendRequest sends msg as a request and waits up to timeout for the reply. See SendRequestContext.
+func (c *Client) SendRequest(msg *message.Message, timeout time.Duration) (*message.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.SendRequestContext(ctx, msg)
+}
+
+// SendRequestContext sends msg as a request and waits until ctx is done for a
+// reply carrying its correlation ID from one of its recipients. A correlation ID
+// is generated if msg has none. Replies are picked up from the WebSocket or the
+// message poller when either is running, and otherwise by fetching msg.From's
+// messages every RequestPollInterval. If ctx's deadline passes first the error
+// wraps ErrRequestTimeout.
+func (c *Client) SendRequestContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	if msg.InReplyTo != "" {
+		return nil, &message.ValidationError{Field: "in_reply_to", Err: fmt.Errorf("a reply cannot be sent as a request")}
+	}
+	if msg.CorrelationID == "" {
+		correlationID, err := newCorrelationID()
+		if err != nil {
+			return nil, err
+		}
+		msg.CorrelationID = correlationID
+	}
+
+	recipients := append(append([]string{}, msg.To...), msg.CC...)
+	waiter, err := c.requests.register(msg.CorrelationID, recipients)
+	if err != nil {
+		return nil, err
+	}
+	defer c.requests.unregister(msg.CorrelationID)
+
+	if err := c.SendMessageContext(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	var poll <-chan time.Time
+	if !c.IsWebSocketConnected() && !c.IsMessagePollingRunning() && c.requests.pollInterval > 0 {
+		ticker := time.NewTicker(c.requests.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case reply := <-waiter.reply:
+			return reply, nil
+		case <-poll:
+			// Replies found here are delivered through fetchMessages
+			if _, err := c.GetMessagesContext(ctx, msg.From); err != nil && ctx.Err() == nil {
+				c.logger.Warn("failed to poll for reply", "correlation_id", msg.CorrelationID, "error", err)
+			}
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("request %s: %w", msg.CorrelationID, ErrRequestTimeout)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ComposeReply returns a message builder addressed to request's sender and
+// carrying its correlation ID
+func (c *Client) ComposeReply(request *message.Message) *message.MessageBuilder {
+	return c.ComposeMessage().ReplyTo(request)
+}
+
+// RespondTo sends body from from as the reply to request. See RespondToContext.
+func (c *Client) RespondTo(request *message.Message, from, body string) error {
+	return c.RespondToContext(context.Background(), request, from, body)
+}
+
+// RespondToContext sends body from from as the reply to a request received from
+// another client's SendRequest. It fails if request carries no correlation ID.
+func (c *Client) RespondToContext(ctx context.Context, request *message.Message, from, body string) error {
+	if !request.IsRequest() {
+		return &message.ValidationError{Field: "correlation_id", Err: fmt.Errorf("message is not a request")}
+	}
+
+	reply, err := c.ComposeReply(request).From(from).Body(body).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build reply: %w", err)
+	}
+	return c.SendMessageContext(ctx, reply)
+}
+
+// GetPendingRequestCount returns the number of SendRequest calls waiting for a reply
+func (c *Client) GetPendingRequestCount() int {
+	return c.requests.pending()
+}
+
+// dispatchWebSocketReply hands a message received over the WebSocket to a
+// waiting request once it passes the same expiry and signature checks as
+// fetched messages
+func (c *Client) dispatchWebSocketReply(data interface{}) {
+	msg, ok := data.(*message.Message)
+	if !ok || !msg.IsReply() {
+		return
+	}
+
+	// Verify a copy; other handlers read the message concurrently
+	received := *msg
+	messages := c.dropExpired([]*message.Message{&received})
+	messages, err := c.verifyIncoming(context.Background(), messages)
+	if err != nil {
+		c.logger.Warn("failed to verify reply received over WebSocket", "message_id", msg.MessageID, "error", err)
+		return
+	}
+	c.requests.dispatch(messages)
+}
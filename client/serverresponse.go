@@ -0,0 +1,96 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ServerResponse is the JSON envelope servers answer requests with, e.g.
+// {"status": "user registered successfully"} or {"error": "user exists", "code": "user_exists"}
+type ServerResponse struct {
+	Status  string       // Outcome reported by a successful response
+	Message string       // Human-readable detail, if any
+	Error   *ServerError // Error reported by a failed response, nil if none
+}
+
+// ServerError is the error a server reports in the body of a failed response
+type ServerError struct {
+	Code    string         `json:"code,omitempty"` // Machine-readable code, e.g. "user_exists"; empty if the server gave none
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Error implements the error interface
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// serverEnvelope is the wire form of ServerResponse. Servers report errors as
+// a string with the code alongside, or as an object.
+type serverEnvelope struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Error   json.RawMessage `json:"error"`
+	Code    string          `json:"code"`
+	Details map[string]any  `json:"details"`
+}
+
+// ParseServerResponse parses the JSON envelope of a server response body. It
+// fails if the body is not a JSON object.
+func ParseServerResponse(body []byte) (*ServerResponse, error) {
+	var envelope serverEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse server response: %w", err)
+	}
+
+	response := &ServerResponse{Status: envelope.Status, Message: envelope.Message}
+	raw := strings.TrimSpace(string(envelope.Error))
+	switch {
+	case raw == "" || raw == "null":
+		// A bare code still reports an error
+		if envelope.Code != "" {
+			response.Error = &ServerError{Code: envelope.Code, Message: envelope.Message, Details: envelope.Details}
+		}
+	case strings.HasPrefix(raw, "{"):
+		var serverErr ServerError
+		if err := json.Unmarshal(envelope.Error, &serverErr); err != nil {
+			return nil, fmt.Errorf("failed to parse server error: %w", err)
+		}
+		response.Error = &serverErr
+	default:
+		var text string
+		if err := json.Unmarshal(envelope.Error, &text); err != nil {
+			return nil, fmt.Errorf("failed to parse server error: %w", err)
+		}
+		response.Error = &ServerError{Code: envelope.Code, Message: text, Details: envelope.Details}
+	}
+	return response, nil
+}
+
+// parseServerError returns the error reported in a failed response's body, or
+// nil if the body holds none
+func parseServerError(body []byte) *ServerError {
+	response, err := ParseServerResponse(body)
+	if err != nil {
+		return nil
+	}
+	return response.Error
+}
+
+// ServerErrorCode returns the code the server gave for a failed request, or ""
+// if err carries none
+func ServerErrorCode(err error) string {
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.Code
+	}
+	return ""
+}
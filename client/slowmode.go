@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// SetGroupSlowMode sets the minimum interval between messages from each member of a group
+func (c *Client) SetGroupSlowMode(groupID, requesterAddress string, interval time.Duration) error {
+	if c.groupManager == nil {
+		return fmt.Errorf("group management not enabled")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group.SetSlowMode(interval, requesterAddress)
+}
+
+// SetGroupSlowModeWithMessage sets a group's slow mode and announces the new setting to members
+func (c *Client) SetGroupSlowModeWithMessage(groupID, requesterAddress string, interval time.Duration) error {
+	if err := c.SetGroupSlowMode(groupID, requesterAddress, interval); err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"slow_mode_interval": int64(interval / time.Second),
+		"action":             "slow_mode_changed",
+	}
+	if err := c.SendGroupManagementMessage(groupID, "slow_mode_changed", requesterAddress, data); err != nil {
+		c.logger.Warn("failed to send slow mode message", "group_id", groupID, "error", err)
+	}
+
+	return nil
+}
+
+// CheckGroupSlowMode returns a *groups.SlowModeError if the member must wait before sending to the group
+func (c *Client) CheckGroupSlowMode(groupID, memberAddress string) error {
+	if c.groupManager == nil {
+		return fmt.Errorf("group management not enabled")
+	}
+
+	group, err := c.groupManager.GetGroup(groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group.CheckSlowMode(memberAddress)
+}
+
+// slowModeGroup returns the locally known group a message is subject to slow mode in, if any.
+// System messages are never throttled, and neither are the copies of a group fan-out,
+// which checks and records slow mode once for all of them.
+func (c *Client) slowModeGroup(ctx context.Context, msg *message.Message) *groups.Group {
+	if c.groupManager == nil || msg.GroupID == "" || msg.Type != "" || inGroupFanout(ctx) {
+		return nil
+	}
+
+	group, err := c.groupManager.GetGroup(msg.GroupID)
+	if err != nil || group.SlowModeInterval() <= 0 {
+		return nil
+	}
+	return group
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// storeMessages persists sent and received messages to the local store if one is configured
+func (c *Client) storeMessages(messages []*message.Message) {
+	if c.messageStore == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, msg := range messages {
+		if msg.MessageID == "" || msg.IsExpired(now) {
+			continue
+		}
+		if err := c.messageStore.Save(msg); err != nil {
+			c.logger.Warn("failed to store message", "message_id", msg.MessageID, "error", err)
+		}
+	}
+}
+
+// storeWebSocketMessage persists a message received over the WebSocket once its
+// signature passes the client's incoming verification
+func (c *Client) storeWebSocketMessage(data interface{}) {
+	msg, ok := data.(*message.Message)
+	if !ok || c.messageStore == nil {
+		return
+	}
+
+	// Verify a copy; other handlers read the message concurrently
+	received := *msg
+	messages, err := c.verifyIncoming(context.Background(), []*message.Message{&received})
+	if err != nil {
+		c.logger.Warn("failed to verify message received over WebSocket", "message_id", msg.MessageID, "error", err)
+		return
+	}
+	c.storeMessages(messages)
+}
+
+// SearchMessages returns the stored sent and received messages matching query,
+// oldest first. Stores that implement store.Searcher answer it themselves.
+func (c *Client) SearchMessages(query *store.Query) ([]*message.Message, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+	return store.Search(c.messageStore, query)
+}
+
+// SetMessageStore sets the local store used for sent and received messages
+func (c *Client) SetMessageStore(messageStore store.MessageStore) {
+	c.messageStore = messageStore
+}
+
+// GetMessageStore returns the local message store, or nil if none is configured
+func (c *Client) GetMessageStore() store.MessageStore {
+	return c.messageStore
+}
+
+// DeleteMessage removes a message from the local store and any temporary files
+// materialized for its attachments
+func (c *Client) DeleteMessage(messageID string) error {
+	if c.messageStore == nil {
+		return fmt.Errorf("message store not configured")
+	}
+	if err := c.messageStore.Delete(messageID); err != nil {
+		return err
+	}
+	return c.releaseAttachmentFiles(messageID)
+}
+
+// releaseAttachmentFiles removes a message's temporary files if attachments are enabled
+func (c *Client) releaseAttachmentFiles(messageID string) error {
+	if c.attachmentConfig == nil {
+		return nil
+	}
+	return c.ReleaseAttachmentFiles(messageID)
+}
+
+// VerifyStore audits the local message store for corrupted or tampered entries.
+// Decryptability is checked against the client's current encryption keys, and
+// messages sent by selfAddress are verified against the client's own signing key.
+func (c *Client) VerifyStore(selfAddress string, quarantine bool, signingKeys store.SigningKeyLookup) (*store.IntegrityReport, error) {
+	if c.messageStore == nil {
+		return nil, fmt.Errorf("message store not configured")
+	}
+
+	lookup := signingKeys
+	if keyPair := c.GetKeyPair(); keyPair != nil && selfAddress != "" {
+		ownKey := keyPair.PublicKeyBase64()
+		lookup = func(address string) (string, error) {
+			if utils.NormalizeEMSGAddress(address) == utils.NormalizeEMSGAddress(selfAddress) {
+				return ownKey, nil
+			}
+			if signingKeys != nil {
+				return signingKeys(address)
+			}
+			return "", fmt.Errorf("no signing key for %s", address)
+		}
+	}
+
+	return store.VerifyStore(c.messageStore, &store.VerifyOptions{
+		SigningKeys:       lookup,
+		EncryptionManager: c.encryptionManager,
+		SelfAddress:       selfAddress,
+		Quarantine:        quarantine,
+	})
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// IncomingEventType identifies what an IncomingEvent reports
+type IncomingEventType string
+
+const (
+	IncomingMessage          IncomingEventType = "message"           // A message was received
+	IncomingTransportChanged IncomingEventType = "transport_changed" // The stream switched between WebSocket and polling
+)
+
+// IncomingEvent is an event of a message stream
+type IncomingEvent struct {
+	Type    IncomingEventType
+	Message *message.Message // The received message, for IncomingMessage
+	Source  Transport        // TransportWebSocket, or TransportHTTP while polling
+	Err     error            // Why the stream fell back to polling, for IncomingTransportChanged
+}
+
+// streamBuffer is how many events a stream buffers for a slow consumer
+const streamBuffer = 64
+
+// messageStream merges WebSocket pushes and polled messages into one channel
+type messageStream struct {
+	client  *Client
+	address string
+	ctx     context.Context
+	in      chan IncomingEvent
+	out     chan IncomingEvent
+
+	// Duplicate suppression, keyed by sender and message ID
+	seen  map[string]bool
+	order []string
+	limit int
+
+	source        Transport
+	detach        []func() // Remove the stream's WebSocket handlers
+	poller        *notifications.MessagePoller
+	pollNotifier  *notifications.NotificationManager
+	ownsWebSocket bool
+	mutex         sync.Mutex
+}
+
+// Stream delivers the messages received for address on one channel, over the
+// WebSocket when it can be used and by polling otherwise. The WebSocket is
+// connected if it is not already; while it is down, including when the
+// supervisor is reconnecting it, messages are polled every PollInterval. Each
+// message is delivered once even when both transports see it, and every switch
+// of transport is reported with an IncomingTransportChanged event. The channel is
+// closed once ctx is done, disconnecting a WebSocket the stream connected.
+func (c *Client) Stream(ctx context.Context, address string) (<-chan IncomingEvent, error) {
+	if _, err := utils.ParseEMSGAddress(address); err != nil {
+		return nil, invalidAddress("address", err)
+	}
+	if c.GetKeyPair() == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	if c.pollInterval <= 0 {
+		return nil, fmt.Errorf("PollInterval must be positive to poll when the WebSocket is down")
+	}
+
+	c.inbox.mutex.RLock()
+	limit := c.inbox.limit
+	c.inbox.mutex.RUnlock()
+
+	s := &messageStream{
+		client:  c,
+		address: address,
+		ctx:     ctx,
+		in:      make(chan IncomingEvent, streamBuffer),
+		out:     make(chan IncomingEvent, streamBuffer),
+		seen:    make(map[string]bool),
+		limit:   limit,
+	}
+
+	switch {
+	case c.IsWebSocketConnected() && c.webSocketAddress != address:
+		s.fallBack(errors.New("WebSocket is connected for another address"))
+	case c.IsWebSocketConnected():
+		s.attachWebSocket()
+	default:
+		if err := c.ConnectWebSocketContext(ctx, address); err != nil {
+			s.fallBack(err)
+			break
+		}
+		s.ownsWebSocket = true
+		s.attachWebSocket()
+	}
+
+	go s.run()
+	return s.out, nil
+}
+
+// run forwards events to the consumer, dropping messages already delivered, until
+// the stream's context is done
+func (s *messageStream) run() {
+	defer s.close()
+
+	for {
+		select {
+		case event := <-s.in:
+			if event.Type == IncomingMessage && !s.firstDelivery(event.Message) {
+				continue
+			}
+			select {
+			case s.out <- event:
+			case <-s.ctx.Done():
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// close stops polling, removes the stream's WebSocket handlers, disconnects a
+// WebSocket the stream connected and closes the consumer's channel
+func (s *messageStream) close() {
+	s.mutex.Lock()
+	s.stopPollingInternal()
+	ownsWebSocket := s.ownsWebSocket
+	detach := s.detach
+	s.detach = nil
+	s.mutex.Unlock()
+
+	for _, remove := range detach {
+		remove()
+	}
+
+	if ownsWebSocket && s.client.IsWebSocketConnected() {
+		if err := s.client.DisconnectWebSocket(); err != nil {
+			s.client.logger.Debug("failed to disconnect stream WebSocket", "address", s.address, "error", err)
+		}
+	}
+	close(s.out)
+}
+
+// attachWebSocket delivers pushed messages and switches to polling while the
+// connection is down
+func (s *messageStream) attachWebSocket() {
+	ws := s.client.webSocketClient
+	detach := []func(){
+		ws.AddEventHandler(websocket.EventMessage, s.receivePush),
+		ws.AddEventHandler(websocket.EventDisconnected, func(data interface{}) {
+			err, _ := data.(error)
+			if err == nil {
+				err = errors.New("WebSocket disconnected")
+			}
+			s.fallBack(err)
+		}),
+		ws.AddEventHandler(websocket.EventConnected, func(interface{}) {
+			s.resume()
+		}),
+	}
+
+	s.mutex.Lock()
+	s.detach = append(s.detach, detach...)
+	s.mutex.Unlock()
+	s.switchTo(TransportWebSocket, nil)
+}
+
+// receivePush delivers a pushed message once it passes the expiry and
+// signature checks polled messages go through, so a forged push cannot
+// suppress the genuine copy
+func (s *messageStream) receivePush(data interface{}) {
+	msg, ok := data.(*message.Message)
+	if !ok || msg == nil {
+		return
+	}
+
+	// Verify a copy; other handlers read the message concurrently
+	received := *msg
+	messages := s.client.dropExpired([]*message.Message{&received})
+	messages, err := s.client.verifyIncoming(s.ctx, messages)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.client.logger.Warn("failed to verify message pushed to stream", "address", s.address, "message_id", msg.MessageID, "error", err)
+		return
+	}
+	for _, msg := range messages {
+		s.emit(IncomingEvent{Type: IncomingMessage, Message: msg, Source: TransportWebSocket})
+	}
+}
+
+// fallBack starts polling because the WebSocket cannot be used
+func (s *messageStream) fallBack(cause error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx.Err() != nil || s.poller != nil {
+		return
+	}
+
+	s.pollNotifier = notifications.NewNotificationManager(1)
+	s.pollNotifier.SetLogger(s.client.logger)
+	s.pollNotifier.RegisterHandler(notifications.EventMessageReceived, func(n *notifications.Notification) error {
+		s.emit(IncomingEvent{Type: IncomingMessage, Message: n.Message, Source: TransportHTTP})
+		return nil
+	})
+	s.poller = notifications.NewMessagePoller(s.client, s.pollNotifier, s.client.pollInterval)
+	if err := s.poller.Start(s.address); err != nil {
+		s.client.logger.Warn("failed to start stream polling", "address", s.address, "error", err)
+	}
+
+	s.client.logger.Info("stream falling back to polling", "address", s.address, "error", cause)
+	s.switchToInternal(TransportHTTP, cause)
+}
+
+// resume stops polling once the WebSocket is connected again
+func (s *messageStream) resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx.Err() != nil || s.poller == nil {
+		return
+	}
+
+	s.stopPollingInternal()
+	s.switchToInternal(TransportWebSocket, nil)
+}
+
+// stopPollingInternal stops the fallback poller (internal method without lock)
+func (s *messageStream) stopPollingInternal() {
+	if s.poller == nil {
+		return
+	}
+	s.poller.Stop()
+	s.pollNotifier.Shutdown()
+	s.poller, s.pollNotifier = nil, nil
+}
+
+// switchTo reports a change of transport
+func (s *messageStream) switchTo(source Transport, cause error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.switchToInternal(source, cause)
+}
+
+// switchToInternal reports a change of transport (internal method without lock)
+func (s *messageStream) switchToInternal(source Transport, cause error) {
+	if s.source == source {
+		return
+	}
+	s.source = source
+	s.emit(IncomingEvent{Type: IncomingTransportChanged, Source: source, Err: cause})
+}
+
+// emit queues an event for the consumer unless the stream has ended
+func (s *messageStream) emit(event IncomingEvent) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	select {
+	case s.in <- event:
+	case <-s.ctx.Done():
+	}
+}
+
+// firstDelivery records a message and reports whether it was not delivered
+// before, forgetting the oldest message once the limit is reached
+func (s *messageStream) firstDelivery(msg *message.Message) bool {
+	if msg.MessageID == "" {
+		return true
+	}
+	key := inboxDedupKey(msg)
+	if s.seen[key] {
+		return false
+	}
+	if len(s.order) >= s.limit {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.seen[key] = true
+	s.order = append(s.order, key)
+	return true
+}
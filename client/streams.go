@@ -0,0 +1,33 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// OpenWebSocketStream opens a logical stream for another account or a group over
+// the client's WebSocket connection, so multi-account and bot deployments need
+// one connection per server rather than one per account. Without a key pair the
+// stream authenticates with the client's own key and delegation token.
+func (c *Client) OpenWebSocketStream(opts websocket.StreamOptions) (*websocket.Stream, error) {
+	if c.webSocketClient == nil {
+		return nil, fmt.Errorf("WebSocket not initialized")
+	}
+	if opts.KeyPair == nil {
+		opts.KeyPair = c.GetKeyPair()
+		opts.Delegation = c.GetDelegationToken()
+	}
+	if opts.KeyPair == nil {
+		return nil, fmt.Errorf("no key pair to authenticate the stream with")
+	}
+	return c.webSocketClient.OpenStream(opts)
+}
+
+// WebSocketStreams returns the streams open on the client's WebSocket connection
+func (c *Client) WebSocketStreams() []*websocket.Stream {
+	if c.webSocketClient == nil {
+		return nil
+	}
+	return c.webSocketClient.Streams()
+}
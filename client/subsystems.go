@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+)
+
+// Init eagerly initializes all configured subsystems that are otherwise created on first use,
+// returning the first setup error. Calling it is optional.
+func (c *Client) Init() error {
+	if c.attachmentConfig != nil {
+		if err := c.InitAttachments(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitAttachments initializes attachment storage now instead of on first use
+func (c *Client) InitAttachments() error {
+	_, err := c.getAttachmentManager()
+	return err
+}
+
+// getAttachmentManager returns the attachment manager, creating it on first call.
+// A failed initialization is remembered and returned on every later call.
+func (c *Client) getAttachmentManager() (*attachments.AttachmentManager, error) {
+	if c.attachmentConfig == nil {
+		return nil, fmt.Errorf("attachment manager not initialized")
+	}
+
+	c.attachmentInit.Do(func() {
+		attachmentManager, err := attachments.NewAttachmentManager(c.attachmentConfig)
+		if err != nil {
+			c.attachmentInitErr = fmt.Errorf("failed to initialize attachment manager: %w", err)
+			return
+		}
+		if c.notificationManager != nil {
+			attachmentManager.SetEventHooks(c.attachmentEventHooks())
+		}
+		c.attachmentManager = attachmentManager
+	})
+
+	return c.attachmentManager, c.attachmentInitErr
+}
@@ -0,0 +1,404 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// Subsystem names a background part of the client that the supervisor keeps running
+type Subsystem string
+
+const (
+	SubsystemWebSocket     Subsystem = "websocket"
+	SubsystemMessagePoller Subsystem = "message_poller"
+)
+
+// SubsystemState describes whether a supervised subsystem is running
+type SubsystemState string
+
+const (
+	SubsystemRunning    SubsystemState = "running"
+	SubsystemRestarting SubsystemState = "restarting" // Failed and waiting for its next restart
+	SubsystemStopped    SubsystemState = "stopped"    // Stopped on purpose
+	SubsystemFailed     SubsystemState = "failed"     // Gave up restarting; it stays stopped until started again
+)
+
+// RestartPolicy controls how a failed subsystem is restarted
+type RestartPolicy struct {
+	Restart       bool          // Restart the subsystem when it fails (false = report the failure and leave it stopped)
+	InitialDelay  time.Duration // Delay before the first restart after a quiet period
+	MaxDelay      time.Duration // Upper bound for the backoff delay
+	BackoffFactor float64       // Multiplier applied to the delay for each recent restart
+	MaxRestarts   int           // Restarts allowed within Window before giving up (0 = unlimited)
+	Window        time.Duration // Period over which restarts are counted
+}
+
+// DefaultRestartPolicy returns a restart policy allowing 5 restarts in 10 minutes
+func DefaultRestartPolicy() *RestartPolicy {
+	return &RestartPolicy{
+		Restart:       true,
+		InitialDelay:  1 * time.Second,
+		MaxDelay:      1 * time.Minute,
+		BackoffFactor: 2.0,
+		MaxRestarts:   5,
+		Window:        10 * time.Minute,
+	}
+}
+
+// restartPolicyFromReconnect derives the WebSocket restart policy from its reconnect strategy
+func restartPolicyFromReconnect(strategy *websocket.ReconnectStrategy) *RestartPolicy {
+	policy := DefaultRestartPolicy()
+	if strategy == nil {
+		return policy
+	}
+	policy.Restart = strategy.EnableReconnect
+	policy.InitialDelay = strategy.InitialDelay
+	policy.MaxDelay = strategy.MaxDelay
+	policy.BackoffFactor = strategy.BackoffFactor
+	policy.MaxRestarts = strategy.MaxRetries
+	return policy
+}
+
+// Delay returns how long to wait before a restart when recent restarts already
+// happened within the policy's window
+func (p *RestartPolicy) Delay(recent int) time.Duration {
+	factor := p.BackoffFactor
+	if factor < 1 {
+		factor = 1
+	}
+	delay := time.Duration(float64(p.InitialDelay) * math.Pow(factor, float64(recent)))
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay < 0) {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// validate reports the first invalid setting of the policy
+func (p *RestartPolicy) validate() error {
+	switch {
+	case p.InitialDelay < 0 || p.MaxDelay < 0:
+		return fmt.Errorf("delays must not be negative")
+	case p.MaxDelay > 0 && p.InitialDelay > p.MaxDelay:
+		return fmt.Errorf("initial delay %v exceeds max delay %v", p.InitialDelay, p.MaxDelay)
+	case p.BackoffFactor < 0:
+		return fmt.Errorf("backoff factor must not be negative")
+	case p.MaxRestarts < 0:
+		return fmt.Errorf("max restarts must not be negative")
+	case p.MaxRestarts > 0 && p.Window <= 0:
+		return fmt.Errorf("window must be positive when restarts are capped")
+	}
+	return nil
+}
+
+// SubsystemHealth is a snapshot of a supervised subsystem
+type SubsystemHealth struct {
+	Subsystem   Subsystem
+	State       SubsystemState
+	Restarts    int       // Successful restarts since the subsystem was started
+	LastError   error     // Most recent failure, or nil
+	LastFailure time.Time // When LastError happened
+}
+
+// SubsystemFailure is reported when a subsystem keeps failing and the supervisor
+// leaves it stopped
+type SubsystemFailure struct {
+	Subsystem Subsystem
+	Err       error // The last failure
+	Restarts  int   // Restarts within the policy's window before giving up
+	Time      time.Time
+}
+
+func (f *SubsystemFailure) Error() string {
+	return fmt.Sprintf("subsystem %s failed after %d restarts: %v", f.Subsystem, f.Restarts, f.Err)
+}
+
+func (f *SubsystemFailure) Unwrap() error {
+	return f.Err
+}
+
+// SupervisedTask is how the supervisor observes and restarts a subsystem
+type SupervisedTask struct {
+	Done    func() <-chan struct{}          // Closed when the current run of the subsystem ends
+	Err     func() error                    // Why the run ended; nil when it was stopped on purpose
+	Restart func(ctx context.Context) error // Starts a new run
+}
+
+// Supervisor restarts subsystems that stop on an unrecoverable error, backing
+// off between restarts and giving up when a subsystem fails too often within
+// its policy's window
+type Supervisor struct {
+	logger    utils.Logger
+	onFailure func(*SubsystemFailure)
+
+	mutex   sync.Mutex
+	entries map[Subsystem]*supervisedEntry
+	wg      sync.WaitGroup
+}
+
+// supervisedEntry is one watched subsystem
+type supervisedEntry struct {
+	task   SupervisedTask
+	policy *RestartPolicy
+	cancel context.CancelFunc
+
+	restartMutex sync.Mutex  // Held while restarting so Unwatch cannot race a restart
+	recent       []time.Time // Restarts within the policy's window
+	health       SubsystemHealth
+}
+
+// NewSupervisor creates a supervisor that logs restarts to logger and passes
+// subsystems it gives up on to onFailure
+func NewSupervisor(logger utils.Logger, onFailure func(*SubsystemFailure)) *Supervisor {
+	return &Supervisor{
+		logger:    utils.LoggerOrNop(logger),
+		onFailure: onFailure,
+		entries:   make(map[Subsystem]*supervisedEntry),
+	}
+}
+
+// Watch starts supervising a running subsystem, replacing any earlier watch of
+// the same name. A nil policy uses DefaultRestartPolicy.
+func (s *Supervisor) Watch(name Subsystem, policy *RestartPolicy, task SupervisedTask) {
+	if policy == nil {
+		policy = DefaultRestartPolicy()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entry := &supervisedEntry{
+		task:   task,
+		policy: policy,
+		cancel: cancel,
+		health: SubsystemHealth{Subsystem: name, State: SubsystemRunning},
+	}
+
+	s.mutex.Lock()
+	previous := s.entries[name]
+	s.entries[name] = entry
+	s.mutex.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+
+	s.wg.Add(1)
+	go s.supervise(ctx, entry)
+}
+
+// Unwatch stops supervising a subsystem, e.g. before stopping it on purpose. It
+// waits for a restart in progress so the caller can stop what it started.
+func (s *Supervisor) Unwatch(name Subsystem) {
+	s.mutex.Lock()
+	entry := s.entries[name]
+	delete(s.entries, name)
+	s.mutex.Unlock()
+
+	if entry != nil {
+		entry.stop()
+	}
+}
+
+// Stop stops supervising all subsystems and waits for the watchers to exit
+func (s *Supervisor) Stop() {
+	s.mutex.Lock()
+	entries := s.entries
+	s.entries = make(map[Subsystem]*supervisedEntry)
+	s.mutex.Unlock()
+
+	for _, entry := range entries {
+		entry.stop()
+	}
+	s.wg.Wait()
+}
+
+// Health returns a snapshot of every supervised subsystem, ordered by name
+func (s *Supervisor) Health() []SubsystemHealth {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	health := make([]SubsystemHealth, 0, len(s.entries))
+	for _, entry := range s.entries {
+		health = append(health, entry.health)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Subsystem < health[j].Subsystem
+	})
+	return health
+}
+
+// stop cancels the entry's watcher after any restart in progress completes
+func (e *supervisedEntry) stop() {
+	e.cancel()
+	e.restartMutex.Lock()
+	e.restartMutex.Unlock()
+}
+
+// supervise waits for each run of a subsystem to end and restarts it if it failed
+func (s *Supervisor) supervise(ctx context.Context, entry *supervisedEntry) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-entry.task.Done():
+		case <-ctx.Done():
+			return
+		}
+
+		err := entry.task.Err()
+		if err == nil {
+			s.setState(entry, SubsystemStopped)
+			return
+		}
+		if !s.restart(ctx, entry, err) {
+			return
+		}
+	}
+}
+
+// restart restarts a failed subsystem with backoff until a restart succeeds.
+// It returns false if the supervisor gave up or the watch was cancelled.
+func (s *Supervisor) restart(ctx context.Context, entry *supervisedEntry, err error) bool {
+	name := entry.health.Subsystem
+	for {
+		delay, ok := s.recordFailure(entry, err)
+		if !ok {
+			return false
+		}
+
+		s.logger.Warn("subsystem failed, restarting", "subsystem", name, "error", err, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+
+		entry.restartMutex.Lock()
+		if ctx.Err() != nil {
+			entry.restartMutex.Unlock()
+			return false
+		}
+		err = entry.task.Restart(ctx)
+		entry.restartMutex.Unlock()
+
+		if err == nil {
+			s.mutex.Lock()
+			entry.health.State = SubsystemRunning
+			entry.health.Restarts++
+			s.mutex.Unlock()
+			s.logger.Info("subsystem restarted", "subsystem", name)
+			return true
+		}
+	}
+}
+
+// recordFailure records a failure and returns the delay before the next
+// restart, or false after reporting the failure if the policy allows no more
+func (s *Supervisor) recordFailure(entry *supervisedEntry, err error) (time.Duration, bool) {
+	now := time.Now()
+	policy := entry.policy
+
+	s.mutex.Lock()
+	entry.health.LastError = err
+	entry.health.LastFailure = now
+
+	recent := entry.recent[:0]
+	for _, at := range entry.recent {
+		if now.Sub(at) < policy.Window {
+			recent = append(recent, at)
+		}
+	}
+	entry.recent = recent
+
+	if !policy.Restart || (policy.MaxRestarts > 0 && len(recent) >= policy.MaxRestarts) {
+		entry.health.State = SubsystemFailed
+		s.mutex.Unlock()
+
+		failure := &SubsystemFailure{Subsystem: entry.health.Subsystem, Err: err, Restarts: len(recent), Time: now}
+		s.logger.Error("subsystem failed permanently", "subsystem", failure.Subsystem, "restarts", failure.Restarts, "error", err)
+		if s.onFailure != nil {
+			s.onFailure(failure)
+		}
+		return 0, false
+	}
+
+	entry.health.State = SubsystemRestarting
+	entry.recent = append(entry.recent, now)
+	delay := policy.Delay(len(recent))
+	s.mutex.Unlock()
+	return delay, true
+}
+
+// setState updates a subsystem's reported state
+func (s *Supervisor) setState(entry *supervisedEntry, state SubsystemState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry.health.State = state
+}
+
+// GetSupervisor returns the supervisor restarting failed subsystems, or nil if
+// Config.SuperviseSubsystems is off
+func (c *Client) GetSupervisor() *Supervisor {
+	return c.supervisor
+}
+
+// GetSubsystemHealth returns the state of the supervised subsystems
+func (c *Client) GetSubsystemHealth() []SubsystemHealth {
+	if c.supervisor == nil {
+		return nil
+	}
+	return c.supervisor.Health()
+}
+
+// restartPolicy returns the configured restart policy for a subsystem
+func (c *Client) restartPolicy(name Subsystem) *RestartPolicy {
+	if policy, ok := c.restartPolicies[name]; ok && policy != nil {
+		return policy
+	}
+	if name == SubsystemWebSocket {
+		return restartPolicyFromReconnect(c.getWebSocketConfig())
+	}
+	return DefaultRestartPolicy()
+}
+
+// superviseMessagePoller restarts the message poller when its poll loop fails
+func (c *Client) superviseMessagePoller() {
+	if c.supervisor == nil {
+		return
+	}
+	poller := c.messagePoller
+	c.supervisor.Watch(SubsystemMessagePoller, c.restartPolicy(SubsystemMessagePoller), SupervisedTask{
+		Done: poller.Done,
+		Err:  poller.Err,
+		Restart: func(ctx context.Context) error {
+			return poller.Restart()
+		},
+	})
+}
+
+// superviseWebSocket reconnects the WebSocket when its connection is lost
+func (c *Client) superviseWebSocket() {
+	if c.supervisor == nil {
+		return
+	}
+	ws := c.webSocketClient
+	c.supervisor.Watch(SubsystemWebSocket, c.restartPolicy(SubsystemWebSocket), SupervisedTask{
+		Done:    ws.Done,
+		Err:     ws.Err,
+		Restart: ws.ReconnectContext,
+	})
+}
+
+// unsupervise stops restarting a subsystem before it is stopped on purpose
+func (c *Client) unsupervise(name Subsystem) {
+	if c.supervisor != nil {
+		c.supervisor.Unwatch(name)
+	}
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// Transport identifies the path used to send a message
+type Transport string
+
+const (
+	TransportAuto      Transport = "auto"
+	TransportHTTP      Transport = "http"
+	TransportWebSocket Transport = "websocket"
+)
+
+// TransportSelectionConfig controls adaptive selection between HTTP and WebSocket
+type TransportSelectionConfig struct {
+	MaxWebSocketPayload int           // Payloads larger than this many bytes go over HTTP
+	CongestionThreshold float64       // Use HTTP when the WebSocket send queue is fuller than this fraction
+	LatencyFactor       float64       // Use HTTP when WebSocket latency exceeds HTTP latency by this factor
+	SmoothingFactor     float64       // Weight of the newest sample in the latency moving average
+	MinSamples          int64         // Samples needed on both paths before latency influences selection
+	ProbeInterval       time.Duration // How often to send over the slower path to refresh its latency
+	// Error budget comparing the failure rates of the two paths
+	ErrorRateThreshold float64       // Avoid a path whose failure rate in the current window exceeds this (0 = disabled)
+	ErrorWindow        time.Duration // Length of each error-rate window (0 = one hour)
+	MinErrorSamples    int64         // Attempts needed in a window before its failure rate is trusted
+}
+
+// DefaultTransportSelectionConfig returns a default transport selection configuration
+func DefaultTransportSelectionConfig() *TransportSelectionConfig {
+	return &TransportSelectionConfig{
+		MaxWebSocketPayload: 64 * 1024,
+		CongestionThreshold: 0.8,
+		LatencyFactor:       1.5,
+		SmoothingFactor:     0.2,
+		MinSamples:          5,
+		ProbeInterval:       time.Minute,
+
+		ErrorRateThreshold: 0.25,
+		ErrorWindow:        time.Hour,
+		MinErrorSamples:    10,
+	}
+}
+
+// TransportStats holds send metrics for a single transport
+type TransportStats struct {
+	Sends          int64
+	Failures       int64
+	Samples        int64
+	AverageLatency time.Duration // Exponentially weighted moving average
+	LastLatency    time.Duration
+}
+
+// TransportSelector chooses between HTTP and WebSocket for each send based on
+// payload size, WebSocket congestion, measured latency and recent failure rates
+type TransportSelector struct {
+	config    TransportSelectionConfig
+	stats     map[Transport]*TransportStats
+	lastProbe time.Time
+	clock     utils.Clock
+	mutex     sync.Mutex
+
+	// Error budget state
+	windows       map[Transport][]TransportErrorWindow // Newest last
+	avoided       Transport                            // Path currently avoided for its failure rate, if any
+	onDiagnostics func(*TransportDiagnostics)
+}
+
+// NewTransportSelector creates a transport selector
+func NewTransportSelector(config *TransportSelectionConfig) *TransportSelector {
+	if config == nil {
+		config = DefaultTransportSelectionConfig()
+	}
+
+	return &TransportSelector{
+		config: *config,
+		stats: map[Transport]*TransportStats{
+			TransportHTTP:      {},
+			TransportWebSocket: {},
+		},
+		lastProbe: time.Now(),
+		clock:     utils.RealClock{},
+		windows:   make(map[Transport][]TransportErrorWindow),
+	}
+}
+
+// SetClock replaces the clock used for probing and error windows.
+// It must be called before the selector is used.
+func (ts *TransportSelector) SetClock(clock utils.Clock) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.clock = clock
+	ts.lastProbe = clock.Now()
+}
+
+// Select picks a transport for a payload of the given size
+func (ts *TransportSelector) Select(payloadSize int, wsConnected bool, queueDepth, queueCapacity int) Transport {
+	if !wsConnected {
+		return TransportHTTP
+	}
+
+	if ts.config.MaxWebSocketPayload > 0 && payloadSize > ts.config.MaxWebSocketPayload {
+		return TransportHTTP
+	}
+
+	if queueCapacity > 0 && float64(queueDepth)/float64(queueCapacity) >= ts.config.CongestionThreshold {
+		return TransportHTTP
+	}
+
+	// Steer away from a path that is failing more than the error budget allows
+	switch ts.refreshErrorBudget() {
+	case TransportWebSocket:
+		return TransportHTTP
+	case TransportHTTP:
+		return TransportWebSocket
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	httpStats := ts.stats[TransportHTTP]
+	wsStats := ts.stats[TransportWebSocket]
+	if httpStats.Samples < ts.config.MinSamples || wsStats.Samples < ts.config.MinSamples {
+		return TransportWebSocket
+	}
+
+	preferred, other := TransportWebSocket, TransportHTTP
+	if float64(wsStats.AverageLatency) > float64(httpStats.AverageLatency)*ts.config.LatencyFactor {
+		preferred, other = TransportHTTP, TransportWebSocket
+	}
+
+	// Occasionally use the other path so its latency estimate stays current
+	if now := ts.clock.Now(); ts.config.ProbeInterval > 0 && now.Sub(ts.lastProbe) >= ts.config.ProbeInterval {
+		ts.lastProbe = now
+		return other
+	}
+
+	return preferred
+}
+
+// RecordLatency records a successful send over a transport
+func (ts *TransportSelector) RecordLatency(transport Transport, latency time.Duration) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	stats := ts.statsFor(transport)
+	stats.Samples++
+	stats.LastLatency = latency
+	if stats.Samples == 1 {
+		stats.AverageLatency = latency
+	} else {
+		alpha := ts.config.SmoothingFactor
+		stats.AverageLatency = time.Duration(alpha*float64(latency) + (1-alpha)*float64(stats.AverageLatency))
+	}
+}
+
+// RecordSend records an attempted send over a transport
+func (ts *TransportSelector) RecordSend(transport Transport, err error) {
+	ts.mutex.Lock()
+	stats := ts.statsFor(transport)
+	stats.Sends++
+	if err != nil {
+		stats.Failures++
+	}
+	diagnostics := ts.recordOutcomeLocked(transport, err)
+	handler := ts.onDiagnostics
+	ts.mutex.Unlock()
+
+	if diagnostics != nil && handler != nil {
+		handler(diagnostics)
+	}
+}
+
+// Stats returns a snapshot of the metrics for both transports
+func (ts *TransportSelector) Stats() map[Transport]TransportStats {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	snapshot := make(map[Transport]TransportStats, len(ts.stats))
+	for transport, stats := range ts.stats {
+		snapshot[transport] = *stats
+	}
+	return snapshot
+}
+
+func (ts *TransportSelector) statsFor(transport Transport) *TransportStats {
+	stats, ok := ts.stats[transport]
+	if !ok {
+		stats = &TransportStats{}
+		ts.stats[transport] = stats
+	}
+	return stats
+}
+
+// SendMessageVia sends a message over the requested transport. TransportAuto
+// lets the client choose based on payload size, congestion and latency.
+func (c *Client) SendMessageVia(msg *message.Message, transport Transport) error {
+	switch transport {
+	case TransportAuto, "":
+		payload, err := msg.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to serialize message: %w", err)
+		}
+
+		connected := c.IsWebSocketConnected()
+		depth, capacity := 0, 0
+		if connected {
+			depth, capacity = c.webSocketClient.QueueDepth()
+		}
+		transport = c.transportSelector.Select(len(payload), connected, depth, capacity)
+	case TransportHTTP, TransportWebSocket:
+	default:
+		return fmt.Errorf("unknown transport: %s", transport)
+	}
+
+	if transport == TransportWebSocket {
+		err := c.sendOverWebSocket(context.Background(), msg)
+		c.transportSelector.RecordSend(TransportWebSocket, err)
+		return err
+	}
+
+	start := time.Now()
+	err := c.SendMessage(msg)
+	c.transportSelector.RecordSend(TransportHTTP, err)
+	if err == nil {
+		c.transportSelector.RecordLatency(TransportHTTP, time.Since(start))
+	}
+	return err
+}
+
+// sendOverWebSocket validates and signs a message exactly like an HTTP send and
+// hands it to the WebSocket, which waits for the server's ack and records it on
+// the receipt. Latency is recorded when the ack arrives.
+func (c *Client) sendOverWebSocket(ctx context.Context, msg *message.Message) error {
+	if !c.IsWebSocketConnected() {
+		return fmt.Errorf("WebSocket not connected")
+	}
+
+	// Block key rotation until this send has completed
+	c.rotationMutex.RLock()
+	defer c.rotationMutex.RUnlock()
+
+	send, err := c.prepareSend(ctx, msg, true)
+	if err != nil {
+		return err
+	}
+	if err := c.webSocketClient.SendMessageContext(ctx, msg); err != nil {
+		// Rejections and missing acks are recorded by the WebSocket; a frame that
+		// never left would otherwise stay pending
+		if send.receipt != nil && ctx.Err() == nil {
+			if receipt, _ := c.deliveryTracker.GetDeliveryReceipt(msg.MessageID); receipt != nil && receipt.Status == delivery.StatusPending {
+				c.deliveryTracker.UpdateDeliveryFailureContext(ctx, msg.MessageID, delivery.StatusFailed, delivery.ClassifyError(err), err.Error())
+			}
+		}
+		return err
+	}
+
+	c.completeSend(ctx, msg, send, nil)
+	return nil
+}
+
+// GetTransportMetrics returns send metrics for the HTTP and WebSocket paths
+func (c *Client) GetTransportMetrics() map[Transport]TransportStats {
+	return c.transportSelector.Stats()
+}
+
+// recordWebSocketAck feeds WebSocket ack latency into the transport selector
+func (c *Client) recordWebSocketAck(data interface{}) {
+	if ack, ok := data.(*websocket.AckInfo); ok {
+		c.transportSelector.RecordLatency(TransportWebSocket, ack.Latency)
+	}
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// maxErrorWindows is how many past error-rate windows are kept per transport
+const maxErrorWindows = 24
+
+// TransportErrorWindow counts the outcomes on one transport during an error-rate window
+type TransportErrorWindow struct {
+	Start    time.Time
+	Attempts int64
+	Failures int64
+}
+
+// ErrorRate returns the fraction of attempts in the window that failed
+func (w TransportErrorWindow) ErrorRate() float64 {
+	if w.Attempts == 0 {
+		return 0
+	}
+	return float64(w.Failures) / float64(w.Attempts)
+}
+
+// TransportDiagnostics is reported when the selector starts or stops avoiding a
+// transport because of its failure rate
+type TransportDiagnostics struct {
+	Avoided   Transport                          // Transport now avoided, or "" when the bias was lifted
+	Previous  Transport                          // Transport avoided before this change, or ""
+	Windows   map[Transport]TransportErrorWindow // Current window for each transport
+	Threshold float64
+	Timestamp time.Time
+}
+
+// Preferred returns the transport favored while Avoided is set
+func (d *TransportDiagnostics) Preferred() Transport {
+	switch d.Avoided {
+	case TransportHTTP:
+		return TransportWebSocket
+	case TransportWebSocket:
+		return TransportHTTP
+	}
+	return ""
+}
+
+// SetDiagnosticsHandler sets a function called whenever the error budget changes
+// which transport is avoided. It is called without the selector's lock held.
+func (ts *TransportSelector) SetDiagnosticsHandler(handler func(*TransportDiagnostics)) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.onDiagnostics = handler
+}
+
+// RecordOutcome records a delivery attempt over a transport that was not a
+// send, such as a poll or a received WebSocket frame, for the error budget
+func (ts *TransportSelector) RecordOutcome(transport Transport, err error) {
+	ts.mutex.Lock()
+	diagnostics := ts.recordOutcomeLocked(transport, err)
+	handler := ts.onDiagnostics
+	ts.mutex.Unlock()
+
+	if diagnostics != nil && handler != nil {
+		handler(diagnostics)
+	}
+}
+
+// ErrorWindows returns the recorded error-rate windows for a transport, oldest first
+func (ts *TransportSelector) ErrorWindows(transport Transport) []TransportErrorWindow {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	windows := make([]TransportErrorWindow, len(ts.windows[transport]))
+	copy(windows, ts.windows[transport])
+	return windows
+}
+
+// AvoidedTransport returns the transport currently avoided for exceeding the
+// error budget, or "" if neither is
+func (ts *TransportSelector) AvoidedTransport() Transport {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return ts.avoided
+}
+
+// refreshErrorBudget re-evaluates the bias, e.g. after a window has rolled over,
+// and returns the avoided transport
+func (ts *TransportSelector) refreshErrorBudget() Transport {
+	ts.mutex.Lock()
+	diagnostics := ts.evaluateErrorBudgetLocked(ts.clock.Now())
+	avoided := ts.avoided
+	handler := ts.onDiagnostics
+	ts.mutex.Unlock()
+
+	if diagnostics != nil && handler != nil {
+		handler(diagnostics)
+	}
+	return avoided
+}
+
+// recordOutcomeLocked counts an outcome in the transport's current window and
+// returns diagnostics if the bias changed. The caller holds the mutex.
+func (ts *TransportSelector) recordOutcomeLocked(transport Transport, err error) *TransportDiagnostics {
+	if ts.config.ErrorRateThreshold <= 0 {
+		return nil
+	}
+
+	now := ts.clock.Now()
+	window := ts.currentWindowLocked(transport, now)
+	window.Attempts++
+	if err != nil {
+		window.Failures++
+	}
+	return ts.evaluateErrorBudgetLocked(now)
+}
+
+// currentWindowLocked returns the transport's window covering now, starting a new one if needed
+func (ts *TransportSelector) currentWindowLocked(transport Transport, now time.Time) *TransportErrorWindow {
+	start := now.Truncate(ts.errorWindow())
+	windows := ts.windows[transport]
+	if n := len(windows); n > 0 && windows[n-1].Start.Equal(start) {
+		return &windows[n-1]
+	}
+
+	windows = append(windows, TransportErrorWindow{Start: start})
+	if len(windows) > maxErrorWindows {
+		windows = windows[len(windows)-maxErrorWindows:]
+	}
+	ts.windows[transport] = windows
+	return &windows[len(windows)-1]
+}
+
+// evaluateErrorBudgetLocked decides which transport, if any, to avoid. A transport
+// is avoided when its failure rate in the current window exceeds the threshold
+// and is worse than the other transport's. The caller holds the mutex.
+func (ts *TransportSelector) evaluateErrorBudgetLocked(now time.Time) *TransportDiagnostics {
+	if ts.config.ErrorRateThreshold <= 0 {
+		return nil
+	}
+
+	start := now.Truncate(ts.errorWindow())
+	current := make(map[Transport]TransportErrorWindow, 2)
+	for _, transport := range []Transport{TransportHTTP, TransportWebSocket} {
+		window := TransportErrorWindow{Start: start}
+		if windows := ts.windows[transport]; len(windows) > 0 && windows[len(windows)-1].Start.Equal(start) {
+			window = windows[len(windows)-1]
+		}
+		current[transport] = window
+	}
+
+	exceeds := func(transport, other Transport) bool {
+		window := current[transport]
+		if window.Attempts < ts.config.MinErrorSamples || window.ErrorRate() <= ts.config.ErrorRateThreshold {
+			return false
+		}
+		otherWindow := current[other]
+		return otherWindow.Attempts < ts.config.MinErrorSamples || otherWindow.ErrorRate() < window.ErrorRate()
+	}
+
+	var avoided Transport
+	switch {
+	case exceeds(TransportWebSocket, TransportHTTP):
+		avoided = TransportWebSocket
+	case exceeds(TransportHTTP, TransportWebSocket):
+		avoided = TransportHTTP
+	}
+
+	if avoided == ts.avoided {
+		return nil
+	}
+
+	diagnostics := &TransportDiagnostics{
+		Avoided:   avoided,
+		Previous:  ts.avoided,
+		Windows:   current,
+		Threshold: ts.config.ErrorRateThreshold,
+		Timestamp: now,
+	}
+	ts.avoided = avoided
+	return diagnostics
+}
+
+func (ts *TransportSelector) errorWindow() time.Duration {
+	if ts.config.ErrorWindow > 0 {
+		return ts.config.ErrorWindow
+	}
+	return time.Hour
+}
+
+// GetTransportErrorWindows returns the recorded error-rate windows for a transport, oldest first
+func (c *Client) GetTransportErrorWindows(transport Transport) []TransportErrorWindow {
+	return c.transportSelector.ErrorWindows(transport)
+}
+
+// recordPollOutcome feeds the result of an HTTP message fetch into the error
+// budget. Cancellation and client-side errors are not the transport's fault.
+func (c *Client) recordPollOutcome(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.recordPollMetrics(err)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode < 500 && httpErr.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	c.transportSelector.RecordOutcome(TransportHTTP, err)
+}
+
+// recordWebSocketError counts a WebSocket connection error against its error budget
+func (c *Client) recordWebSocketError(data interface{}) {
+	err, _ := data.(error)
+	if err == nil {
+		return
+	}
+	c.transportSelector.RecordOutcome(TransportWebSocket, err)
+}
+
+// recordWebSocketDelivery counts a message received over the WebSocket as a success
+func (c *Client) recordWebSocketDelivery(data interface{}) {
+	c.transportSelector.RecordOutcome(TransportWebSocket, nil)
+}
+
+// reportTransportDiagnostics logs and publishes a change in transport bias
+func (c *Client) reportTransportDiagnostics(diagnostics *TransportDiagnostics) {
+	httpWindow, wsWindow := diagnostics.Windows[TransportHTTP], diagnostics.Windows[TransportWebSocket]
+	args := []any{
+		"http_error_rate", httpWindow.ErrorRate(), "http_attempts", httpWindow.Attempts,
+		"websocket_error_rate", wsWindow.ErrorRate(), "websocket_attempts", wsWindow.Attempts,
+		"threshold", diagnostics.Threshold,
+	}
+	if diagnostics.Avoided != "" {
+		c.logger.Warn("transport exceeded error budget, switching", append([]any{"avoided", diagnostics.Avoided, "preferred", diagnostics.Preferred()}, args...)...)
+	} else {
+		c.logger.Info("transport error rates recovered, resuming normal selection", append([]any{"previously_avoided", diagnostics.Previous}, args...)...)
+	}
+
+	if c.notificationManager == nil {
+		return
+	}
+	metadata := map[string]any{
+		"avoided":              string(diagnostics.Avoided),
+		"preferred":            string(diagnostics.Preferred()),
+		"previously_avoided":   string(diagnostics.Previous),
+		"http_attempts":        httpWindow.Attempts,
+		"http_failures":        httpWindow.Failures,
+		"http_error_rate":      httpWindow.ErrorRate(),
+		"websocket_attempts":   wsWindow.Attempts,
+		"websocket_failures":   wsWindow.Failures,
+		"websocket_error_rate": wsWindow.ErrorRate(),
+		"threshold":            diagnostics.Threshold,
+		"window_start":         httpWindow.Start.Unix(),
+	}
+	if err := c.notificationManager.NotifyTransportDiagnostics(metadata); err != nil {
+		c.logger.Warn("failed to notify transport diagnostics", "error", err)
+	}
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// PendingDecryption is a received message whose body could not be decrypted with
+// the keys available at the time. The raw ciphertext is retained so the message
+// can be decrypted once the missing key or session state arrives.
+type PendingDecryption struct {
+	Message   *message.Message
+	LastError error
+	Attempts  int
+	FirstSeen time.Time
+}
+
+// DecryptedMessage is a previously undecryptable message that is now readable
+type DecryptedMessage struct {
+	Message *message.Message
+	Body    string
+}
+
+// undecryptableInbox holds messages awaiting re-decryption, keyed by message ID
+type undecryptableInbox struct {
+	entries map[string]*PendingDecryption
+	limit   int
+	enabled bool
+	mutex   sync.Mutex
+}
+
+// DecryptMessage decrypts a received message's body. Unlike message.GetDecryptedBody,
+// failures are reported instead of falling back to the ciphertext, and the message
+// is retained for automatic re-decryption when keys change.
+func (c *Client) DecryptMessage(msg *message.Message) (string, error) {
+	if !msg.IsEncrypted() {
+		return msg.Body, nil
+	}
+	if c.encryptionManager == nil {
+		c.retainUndecryptable(msg, ErrEncryptionUnavailable)
+		return "", ErrEncryptionUnavailable
+	}
+
+	body, err := msg.DecryptBody(c.encryptionManager)
+	if err != nil {
+		c.retainUndecryptable(msg, err)
+		return "", err
+	}
+
+	c.undecryptable.mutex.Lock()
+	delete(c.undecryptable.entries, msg.MessageID)
+	c.undecryptable.mutex.Unlock()
+
+	return body, nil
+}
+
+// GetUndecryptableMessages returns the retained messages that could not be decrypted, oldest first
+func (c *Client) GetUndecryptableMessages() []*PendingDecryption {
+	c.undecryptable.mutex.Lock()
+	defer c.undecryptable.mutex.Unlock()
+
+	pending := make([]*PendingDecryption, 0, len(c.undecryptable.entries))
+	for _, entry := range c.undecryptable.entries {
+		copied := *entry
+		pending = append(pending, &copied)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].FirstSeen.Before(pending[j].FirstSeen)
+	})
+
+	return pending
+}
+
+// DiscardUndecryptable stops retaining a message for re-decryption
+func (c *Client) DiscardUndecryptable(messageID string) bool {
+	c.undecryptable.mutex.Lock()
+	defer c.undecryptable.mutex.Unlock()
+
+	if _, ok := c.undecryptable.entries[messageID]; !ok {
+		return false
+	}
+	delete(c.undecryptable.entries, messageID)
+	return true
+}
+
+// RetryUndecryptable re-attempts decryption of every retained message with the
+// current keys. Messages that become readable are removed from the retained set,
+// returned, and announced with an EventMessageDecrypted notification.
+func (c *Client) RetryUndecryptable() []*DecryptedMessage {
+	encryptionManager := c.encryptionManager
+	if encryptionManager == nil {
+		return nil
+	}
+
+	c.undecryptable.mutex.Lock()
+	var recovered []*DecryptedMessage
+	var attempts []int
+	for id, entry := range c.undecryptable.entries {
+		entry.Attempts++
+		body, err := entry.Message.DecryptBody(encryptionManager)
+		if err != nil {
+			entry.LastError = err
+			continue
+		}
+		delete(c.undecryptable.entries, id)
+		recovered = append(recovered, &DecryptedMessage{Message: entry.Message, Body: body})
+		attempts = append(attempts, entry.Attempts)
+	}
+	c.undecryptable.mutex.Unlock()
+
+	// Notify outside the lock so handlers may call back into the client
+	if c.notificationManager != nil {
+		for i, decrypted := range recovered {
+			if err := c.notificationManager.NotifyMessageDecrypted(decrypted.Message, decrypted.Body, attempts[i]); err != nil {
+				c.logger.Warn("failed to notify message decryption", "message_id", decrypted.Message.MessageID, "error", err)
+			}
+		}
+	}
+
+	return recovered
+}
+
+// trackUndecryptable retains fetched messages addressed to us that cannot be decrypted yet
+func (c *Client) trackUndecryptable(messages []*message.Message, selfAddress string) {
+	if c.encryptionManager == nil {
+		return
+	}
+
+	for _, msg := range messages {
+		if !msg.IsEncrypted() || utils.NormalizeEMSGAddress(msg.From) == utils.NormalizeEMSGAddress(selfAddress) {
+			continue
+		}
+		if _, err := msg.DecryptBody(c.encryptionManager); err != nil {
+			c.retainUndecryptable(msg, err)
+		}
+	}
+}
+
+// retainUndecryptable records a message that failed to decrypt, evicting the oldest
+// retained message when the limit is reached
+func (c *Client) retainUndecryptable(msg *message.Message, err error) {
+	inbox := c.undecryptable
+	if !inbox.enabled || msg.MessageID == "" {
+		return
+	}
+
+	inbox.mutex.Lock()
+	defer inbox.mutex.Unlock()
+
+	if entry, ok := inbox.entries[msg.MessageID]; ok {
+		entry.Attempts++
+		entry.LastError = err
+		return
+	}
+
+	if inbox.limit > 0 && len(inbox.entries) >= inbox.limit {
+		var oldestID string
+		var oldest time.Time
+		for id, entry := range inbox.entries {
+			if oldestID == "" || entry.FirstSeen.Before(oldest) {
+				oldestID, oldest = id, entry.FirstSeen
+			}
+		}
+		delete(inbox.entries, oldestID)
+		c.logger.Warn("undecryptable message limit reached, dropped oldest message", "message_id", oldestID)
+	}
+
+	inbox.entries[msg.MessageID] = &PendingDecryption{
+		Message:   msg,
+		LastError: err,
+		Attempts:  1,
+		FirstSeen: time.Now(),
+	}
+}
+
+// retryUndecryptableAfterKeyChange re-attempts decryption when new key material may have arrived
+func (c *Client) retryUndecryptableAfterKeyChange() {
+	c.undecryptable.mutex.Lock()
+	pending := len(c.undecryptable.entries)
+	c.undecryptable.mutex.Unlock()
+
+	if pending > 0 {
+		c.RetryUndecryptable()
+	}
+}
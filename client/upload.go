@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/tracing"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// FeatureAttachmentUpload is advertised by servers that accept chunked attachment uploads
+const FeatureAttachmentUpload = "attachments.upload"
+
+// uploadStart announces an upload so the server can check limits before any data is sent
+type uploadStart struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// uploadComplete is the server's reply once all chunks are received
+type uploadComplete struct {
+	URL string `json:"url"`
+}
+
+// AttachmentUploader uploads attachments to an EMSG server chunk by chunk, so a
+// message can carry a URL reference instead of the file's data. Requests are
+// authenticated with the client's key pair and follow its retry strategy.
+type AttachmentUploader struct {
+	client  *Client
+	manager *attachments.AttachmentManager
+}
+
+// NewAttachmentUploader creates an uploader for the client's attachments
+func (c *Client) NewAttachmentUploader() (*AttachmentUploader, error) {
+	attachmentManager, err := c.getAttachmentManager()
+	if err != nil {
+		return nil, err
+	}
+	return &AttachmentUploader{client: c, manager: attachmentManager}, nil
+}
+
+// Upload uploads an attachment to the server of domain. See UploadContext.
+func (u *AttachmentUploader) Upload(domain string, attachment *attachments.Attachment) error {
+	return u.UploadContext(context.Background(), domain, attachment)
+}
+
+// UploadContext uploads an attachment to the server of domain and sets
+// attachment.URL to where recipients can download it. Data is read from
+// wherever the attachment holds it, one chunk at a time, so an attachment created
+// with CreateAttachmentFromReader is never fully loaded into memory. Chunks are
+// sized adaptively when AttachmentConfig.AdaptiveChunking is set. Local data is
+// kept; call ToURLReference to send only the URL. With notifications enabled the
+// upload raises attachment upload started, completed and failed events.
+func (u *AttachmentUploader) UploadContext(ctx context.Context, domain string, attachment *attachments.Attachment) error {
+	u.client.notifyAttachmentUpload(notifications.EventAttachmentUploadStarted, attachment, domain, nil)
+	if err := u.upload(ctx, domain, attachment); err != nil {
+		u.client.notifyAttachmentUpload(notifications.EventAttachmentUploadFailed, attachment, domain, err)
+		return err
+	}
+	u.client.notifyAttachmentUpload(notifications.EventAttachmentUploadCompleted, attachment, domain, nil)
+	return nil
+}
+
+// upload sends the attachment's data and sets its URL
+func (u *AttachmentUploader) upload(ctx context.Context, domain string, attachment *attachments.Attachment) (err error) {
+	ctx, span := tracing.Start(u.client.tracer, ctx, tracing.SpanUploadAttachment,
+		tracing.String(tracing.AttrDomain, domain),
+		tracing.String(tracing.AttrAttachmentID, attachment.ID),
+		tracing.Int64(tracing.AttrAttachmentSize, attachment.Size))
+	defer func() { tracing.End(span, err) }()
+
+	serverInfo, err := u.client.resolver.ResolveDomainContext(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("failed to resolve domain: %w", err)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/attachments/%s", serverInfo.URL, url.PathEscape(attachment.ID))
+
+	reader, err := u.manager.OpenAttachment(attachment)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	start, err := json.Marshal(&uploadStart{
+		ID:       attachment.ID,
+		Name:     attachment.Name,
+		MimeType: attachment.MimeType,
+		Size:     attachment.Size,
+		Checksum: attachment.Checksum,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize upload request: %w", err)
+	}
+	if err := u.client.sendHTTPRequest(ctx, domain, "POST", endpoint, start); err != nil {
+		return fmt.Errorf("failed to start attachment upload: %w", err)
+	}
+
+	chunks, err := u.uploadChunks(ctx, domain, endpoint, reader, attachment)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]int{"chunks": chunks})
+	if err != nil {
+		return fmt.Errorf("failed to serialize upload completion: %w", err)
+	}
+	resp, err := u.client.sendHTTPRequestWithResponse(ctx, domain, "POST", endpoint+"/complete", payload)
+	if err != nil {
+		return fmt.Errorf("failed to complete attachment upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var complete uploadComplete
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&complete); err != nil {
+		return fmt.Errorf("failed to parse upload completion: %w", err)
+	}
+	if complete.URL == "" {
+		return fmt.Errorf("server returned no URL for attachment %s", attachment.ID)
+	}
+
+	attachment.URL = complete.URL
+	return nil
+}
+
+// UploadAttachment uploads an attachment to the sender's server chunk by chunk and
+// sets its URL. See UploadAttachmentContext.
+func (c *Client) UploadAttachment(fromAddress string, attachment *attachments.Attachment) error {
+	return c.UploadAttachmentContext(context.Background(), fromAddress, attachment)
+}
+
+// UploadAttachmentContext uploads an attachment to the server of fromAddress and
+// sets attachment.URL. See AttachmentUploader.UploadContext.
+func (c *Client) UploadAttachmentContext(ctx context.Context, fromAddress string, attachment *attachments.Attachment) error {
+	addr, err := utils.ParseEMSGAddress(fromAddress)
+	if err != nil {
+		return invalidAddress("fromAddress", err)
+	}
+
+	uploader, err := c.NewAttachmentUploader()
+	if err != nil {
+		return err
+	}
+	return uploader.UploadContext(ctx, addr.Domain, attachment)
+}
+
+// UploadAttachmentForRecipient uploads an attachment to the recipient's server and
+// sets its URL. See UploadAttachmentForRecipientContext.
+func (c *Client) UploadAttachmentForRecipient(recipientAddress string, attachment *attachments.Attachment) error {
+	return c.UploadAttachmentForRecipientContext(context.Background(), recipientAddress, attachment)
+}
+
+// UploadAttachmentForRecipientContext uploads an attachment to the server of
+// recipientAddress, which then serves it to the recipient from its own domain.
+func (c *Client) UploadAttachmentForRecipientContext(ctx context.Context, recipientAddress string, attachment *attachments.Attachment) error {
+	addr, err := utils.ParseEMSGAddress(recipientAddress)
+	if err != nil {
+		return invalidAddress("recipientAddress", err)
+	}
+
+	uploader, err := c.NewAttachmentUploader()
+	if err != nil {
+		return err
+	}
+	return uploader.UploadContext(ctx, addr.Domain, attachment)
+}
+
+// uploadOversizedAttachments uploads attachments that no recipient server would
+// accept in the message body to a recipient server that accepts uploads, so the
+// delivery plan can send them as URLs
+func (c *Client) uploadOversizedAttachments(ctx context.Context, msg *message.Message, capabilities map[string]*ServerCapabilities) error {
+	constraints := combineCapabilities(capabilities)
+	if !constraints.urls {
+		return nil
+	}
+
+	// Prefer domains in a fixed order so repeated sends upload to the same server
+	var domain string
+	domains := make([]string, 0, len(capabilities))
+	for d := range capabilities {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	for _, d := range domains {
+		if capabilities[d].HasFeature(FeatureAttachmentUpload) {
+			domain = d
+			break
+		}
+	}
+	if domain == "" {
+		return nil
+	}
+
+	var uploader *AttachmentUploader
+	for _, att := range msg.Attachments {
+		if att.URL != "" || !needsUpload(att, constraints) {
+			continue
+		}
+		if uploader == nil {
+			var err error
+			if uploader, err = c.NewAttachmentUploader(); err != nil {
+				return err
+			}
+		}
+		if err := uploader.UploadContext(ctx, domain, att); err != nil {
+			return fmt.Errorf("failed to upload attachment %s: %w", att.ID, err)
+		}
+		c.logger.Debug("uploaded oversized attachment", "attachment", att.ID, "domain", domain, "size", att.Size)
+	}
+	return nil
+}
+
+// needsUpload reports whether an attachment can only be delivered as a URL
+func needsUpload(att *attachments.Attachment, constraints *deliveryConstraints) bool {
+	if limit := constraints.maxAttachment.value; limit > 0 && att.Size > limit {
+		return true
+	}
+	limit := constraints.maxInline.value
+	return !constraints.chunked && limit > 0 && att.Size > limit
+}
+
+// uploadChunks sends the attachment's data as numbered chunks and returns how many
+// were sent. The data is checked against the attachment's checksum as it is read.
+func (u *AttachmentUploader) uploadChunks(ctx context.Context, domain, endpoint string, reader io.Reader, attachment *attachments.Attachment) (int, error) {
+	config := u.client.attachmentConfig
+	maxSize := config.MaxChunkSize
+	if maxSize <= 0 {
+		maxSize = attachments.DefaultAttachmentConfig().MaxChunkSize
+	}
+	var sizer *attachments.ChunkSizer
+	if config.AdaptiveChunking {
+		sizer = attachments.NewChunkSizer(config.MinChunkSize, maxSize, config.MinChunkSize)
+	}
+
+	// One buffer of the largest chunk size bounds memory for the whole upload
+	buffer := make([]byte, maxSize)
+	hash := sha256.New()
+	var total int64
+	index := 0
+
+	for ; ; index++ {
+		size := maxSize
+		if sizer != nil {
+			size = sizer.Size()
+		}
+
+		n, err := io.ReadFull(reader, buffer[:size])
+		if n == 0 {
+			if err == nil || errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, fmt.Errorf("failed to read attachment data: %w", err)
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("failed to read attachment data: %w", err)
+		}
+
+		data := buffer[:n]
+		hash.Write(data)
+		total += int64(n)
+
+		payload, err := json.Marshal(&attachments.AttachmentChunk{
+			Index:    index,
+			Size:     n,
+			Checksum: attachments.Checksum(data),
+			Data:     data,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to serialize chunk %d: %w", index, err)
+		}
+
+		started := time.Now()
+		if err := u.client.sendHTTPRequest(ctx, domain, "PUT", fmt.Sprintf("%s/chunks/%d", endpoint, index), payload); err != nil {
+			return 0, fmt.Errorf("failed to upload chunk %d: %w", index, err)
+		}
+		if sizer != nil {
+			sizer.RecordSuccess(int64(n), time.Since(started))
+		}
+	}
+
+	if attachment.Size > 0 && total != attachment.Size {
+		return 0, fmt.Errorf("attachment %s changed during upload: expected %d bytes, read %d", attachment.ID, attachment.Size, total)
+	}
+	if attachment.Checksum != "" && base64.StdEncoding.EncodeToString(hash.Sum(nil)) != attachment.Checksum {
+		return 0, fmt.Errorf("attachment %s changed during upload: checksum mismatch", attachment.ID)
+	}
+
+	return index, nil
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
+)
+
+// IncomingVerification selects how the signatures of fetched messages are checked
+type IncomingVerification int
+
+const (
+	VerifyOff    IncomingVerification = iota // Fetched messages are not verified
+	VerifyFlag                               // Messages are verified and flagged through VerificationStatus, but all are returned
+	VerifyReject                             // Messages that cannot be verified are dropped and logged
+)
+
+// KeyResolver looks up the base64 Ed25519 signing key of a sender
+type KeyResolver interface {
+	ResolveSigningKey(ctx context.Context, address string) (string, error)
+}
+
+// KeyResolverFunc adapts a function to the KeyResolver interface
+type KeyResolverFunc func(ctx context.Context, address string) (string, error)
+
+// ResolveSigningKey calls f(ctx, address)
+func (f KeyResolverFunc) ResolveSigningKey(ctx context.Context, address string) (string, error) {
+	return f(ctx, address)
+}
+
+// signingKeyCache caches resolved sender keys so a batch of messages from one
+// sender costs a single lookup, including when the messages are verified
+// concurrently. Failed lookups are cached for negativeTTL, and revoked keys are
+// refused however they were resolved.
+type signingKeyCache struct {
+	resolver    KeyResolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*signingKeyEntry
+	revoked     map[string]map[string]bool // Key IDs of revoked keys, by sender
+	flights     utils.FlightGroup[string]
+	mutex       sync.Mutex
+}
+
+type signingKeyEntry struct {
+	key       string
+	err       error
+	expiresAt time.Time
+}
+
+// lookup returns the sender's signing key, resolving it if it is not cached
+func (skc *signingKeyCache) lookup(ctx context.Context, address string) (string, error) {
+	address = utils.NormalizeEMSGAddress(address)
+
+	skc.mutex.Lock()
+	entry, exists := skc.entries[address]
+	skc.mutex.Unlock()
+	if exists && time.Now().Before(entry.expiresAt) {
+		return skc.checkRevoked(address, entry.key, entry.err)
+	}
+
+	key, err, _ := skc.flights.DoContext(ctx, address, func(ctx context.Context) (string, error) {
+		return skc.resolve(ctx, address)
+	})
+	if ctx.Err() != nil {
+		// Cancellation says nothing about the sender's key
+		return "", ctx.Err()
+	}
+	return skc.checkRevoked(address, key, err)
+}
+
+// checkRevoked fails a resolved key that was revoked, e.g. one a stale server
+// cache still publishes
+func (skc *signingKeyCache) checkRevoked(address, key string, err error) (string, error) {
+	if err != nil || key == "" {
+		return key, err
+	}
+
+	skc.mutex.Lock()
+	defer skc.mutex.Unlock()
+	if skc.revoked[address][signingKeyID(key)] {
+		return "", fmt.Errorf("signing key of %s: %w", address, message.ErrKeyRevoked)
+	}
+	return key, nil
+}
+
+// resolve resolves a sender's key and caches the outcome
+func (skc *signingKeyCache) resolve(ctx context.Context, address string) (string, error) {
+	key, err := skc.resolver.ResolveSigningKey(ctx, address)
+
+	ttl := skc.ttl
+	if err != nil {
+		ttl = skc.negativeTTL
+	}
+
+	skc.mutex.Lock()
+	skc.entries[address] = &signingKeyEntry{key: key, err: err, expiresAt: time.Now().Add(ttl)}
+	skc.mutex.Unlock()
+
+	return key, err
+}
+
+// forget drops a cached key, e.g. after the sender rotated it
+func (skc *signingKeyCache) forget(address string) {
+	skc.mutex.Lock()
+	defer skc.mutex.Unlock()
+	delete(skc.entries, utils.NormalizeEMSGAddress(address))
+}
+
+// revoke refuses a sender's key from now on and drops it if cached
+func (skc *signingKeyCache) revoke(address, key string) {
+	address = utils.NormalizeEMSGAddress(address)
+	keyID := signingKeyID(key)
+
+	skc.mutex.Lock()
+	defer skc.mutex.Unlock()
+
+	if skc.revoked[address] == nil {
+		skc.revoked[address] = make(map[string]bool)
+	}
+	skc.revoked[address][keyID] = true
+	if entry, exists := skc.entries[address]; exists && entry.key != "" && signingKeyID(entry.key) == keyID {
+		delete(skc.entries, address)
+	}
+}
+
+// signingKeyID returns the key ID of a base64 public key, or key itself if it
+// is not one, i.e. already a key ID
+func signingKeyID(key string) string {
+	publicKey, err := keymgmt.LoadPublicKeyFromBase64(key)
+	if err != nil {
+		return key
+	}
+	return keymgmt.KeyID(publicKey)
+}
+
+// VerifyMessage checks a received message's signature against its sender's key and
+// records the outcome in msg.VerificationStatus
+func (c *Client) VerifyMessage(msg *message.Message) (message.VerificationStatus, error) {
+	return c.VerifyMessageContext(context.Background(), msg)
+}
+
+// VerifyMessageContext checks a received message's signature against its sender's
+// key, honouring ctx cancellation and deadlines while the key is resolved. A key that
+// cannot be resolved is reported as VerificationKeyUnavailable, not as an error.
+func (c *Client) VerifyMessageContext(ctx context.Context, msg *message.Message) (message.VerificationStatus, error) {
+	if !msg.IsSigned() {
+		return msg.VerifySender("", nil), nil
+	}
+
+	key, err := c.signingKeys.lookup(ctx, msg.From)
+	if ctx.Err() != nil {
+		return message.VerificationUnchecked, ctx.Err()
+	}
+	if err != nil {
+		c.logger.Debug("could not resolve sender signing key", "from", msg.From, "error", err)
+	}
+	return msg.VerifySender(key, err), nil
+}
+
+// ForgetSigningKey drops a sender's cached signing key so the next verification resolves it again
+func (c *Client) ForgetSigningKey(address string) {
+	c.signingKeys.forget(address)
+}
+
+// RevokeSigningKey stops trusting one of a sender's signing keys, given as a
+// base64 public key or key ID. Messages signed with it are reported as
+// VerificationKeyRevoked, even if the sender's server still publishes the key.
+// Revocations pushed over the WebSocket are applied automatically.
+func (c *Client) RevokeSigningKey(address, key string) {
+	c.signingKeys.revoke(address, key)
+}
+
+// handleKeyChanged drops the cached keys of a sender who published a new key
+func (c *Client) handleKeyChanged(data interface{}) {
+	event, ok := data.(*websocket.KeyEvent)
+	if !ok {
+		return
+	}
+	c.logger.Debug("sender key changed", "address", event.Address)
+	c.signingKeys.forget(event.Address)
+	if c.keyDiscovery != nil {
+		c.keyDiscovery.Forget(event.Address)
+	}
+}
+
+// handleKeyRevoked applies a key revocation pushed by the server
+func (c *Client) handleKeyRevoked(data interface{}) {
+	event, ok := data.(*websocket.KeyEvent)
+	if !ok {
+		return
+	}
+	key := event.PublicKey
+	if key == "" {
+		key = event.KeyID
+	}
+	c.logger.Info("sender key revoked", "address", event.Address, "key_id", signingKeyID(key), "reason", event.Reason)
+	c.signingKeys.revoke(event.Address, key)
+	if c.keyDiscovery != nil {
+		c.keyDiscovery.Forget(event.Address)
+	}
+}
+
+// verifyIncoming checks fetched messages according to the VerifyIncoming mode and
+// returns the messages to keep
+func (c *Client) verifyIncoming(ctx context.Context, messages []*message.Message) ([]*message.Message, error) {
+	if c.verifyIncomingMode == VerifyOff {
+		return messages, nil
+	}
+
+	kept := messages[:0]
+	for _, msg := range messages {
+		status, err := c.VerifyMessageContext(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		if status != message.VerificationVerified {
+			if c.verifyIncomingMode == VerifyReject {
+				c.logger.Warn("rejected unverified message", "message_id", msg.MessageID, "from", msg.From, "status", status)
+				continue
+			}
+			c.logger.Info("received unverified message", "message_id", msg.MessageID, "from", msg.From, "status", status)
+		}
+		kept = append(kept, msg)
+	}
+	return kept, nil
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+// WipeAll closes the client and erases the sensitive data it holds, for logout
+// or lock-screen scenarios: drafts, stored and queued messages, retained
+// undecryptable messages, delivery receipts and proofs, cached peer details and
+// all private key material. The client must not be used afterwards.
+func (c *Client) WipeAll() error {
+	errs := []error{c.Close()}
+
+	if c.drafts != nil {
+		if err := store.WipeDrafts(c.drafts.store); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wipe drafts: %w", err))
+		}
+	}
+	if c.messageStore != nil {
+		if err := store.WipeMessages(c.messageStore); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wipe message store: %w", err))
+		}
+	}
+	if c.outbox != nil {
+		if err := store.WipeOutbox(c.outbox.store); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wipe outbox: %w", err))
+		}
+	}
+	if c.deliveryTracker != nil {
+		if err := c.deliveryTracker.WipeReceipts(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wipe delivery receipts: %w", err))
+		}
+	}
+
+	c.wipeCaches()
+	c.wipeKeys()
+	return errors.Join(errs...)
+}
+
+// wipeCaches drops messages and per-peer details held in memory
+func (c *Client) wipeCaches() {
+	c.undecryptable.mutex.Lock()
+	clear(c.undecryptable.entries)
+	c.undecryptable.mutex.Unlock()
+
+	c.deliveryProofs.mutex.Lock()
+	clear(c.deliveryProofs.records)
+	c.deliveryProofs.mutex.Unlock()
+
+	c.capabilities.mutex.Lock()
+	clear(c.capabilities.entries)
+	c.capabilities.mutex.Unlock()
+
+	c.signingKeys.mutex.Lock()
+	clear(c.signingKeys.entries)
+	clear(c.signingKeys.revoked)
+	c.signingKeys.mutex.Unlock()
+
+	c.contactMutex.Lock()
+	clear(c.contactedRecipients)
+	c.contactMutex.Unlock()
+
+	c.peerMutex.Lock()
+	clear(c.peerClientInfo)
+	c.peerMutex.Unlock()
+
+	c.membershipProofs.mutex.Lock()
+	clear(c.membershipProofs.proofs)
+	c.membershipProofs.mutex.Unlock()
+
+	c.inbox.mutex.Lock()
+	clear(c.inbox.seen)
+	c.inbox.order = nil
+	c.inbox.mutex.Unlock()
+
+	c.emojiAssets.mutex.Lock()
+	clear(c.emojiAssets.assets)
+	c.emojiAssets.mutex.Unlock()
+}
+
+// wipeKeys zeroes the signing keys, the encryption key and the draft key
+func (c *Client) wipeKeys() {
+	c.keyMutex.Lock()
+	if c.keyRing != nil {
+		c.keyRing.Wipe()
+	}
+	if c.keyPair != nil {
+		c.keyPair.Wipe()
+	}
+	c.keyMutex.Unlock()
+
+	if c.encryptionManager != nil {
+		c.encryptionManager.Wipe()
+	}
+	if c.drafts != nil {
+		c.drafts.wipeKey()
+	}
+}
@@ -1,6 +1,7 @@
 package delivery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 // DeliveryStatus represents the status of a message delivery
@@ -24,15 +26,31 @@ const (
 
 // DeliveryReceipt represents a delivery receipt for a message
 type DeliveryReceipt struct {
-	MessageID    string         `json:"message_id"`
-	Recipient    string         `json:"recipient"`
-	Status       DeliveryStatus `json:"status"`
-	Timestamp    int64          `json:"timestamp"`
-	AttemptCount int            `json:"attempt_count"`
-	LastAttempt  int64          `json:"last_attempt"`
-	NextAttempt  int64          `json:"next_attempt,omitempty"`
-	ErrorMessage string         `json:"error_message,omitempty"`
-	Metadata     map[string]any `json:"metadata,omitempty"`
+	MessageID     string         `json:"message_id"`
+	Recipient     string         `json:"recipient"`
+	Status        DeliveryStatus `json:"status"`
+	Timestamp     int64          `json:"timestamp"`
+	AttemptCount  int            `json:"attempt_count"`
+	LastAttempt   int64          `json:"last_attempt"`
+	NextAttempt   int64          `json:"next_attempt,omitempty"`
+	ErrorMessage  string         `json:"error_message,omitempty"`
+	FailureReason FailureReason  `json:"failure_reason,omitempty"`
+	Metadata      map[string]any `json:"metadata,omitempty"`
+	// Recipients a partial delivery has not reached yet, with the last error for each
+	Undelivered map[string]string `json:"undelivered,omitempty"`
+	// Delivery state of each To and CC recipient; Status aggregates them
+	Recipients map[string]RecipientStatus `json:"recipients,omitempty"`
+	// Unix time the message self-destructs, copied from the message (0 = never)
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// TTL returns how long the message has left at now before it self-destructs,
+// and false if it does not expire
+func (r *DeliveryReceipt) TTL(now time.Time) (time.Duration, bool) {
+	if r.ExpiresAt == 0 {
+		return 0, false
+	}
+	return max(time.Unix(r.ExpiresAt, 0).Sub(now), 0), true
 }
 
 // DeliveryTracker tracks message delivery status and handles retries
@@ -40,8 +58,13 @@ type DeliveryTracker struct {
 	receipts      map[string]*DeliveryReceipt
 	mutex         sync.RWMutex
 	retryStrategy *RetryStrategy
-	callbacks     map[string][]DeliveryCallback
+	callbacks     map[string][]ContextDeliveryCallback
 	callbackMutex sync.RWMutex
+	panicHandler  utils.PanicHandler
+	maxReceipts   int                         // Zero means unlimited
+	payloads      map[string]*message.Message // Messages retained for resending
+	store         ReceiptStore
+	logger        utils.Logger
 }
 
 // RetryStrategy defines retry behavior for message delivery
@@ -58,6 +81,11 @@ type RetryStrategy struct {
 // DeliveryCallback is called when delivery status changes
 type DeliveryCallback func(receipt *DeliveryReceipt)
 
+// ContextDeliveryCallback is called when delivery status changes, with the context
+// of the operation that changed it. Callbacks run asynchronously, so the context
+// carries the operation's values but is not cancelled when the operation ends.
+type ContextDeliveryCallback func(ctx context.Context, receipt *DeliveryReceipt)
+
 // DefaultRetryStrategy returns a default retry strategy for delivery
 func DefaultRetryStrategy() *RetryStrategy {
 	return &RetryStrategy{
@@ -79,8 +107,10 @@ func NewDeliveryTracker(retryStrategy *RetryStrategy) *DeliveryTracker {
 
 	return &DeliveryTracker{
 		receipts:      make(map[string]*DeliveryReceipt),
+		payloads:      make(map[string]*message.Message),
 		retryStrategy: retryStrategy,
-		callbacks:     make(map[string][]DeliveryCallback),
+		callbacks:     make(map[string][]ContextDeliveryCallback),
+		logger:        utils.NopLogger{},
 	}
 }
 
@@ -91,11 +121,16 @@ func (dt *DeliveryTracker) TrackMessage(msg *message.Message) *DeliveryReceipt {
 
 	receipt := &DeliveryReceipt{
 		MessageID:    msg.MessageID,
-		Recipient:    msg.To[0], // Track first recipient for simplicity
+		Recipient:    msg.To[0], // Primary recipient; see Recipients for all of them
 		Status:       StatusPending,
 		Timestamp:    time.Now().Unix(),
 		AttemptCount: 0,
 		Metadata:     make(map[string]any),
+		ExpiresAt:    msg.ExpiresAt,
+	}
+	receipt.Recipients = trackedRecipients(msg, receipt.Timestamp)
+	if _, exists := dt.receipts[msg.MessageID]; !exists && dt.maxReceipts > 0 && len(dt.receipts) >= dt.maxReceipts {
+		dt.evictReceiptLocked()
 	}
 
 	// Add message metadata
@@ -104,13 +139,21 @@ func (dt *DeliveryTracker) TrackMessage(msg *message.Message) *DeliveryReceipt {
 	receipt.Metadata["recipients"] = msg.GetRecipients()
 	receipt.Metadata["is_system"] = msg.IsSystemMessage()
 	receipt.Metadata["is_encrypted"] = msg.IsEncrypted()
+	setEncryptionMetadata(receipt, msg.EffectiveEncryption())
 
 	dt.receipts[msg.MessageID] = receipt
+	dt.persistLocked(receipt)
 	return receipt
 }
 
 // UpdateDeliveryStatus updates the delivery status of a message
 func (dt *DeliveryTracker) UpdateDeliveryStatus(messageID string, status DeliveryStatus, errorMsg string) error {
+	return dt.UpdateDeliveryStatusContext(context.Background(), messageID, status, errorMsg)
+}
+
+// UpdateDeliveryStatusContext updates the delivery status of a message, passing
+// ctx's values on to the callbacks
+func (dt *DeliveryTracker) UpdateDeliveryStatusContext(ctx context.Context, messageID string, status DeliveryStatus, errorMsg string) error {
 	dt.mutex.Lock()
 	defer dt.mutex.Unlock()
 
@@ -127,6 +170,11 @@ func (dt *DeliveryTracker) UpdateDeliveryStatus(messageID string, status Deliver
 		receipt.ErrorMessage = errorMsg
 	}
 
+	// A successful transition clears any earlier failure classification
+	if status == StatusSent || status == StatusDelivered {
+		receipt.FailureReason = ""
+	}
+
 	// Update attempt tracking
 	if status == StatusSent || status == StatusRetrying {
 		receipt.AttemptCount++
@@ -143,16 +191,24 @@ func (dt *DeliveryTracker) UpdateDeliveryStatus(messageID string, status Deliver
 	if time.Since(time.Unix(receipt.Timestamp, 0)) > dt.retryStrategy.ExpirationTime {
 		receipt.Status = StatusExpired
 	}
+	dt.applyToRecipientsLocked(receipt, receipt.Status, errorMsg)
+	dt.persistLocked(receipt)
+
+	// Only pending and retrying deliveries can still be resent
+	if receipt.Status != StatusPending && receipt.Status != StatusRetrying {
+		dt.releasePayloadLocked(messageID)
+	}
 
 	// Trigger callbacks if status changed
 	if oldStatus != receipt.Status {
-		dt.triggerCallbacks(messageID, receipt)
+		dt.triggerCallbacks(ctx, messageID, receipt)
 	}
 
 	return nil
 }
 
-// GetDeliveryReceipt returns the delivery receipt for a message
+// GetDeliveryReceipt returns the delivery receipt for a message, including the
+// status of each recipient
 func (dt *DeliveryTracker) GetDeliveryReceipt(messageID string) (*DeliveryReceipt, error) {
 	dt.mutex.RLock()
 	defer dt.mutex.RUnlock()
@@ -179,7 +235,8 @@ func (dt *DeliveryTracker) GetPendingRetries() []*DeliveryReceipt {
 		if receipt.Status == StatusRetrying &&
 			receipt.NextAttempt > 0 &&
 			receipt.NextAttempt <= now &&
-			receipt.AttemptCount < dt.retryStrategy.MaxRetries {
+			receipt.AttemptCount < dt.retryStrategy.MaxRetries &&
+			(receipt.ExpiresAt == 0 || receipt.ExpiresAt > now) {
 
 			// Check if not expired
 			if time.Since(time.Unix(receipt.Timestamp, 0)) <= dt.retryStrategy.ExpirationTime {
@@ -194,6 +251,11 @@ func (dt *DeliveryTracker) GetPendingRetries() []*DeliveryReceipt {
 
 // RegisterCallback registers a callback for delivery status changes
 func (dt *DeliveryTracker) RegisterCallback(messageID string, callback DeliveryCallback) {
+	dt.RegisterCallbackContext(messageID, ignoreContext(callback))
+}
+
+// RegisterCallbackContext registers a context-aware callback for delivery status changes
+func (dt *DeliveryTracker) RegisterCallbackContext(messageID string, callback ContextDeliveryCallback) {
 	dt.callbackMutex.Lock()
 	defer dt.callbackMutex.Unlock()
 
@@ -202,37 +264,72 @@ func (dt *DeliveryTracker) RegisterCallback(messageID string, callback DeliveryC
 
 // RegisterGlobalCallback registers a callback for all delivery status changes
 func (dt *DeliveryTracker) RegisterGlobalCallback(callback DeliveryCallback) {
+	dt.RegisterGlobalCallbackContext(ignoreContext(callback))
+}
+
+// RegisterGlobalCallbackContext registers a context-aware callback for all delivery status changes
+func (dt *DeliveryTracker) RegisterGlobalCallbackContext(callback ContextDeliveryCallback) {
 	dt.callbackMutex.Lock()
 	defer dt.callbackMutex.Unlock()
 
 	dt.callbacks["*"] = append(dt.callbacks["*"], callback)
 }
 
+// SetLogger sets the logger for failed receipt store writes (nil discards them)
+func (dt *DeliveryTracker) SetLogger(logger utils.Logger) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	dt.logger = utils.LoggerOrNop(logger)
+}
+
+// SetPanicHandler sets the hook that receives panics recovered from callbacks
+func (dt *DeliveryTracker) SetPanicHandler(handler utils.PanicHandler) {
+	dt.callbackMutex.Lock()
+	defer dt.callbackMutex.Unlock()
+
+	dt.panicHandler = handler
+}
+
+// ignoreContext adapts a DeliveryCallback to a ContextDeliveryCallback
+func ignoreContext(callback DeliveryCallback) ContextDeliveryCallback {
+	return func(ctx context.Context, receipt *DeliveryReceipt) {
+		callback(receipt)
+	}
+}
+
 // triggerCallbacks triggers callbacks for a message
-func (dt *DeliveryTracker) triggerCallbacks(messageID string, receipt *DeliveryReceipt) {
+func (dt *DeliveryTracker) triggerCallbacks(ctx context.Context, messageID string, receipt *DeliveryReceipt) {
 	dt.callbackMutex.RLock()
 	callbacks := append(dt.callbacks[messageID], dt.callbacks["*"]...)
+	panicHandler := dt.panicHandler
 	dt.callbackMutex.RUnlock()
 
+	// Callbacks outlive the update, so keep ctx's values but not its cancellation
+	ctx = context.WithoutCancel(ctx)
 	for _, callback := range callbacks {
-		go func(cb DeliveryCallback) {
+		go func(cb ContextDeliveryCallback) {
 			defer func() {
 				if r := recover(); r != nil {
-					// Log panic but don't crash
+					utils.ReportPanic(panicHandler, utils.PanicSubsystemDeliveryCallback, r)
 				}
 			}()
-			cb(receipt)
+			cb(ctx, receipt)
 		}(callback)
 	}
 }
 
 // calculateRetryDelay calculates the delay before the next retry
 func (dt *DeliveryTracker) calculateRetryDelay(attemptCount int) time.Duration {
-	delay := time.Duration(float64(dt.retryStrategy.InitialDelay) *
-		math.Pow(dt.retryStrategy.BackoffFactor, float64(attemptCount-1))) // Exponential backoff
+	return dt.retryStrategy.RetryDelay(attemptCount)
+}
 
-	if delay > dt.retryStrategy.MaxDelay {
-		delay = dt.retryStrategy.MaxDelay
+// RetryDelay returns the delay before the next attempt after attemptCount failed attempts
+func (rs *RetryStrategy) RetryDelay(attemptCount int) time.Duration {
+	delay := time.Duration(float64(rs.InitialDelay) *
+		math.Pow(rs.BackoffFactor, float64(attemptCount-1))) // Exponential backoff
+
+	if delay > rs.MaxDelay {
+		delay = rs.MaxDelay
 	}
 
 	return delay
@@ -291,7 +388,8 @@ func (dt *DeliveryTracker) GetDeliveryStats() map[DeliveryStatus]int {
 	return stats
 }
 
-// CleanupExpiredReceipts removes expired delivery receipts
+// CleanupExpiredReceipts removes expired delivery receipts, also from the
+// receipt store
 func (dt *DeliveryTracker) CleanupExpiredReceipts() int {
 	dt.mutex.Lock()
 	defer dt.mutex.Unlock()
@@ -301,7 +399,7 @@ func (dt *DeliveryTracker) CleanupExpiredReceipts() int {
 
 	for messageID, receipt := range dt.receipts {
 		if now.Sub(time.Unix(receipt.Timestamp, 0)) > dt.retryStrategy.ExpirationTime {
-			delete(dt.receipts, messageID)
+			dt.forgetLocked(messageID)
 			cleaned++
 		}
 	}
@@ -338,6 +436,38 @@ func FromJSON(data []byte) (*DeliveryReceipt, error) {
 	return &receipt, nil
 }
 
+// SetMaxReceipts limits how many receipts are kept in memory. Tracking a message
+// beyond the limit drops the oldest finished receipt, or the oldest receipt if none
+// has finished. Zero removes the limit.
+func (dt *DeliveryTracker) SetMaxReceipts(maxReceipts int) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	dt.maxReceipts = maxReceipts
+	for dt.maxReceipts > 0 && len(dt.receipts) > dt.maxReceipts {
+		dt.evictReceiptLocked()
+	}
+}
+
+// evictReceiptLocked drops one receipt, preferring the oldest terminal one
+func (dt *DeliveryTracker) evictReceiptLocked() {
+	var oldest, oldestTerminal *DeliveryReceipt
+	for _, receipt := range dt.receipts {
+		if oldest == nil || receipt.Timestamp < oldest.Timestamp {
+			oldest = receipt
+		}
+		if receipt.IsTerminal() && (oldestTerminal == nil || receipt.Timestamp < oldestTerminal.Timestamp) {
+			oldestTerminal = receipt
+		}
+	}
+	if oldestTerminal != nil {
+		oldest = oldestTerminal
+	}
+	if oldest != nil {
+		dt.forgetLocked(oldest.MessageID)
+	}
+}
+
 // IsTerminal returns true if the status is terminal (no more changes expected)
 func (dr *DeliveryReceipt) IsTerminal() bool {
 	return dr.Status == StatusDelivered ||
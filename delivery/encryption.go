@@ -0,0 +1,58 @@
+package delivery
+
+import "github.com/emsg-protocol/emsg-client-sdk/message"
+
+// Receipt metadata keys recording the sender's encryption decision
+const (
+	MetadataEncryptionScheme   = "encryption_scheme"
+	MetadataRecipientsCovered  = "encryption_recipients_covered"
+	MetadataEncryptionFallback = "encryption_fallback_reason"
+)
+
+// EncryptionStats summarizes how tracked messages were encrypted
+type EncryptionStats struct {
+	Encrypted       int                                // Encrypted for every recipient
+	Partial         int                                // Encrypted, but not every recipient can decrypt the body
+	Plaintext       int                                // Sent without encryption
+	FallbackReasons map[message.EncryptionFallback]int // Messages not encrypted for every recipient, by reason
+}
+
+// setEncryptionMetadata stamps an encryption decision into a receipt's metadata
+func setEncryptionMetadata(receipt *DeliveryReceipt, decision *message.EncryptionDecision) {
+	receipt.Metadata[MetadataEncryptionScheme] = decision.Scheme
+	receipt.Metadata[MetadataRecipientsCovered] = decision.Covered
+	receipt.Metadata[MetadataEncryptionFallback] = string(decision.FallbackReason)
+}
+
+// GetEncryptionStats counts tracked messages by how they were encrypted. Receipts
+// imported without an encryption decision are counted by their is_encrypted flag.
+func (dt *DeliveryTracker) GetEncryptionStats() *EncryptionStats {
+	dt.mutex.RLock()
+	defer dt.mutex.RUnlock()
+
+	stats := &EncryptionStats{FallbackReasons: make(map[message.EncryptionFallback]int)}
+	for _, receipt := range dt.receipts {
+		scheme, recorded := receipt.Metadata[MetadataEncryptionScheme].(string)
+		if !recorded {
+			encrypted, _ := receipt.Metadata["is_encrypted"].(bool)
+			if encrypted {
+				scheme = "unknown"
+			}
+		}
+		reason, _ := receipt.Metadata[MetadataEncryptionFallback].(string)
+
+		switch {
+		case scheme == "":
+			stats.Plaintext++
+		case reason == "" || reason == string(message.FallbackUnknown):
+			stats.Encrypted++
+		default:
+			stats.Partial++
+		}
+		if reason != "" {
+			stats.FallbackReasons[message.EncryptionFallback(reason)]++
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,150 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// FailureReason classifies why a delivery failed
+type FailureReason string
+
+const (
+	FailureDNS              FailureReason = "dns_failure"
+	FailureAuthRejected     FailureReason = "auth_rejected"
+	FailureRecipientUnknown FailureReason = "recipient_unknown"
+	FailureRateLimited      FailureReason = "rate_limited"
+	FailurePayloadTooLarge  FailureReason = "payload_too_large"
+	FailureServerError      FailureReason = "server_error"
+	FailureTimeout          FailureReason = "timeout"
+	FailureUnknown          FailureReason = "unknown"
+)
+
+// IsPermanent reports whether a failure cannot be fixed by retrying the same message
+func (r FailureReason) IsPermanent() bool {
+	switch r {
+	case FailureAuthRejected, FailureRecipientUnknown, FailurePayloadTooLarge:
+		return true
+	default:
+		return false
+	}
+}
+
+// httpStatusError is implemented by errors that carry an HTTP status code
+type httpStatusError interface {
+	HTTPStatusCode() int
+}
+
+// dnsError is implemented by errors raised while resolving a recipient domain
+type dnsError interface {
+	DNSFailure() bool
+}
+
+// ClassifyHTTPStatus maps an HTTP status code to a failure reason
+func ClassifyHTTPStatus(statusCode int) FailureReason {
+	switch {
+	case statusCode == 401 || statusCode == 403:
+		return FailureAuthRejected
+	case statusCode == 404 || statusCode == 410:
+		return FailureRecipientUnknown
+	case statusCode == 408 || statusCode == 504:
+		return FailureTimeout
+	case statusCode == 413:
+		return FailurePayloadTooLarge
+	case statusCode == 429:
+		return FailureRateLimited
+	case statusCode >= 500:
+		return FailureServerError
+	default:
+		return FailureUnknown
+	}
+}
+
+// ClassifyError maps a send error to a failure reason
+func ClassifyError(err error) FailureReason {
+	if err == nil {
+		return ""
+	}
+
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		return ClassifyHTTPStatus(statusErr.HTTPStatusCode())
+	}
+
+	var resolveErr dnsError
+	if errors.As(err, &resolveErr) && resolveErr.DNSFailure() {
+		return FailureDNS
+	}
+
+	var netDNSErr *net.DNSError
+	if errors.As(err, &netDNSErr) {
+		if netDNSErr.IsTimeout {
+			return FailureTimeout
+		}
+		return FailureDNS
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureTimeout
+	}
+
+	errStr := err.Error()
+	if strings.Contains(errStr, "timeout") || strings.Contains(errStr, "deadline exceeded") {
+		return FailureTimeout
+	}
+
+	return FailureUnknown
+}
+
+// UpdateDeliveryFailure marks a delivery as failed or retrying and records why
+func (dt *DeliveryTracker) UpdateDeliveryFailure(messageID string, status DeliveryStatus, reason FailureReason, errorMsg string) error {
+	return dt.UpdateDeliveryFailureContext(context.Background(), messageID, status, reason, errorMsg)
+}
+
+// UpdateDeliveryFailureContext is UpdateDeliveryFailure with ctx's values passed on to the callbacks
+func (dt *DeliveryTracker) UpdateDeliveryFailureContext(ctx context.Context, messageID string, status DeliveryStatus, reason FailureReason, errorMsg string) error {
+	dt.mutex.Lock()
+	if receipt, exists := dt.receipts[messageID]; exists {
+		receipt.FailureReason = reason
+	}
+	dt.mutex.Unlock()
+
+	return dt.UpdateDeliveryStatusContext(ctx, messageID, status, errorMsg)
+}
+
+// GetFailureStats returns the number of failed or retrying deliveries per failure reason
+func (dt *DeliveryTracker) GetFailureStats() map[FailureReason]int {
+	dt.mutex.RLock()
+	defer dt.mutex.RUnlock()
+
+	stats := make(map[FailureReason]int)
+	for _, receipt := range dt.receipts {
+		if receipt.FailureReason != "" && receipt.IsRetryable() {
+			stats[receipt.FailureReason]++
+		}
+	}
+
+	return stats
+}
+
+// GetReceiptsByFailureReason returns failed or retrying receipts with the given failure reason
+func (dt *DeliveryTracker) GetReceiptsByFailureReason(reason FailureReason) []*DeliveryReceipt {
+	dt.mutex.RLock()
+	defer dt.mutex.RUnlock()
+
+	var receipts []*DeliveryReceipt
+	for _, receipt := range dt.receipts {
+		if receipt.FailureReason == reason && receipt.IsRetryable() {
+			receiptCopy := *receipt
+			receipts = append(receipts, &receiptCopy)
+		}
+	}
+
+	return receipts
+}
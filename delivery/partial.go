@@ -0,0 +1,62 @@
+package delivery
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// MarkRecipientsUndelivered records recipients a partial delivery could not reach.
+// The receipt keeps its overall status; check Undelivered for the missing recipients.
+func (dt *DeliveryTracker) MarkRecipientsUndelivered(messageID string, recipients []string, errorMsg string) error {
+	return dt.updateUndelivered(messageID, recipients, StatusFailed, errorMsg, func(undelivered map[string]string) {
+		for _, recipient := range recipients {
+			undelivered[recipient] = errorMsg
+		}
+	})
+}
+
+// MarkRecipientsDelivered clears recipients that a later attempt reached
+func (dt *DeliveryTracker) MarkRecipientsDelivered(messageID string, recipients []string) error {
+	return dt.updateUndelivered(messageID, recipients, StatusSent, "", func(undelivered map[string]string) {
+		for _, recipient := range recipients {
+			delete(undelivered, recipient)
+		}
+	})
+}
+
+// updateUndelivered applies update to a copy of the receipt's undelivered
+// recipients, so copies handed out earlier never change underneath their holders,
+// and records status for the recipients it concerns
+func (dt *DeliveryTracker) updateUndelivered(messageID string, recipients []string, status DeliveryStatus, errorMsg string, update func(map[string]string)) error {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	receipt, exists := dt.receipts[messageID]
+	if !exists {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+
+	undelivered := make(map[string]string, len(receipt.Undelivered))
+	for recipient, errorMsg := range receipt.Undelivered {
+		undelivered[recipient] = errorMsg
+	}
+	update(undelivered)
+
+	if len(undelivered) == 0 {
+		undelivered = nil
+	}
+	receipt.Undelivered = undelivered
+
+	tracked := maps.Clone(receipt.Recipients)
+	now := time.Now().Unix()
+	for _, address := range recipients {
+		if recipient, exists := tracked[address]; exists {
+			tracked[address] = recipientUpdate(recipient, status, errorMsg, "", now)
+		}
+	}
+	receipt.Recipients = tracked
+
+	dt.persistLocked(receipt)
+	return nil
+}
@@ -0,0 +1,204 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// PayloadStore is implemented by receipt stores that also keep the messages of
+// deliveries awaiting a retry, so they can be resent after a restart
+type PayloadStore interface {
+	PutPayload(msg *message.Message) error
+	DeletePayload(messageID string) error
+	// Payload returns ErrReceiptNotFound when no message is stored for messageID
+	Payload(messageID string) (*message.Message, error)
+}
+
+// RetainPayload keeps a copy of a tracked message so it can be resent while its
+// delivery is pending or retrying. The copy is released once the delivery
+// reaches any other status, and persisted when the receipt store is a PayloadStore.
+func (dt *DeliveryTracker) RetainPayload(msg *message.Message) error {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	if _, exists := dt.receipts[msg.MessageID]; !exists {
+		return fmt.Errorf("message %s not found in delivery tracker", msg.MessageID)
+	}
+	payload := msg.Clone()
+	dt.payloads[msg.MessageID] = payload
+	if payloads, ok := dt.store.(PayloadStore); ok {
+		if err := payloads.PutPayload(payload); err != nil {
+			return fmt.Errorf("failed to persist message %s: %w", msg.MessageID, err)
+		}
+	}
+	return nil
+}
+
+// Payload returns a copy of the retained message of a delivery, loading it from
+// the receipt store for deliveries recovered after a restart
+func (dt *DeliveryTracker) Payload(messageID string) (*message.Message, bool) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	if _, exists := dt.receipts[messageID]; !exists {
+		return nil, false
+	}
+	payload, exists := dt.payloads[messageID]
+	if !exists {
+		payloads, ok := dt.store.(PayloadStore)
+		if !ok {
+			return nil, false
+		}
+		stored, err := payloads.Payload(messageID)
+		if err != nil {
+			if !errors.Is(err, ErrReceiptNotFound) {
+				dt.logger.Warn("failed to load retained message", "message_id", messageID, "error", err)
+			}
+			return nil, false
+		}
+		payload = stored
+		dt.payloads[messageID] = payload
+	}
+	return payload.Clone(), true
+}
+
+// releasePayloadLocked drops the retained message of a delivery
+func (dt *DeliveryTracker) releasePayloadLocked(messageID string) {
+	delete(dt.payloads, messageID)
+	payloads, ok := dt.store.(PayloadStore)
+	if !ok {
+		return
+	}
+	if err := payloads.DeletePayload(messageID); err != nil && !errors.Is(err, ErrReceiptNotFound) {
+		dt.logger.Warn("failed to delete retained message", "message_id", messageID, "error", err)
+	}
+}
+
+// PutPayload stores the message of a delivery awaiting a retry
+func (m *MemoryReceiptStore) PutPayload(msg *message.Message) error {
+	data, err := marshalPayload(msg)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.payloads[msg.MessageID] = data
+	return nil
+}
+
+// DeletePayload removes the message of a delivery
+func (m *MemoryReceiptStore) DeletePayload(messageID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.payloads[messageID]; !exists {
+		return ErrReceiptNotFound
+	}
+	delete(m.payloads, messageID)
+	return nil
+}
+
+// Payload returns the stored message of a delivery
+func (m *MemoryReceiptStore) Payload(messageID string) (*message.Message, error) {
+	m.mutex.RLock()
+	data, exists := m.payloads[messageID]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return nil, ErrReceiptNotFound
+	}
+	return message.FromJSON(data)
+}
+
+// PutPayload stores the message of a delivery awaiting a retry in the payloads
+// subdirectory
+func (f *FileReceiptStore) PutPayload(msg *message.Message) error {
+	data, err := marshalPayload(msg)
+	if err != nil {
+		return err
+	}
+	path, err := f.payloadPath(msg.MessageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create payload directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+	return nil
+}
+
+// DeletePayload removes the message of a delivery
+func (f *FileReceiptStore) DeletePayload(messageID string) error {
+	path, err := f.payloadPath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrReceiptNotFound
+		}
+		return fmt.Errorf("failed to delete payload: %w", err)
+	}
+	return nil
+}
+
+// Payload returns the stored message of a delivery
+func (f *FileReceiptStore) Payload(messageID string) (*message.Message, error) {
+	path, err := f.payloadPath(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.RLock()
+	data, err := os.ReadFile(path)
+	f.mutex.RUnlock()
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrReceiptNotFound
+		}
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	return message.FromJSON(data)
+}
+
+// payloadPath returns the file path of a delivery's message
+func (f *FileReceiptStore) payloadPath(messageID string) (string, error) {
+	path, err := f.receiptPath(messageID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(f.dir, "payloads", filepath.Base(path)), nil
+}
+
+func marshalPayload(msg *message.Message) ([]byte, error) {
+	if msg == nil || msg.MessageID == "" {
+		return nil, fmt.Errorf("message ID is required")
+	}
+	data, err := msg.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+	return data, nil
+}
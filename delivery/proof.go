@@ -0,0 +1,230 @@
+package delivery
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// DeliveryProofVersion is the format version of generated delivery proofs
+const DeliveryProofVersion = 1
+
+// ServerAcceptance records a recipient server's response to a sent message
+type ServerAcceptance struct {
+	Domain     string `json:"domain"`
+	StatusCode int    `json:"status_code"`
+	Response   string `json:"response,omitempty"` // Raw response body returned by the server
+	AcceptedAt int64  `json:"accepted_at"`
+}
+
+// SignedReceipt is a delivery acknowledgement signed by the recipient's key
+type SignedReceipt struct {
+	MessageID   string `json:"message_id"`
+	Recipient   string `json:"recipient"`
+	MessageHash string `json:"message_hash"` // MessageHash of the message as received
+	Timestamp   int64  `json:"timestamp"`
+	SigningKey  string `json:"signing_key"` // Recipient's base64 Ed25519 public key
+	Signature   string `json:"signature"`
+}
+
+// DeliveryProof bundles everything needed to show that a message was sent and
+// delivered: the original signed message, the servers' acceptance responses and
+// the recipients' signed receipts. It can be checked with VerifyDeliveryProof
+// without access to the sending client.
+type DeliveryProof struct {
+	Version     int                 `json:"version"`
+	Message     *message.Message    `json:"message"`
+	SenderKey   string              `json:"sender_key"` // Sender's base64 Ed25519 public key
+	MessageHash string              `json:"message_hash"`
+	Acceptances []*ServerAcceptance `json:"acceptances"`
+	Receipts    []*SignedReceipt    `json:"receipts,omitempty"`
+	GeneratedAt int64               `json:"generated_at"`
+}
+
+// ProofVerifyOptions controls optional checks performed by VerifyDeliveryProof
+type ProofVerifyOptions struct {
+	// SenderKey, when set, must match the key recorded in the proof
+	SenderKey string
+	// RecipientKey returns the trusted signing key of a recipient. When set,
+	// receipts must be signed by that key rather than only by the key they carry.
+	RecipientKey func(address string) (string, error)
+}
+
+// MessageHash returns the hex SHA-256 of a signed message's JSON encoding
+func MessageHash(msg *message.Message) (string, error) {
+	if msg == nil {
+		return "", fmt.Errorf("message is nil")
+	}
+	if !msg.IsSigned() {
+		return "", fmt.Errorf("message is not signed")
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// NewSignedReceipt creates a receipt for a received message, signed by the recipient
+func NewSignedReceipt(msg *message.Message, recipient string, keyPair *keymgmt.KeyPair) (*SignedReceipt, error) {
+	if keyPair == nil {
+		return nil, fmt.Errorf("no key pair configured")
+	}
+	if msg.MessageID == "" {
+		return nil, fmt.Errorf("message has no ID")
+	}
+
+	hash, err := MessageHash(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &SignedReceipt{
+		MessageID:   msg.MessageID,
+		Recipient:   recipient,
+		MessageHash: hash,
+		Timestamp:   time.Now().Unix(),
+		SigningKey:  keyPair.PublicKeyBase64(),
+	}
+
+	payload, err := receipt.signingPayload()
+	if err != nil {
+		return nil, err
+	}
+	receipt.Signature = base64.StdEncoding.EncodeToString(keyPair.Sign(payload))
+
+	return receipt, nil
+}
+
+// signingPayload creates the payload for receipt signing
+func (sr *SignedReceipt) signingPayload() ([]byte, error) {
+	signing := *sr
+	signing.Signature = ""
+
+	payload, err := json.Marshal(signing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal receipt for signing: %w", err)
+	}
+	return payload, nil
+}
+
+// Verify checks the receipt signature against the key it carries
+func (sr *SignedReceipt) Verify() error {
+	if sr.Signature == "" {
+		return fmt.Errorf("receipt is not signed")
+	}
+
+	pubKey, err := keymgmt.LoadPublicKeyFromBase64(sr.SigningKey)
+	if err != nil {
+		return fmt.Errorf("failed to load receipt signing key: %w", err)
+	}
+
+	payload, err := sr.signingPayload()
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sr.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode receipt signature: %w", err)
+	}
+
+	if !ed25519.Verify(pubKey, payload, signature) {
+		return fmt.Errorf("receipt signature verification failed")
+	}
+
+	return nil
+}
+
+// VerifyDeliveryProof checks that a proof is internally consistent: the message
+// is signed by the sender key, at least one recipient server accepted it, and
+// every receipt is signed, names a recipient of the message and covers the same
+// message content. opts may be nil.
+func VerifyDeliveryProof(proof *DeliveryProof, opts *ProofVerifyOptions) error {
+	if proof == nil || proof.Message == nil {
+		return fmt.Errorf("proof has no message")
+	}
+	if opts == nil {
+		opts = &ProofVerifyOptions{}
+	}
+
+	msg := proof.Message
+	if opts.SenderKey != "" && opts.SenderKey != proof.SenderKey {
+		return fmt.Errorf("proof is not for the expected sender key")
+	}
+	if err := msg.Verify(proof.SenderKey); err != nil {
+		return fmt.Errorf("message signature invalid: %w", err)
+	}
+
+	hash, err := MessageHash(msg)
+	if err != nil {
+		return err
+	}
+	if hash != proof.MessageHash {
+		return fmt.Errorf("message hash mismatch")
+	}
+
+	recipients := make(map[string]bool)
+	domains := make(map[string]bool)
+	for _, recipient := range msg.GetRecipients() {
+		recipients[utils.NormalizeEMSGAddress(recipient)] = true
+		if domain, err := utils.ExtractDomainFromEMSGAddress(recipient); err == nil {
+			domains[domain] = true
+		}
+	}
+
+	if len(proof.Acceptances) == 0 {
+		return fmt.Errorf("proof has no server acceptances")
+	}
+	for _, acceptance := range proof.Acceptances {
+		if !domains[acceptance.Domain] {
+			return fmt.Errorf("acceptance from %s is not a recipient domain", acceptance.Domain)
+		}
+		if acceptance.StatusCode < 200 || acceptance.StatusCode >= 300 {
+			return fmt.Errorf("server %s did not accept the message (status %d)", acceptance.Domain, acceptance.StatusCode)
+		}
+		if acceptance.AcceptedAt < msg.Timestamp {
+			return fmt.Errorf("acceptance from %s predates the message", acceptance.Domain)
+		}
+	}
+
+	for _, receipt := range proof.Receipts {
+		if receipt.MessageID != msg.MessageID {
+			return fmt.Errorf("receipt from %s is for message %s", receipt.Recipient, receipt.MessageID)
+		}
+		if !recipients[utils.NormalizeEMSGAddress(receipt.Recipient)] {
+			return fmt.Errorf("receipt from %s is not from a recipient", receipt.Recipient)
+		}
+		if receipt.MessageHash != proof.MessageHash {
+			return fmt.Errorf("receipt from %s covers different message content", receipt.Recipient)
+		}
+		if receipt.Timestamp < msg.Timestamp {
+			return fmt.Errorf("receipt from %s predates the message", receipt.Recipient)
+		}
+		if err := receipt.Verify(); err != nil {
+			return fmt.Errorf("receipt from %s: %w", receipt.Recipient, err)
+		}
+		if opts.RecipientKey != nil {
+			key, err := opts.RecipientKey(receipt.Recipient)
+			if err != nil {
+				return fmt.Errorf("failed to look up key for %s: %w", receipt.Recipient, err)
+			}
+			if key != receipt.SigningKey {
+				return fmt.Errorf("receipt from %s is not signed by the recipient's key", receipt.Recipient)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReceiptNotFound is returned when a receipt is not in a receipt store
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ReceiptStore persists delivery receipts, keyed by message ID, so tracked
+// deliveries and pending retries survive process restarts
+type ReceiptStore interface {
+	Put(receipt *DeliveryReceipt) error
+	Delete(messageID string) error
+	// List returns all stored receipts, ordered by message ID
+	List() ([]*DeliveryReceipt, error)
+}
+
+// SetReceiptStore persists receipts to store from now on and recovers the
+// receipts it holds from an earlier run, so GetPendingRetries includes retries
+// scheduled before a restart. Stored receipts that have expired are removed
+// instead, as are the oldest beyond SetMaxReceipts. Receipts already tracked
+// take precedence over stored ones.
+func (dt *DeliveryTracker) SetReceiptStore(store ReceiptStore) error {
+	stored, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to load delivery receipts: %w", err)
+	}
+
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	var errs []error
+	for _, receipt := range stored {
+		if _, tracked := dt.receipts[receipt.MessageID]; tracked {
+			continue
+		}
+		if dt.expiredLocked(receipt) {
+			if err := store.Delete(receipt.MessageID); err != nil && !errors.Is(err, ErrReceiptNotFound) {
+				errs = append(errs, err)
+			}
+			if payloads, ok := store.(PayloadStore); ok {
+				if err := payloads.DeletePayload(receipt.MessageID); err != nil && !errors.Is(err, ErrReceiptNotFound) {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		dt.receipts[receipt.MessageID] = receipt
+	}
+
+	dt.store = store
+	for dt.maxReceipts > 0 && len(dt.receipts) > dt.maxReceipts {
+		dt.evictReceiptLocked()
+	}
+	for _, receipt := range dt.receipts {
+		if err := store.Put(receipt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WipeReceipts drops every receipt, in memory and in the receipt store
+func (dt *DeliveryTracker) WipeReceipts() error {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	var errs []error
+	for messageID := range dt.receipts {
+		delete(dt.receipts, messageID)
+		dt.releasePayloadLocked(messageID)
+		if dt.store != nil {
+			if err := dt.store.Delete(messageID); err != nil && !errors.Is(err, ErrReceiptNotFound) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// persistLocked writes a receipt to the receipt store, if any. Receipts stay
+// tracked in memory when the write fails.
+func (dt *DeliveryTracker) persistLocked(receipt *DeliveryReceipt) {
+	if dt.store == nil {
+		return
+	}
+	if err := dt.store.Put(receipt); err != nil {
+		dt.logger.Warn("failed to persist delivery receipt", "message_id", receipt.MessageID, "error", err)
+	}
+}
+
+// forgetLocked drops a receipt and its retained message from memory and the
+// receipt store
+func (dt *DeliveryTracker) forgetLocked(messageID string) {
+	delete(dt.receipts, messageID)
+	dt.releasePayloadLocked(messageID)
+	if dt.store == nil {
+		return
+	}
+	if err := dt.store.Delete(messageID); err != nil && !errors.Is(err, ErrReceiptNotFound) {
+		dt.logger.Warn("failed to delete delivery receipt", "message_id", messageID, "error", err)
+	}
+}
+
+// expiredLocked reports whether a receipt is older than the expiration time
+func (dt *DeliveryTracker) expiredLocked(receipt *DeliveryReceipt) bool {
+	return time.Since(time.Unix(receipt.Timestamp, 0)) > dt.retryStrategy.ExpirationTime
+}
+
+// MemoryReceiptStore is an in-memory implementation of ReceiptStore. Receipts
+// do not survive a restart.
+type MemoryReceiptStore struct {
+	receipts map[string][]byte
+	payloads map[string][]byte
+	mutex    sync.RWMutex
+}
+
+// NewMemoryReceiptStore creates a new in-memory receipt store
+func NewMemoryReceiptStore() *MemoryReceiptStore {
+	return &MemoryReceiptStore{
+		receipts: make(map[string][]byte),
+		payloads: make(map[string][]byte),
+	}
+}
+
+// Put stores a receipt, replacing any receipt for the same message
+func (m *MemoryReceiptStore) Put(receipt *DeliveryReceipt) error {
+	data, err := marshalReceipt(receipt)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.receipts[receipt.MessageID] = data
+	return nil
+}
+
+// Delete removes a receipt
+func (m *MemoryReceiptStore) Delete(messageID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.receipts[messageID]; !exists {
+		return ErrReceiptNotFound
+	}
+	delete(m.receipts, messageID)
+	return nil
+}
+
+// List returns all stored receipts, ordered by message ID
+func (m *MemoryReceiptStore) List() ([]*DeliveryReceipt, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	receipts := make([]*DeliveryReceipt, 0, len(m.receipts))
+	for _, data := range m.receipts {
+		receipt, err := FromJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].MessageID < receipts[j].MessageID })
+	return receipts, nil
+}
+
+// FileReceiptStore stores each receipt as a JSON file in a directory so tracked
+// deliveries survive process restarts
+type FileReceiptStore struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+// NewFileReceiptStore creates a file-backed receipt store rooted at dir
+func NewFileReceiptStore(dir string) (*FileReceiptStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create receipt directory: %w", err)
+	}
+	return &FileReceiptStore{dir: dir}, nil
+}
+
+// Put stores a receipt, replacing any receipt for the same message
+func (f *FileReceiptStore) Put(receipt *DeliveryReceipt) error {
+	data, err := marshalReceipt(receipt)
+	if err != nil {
+		return err
+	}
+
+	path, err := f.receiptPath(receipt.MessageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partial receipt
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write receipt: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write receipt: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a receipt
+func (f *FileReceiptStore) Delete(messageID string) error {
+	path, err := f.receiptPath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrReceiptNotFound
+		}
+		return fmt.Errorf("failed to delete receipt: %w", err)
+	}
+	return nil
+}
+
+// List returns all stored receipts, ordered by message ID. Temporary files left
+// by writes interrupted in an earlier run are removed.
+func (f *FileReceiptStore) List() ([]*DeliveryReceipt, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipt directory: %w", err)
+	}
+
+	var receipts []*DeliveryReceipt
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(f.dir, name)
+		switch {
+		case entry.IsDir():
+			continue
+		case strings.HasSuffix(name, ".tmp"):
+			os.Remove(path)
+			continue
+		case !strings.HasSuffix(name, ".json"):
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read receipt: %w", err)
+		}
+		receipt, err := FromJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: %w", strings.TrimSuffix(name, ".json"), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].MessageID < receipts[j].MessageID })
+	return receipts, nil
+}
+
+// receiptPath returns the file path for a message ID, rejecting IDs that would escape the store
+func (f *FileReceiptStore) receiptPath(messageID string) (string, error) {
+	if messageID == "" || messageID != filepath.Base(messageID) || messageID[0] == '.' {
+		return "", fmt.Errorf("invalid message ID: %q", messageID)
+	}
+	return filepath.Join(f.dir, messageID+".json"), nil
+}
+
+func marshalReceipt(receipt *DeliveryReceipt) ([]byte, error) {
+	if receipt == nil || receipt.MessageID == "" {
+		return nil, fmt.Errorf("message ID is required")
+	}
+	data, err := receipt.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize receipt: %w", err)
+	}
+	return data, nil
+}
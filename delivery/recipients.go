@@ -0,0 +1,199 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// RecipientStatus is the delivery state of one recipient of a message
+type RecipientStatus struct {
+	Domain        string         `json:"domain"`
+	Status        DeliveryStatus `json:"status"`
+	ErrorMessage  string         `json:"error_message,omitempty"`
+	FailureReason FailureReason  `json:"failure_reason,omitempty"`
+	Timestamp     int64          `json:"timestamp"`
+}
+
+// DomainStatuses aggregates the recipients' statuses per recipient domain
+func (dr *DeliveryReceipt) DomainStatuses() map[string]DeliveryStatus {
+	byDomain := make(map[string][]DeliveryStatus)
+	for _, recipient := range dr.Recipients {
+		byDomain[recipient.Domain] = append(byDomain[recipient.Domain], recipient.Status)
+	}
+
+	statuses := make(map[string]DeliveryStatus, len(byDomain))
+	for domain, recipientStatuses := range byDomain {
+		statuses[domain] = aggregateStatus(recipientStatuses)
+	}
+	return statuses
+}
+
+// UpdateRecipientStatus updates the delivery status of one recipient of a message
+func (dt *DeliveryTracker) UpdateRecipientStatus(messageID, recipient string, status DeliveryStatus, errorMsg string) error {
+	return dt.UpdateRecipientStatusContext(context.Background(), messageID, recipient, status, errorMsg)
+}
+
+// UpdateRecipientStatusContext updates the delivery status of one recipient of a
+// message. The receipt's overall status becomes the aggregate of its recipients',
+// and callbacks run with ctx's values when that changes it.
+func (dt *DeliveryTracker) UpdateRecipientStatusContext(ctx context.Context, messageID, recipient string, status DeliveryStatus, errorMsg string) error {
+	recipient = utils.NormalizeEMSGAddress(recipient)
+	return dt.updateRecipients(ctx, messageID, status, errorMsg, func(address string, _ RecipientStatus) bool {
+		return utils.NormalizeEMSGAddress(address) == recipient
+	})
+}
+
+// UpdateDomainStatus updates the delivery status of a message's recipients on one domain
+func (dt *DeliveryTracker) UpdateDomainStatus(messageID, domain string, status DeliveryStatus, errorMsg string) error {
+	return dt.UpdateDomainStatusContext(context.Background(), messageID, domain, status, errorMsg)
+}
+
+// UpdateDomainStatusContext updates the delivery status of a message's recipients
+// on one domain, aggregating the overall status like UpdateRecipientStatusContext
+func (dt *DeliveryTracker) UpdateDomainStatusContext(ctx context.Context, messageID, domain string, status DeliveryStatus, errorMsg string) error {
+	domain = strings.ToLower(domain)
+	return dt.updateRecipients(ctx, messageID, status, errorMsg, func(_ string, recipient RecipientStatus) bool {
+		return recipient.Domain == domain
+	})
+}
+
+// updateRecipients sets the status of the recipients selected by match and
+// re-aggregates the overall status
+func (dt *DeliveryTracker) updateRecipients(ctx context.Context, messageID string, status DeliveryStatus, errorMsg string, match func(string, RecipientStatus) bool) error {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	receipt, exists := dt.receipts[messageID]
+	if !exists {
+		return fmt.Errorf("message %s not found in delivery tracker", messageID)
+	}
+
+	// Replace the map rather than changing it, so copies handed out earlier never
+	// change underneath their holders
+	recipients := maps.Clone(receipt.Recipients)
+	now := time.Now().Unix()
+	matched := false
+	for address, recipient := range recipients {
+		if !match(address, recipient) {
+			continue
+		}
+		matched = true
+		recipients[address] = recipientUpdate(recipient, status, errorMsg, "", now)
+	}
+	if !matched {
+		return fmt.Errorf("no matching recipients tracked for message %s", messageID)
+	}
+	receipt.Recipients = recipients
+
+	oldStatus := receipt.Status
+	receipt.Status = aggregateStatus(recipientStatuses(recipients))
+	receipt.Timestamp = now
+	if status == StatusFailed && errorMsg != "" {
+		receipt.ErrorMessage = errorMsg
+	}
+	dt.persistLocked(receipt)
+
+	if receipt.Status != StatusPending && receipt.Status != StatusRetrying {
+		dt.releasePayloadLocked(messageID)
+	}
+	if oldStatus != receipt.Status {
+		dt.triggerCallbacks(ctx, messageID, receipt)
+	}
+	return nil
+}
+
+// applyToRecipientsLocked carries a whole-message status update over to the
+// recipients still concerned by it: successes skip recipients a partial delivery
+// missed, and failures skip recipients the message already reached
+func (dt *DeliveryTracker) applyToRecipientsLocked(receipt *DeliveryReceipt, status DeliveryStatus, errorMsg string) {
+	if len(receipt.Recipients) == 0 {
+		return
+	}
+
+	recipients := maps.Clone(receipt.Recipients)
+	now := time.Now().Unix()
+	for address, recipient := range recipients {
+		switch status {
+		case StatusSent, StatusDelivered:
+			if _, missed := receipt.Undelivered[address]; missed || recipient.Status == StatusDelivered {
+				continue
+			}
+		default:
+			if recipient.Status == StatusSent || recipient.Status == StatusDelivered {
+				continue
+			}
+		}
+		recipients[address] = recipientUpdate(recipient, status, errorMsg, receipt.FailureReason, now)
+	}
+	receipt.Recipients = recipients
+}
+
+// recipientUpdate returns a recipient's state after a status change. Successful
+// statuses clear the recipient's earlier failure.
+func recipientUpdate(recipient RecipientStatus, status DeliveryStatus, errorMsg string, reason FailureReason, now int64) RecipientStatus {
+	recipient.Status = status
+	recipient.Timestamp = now
+	switch status {
+	case StatusSent, StatusDelivered:
+		recipient.ErrorMessage = ""
+		recipient.FailureReason = ""
+	default:
+		if errorMsg != "" {
+			recipient.ErrorMessage = errorMsg
+		}
+		if reason != "" {
+			recipient.FailureReason = reason
+		}
+	}
+	return recipient
+}
+
+// trackedRecipients returns the initial per-recipient states for a message
+func trackedRecipients(msg *message.Message, now int64) map[string]RecipientStatus {
+	recipients := make(map[string]RecipientStatus)
+	for _, address := range msg.GetRecipients() {
+		domain, _ := utils.ExtractDomainFromEMSGAddress(address)
+		recipients[address] = RecipientStatus{Domain: strings.ToLower(domain), Status: StatusPending, Timestamp: now}
+	}
+	return recipients
+}
+
+// aggregateStatus combines recipient statuses into an overall status: retrying
+// or pending while any recipient is, delivered once all are, sent once any was
+// reached, and otherwise expired or failed
+func aggregateStatus(statuses []DeliveryStatus) DeliveryStatus {
+	counts := make(map[DeliveryStatus]int, len(statuses))
+	for _, status := range statuses {
+		counts[status]++
+	}
+
+	switch {
+	case counts[StatusRetrying] > 0:
+		return StatusRetrying
+	case counts[StatusPending] > 0:
+		return StatusPending
+	case counts[StatusDelivered] == len(statuses):
+		return StatusDelivered
+	case counts[StatusSent] > 0 || counts[StatusDelivered] > 0:
+		return StatusSent
+	case counts[StatusExpired] == len(statuses):
+		return StatusExpired
+	default:
+		return StatusFailed
+	}
+}
+
+// recipientStatuses returns the statuses of a message's recipients
+func recipientStatuses(recipients map[string]RecipientStatus) []DeliveryStatus {
+	statuses := make([]DeliveryStatus, 0, len(recipients))
+	for _, recipient := range recipients {
+		statuses = append(statuses, recipient.Status)
+	}
+	return statuses
+}
@@ -0,0 +1,204 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+)
+
+// DiscoveryMode selects how identifiers are matched against the server
+type DiscoveryMode string
+
+const (
+	// ModeDirect sends every hashed identifier to the server
+	ModeDirect DiscoveryMode = "direct"
+	// ModeBloomFilter downloads a Bloom filter of registered hashes and only
+	// sends identifiers that may be registered, so most of the address book
+	// never leaves the device
+	ModeBloomFilter DiscoveryMode = "bloom_filter"
+)
+
+// Options controls a contact discovery request
+type Options struct {
+	Mode        DiscoveryMode
+	Salt        []byte // Server-published salt mixed into every hash
+	PinKeys     bool   // Pin returned key bundles into the encryption key store
+	MaxPerQuery int    // Maximum hashes per request (0 = no limit)
+}
+
+// DefaultOptions returns default discovery options
+func DefaultOptions() *Options {
+	return &Options{
+		Mode:        ModeDirect,
+		PinKeys:     true,
+		MaxPerQuery: 1000,
+	}
+}
+
+// Contact is a local identifier that belongs to a registered EMSG user
+type Contact struct {
+	Identifier string                `json:"identifier"`
+	Hash       string                `json:"hash"`
+	Address    string                `json:"address"`
+	KeyBundle  *encryption.KeyBundle `json:"key_bundle,omitempty"`
+}
+
+// Request is the body of a discovery query
+type Request struct {
+	Hashes []string `json:"hashes"`
+}
+
+// Match is a single server-side match for a hashed identifier
+type Match struct {
+	Hash      string                `json:"hash"`
+	Address   string                `json:"address"`
+	KeyBundle *encryption.KeyBundle `json:"key_bundle,omitempty"`
+}
+
+// Response is the body returned by a discovery query
+type Response struct {
+	Matches []*Match `json:"matches"`
+}
+
+// NormalizeIdentifier canonicalizes an email address or phone number so that
+// equivalent spellings hash identically
+func NormalizeIdentifier(identifier string) string {
+	identifier = strings.TrimSpace(identifier)
+
+	if strings.Contains(identifier, "@") {
+		return strings.ToLower(identifier)
+	}
+
+	// Treat everything else as a phone number: keep digits and a leading plus
+	var b strings.Builder
+	for i, r := range identifier {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else if r == '+' && i == 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// HashIdentifier returns the salted SHA-256 hash of a normalized identifier
+func HashIdentifier(identifier string, salt []byte) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(NormalizeIdentifier(identifier)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// HashIdentifiers hashes a list of identifiers, returning a map from hash to the original identifier
+func HashIdentifiers(identifiers []string, salt []byte) map[string]string {
+	hashes := make(map[string]string, len(identifiers))
+	for _, identifier := range identifiers {
+		if NormalizeIdentifier(identifier) == "" {
+			continue
+		}
+		hashes[HashIdentifier(identifier, salt)] = identifier
+	}
+	return hashes
+}
+
+// Limits on Bloom filters, which servers choose: a filter beyond them would make
+// the client allocate and hash without bound
+const (
+	MaxBloomFilterBits   = 1 << 27 // 16 MiB of bits
+	MaxBloomFilterHashes = 64
+)
+
+// BloomFilter is a probabilistic set of identifier hashes
+type BloomFilter struct {
+	Bits      []byte `json:"bits"`
+	NumBits   uint32 `json:"num_bits"`
+	NumHashes uint32 `json:"num_hashes"`
+}
+
+// NewBloomFilter creates an empty Bloom filter with the given size and hash count
+func NewBloomFilter(numBits, numHashes uint32) (*BloomFilter, error) {
+	filter := &BloomFilter{NumBits: numBits, NumHashes: numHashes}
+	if err := filter.checkParameters(); err != nil {
+		return nil, err
+	}
+	filter.Bits = make([]byte, (numBits+7)/8)
+	return filter, nil
+}
+
+// Validate checks a filter received from a server: its size and hash count must
+// be positive and within MaxBloomFilterBits and MaxBloomFilterHashes, and it
+// must hold as many bits as it declares
+func (bf *BloomFilter) Validate() error {
+	if err := bf.checkParameters(); err != nil {
+		return err
+	}
+	if uint64(len(bf.Bits))*8 < uint64(bf.NumBits) {
+		return fmt.Errorf("bloom filter holds %d bits, declares %d", uint64(len(bf.Bits))*8, bf.NumBits)
+	}
+	return nil
+}
+
+// checkParameters checks the size and hash count against the limits
+func (bf *BloomFilter) checkParameters() error {
+	if bf.NumBits == 0 || bf.NumHashes == 0 {
+		return fmt.Errorf("bloom filter size and hash count must be positive")
+	}
+	if bf.NumBits > MaxBloomFilterBits {
+		return fmt.Errorf("bloom filter size %d exceeds limit of %d bits", bf.NumBits, MaxBloomFilterBits)
+	}
+	if bf.NumHashes > MaxBloomFilterHashes {
+		return fmt.Errorf("bloom filter hash count %d exceeds limit of %d", bf.NumHashes, MaxBloomFilterHashes)
+	}
+	return nil
+}
+
+// positions derives the bit positions for a value using double hashing
+func (bf *BloomFilter) positions(value string) []uint32 {
+	sum := sha256.Sum256([]byte(value))
+	h1 := binary.BigEndian.Uint32(sum[0:4])
+	h2 := binary.BigEndian.Uint32(sum[4:8]) | 1
+
+	positions := make([]uint32, bf.NumHashes)
+	for i := uint32(0); i < bf.NumHashes; i++ {
+		positions[i] = (h1 + i*h2) % bf.NumBits
+	}
+	return positions
+}
+
+// Add inserts a hash into the filter
+func (bf *BloomFilter) Add(hash string) {
+	for _, pos := range bf.positions(hash) {
+		bf.Bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+// MayContain reports whether a hash may be in the filter. False positives are
+// possible; false negatives are not.
+func (bf *BloomFilter) MayContain(hash string) bool {
+	if bf.Validate() != nil {
+		return false
+	}
+
+	for _, pos := range bf.positions(hash) {
+		if bf.Bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Candidates returns the hashes that may be present in the filter
+func (bf *BloomFilter) Candidates(hashes map[string]string) []string {
+	candidates := make([]string, 0)
+	for hash := range hashes {
+		if bf.MayContain(hash) {
+			candidates = append(candidates, hash)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// LookupTXTFunc resolves the TXT records of a DNS name
+type LookupTXTFunc func(ctx context.Context, name string) ([]string, error)
+
+// maxDoHResponseSize bounds the DNS-over-HTTPS responses read
+const maxDoHResponseSize = 64 * 1024
+
+// Validate checks that at most one DNS backend is set and that it is well formed
+func (c *ResolverConfig) Validate() error {
+	backends := 0
+	if c.Nameserver != "" {
+		backends++
+		host, port, err := net.SplitHostPort(c.Nameserver)
+		if err != nil {
+			return fmt.Errorf("invalid nameserver %q: must be IP:port: %w", c.Nameserver, err)
+		}
+		if net.ParseIP(host) == nil || port == "" {
+			return fmt.Errorf("invalid nameserver %q: must be IP:port", c.Nameserver)
+		}
+	}
+	if c.DoHURL != "" {
+		backends++
+		u, err := url.Parse(c.DoHURL)
+		if err != nil {
+			return fmt.Errorf("invalid DoH URL: %w", err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid DoH URL %q: must be an https URL", c.DoHURL)
+		}
+	}
+	if c.LookupTXT != nil {
+		backends++
+	}
+	if backends > 1 {
+		return fmt.Errorf("only one of Nameserver, DoHURL and LookupTXT may be set")
+	}
+	if err := validateDiscoveryOrder(c.DiscoveryOrder); err != nil {
+		return err
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	return nil
+}
+
+// lookupFunc returns the TXT lookup of the configured DNS backend
+func (c *ResolverConfig) lookupFunc() LookupTXTFunc {
+	switch {
+	case c.LookupTXT != nil:
+		return c.LookupTXT
+	case c.DoHURL != "":
+		return DoHLookup(c.DoHURL, c.httpClient())
+	case c.Nameserver != "":
+		return NameserverLookup(c.Nameserver)
+	default:
+		return net.DefaultResolver.LookupTXT
+	}
+}
+
+// httpClient returns the client for DoH and .well-known requests
+func (c *ResolverConfig) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: c.Timeout}
+}
+
+// NameserverLookup returns a TXT lookup that queries one nameserver (IP:port)
+// with Go's built-in resolver, bypassing the system's resolver configuration
+func NameserverLookup(nameserver string) LookupTXTFunc {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, nameserver)
+		},
+	}
+	return resolver.LookupTXT
+}
+
+// DoHLookup returns a TXT lookup that queries a DNS-over-HTTPS endpoint with
+// RFC 8484 POST requests, for networks that block plain DNS
+func DoHLookup(endpoint string, httpClient *http.Client) LookupTXTFunc {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return func(ctx context.Context, name string) ([]string, error) {
+		query, err := encodeTXTQuery(name)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DoH request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("DoH query failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("DoH query failed with status %d", resp.StatusCode)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DoH response: %w", err)
+		}
+		return decodeTXTResponse(name, body)
+	}
+}
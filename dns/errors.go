@@ -0,0 +1,27 @@
+package dns
+
+import "errors"
+
+// ErrDomainResolution matches any *ResolutionError with errors.Is
+var ErrDomainResolution = errors.New("domain resolution failed")
+
+// ResolutionError is returned when an EMSG domain cannot be resolved to a server
+type ResolutionError struct {
+	Domain string
+	Err    error
+}
+
+// Error implements the error interface
+func (e *ResolutionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying lookup or parse error
+func (e *ResolutionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrDomainResolution
+func (e *ResolutionError) Is(target error) bool {
+	return target == ErrDomainResolution
+}
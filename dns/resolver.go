@@ -1,11 +1,13 @@
 package dns
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +22,16 @@ type EMSGServerInfo struct {
 type ResolverConfig struct {
 	Timeout time.Duration
 	Retries int
+
+	// DNS backend: set at most one; none uses the system resolver
+	Nameserver string        // Query this nameserver (IP:port) directly
+	DoHURL     string        // Query this DNS-over-HTTPS endpoint (RFC 8484), e.g. "https://cloudflare-dns.com/dns-query"
+	LookupTXT  LookupTXTFunc // Resolve TXT records with this function, e.g. a pure-Go resolver
+	HTTPClient *http.Client  // Client for DoH and .well-known requests (default: a client with Timeout)
+
+	// Discovery methods tried in order until one finds the domain's server
+	// (default: DNS, then .well-known)
+	DiscoveryOrder []DiscoveryMethod
 }
 
 // DefaultResolverConfig returns a default resolver configuration
@@ -32,7 +44,9 @@ func DefaultResolverConfig() *ResolverConfig {
 
 // Resolver handles EMSG DNS resolution
 type Resolver struct {
-	config *ResolverConfig
+	config     *ResolverConfig
+	lookup     LookupTXTFunc
+	httpClient *http.Client
 }
 
 // NewResolver creates a new DNS resolver with the given configuration
@@ -40,20 +54,55 @@ func NewResolver(config *ResolverConfig) *Resolver {
 	if config == nil {
 		config = DefaultResolverConfig()
 	}
-	return &Resolver{config: config}
+	return &Resolver{config: config, lookup: config.lookupFunc(), httpClient: config.httpClient()}
 }
 
 // ResolveDomain resolves an EMSG domain to server information
 func (r *Resolver) ResolveDomain(domain string) (*EMSGServerInfo, error) {
+	return r.ResolveDomainContext(context.Background(), domain)
+}
+
+// ResolveDomainContext resolves an EMSG domain to server information, stopping early
+// if ctx is done. The discovery methods are tried in the configured order, and
+// the error reports why each of them failed.
+func (r *Resolver) ResolveDomainContext(ctx context.Context, domain string) (*EMSGServerInfo, error) {
 	if domain == "" {
-		return nil, fmt.Errorf("domain cannot be empty")
+		return nil, &ResolutionError{Domain: domain, Err: fmt.Errorf("domain cannot be empty")}
 	}
 
+	var resolveErr error
+	for _, method := range r.config.discoveryOrder() {
+		var serverInfo *EMSGServerInfo
+		var err error
+		switch method {
+		case DiscoveryWellKnown:
+			serverInfo, err = r.resolveWellKnown(ctx, domain)
+		default:
+			serverInfo, err = r.resolveTXT(ctx, domain)
+		}
+		if err == nil {
+			return serverInfo, nil
+		}
+		if ctx.Err() != nil {
+			return nil, &ResolutionError{Domain: domain, Err: err}
+		}
+
+		if resolveErr == nil {
+			resolveErr = err
+		} else {
+			resolveErr = fmt.Errorf("%w; %w", resolveErr, err)
+		}
+	}
+	return nil, &ResolutionError{Domain: domain, Err: resolveErr}
+}
+
+// resolveTXT discovers a domain's server from its _emsg TXT records
+func (r *Resolver) resolveTXT(ctx context.Context, domain string) (*EMSGServerInfo, error) {
 	// Construct the EMSG DNS name
 	dnsName := fmt.Sprintf("_emsg.%s", domain)
 
 	// Perform TXT record lookup
-	txtRecords, err := r.lookupTXT(dnsName)
+	txtRecords, err := r.lookupTXT(ctx, dnsName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to lookup TXT records for %s: %w", dnsName, err)
 	}
@@ -75,38 +124,52 @@ func (r *Resolver) ResolveDomain(domain string) (*EMSGServerInfo, error) {
 }
 
 // lookupTXT performs a TXT record lookup with retries
-func (r *Resolver) lookupTXT(name string) ([]string, error) {
+func (r *Resolver) lookupTXT(ctx context.Context, name string) ([]string, error) {
 	var lastErr error
-	
+
 	for i := 0; i < r.config.Retries; i++ {
-		txtRecords, err := net.LookupTXT(name)
+		txtRecords, err := r.lookupTXTOnce(ctx, name)
 		if err == nil {
 			return txtRecords, nil
 		}
 		lastErr = err
-		
+
 		if i < r.config.Retries-1 {
-			time.Sleep(time.Duration(i+1) * time.Second)
+			select {
+			case <-time.After(time.Duration(i+1) * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 		}
 	}
-	
+
 	return nil, lastErr
 }
 
+// lookupTXTOnce performs a single TXT lookup bounded by the configured timeout
+func (r *Resolver) lookupTXTOnce(ctx context.Context, name string) ([]string, error) {
+	if r.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.Timeout)
+		defer cancel()
+	}
+	return r.lookup(ctx, name)
+}
+
 // parseTXTRecord parses a TXT record to extract EMSG server information
 func (r *Resolver) parseTXTRecord(record string) (*EMSGServerInfo, error) {
 	record = strings.TrimSpace(record)
-	
+
 	// Try JSON format first
 	if strings.HasPrefix(record, "{") && strings.HasSuffix(record, "}") {
 		return r.parseJSONRecord(record)
 	}
-	
+
 	// Try URL format
 	if strings.HasPrefix(record, "http://") || strings.HasPrefix(record, "https://") {
 		return r.parseURLRecord(record)
 	}
-	
+
 	// Try key-value format (e.g., "url=https://example.com pubkey=abc123")
 	return r.parseKeyValueRecord(record)
 }
@@ -118,16 +181,16 @@ func (r *Resolver) parseJSONRecord(record string) (*EMSGServerInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JSON record: %w", err)
 	}
-	
+
 	if serverInfo.URL == "" {
 		return nil, fmt.Errorf("missing URL in JSON record")
 	}
-	
+
 	// Validate URL
 	if err := r.validateURL(serverInfo.URL); err != nil {
 		return nil, fmt.Errorf("invalid URL in JSON record: %w", err)
 	}
-	
+
 	return &serverInfo, nil
 }
 
@@ -136,7 +199,7 @@ func (r *Resolver) parseURLRecord(record string) (*EMSGServerInfo, error) {
 	if err := r.validateURL(record); err != nil {
 		return nil, fmt.Errorf("invalid URL record: %w", err)
 	}
-	
+
 	return &EMSGServerInfo{
 		URL: record,
 	}, nil
@@ -145,7 +208,7 @@ func (r *Resolver) parseURLRecord(record string) (*EMSGServerInfo, error) {
 // parseKeyValueRecord parses a key-value formatted TXT record
 func (r *Resolver) parseKeyValueRecord(record string) (*EMSGServerInfo, error) {
 	serverInfo := &EMSGServerInfo{}
-	
+
 	// Split by spaces and parse key=value pairs
 	parts := strings.Fields(record)
 	for _, part := range parts {
@@ -153,10 +216,10 @@ func (r *Resolver) parseKeyValueRecord(record string) (*EMSGServerInfo, error) {
 		if len(kv) != 2 {
 			continue
 		}
-		
+
 		key := strings.ToLower(strings.TrimSpace(kv[0]))
 		value := strings.TrimSpace(kv[1])
-		
+
 		switch key {
 		case "url":
 			serverInfo.URL = value
@@ -166,16 +229,16 @@ func (r *Resolver) parseKeyValueRecord(record string) (*EMSGServerInfo, error) {
 			serverInfo.Version = value
 		}
 	}
-	
+
 	if serverInfo.URL == "" {
 		return nil, fmt.Errorf("missing URL in key-value record")
 	}
-	
+
 	// Validate URL
 	if err := r.validateURL(serverInfo.URL); err != nil {
 		return nil, fmt.Errorf("invalid URL in key-value record: %w", err)
 	}
-	
+
 	return serverInfo, nil
 }
 
@@ -185,15 +248,15 @@ func (r *Resolver) validateURL(urlStr string) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse URL: %w", err)
 	}
-	
+
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
 		return fmt.Errorf("URL must use HTTP or HTTPS scheme, got: %s", parsedURL.Scheme)
 	}
-	
+
 	if parsedURL.Host == "" {
 		return fmt.Errorf("URL must have a host")
 	}
-	
+
 	return nil
 }
 
@@ -204,7 +267,7 @@ func (r *Resolver) ResolveEMSGAddress(address string) (*EMSGServerInfo, error) {
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid EMSG address format: %s", address)
 	}
-	
+
 	domain := parts[1]
 	return r.ResolveDomain(domain)
 }
@@ -218,9 +281,11 @@ type CacheEntry struct {
 
 // CachedResolver wraps a resolver with caching capabilities
 type CachedResolver struct {
-	resolver *Resolver
-	cache    map[string]*CacheEntry
+	resolver   *Resolver
+	cache      map[string]*CacheEntry
 	defaultTTL time.Duration
+	maxEntries int // Zero means unlimited
+	mutex      sync.Mutex
 }
 
 // NewCachedResolver creates a new cached resolver
@@ -228,7 +293,7 @@ func NewCachedResolver(config *ResolverConfig, ttl time.Duration) *CachedResolve
 	if ttl == 0 {
 		ttl = 5 * time.Minute // Default TTL
 	}
-	
+
 	return &CachedResolver{
 		resolver:   NewResolver(config),
 		cache:      make(map[string]*CacheEntry),
@@ -236,29 +301,83 @@ func NewCachedResolver(config *ResolverConfig, ttl time.Duration) *CachedResolve
 	}
 }
 
+// SetMaxEntries limits how many domains are cached. When the cache is full,
+// expired entries are dropped first and then the oldest. Zero removes the limit.
+func (cr *CachedResolver) SetMaxEntries(maxEntries int) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.maxEntries = maxEntries
+	for cr.maxEntries > 0 && len(cr.cache) > cr.maxEntries {
+		cr.evictLocked(time.Now())
+	}
+}
+
 // ResolveDomain resolves a domain with caching
 func (cr *CachedResolver) ResolveDomain(domain string) (*EMSGServerInfo, error) {
+	return cr.ResolveDomainContext(context.Background(), domain)
+}
+
+// ResolveDomainContext resolves a domain with caching, stopping early if ctx is done
+func (cr *CachedResolver) ResolveDomainContext(ctx context.Context, domain string) (*EMSGServerInfo, error) {
 	// Check cache first
+	cr.mutex.Lock()
 	if entry, exists := cr.cache[domain]; exists {
 		if time.Since(entry.Timestamp) < entry.TTL {
+			cr.mutex.Unlock()
 			return entry.ServerInfo, nil
 		}
 		// Cache expired, remove entry
 		delete(cr.cache, domain)
 	}
-	
+	cr.mutex.Unlock()
+
 	// Resolve from DNS
-	serverInfo, err := cr.resolver.ResolveDomain(domain)
+	serverInfo, err := cr.resolver.ResolveDomainContext(ctx, domain)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// Cache the result
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	now := time.Now()
+	if _, exists := cr.cache[domain]; !exists && cr.maxEntries > 0 && len(cr.cache) >= cr.maxEntries {
+		cr.evictLocked(now)
+	}
 	cr.cache[domain] = &CacheEntry{
 		ServerInfo: serverInfo,
-		Timestamp:  time.Now(),
+		Timestamp:  now,
 		TTL:        cr.defaultTTL,
 	}
-	
+
 	return serverInfo, nil
 }
+
+// CacheSize returns the number of cached domains
+func (cr *CachedResolver) CacheSize() int {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return len(cr.cache)
+}
+
+// evictLocked removes every expired entry and, if the cache is still full, the oldest one
+func (cr *CachedResolver) evictLocked(now time.Time) {
+	for domain, entry := range cr.cache {
+		if now.Sub(entry.Timestamp) >= entry.TTL {
+			delete(cr.cache, domain)
+		}
+	}
+	if len(cr.cache) < cr.maxEntries {
+		return
+	}
+
+	var oldest string
+	for domain, entry := range cr.cache {
+		if oldest == "" || entry.Timestamp.Before(cr.cache[oldest].Timestamp) {
+			oldest = domain
+		}
+	}
+	delete(cr.cache, oldest)
+}
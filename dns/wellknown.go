@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// DiscoveryMethod is a way of finding the EMSG server of a domain
+type DiscoveryMethod string
+
+const (
+	DiscoveryDNS       DiscoveryMethod = "dns"        // The domain's _emsg TXT records
+	DiscoveryWellKnown DiscoveryMethod = "well_known" // https://<domain>/.well-known/emsg.json, for domains that cannot edit DNS
+)
+
+// WellKnownPath is where domains publish their EMSG server information over HTTPS
+const WellKnownPath = "/.well-known/emsg.json"
+
+// maxWellKnownSize bounds the .well-known documents read
+const maxWellKnownSize = 64 * 1024
+
+// discoveryOrder returns the discovery methods to try, in order
+func (c *ResolverConfig) discoveryOrder() []DiscoveryMethod {
+	if len(c.DiscoveryOrder) == 0 {
+		return []DiscoveryMethod{DiscoveryDNS, DiscoveryWellKnown}
+	}
+	return c.DiscoveryOrder
+}
+
+// validateDiscoveryOrder checks that every method is known and listed once
+func validateDiscoveryOrder(order []DiscoveryMethod) error {
+	seen := make(map[DiscoveryMethod]bool, len(order))
+	for _, method := range order {
+		switch method {
+		case DiscoveryDNS, DiscoveryWellKnown:
+		default:
+			return fmt.Errorf("unknown discovery method %q", method)
+		}
+		if seen[method] {
+			return fmt.Errorf("discovery method %q listed twice", method)
+		}
+		seen[method] = true
+	}
+	return nil
+}
+
+// resolveWellKnown discovers a domain's server from the JSON document it
+// publishes at WellKnownPath, in the format of JSON TXT records
+func (r *Resolver) resolveWellKnown(ctx context.Context, domain string) (*EMSGServerInfo, error) {
+	endpoint := (&url.URL{Scheme: "https", Host: domain, Path: WellKnownPath}).String()
+	if r.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .well-known URL %s: %w", endpoint, err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", endpoint, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWellKnownSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", endpoint, err)
+	}
+
+	var serverInfo EMSGServerInfo
+	if err := json.Unmarshal(body, &serverInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", endpoint, err)
+	}
+	if serverInfo.URL == "" {
+		return nil, fmt.Errorf("missing URL in %s", endpoint)
+	}
+	if err := r.validateURL(serverInfo.URL); err != nil {
+		return nil, fmt.Errorf("invalid URL in %s: %w", endpoint, err)
+	}
+	return &serverInfo, nil
+}
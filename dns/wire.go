@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS wire format constants used by DoH queries (RFC 1035)
+const (
+	dnsHeaderSize     = 12
+	dnsTypeTXT        = 16
+	dnsClassIN        = 1
+	dnsFlagResponse   = 0x8000
+	dnsFlagTruncated  = 0x0200
+	dnsFlagRecursion  = 0x0100
+	dnsRcodeMask      = 0x000f
+	dnsRcodeNXDomain  = 3
+	dnsPointerMask    = 0xc0
+	dnsMaxLabelLength = 63
+	dnsMaxNameLength  = 255
+)
+
+// encodeTXTQuery encodes a recursive TXT query for name. The ID is zero, as
+// RFC 8484 recommends for cache-friendly DoH requests.
+func encodeTXTQuery(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > dnsMaxNameLength-2 {
+		return nil, fmt.Errorf("invalid DNS name %q", name)
+	}
+
+	query := make([]byte, dnsHeaderSize, dnsHeaderSize+len(name)+6)
+	binary.BigEndian.PutUint16(query[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(query[4:], 1) // One question
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > dnsMaxLabelLength {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, dnsTypeTXT)
+	query = binary.BigEndian.AppendUint16(query, dnsClassIN)
+	return query, nil
+}
+
+// decodeTXTResponse returns the TXT records in the answer of a response to
+// encodeTXTQuery, joining the strings of each record like net.LookupTXT
+func decodeTXTResponse(name string, response []byte) ([]string, error) {
+	if len(response) < dnsHeaderSize {
+		return nil, fmt.Errorf("malformed DNS response: %d bytes", len(response))
+	}
+	flags := binary.BigEndian.Uint16(response[2:])
+	switch {
+	case flags&dnsFlagResponse == 0:
+		return nil, fmt.Errorf("malformed DNS response: not a response")
+	case flags&dnsFlagTruncated != 0:
+		return nil, fmt.Errorf("DNS response for %s was truncated", name)
+	case flags&dnsRcodeMask == dnsRcodeNXDomain:
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case flags&dnsRcodeMask != 0:
+		return nil, fmt.Errorf("DNS query for %s failed with rcode %d", name, flags&dnsRcodeMask)
+	}
+
+	questions := int(binary.BigEndian.Uint16(response[4:]))
+	answers := int(binary.BigEndian.Uint16(response[6:]))
+	offset := dnsHeaderSize
+	var err error
+	for range questions {
+		if offset, err = skipDNSName(response, offset); err != nil {
+			return nil, err
+		}
+		offset += 4 // Type and class
+	}
+
+	var records []string
+	for range answers {
+		if offset, err = skipDNSName(response, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(response) {
+			return nil, fmt.Errorf("malformed DNS response: truncated answer")
+		}
+		rrType := binary.BigEndian.Uint16(response[offset:])
+		rrClass := binary.BigEndian.Uint16(response[offset+2:])
+		length := int(binary.BigEndian.Uint16(response[offset+8:]))
+		offset += 10
+		if offset+length > len(response) {
+			return nil, fmt.Errorf("malformed DNS response: truncated record data")
+		}
+		data := response[offset : offset+length]
+		offset += length
+
+		// Answers may include the CNAME chain leading to the TXT records
+		if rrType != dnsTypeTXT || rrClass != dnsClassIN {
+			continue
+		}
+		record, err := decodeTXTData(data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// decodeTXTData joins the length-prefixed strings of a TXT record
+func decodeTXTData(data []byte) (string, error) {
+	var record strings.Builder
+	for len(data) > 0 {
+		length := int(data[0])
+		if 1+length > len(data) {
+			return "", fmt.Errorf("malformed DNS response: truncated TXT string")
+		}
+		record.Write(data[1 : 1+length])
+		data = data[1+length:]
+	}
+	return record.String(), nil
+}
+
+// skipDNSName returns the offset after an encoded, possibly compressed, name
+func skipDNSName(response []byte, offset int) (int, error) {
+	for {
+		if offset >= len(response) {
+			return 0, fmt.Errorf("malformed DNS response: truncated name")
+		}
+		length := int(response[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&dnsPointerMask == dnsPointerMask:
+			// A compression pointer ends the name
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
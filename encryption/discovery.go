@@ -0,0 +1,159 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// KeyFetcher retrieves the base64 encryption public key published for an address
+type KeyFetcher func(address string) (string, error)
+
+// KeyDiscovery fetches recipient encryption keys that are missing from the key store
+// and caches them there. Discovered keys are refreshed after their TTL; keys that were
+// registered or pinned by other means are never replaced. Concurrent lookups of the
+// same address share one fetch.
+type KeyDiscovery struct {
+	fetcher     KeyFetcher
+	ttl         time.Duration
+	negativeTTL time.Duration
+	discovered  map[string]time.Time // Expiry of keys stored by discovery
+	failures    map[string]time.Time // Addresses not to retry before the given time
+	flights     utils.FlightGroup[[32]byte]
+	logger      utils.Logger
+	mutex       sync.Mutex
+}
+
+// NewKeyDiscovery creates a key discovery subsystem. Failed lookups are not retried for
+// negativeTTL, so recipients without a published key do not cause a request per send.
+func NewKeyDiscovery(fetcher KeyFetcher, ttl, negativeTTL time.Duration) *KeyDiscovery {
+	return &KeyDiscovery{
+		fetcher:     fetcher,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		discovered:  make(map[string]time.Time),
+		failures:    make(map[string]time.Time),
+		logger:      utils.NopLogger{},
+	}
+}
+
+// SetLogger sets the logger for failed key refreshes (nil discards them)
+func (kd *KeyDiscovery) SetLogger(logger utils.Logger) {
+	kd.mutex.Lock()
+	defer kd.mutex.Unlock()
+	kd.logger = utils.LoggerOrNop(logger)
+}
+
+// Lookup returns the public key for an address from the key store, fetching it first
+// if it is missing or a previously discovered key has expired. An expired key is still
+// used if it cannot be refreshed.
+func (kd *KeyDiscovery) Lookup(keyStore KeyStore, address string) ([32]byte, error) {
+	kd.mutex.Lock()
+	now := time.Now()
+	stored := keyStore.HasPublicKey(address)
+	expiry, discovered := kd.discovered[address]
+	retryAt, failed := kd.failures[address]
+	kd.mutex.Unlock()
+
+	if stored && (!discovered || now.Before(expiry)) {
+		return keyStore.GetPublicKey(address)
+	}
+
+	if failed && now.Before(retryAt) {
+		if stored {
+			return keyStore.GetPublicKey(address)
+		}
+		return [32]byte{}, fmt.Errorf("no public key discovered for %s", address)
+	}
+
+	publicKey, err, _ := kd.flights.Do(address, func() ([32]byte, error) {
+		return kd.refresh(keyStore, address, stored)
+	})
+	return publicKey, err
+}
+
+// refresh fetches a key and stores it, recording the failure if it cannot be fetched
+func (kd *KeyDiscovery) refresh(keyStore KeyStore, address string, stored bool) ([32]byte, error) {
+	publicKey, err := kd.fetch(address)
+	now := time.Now()
+	if err != nil {
+		kd.mutex.Lock()
+		kd.failures[address] = now.Add(kd.negativeTTL)
+		logger := kd.logger
+		kd.mutex.Unlock()
+
+		if stored {
+			logger.Warn("failed to refresh public key, using cached key", "address", address, "error", err)
+			return keyStore.GetPublicKey(address)
+		}
+		return [32]byte{}, fmt.Errorf("failed to discover public key for %s: %w", address, err)
+	}
+
+	if err := keyStore.StorePublicKey(address, publicKey); err != nil {
+		return [32]byte{}, fmt.Errorf("failed to store discovered public key: %w", err)
+	}
+
+	kd.mutex.Lock()
+	delete(kd.failures, address)
+	kd.discovered[address] = now.Add(kd.ttl)
+	kd.mutex.Unlock()
+
+	return publicKey, nil
+}
+
+// IsDiscovered returns true if the stored key for an address was obtained by discovery
+func (kd *KeyDiscovery) IsDiscovered(address string) bool {
+	kd.mutex.Lock()
+	defer kd.mutex.Unlock()
+
+	_, discovered := kd.discovered[address]
+	return discovered
+}
+
+// Forget drops discovery state for an address so the next lookup fetches its key again
+func (kd *KeyDiscovery) Forget(address string) {
+	kd.mutex.Lock()
+	defer kd.mutex.Unlock()
+
+	if _, discovered := kd.discovered[address]; discovered {
+		kd.discovered[address] = time.Time{}
+	}
+	delete(kd.failures, address)
+}
+
+// fetch retrieves and decodes a published key
+func (kd *KeyDiscovery) fetch(address string) ([32]byte, error) {
+	publicKeyBase64, err := kd.fetcher(address)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKeyBase64)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("invalid public key format: %w", err)
+	}
+	if len(publicKeyBytes) != 32 {
+		return [32]byte{}, fmt.Errorf("invalid public key length: expected 32 bytes, got %d", len(publicKeyBytes))
+	}
+
+	var publicKey [32]byte
+	copy(publicKey[:], publicKeyBytes)
+	return publicKey, nil
+}
+
+// SetKeyDiscovery enables automatic discovery of recipient keys missing from the key store.
+// Pass nil to disable it.
+func (em *EncryptionManager) SetKeyDiscovery(discovery *KeyDiscovery) {
+	em.discovery = discovery
+}
+
+// recipientKey returns a recipient's public key, discovering it if needed
+func (em *EncryptionManager) recipientKey(address string) ([32]byte, error) {
+	if em.discovery != nil {
+		return em.discovery.Lookup(em.keyStore, address)
+	}
+	return em.keyStore.GetPublicKey(address)
+}
@@ -3,12 +3,20 @@ package encryption
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/nacl/box"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
+// Scheme names the NaCl box construction used to encrypt message bodies
+const Scheme = "x25519-xsalsa20-poly1305"
+
 // EncryptionKeyPair represents a NaCl encryption key pair
 type EncryptionKeyPair struct {
 	PublicKey  [32]byte
@@ -31,7 +39,8 @@ type KeyStore interface {
 
 // MemoryKeyStore is an in-memory implementation of KeyStore
 type MemoryKeyStore struct {
-	keys map[string][32]byte
+	keys  map[string][32]byte
+	mutex sync.RWMutex
 }
 
 // NewMemoryKeyStore creates a new in-memory key store
@@ -43,12 +52,16 @@ func NewMemoryKeyStore() *MemoryKeyStore {
 
 // StorePublicKey stores a public key for an address
 func (m *MemoryKeyStore) StorePublicKey(address string, publicKey [32]byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.keys[address] = publicKey
 	return nil
 }
 
 // GetPublicKey retrieves a public key for an address
 func (m *MemoryKeyStore) GetPublicKey(address string) ([32]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	key, exists := m.keys[address]
 	if !exists {
 		return [32]byte{}, fmt.Errorf("public key not found for address: %s", address)
@@ -58,13 +71,21 @@ func (m *MemoryKeyStore) GetPublicKey(address string) ([32]byte, error) {
 
 // HasPublicKey checks if a public key exists for an address
 func (m *MemoryKeyStore) HasPublicKey(address string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	_, exists := m.keys[address]
 	return exists
 }
 
 // GenerateEncryptionKeyPair generates a new NaCl encryption key pair
 func GenerateEncryptionKeyPair() (*EncryptionKeyPair, error) {
-	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	return GenerateEncryptionKeyPairFrom(rand.Reader)
+}
+
+// GenerateEncryptionKeyPairFrom generates a NaCl encryption key pair from an
+// entropy source (nil = crypto/rand)
+func GenerateEncryptionKeyPairFrom(entropy io.Reader) (*EncryptionKeyPair, error) {
+	publicKey, privateKey, err := box.GenerateKey(utils.Entropy(entropy))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate encryption key pair: %w", err)
 	}
@@ -87,9 +108,15 @@ func (kp *EncryptionKeyPair) PrivateKeyBase64() string {
 
 // Encrypt encrypts a message for a recipient
 func (kp *EncryptionKeyPair) Encrypt(message []byte, recipientPublicKey [32]byte) (*EncryptedMessage, error) {
+	return kp.EncryptFrom(message, recipientPublicKey, rand.Reader)
+}
+
+// EncryptFrom encrypts a message for a recipient with a nonce read from an
+// entropy source (nil = crypto/rand)
+func (kp *EncryptionKeyPair) EncryptFrom(message []byte, recipientPublicKey [32]byte, entropy io.Reader) (*EncryptedMessage, error) {
 	// Generate a random nonce
 	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+	if _, err := io.ReadFull(utils.Entropy(entropy), nonce[:]); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
@@ -103,6 +130,12 @@ func (kp *EncryptionKeyPair) Encrypt(message []byte, recipientPublicKey [32]byte
 	}, nil
 }
 
+// Wipe zeroes the private key so it no longer lingers in memory. The key pair
+// cannot decrypt afterwards.
+func (kp *EncryptionKeyPair) Wipe() {
+	utils.Wipe(kp.PrivateKey[:])
+}
+
 // Decrypt decrypts a message from a sender
 func (kp *EncryptionKeyPair) Decrypt(encMsg *EncryptedMessage) ([]byte, error) {
 	// Decrypt the message
@@ -116,8 +149,10 @@ func (kp *EncryptionKeyPair) Decrypt(encMsg *EncryptedMessage) ([]byte, error) {
 
 // EncryptionManager manages encryption operations and key storage
 type EncryptionManager struct {
-	keyPair  *EncryptionKeyPair
-	keyStore KeyStore
+	keyPair   *EncryptionKeyPair
+	keyStore  KeyStore
+	discovery *KeyDiscovery // Fetches keys missing from the key store (nil = disabled)
+	entropy   io.Reader     // Source of nonces (nil = crypto/rand)
 }
 
 // NewEncryptionManager creates a new encryption manager
@@ -128,16 +163,29 @@ func NewEncryptionManager(keyPair *EncryptionKeyPair, keyStore KeyStore) *Encryp
 	}
 }
 
+// SetEntropy replaces the source nonces are read from (nil = crypto/rand),
+// e.g. with utils.DeterministicEntropy for reproducible ciphertexts in tests
+func (em *EncryptionManager) SetEntropy(entropy io.Reader) {
+	em.entropy = entropy
+}
+
+// Wipe zeroes the manager's private key
+func (em *EncryptionManager) Wipe() {
+	if em.keyPair != nil {
+		em.keyPair.Wipe()
+	}
+}
+
 // EncryptForRecipient encrypts a message for a specific recipient
 func (em *EncryptionManager) EncryptForRecipient(message []byte, recipientAddress string) (*EncryptedMessage, error) {
 	// Get recipient's public key
-	recipientPublicKey, err := em.keyStore.GetPublicKey(recipientAddress)
+	recipientPublicKey, err := em.recipientKey(recipientAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recipient public key: %w", err)
 	}
 
 	// Encrypt the message
-	return em.keyPair.Encrypt(message, recipientPublicKey)
+	return em.keyPair.EncryptFrom(message, recipientPublicKey, em.entropy)
 }
 
 // DecryptMessage decrypts a message from a sender
@@ -147,6 +195,10 @@ func (em *EncryptionManager) DecryptMessage(encMsg *EncryptedMessage) ([]byte, e
 
 // CanEncryptFor checks if we can encrypt for a recipient
 func (em *EncryptionManager) CanEncryptFor(recipientAddress string) bool {
+	if em.discovery != nil {
+		_, err := em.discovery.Lookup(em.keyStore, recipientAddress)
+		return err == nil
+	}
 	return em.keyStore.HasPublicKey(recipientAddress)
 }
 
@@ -172,6 +224,66 @@ func (em *EncryptionManager) RegisterPublicKey(address string, publicKeyBase64 s
 	return em.keyStore.StorePublicKey(address, publicKey)
 }
 
+// ErrKeyBundleMismatch is returned when a key bundle conflicts with a key already pinned for the address
+var ErrKeyBundleMismatch = errors.New("key bundle does not match pinned public key")
+
+// KeyBundle carries a sender's public keys so a recipient can reply encrypted
+// without a separate key exchange
+type KeyBundle struct {
+	Address       string `json:"address"`
+	EncryptionKey string `json:"encryption_key"` // Base64 NaCl box public key
+	SigningKey    string `json:"signing_key"`    // Base64 Ed25519 public key
+	CreatedAt     int64  `json:"created_at"`
+}
+
+// CreateKeyBundle creates a key bundle advertising our encryption key for an address
+func (em *EncryptionManager) CreateKeyBundle(address, signingKeyBase64 string) *KeyBundle {
+	return &KeyBundle{
+		Address:       address,
+		EncryptionKey: em.keyPair.PublicKeyBase64(),
+		SigningKey:    signingKeyBase64,
+		CreatedAt:     time.Now().Unix(),
+	}
+}
+
+// PinKeyBundle stores the encryption key from a bundle on first use. If a
+// different key is already stored for the address, ErrKeyBundleMismatch is
+// returned and the existing key is kept.
+func (em *EncryptionManager) PinKeyBundle(bundle *KeyBundle) (bool, error) {
+	if bundle == nil || bundle.Address == "" {
+		return false, fmt.Errorf("key bundle has no address")
+	}
+
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(bundle.EncryptionKey)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key format: %w", err)
+	}
+
+	if len(publicKeyBytes) != 32 {
+		return false, fmt.Errorf("invalid public key length: expected 32 bytes, got %d", len(publicKeyBytes))
+	}
+
+	var publicKey [32]byte
+	copy(publicKey[:], publicKeyBytes)
+
+	if em.keyStore.HasPublicKey(bundle.Address) {
+		existing, err := em.keyStore.GetPublicKey(bundle.Address)
+		if err != nil {
+			return false, fmt.Errorf("failed to get pinned public key: %w", err)
+		}
+		if existing != publicKey {
+			return false, fmt.Errorf("%w for %s", ErrKeyBundleMismatch, bundle.Address)
+		}
+		return false, nil // Already pinned
+	}
+
+	if err := em.keyStore.StorePublicKey(bundle.Address, publicKey); err != nil {
+		return false, fmt.Errorf("failed to pin public key: %w", err)
+	}
+
+	return true, nil
+}
+
 // LoadEncryptionKeyPairFromBase64 loads a key pair from base64 strings
 func LoadEncryptionKeyPairFromBase64(publicKeyB64, privateKeyB64 string) (*EncryptionKeyPair, error) {
 	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKeyB64)
@@ -203,7 +315,8 @@ type EncryptionConfig struct {
 	Enabled           bool
 	KeyPair           *EncryptionKeyPair
 	KeyStore          KeyStore
-	FallbackOnFailure bool // If true, send unencrypted if encryption fails
+	FallbackOnFailure bool               // If true, send unencrypted if encryption fails
+	WriteBehind       *WriteBehindConfig // Persist key store writes in the background (nil = synchronous writes)
 }
 
 // DefaultEncryptionConfig returns a default encryption configuration
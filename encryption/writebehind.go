@@ -0,0 +1,308 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// BatchKeyStore is implemented by key stores that can persist several keys in one
+// operation. WriteBehindKeyStore uses it when available.
+type BatchKeyStore interface {
+	KeyStore
+	StorePublicKeys(keys map[string][32]byte) error
+}
+
+// WriteBehindConfig configures background persistence of key store writes
+type WriteBehindConfig struct {
+	FlushInterval     time.Duration // How often pending writes are persisted
+	MaxBatchSize      int           // Flush early once this many writes are pending; also the largest batch written at once
+	InitialRetryDelay time.Duration // Delay before retrying a failed flush; doubles per consecutive failure
+	MaxRetryDelay     time.Duration // Upper bound on the retry delay
+}
+
+// DefaultWriteBehindConfig returns a default write-behind configuration
+func DefaultWriteBehindConfig() *WriteBehindConfig {
+	return &WriteBehindConfig{
+		FlushInterval:     time.Second,
+		MaxBatchSize:      100,
+		InitialRetryDelay: time.Second,
+		MaxRetryDelay:     time.Minute,
+	}
+}
+
+// ErrKeyStoreClosed is returned by Flush after Close
+var ErrKeyStoreClosed = errors.New("key store closed")
+
+// WriteBehindKeyStore wraps a KeyStore so writes return immediately and are
+// persisted in batches by a background goroutine. Pending keys are visible to
+// reads straight away. Failed flushes are retried with exponential backoff, and
+// Close persists everything still pending. The wrapped store must be safe for
+// concurrent use.
+type WriteBehindKeyStore struct {
+	store    KeyStore
+	config   WriteBehindConfig
+	pending  map[string]pendingKey
+	sequence uint64
+	failures int       // Consecutive failed flushes
+	retryAt  time.Time // No background flush before this time after a failure
+	closed   bool
+	logger   utils.Logger
+	mutex    sync.Mutex
+
+	flushMutex sync.Mutex // Serializes flushes so a key is never written twice at once
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+type pendingKey struct {
+	key      [32]byte
+	sequence uint64 // Distinguishes a rewrite during a flush from the flushed value
+}
+
+// NewWriteBehindKeyStore starts background persistence of writes to store. A nil
+// config uses DefaultWriteBehindConfig.
+func NewWriteBehindKeyStore(store KeyStore, config *WriteBehindConfig) *WriteBehindKeyStore {
+	if config == nil {
+		config = DefaultWriteBehindConfig()
+	}
+
+	wb := &WriteBehindKeyStore{
+		store:   store,
+		config:  *config,
+		pending: make(map[string]pendingKey),
+		logger:  utils.NopLogger{},
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go wb.loop()
+	return wb
+}
+
+// SetLogger sets the logger for failed background flushes (nil discards them)
+func (wb *WriteBehindKeyStore) SetLogger(logger utils.Logger) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+	wb.logger = utils.LoggerOrNop(logger)
+}
+
+// StorePublicKey queues a public key to be persisted. After Close, keys are written
+// straight to the wrapped store.
+func (wb *WriteBehindKeyStore) StorePublicKey(address string, publicKey [32]byte) error {
+	wb.mutex.Lock()
+	if wb.closed {
+		wb.mutex.Unlock()
+		return wb.store.StorePublicKey(address, publicKey)
+	}
+
+	wb.sequence++
+	wb.pending[address] = pendingKey{key: publicKey, sequence: wb.sequence}
+	full := wb.config.MaxBatchSize > 0 && len(wb.pending) >= wb.config.MaxBatchSize
+	wb.mutex.Unlock()
+
+	if full {
+		select {
+		case wb.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// GetPublicKey returns a pending key if there is one, otherwise the stored key
+func (wb *WriteBehindKeyStore) GetPublicKey(address string) ([32]byte, error) {
+	wb.mutex.Lock()
+	entry, exists := wb.pending[address]
+	wb.mutex.Unlock()
+
+	if exists {
+		return entry.key, nil
+	}
+	return wb.store.GetPublicKey(address)
+}
+
+// HasPublicKey checks pending and stored keys for an address
+func (wb *WriteBehindKeyStore) HasPublicKey(address string) bool {
+	wb.mutex.Lock()
+	_, exists := wb.pending[address]
+	wb.mutex.Unlock()
+
+	return exists || wb.store.HasPublicKey(address)
+}
+
+// Pending returns the number of writes not yet persisted
+func (wb *WriteBehindKeyStore) Pending() int {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+	return len(wb.pending)
+}
+
+// Flush persists all pending writes now, ignoring any retry backoff
+func (wb *WriteBehindKeyStore) Flush(ctx context.Context) error {
+	wb.mutex.Lock()
+	closed := wb.closed
+	wb.mutex.Unlock()
+	if closed {
+		return ErrKeyStoreClosed
+	}
+	return wb.flush(ctx)
+}
+
+// Close stops the background goroutine and persists every pending write. It
+// returns an error if some writes could not be persisted; they are then lost.
+func (wb *WriteBehindKeyStore) Close() error {
+	wb.mutex.Lock()
+	if wb.closed {
+		wb.mutex.Unlock()
+		return nil
+	}
+	wb.closed = true
+	wb.mutex.Unlock()
+
+	close(wb.stop)
+	<-wb.done
+
+	if err := wb.flush(context.Background()); err != nil {
+		return fmt.Errorf("failed to persist %d pending keys on close: %w", wb.Pending(), err)
+	}
+	return nil
+}
+
+// loop flushes on every tick and whenever a full batch is pending
+func (wb *WriteBehindKeyStore) loop() {
+	defer close(wb.done)
+
+	interval := wb.config.FlushInterval
+	if interval <= 0 {
+		interval = DefaultWriteBehindConfig().FlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wb.stop:
+			return
+		case <-ticker.C:
+		case <-wb.wake:
+		}
+
+		wb.mutex.Lock()
+		backingOff := time.Now().Before(wb.retryAt)
+		wb.mutex.Unlock()
+		if backingOff {
+			continue
+		}
+
+		if err := wb.flush(context.Background()); err != nil {
+			wb.mutex.Lock()
+			logger, failures, retryAt := wb.logger, wb.failures, wb.retryAt
+			wb.mutex.Unlock()
+			logger.Warn("failed to persist key store writes, will retry", "failures", failures, "retry_at", retryAt, "error", err)
+		}
+	}
+}
+
+// flush writes pending keys to the wrapped store in batches, stopping at the first failure
+func (wb *WriteBehindKeyStore) flush(ctx context.Context) error {
+	wb.flushMutex.Lock()
+	defer wb.flushMutex.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch := wb.nextBatch()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := wb.writeBatch(batch); err != nil {
+			wb.recordFailure()
+			return err
+		}
+		wb.recordSuccess(batch)
+	}
+}
+
+// nextBatch returns up to MaxBatchSize pending writes, in address order
+func (wb *WriteBehindKeyStore) nextBatch() map[string]pendingKey {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	addresses := make([]string, 0, len(wb.pending))
+	for address := range wb.pending {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	if limit := wb.config.MaxBatchSize; limit > 0 && len(addresses) > limit {
+		addresses = addresses[:limit]
+	}
+
+	batch := make(map[string]pendingKey, len(addresses))
+	for _, address := range addresses {
+		batch[address] = wb.pending[address]
+	}
+	return batch
+}
+
+// writeBatch persists a batch, in one call if the wrapped store supports it
+func (wb *WriteBehindKeyStore) writeBatch(batch map[string]pendingKey) error {
+	if batchStore, ok := wb.store.(BatchKeyStore); ok {
+		keys := make(map[string][32]byte, len(batch))
+		for address, entry := range batch {
+			keys[address] = entry.key
+		}
+		return batchStore.StorePublicKeys(keys)
+	}
+
+	for address, entry := range batch {
+		if err := wb.store.StorePublicKey(address, entry.key); err != nil {
+			return fmt.Errorf("failed to store public key for %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// recordSuccess drops persisted writes that were not replaced during the flush
+func (wb *WriteBehindKeyStore) recordSuccess(batch map[string]pendingKey) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	for address, entry := range batch {
+		if current, exists := wb.pending[address]; exists && current.sequence == entry.sequence {
+			delete(wb.pending, address)
+		}
+	}
+	wb.failures = 0
+	wb.retryAt = time.Time{}
+}
+
+// recordFailure schedules the next background flush with exponential backoff
+func (wb *WriteBehindKeyStore) recordFailure() {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	maxDelay := wb.config.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultWriteBehindConfig().MaxRetryDelay
+	}
+
+	wb.failures++
+	delay := wb.config.InitialRetryDelay
+	for i := 1; i < wb.failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	wb.retryAt = time.Now().Add(delay)
+}
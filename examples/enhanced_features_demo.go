@@ -48,7 +48,10 @@ func demoSystemMessages(keyPair *keymgmt.KeyPair) {
 	// Create client
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Demo system message types
 	systemTypes := []struct {
@@ -219,7 +222,10 @@ func demoRetryAndHooks(keyPair *keymgmt.KeyPair) {
 		return nil
 	}
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	fmt.Printf("  ⚙️  Retry strategy configured: %d max retries, %v initial delay\n",
 		config.RetryStrategy.MaxRetries, config.RetryStrategy.InitialDelay)
@@ -299,7 +305,10 @@ func demoEnhancedConfig(keyPair *keymgmt.KeyPair) {
 			cfg.config.KeyPair = keyPair
 		}
 
-		emsgClient := client.New(cfg.config)
+		emsgClient, err := client.New(cfg.config)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
 
 		fmt.Printf("      🕐 Timeout: %v\n", cfg.config.Timeout)
 		fmt.Printf("      🏷️  User Agent: %s\n", cfg.config.UserAgent)
@@ -312,7 +321,7 @@ func demoEnhancedConfig(keyPair *keymgmt.KeyPair) {
 		}
 
 		// Test message composition
-		_, err := emsgClient.ComposeMessage().
+		_, err = emsgClient.ComposeMessage().
 			From("test#example.com").
 			To("target#example.com").
 			Subject(fmt.Sprintf("Test from %s", cfg.name)).
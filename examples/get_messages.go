@@ -40,7 +40,10 @@ func main() {
 	}
 
 	// Create client
-	emsgClient := client.NewWithKeyPair(keyPair)
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Get messages
 	fmt.Printf("Retrieving messages for %s...\n", *address)
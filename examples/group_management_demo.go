@@ -36,7 +36,10 @@ func main() {
 	config := client.DefaultConfig()
 	config.KeyPair = aliceKeyPair
 	config.EnableGroupManagement = true
-	aliceClient := client.New(config)
+	aliceClient, err := client.New(config)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Demo addresses
 	aliceAddr := "alice@example.com"
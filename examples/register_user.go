@@ -60,7 +60,10 @@ func main() {
 	}
 
 	// Create client
-	emsgClient := client.NewWithKeyPair(keyPair)
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Register user
 	fmt.Printf("Registering user %s...\n", *address)
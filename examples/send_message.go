@@ -66,7 +66,10 @@ func main() {
 	}
 
 	// Create client
-	emsgClient := client.NewWithKeyPair(keyPair)
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Parse recipients
 	toAddresses := parseAddressList(*to)
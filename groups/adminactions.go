@@ -0,0 +1,260 @@
+package groups
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+)
+
+// maxAuditEntries bounds a group's audit log; the oldest entries are dropped beyond it
+const maxAuditEntries = 1000
+
+// AdminAction is a group operation an admin signs in advance, with the earliest
+// time it may be carried out
+type AdminAction struct {
+	GroupOperation
+	NotBefore int64 `json:"not_before,omitempty"` // Unix time the action becomes due (0 = right away)
+}
+
+// SignedAdminActions is a batch of actions an admin signed for another client or
+// the group's server to carry out later, e.g. scheduled member removals. Each
+// action runs once, with the permissions the admin has when it runs.
+type SignedAdminActions struct {
+	ID        string         `json:"id"`
+	GroupID   string         `json:"grp"`
+	Admin     string         `json:"admin"` // Address whose authority the actions use
+	PublicKey string         `json:"key"`   // Admin's key that signed the batch
+	Actions   []*AdminAction `json:"actions"`
+	IssuedAt  int64          `json:"iat"`
+	ExpiresAt int64          `json:"exp"`
+	Signature string         `json:"sig,omitempty"`
+}
+
+// AuditEntry records a pre-signed action carried out on a group
+type AuditEntry struct {
+	BatchID    string          `json:"batch_id"`
+	Operation  *GroupOperation `json:"operation"`          // Actor is the admin who signed it
+	Executor   string          `json:"executor,omitempty"` // Client or server that carried it out
+	ExecutedAt int64           `json:"executed_at"`
+	Error      string          `json:"error,omitempty"` // Why the action was refused, e.g. the admin lost the permission
+}
+
+// AdminExecution reports a run of ExecuteAdminActions
+type AdminExecution struct {
+	Executed []*AuditEntry // Actions that were due, including refused ones
+	Pending  int           // Actions not due yet
+}
+
+// SignAdminActions signs a batch of actions on a group with the admin's key. The
+// batch can be carried out until ttl has passed. Permissions are not checked
+// here but when each action runs.
+func SignAdminActions(adminKey *keymgmt.KeyPair, adminAddress, groupID string, actions []*AdminAction, ttl time.Duration) (*SignedAdminActions, error) {
+	if adminKey == nil {
+		return nil, fmt.Errorf("admin key is required")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive")
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("no actions to sign")
+	}
+
+	now := time.Now()
+	for i, action := range actions {
+		switch action.Type {
+		case OpAddMember, OpRemoveMember, OpChangeRole, OpBanMember, OpUnbanMember, OpMuteMember, OpUnmuteMember:
+		default:
+			return nil, fmt.Errorf("action %d: operation %s cannot be pre-signed", i, action.Type)
+		}
+		if action.Member == "" {
+			return nil, fmt.Errorf("action %d: member is required", i)
+		}
+		action.GroupID = groupID
+		action.Actor = adminAddress
+		action.Timestamp = now.Unix()
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate batch ID: %w", err)
+	}
+	batch := &SignedAdminActions{
+		ID:        hex.EncodeToString(id),
+		GroupID:   groupID,
+		Admin:     adminAddress,
+		PublicKey: adminKey.PublicKeyBase64(),
+		Actions:   actions,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	payload, err := batch.signingPayload()
+	if err != nil {
+		return nil, err
+	}
+	batch.Signature = base64.StdEncoding.EncodeToString(adminKey.Sign(payload))
+	return batch, nil
+}
+
+// signingPayload returns the batch's JSON without its signature
+func (b *SignedAdminActions) signingPayload() ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = ""
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize admin actions: %w", err)
+	}
+	return payload, nil
+}
+
+// Verify checks that the batch was signed by adminPublicKey, the signing key of
+// Admin, and that every action is made by Admin on the batch's group
+func (b *SignedAdminActions) Verify(adminPublicKey string) error {
+	if b.PublicKey != adminPublicKey {
+		return fmt.Errorf("admin actions not signed by the admin's key")
+	}
+	publicKey, err := keymgmt.LoadPublicKeyFromBase64(b.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to load admin key: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode admin actions signature: %w", err)
+	}
+	payload, err := b.signingPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return fmt.Errorf("admin actions signature verification failed")
+	}
+
+	for i, action := range b.Actions {
+		if action.GroupID != b.GroupID || action.Actor != b.Admin {
+			return fmt.Errorf("action %d is not made by %s on group %s", i, b.Admin, b.GroupID)
+		}
+	}
+	return nil
+}
+
+// ValidAt returns true if the batch was issued at or before t and had not yet expired
+func (b *SignedAdminActions) ValidAt(at time.Time) bool {
+	unix := at.Unix()
+	return unix >= b.IssuedAt && unix <= b.ExpiresAt
+}
+
+// Encode returns the batch as a compact string to hand to the executing client or server
+func (b *SignedAdminActions) Encode() (string, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize admin actions: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseSignedAdminActions decodes a batch produced by Encode. The signature is
+// not checked; use Verify.
+func ParseSignedAdminActions(encoded string) (*SignedAdminActions, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode admin actions: %w", err)
+	}
+	var batch SignedAdminActions
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("failed to parse admin actions: %w", err)
+	}
+	if batch.ID == "" || batch.GroupID == "" || batch.Admin == "" || batch.PublicKey == "" || batch.Signature == "" || len(batch.Actions) == 0 {
+		return nil, fmt.Errorf("admin actions missing required fields")
+	}
+	return &batch, nil
+}
+
+// ExecuteAdminActions carries out the actions of a batch signed by
+// adminPublicKey that are due at now and have not run before. Each runs with
+// the admin's permissions at that moment, so actions the admin is no longer
+// allowed to take are refused. Every action run, refused or not, is added to
+// the audit log; call again later for the actions still pending.
+func (g *Group) ExecuteAdminActions(batch *SignedAdminActions, adminPublicKey, executor string, now time.Time) (*AdminExecution, error) {
+	if err := batch.Verify(adminPublicKey); err != nil {
+		return nil, err
+	}
+	if batch.GroupID != g.ID {
+		return nil, fmt.Errorf("admin actions are for group %s, not %s", batch.GroupID, g.ID)
+	}
+	if !batch.ValidAt(now) {
+		return nil, fmt.Errorf("admin actions not valid at %s", now.UTC().Format(time.RFC3339))
+	}
+
+	// Claim the due actions before running them so concurrent runs never repeat one
+	result := &AdminExecution{}
+	var due []*AdminAction
+	g.mutex.Lock()
+	g.pruneExecutedActionsInternal(now)
+	for i, action := range batch.Actions {
+		key := fmt.Sprintf("%s/%d", batch.ID, i)
+		switch {
+		case g.ExecutedAdminActions[key] != 0:
+		case action.NotBefore > now.Unix():
+			result.Pending++
+		default:
+			if g.ExecutedAdminActions == nil {
+				g.ExecutedAdminActions = make(map[string]int64)
+			}
+			g.ExecutedAdminActions[key] = batch.ExpiresAt
+			due = append(due, action)
+		}
+	}
+	g.mutex.Unlock()
+
+	for _, action := range due {
+		op := action.GroupOperation
+		entry := &AuditEntry{BatchID: batch.ID, Operation: &op, Executor: executor, ExecutedAt: now.Unix()}
+		if err := g.Apply(&op); err != nil {
+			entry.Error = err.Error()
+		}
+		g.appendAuditEntry(entry)
+		result.Executed = append(result.Executed, entry)
+	}
+	return result, nil
+}
+
+// GetAuditLog returns the pre-signed actions carried out on the group, oldest first
+func (g *Group) GetAuditLog() []*AuditEntry {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	log := make([]*AuditEntry, len(g.AuditLog))
+	for i, entry := range g.AuditLog {
+		entryCopy := *entry
+		log[i] = &entryCopy
+	}
+	return log
+}
+
+// appendAuditEntry adds an entry to the audit log, dropping the oldest beyond maxAuditEntries
+func (g *Group) appendAuditEntry(entry *AuditEntry) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.AuditLog = append(g.AuditLog, entry)
+	if excess := len(g.AuditLog) - maxAuditEntries; excess > 0 {
+		g.AuditLog = append([]*AuditEntry(nil), g.AuditLog[excess:]...)
+	}
+}
+
+// pruneExecutedActionsInternal forgets executed actions of expired batches, which
+// cannot run again anyway
+func (g *Group) pruneExecutedActionsInternal(now time.Time) {
+	for key, expiresAt := range g.ExecutedAdminActions {
+		if expiresAt < now.Unix() {
+			delete(g.ExecutedAdminActions, key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package groups
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+)
+
+// AttachmentBlockReason says why group policy blocked an attachment
+type AttachmentBlockReason string
+
+const (
+	AttachmentBlockedPermission AttachmentBlockReason = "permission" // The sender lacks PermissionSendAttachment
+	AttachmentBlockedSize       AttachmentBlockReason = "size"       // The attachment exceeds the group's size limit
+	AttachmentBlockedType       AttachmentBlockReason = "type"       // The group does not accept the attachment's MIME type
+)
+
+// AttachmentBlockedError is returned when group policy does not let a member send an attachment
+type AttachmentBlockedError struct {
+	GroupID    string
+	Member     string
+	Attachment string // Name of the blocked attachment
+	Reason     AttachmentBlockReason
+}
+
+// Error implements the error interface
+func (e *AttachmentBlockedError) Error() string {
+	switch e.Reason {
+	case AttachmentBlockedSize:
+		return fmt.Sprintf("attachment %s exceeds the size limit of group %s", e.Attachment, e.GroupID)
+	case AttachmentBlockedType:
+		return fmt.Sprintf("group %s does not accept the type of attachment %s", e.GroupID, e.Attachment)
+	default:
+		return fmt.Sprintf("%s is not allowed to send attachments to group %s", e.Member, e.GroupID)
+	}
+}
+
+// SetAttachmentLimits sets the largest attachment members may send, in bytes, and
+// the MIME types they may send, e.g. "application/pdf" or "image/*". Zero and
+// an empty list remove the respective limit.
+func (g *Group) SetAttachmentLimits(maxSize int64, allowedTypes []string, requesterAddress string) error {
+	if maxSize < 0 {
+		return fmt.Errorf("attachment size limit cannot be negative")
+	}
+	for _, pattern := range allowedTypes {
+		if major, minor, ok := strings.Cut(pattern, "/"); !ok || major == "" || minor == "" {
+			return fmt.Errorf("invalid attachment type %q", pattern)
+		}
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to change attachment limits")
+	}
+
+	g.Settings.MaxAttachmentSize = maxSize
+	g.Settings.AllowedAttachmentTypes = slices.Clone(allowedTypes)
+	return nil
+}
+
+// AttachmentLimits returns the group's attachment size limit and accepted MIME types
+func (g *Group) AttachmentLimits() (int64, []string) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.Settings.MaxAttachmentSize, slices.Clone(g.Settings.AllowedAttachmentTypes)
+}
+
+// CheckAttachments returns an *AttachmentBlockedError for the first attachment
+// the member may not send to the group: without PermissionSendAttachment none
+// may be sent, and the rest must be within the group's size and type limits
+func (g *Group) CheckAttachments(address string, atts []*attachments.Attachment) error {
+	if len(atts) == 0 {
+		return nil
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if !g.hasPermissionInternal(address, PermissionSendAttachment) {
+		return &AttachmentBlockedError{GroupID: g.ID, Member: address, Attachment: atts[0].Name, Reason: AttachmentBlockedPermission}
+	}
+	for _, att := range atts {
+		if g.Settings.MaxAttachmentSize > 0 && att.Size > g.Settings.MaxAttachmentSize {
+			return &AttachmentBlockedError{GroupID: g.ID, Member: address, Attachment: att.Name, Reason: AttachmentBlockedSize}
+		}
+		if !acceptsAttachmentType(g.Settings.AllowedAttachmentTypes, att.MimeType) {
+			return &AttachmentBlockedError{GroupID: g.ID, Member: address, Attachment: att.Name, Reason: AttachmentBlockedType}
+		}
+	}
+	return nil
+}
+
+// acceptsAttachmentType reports whether a MIME type matches one of the patterns.
+// No patterns accept every type.
+func acceptsAttachmentType(patterns []string, mimeType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	mimeType, _, _ = strings.Cut(strings.ToLower(mimeType), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mimeType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if pattern == mimeType {
+			return true
+		}
+	}
+	return false
+}
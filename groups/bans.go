@@ -0,0 +1,200 @@
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrMemberBanned is returned when a banned address sends to or is added to a group
+var ErrMemberBanned = errors.New("banned from group")
+
+// GroupBan records an address banned from a group. Bans outlive membership so a
+// banned address cannot simply be added back.
+type GroupBan struct {
+	Address  string `json:"address"`
+	BannedBy string `json:"banned_by"`
+	BannedAt int64  `json:"banned_at"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// MutedError is returned when a muted member sends to a group
+type MutedError struct {
+	GroupID string
+	Member  string
+	Until   time.Time // When the mute ends
+}
+
+// Error implements the error interface
+func (e *MutedError) Error() string {
+	return fmt.Sprintf("%s is muted in group %s until %s", e.Member, e.GroupID, e.Until.Format(time.RFC3339))
+}
+
+// BanMember bans an address from the group. A current member keeps their entry
+// with status "banned" and loses all permissions; the ban also stops the address
+// from being added again until UnbanMember. Owners cannot be banned.
+func (g *Group) BanMember(address, requesterAddress, reason string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionRemoveMember) {
+		return fmt.Errorf("insufficient permissions to ban member")
+	}
+	if address == requesterAddress {
+		return fmt.Errorf("cannot ban yourself")
+	}
+	if _, banned := g.Bans[address]; banned {
+		return fmt.Errorf("%s is already banned", address)
+	}
+
+	member, exists := g.Members[address]
+	if exists {
+		if member.Role == RoleOwner {
+			return fmt.Errorf("cannot ban group owner")
+		}
+		if !g.canModifyRole(g.Members[requesterAddress].Role, member.Role) {
+			return fmt.Errorf("insufficient permissions to ban member with role %s", member.Role)
+		}
+		member.Status = "banned"
+	}
+
+	if g.Bans == nil {
+		g.Bans = make(map[string]*GroupBan)
+	}
+	g.Bans[address] = &GroupBan{
+		Address:  address,
+		BannedBy: requesterAddress,
+		BannedAt: time.Now().Unix(),
+		Reason:   reason,
+	}
+	return nil
+}
+
+// UnbanMember lifts a ban. A banned member who is still in the group becomes active again.
+func (g *Group) UnbanMember(address, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionRemoveMember) {
+		return fmt.Errorf("insufficient permissions to unban member")
+	}
+	if _, banned := g.Bans[address]; !banned {
+		return fmt.Errorf("%s is not banned", address)
+	}
+
+	delete(g.Bans, address)
+	if member, exists := g.Members[address]; exists && member.Status == "banned" {
+		member.Status = "active"
+	}
+	return nil
+}
+
+// IsBanned returns true if the address is banned from the group
+func (g *Group) IsBanned(address string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, banned := g.Bans[address]
+	return banned
+}
+
+// GetBans returns the group's bans, oldest first
+func (g *Group) GetBans() []*GroupBan {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	bans := make([]*GroupBan, 0, len(g.Bans))
+	for _, ban := range g.Bans {
+		banCopy := *ban
+		bans = append(bans, &banCopy)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].BannedAt != bans[j].BannedAt {
+			return bans[i].BannedAt < bans[j].BannedAt
+		}
+		return bans[i].Address < bans[j].Address
+	})
+	return bans
+}
+
+// MuteMember stops a member from sending to the group for duration. Moderators
+// and higher roles may mute members they outrank.
+func (g *Group) MuteMember(address, requesterAddress string, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("mute duration must be positive")
+	}
+	return g.MuteMemberUntil(address, requesterAddress, time.Now().Add(duration))
+}
+
+// MuteMemberUntil stops a member from sending to the group until the given time
+func (g *Group) MuteMemberUntil(address, requesterAddress string, until time.Time) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.isModeratorInternal(requesterAddress) {
+		return fmt.Errorf("insufficient permissions to mute member")
+	}
+	member, exists := g.Members[address]
+	if !exists {
+		return fmt.Errorf("member %s not found in group", address)
+	}
+	if !g.canModifyRole(g.Members[requesterAddress].Role, member.Role) {
+		return fmt.Errorf("insufficient permissions to mute member with role %s", member.Role)
+	}
+	if !until.After(time.Now()) {
+		return fmt.Errorf("mute must end in the future")
+	}
+
+	member.MutedUntil = until.Unix()
+	return nil
+}
+
+// UnmuteMember ends a member's mute early
+func (g *Group) UnmuteMember(address, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.isModeratorInternal(requesterAddress) {
+		return fmt.Errorf("insufficient permissions to unmute member")
+	}
+	member, exists := g.Members[address]
+	if !exists {
+		return fmt.Errorf("member %s not found in group", address)
+	}
+	if !g.isMutedInternal(member, time.Now()) {
+		return fmt.Errorf("%s is not muted", address)
+	}
+
+	member.MutedUntil = 0
+	return nil
+}
+
+// IsMuted returns true if the member is muted
+func (g *Group) IsMuted(address string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	member, exists := g.Members[address]
+	return exists && g.isMutedInternal(member, time.Now())
+}
+
+// CheckCanSend returns an error wrapping ErrMemberBanned if the address is banned,
+// or a *MutedError if it is muted
+func (g *Group) CheckCanSend(address string) error {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if _, banned := g.Bans[address]; banned {
+		return fmt.Errorf("%s is %w %s", address, ErrMemberBanned, g.ID)
+	}
+	if member, exists := g.Members[address]; exists && g.isMutedInternal(member, time.Now()) {
+		return &MutedError{GroupID: g.ID, Member: address, Until: time.Unix(member.MutedUntil, 0)}
+	}
+	return nil
+}
+
+// isMutedInternal returns true if the member's mute has not yet ended (internal method without lock)
+func (g *Group) isMutedInternal(member *GroupMember, now time.Time) bool {
+	return member.MutedUntil != 0 && now.Unix() < member.MutedUntil
+}
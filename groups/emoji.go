@@ -0,0 +1,175 @@
+package groups
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+)
+
+// EmojiMetadataKey is the group metadata key holding the group's custom emoji and
+// stickers, so they travel with the group's shared state
+const EmojiMetadataKey = "custom_emoji"
+
+// MaxEmojiAssetSize is the largest image a custom emoji or sticker may use
+const MaxEmojiAssetSize = 512 * 1024
+
+// shortcodePattern matches valid shortcodes, written :shortcode: in message text
+var shortcodePattern = regexp.MustCompile(`^[a-z][a-z0-9_+-]{1,31}$`)
+
+// CustomEmoji is an image a group defines for its members to use by shortcode,
+// either inline in text as :shortcode: or sent on its own as a sticker
+type CustomEmoji struct {
+	Shortcode string                  `json:"shortcode"`
+	Sticker   bool                    `json:"sticker,omitempty"` // Sent as a message of its own rather than inline in text
+	Asset     *attachments.Attachment `json:"asset"`             // The image, inline or referenced by URL
+	AddedBy   string                  `json:"added_by"`
+	AddedAt   int64                   `json:"added_at"`
+}
+
+// ValidateShortcode checks that a shortcode is 2-32 lowercase letters, digits, '_',
+// '+' or '-', starting with a letter so times such as 10:30:00 are not mistaken for one
+func ValidateShortcode(shortcode string) error {
+	if !shortcodePattern.MatchString(shortcode) {
+		return fmt.Errorf("invalid shortcode %q: use 2-32 lowercase letters, digits, '_', '+' or '-', starting with a letter", shortcode)
+	}
+	return nil
+}
+
+// AddCustomEmoji adds an emoji or sticker to the group, replacing any with the same
+// shortcode. The requester needs the manage group permission.
+func (g *Group) AddCustomEmoji(shortcode string, asset *attachments.Attachment, sticker bool, requesterAddress string) (*CustomEmoji, error) {
+	if err := ValidateShortcode(shortcode); err != nil {
+		return nil, err
+	}
+	if err := validateEmojiAsset(asset); err != nil {
+		return nil, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return nil, fmt.Errorf("insufficient permissions to manage custom emoji")
+	}
+
+	emoji := &CustomEmoji{
+		Shortcode: shortcode,
+		Sticker:   sticker,
+		Asset:     asset,
+		AddedBy:   requesterAddress,
+		AddedAt:   time.Now().Unix(),
+	}
+	g.updateCustomEmojiInternal(func(registry map[string]*CustomEmoji) {
+		registry[shortcode] = emoji
+	})
+	return emoji, nil
+}
+
+// RemoveCustomEmoji removes an emoji or sticker from the group. The requester needs
+// the manage group permission.
+func (g *Group) RemoveCustomEmoji(shortcode, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to manage custom emoji")
+	}
+	if _, exists := g.customEmojiInternal()[shortcode]; !exists {
+		return fmt.Errorf("custom emoji %s not found", shortcode)
+	}
+
+	g.updateCustomEmojiInternal(func(registry map[string]*CustomEmoji) {
+		delete(registry, shortcode)
+	})
+	return nil
+}
+
+// GetCustomEmoji returns the group's emoji or sticker with the shortcode
+func (g *Group) GetCustomEmoji(shortcode string) (*CustomEmoji, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	emoji, exists := g.customEmojiInternal()[shortcode]
+	return emoji, exists
+}
+
+// ListCustomEmoji returns the group's emoji and stickers ordered by shortcode
+func (g *Group) ListCustomEmoji() []*CustomEmoji {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	registry := g.customEmojiInternal()
+	emoji := make([]*CustomEmoji, 0, len(registry))
+	for _, e := range registry {
+		emoji = append(emoji, e)
+	}
+	sort.Slice(emoji, func(i, j int) bool { return emoji[i].Shortcode < emoji[j].Shortcode })
+	return emoji
+}
+
+// customEmojiInternal returns the registry held in the group's metadata. Groups
+// decoded from JSON hold it as generic maps, which are converted (internal method
+// without lock).
+func (g *Group) customEmojiInternal() map[string]*CustomEmoji {
+	switch registry := g.Metadata[EmojiMetadataKey].(type) {
+	case nil:
+		return nil
+	case map[string]*CustomEmoji:
+		return registry
+	default:
+		data, err := json.Marshal(registry)
+		if err != nil {
+			return nil
+		}
+		var decoded map[string]*CustomEmoji
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return nil
+		}
+		return decoded
+	}
+}
+
+// updateCustomEmojiInternal applies update to a copy of the registry, so groups
+// sharing metadata through ApplyState never see each other's changes (internal
+// method without lock)
+func (g *Group) updateCustomEmojiInternal(update func(map[string]*CustomEmoji)) {
+	current := g.customEmojiInternal()
+	registry := make(map[string]*CustomEmoji, len(current)+1)
+	for shortcode, emoji := range current {
+		registry[shortcode] = emoji
+	}
+	update(registry)
+
+	if g.Metadata == nil {
+		g.Metadata = make(map[string]any)
+	}
+	if len(registry) == 0 {
+		delete(g.Metadata, EmojiMetadataKey)
+		return
+	}
+	g.Metadata[EmojiMetadataKey] = registry
+}
+
+// validateEmojiAsset checks that an emoji asset is a small image that can be verified
+func validateEmojiAsset(asset *attachments.Attachment) error {
+	if asset == nil {
+		return fmt.Errorf("custom emoji asset is required")
+	}
+	if !asset.IsImage() {
+		return fmt.Errorf("custom emoji asset must be an image, got %s", asset.MimeType)
+	}
+	if asset.Size > MaxEmojiAssetSize {
+		return fmt.Errorf("custom emoji asset is %d bytes, larger than the %d byte limit", asset.Size, MaxEmojiAssetSize)
+	}
+	if len(asset.Data) == 0 && asset.URL == "" {
+		return fmt.Errorf("custom emoji asset must be inline or have a URL")
+	}
+	if asset.Checksum == "" {
+		return fmt.Errorf("custom emoji asset has no checksum")
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package groups
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +28,7 @@ type Permission string
 
 const (
 	PermissionSendMessage    Permission = "send_message"
+	PermissionSendAttachment Permission = "send_attachment"
 	PermissionDeleteMessage  Permission = "delete_message"
 	PermissionAddMember      Permission = "add_member"
 	PermissionRemoveMember   Permission = "remove_member"
@@ -45,6 +48,8 @@ type GroupMember struct {
 	InvitedBy string    `json:"invited_by,omitempty"`
 	Nickname  string    `json:"nickname,omitempty"`
 	Status    string    `json:"status,omitempty"` // active, inactive, banned
+	// Unix time a mute ends; the member cannot send until then (0 = not muted)
+	MutedUntil int64 `json:"muted_until,omitempty"`
 }
 
 // Group represents a messaging group
@@ -57,7 +62,20 @@ type Group struct {
 	Members     map[string]*GroupMember `json:"members"`
 	Settings    *GroupSettings          `json:"settings"`
 	Metadata    map[string]any          `json:"metadata,omitempty"`
-	mutex       sync.RWMutex            `json:"-"`
+	Version     int64                   `json:"version,omitempty"`   // Revision on the group's server (0 = never synced)
+	Bans        map[string]*GroupBan    `json:"bans,omitempty"`      // Banned addresses, including former members
+	AuditLog    []*AuditEntry           `json:"audit_log,omitempty"` // Pre-signed admin actions carried out, oldest first
+	// Pre-signed actions already run, as "<batch ID>/<index>", with their batch's expiry
+	ExecutedAdminActions map[string]int64 `json:"executed_admin_actions,omitempty"`
+	mutex                sync.RWMutex     `json:"-"`
+
+	lastMessageAt   map[string]time.Time            // Last send time per member, for slow mode
+	pendingMessages map[string]*PendingGuestMessage // Guest messages awaiting approval, keyed by message ID
+
+	// Member addresses in sorted order, updated as members are added and removed
+	// and rebuilt when it is unset or no longer matches Members
+	memberIndex []string
+	indexMutex  sync.Mutex // Guards memberIndex while readers share the group's lock
 }
 
 // GroupSettings holds group configuration
@@ -68,6 +86,17 @@ type GroupSettings struct {
 	MaxMembers         int                        `json:"max_members"`
 	MessageRetention   time.Duration              `json:"message_retention"`
 	Permissions        map[GroupRole][]Permission `json:"permissions"`
+	SlowModeInterval   time.Duration              `json:"slow_mode_interval,omitempty"` // Minimum time between messages per member (0 = off)
+	// Hold guest messages for moderator approval instead of rejecting them (requires AllowGuestMessages = false)
+	ModerateGuestMessages bool `json:"moderate_guest_messages,omitempty"`
+	// Let owners promote members to owner and demote or remove other owners, keeping at least one
+	AllowMultipleOwners bool `json:"allow_multiple_owners,omitempty"`
+	// Roles beyond the built-in five, ranked alongside them
+	CustomRoles []*RoleDefinition `json:"custom_roles,omitempty"`
+	// Largest attachment members may send in bytes (0 = no limit)
+	MaxAttachmentSize int64 `json:"max_attachment_size,omitempty"`
+	// MIME types members may send as attachments, e.g. "image/*" (empty = any type)
+	AllowedAttachmentTypes []string `json:"allowed_attachment_types,omitempty"`
 }
 
 // GroupManager manages groups and their operations
@@ -93,22 +122,22 @@ func DefaultGroupSettings() *GroupSettings {
 		MessageRetention:   30 * 24 * time.Hour, // 30 days
 		Permissions: map[GroupRole][]Permission{
 			RoleOwner: {
-				PermissionSendMessage, PermissionDeleteMessage, PermissionAddMember,
+				PermissionSendMessage, PermissionSendAttachment, PermissionDeleteMessage, PermissionAddMember,
 				PermissionRemoveMember, PermissionChangeRole, PermissionManageGroup,
 				PermissionViewMembers, PermissionViewHistory, PermissionCreateSubgroup,
 				PermissionDeleteGroup,
 			},
 			RoleAdmin: {
-				PermissionSendMessage, PermissionDeleteMessage, PermissionAddMember,
+				PermissionSendMessage, PermissionSendAttachment, PermissionDeleteMessage, PermissionAddMember,
 				PermissionRemoveMember, PermissionChangeRole, PermissionManageGroup,
 				PermissionViewMembers, PermissionViewHistory, PermissionCreateSubgroup,
 			},
 			RoleModerator: {
-				PermissionSendMessage, PermissionDeleteMessage, PermissionAddMember,
+				PermissionSendMessage, PermissionSendAttachment, PermissionDeleteMessage, PermissionAddMember,
 				PermissionViewMembers, PermissionViewHistory,
 			},
 			RoleMember: {
-				PermissionSendMessage, PermissionViewMembers, PermissionViewHistory,
+				PermissionSendMessage, PermissionSendAttachment, PermissionViewMembers, PermissionViewHistory,
 			},
 			RoleGuest: {
 				PermissionViewHistory,
@@ -129,6 +158,9 @@ func (gm *GroupManager) CreateGroup(id, name, createdBy string, settings *GroupS
 	if settings == nil {
 		settings = DefaultGroupSettings()
 	}
+	if err := settings.validateRoles(); err != nil {
+		return nil, fmt.Errorf("invalid group settings: %w", err)
+	}
 
 	group := &Group{
 		ID:        id,
@@ -207,6 +239,15 @@ func (g *Group) AddMember(address, invitedBy string, role GroupRole) error {
 		return fmt.Errorf("insufficient permissions to add member")
 	}
 
+	if !g.Settings.HasRole(role) {
+		return fmt.Errorf("unknown role %s", role)
+	}
+
+	// Banned addresses stay out until unbanned
+	if _, banned := g.Bans[address]; banned {
+		return fmt.Errorf("%s is %w %s", address, ErrMemberBanned, g.ID)
+	}
+
 	// Check if member already exists
 	if _, exists := g.Members[address]; exists {
 		return fmt.Errorf("member %s already exists in group", address)
@@ -225,6 +266,7 @@ func (g *Group) AddMember(address, invitedBy string, role GroupRole) error {
 		InvitedBy: invitedBy,
 		Status:    "active",
 	}
+	g.indexAddInternal(address)
 
 	return nil
 }
@@ -245,9 +287,17 @@ func (g *Group) RemoveMember(address, requesterAddress string) error {
 		return fmt.Errorf("member %s not found in group", address)
 	}
 
-	// Cannot remove owner
+	// Owners can only be removed by another owner of a multi-owner group
 	if member.Role == RoleOwner {
-		return fmt.Errorf("cannot remove group owner")
+		if !g.allowsMultipleOwnersInternal() {
+			return fmt.Errorf("cannot remove group owner; transfer ownership first")
+		}
+		if err := g.checkOwnerChangeInternal(requesterAddress, true); err != nil {
+			return err
+		}
+		delete(g.Members, address)
+		g.indexRemoveInternal(address)
+		return nil
 	}
 
 	// Check role hierarchy (can't remove someone with equal or higher role)
@@ -257,6 +307,7 @@ func (g *Group) RemoveMember(address, requesterAddress string) error {
 	}
 
 	delete(g.Members, address)
+	g.indexRemoveInternal(address)
 	return nil
 }
 
@@ -270,23 +321,35 @@ func (g *Group) ChangeRole(address, requesterAddress string, newRole GroupRole)
 		return fmt.Errorf("insufficient permissions to change role")
 	}
 
+	if !g.Settings.HasRole(newRole) {
+		return fmt.Errorf("unknown role %s", newRole)
+	}
+
 	// Check if member exists
 	member, exists := g.Members[address]
 	if !exists {
 		return fmt.Errorf("member %s not found in group", address)
 	}
 
+	// Owner roles only change through TransferOwnership unless the group allows multiple owners
+	if member.Role == RoleOwner || newRole == RoleOwner {
+		if !g.allowsMultipleOwnersInternal() {
+			return fmt.Errorf("cannot change owner role")
+		}
+		demoting := member.Role == RoleOwner && newRole != RoleOwner
+		if err := g.checkOwnerChangeInternal(requesterAddress, demoting); err != nil {
+			return err
+		}
+		member.Role = newRole
+		return nil
+	}
+
 	// Check role hierarchy
 	requesterMember := g.Members[requesterAddress]
 	if !g.canModifyRole(requesterMember.Role, member.Role) || !g.canModifyRole(requesterMember.Role, newRole) {
 		return fmt.Errorf("insufficient permissions to change role")
 	}
 
-	// Cannot change owner role
-	if member.Role == RoleOwner || newRole == RoleOwner {
-		return fmt.Errorf("cannot change owner role")
-	}
-
 	member.Role = newRole
 	return nil
 }
@@ -296,17 +359,17 @@ func (g *Group) HasPermission(address string, permission Permission) bool {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
 
-	member, exists := g.Members[address]
-	if !exists {
-		return false
-	}
+	return g.hasPermissionInternal(address, permission)
+}
 
-	permissions, exists := g.Settings.Permissions[member.Role]
-	if !exists {
+// hasPermissionInternal checks if a member has a specific permission (internal method without lock)
+func (g *Group) hasPermissionInternal(address string, permission Permission) bool {
+	member, exists := g.Members[address]
+	if !exists || !g.permitsInternal(address, member, permission) {
 		return false
 	}
 
-	for _, p := range permissions {
+	for _, p := range g.Settings.RolePermissions(member.Role) {
 		if p == permission {
 			return true
 		}
@@ -315,25 +378,30 @@ func (g *Group) HasPermission(address string, permission Permission) bool {
 	return false
 }
 
-// hasPermissionInternal checks if a member has a specific permission (internal method without lock)
-func (g *Group) hasPermissionInternal(address string, permission Permission) bool {
-	member, exists := g.Members[address]
-	if !exists {
-		return false
+// CanSendMessage checks if an address may post to the group: members need the
+// send permission, while guests may post without it when the group allows guest messages
+func (g *Group) CanSendMessage(address string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if g.hasPermissionInternal(address, PermissionSendMessage) {
+		return true
 	}
+	member, exists := g.Members[address]
+	return exists && member.Role == RoleGuest && g.Settings.AllowGuestMessages &&
+		g.permitsInternal(address, member, PermissionSendMessage)
+}
 
-	permissions, exists := g.Settings.Permissions[member.Role]
-	if !exists {
+// permitsInternal returns false if a ban or mute withholds a permission from the member (internal method without lock)
+func (g *Group) permitsInternal(address string, member *GroupMember, permission Permission) bool {
+	if member.Status == "banned" {
 		return false
 	}
-
-	for _, p := range permissions {
-		if p == permission {
-			return true
-		}
+	if _, banned := g.Bans[address]; banned {
+		return false
 	}
-
-	return false
+	sending := permission == PermissionSendMessage || permission == PermissionSendAttachment
+	return !sending || !g.isMutedInternal(member, time.Now())
 }
 
 // GetMember returns a member by address
@@ -381,20 +449,200 @@ func (g *Group) GetMembersByRole(role GroupRole) []*GroupMember {
 	return members
 }
 
-// canModifyRole checks if a role can modify another role
-func (g *Group) canModifyRole(modifierRole, targetRole GroupRole) bool {
-	roleHierarchy := map[GroupRole]int{
-		RoleOwner:     5,
-		RoleAdmin:     4,
-		RoleModerator: 3,
-		RoleMember:    2,
-		RoleGuest:     1,
+// MemberFilter restricts which members are returned by paginated and iterator access.
+// Zero-valued fields match any member.
+type MemberFilter struct {
+	Role   GroupRole
+	Status string
+}
+
+// matches returns true if the member satisfies the filter
+func (f *MemberFilter) matches(member *GroupMember) bool {
+	if f == nil {
+		return true
+	}
+	if f.Role != "" && member.Role != f.Role {
+		return false
 	}
+	if f.Status != "" && member.Status != f.Status {
+		return false
+	}
+	return true
+}
 
-	modifierLevel := roleHierarchy[modifierRole]
-	targetLevel := roleHierarchy[targetRole]
+// MemberPage represents a single page of group members
+type MemberPage struct {
+	Members []*GroupMember `json:"members"`
+	Offset  int            `json:"offset"`
+	Limit   int            `json:"limit"`
+	Total   int            `json:"total"` // Total members matching the filter
+	HasMore bool           `json:"has_more"`
+}
 
-	return modifierLevel > targetLevel
+// memberIterChunk is how many members MembersIter copies per lock acquisition
+const memberIterChunk = 256
+
+// MembersIter calls fn with a copy of each member matching the filter, ordered by
+// address, until fn returns false. Members are copied out in chunks and fn runs
+// without the group's lock held, so it may change the group, e.g. remove the
+// member it was passed. Members added or removed during iteration may or may
+// not be visited.
+func (g *Group) MembersIter(filter *MemberFilter, fn func(member *GroupMember) bool) {
+	after, started := "", false
+	for {
+		chunk := g.membersAfter(after, started, filter)
+		for _, member := range chunk {
+			if !fn(member) {
+				return
+			}
+		}
+		if len(chunk) < memberIterChunk {
+			return
+		}
+		after, started = chunk[len(chunk)-1].Address, true
+	}
+}
+
+// membersAfter copies up to memberIterChunk members matching the filter whose
+// addresses sort after the given one, or from the first if not started
+func (g *Group) membersAfter(after string, started bool, filter *MemberFilter) []*GroupMember {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	addresses := g.sortedAddressesInternal()
+	start := 0
+	if started {
+		start = sort.Search(len(addresses), func(i int) bool { return addresses[i] > after })
+	}
+
+	chunk := make([]*GroupMember, 0, memberIterChunk)
+	for _, address := range addresses[start:] {
+		member := g.Members[address]
+		if !filter.matches(member) {
+			continue
+		}
+		memberCopy := *member
+		chunk = append(chunk, &memberCopy)
+		if len(chunk) == memberIterChunk {
+			break
+		}
+	}
+	return chunk
+}
+
+// GetMembersPage returns up to limit members matching the filter, starting at offset.
+// Members are ordered by address so pages are stable between calls. Without a
+// filter, a page is read straight from the sorted address index.
+func (g *Group) GetMembersPage(offset, limit int, filter *MemberFilter) (*MemberPage, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("offset cannot be negative")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	page := &MemberPage{
+		Offset: offset,
+		Limit:  limit,
+	}
+
+	addresses := g.sortedAddressesInternal()
+	if filter == nil {
+		page.Total = len(addresses)
+		end := min(offset+limit, len(addresses))
+		page.Members = make([]*GroupMember, 0, max(end-offset, 0))
+		for i := offset; i < end; i++ {
+			memberCopy := *g.Members[addresses[i]]
+			page.Members = append(page.Members, &memberCopy)
+		}
+		page.HasMore = end < page.Total
+		return page, nil
+	}
+
+	page.Members = make([]*GroupMember, 0, min(limit, len(addresses)))
+	for _, address := range addresses {
+		member := g.Members[address]
+		if !filter.matches(member) {
+			continue
+		}
+		if page.Total >= offset && len(page.Members) < limit {
+			memberCopy := *member
+			page.Members = append(page.Members, &memberCopy)
+		}
+		page.Total++
+	}
+
+	page.HasMore = offset+len(page.Members) < page.Total
+	return page, nil
+}
+
+// CountMembers returns the number of members matching the filter
+func (g *Group) CountMembers(filter *MemberFilter) int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if filter == nil {
+		return len(g.Members)
+	}
+
+	count := 0
+	for _, member := range g.Members {
+		if filter.matches(member) {
+			count++
+		}
+	}
+	return count
+}
+
+// sortedAddressesInternal returns member addresses in sorted order from the
+// index, rebuilding it if needed (internal method without lock). The result
+// must not be kept past releasing the group's lock.
+func (g *Group) sortedAddressesInternal() []string {
+	g.indexMutex.Lock()
+	defer g.indexMutex.Unlock()
+
+	if g.memberIndex == nil || len(g.memberIndex) != len(g.Members) {
+		addresses := make([]string, 0, len(g.Members))
+		for address := range g.Members {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		g.memberIndex = addresses
+	}
+	return g.memberIndex
+}
+
+// indexAddInternal inserts a new member's address into the index (internal
+// method, called with the group's write lock held)
+func (g *Group) indexAddInternal(address string) {
+	g.indexMutex.Lock()
+	defer g.indexMutex.Unlock()
+
+	if g.memberIndex == nil {
+		return
+	}
+	if i, found := slices.BinarySearch(g.memberIndex, address); !found {
+		g.memberIndex = slices.Insert(g.memberIndex, i, address)
+	}
+}
+
+// indexRemoveInternal removes a former member's address from the index
+// (internal method, called with the group's write lock held)
+func (g *Group) indexRemoveInternal(address string) {
+	g.indexMutex.Lock()
+	defer g.indexMutex.Unlock()
+
+	if i, found := slices.BinarySearch(g.memberIndex, address); found {
+		g.memberIndex = slices.Delete(g.memberIndex, i, i+1)
+	}
+}
+
+// canModifyRole checks if a role can modify another role, comparing their ranks
+func (g *Group) canModifyRole(modifierRole, targetRole GroupRole) bool {
+	return g.Settings.RoleRank(modifierRole) > g.Settings.RoleRank(targetRole)
 }
 
 // ToJSON serializes a group to JSON
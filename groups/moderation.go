@@ -0,0 +1,141 @@
+package groups
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// PendingGuestMessage is a guest-authored message awaiting moderator approval
+type PendingGuestMessage struct {
+	Message     *message.Message `json:"message"`
+	Author      string           `json:"author"`
+	SubmittedAt time.Time        `json:"submitted_at"`
+}
+
+// SetGuestModeration enables or disables the moderation queue for guest messages.
+// It only takes effect while AllowGuestMessages is false.
+func (g *Group) SetGuestModeration(enabled bool, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to change guest moderation")
+	}
+
+	g.Settings.ModerateGuestMessages = enabled
+	return nil
+}
+
+// RequiresModeration returns true if messages from the address must be approved
+// by a moderator before they reach the group
+func (g *Group) RequiresModeration(address string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.requiresModerationInternal(address)
+}
+
+// SubmitForModeration queues a guest's message for approval
+func (g *Group) SubmitForModeration(msg *message.Message) error {
+	if msg.MessageID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.requiresModerationInternal(msg.From) {
+		return fmt.Errorf("messages from %s do not require moderation", msg.From)
+	}
+
+	if g.pendingMessages == nil {
+		g.pendingMessages = make(map[string]*PendingGuestMessage)
+	}
+	if _, exists := g.pendingMessages[msg.MessageID]; exists {
+		return fmt.Errorf("message %s is already awaiting approval", msg.MessageID)
+	}
+
+	g.pendingMessages[msg.MessageID] = &PendingGuestMessage{
+		Message:     msg,
+		Author:      msg.From,
+		SubmittedAt: time.Now(),
+	}
+	return nil
+}
+
+// PendingMessages returns the messages awaiting approval, oldest first
+func (g *Group) PendingMessages() []*PendingGuestMessage {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	pending := make([]*PendingGuestMessage, 0, len(g.pendingMessages))
+	for _, entry := range g.pendingMessages {
+		entryCopy := *entry
+		pending = append(pending, &entryCopy)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].SubmittedAt.Before(pending[j].SubmittedAt)
+	})
+	return pending
+}
+
+// ApproveMessage removes a message from the moderation queue and returns it for delivery
+func (g *Group) ApproveMessage(messageID, moderatorAddress string) (*PendingGuestMessage, error) {
+	return g.resolvePending(messageID, moderatorAddress)
+}
+
+// RejectMessage discards a message from the moderation queue
+func (g *Group) RejectMessage(messageID, moderatorAddress string) (*PendingGuestMessage, error) {
+	return g.resolvePending(messageID, moderatorAddress)
+}
+
+// Moderators returns the addresses of members who can approve guest messages, in sorted order
+func (g *Group) Moderators() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	var moderators []string
+	for _, address := range g.sortedAddressesInternal() {
+		if g.isModeratorInternal(address) {
+			moderators = append(moderators, address)
+		}
+	}
+	return moderators
+}
+
+// resolvePending removes a queued message on behalf of a moderator
+func (g *Group) resolvePending(messageID, moderatorAddress string) (*PendingGuestMessage, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.isModeratorInternal(moderatorAddress) {
+		return nil, fmt.Errorf("insufficient permissions to moderate messages")
+	}
+
+	entry, exists := g.pendingMessages[messageID]
+	if !exists {
+		return nil, fmt.Errorf("message %s is not awaiting approval", messageID)
+	}
+	delete(g.pendingMessages, messageID)
+
+	return entry, nil
+}
+
+// requiresModerationInternal checks the guest moderation settings (internal method without lock)
+func (g *Group) requiresModerationInternal(address string) bool {
+	if g.Settings.AllowGuestMessages || !g.Settings.ModerateGuestMessages {
+		return false
+	}
+
+	member, exists := g.Members[address]
+	return exists && member.Role == RoleGuest
+}
+
+// isModeratorInternal returns true for active members ranked above ordinary members (internal method without lock)
+func (g *Group) isModeratorInternal(address string) bool {
+	member, exists := g.Members[address]
+	return exists && member.Status != "banned" && g.canModifyRole(member.Role, RoleMember)
+}
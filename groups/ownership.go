@@ -0,0 +1,66 @@
+package groups
+
+import "fmt"
+
+// TransferOwnership makes newOwner an owner of the group and demotes the
+// requesting owner to admin, so the owner can hand the group over before leaving
+func (g *Group) TransferOwnership(newOwner, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	requester, exists := g.Members[requesterAddress]
+	if !exists || requester.Role != RoleOwner {
+		return fmt.Errorf("only an owner can transfer ownership")
+	}
+	if newOwner == requesterAddress {
+		return fmt.Errorf("cannot transfer ownership to yourself")
+	}
+
+	member, exists := g.Members[newOwner]
+	if !exists {
+		return fmt.Errorf("member %s not found in group", newOwner)
+	}
+	if member.Status == "banned" {
+		return fmt.Errorf("cannot transfer ownership to banned member %s", newOwner)
+	}
+	if member.Role == RoleOwner {
+		return fmt.Errorf("member %s is already an owner", newOwner)
+	}
+
+	member.Role = RoleOwner
+	requester.Role = RoleAdmin
+	return nil
+}
+
+// GetOwners returns the group's owners
+func (g *Group) GetOwners() []*GroupMember {
+	return g.GetMembersByRole(RoleOwner)
+}
+
+// allowsMultipleOwnersInternal returns true if owners may be added, demoted and removed
+func (g *Group) allowsMultipleOwnersInternal() bool {
+	return g.Settings != nil && g.Settings.AllowMultipleOwners
+}
+
+// ownerCountInternal returns the number of owners
+func (g *Group) ownerCountInternal() int {
+	count := 0
+	for _, member := range g.Members {
+		if member.Role == RoleOwner {
+			count++
+		}
+	}
+	return count
+}
+
+// checkOwnerChangeInternal checks that requesterAddress may promote, demote or
+// remove an owner. demoting is true when the change takes an owner away.
+func (g *Group) checkOwnerChangeInternal(requesterAddress string, demoting bool) error {
+	if requester := g.Members[requesterAddress]; requester == nil || requester.Role != RoleOwner {
+		return fmt.Errorf("only an owner can change the group's owners")
+	}
+	if demoting && g.ownerCountInternal() <= 1 {
+		return fmt.Errorf("cannot remove the last group owner")
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package groups
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// MembershipProof attests that an address belongs to a group. It is signed by a
+// group owner or the group's home server and attached to group messages so
+// servers and recipients on other domains can check the sender is a member.
+type MembershipProof struct {
+	GroupID       string    `json:"grp"`
+	Member        string    `json:"sub"`            // Normalized member address
+	Role          GroupRole `json:"role,omitempty"` // Member's role when the proof was issued
+	Issuer        string    `json:"iss"`            // Public key that signed the proof
+	IssuerAddress string    `json:"iss_addr"`       // Owner address, or the group ID for its server, the issuer key belongs to
+	IssuedAt      int64     `json:"iat"`
+	ExpiresAt     int64     `json:"exp,omitempty"`
+	Signature     string    `json:"sig,omitempty"`
+}
+
+// IssueMembershipProof signs a proof that member belongs to the group. The issuer
+// must be an owner of the group, or the group's own address when the group is
+// held by a server, signing with the key published for that address. ttl 0
+// issues a proof that does not expire.
+func IssueMembershipProof(issuerKey *keymgmt.KeyPair, issuerAddress string, group *Group, member string, ttl time.Duration) (*MembershipProof, error) {
+	if issuerKey == nil {
+		return nil, fmt.Errorf("issuer key is required")
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("ttl must not be negative")
+	}
+
+	group.mutex.RLock()
+	groupID := group.ID
+	issuer, isMember := group.Members[issuerAddress]
+	target, exists := group.Members[member]
+	var role GroupRole
+	var banned bool
+	if exists {
+		role, banned = target.Role, target.Status == "banned"
+	}
+	group.mutex.RUnlock()
+
+	if !exists || banned {
+		return nil, fmt.Errorf("%s is not a member of group %s", member, groupID)
+	}
+	if !(isMember && issuer.Role == RoleOwner) && !IsGroupServerAddress(groupID, issuerAddress) {
+		return nil, fmt.Errorf("%s cannot issue membership proofs for group %s", issuerAddress, groupID)
+	}
+
+	now := time.Now()
+	proof := &MembershipProof{
+		GroupID:       groupID,
+		Member:        utils.NormalizeEMSGAddress(member),
+		Role:          role,
+		Issuer:        issuerKey.PublicKeyBase64(),
+		IssuerAddress: utils.NormalizeEMSGAddress(issuerAddress),
+		IssuedAt:      now.Unix(),
+	}
+	if ttl > 0 {
+		proof.ExpiresAt = now.Add(ttl).Unix()
+	}
+
+	payload, err := proof.signingPayload()
+	if err != nil {
+		return nil, err
+	}
+	proof.Signature = base64.StdEncoding.EncodeToString(issuerKey.Sign(payload))
+	return proof, nil
+}
+
+// IsGroupServerAddress returns true if address is the group ID of a group held
+// by a server. The server vouches for members with the key it publishes for
+// that address; other accounts on its domain cannot.
+func IsGroupServerAddress(groupID, address string) bool {
+	if _, err := GroupDomain(groupID); err != nil {
+		return false
+	}
+	return utils.NormalizeEMSGAddress(address) == utils.NormalizeEMSGAddress(groupID)
+}
+
+// signingPayload returns the proof's JSON without its signature
+func (p *MembershipProof) signingPayload() ([]byte, error) {
+	unsigned := *p
+	unsigned.Signature = ""
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize membership proof: %w", err)
+	}
+	return payload, nil
+}
+
+// Verify checks that the proof was signed by issuerPublicKey, the signing key of
+// IssuerAddress. Expiry is checked separately with IsExpired or ValidAt.
+func (p *MembershipProof) Verify(issuerPublicKey string) error {
+	if p.Issuer != issuerPublicKey {
+		return fmt.Errorf("membership proof not issued by the expected key")
+	}
+	publicKey, err := keymgmt.LoadPublicKeyFromBase64(p.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to load issuer key: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode membership proof signature: %w", err)
+	}
+	payload, err := p.signingPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return fmt.Errorf("membership proof signature verification failed")
+	}
+	return nil
+}
+
+// ValidAt returns true if the proof was issued at or before t and had not yet expired
+func (p *MembershipProof) ValidAt(at time.Time) bool {
+	unix := at.Unix()
+	return unix >= p.IssuedAt && (p.ExpiresAt == 0 || unix <= p.ExpiresAt)
+}
+
+// IsExpired returns true if the proof has an expiry and it has passed
+func (p *MembershipProof) IsExpired() bool {
+	return p.ExpiresAt != 0 && time.Now().Unix() > p.ExpiresAt
+}
+
+// Covers returns true if the proof is for member of groupID
+func (p *MembershipProof) Covers(groupID, member string) bool {
+	return p.GroupID == groupID && p.Member == utils.NormalizeEMSGAddress(member)
+}
+
+// Encode returns the proof as a compact string for message fields and headers
+func (p *MembershipProof) Encode() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize membership proof: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseMembershipProof decodes a proof produced by Encode. The signature is not
+// checked; use Verify.
+func ParseMembershipProof(encoded string) (*MembershipProof, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode membership proof: %w", err)
+	}
+	var proof MembershipProof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return nil, fmt.Errorf("failed to parse membership proof: %w", err)
+	}
+	if proof.GroupID == "" || proof.Member == "" || proof.Issuer == "" || proof.IssuerAddress == "" || proof.Signature == "" {
+		return nil, fmt.Errorf("membership proof missing required fields")
+	}
+	return &proof, nil
+}
+
+// VerifyMessageMembership checks a group message delivered now. See
+// VerifyMessageMembershipAt.
+func VerifyMessageMembership(msg *message.Message, issuerPublicKey string) (*MembershipProof, error) {
+	return VerifyMessageMembershipAt(msg, issuerPublicKey, time.Now())
+}
+
+// VerifyMessageMembershipAt checks that a group message received at receivedAt
+// carries a proof, signed by issuerPublicKey, that its sender was a member of
+// the message's group when it was sent. The send time is set by the sender, so
+// it must be within auth.DefaultMaxClockSkew of receivedAt; a member whose
+// proof expired cannot backdate messages into its validity. The message's own
+// signature is checked separately.
+func VerifyMessageMembershipAt(msg *message.Message, issuerPublicKey string, receivedAt time.Time) (*MembershipProof, error) {
+	proof, err := MessageMembershipProof(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := proof.Verify(issuerPublicKey); err != nil {
+		return nil, err
+	}
+	if !proof.Covers(msg.GroupID, msg.From) {
+		return nil, fmt.Errorf("membership proof is for %s in %s, not %s in %s", proof.Member, proof.GroupID, msg.From, msg.GroupID)
+	}
+	sentAt := msg.SentAt()
+	if skew := receivedAt.Sub(sentAt); skew > auth.DefaultMaxClockSkew || skew < -auth.DefaultMaxClockSkew {
+		return nil, fmt.Errorf("message sent at %s, too far from its receipt at %s to judge its membership proof", sentAt.Format(time.RFC3339), receivedAt.Format(time.RFC3339))
+	}
+	if !proof.ValidAt(sentAt) {
+		return nil, fmt.Errorf("membership proof not valid when the message was sent")
+	}
+	return proof, nil
+}
+
+// MessageMembershipProof parses the membership proof attached to a group message
+func MessageMembershipProof(msg *message.Message) (*MembershipProof, error) {
+	if msg.GroupID == "" {
+		return nil, fmt.Errorf("message is not a group message")
+	}
+	if msg.MembershipProof == "" {
+		return nil, fmt.Errorf("message carries no membership proof")
+	}
+	return ParseMembershipProof(msg.MembershipProof)
+}
@@ -0,0 +1,179 @@
+package groups
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Ranks of the built-in roles. A role can only modify members whose role ranks
+// below its own; custom roles are ranked on the same scale.
+const (
+	RankOwner     = 100
+	RankAdmin     = 80
+	RankModerator = 60
+	RankMember    = 40
+	RankGuest     = 20
+)
+
+// builtinRoleRanks maps the built-in roles to their ranks
+var builtinRoleRanks = map[GroupRole]int{
+	RoleOwner:     RankOwner,
+	RoleAdmin:     RankAdmin,
+	RoleModerator: RankModerator,
+	RoleMember:    RankMember,
+	RoleGuest:     RankGuest,
+}
+
+// RoleDefinition describes a custom group role
+type RoleDefinition struct {
+	Name        GroupRole    `json:"name"`
+	Rank        int          `json:"rank"`        // Between 1 and RankOwner-1; equal ranks cannot modify each other
+	Permissions []Permission `json:"permissions"` // Used unless GroupSettings.Permissions has an entry for the role
+}
+
+// validate checks a definition on its own
+func (d *RoleDefinition) validate() error {
+	if strings.TrimSpace(string(d.Name)) == "" {
+		return fmt.Errorf("role name cannot be empty")
+	}
+	if _, builtin := builtinRoleRanks[d.Name]; builtin {
+		return fmt.Errorf("role %s is built in", d.Name)
+	}
+	if d.Rank <= 0 || d.Rank >= RankOwner {
+		return fmt.Errorf("role %s rank must be between 1 and %d", d.Name, RankOwner-1)
+	}
+	return nil
+}
+
+// DefineRole adds or replaces a custom role, e.g. before the settings are passed to CreateGroup
+func (s *GroupSettings) DefineRole(def *RoleDefinition) error {
+	if err := def.validate(); err != nil {
+		return err
+	}
+	copied := *def
+	copied.Permissions = slices.Clone(def.Permissions)
+
+	for i, existing := range s.CustomRoles {
+		if existing.Name == def.Name {
+			s.CustomRoles[i] = &copied
+			return nil
+		}
+	}
+	s.CustomRoles = append(s.CustomRoles, &copied)
+	return nil
+}
+
+// Role returns the definition of a custom role, or nil
+func (s *GroupSettings) Role(name GroupRole) *RoleDefinition {
+	for _, def := range s.CustomRoles {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// HasRole returns true for built-in roles and the custom roles defined in the settings
+func (s *GroupSettings) HasRole(name GroupRole) bool {
+	if _, builtin := builtinRoleRanks[name]; builtin {
+		return true
+	}
+	return s.Role(name) != nil
+}
+
+// RoleRank returns a role's rank, or 0 for an unknown role
+func (s *GroupSettings) RoleRank(name GroupRole) int {
+	if rank, builtin := builtinRoleRanks[name]; builtin {
+		return rank
+	}
+	if def := s.Role(name); def != nil {
+		return def.Rank
+	}
+	return 0
+}
+
+// RolePermissions returns the permissions granted to a role
+func (s *GroupSettings) RolePermissions(name GroupRole) []Permission {
+	if permissions, exists := s.Permissions[name]; exists {
+		return permissions
+	}
+	if def := s.Role(name); def != nil {
+		return def.Permissions
+	}
+	return nil
+}
+
+// validateRoles checks the custom role definitions
+func (s *GroupSettings) validateRoles() error {
+	seen := make(map[GroupRole]bool, len(s.CustomRoles))
+	for _, def := range s.CustomRoles {
+		if err := def.validate(); err != nil {
+			return err
+		}
+		if seen[def.Name] {
+			return fmt.Errorf("role %s is defined more than once", def.Name)
+		}
+		seen[def.Name] = true
+	}
+	return nil
+}
+
+// DefineRole adds or replaces a custom role in the group. The requester needs
+// PermissionManageGroup and may only define roles ranked below their own.
+func (g *Group) DefineRole(def *RoleDefinition, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to define roles")
+	}
+	if rank := g.Settings.RoleRank(g.Members[requesterAddress].Role); def.Rank >= rank {
+		return fmt.Errorf("cannot define a role ranked at or above your own")
+	}
+	if existing := g.Settings.Role(def.Name); existing != nil && !g.canModifyRole(g.Members[requesterAddress].Role, def.Name) {
+		return fmt.Errorf("insufficient permissions to change role %s", def.Name)
+	}
+
+	return g.Settings.DefineRole(def)
+}
+
+// RemoveRole deletes a custom role that no member holds
+func (g *Group) RemoveRole(name GroupRole, requesterAddress string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to remove roles")
+	}
+	if g.Settings.Role(name) == nil {
+		return fmt.Errorf("role %s is not a custom role", name)
+	}
+	if !g.canModifyRole(g.Members[requesterAddress].Role, name) {
+		return fmt.Errorf("insufficient permissions to remove role %s", name)
+	}
+	for address, member := range g.Members {
+		if member.Role == name {
+			return fmt.Errorf("role %s is still held by %s", name, address)
+		}
+	}
+
+	g.Settings.CustomRoles = slices.DeleteFunc(g.Settings.CustomRoles, func(def *RoleDefinition) bool {
+		return def.Name == name
+	})
+	return nil
+}
+
+// GetRoles returns the custom roles defined in the group
+func (g *Group) GetRoles() []*RoleDefinition {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	roles := make([]*RoleDefinition, 0, len(g.Settings.CustomRoles))
+	for _, def := range g.Settings.CustomRoles {
+		copied := *def
+		copied.Permissions = slices.Clone(def.Permissions)
+		roles = append(roles, &copied)
+	}
+	return roles
+}
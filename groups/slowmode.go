@@ -0,0 +1,92 @@
+package groups
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowModeError is returned when a member sends to a slow-mode group before their interval has elapsed
+type SlowModeError struct {
+	GroupID    string
+	Member     string
+	Interval   time.Duration // The group's slow-mode interval
+	RetryAfter time.Duration // How long the member must wait before sending again
+}
+
+// Error implements the error interface
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("group %s is in slow mode: %s can send again in %v", e.GroupID, e.Member, e.RetryAfter.Round(time.Second))
+}
+
+// SetSlowMode sets the minimum interval between messages from each member. Zero disables slow mode.
+func (g *Group) SetSlowMode(interval time.Duration, requesterAddress string) error {
+	if interval < 0 {
+		return fmt.Errorf("slow mode interval cannot be negative")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.hasPermissionInternal(requesterAddress, PermissionManageGroup) {
+		return fmt.Errorf("insufficient permissions to change slow mode")
+	}
+
+	g.Settings.SlowModeInterval = interval
+	return nil
+}
+
+// SlowModeInterval returns the group's slow-mode interval, or zero if slow mode is off
+func (g *Group) SlowModeInterval() time.Duration {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.Settings.SlowModeInterval
+}
+
+// CheckSlowMode returns a *SlowModeError if the member must wait before sending.
+// Moderators and higher roles are exempt.
+func (g *Group) CheckSlowMode(address string) error {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.checkSlowModeInternal(address, time.Now())
+}
+
+// RecordMessageSent starts the member's slow-mode interval
+func (g *Group) RecordMessageSent(address string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.lastMessageAt == nil {
+		g.lastMessageAt = make(map[string]time.Time)
+	}
+	g.lastMessageAt[address] = time.Now()
+}
+
+// checkSlowModeInternal checks the member's interval (internal method without lock)
+func (g *Group) checkSlowModeInternal(address string, now time.Time) error {
+	interval := g.Settings.SlowModeInterval
+	if interval <= 0 {
+		return nil
+	}
+
+	// Roles that outrank ordinary members moderate the group and are not throttled
+	if member, exists := g.Members[address]; exists && g.canModifyRole(member.Role, RoleMember) {
+		return nil
+	}
+
+	last, sent := g.lastMessageAt[address]
+	if !sent {
+		return nil
+	}
+	if wait := last.Add(interval).Sub(now); wait > 0 {
+		return &SlowModeError{
+			GroupID:    g.ID,
+			Member:     address,
+			Interval:   interval,
+			RetryAfter: wait,
+		}
+	}
+
+	return nil
+}
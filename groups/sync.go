@@ -0,0 +1,172 @@
+package groups
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// GroupOpType identifies a change to a group's shared state
+type GroupOpType string
+
+const (
+	OpAddMember    GroupOpType = "add_member"
+	OpRemoveMember GroupOpType = "remove_member"
+	OpChangeRole   GroupOpType = "change_role"
+	// OpTransferOwnership makes Member an owner and demotes Actor to admin
+	OpTransferOwnership GroupOpType = "transfer_ownership"
+	OpBanMember         GroupOpType = "ban_member"
+	OpUnbanMember       GroupOpType = "unban_member"
+	OpMuteMember        GroupOpType = "mute_member"
+	OpUnmuteMember      GroupOpType = "unmute_member"
+)
+
+// GroupOperation is a change made to a group, kept so it can be replayed on the
+// server's copy of the group after a conflicting change
+type GroupOperation struct {
+	Type      GroupOpType `json:"type"`
+	GroupID   string      `json:"group_id"`
+	Actor     string      `json:"actor"`            // Who made the change
+	Member    string      `json:"member,omitempty"` // Member added, removed or changed
+	Role      GroupRole   `json:"role,omitempty"`   // Role given to the member
+	Reason    string      `json:"reason,omitempty"` // Why the member was banned
+	Until     int64       `json:"until,omitempty"`  // Unix time a mute ends
+	Timestamp int64       `json:"timestamp"`
+}
+
+// NewGroupOperation creates an operation made by actor now
+func NewGroupOperation(opType GroupOpType, groupID, actor string) *GroupOperation {
+	return &GroupOperation{
+		Type:      opType,
+		GroupID:   groupID,
+		Actor:     actor,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// Apply replays a member operation on the group, subject to the same permission
+// checks as the original change
+func (g *Group) Apply(op *GroupOperation) error {
+	switch op.Type {
+	case OpAddMember:
+		return g.AddMember(op.Member, op.Actor, op.Role)
+	case OpRemoveMember:
+		return g.RemoveMember(op.Member, op.Actor)
+	case OpChangeRole:
+		return g.ChangeRole(op.Member, op.Actor, op.Role)
+	case OpTransferOwnership:
+		return g.TransferOwnership(op.Member, op.Actor)
+	case OpBanMember:
+		return g.BanMember(op.Member, op.Actor, op.Reason)
+	case OpUnbanMember:
+		return g.UnbanMember(op.Member, op.Actor)
+	case OpMuteMember:
+		return g.MuteMemberUntil(op.Member, op.Actor, time.Unix(op.Until, 0))
+	case OpUnmuteMember:
+		return g.UnmuteMember(op.Member, op.Actor)
+	default:
+		return fmt.Errorf("operation %s cannot be applied to a group", op.Type)
+	}
+}
+
+// GetVersion returns the group's revision on its server, or 0 if it was never synced
+func (g *Group) GetVersion() int64 {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.Version
+}
+
+// ApplyState replaces the group's shared state (name, members, bans, settings
+// and version) with remote's, keeping local-only state such as slow mode timers and
+// messages awaiting moderation
+func (g *Group) ApplyState(remote *Group) {
+	remote.mutex.RLock()
+	name, description := remote.Name, remote.Description
+	createdAt, createdBy := remote.CreatedAt, remote.CreatedBy
+	settings, version := remote.Settings, remote.Version
+	members := make(map[string]*GroupMember, len(remote.Members))
+	for address, member := range remote.Members {
+		copied := *member
+		members[address] = &copied
+	}
+	metadata := make(map[string]any, len(remote.Metadata))
+	for k, v := range remote.Metadata {
+		metadata[k] = v
+	}
+	bans := make(map[string]*GroupBan, len(remote.Bans))
+	for address, ban := range remote.Bans {
+		copied := *ban
+		bans[address] = &copied
+	}
+	// Servers that carry out pre-signed admin actions keep the audit log; others
+	// leave it out, so the local one stays
+	auditLog := remote.AuditLog
+	executed := remote.ExecutedAdminActions
+	remote.mutex.RUnlock()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.Name = name
+	g.Description = description
+	g.CreatedAt = createdAt
+	g.CreatedBy = createdBy
+	g.Members = members
+	g.memberIndex = nil
+	g.Metadata = metadata
+	g.Bans = bans
+	if settings != nil {
+		g.Settings = settings
+	}
+	g.Version = version
+	if auditLog != nil {
+		g.AuditLog = append([]*AuditEntry(nil), auditLog...)
+	}
+	if executed != nil {
+		g.ExecutedAdminActions = make(map[string]int64, len(executed))
+		for key, expiresAt := range executed {
+			g.ExecutedAdminActions[key] = expiresAt
+		}
+	}
+}
+
+// ImportGroup adds a group received from its server, or applies its state to the
+// local copy if the group is already known. The local copy is returned.
+func (gm *GroupManager) ImportGroup(remote *Group) (*Group, error) {
+	if remote.ID == "" {
+		return nil, fmt.Errorf("group ID cannot be empty")
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if group, exists := gm.groups[remote.ID]; exists {
+		group.ApplyState(remote)
+		return group, nil
+	}
+
+	group := &Group{ID: remote.ID, Settings: DefaultGroupSettings()}
+	group.ApplyState(remote)
+	gm.groups[remote.ID] = group
+	return group, nil
+}
+
+// RemoveGroup drops a group from the manager without a permission check, e.g.
+// after its server reports it deleted
+func (gm *GroupManager) RemoveGroup(id string) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	delete(gm.groups, id)
+}
+
+// GroupDomain returns the domain of the server that holds a group's shared state.
+// Synced groups must have IDs of the form name#domain.
+func GroupDomain(groupID string) (string, error) {
+	addr, err := utils.ParseEMSGAddress(groupID)
+	if err != nil {
+		return "", fmt.Errorf("group ID %s has no server domain: %w", groupID, err)
+	}
+	return strings.ToLower(addr.Domain), nil
+}
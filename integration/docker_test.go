@@ -31,7 +31,10 @@ func TestWithDockerEMSGDaemon(t *testing.T) {
 	config.KeyPair = keyPair
 	config.Timeout = 30 * time.Second
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test user registration
 	testAddress := "testuser#localhost"
@@ -85,7 +88,10 @@ func TestWithRealEMSGServer(t *testing.T) {
 	config.KeyPair = keyPair
 	config.Timeout = 60 * time.Second
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test DNS resolution first
 	t.Logf("Testing DNS resolution for domain: %s", testDomain)
@@ -186,7 +192,10 @@ func TestRetryWithRealServer(t *testing.T) {
 		return nil
 	}
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test with a domain that might have rate limiting
 	testDomain := "sandipwalke.com"
@@ -233,7 +242,10 @@ func TestConcurrentRequests(t *testing.T) {
 	config.KeyPair = keyPair
 	config.Timeout = 30 * time.Second
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test concurrent message sending
 	const numMessages = 5
@@ -301,7 +313,10 @@ func TestPerformance(t *testing.T) {
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test message creation performance
 	const numMessages = 1000
@@ -340,3 +355,32 @@ func TestPerformance(t *testing.T) {
 		t.Logf("Warning: Performance is below 100 messages/second (%.2f)", messagesPerSecond)
 	}
 }
+
+// TestConformance runs the conformance suite against a live server, by default
+// sandipwalke.com or the domain in CONFORMANCE_DOMAIN
+// This test is skipped unless INTEGRATION_TEST=conformance environment variable is set
+func TestConformance(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") != "conformance" {
+		t.Skip("Skipping conformance test. Set INTEGRATION_TEST=conformance to run.")
+	}
+
+	domain := os.Getenv("CONFORMANCE_DOMAIN")
+	if domain == "" {
+		domain = "sandipwalke.com"
+	}
+
+	report, err := client.RunConformance(context.Background(), domain, nil)
+	if err != nil {
+		t.Fatalf("Failed to run conformance suite: %v", err)
+	}
+
+	for _, result := range report.Results {
+		t.Logf("%-12s %-4s %8v %s%s", result.Scenario, result.Status, result.Duration.Round(time.Millisecond), result.Detail, result.Error)
+		if result.Status == client.ConformanceFail {
+			t.Errorf("Scenario %s failed: %s", result.Scenario, result.Error)
+		}
+	}
+
+	passed, failed, skipped := report.Counts()
+	t.Logf("Conformance of %s (%s): %d passed, %d failed, %d skipped", domain, report.ServerURL, passed, failed, skipped)
+}
@@ -3,7 +3,6 @@ package integration
 import (
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 	"time"
 
@@ -101,27 +100,18 @@ func TestUserRegistration(t *testing.T) {
 		t.Fatalf("Failed to generate key pair: %v", err)
 	}
 
-	// Create client with mock resolver
+	// Create client with mock resolver pointing to our test server
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
-	config.DNSConfig = &dns.ResolverConfig{
-		Timeout: 5 * time.Second,
-		Retries: 1,
-	}
-
-	_ = client.New(config)
-
-	// Mock the DNS resolution to point to our test server
-	_ = &mockDNSResolver{serverURL: server.URL}
-	// Note: In a real implementation, we'd need to inject the resolver
+	config.Resolver = &mockDNSResolver{serverURL: server.URL}
 
-	// For this test, we'll test the components separately
-	testAddress := "testuser#example.com"
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
-	// Test that we can create a registration request
-	// (We can't easily test the full flow without exposing the resolver)
-	if !strings.Contains(testAddress, "#") {
-		t.Errorf("Invalid test address format: %s", testAddress)
+	if err := emsgClient.RegisterUser("testuser#example.com"); err != nil {
+		t.Errorf("Failed to register user: %v", err)
 	}
 }
 
@@ -136,7 +126,10 @@ func TestMessageSending(t *testing.T) {
 	// Create client
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test message creation and validation
 	msg, err := emsgClient.ComposeMessage().
@@ -182,7 +175,10 @@ func TestSystemMessages(t *testing.T) {
 	// Create client
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test system message creation
 	systemMsg, err := emsgClient.ComposeSystemMessage().
@@ -256,7 +252,9 @@ func TestRetryLogic(t *testing.T) {
 		RetryOnTimeout: true,
 	}
 
-	_ = client.New(config)
+	if _, err := client.New(config); err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test that retry strategy is configured
 	if config.RetryStrategy.MaxRetries != 2 {
@@ -294,7 +292,10 @@ func TestHooks(t *testing.T) {
 		return nil
 	}
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Create a test message
 	msg, err := emsgClient.ComposeMessage().
@@ -6,9 +6,12 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 // KeyPair represents an Ed25519 key pair
@@ -19,7 +22,13 @@ type KeyPair struct {
 
 // GenerateKeyPair creates a new Ed25519 key pair
 func GenerateKeyPair() (*KeyPair, error) {
-	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	return GenerateKeyPairFrom(rand.Reader)
+}
+
+// GenerateKeyPairFrom creates an Ed25519 key pair from an entropy source
+// (nil = crypto/rand), e.g. utils.DeterministicEntropy for reproducible fixtures
+func GenerateKeyPairFrom(entropy io.Reader) (*KeyPair, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(utils.Entropy(entropy))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate key pair: %w", err)
 	}
@@ -35,6 +44,12 @@ func (kp *KeyPair) Sign(message []byte) []byte {
 	return ed25519.Sign(kp.PrivateKey, message)
 }
 
+// Wipe zeroes the private key so it no longer lingers in memory. The key pair
+// cannot sign afterwards.
+func (kp *KeyPair) Wipe() {
+	utils.Wipe(kp.PrivateKey)
+}
+
 // Verify verifies a signature with the public key
 func (kp *KeyPair) Verify(message, signature []byte) bool {
 	return ed25519.Verify(kp.PublicKey, message, signature)
@@ -0,0 +1,181 @@
+package keymgmt
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxPreviousKeys is how many retired key pairs a KeyRing keeps for verification
+const DefaultMaxPreviousKeys = 3
+
+// KeyID returns a short identifier for an Ed25519 public key: the first 8 bytes of
+// its SHA-256 hash, base64url encoded without padding
+func KeyID(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	return base64.RawURLEncoding.EncodeToString(hash[:8])
+}
+
+// KeyID returns the identifier of the key pair's public key
+func (kp *KeyPair) KeyID() string {
+	return KeyID(kp.PublicKey)
+}
+
+// KeyRing holds the current signing key pair and the previous ones it replaced.
+// New signatures always use the current key; signatures made by any key still
+// in the ring verify.
+type KeyRing struct {
+	current     *KeyPair
+	previous    []*KeyPair // Newest first
+	maxPrevious int
+	mutex       sync.RWMutex
+}
+
+// NewKeyRing creates a key ring whose current key is current
+func NewKeyRing(current *KeyPair) *KeyRing {
+	return &KeyRing{
+		current:     current,
+		maxPrevious: DefaultMaxPreviousKeys,
+	}
+}
+
+// SetMaxPrevious sets how many previous key pairs are kept; older ones are dropped
+func (kr *KeyRing) SetMaxPrevious(max int) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	kr.maxPrevious = max
+	kr.trimLocked()
+}
+
+// Current returns the key pair used for new signatures
+func (kr *KeyRing) Current() *KeyPair {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	return kr.current
+}
+
+// CurrentKeyID returns the identifier of the current key
+func (kr *KeyRing) CurrentKeyID() string {
+	return kr.Current().KeyID()
+}
+
+// Wipe zeroes the private keys of the current and all previous key pairs
+func (kr *KeyRing) Wipe() {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	kr.current.Wipe()
+	for _, keyPair := range kr.previous {
+		keyPair.Wipe()
+	}
+}
+
+// Previous returns the retained previous key pairs, newest first
+func (kr *KeyRing) Previous() []*KeyPair {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+
+	previous := make([]*KeyPair, len(kr.previous))
+	copy(previous, kr.previous)
+	return previous
+}
+
+// Rotate makes newKey the current key and keeps the replaced key for verification.
+// It returns the replaced key.
+func (kr *KeyRing) Rotate(newKey *KeyPair) (*KeyPair, error) {
+	if newKey == nil {
+		return nil, fmt.Errorf("new key pair is required")
+	}
+
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	old := kr.current
+	if old.KeyID() == newKey.KeyID() {
+		return nil, fmt.Errorf("key %s is already current", newKey.KeyID())
+	}
+
+	// A key rotated back in is no longer a previous key
+	kr.removeLocked(newKey.KeyID())
+	kr.previous = append([]*KeyPair{old}, kr.previous...)
+	kr.current = newKey
+	kr.trimLocked()
+
+	return old, nil
+}
+
+// Retire drops a previous key so signatures made with it no longer verify
+func (kr *KeyRing) Retire(keyID string) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	if kr.current.KeyID() == keyID {
+		return fmt.Errorf("cannot retire the current key %s", keyID)
+	}
+	if !kr.removeLocked(keyID) {
+		return fmt.Errorf("key %s not found", keyID)
+	}
+	return nil
+}
+
+// Lookup returns the key pair with the given identifier, current or previous
+func (kr *KeyRing) Lookup(keyID string) (*KeyPair, bool) {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+
+	if kr.current.KeyID() == keyID {
+		return kr.current, true
+	}
+	for _, kp := range kr.previous {
+		if kp.KeyID() == keyID {
+			return kp, true
+		}
+	}
+	return nil, false
+}
+
+// Sign signs message with the current key and returns the key's identifier with the signature
+func (kr *KeyRing) Sign(message []byte) (string, []byte) {
+	current := kr.Current()
+	return current.KeyID(), current.Sign(message)
+}
+
+// Verify checks a signature against the key with the given identifier. An empty
+// keyID tries every key in the ring.
+func (kr *KeyRing) Verify(keyID string, message, signature []byte) bool {
+	if keyID != "" {
+		kp, ok := kr.Lookup(keyID)
+		return ok && kp.Verify(message, signature)
+	}
+
+	kr.mutex.RLock()
+	keys := append([]*KeyPair{kr.current}, kr.previous...)
+	kr.mutex.RUnlock()
+
+	for _, kp := range keys {
+		if kp.Verify(message, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLocked drops a previous key and reports whether it was present. The caller holds the mutex.
+func (kr *KeyRing) removeLocked(keyID string) bool {
+	for i, kp := range kr.previous {
+		if kp.KeyID() == keyID {
+			kr.previous = append(kr.previous[:i], kr.previous[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// trimLocked drops the oldest previous keys beyond maxPrevious. The caller holds the mutex.
+func (kr *KeyRing) trimLocked() {
+	if kr.maxPrevious >= 0 && len(kr.previous) > kr.maxPrevious {
+		kr.previous = kr.previous[:kr.maxPrevious]
+	}
+}
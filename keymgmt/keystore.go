@@ -0,0 +1,108 @@
+package keymgmt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultKeyStoreService is the service name system key stores file key pairs under
+const DefaultKeyStoreService = "emsg-client-sdk"
+
+// maxKeyNameLength bounds key and service names accepted by key stores
+const maxKeyNameLength = 128
+
+var (
+	// ErrKeyNotFound is returned by KeyStore.Load and Delete when no key pair is stored under a name
+	ErrKeyNotFound = errors.New("key pair not found")
+	// ErrKeyStoreUnavailable is returned by NewSystemKeyStore when the platform has no usable secret store
+	ErrKeyStoreUnavailable = errors.New("system key store unavailable")
+)
+
+// KeyStore keeps private keys somewhere other than plain files, such as the
+// operating system's keychain. Names identify key pairs within the store, e.g.
+// by EMSG address.
+type KeyStore interface {
+	Save(name string, kp *KeyPair) error
+	Load(name string) (*KeyPair, error)
+	Delete(name string) error
+}
+
+// LoadOrGenerateKeyPair loads the key pair stored under name, generating and
+// saving a new one if there is none
+func LoadOrGenerateKeyPair(store KeyStore, name string) (*KeyPair, error) {
+	kp, err := store.Load(name)
+	if err == nil {
+		return kp, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	kp, err = GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Save(name, kp); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// MemoryKeyStore is an in-memory KeyStore, for tests and short-lived processes
+type MemoryKeyStore struct {
+	keys  map[string]string // Private keys as hex, by name
+	mutex sync.RWMutex
+}
+
+// NewMemoryKeyStore creates an empty in-memory key store
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]string)}
+}
+
+// Save stores a key pair under name, replacing any existing one
+func (m *MemoryKeyStore) Save(name string, kp *KeyPair) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.keys[name] = kp.PrivateKeyHex()
+	return nil
+}
+
+// Load returns the key pair stored under name
+func (m *MemoryKeyStore) Load(name string) (*KeyPair, error) {
+	m.mutex.RLock()
+	secret, exists := m.keys[name]
+	m.mutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return LoadPrivateKeyFromHex(secret)
+}
+
+// Delete removes the key pair stored under name
+func (m *MemoryKeyStore) Delete(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.keys[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	delete(m.keys, name)
+	return nil
+}
+
+// validateKeyName restricts names to characters every platform store and its
+// command-line tools accept without quoting: letters, digits and . _ - @ #
+func validateKeyName(name string) error {
+	if name == "" || len(name) > maxKeyNameLength {
+		return fmt.Errorf("invalid key name %q: must be 1 to %d characters", name, maxKeyNameLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' || r == '@' || r == '#') {
+			return fmt.Errorf("invalid key name %q: only letters, digits and . _ - @ # are allowed", name)
+		}
+	}
+	return nil
+}
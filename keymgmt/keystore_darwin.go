@@ -0,0 +1,111 @@
+//go:build darwin
+
+package keymgmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityItemNotFound is the exit status of security(1) when no keychain item matches
+const securityItemNotFound = 44
+
+var errItemNotFound = errors.New("keychain item not found")
+
+// keychainStore keeps key pairs as generic passwords in the user's login keychain
+type keychainStore struct {
+	service string
+	tool    string
+}
+
+// NewSystemKeyStore returns a KeyStore backed by the macOS Keychain. Key pairs are
+// filed under service (DefaultKeyStoreService if empty).
+func NewSystemKeyStore(service string) (KeyStore, error) {
+	if service == "" {
+		service = DefaultKeyStoreService
+	}
+	if err := validateKeyName(service); err != nil {
+		return nil, err
+	}
+
+	tool, err := exec.LookPath("security")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyStoreUnavailable, err)
+	}
+	return &keychainStore{service: service, tool: tool}, nil
+}
+
+// Save stores a key pair under name, replacing any existing one
+func (k *keychainStore) Save(name string, kp *KeyPair) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+
+	// Interactive mode reads the command from stdin, keeping the key out of the process list
+	command := fmt.Sprintf("add-generic-password -U -s %q -a %q -l %q -w %q\n",
+		k.service, name, k.service+" "+name, kp.PrivateKeyHex())
+	if _, err := k.run(command); err != nil {
+		return fmt.Errorf("failed to save key pair to keychain: %w", err)
+	}
+	return nil
+}
+
+// Load returns the key pair stored under name
+func (k *keychainStore) Load(name string) (*KeyPair, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+
+	output, err := k.run(fmt.Sprintf("find-generic-password -s %q -a %q -w\n", k.service, name))
+	if err != nil {
+		return nil, k.notFound(name, err, "failed to load key pair from keychain")
+	}
+	return LoadPrivateKeyFromHex(strings.TrimSpace(output))
+}
+
+// Delete removes the key pair stored under name
+func (k *keychainStore) Delete(name string) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+
+	if _, err := k.run(fmt.Sprintf("delete-generic-password -s %q -a %q\n", k.service, name)); err != nil {
+		return k.notFound(name, err, "failed to delete key pair from keychain")
+	}
+	return nil
+}
+
+// run executes one security(1) command and returns its standard output
+func (k *keychainStore) run(command string) (string, error) {
+	cmd := exec.Command(k.tool, "-i")
+	cmd.Stdin = strings.NewReader(command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound || strings.Contains(msg, "could not be found") {
+		return "", errItemNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, msg)
+	}
+	// Interactive mode reports failed commands on stderr with a zero exit status
+	if msg != "" {
+		return "", errors.New(msg)
+	}
+	return stdout.String(), nil
+}
+
+// notFound maps a missing keychain item to ErrKeyNotFound
+func (k *keychainStore) notFound(name string, err error, context string) error {
+	if errors.Is(err, errItemNotFound) {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return fmt.Errorf("%s: %w", context, err)
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package keymgmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var errNoMatch = errors.New("no matching secret")
+
+// secretServiceStore keeps key pairs in the freedesktop Secret Service (GNOME
+// Keyring, KWallet) through secret-tool(1)
+type secretServiceStore struct {
+	service string
+	tool    string
+}
+
+// NewSystemKeyStore returns a KeyStore backed by the Linux Secret Service. Key
+// pairs are filed under service (DefaultKeyStoreService if empty). It needs
+// secret-tool from libsecret and a running Secret Service provider.
+func NewSystemKeyStore(service string) (KeyStore, error) {
+	if service == "" {
+		service = DefaultKeyStoreService
+	}
+	if err := validateKeyName(service); err != nil {
+		return nil, err
+	}
+
+	tool, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyStoreUnavailable, err)
+	}
+	return &secretServiceStore{service: service, tool: tool}, nil
+}
+
+// Save stores a key pair under name, replacing any existing one
+func (s *secretServiceStore) Save(name string, kp *KeyPair) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+
+	// secret-tool reads the secret from stdin, keeping it out of the process list
+	args := append([]string{"store", "--label=" + s.service + " " + name}, s.attributes(name)...)
+	if _, err := s.run(kp.PrivateKeyHex(), args...); err != nil {
+		return fmt.Errorf("failed to save key pair to secret service: %w", err)
+	}
+	return nil
+}
+
+// Load returns the key pair stored under name
+func (s *secretServiceStore) Load(name string) (*KeyPair, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+
+	output, err := s.run("", append([]string{"lookup"}, s.attributes(name)...)...)
+	if errors.Is(err, errNoMatch) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key pair from secret service: %w", err)
+	}
+	return LoadPrivateKeyFromHex(strings.TrimSpace(output))
+}
+
+// Delete removes the key pair stored under name
+func (s *secretServiceStore) Delete(name string) error {
+	// clear succeeds whether or not an item matched
+	if _, err := s.Load(name); err != nil {
+		return err
+	}
+	if _, err := s.run("", append([]string{"clear"}, s.attributes(name)...)...); err != nil {
+		return fmt.Errorf("failed to delete key pair from secret service: %w", err)
+	}
+	return nil
+}
+
+// attributes identifies a key pair's item in the secret service
+func (s *secretServiceStore) attributes(name string) []string {
+	return []string{"service", s.service, "account", name}
+}
+
+// run executes secret-tool with stdin as input and returns its standard output
+func (s *secretServiceStore) run(stdin string, args ...string) (string, error) {
+	cmd := exec.Command(s.tool, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+
+	// A lookup that matches nothing exits non-zero without printing anything
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && msg == "" && stdout.Len() == 0 {
+		return "", errNoMatch
+	}
+	if err != nil && msg != "" {
+		return "", fmt.Errorf("%w: %s", err, msg)
+	}
+	return stdout.String(), err
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !linux && !windows
+
+package keymgmt
+
+// NewSystemKeyStore returns ErrKeyStoreUnavailable: this platform has no supported secret store
+func NewSystemKeyStore(service string) (KeyStore, error) {
+	return nil, ErrKeyStoreUnavailable
+}
@@ -0,0 +1,131 @@
+//go:build windows
+
+package keymgmt
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredWrite  = advapi32.NewProc("CredWriteW")
+	procCredRead   = advapi32.NewProc("CredReadW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors the Win32 CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialStore keeps key pairs as generic credentials in the Windows Credential Manager
+type credentialStore struct {
+	service string
+}
+
+// NewSystemKeyStore returns a KeyStore backed by the Windows Credential Manager.
+// Key pairs are filed under service (DefaultKeyStoreService if empty).
+func NewSystemKeyStore(service string) (KeyStore, error) {
+	if service == "" {
+		service = DefaultKeyStoreService
+	}
+	if err := validateKeyName(service); err != nil {
+		return nil, err
+	}
+	if err := advapi32.Load(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyStoreUnavailable, err)
+	}
+	return &credentialStore{service: service}, nil
+}
+
+// Save stores a key pair under name, replacing any existing one
+func (c *credentialStore) Save(name string, kp *KeyPair) error {
+	target, err := c.target(name)
+	if err != nil {
+		return err
+	}
+	userName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	secret := []byte(kp.PrivateKeyHex())
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		CredentialBlob:     &secret[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	if ret, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return fmt.Errorf("failed to save key pair to credential manager: %w", err)
+	}
+	return nil
+}
+
+// Load returns the key pair stored under name
+func (c *credentialStore) Load(name string) (*KeyPair, error) {
+	target, err := c.target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *credential
+	ret, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to load key pair from credential manager: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	secret := string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize))
+	return LoadPrivateKeyFromHex(secret)
+}
+
+// Delete removes the key pair stored under name
+func (c *credentialStore) Delete(name string) error {
+	target, err := c.target(name)
+	if err != nil {
+		return err
+	}
+
+	if ret, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		}
+		return fmt.Errorf("failed to delete key pair from credential manager: %w", err)
+	}
+	return nil
+}
+
+// target returns the credential target name for a key pair, "service:name"
+func (c *credentialStore) target(name string) (*uint16, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+	return syscall.UTF16PtrFromString(c.service + ":" + name)
+}
@@ -0,0 +1,161 @@
+package keymgmt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// recoveryAlphabet is Crockford's base32 alphabet, which omits easily confused letters
+const recoveryAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// RecoveryCodeLength is the number of symbols in a recovery code, excluding the separator
+const RecoveryCodeLength = 10
+
+// GenerateRecoveryCodes creates count one-time recovery codes formatted as "XXXXX-XXXXX"
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	return GenerateRecoveryCodesFrom(count, rand.Reader)
+}
+
+// GenerateRecoveryCodesFrom creates recovery codes from an entropy source (nil = crypto/rand)
+func GenerateRecoveryCodesFrom(count int, entropy io.Reader) ([]string, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("recovery code count must be positive")
+	}
+
+	codes := make([]string, 0, count)
+	buf := make([]byte, RecoveryCodeLength)
+	for len(codes) < count {
+		if _, err := io.ReadFull(utils.Entropy(entropy), buf); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		var code strings.Builder
+		for i, b := range buf {
+			if i == RecoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes = append(codes, code.String())
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode canonicalizes user input: case, separators and whitespace are
+// ignored, and the commonly misread letters O, I and L are read as digits
+func NormalizeRecoveryCode(code string) (string, error) {
+	replacer := strings.NewReplacer("-", "", " ", "", "O", "0", "I", "1", "L", "1")
+	normalized := replacer.Replace(strings.ToUpper(strings.TrimSpace(code)))
+
+	if len(normalized) != RecoveryCodeLength {
+		return "", fmt.Errorf("recovery code must have %d characters", RecoveryCodeLength)
+	}
+	for _, r := range normalized {
+		if !strings.ContainsRune(recoveryAlphabet, r) {
+			return "", fmt.Errorf("recovery code contains invalid character %q", r)
+		}
+	}
+
+	return normalized, nil
+}
+
+// Cost of recovery code hashes: scrypt with N = 2^recoveryHashLogN. Codes hold
+// only 50 bits, so a leaked table must be slow to guess against.
+const (
+	recoveryHashLogN    = 15
+	recoveryHashR       = 8
+	recoveryHashP       = 1
+	recoveryHashSaltLen = 16
+	recoveryHashKeyLen  = 32
+
+	// Hashes asking for more work than this are rejected rather than computed
+	maxRecoveryHashLogN = 20
+)
+
+// HashRecoveryCode returns the salted scrypt hash under which a recovery code is
+// stored, encoded as "$scrypt$<log2 N>$<r>$<p>$<salt>$<hash>" in unpadded
+// base64, without the commas SetupRecoveryCodes joins hashes with.
+// The hash is bound to the account address and its identity public key, so a
+// code only recovers the identity it was issued for; verify codes against it
+// with VerifyRecoveryCode.
+func HashRecoveryCode(code, address, publicKeyBase64 string) (string, error) {
+	return HashRecoveryCodeFrom(code, address, publicKeyBase64, rand.Reader)
+}
+
+// HashRecoveryCodeFrom hashes a recovery code with a salt read from an entropy
+// source (nil = crypto/rand)
+func HashRecoveryCodeFrom(code, address, publicKeyBase64 string, entropy io.Reader) (string, error) {
+	normalized, err := NormalizeRecoveryCode(code)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, recoveryHashSaltLen)
+	if _, err := io.ReadFull(utils.Entropy(entropy), salt); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code salt: %w", err)
+	}
+	hash, err := recoveryCodeKey(normalized, address, publicKeyBase64, salt, recoveryHashLogN, recoveryHashR, recoveryHashP)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$%d$%d$%d$%s$%s", recoveryHashLogN, recoveryHashR, recoveryHashP,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyRecoveryCode reports whether a recovery code matches a hash made by
+// HashRecoveryCode for the same address and identity key, e.g. on a server
+func VerifyRecoveryCode(code, address, publicKeyBase64, encodedHash string) (bool, error) {
+	normalized, err := NormalizeRecoveryCode(code)
+	if err != nil {
+		return false, err
+	}
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 7 || parts[0] != "" || parts[1] != "scrypt" {
+		return false, fmt.Errorf("invalid recovery code hash")
+	}
+	logN, errN := strconv.Atoi(parts[2])
+	r, errR := strconv.Atoi(parts[3])
+	p, errP := strconv.Atoi(parts[4])
+	if err := errors.Join(errN, errR, errP); err != nil {
+		return false, fmt.Errorf("invalid recovery code hash parameters: %w", err)
+	}
+	if logN < 1 || logN > maxRecoveryHashLogN || r < 1 || r > 32 || p < 1 || p > 16 {
+		return false, fmt.Errorf("recovery code hash parameters out of range")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid recovery code hash salt: %w", err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[6])
+	if err != nil || len(expected) == 0 {
+		return false, fmt.Errorf("invalid recovery code hash")
+	}
+
+	hash, err := recoveryCodeKey(normalized, address, publicKeyBase64, salt, logN, r, p)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// recoveryCodeKey derives the stored hash of a normalized code
+func recoveryCodeKey(normalized, address, publicKeyBase64 string, salt []byte, logN, r, p int) ([]byte, error) {
+	secret := []byte("emsg-recovery:" + utils.NormalizeEMSGAddress(address) + ":" + publicKeyBase64 + ":" + normalized)
+	hash, err := scrypt.Key(secret, salt, 1<<logN, r, p, recoveryHashKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash recovery code: %w", err)
+	}
+	return hash, nil
+}
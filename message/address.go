@@ -0,0 +1,43 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// FromAddress sets the sender from a parsed address
+func (mb *MessageBuilder) FromAddress(address utils.Address) *MessageBuilder {
+	mb.message.From = address.String()
+	return mb
+}
+
+// ToAddresses sets the recipients from parsed addresses
+func (mb *MessageBuilder) ToAddresses(addresses ...utils.Address) *MessageBuilder {
+	mb.message.To = utils.AddressStrings(addresses)
+	return mb
+}
+
+// CCAddresses sets the carbon copy recipients from parsed addresses
+func (mb *MessageBuilder) CCAddresses(addresses ...utils.Address) *MessageBuilder {
+	mb.message.CC = utils.AddressStrings(addresses)
+	return mb
+}
+
+// SenderAddress parses the sender address
+func (msg *Message) SenderAddress() (utils.Address, error) {
+	addr, err := utils.ParseAddress(msg.From)
+	if err != nil {
+		return utils.Address{}, fmt.Errorf("invalid sender address: %w", err)
+	}
+	return addr, nil
+}
+
+// RecipientAddresses parses all recipients (To + CC)
+func (msg *Message) RecipientAddresses() ([]utils.Address, error) {
+	addresses, err := utils.ParseAddresses(msg.GetRecipients())
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	return addresses, nil
+}
@@ -0,0 +1,47 @@
+package message
+
+import "slices"
+
+// Client feature names advertised in ClientInfo.Features
+const (
+	FeatureEncryption         = "encryption"
+	FeatureKeyBundles         = "key_bundles"
+	FeatureAttachments        = "attachments"
+	FeatureChunkedAttachments = "attachments.chunked"
+	FeatureGroups             = "groups"
+	FeatureMarkdown           = "content.markdown" // Renders text/markdown bodies
+	FeatureHTML               = "content.html"     // Renders text/html bodies
+)
+
+// ClientInfo describes the software that sent a message so correspondents can
+// degrade gracefully when a feature is not supported
+type ClientInfo struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+// Supports returns true if the client advertises the named feature
+func (ci *ClientInfo) Supports(feature string) bool {
+	return slices.Contains(ci.Features, feature)
+}
+
+// ClientInfo attaches a client-info envelope to the message
+func (mb *MessageBuilder) ClientInfo(info *ClientInfo) *MessageBuilder {
+	mb.message.ClientInfo = info
+	return mb
+}
+
+// HasClientInfo returns true if the sender advertised its client information
+func (msg *Message) HasClientInfo() bool {
+	return msg.ClientInfo != nil
+}
+
+// SenderSupports reports whether the sender's client supports a feature. The second
+// result is false when the sender did not advertise its client information.
+func (msg *Message) SenderSupports(feature string) (supported bool, known bool) {
+	if msg.ClientInfo == nil {
+		return false, false
+	}
+	return msg.ClientInfo.Supports(feature), true
+}
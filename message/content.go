@@ -0,0 +1,201 @@
+package message
+
+import (
+	"mime"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Content types of message bodies
+const (
+	ContentTypePlain    = "text/plain"
+	ContentTypeMarkdown = "text/markdown"
+	ContentTypeHTML     = "text/html"
+)
+
+// contentTypeFeatures maps the rich content types to the ClientInfo feature
+// advertising that a client renders them
+var contentTypeFeatures = map[string]string{
+	ContentTypeMarkdown: FeatureMarkdown,
+	ContentTypeHTML:     FeatureHTML,
+}
+
+// ContentTypeFeature returns the ClientInfo feature advertising that a client
+// renders contentType, and false for text/plain and unsupported types
+func ContentTypeFeature(contentType string) (string, bool) {
+	feature, ok := contentTypeFeatures[mediaType(contentType)]
+	return feature, ok
+}
+
+// ContentType sets the content type of the body; see Markdown and HTML. Types
+// other than text/plain, text/markdown and text/html make Build fail.
+func (mb *MessageBuilder) ContentType(contentType string) *MessageBuilder {
+	if mediaType(contentType) == ContentTypePlain {
+		contentType = ""
+	}
+	mb.message.ContentType = contentType
+	return mb
+}
+
+// Markdown sets a Markdown body
+func (mb *MessageBuilder) Markdown(body string) *MessageBuilder {
+	mb.message.Body = body
+	return mb.ContentType(ContentTypeMarkdown)
+}
+
+// HTML sets an HTML body. Build sanitizes it with SanitizeHTML.
+func (mb *MessageBuilder) HTML(body string) *MessageBuilder {
+	mb.message.Body = body
+	return mb.ContentType(ContentTypeHTML)
+}
+
+// PlainBody sets the plain text shown by clients that do not render the body's
+// content type. Without it Build derives one from a Markdown or HTML body.
+func (mb *MessageBuilder) PlainBody(text string) *MessageBuilder {
+	mb.message.PlainBody = text
+	return mb
+}
+
+// applyContentType checks the content type, sanitizes an HTML body and fills
+// in the plain text fallback of a rich body
+func (mb *MessageBuilder) applyContentType() error {
+	msg := mb.message
+	if msg.ContentType == "" {
+		msg.PlainBody = ""
+		return nil
+	}
+	if _, ok := ContentTypeFeature(msg.ContentType); !ok {
+		return invalid("content_type", "unsupported content type %q", msg.ContentType)
+	}
+
+	if msg.MediaType() == ContentTypeHTML {
+		msg.Body = SanitizeHTML(msg.Body)
+	}
+	if msg.PlainBody == "" {
+		msg.PlainBody = ToPlainText(msg.ContentType, msg.Body)
+	}
+	return nil
+}
+
+// MediaType returns the lowercase content type of the body without parameters,
+// text/plain if none is set
+func (msg *Message) MediaType() string {
+	return mediaType(msg.ContentType)
+}
+
+// IsRichText returns true if the body is not plain text
+func (msg *Message) IsRichText() bool {
+	return msg.MediaType() != ContentTypePlain
+}
+
+// PlainText returns the body as plain text: the body itself when it is plain
+// text, otherwise PlainBody or, if the sender left it out, text derived from
+// the body. An encrypted message carries no PlainBody; pass its decrypted body
+// to ToPlainText instead.
+func (msg *Message) PlainText() string {
+	if !msg.IsRichText() {
+		return msg.Body
+	}
+	if msg.PlainBody != "" {
+		return msg.PlainBody
+	}
+	return ToPlainText(msg.ContentType, msg.Body)
+}
+
+// Render picks how to show the message on a client rendering the given content
+// types: the body when its type is among them, sanitized if it is HTML, and
+// otherwise the plain text. It returns the text with its content type. Render
+// an encrypted message's decrypted body with RenderContent.
+func (msg *Message) Render(renders ...string) (string, string) {
+	return RenderContent(msg.ContentType, msg.Body, msg.PlainBody, renders...)
+}
+
+// RenderContent is Render for a body and plain text fallback of the given
+// content type, e.g. a message body after decryption
+func RenderContent(contentType, body, plainBody string, renders ...string) (string, string) {
+	media := mediaType(contentType)
+	if media == ContentTypePlain {
+		return body, ContentTypePlain
+	}
+	if slices.ContainsFunc(renders, func(ct string) bool { return mediaType(ct) == media }) {
+		if media == ContentTypeHTML {
+			body = SanitizeHTML(body)
+		}
+		return body, media
+	}
+	if plainBody != "" {
+		return plainBody, ContentTypePlain
+	}
+	return ToPlainText(contentType, body), ContentTypePlain
+}
+
+// ToPlainText converts a body of the given content type to plain text. Bodies
+// of unknown types are returned unchanged.
+func ToPlainText(contentType, body string) string {
+	switch mediaType(contentType) {
+	case ContentTypeHTML:
+		return HTMLToText(body)
+	case ContentTypeMarkdown:
+		return MarkdownToText(body)
+	default:
+		return body
+	}
+}
+
+// mediaType returns the lowercase media type of a content type, text/plain if
+// it is empty. Malformed content types are returned lowercased as they are.
+func mediaType(contentType string) string {
+	if strings.TrimSpace(contentType) == "" {
+		return ContentTypePlain
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return media
+}
+
+// Markdown syntax removed by MarkdownToText
+var (
+	markdownFence    = regexp.MustCompile("(?m)^[ \t]*(```|~~~).*$\n?")
+	markdownHeading  = regexp.MustCompile(`(?m)^[ \t]{0,3}#{1,6}[ \t]+(.*?)[ \t#]*$`)
+	markdownRule     = regexp.MustCompile(`(?m)^[ \t]{0,3}([-*_][ \t]*){3,}$`)
+	markdownQuote    = regexp.MustCompile(`(?m)^[ \t]{0,3}>[ \t]?`)
+	markdownBullet   = regexp.MustCompile(`(?m)^([ \t]*)[*+][ \t]+`)
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	markdownAutoLink = regexp.MustCompile(`<((?:https?|mailto):[^>\s]+)>`)
+	markdownCode     = regexp.MustCompile("`([^`]+)`")
+	markdownStrong   = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	markdownStrike   = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	markdownEmStar   = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	// Underscores only mark emphasis outside words, so snake_case survives
+	markdownEmUnder = regexp.MustCompile(`(^|[^\w])_(\S(?:[^_]*?\S)?)_([^\w]|$)`)
+)
+
+// MarkdownToText converts a Markdown body to plain text for clients that do not
+// render Markdown: emphasis, headings, quotes and code markers are removed,
+// bullets become "- " and links keep their target in parentheses
+func MarkdownToText(body string) string {
+	text := markdownFence.ReplaceAllString(body, "")
+	text = markdownHeading.ReplaceAllString(text, "$1")
+	text = markdownRule.ReplaceAllString(text, "")
+	text = markdownQuote.ReplaceAllString(text, "")
+	text = markdownBullet.ReplaceAllString(text, "$1- ")
+	text = markdownImage.ReplaceAllString(text, "$1")
+	text = markdownLink.ReplaceAllStringFunc(text, func(link string) string {
+		parts := markdownLink.FindStringSubmatch(link)
+		if parts[1] == parts[2] {
+			return parts[1]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+	text = markdownAutoLink.ReplaceAllString(text, "$1")
+	text = markdownCode.ReplaceAllString(text, "$1")
+	text = markdownStrong.ReplaceAllString(text, "$1$2")
+	text = markdownStrike.ReplaceAllString(text, "$1")
+	text = markdownEmStar.ReplaceAllString(text, "$1")
+	text = markdownEmUnder.ReplaceAllString(text, "$1$2$3")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,149 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+)
+
+// builderDraftVersion is the format version of serialized builder drafts
+const builderDraftVersion = 1
+
+// DraftStore persists serialized builder drafts under a key. Every
+// store.DraftStore satisfies it, keeping drafts as plain JSON; wrap one with
+// Client.BuilderDraftStore to keep them encrypted at rest.
+type DraftStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// BuilderDraft is the state of an unfinished MessageBuilder
+type BuilderDraft struct {
+	Version       int                `json:"version"`
+	From          string             `json:"from,omitempty"`
+	To            []string           `json:"to,omitempty"`
+	CC            []string           `json:"cc,omitempty"`
+	Subject       string             `json:"subject,omitempty"`
+	Body          string             `json:"body,omitempty"`
+	ContentType   string             `json:"content_type,omitempty"`
+	PlainBody     string             `json:"plain_body,omitempty"`
+	GroupID       string             `json:"group_id,omitempty"`
+	MessageID     string             `json:"message_id,omitempty"`
+	CorrelationID string             `json:"correlation_id,omitempty"`
+	InReplyTo     string             `json:"in_reply_to,omitempty"`
+	Sticker       string             `json:"sticker,omitempty"`
+	Attachments   []*DraftAttachment `json:"attachments,omitempty"`
+	ExpiresIn     time.Duration      `json:"expires_in,omitempty"` // Set with ExpiresIn; the expiry is fixed when the message is built
+	Encrypt       bool               `json:"encrypt,omitempty"`    // The builder was set to encrypt; the encryption manager itself is not saved
+	SavedAt       time.Time          `json:"saved_at"`
+}
+
+// DraftAttachment references an attachment of a draft
+type DraftAttachment struct {
+	Path       string                  `json:"path,omitempty"`       // File added with AttachFile, attached again when the draft is loaded
+	Attachment *attachments.Attachment `json:"attachment,omitempty"` // Any other attachment, saved with its data
+}
+
+// Draft returns the builder's current state. Attachments added with AttachFile
+// are referenced by path rather than copied; ones that failed to attach are left out.
+func (mb *MessageBuilder) Draft() *BuilderDraft {
+	msg := mb.message
+	draft := &BuilderDraft{
+		Version:       builderDraftVersion,
+		From:          msg.From,
+		To:            append([]string(nil), msg.To...),
+		CC:            append([]string(nil), msg.CC...),
+		Subject:       msg.Subject,
+		Body:          msg.Body,
+		ContentType:   msg.ContentType,
+		PlainBody:     msg.PlainBody,
+		GroupID:       msg.GroupID,
+		MessageID:     msg.MessageID,
+		CorrelationID: msg.CorrelationID,
+		InReplyTo:     msg.InReplyTo,
+		Sticker:       msg.Sticker,
+		ExpiresIn:     mb.ttl,
+		Encrypt:       mb.encryptionManager != nil || mb.encryptRequested,
+		SavedAt:       time.Now(),
+	}
+	for _, attachment := range msg.Attachments {
+		if path, ok := mb.attachPaths[attachment]; ok {
+			draft.Attachments = append(draft.Attachments, &DraftAttachment{Path: path})
+		} else {
+			draft.Attachments = append(draft.Attachments, &DraftAttachment{Attachment: attachment})
+		}
+	}
+	return draft
+}
+
+// ApplyDraft restores a builder's state from a draft, replacing what was set
+// before. Referenced files are attached again with the builder's attachment
+// manager, so set it first; files that cannot be attached make Build fail. A
+// draft that asked for encryption makes Build fail unless WithEncryption is set.
+func (mb *MessageBuilder) ApplyDraft(draft *BuilderDraft) *MessageBuilder {
+	msg := mb.message
+	msg.From = draft.From
+	msg.To = append([]string(nil), draft.To...)
+	msg.CC = append([]string(nil), draft.CC...)
+	msg.Subject = draft.Subject
+	msg.Body = draft.Body
+	msg.ContentType = draft.ContentType
+	msg.PlainBody = draft.PlainBody
+	msg.GroupID = draft.GroupID
+	msg.MessageID = draft.MessageID
+	msg.CorrelationID = draft.CorrelationID
+	msg.InReplyTo = draft.InReplyTo
+	msg.Sticker = draft.Sticker
+	mb.ttl = draft.ExpiresIn
+	mb.encryptRequested = draft.Encrypt
+
+	msg.Attachments = nil
+	mb.attachPaths = nil
+	mb.attachErrs = nil
+	for _, ref := range draft.Attachments {
+		switch {
+		case ref.Path != "":
+			mb.AttachFile(ref.Path)
+		case ref.Attachment != nil:
+			mb.Attachment(ref.Attachment)
+		}
+	}
+	return mb
+}
+
+// SaveDraft serializes the builder's state into a draft store under key, so an
+// unfinished message survives an app restart
+func (mb *MessageBuilder) SaveDraft(store DraftStore, key string) error {
+	if key == "" {
+		return fmt.Errorf("draft key is required")
+	}
+
+	data, err := json.Marshal(mb.Draft())
+	if err != nil {
+		return fmt.Errorf("failed to encode draft: %w", err)
+	}
+	if err := store.Put(key, data); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	return nil
+}
+
+// LoadDraft restores the builder's state from the draft saved under key. See ApplyDraft.
+func (mb *MessageBuilder) LoadDraft(store DraftStore, key string) error {
+	data, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	var draft BuilderDraft
+	if err := json.Unmarshal(data, &draft); err != nil {
+		return fmt.Errorf("failed to decode draft: %w", err)
+	}
+	if draft.Version > builderDraftVersion {
+		return fmt.Errorf("unsupported draft version %d", draft.Version)
+	}
+	mb.ApplyDraft(&draft)
+	return nil
+}
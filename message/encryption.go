@@ -0,0 +1,40 @@
+package message
+
+import "github.com/emsg-protocol/emsg-client-sdk/encryption"
+
+// EncryptionFallback explains why a message was not encrypted for every recipient
+type EncryptionFallback string
+
+const (
+	FallbackNone               EncryptionFallback = ""                       // Encrypted for every recipient
+	FallbackNotRequested       EncryptionFallback = "not_requested"          // The sender did not ask for encryption
+	FallbackMissingKeys        EncryptionFallback = "missing_recipient_keys" // Some recipients have no known key, so the body was sent in plaintext
+	FallbackSingleRecipientKey EncryptionFallback = "single_recipient_key"   // The body was sealed to the first recipient's key only
+	FallbackUnknown            EncryptionFallback = "unknown"                // The decision was not recorded, e.g. the message predates it
+)
+
+// EncryptionDecision records how the builder encrypted an outgoing message, so a
+// plaintext fallback leaves an audit trail in the message's delivery receipt
+type EncryptionDecision struct {
+	Scheme         string             `json:"scheme,omitempty"`             // Encryption scheme, empty when sent in plaintext
+	Covered        []string           `json:"recipients_covered,omitempty"` // Recipients able to decrypt the body
+	Uncovered      []string           `json:"recipients_uncovered,omitempty"`
+	FallbackReason EncryptionFallback `json:"fallback_reason,omitempty"`
+}
+
+// Encrypted reports whether the body was encrypted for at least one recipient
+func (d *EncryptionDecision) Encrypted() bool {
+	return d.Scheme != ""
+}
+
+// EffectiveEncryption returns the recorded encryption decision. Messages built
+// without one, such as received messages, get a decision derived from Encrypted.
+func (msg *Message) EffectiveEncryption() *EncryptionDecision {
+	if msg.EncryptionDecision != nil {
+		return msg.EncryptionDecision
+	}
+	if msg.Encrypted {
+		return &EncryptionDecision{Scheme: encryption.Scheme, FallbackReason: FallbackUnknown}
+	}
+	return &EncryptionDecision{FallbackReason: FallbackUnknown}
+}
@@ -0,0 +1,35 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrValidation matches any *ValidationError with errors.Is
+var ErrValidation = errors.New("validation failed")
+
+// ValidationError describes a message or input that failed validation
+type ValidationError struct {
+	Field string // The offending field, e.g. "from" or "to"
+	Err   error
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying validation failure
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// invalid creates a ValidationError for a field
+func invalid(field, format string, args ...any) *ValidationError {
+	return &ValidationError{Field: field, Err: fmt.Errorf(format, args...)}
+}
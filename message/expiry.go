@@ -0,0 +1,66 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMessageExpired is returned for a message whose ExpiresAt has passed
+var ErrMessageExpired = errors.New("message expired")
+
+// ExpiresIn makes the message self-destruct d after it is sent: recipients
+// reject it once expired and local stores purge it. The expiry is fixed from
+// the message timestamp when Build is called.
+func (mb *MessageBuilder) ExpiresIn(d time.Duration) *MessageBuilder {
+	mb.ttl = d
+	return mb
+}
+
+// applyTTL sets ExpiresAt from the builder's TTL, rounding up to whole seconds
+// so the message never expires early
+func (mb *MessageBuilder) applyTTL() error {
+	if mb.ttl == 0 {
+		return nil
+	}
+	if mb.ttl < 0 {
+		return invalid("expires_at", "expiry must be in the future")
+	}
+	expires := mb.message.SentAt().Add(mb.ttl)
+	mb.message.ExpiresAt = expires.Unix()
+	if expires.Nanosecond() > 0 {
+		mb.message.ExpiresAt++
+	}
+	return nil
+}
+
+// ExpiryTime returns when the message expires, or the zero time if it does not
+func (msg *Message) ExpiryTime() time.Time {
+	if msg.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(msg.ExpiresAt, 0)
+}
+
+// IsExpired returns true if the message has an expiry at or before now
+func (msg *Message) IsExpired(now time.Time) bool {
+	return msg.ExpiresAt != 0 && !now.Before(msg.ExpiryTime())
+}
+
+// TTL returns how long the message has left before it expires at now, and false
+// if it does not expire
+func (msg *Message) TTL(now time.Time) (time.Duration, bool) {
+	if msg.ExpiresAt == 0 {
+		return 0, false
+	}
+	return max(msg.ExpiryTime().Sub(now), 0), true
+}
+
+// CheckExpiry returns a ValidationError matching ErrMessageExpired if the
+// message has expired at now
+func (msg *Message) CheckExpiry(now time.Time) error {
+	if msg.IsExpired(now) {
+		return &ValidationError{Field: "expires_at", Err: fmt.Errorf("%w at %s", ErrMessageExpired, msg.ExpiryTime().UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package message
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// allowedHTMLTags are the formatting elements SanitizeHTML keeps. Images, forms
+// and embedded content are left out: they load remote resources or run code.
+var allowedHTMLTags = map[string]bool{
+	"a": true, "b": true, "strong": true, "i": true, "em": true, "u": true,
+	"s": true, "del": true, "ins": true, "mark": true, "small": true, "sub": true, "sup": true,
+	"code": true, "pre": true, "kbd": true, "p": true, "br": true, "hr": true, "div": true, "span": true,
+	"blockquote": true, "ul": true, "ol": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+}
+
+// voidHTMLTags have no content and no end tag
+var voidHTMLTags = map[string]bool{"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "wbr": true}
+
+// droppedHTMLTags are removed together with their content
+var droppedHTMLTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "template": true,
+	"noscript": true, "textarea": true, "title": true, "head": true, "svg": true, "math": true,
+}
+
+// blockHTMLTags start a new line when converted to text
+var blockHTMLTags = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "blockquote": true, "pre": true,
+	"ul": true, "ol": true, "li": true, "table": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// implicitlyClosedTags end an unclosed element of the same name they follow
+var implicitlyClosedTags = map[string]bool{"a": true, "p": true, "li": true, "tr": true, "td": true, "th": true}
+
+// allowedURLSchemes are the link targets SanitizeHTML keeps
+var allowedURLSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// htmlToken is a piece of an HTML body: text, or a start or end tag
+type htmlToken struct {
+	text  string // Unescaped text; empty for tags
+	tag   string // Lowercase tag name; empty for text
+	end   bool   // An end tag
+	attrs map[string]string
+}
+
+var tagName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*`)
+
+// tokenizeHTML splits an HTML body into text and tags, dropping comments,
+// doctypes and the elements in droppedHTMLTags with their content. Malformed
+// markup is treated as text rather than rejected.
+func tokenizeHTML(body string, emit func(tok htmlToken)) {
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			emit(htmlToken{text: html.UnescapeString(text.String())})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(body); {
+		lt := strings.IndexByte(body[i:], '<')
+		if lt < 0 {
+			text.WriteString(body[i:])
+			break
+		}
+		text.WriteString(body[i : i+lt])
+		i += lt
+		rest := body[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			i += skipPast(rest, "-->", 4)
+			continue
+		case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+			i += skipPast(rest, ">", 2)
+			continue
+		}
+
+		end := strings.HasPrefix(rest, "</")
+		nameStart := 1
+		if end {
+			nameStart = 2
+		}
+		name := tagName.FindString(rest[nameStart:])
+		if name == "" {
+			// A lone '<' is text
+			text.WriteByte('<')
+			i++
+			continue
+		}
+
+		attrs, n, selfClosing := parseTagAttributes(rest, nameStart+len(name))
+		i += n
+		name = strings.ToLower(name)
+		flush()
+
+		if end {
+			emit(htmlToken{tag: name, end: true})
+			continue
+		}
+		if droppedHTMLTags[name] {
+			if !selfClosing {
+				i += skipElementContent(body[i:], name)
+			}
+			continue
+		}
+		emit(htmlToken{tag: name, attrs: attrs})
+		if selfClosing && !voidHTMLTags[name] {
+			emit(htmlToken{tag: name, end: true})
+		}
+	}
+	flush()
+}
+
+// skipPast returns the length of s up to and including the first terminator
+// after offset, or len(s) if there is none
+func skipPast(s, terminator string, offset int) int {
+	if j := strings.Index(s[offset:], terminator); j >= 0 {
+		return offset + j + len(terminator)
+	}
+	return len(s)
+}
+
+// skipElementContent returns the length of s up to and including the end tag
+// of the named element, or len(s) if it is never closed
+func skipElementContent(s, name string) int {
+	lower := strings.ToLower(s)
+	for offset := 0; ; {
+		j := strings.Index(lower[offset:], "</"+name)
+		if j < 0 {
+			return len(s)
+		}
+		offset += j + 2 + len(name)
+		// Only a whole tag name ends the element, not e.g. </scripts
+		if offset >= len(s) || !isTagNameByte(s[offset]) {
+			return offset + skipPast(s[offset:], ">", 0)
+		}
+	}
+}
+
+func isTagNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// parseTagAttributes reads the attributes of the tag starting at s from pos on,
+// returning them with the length of the whole tag and whether it ended with "/>"
+func parseTagAttributes(s string, pos int) (map[string]string, int, bool) {
+	attrs := make(map[string]string)
+	for pos < len(s) {
+		switch c := s[pos]; {
+		case c == '>':
+			return attrs, pos + 1, false
+		case c == '/' && pos+1 < len(s) && s[pos+1] == '>':
+			return attrs, pos + 2, true
+		case c == '/' || isHTMLSpace(c):
+			pos++
+			continue
+		}
+
+		nameEnd := pos
+		for nameEnd < len(s) && !isHTMLSpace(s[nameEnd]) && !strings.ContainsRune("=>/", rune(s[nameEnd])) {
+			nameEnd++
+		}
+		if nameEnd == pos {
+			nameEnd++
+		}
+		name := strings.ToLower(s[pos:nameEnd])
+		pos = nameEnd
+		for pos < len(s) && isHTMLSpace(s[pos]) {
+			pos++
+		}
+		if pos >= len(s) || s[pos] != '=' {
+			attrs[name] = ""
+			continue
+		}
+
+		pos++
+		for pos < len(s) && isHTMLSpace(s[pos]) {
+			pos++
+		}
+		var value string
+		if pos < len(s) && (s[pos] == '"' || s[pos] == '\'') {
+			quote := s[pos]
+			valueEnd := strings.IndexByte(s[pos+1:], quote)
+			if valueEnd < 0 {
+				return attrs, len(s), false
+			}
+			value = s[pos+1 : pos+1+valueEnd]
+			pos += valueEnd + 2
+		} else {
+			valueEnd := pos
+			for valueEnd < len(s) && !isHTMLSpace(s[valueEnd]) && s[valueEnd] != '>' {
+				valueEnd++
+			}
+			value = s[pos:valueEnd]
+			pos = valueEnd
+		}
+		attrs[name] = html.UnescapeString(value)
+	}
+	return attrs, len(s), false
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// SanitizeHTML makes an untrusted HTML body safe to render. Only basic
+// formatting elements are kept; scripts, styles and embedded content are
+// removed with their content, other elements are unwrapped to their text, and
+// every attribute is dropped except title and http, https and mailto link
+// targets. Tags are balanced, so the body cannot affect markup around it.
+func SanitizeHTML(body string) string {
+	var out strings.Builder
+	var open []string
+
+	tokenizeHTML(body, func(tok htmlToken) {
+		switch {
+		case tok.tag == "":
+			out.WriteString(html.EscapeString(tok.text))
+		case !allowedHTMLTags[tok.tag]:
+		case tok.end:
+			// Close the element and any left open inside it; stray end tags are dropped
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.tag {
+					closeTags(&out, open[i:])
+					open = open[:i]
+					break
+				}
+			}
+		default:
+			// An unclosed sibling ends where the next one starts, as browsers parse it
+			if implicitlyClosedTags[tok.tag] && len(open) > 0 && open[len(open)-1] == tok.tag {
+				closeTags(&out, open[len(open)-1:])
+				open = open[:len(open)-1]
+			}
+			out.WriteString("<" + tok.tag)
+			if title, ok := tok.attrs["title"]; ok {
+				out.WriteString(` title="` + html.EscapeString(title) + `"`)
+			}
+			if href, ok := safeURL(tok.attrs["href"]); ok && tok.tag == "a" {
+				out.WriteString(` href="` + html.EscapeString(href) + `" rel="noopener noreferrer nofollow"`)
+			}
+			out.WriteString(">")
+			if !voidHTMLTags[tok.tag] {
+				open = append(open, tok.tag)
+			}
+		}
+	})
+
+	closeTags(&out, open)
+	return out.String()
+}
+
+// closeTags writes the end tags of the open elements, innermost first
+func closeTags(out *strings.Builder, open []string) {
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+}
+
+// safeURL returns a link target with an allowed scheme, trimmed of the
+// whitespace and control characters browsers ignore
+func safeURL(raw string) (string, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+	if cleaned == "" {
+		return "", false
+	}
+	u, err := url.Parse(cleaned)
+	if err != nil || !allowedURLSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	return cleaned, true
+}
+
+var (
+	spaceRun   = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText converts an HTML body to plain text for clients that do not render
+// HTML: block elements become line breaks, list items are bulleted, links keep
+// their target in parentheses and scripts and styles are left out
+func HTMLToText(body string) string {
+	var out strings.Builder
+	var linkHref string
+	var linkText strings.Builder
+	inLink, pre := false, 0
+
+	write := func(s string) {
+		if inLink {
+			linkText.WriteString(s)
+		} else {
+			out.WriteString(s)
+		}
+	}
+
+	tokenizeHTML(body, func(tok htmlToken) {
+		switch {
+		case tok.tag == "":
+			if pre > 0 {
+				write(tok.text)
+			} else {
+				write(spaceRun.ReplaceAllString(tok.text, " "))
+			}
+		case tok.tag == "pre":
+			if tok.end {
+				pre = max(pre-1, 0)
+			} else {
+				pre++
+			}
+			write("\n")
+		case tok.tag == "a" && !tok.end:
+			inLink, linkHref = true, ""
+			linkText.Reset()
+			if href, ok := safeURL(tok.attrs["href"]); ok {
+				linkHref = href
+			}
+		case tok.tag == "a" && inLink:
+			inLink = false
+			text := strings.TrimSpace(linkText.String())
+			switch {
+			case linkHref == "" || text == linkHref || "mailto:"+text == linkHref:
+				write(linkText.String())
+			case text == "":
+				write(linkHref)
+			default:
+				write(linkText.String() + " (" + linkHref + ")")
+			}
+		case tok.tag == "li" && !tok.end:
+			write("\n- ")
+		case tok.tag == "td" || tok.tag == "th":
+			if !tok.end {
+				write(" ")
+			}
+		case blockHTMLTags[tok.tag]:
+			write("\n")
+		}
+	})
+	if inLink {
+		out.WriteString(linkText.String())
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,39 @@
+package message
+
+import (
+	"fmt"
+	"slices"
+	"unicode"
+)
+
+// Labels servers assign to messages; other labels are created by the user
+const (
+	LabelInbox   = "inbox"
+	LabelArchive = "archive"
+	LabelSpam    = "spam"
+)
+
+// MaxLabelLength is the longest label name accepted
+const MaxLabelLength = 64
+
+// HasLabel reports whether the recipient's server filed the message under label
+func (msg *Message) HasLabel(label string) bool {
+	return slices.Contains(msg.Labels, label)
+}
+
+// ValidateLabel checks that a label name is not empty, at most MaxLabelLength
+// bytes, and free of whitespace and control characters
+func ValidateLabel(label string) error {
+	if label == "" {
+		return &ValidationError{Field: "label", Err: fmt.Errorf("label cannot be empty")}
+	}
+	if len(label) > MaxLabelLength {
+		return &ValidationError{Field: "label", Err: fmt.Errorf("label %q exceeds %d bytes", label, MaxLabelLength)}
+	}
+	for _, r := range label {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return &ValidationError{Field: "label", Err: fmt.Errorf("label %q contains whitespace or control characters", label)}
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -39,8 +40,39 @@ type Message struct {
 	// Encryption fields
 	Encrypted     bool   `json:"encrypted,omitempty"`      // Whether the body is encrypted
 	EncryptionKey string `json:"encryption_key,omitempty"` // Sender's encryption public key
+	// Sender's public key bundle, included on first contact so the recipient can reply encrypted
+	KeyBundle *encryption.KeyBundle `json:"key_bundle,omitempty"`
+	// Sending client's name, version and features (omitted when the sender disables advertisement)
+	ClientInfo *ClientInfo `json:"client_info,omitempty"`
 	// Attachment fields
 	Attachments []*attachments.Attachment `json:"attachments,omitempty"` // File attachments
+	// Identifier of the signing key, set when the sender signs with a key ring
+	KeyID string `json:"key_id,omitempty"`
+	// Request/response correlation for service-to-service messaging
+	CorrelationID string `json:"correlation_id,omitempty"` // Set on requests and copied into their replies
+	InReplyTo     string `json:"in_reply_to,omitempty"`    // Message ID of the message this one answers
+	// Encoded delegation token, set when a delegate key signs on the sender's behalf
+	Delegation string `json:"delegation,omitempty"`
+	// Millisecond send time and sender-assigned ordering for stable conversation order
+	TimestampMs int64 `json:"timestamp_ms,omitempty"` // Send time in Unix milliseconds; agrees with Timestamp
+	Sequence    int64 `json:"sequence,omitempty"`     // Sender's SequenceClock value; see OrderingKey
+	// Encoded groups.MembershipProof showing a group message's sender belongs to the group
+	MembershipProof string `json:"membership_proof,omitempty"`
+	// Shortcode of the group sticker the message consists of; Body carries :shortcode: for clients without it
+	Sticker string `json:"sticker,omitempty"`
+	// Labels the recipient's server filed a received message under, e.g. LabelArchive; mailbox state the signature does not cover
+	Labels []string `json:"labels,omitempty"`
+	// Unix time after which recipients reject the message and local stores purge it (0 = never expires)
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// Rich content: the body's content type and a plain text rendering for clients that cannot show it
+	ContentType string `json:"content_type,omitempty"` // ContentTypeMarkdown or ContentTypeHTML ("" = text/plain)
+	PlainBody   string `json:"plain_body,omitempty"`   // Fallback for a rich body; omitted when the body is encrypted
+	// Local identities a received message was addressed to when the client has several; local only, never sent
+	AddressedIdentities []string `json:"-"`
+	// Result of checking a received message's signature; local only, never sent
+	VerificationStatus VerificationStatus `json:"-"`
+	// How the builder encrypted an outgoing message; local only, never sent
+	EncryptionDecision *EncryptionDecision `json:"-"`
 }
 
 // SystemMessage represents a system message with structured data
@@ -63,13 +95,21 @@ type MessageBuilder struct {
 	message           *Message
 	encryptionManager *encryption.EncryptionManager
 	attachmentManager *attachments.AttachmentManager
+	idGenerator       utils.IDGenerator                  // Generates the message ID when none is set (nil = derived from the content)
+	attachErrs        []error                            // Failures from AttachFile and AttachData, reported by Build
+	mustAttach        bool                               // AttachFile and AttachData panic on failure instead of deferring it to Build
+	attachPaths       map[*attachments.Attachment]string // Source files of attachments added with AttachFile, saved in drafts
+	encryptRequested  bool                               // A loaded draft asked for encryption; Build fails without an encryption manager
+	ttl               time.Duration                      // Time from sending until the message expires (0 = never)
 }
 
 // NewMessageBuilder creates a new message builder
 func NewMessageBuilder() *MessageBuilder {
+	now := time.Now()
 	return &MessageBuilder{
 		message: &Message{
-			Timestamp: time.Now().Unix(),
+			Timestamp:   now.Unix(),
+			TimestampMs: now.UnixMilli(),
 		},
 	}
 }
@@ -116,44 +156,87 @@ func (mb *MessageBuilder) MessageID(messageID string) *MessageBuilder {
 	return mb
 }
 
+// WithIDGenerator generates the message ID with gen when none is set, instead
+// of deriving it from the content and timestamp
+func (mb *MessageBuilder) WithIDGenerator(gen utils.IDGenerator) *MessageBuilder {
+	mb.idGenerator = gen
+	return mb
+}
+
+// WithClock timestamps the message with clock instead of the system time, e.g.
+// a utils.FakeClock for reproducible fixtures
+func (mb *MessageBuilder) WithClock(clock utils.Clock) *MessageBuilder {
+	now := clock.Now()
+	mb.message.Timestamp = now.Unix()
+	mb.message.TimestampMs = now.UnixMilli()
+	return mb
+}
+
 // WithEncryption sets the encryption manager for this message
 func (mb *MessageBuilder) WithEncryption(encManager *encryption.EncryptionManager) *MessageBuilder {
 	mb.encryptionManager = encManager
 	return mb
 }
 
+// KeyBundle attaches the sender's public key bundle to the message
+func (mb *MessageBuilder) KeyBundle(bundle *encryption.KeyBundle) *MessageBuilder {
+	mb.message.KeyBundle = bundle
+	return mb
+}
+
 // WithAttachmentManager sets the attachment manager for this message
 func (mb *MessageBuilder) WithAttachmentManager(attManager *attachments.AttachmentManager) *MessageBuilder {
 	mb.attachmentManager = attManager
 	return mb
 }
 
-// AttachFile attaches a file to the message
-func (mb *MessageBuilder) AttachFile(filePath string) *MessageBuilder {
-	if mb.attachmentManager != nil {
-		attachment, err := mb.attachmentManager.CreateAttachmentFromFile(filePath)
-		if err == nil {
-			if mb.message.Attachments == nil {
-				mb.message.Attachments = make([]*attachments.Attachment, 0)
-			}
-			mb.message.Attachments = append(mb.message.Attachments, attachment)
-		}
+// MustAttach makes AttachFile and AttachData panic as soon as an attachment
+// fails, like regexp.MustCompile, for attachments whose failure is a bug, e.g.
+// files shipped with the app. By default failures are collected and make Build
+// fail instead.
+func (mb *MessageBuilder) MustAttach() *MessageBuilder {
+	mb.mustAttach = true
+	return mb
+}
+
+// attachFailed records an attachment failure for Build, or panics with it
+// under MustAttach
+func (mb *MessageBuilder) attachFailed(err error) *MessageBuilder {
+	if mb.mustAttach {
+		panic(err)
 	}
+	mb.attachErrs = append(mb.attachErrs, err)
 	return mb
 }
 
-// AttachData attaches raw data as an attachment to the message
+// AttachFile attaches a file to the message. A file that cannot be attached
+// makes Build fail, or panics under MustAttach.
+func (mb *MessageBuilder) AttachFile(filePath string) *MessageBuilder {
+	if mb.attachmentManager == nil {
+		return mb.attachFailed(fmt.Errorf("cannot attach %s: attachment manager not set", filePath))
+	}
+	attachment, err := mb.attachmentManager.CreateAttachmentFromFile(filePath)
+	if err != nil {
+		return mb.attachFailed(fmt.Errorf("failed to attach %s: %w", filePath, err))
+	}
+	if mb.attachPaths == nil {
+		mb.attachPaths = make(map[*attachments.Attachment]string)
+	}
+	mb.attachPaths[attachment] = filePath
+	return mb.Attachment(attachment)
+}
+
+// AttachData attaches raw data as an attachment to the message. Data that cannot
+// be attached makes Build fail, or panics under MustAttach.
 func (mb *MessageBuilder) AttachData(name string, data []byte, mimeType string) *MessageBuilder {
-	if mb.attachmentManager != nil {
-		attachment, err := mb.attachmentManager.CreateAttachmentFromData(name, data, mimeType)
-		if err == nil {
-			if mb.message.Attachments == nil {
-				mb.message.Attachments = make([]*attachments.Attachment, 0)
-			}
-			mb.message.Attachments = append(mb.message.Attachments, attachment)
-		}
+	if mb.attachmentManager == nil {
+		return mb.attachFailed(fmt.Errorf("cannot attach %s: attachment manager not set", name))
 	}
-	return mb
+	attachment, err := mb.attachmentManager.CreateAttachmentFromData(name, data, mimeType)
+	if err != nil {
+		return mb.attachFailed(fmt.Errorf("failed to attach %s: %w", name, err))
+	}
+	return mb.Attachment(attachment)
 }
 
 // Attachment adds an existing attachment to the message
@@ -169,18 +252,36 @@ func (mb *MessageBuilder) Attachment(attachment *attachments.Attachment) *Messag
 
 // Build validates and returns the constructed message
 func (mb *MessageBuilder) Build() (*Message, error) {
+	// Report attachments that were dropped rather than sending without them
+	if len(mb.attachErrs) > 0 {
+		return nil, &ValidationError{Field: "attachments", Err: errors.Join(mb.attachErrs...)}
+	}
+	if mb.encryptRequested && mb.encryptionManager == nil {
+		return nil, invalid("encrypted", "the draft asked for encryption but no encryption manager is set")
+	}
+	if err := mb.applyTTL(); err != nil {
+		return nil, err
+	}
+	if err := mb.applyContentType(); err != nil {
+		return nil, err
+	}
+
 	// Handle encryption if enabled
 	if mb.encryptionManager != nil && mb.message.Body != "" {
 		if err := mb.encryptMessage(); err != nil {
 			return nil, fmt.Errorf("encryption failed: %w", err)
 		}
+	} else if !mb.message.Encrypted {
+		mb.message.EncryptionDecision = &EncryptionDecision{FallbackReason: FallbackNotRequested}
 	}
 	if err := mb.validate(); err != nil {
 		return nil, err
 	}
 
 	// Generate message ID if not provided
-	if mb.message.MessageID == "" {
+	if mb.message.MessageID == "" && mb.idGenerator != nil {
+		mb.message.MessageID = mb.idGenerator.NewID()
+	} else if mb.message.MessageID == "" {
 		mb.message.MessageID = mb.generateMessageID()
 	}
 
@@ -189,27 +290,29 @@ func (mb *MessageBuilder) Build() (*Message, error) {
 	return &msg, nil
 }
 
-// encryptMessage encrypts the message body for all recipients
+// encryptMessage encrypts the message body for all recipients and records the
+// outcome in EncryptionDecision
 func (mb *MessageBuilder) encryptMessage() error {
 	if mb.encryptionManager == nil {
 		return fmt.Errorf("encryption manager not set")
 	}
 
 	// Check if we can encrypt for all recipients
-	allRecipients := append(mb.message.To, mb.message.CC...)
-	canEncryptForAll := true
-
+	allRecipients := append(append([]string(nil), mb.message.To...), mb.message.CC...)
+	var missing []string
 	for _, recipient := range allRecipients {
 		if !mb.encryptionManager.CanEncryptFor(recipient) {
-			canEncryptForAll = false
-			break
+			missing = append(missing, recipient)
 		}
 	}
 
-	// If we can't encrypt for all recipients, check fallback behavior
-	if !canEncryptForAll {
-		// For now, we'll just not encrypt if we can't encrypt for everyone
-		// In the future, this could be configurable
+	// Without a key for everyone the body is sent in plaintext rather than
+	// leaving some recipients unable to read it
+	if len(missing) > 0 {
+		mb.message.EncryptionDecision = &EncryptionDecision{
+			Uncovered:      allRecipients,
+			FallbackReason: FallbackMissingKeys,
+		}
 		return nil
 	}
 
@@ -230,8 +333,20 @@ func (mb *MessageBuilder) encryptMessage() error {
 
 		mb.message.Body = string(encryptedData)
 		mb.message.Encrypted = true
+		// The fallback would give away the body; recipients derive it after decrypting
+		mb.message.PlainBody = ""
 		publicKey := mb.encryptionManager.GetPublicKey()
 		mb.message.EncryptionKey = base64.StdEncoding.EncodeToString(publicKey[:])
+
+		decision := &EncryptionDecision{
+			Scheme:  encryption.Scheme,
+			Covered: allRecipients[:1],
+		}
+		if len(allRecipients) > 1 {
+			decision.Uncovered = allRecipients[1:]
+			decision.FallbackReason = FallbackSingleRecipientKey
+		}
+		mb.message.EncryptionDecision = decision
 	}
 
 	return nil
@@ -240,31 +355,31 @@ func (mb *MessageBuilder) encryptMessage() error {
 // validate validates the message structure
 func (mb *MessageBuilder) validate() error {
 	if mb.message.From == "" {
-		return fmt.Errorf("from address is required")
+		return invalid("from", "from address is required")
 	}
 
 	if !utils.IsValidEMSGAddress(mb.message.From) {
-		return fmt.Errorf("invalid from address: %s", mb.message.From)
+		return invalid("from", "invalid from address: %s", mb.message.From)
 	}
 
 	if len(mb.message.To) == 0 {
-		return fmt.Errorf("at least one recipient is required")
+		return invalid("to", "at least one recipient is required")
 	}
 
 	// Validate all recipient addresses
 	allRecipients := append(mb.message.To, mb.message.CC...)
 	if err := utils.ValidateEMSGAddressList(allRecipients); err != nil {
-		return fmt.Errorf("invalid recipient address: %w", err)
+		return invalid("to", "invalid recipient address: %w", err)
 	}
 
 	if mb.message.Body == "" {
-		return fmt.Errorf("message body is required")
+		return invalid("body", "message body is required")
 	}
 
 	// Validate system message if it's a system type
 	if strings.HasPrefix(mb.message.Type, "system:") {
 		if err := mb.validateSystemMessage(); err != nil {
-			return fmt.Errorf("invalid system message: %w", err)
+			return invalid("body", "invalid system message: %w", err)
 		}
 	}
 
@@ -323,11 +438,42 @@ func (msg *Message) Sign(keyPair *keymgmt.KeyPair) error {
 	return nil
 }
 
+// SignWithKeyRing signs the message with the key ring's current key and records
+// the key's identifier, which the signature covers
+func (msg *Message) SignWithKeyRing(keyRing *keymgmt.KeyRing) error {
+	msg.KeyID = keyRing.CurrentKeyID()
+	return msg.Sign(keyRing.Current())
+}
+
+// VerifyWithKeyRing verifies the signature against the ring key named by KeyID,
+// or against every key in the ring when the message carries no key ID
+func (msg *Message) VerifyWithKeyRing(keyRing *keymgmt.KeyRing) error {
+	if msg.Signature == "" {
+		return fmt.Errorf("message is not signed")
+	}
+
+	payload, err := msg.getSigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to create signing payload: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	if !keyRing.Verify(msg.KeyID, payload, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
 // getSigningPayload creates the payload for message signing
 func (msg *Message) getSigningPayload() ([]byte, error) {
-	// Create a copy without signature for signing
+	// Create a copy without signature for signing; labels change after sending
 	signingMsg := *msg
 	signingMsg.Signature = ""
+	signingMsg.Labels = nil
 
 	// Serialize to JSON for consistent signing
 	payload, err := json.Marshal(signingMsg)
@@ -388,36 +534,51 @@ func FromJSON(data []byte) (*Message, error) {
 // Validate validates the message structure
 func (msg *Message) Validate() error {
 	if msg.From == "" {
-		return fmt.Errorf("from address is required")
+		return invalid("from", "from address is required")
 	}
 
 	if !utils.IsValidEMSGAddress(msg.From) {
-		return fmt.Errorf("invalid from address: %s", msg.From)
+		return invalid("from", "invalid from address: %s", msg.From)
 	}
 
 	if len(msg.To) == 0 {
-		return fmt.Errorf("at least one recipient is required")
+		return invalid("to", "at least one recipient is required")
 	}
 
 	// Validate all recipient addresses
 	allRecipients := append(msg.To, msg.CC...)
 	if err := utils.ValidateEMSGAddressList(allRecipients); err != nil {
-		return fmt.Errorf("invalid recipient address: %w", err)
+		return invalid("to", "invalid recipient address: %w", err)
 	}
 
 	if msg.Body == "" {
-		return fmt.Errorf("message body is required")
+		return invalid("body", "message body is required")
 	}
 
 	if msg.Timestamp <= 0 {
-		return fmt.Errorf("invalid timestamp")
+		return invalid("timestamp", "invalid timestamp")
+	}
+	if msg.TimestampMs != 0 && msg.TimestampMs/1000 != msg.Timestamp {
+		return invalid("timestamp_ms", "timestamp_ms %d disagrees with timestamp %d", msg.TimestampMs, msg.Timestamp)
+	}
+	if msg.Sequence < 0 {
+		return invalid("sequence", "sequence must not be negative")
+	}
+	if msg.ExpiresAt != 0 && msg.ExpiresAt <= msg.Timestamp {
+		return invalid("expires_at", "expires_at %d must be after timestamp %d", msg.ExpiresAt, msg.Timestamp)
+	}
+	if media := msg.MediaType(); !strings.HasPrefix(media, "text/") {
+		return invalid("content_type", "content type %q is not a text type", msg.ContentType)
+	}
+	if msg.PlainBody != "" && !msg.IsRichText() {
+		return invalid("plain_body", "plain_body is only set for a rich content type")
 	}
 
 	// Validate system message if it's a system type
 	if msg.IsSystemMessage() {
 		_, err := msg.GetSystemMessage()
 		if err != nil {
-			return fmt.Errorf("invalid system message: %w", err)
+			return invalid("body", "invalid system message: %w", err)
 		}
 	}
 
@@ -437,6 +598,22 @@ func (msg *Message) IsSigned() bool {
 	return msg.Signature != ""
 }
 
+// IsNoteToSelf returns true if the message is addressed only to its sender and
+// not to a group, i.e. a note the user keeps for themselves
+func (msg *Message) IsNoteToSelf() bool {
+	if msg.From == "" || msg.GroupID != "" || len(msg.To) == 0 {
+		return false
+	}
+
+	self := utils.NormalizeEMSGAddress(msg.From)
+	for _, recipient := range msg.GetRecipients() {
+		if utils.NormalizeEMSGAddress(recipient) != self {
+			return false
+		}
+	}
+	return true
+}
+
 // Clone creates a deep copy of the message
 func (msg *Message) Clone() *Message {
 	clone := *msg
@@ -447,6 +624,9 @@ func (msg *Message) Clone() *Message {
 		clone.CC = make([]string, len(msg.CC))
 		copy(clone.CC, msg.CC)
 	}
+	if len(msg.Labels) > 0 {
+		clone.Labels = append([]string(nil), msg.Labels...)
+	}
 
 	return &clone
 }
@@ -613,6 +793,8 @@ func (msg *Message) DecryptBody(encManager *encryption.EncryptionManager) (strin
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt message: %w", err)
 	}
+	// The string is a copy; do not leave the plaintext buffer behind
+	defer utils.Wipe(decryptedBytes)
 
 	return string(decryptedBytes), nil
 }
@@ -632,6 +814,54 @@ func (msg *Message) GetDecryptedBody(encManager *encryption.EncryptionManager) s
 	return decryptedBody
 }
 
+// HasKeyBundle returns true if the message carries a sender key bundle
+func (msg *Message) HasKeyBundle() bool {
+	return msg.KeyBundle != nil
+}
+
+// VerifyKeyBundle checks that the key bundle names the sender and that the
+// message was signed with the bundle's signing key. The bundle only vouches for
+// itself, so trusting it on this check alone is trust on first use; check it
+// with VerifyKeyBundleWith before pinning its keys.
+func (msg *Message) VerifyKeyBundle() error {
+	if msg.KeyBundle == nil {
+		return fmt.Errorf("message has no key bundle")
+	}
+
+	if utils.NormalizeEMSGAddress(msg.KeyBundle.Address) != utils.NormalizeEMSGAddress(msg.From) {
+		return fmt.Errorf("key bundle address %s does not match sender %s", msg.KeyBundle.Address, msg.From)
+	}
+
+	if err := msg.Verify(msg.KeyBundle.SigningKey); err != nil {
+		return fmt.Errorf("key bundle signature check failed: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyKeyBundleWith checks the key bundle against the sender's signing key
+// obtained independently of the message, e.g. published on the sender's domain:
+// the bundle must name the sender and carry that key, and the message must be
+// signed with it
+func (msg *Message) VerifyKeyBundleWith(signingKey string) error {
+	if err := msg.VerifyKeyBundle(); err != nil {
+		return err
+	}
+
+	senderKey, err := keymgmt.LoadPublicKeyFromBase64(signingKey)
+	if err != nil {
+		return fmt.Errorf("invalid sender signing key: %w", err)
+	}
+	bundleKey, err := keymgmt.LoadPublicKeyFromBase64(msg.KeyBundle.SigningKey)
+	if err != nil {
+		return fmt.Errorf("invalid key bundle signing key: %w", err)
+	}
+	if !senderKey.Equal(bundleKey) {
+		return fmt.Errorf("key bundle signing key is not the signing key of %s", msg.From)
+	}
+	return nil
+}
+
 // HasAttachments returns true if the message has attachments
 func (msg *Message) HasAttachments() bool {
 	return len(msg.Attachments) > 0
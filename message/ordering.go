@@ -0,0 +1,98 @@
+package message
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SentAt returns when the sender composed the message, to the millisecond when
+// the sender recorded it
+func (msg *Message) SentAt() time.Time {
+	if msg.TimestampMs > 0 {
+		return time.UnixMilli(msg.TimestampMs)
+	}
+	return time.Unix(msg.Timestamp, 0)
+}
+
+// OrderingKey returns the key messages are ordered by: the sender's sequence
+// number, or the send time in milliseconds for senders that do not assign one
+func (msg *Message) OrderingKey() int64 {
+	if msg.Sequence > 0 {
+		return msg.Sequence
+	}
+	return msg.SentAt().UnixMilli()
+}
+
+// CompareOrder orders two messages by OrderingKey, breaking ties by sender and
+// then message ID so the order is the same on every device. It returns a
+// negative number if a comes first, a positive number if b does, and 0 if both
+// are the same message.
+func CompareOrder(a, b *Message) int {
+	if ka, kb := a.OrderingKey(), b.OrderingKey(); ka != kb {
+		if ka < kb {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case a.From < b.From:
+		return -1
+	case a.From > b.From:
+		return 1
+	case a.MessageID < b.MessageID:
+		return -1
+	case a.MessageID > b.MessageID:
+		return 1
+	}
+	return 0
+}
+
+// SortMessages sorts messages into conversation order, oldest first
+func SortMessages(messages []*Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return CompareOrder(messages[i], messages[j]) < 0
+	})
+}
+
+// SequenceClock assigns sequence numbers to outgoing messages. It is a hybrid
+// logical clock in milliseconds: each number is at least the current time and
+// greater than every number issued or observed before, so replies sort after
+// the messages they answer even when device clocks disagree.
+type SequenceClock struct {
+	mutex sync.Mutex
+	last  int64
+	now   func() time.Time
+}
+
+// NewSequenceClock creates a sequence clock reading the system time
+func NewSequenceClock() *SequenceClock {
+	return &SequenceClock{now: time.Now}
+}
+
+// Next returns the sequence number for a message sent now
+func (sc *SequenceClock) Next() int64 {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.last = max(sc.last+1, sc.now().UnixMilli())
+	return sc.last
+}
+
+// Observe advances the clock past a received message so that messages sent
+// afterwards are ordered after it
+func (sc *SequenceClock) Observe(msg *Message) {
+	key := msg.OrderingKey()
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.last = max(sc.last, key)
+}
+
+// Last returns the most recent sequence number issued or observed
+func (sc *SequenceClock) Last() int64 {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.last
+}
@@ -0,0 +1,32 @@
+package message
+
+// CorrelationID marks the message as a request whose replies carry the given ID
+func (mb *MessageBuilder) CorrelationID(correlationID string) *MessageBuilder {
+	mb.message.CorrelationID = correlationID
+	return mb
+}
+
+// InReplyTo sets the ID of the message this one answers
+func (mb *MessageBuilder) InReplyTo(messageID string) *MessageBuilder {
+	mb.message.InReplyTo = messageID
+	return mb
+}
+
+// ReplyTo addresses the message to request's sender and references the request,
+// copying its correlation ID so the requester can match the reply
+func (mb *MessageBuilder) ReplyTo(request *Message) *MessageBuilder {
+	mb.message.To = []string{request.From}
+	mb.message.InReplyTo = request.MessageID
+	mb.message.CorrelationID = request.CorrelationID
+	return mb
+}
+
+// IsRequest returns true if the message expects a correlated reply
+func (msg *Message) IsRequest() bool {
+	return msg.CorrelationID != "" && msg.InReplyTo == ""
+}
+
+// IsReply returns true if the message answers another message
+func (msg *Message) IsReply() bool {
+	return msg.InReplyTo != ""
+}
@@ -0,0 +1,47 @@
+package message
+
+import "regexp"
+
+// shortcodeText matches :shortcode: references to custom emoji in message text
+var shortcodeText = regexp.MustCompile(`:([a-z][a-z0-9_+-]{1,31}):`)
+
+// Sticker makes the message a group sticker, with the shortcode as its body for
+// clients that cannot show the sticker
+func (mb *MessageBuilder) Sticker(shortcode string) *MessageBuilder {
+	mb.message.Sticker = shortcode
+	mb.message.Body = ":" + shortcode + ":"
+	return mb
+}
+
+// IsSticker returns true if the message is a group sticker
+func (msg *Message) IsSticker() bool {
+	return msg.Sticker != ""
+}
+
+// Shortcodes returns the distinct custom emoji shortcodes the message uses, the
+// sticker first, then those written :shortcode: in the body in order of appearance
+func (msg *Message) Shortcodes() []string {
+	var shortcodes []string
+	seen := make(map[string]bool)
+	if msg.Sticker != "" {
+		shortcodes = append(shortcodes, msg.Sticker)
+		seen[msg.Sticker] = true
+	}
+	for _, shortcode := range ExtractShortcodes(msg.Body) {
+		if !seen[shortcode] {
+			seen[shortcode] = true
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+	return shortcodes
+}
+
+// ExtractShortcodes returns the shortcodes written :shortcode: in text, in order
+// of appearance and including repeats
+func ExtractShortcodes(text string) []string {
+	var shortcodes []string
+	for _, match := range shortcodeText.FindAllStringSubmatch(text, -1) {
+		shortcodes = append(shortcodes, match[1])
+	}
+	return shortcodes
+}
@@ -0,0 +1,80 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+)
+
+// VerificationStatus records whether a received message's signature was checked
+// against its sender's public key
+type VerificationStatus string
+
+const (
+	VerificationUnchecked      VerificationStatus = ""                // Not checked, e.g. verification is disabled
+	VerificationVerified       VerificationStatus = "verified"        // Signed by the sender's key
+	VerificationUnsigned       VerificationStatus = "unsigned"        // The message carries no signature
+	VerificationInvalid        VerificationStatus = "invalid"         // The signature does not match the sender's key
+	VerificationKeyUnavailable VerificationStatus = "key_unavailable" // The sender's key could not be resolved
+	VerificationKeyRevoked     VerificationStatus = "key_revoked"     // The sender's resolved key has been revoked
+)
+
+// ErrKeyRevoked is matched by key resolution errors for revoked keys
+var ErrKeyRevoked = errors.New("key revoked")
+
+// VerifySender checks the signature against the sender's public key and records
+// the outcome in VerificationStatus. A keyErr from resolving the key is recorded
+// as VerificationKeyUnavailable, or VerificationKeyRevoked if it matches
+// ErrKeyRevoked. A message carrying a delegation token verifies if
+// the token was issued by the sender's key, covers the message, and names the key
+// that signed it.
+func (msg *Message) VerifySender(publicKey string, keyErr error) VerificationStatus {
+	switch {
+	case !msg.IsSigned():
+		msg.VerificationStatus = VerificationUnsigned
+	case errors.Is(keyErr, ErrKeyRevoked):
+		msg.VerificationStatus = VerificationKeyRevoked
+	case keyErr != nil || publicKey == "":
+		msg.VerificationStatus = VerificationKeyUnavailable
+	case msg.Delegation != "" && msg.verifyDelegated(publicKey) != nil:
+		msg.VerificationStatus = VerificationInvalid
+	case msg.Delegation == "" && msg.Verify(publicKey) != nil:
+		msg.VerificationStatus = VerificationInvalid
+	default:
+		msg.VerificationStatus = VerificationVerified
+	}
+	return msg.VerificationStatus
+}
+
+// verifyDelegated checks a message signed by a delegate of the sender. The
+// token's validity is judged at the message's timestamp. The delegate sets the
+// timestamp, so for a token that expires it must be within
+// auth.DefaultMaxClockSkew of the verifier's clock; otherwise a delegate could
+// keep sending after the token expired by backdating its messages.
+func (msg *Message) verifyDelegated(publicKey string) error {
+	token, err := auth.ParseDelegationToken(msg.Delegation)
+	if err != nil {
+		return err
+	}
+	if err := token.Verify(publicKey); err != nil {
+		return err
+	}
+	sentAt := time.Unix(msg.Timestamp, 0)
+	if skew := time.Since(sentAt); token.ExpiresAt != 0 && (skew > auth.DefaultMaxClockSkew || skew < -auth.DefaultMaxClockSkew) {
+		return fmt.Errorf("message timestamp %d outside allowed clock skew of a limited delegation token", msg.Timestamp)
+	}
+	if !token.ValidAt(sentAt) {
+		return fmt.Errorf("delegation token not valid when the message was sent")
+	}
+	if err := token.Permits(msg.From, msg.GetRecipients()); err != nil {
+		return err
+	}
+	return msg.Verify(token.Delegate)
+}
+
+// IsVerified returns true if the message's signature was verified against its sender's key
+func (msg *Message) IsVerified() bool {
+	return msg.VerificationStatus == VerificationVerified
+}
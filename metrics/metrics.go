@@ -0,0 +1,36 @@
+package metrics
+
+// Labels qualify a metric sample, e.g. {"domain": "example.com"}
+type Labels map[string]string
+
+// Recorder receives the SDK's counters and histograms. Implementations must be
+// safe for concurrent use; adapters for other metrics systems implement it.
+type Recorder interface {
+	AddCounter(name string, labels Labels, delta float64)
+	ObserveHistogram(name string, labels Labels, value float64)
+}
+
+// Metrics recorded by the client
+const (
+	MessagesSent        = "emsg_messages_sent_total"           // Messages a recipient domain's server accepted; labels: domain
+	MessagesFailed      = "emsg_messages_failed_total"         // Messages a recipient domain's server did not accept; labels: domain
+	HTTPRetries         = "emsg_http_retries_total"            // HTTP attempts retried after failing; labels: domain
+	HTTPRequestDuration = "emsg_http_request_duration_seconds" // Histogram of HTTP attempt latency; labels: domain, method, code ("0" = no response)
+	WebSocketReconnects = "emsg_websocket_reconnects_total"    // WebSocket reconnection attempts
+	PollCycles          = "emsg_poll_cycles_total"             // Message fetches from a server; labels: result ("success" or "error")
+	AttachmentBytes     = "emsg_attachment_bytes_total"        // Attachment bytes in sent and fetched messages; labels: direction ("sent" or "received")
+)
+
+// help describes the client's metrics in the Prometheus exposition
+var help = map[string]string{
+	MessagesSent:        "Messages accepted by a recipient domain's server.",
+	MessagesFailed:      "Messages a recipient domain's server did not accept.",
+	HTTPRetries:         "HTTP attempts retried after failing.",
+	HTTPRequestDuration: "Latency of HTTP attempts to EMSG servers.",
+	WebSocketReconnects: "WebSocket reconnection attempts.",
+	PollCycles:          "Message fetches from EMSG servers.",
+	AttachmentBytes:     "Attachment bytes in sent and fetched messages.",
+}
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, used when none are given
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is an in-memory Recorder that serves what it records in the
+// Prometheus text exposition format. Mount it as an http.Handler, e.g. at
+// /metrics, to have Prometheus scrape it without any dependency.
+type Registry struct {
+	buckets    []float64
+	counters   map[string]map[string]*counter   // By name, then by encoded labels
+	histograms map[string]map[string]*histogram // By name, then by encoded labels
+	mutex      sync.Mutex
+}
+
+type counter struct {
+	labels Labels
+	value  float64
+}
+
+type histogram struct {
+	labels Labels
+	counts []uint64 // Observations in each bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewRegistry creates an empty registry whose histograms use buckets (nil = DefaultBuckets)
+func NewRegistry(buckets []float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Registry{
+		buckets:    slices.Compact(buckets),
+		counters:   make(map[string]map[string]*counter),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// AddCounter adds delta to a counter
+func (r *Registry) AddCounter(name string, labels Labels, delta float64) {
+	key := encodeLabels(labels)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	series := r.counters[name]
+	if series == nil {
+		series = make(map[string]*counter)
+		r.counters[name] = series
+	}
+	c := series[key]
+	if c == nil {
+		c = &counter{labels: cloneLabels(labels)}
+		series[key] = c
+	}
+	c.value += delta
+}
+
+// ObserveHistogram records a value in a histogram
+func (r *Registry) ObserveHistogram(name string, labels Labels, value float64) {
+	key := encodeLabels(labels)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	series := r.histograms[name]
+	if series == nil {
+		series = make(map[string]*histogram)
+		r.histograms[name] = series
+	}
+	h := series[key]
+	if h == nil {
+		h = &histogram{labels: cloneLabels(labels), counts: make([]uint64, len(r.buckets))}
+		series[key] = h
+	}
+	if i := sort.SearchFloat64s(r.buckets, value); i < len(r.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// Counter returns a counter's value, 0 if it was never added to
+func (r *Registry) Counter(name string, labels Labels) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c := r.counters[name][encodeLabels(labels)]; c != nil {
+		return c.value
+	}
+	return 0
+}
+
+// HistogramCount returns the number of values a histogram observed
+func (r *Registry) HistogramCount(name string, labels Labels) uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if h := r.histograms[name][encodeLabels(labels)]; h != nil {
+		return h.count
+	}
+	return 0
+}
+
+// ServeHTTP writes the registry in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the registry in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	for _, name := range sortedKeys(r.counters) {
+		writeHeader(out, name, "counter")
+		series := r.counters[name]
+		for _, key := range sortedKeys(series) {
+			fmt.Fprintf(out, "%s%s %s\n", name, key, formatFloat(series[key].value))
+		}
+	}
+	for _, name := range sortedKeys(r.histograms) {
+		writeHeader(out, name, "histogram")
+		series := r.histograms[name]
+		for _, key := range sortedKeys(series) {
+			h := series[key]
+			var cumulative uint64
+			for i, bound := range r.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(h.labels, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(h.labels, "le", "+Inf"), h.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", name, key, formatFloat(h.sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", name, key, h.count)
+		}
+	}
+	if err := out.w.Flush(); err != nil {
+		return out.n, err
+	}
+	return out.n, out.err
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, kind string) {
+	if text, ok := help[name]; ok {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, text)
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// encodeLabels renders labels as {a="1",b="2"} in name order, or "" if there are none
+func encodeLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range sortedKeys(labels) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel encodes labels with one more label added
+func withLabel(labels Labels, name, value string) string {
+	extended := cloneLabels(labels)
+	if extended == nil {
+		extended = make(Labels, 1)
+	}
+	extended[name] = value
+	return encodeLabels(extended)
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines in a label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats a sample value as Prometheus expects
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// cloneLabels copies labels so callers can reuse theirs
+func cloneLabels(labels Labels) Labels {
+	if labels == nil {
+		return nil
+	}
+	clone := make(Labels, len(labels))
+	for name, value := range labels {
+		clone[name] = value
+	}
+	return clone
+}
+
+// sortedKeys returns a map's keys in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts bytes written and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
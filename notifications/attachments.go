@@ -0,0 +1,92 @@
+package notifications
+
+import (
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+)
+
+// Attachment lifecycle events. Their metadata always carries attachment_id, name,
+// mime_type and size, plus the event-specific fields noted below.
+const (
+	// An upload to a server started (domain)
+	EventAttachmentUploadStarted NotificationEvent = "attachment_upload_started"
+	// An upload finished and the attachment can be sent by URL (domain, url)
+	EventAttachmentUploadCompleted NotificationEvent = "attachment_upload_completed"
+	// An upload failed (domain, error)
+	EventAttachmentUploadFailed NotificationEvent = "attachment_upload_failed"
+	// More of a download arrived (bytes_received, total_bytes, and percent when the total is known)
+	EventAttachmentDownloadProgress NotificationEvent = "attachment_download_progress"
+	// Attachment data did not match its recorded size or checksum (error)
+	EventAttachmentValidationFailed NotificationEvent = "attachment_validation_failed"
+	// The attachment scanner rejected an attachment, which can no longer be read (reason)
+	EventAttachmentQuarantined NotificationEvent = "attachment_quarantined"
+)
+
+// NotifyAttachmentUploadStarted reports the start of an attachment upload
+func (nm *NotificationManager) NotifyAttachmentUploadStarted(attachment *attachments.Attachment, domain string) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["domain"] = domain
+	return nm.notifyAttachment(EventAttachmentUploadStarted, metadata)
+}
+
+// NotifyAttachmentUploadCompleted reports a finished upload and the URL it is served from
+func (nm *NotificationManager) NotifyAttachmentUploadCompleted(attachment *attachments.Attachment, domain string) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["domain"] = domain
+	metadata["url"] = attachment.URL
+	return nm.notifyAttachment(EventAttachmentUploadCompleted, metadata)
+}
+
+// NotifyAttachmentUploadFailed reports a failed upload
+func (nm *NotificationManager) NotifyAttachmentUploadFailed(attachment *attachments.Attachment, domain string, err error) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["domain"] = domain
+	metadata["error"] = err.Error()
+	return nm.notifyAttachment(EventAttachmentUploadFailed, metadata)
+}
+
+// NotifyAttachmentDownloadProgress reports how much of a download has arrived.
+// A total of 0 means the size is unknown.
+func (nm *NotificationManager) NotifyAttachmentDownloadProgress(attachment *attachments.Attachment, received, total int64) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["bytes_received"] = received
+	metadata["total_bytes"] = total
+	if total > 0 {
+		metadata["percent"] = float64(received) * 100 / float64(total)
+	}
+	return nm.notifyAttachment(EventAttachmentDownloadProgress, metadata)
+}
+
+// NotifyAttachmentValidationFailed reports attachment data that failed its integrity check
+func (nm *NotificationManager) NotifyAttachmentValidationFailed(attachment *attachments.Attachment, err error) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["error"] = err.Error()
+	return nm.notifyAttachment(EventAttachmentValidationFailed, metadata)
+}
+
+// NotifyAttachmentQuarantined reports an attachment the scanner rejected
+func (nm *NotificationManager) NotifyAttachmentQuarantined(attachment *attachments.Attachment, reason error) error {
+	metadata := attachmentMetadata(attachment)
+	metadata["reason"] = reason.Error()
+	return nm.notifyAttachment(EventAttachmentQuarantined, metadata)
+}
+
+// notifyAttachment raises an attachment event
+func (nm *NotificationManager) notifyAttachment(event NotificationEvent, metadata map[string]any) error {
+	return nm.Notify(&Notification{
+		Event:     event,
+		Timestamp: time.Now().Unix(),
+		Metadata:  metadata,
+	})
+}
+
+// attachmentMetadata describes an attachment for event metadata
+func attachmentMetadata(attachment *attachments.Attachment) map[string]any {
+	return map[string]any{
+		"attachment_id": attachment.ID,
+		"name":          attachment.Name,
+		"mime_type":     attachment.MimeType,
+		"size":          attachment.Size,
+	}
+}
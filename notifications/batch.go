@@ -0,0 +1,273 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// EventDigest is emitted when repetitive events are coalesced into a single summary
+const EventDigest NotificationEvent = "digest"
+
+// BatchHandler is a function that handles a batch of notifications at once
+type BatchHandler func(batch []*Notification)
+
+// BatchConfig controls when a batch is delivered
+type BatchConfig struct {
+	MaxSize int           // Deliver once this many notifications are buffered
+	MaxWait time.Duration // Deliver this long after the first buffered notification
+}
+
+// DefaultBatchConfig returns a default batch configuration
+func DefaultBatchConfig() *BatchConfig {
+	return &BatchConfig{
+		MaxSize: 100,
+		MaxWait: 500 * time.Millisecond,
+	}
+}
+
+// DigestKeyFunc returns the key used to group notifications into a digest
+type DigestKeyFunc func(notification *Notification) string
+
+// DigestConfig controls how repetitive events are coalesced
+type DigestConfig struct {
+	Window  time.Duration // How long to collect events before emitting a digest
+	KeyFunc DigestKeyFunc // Groups events into separate digests (nil = by group_id)
+}
+
+// batcher buffers notifications for a single batch handler
+type batcher struct {
+	config  BatchConfig
+	handler BatchHandler
+	manager *NotificationManager
+	pending []*Notification
+	timer   *time.Timer
+	mutex   sync.Mutex
+}
+
+func newBatcher(config *BatchConfig, handler BatchHandler, manager *NotificationManager) *batcher {
+	if config == nil {
+		config = DefaultBatchConfig()
+	}
+	b := &batcher{config: *config, handler: handler, manager: manager}
+	if b.config.MaxSize <= 0 {
+		b.config.MaxSize = DefaultBatchConfig().MaxSize
+	}
+	return b
+}
+
+// add buffers a notification and delivers the batch if it is full
+func (b *batcher) add(notification *Notification) {
+	maxSize := b.config.MaxSize
+	if limit := b.manager.getMaxQueued(); limit > 0 && limit < maxSize {
+		maxSize = limit
+	}
+
+	b.mutex.Lock()
+	b.pending = append(b.pending, notification)
+
+	var batch []*Notification
+	if len(b.pending) >= maxSize {
+		batch = b.takeLocked()
+	} else if len(b.pending) == 1 && b.config.MaxWait > 0 {
+		b.timer = time.AfterFunc(b.config.MaxWait, b.flush)
+	}
+	b.mutex.Unlock()
+
+	b.deliver(batch)
+}
+
+// flush delivers whatever is currently buffered
+func (b *batcher) flush() {
+	b.mutex.Lock()
+	batch := b.takeLocked()
+	b.mutex.Unlock()
+
+	b.deliver(batch)
+}
+
+func (b *batcher) takeLocked() []*Notification {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+func (b *batcher) deliver(batch []*Notification) {
+	if len(batch) == 0 {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b.manager.getLogger().Error("batch notification handler panicked", "panic", r)
+			utils.ReportPanic(b.manager.getPanicHandler(), utils.PanicSubsystemNotificationBatch, r)
+		}
+	}()
+
+	b.handler(batch)
+}
+
+// digester coalesces notifications of one event type into digest notifications
+type digester struct {
+	event   NotificationEvent
+	config  DigestConfig
+	manager *NotificationManager
+	pending map[string][]*Notification
+	timers  map[string]*time.Timer
+	mutex   sync.Mutex
+}
+
+// add buffers a notification, starting the digest window for its key if needed.
+// A digest that reaches the manager's queue limit is delivered early.
+func (d *digester) add(notification *Notification) {
+	key := d.config.KeyFunc(notification)
+	limit := d.manager.getMaxQueued()
+
+	d.mutex.Lock()
+	if _, exists := d.pending[key]; !exists {
+		d.timers[key] = time.AfterFunc(d.config.Window, func() { d.emit(key) })
+	}
+	d.pending[key] = append(d.pending[key], notification)
+	full := limit > 0 && len(d.pending[key]) >= limit
+	d.mutex.Unlock()
+
+	if full {
+		d.emit(key)
+	}
+}
+
+// emit dispatches the buffered notifications for a key, summarizing them if there is more than one
+func (d *digester) emit(key string) {
+	d.mutex.Lock()
+	items := d.pending[key]
+	delete(d.pending, key)
+	if timer := d.timers[key]; timer != nil {
+		timer.Stop()
+		delete(d.timers, key)
+	}
+	d.mutex.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+
+	var notification *Notification
+	if len(items) == 1 {
+		notification = items[0]
+	} else {
+		notification = &Notification{
+			Event:     EventDigest,
+			Timestamp: time.Now().Unix(),
+			Metadata: map[string]any{
+				"digest_event":  d.event,
+				"digest_key":    key,
+				"count":         len(items),
+				"first_at":      items[0].Timestamp,
+				"last_at":       items[len(items)-1].Timestamp,
+				"notifications": items,
+			},
+		}
+	}
+
+	if err := d.manager.dispatch(context.Background(), notification); err != nil {
+		d.manager.getLogger().Warn("digest notification handler failed", "event", d.event, "error", err)
+	}
+}
+
+// emitAll dispatches every pending digest immediately
+func (d *digester) emitAll() {
+	d.mutex.Lock()
+	keys := make([]string, 0, len(d.pending))
+	for key := range d.pending {
+		keys = append(keys, key)
+	}
+	d.mutex.Unlock()
+
+	for _, key := range keys {
+		d.emit(key)
+	}
+}
+
+// defaultDigestKey groups notifications by their group_id metadata
+func defaultDigestKey(notification *Notification) string {
+	if groupID, ok := notification.Metadata["group_id"].(string); ok {
+		return groupID
+	}
+	return ""
+}
+
+// RegisterBatchHandler registers a handler that receives notifications for an
+// event in batches instead of one call per notification
+func (nm *NotificationManager) RegisterBatchHandler(event NotificationEvent, config *BatchConfig, handler BatchHandler) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	nm.batchers[event] = append(nm.batchers[event], newBatcher(config, handler, nm))
+}
+
+// EnableDigest coalesces notifications for an event that arrive within the
+// configured window. A lone event is delivered unchanged; two or more are
+// delivered as a single EventDigest notification whose metadata holds the
+// count and the original notifications.
+func (nm *NotificationManager) EnableDigest(event NotificationEvent, config *DigestConfig) {
+	digestConfig := DigestConfig{Window: time.Second, KeyFunc: defaultDigestKey}
+	if config != nil {
+		if config.Window > 0 {
+			digestConfig.Window = config.Window
+		}
+		if config.KeyFunc != nil {
+			digestConfig.KeyFunc = config.KeyFunc
+		}
+	}
+
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	nm.digesters[event] = &digester{
+		event:   event,
+		config:  digestConfig,
+		manager: nm,
+		pending: make(map[string][]*Notification),
+		timers:  make(map[string]*time.Timer),
+	}
+}
+
+// DisableDigest stops coalescing an event, emitting any pending digest first
+func (nm *NotificationManager) DisableDigest(event NotificationEvent) {
+	nm.mutex.Lock()
+	d := nm.digesters[event]
+	delete(nm.digesters, event)
+	nm.mutex.Unlock()
+
+	if d != nil {
+		d.emitAll()
+	}
+}
+
+// Flush immediately delivers all pending digests and batches
+func (nm *NotificationManager) Flush() {
+	nm.mutex.RLock()
+	digesters := make([]*digester, 0, len(nm.digesters))
+	for _, d := range nm.digesters {
+		digesters = append(digesters, d)
+	}
+	batchers := make([]*batcher, 0)
+	for _, eventBatchers := range nm.batchers {
+		batchers = append(batchers, eventBatchers...)
+	}
+	nm.mutex.RUnlock()
+
+	// Digests first, since emitting them may feed batch handlers
+	for _, d := range digesters {
+		d.emitAll()
+	}
+	for _, b := range batchers {
+		b.flush()
+	}
+}
@@ -0,0 +1,31 @@
+package notifications
+
+import "time"
+
+// EventOnline reports that the client is back online (offline_for, the seconds it was offline)
+const EventOnline NotificationEvent = "online"
+
+// EventOffline reports that the client went offline and queues sends (reason)
+const EventOffline NotificationEvent = "offline"
+
+// NotifyOnline is a convenience method for the client coming back online
+func (nm *NotificationManager) NotifyOnline(offlineFor time.Duration) error {
+	return nm.Notify(&Notification{
+		Event:     EventOnline,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"offline_for": offlineFor.Seconds(),
+		},
+	})
+}
+
+// NotifyOffline is a convenience method for the client going offline
+func (nm *NotificationManager) NotifyOffline(reason string) error {
+	return nm.Notify(&Notification{
+		Event:     EventOffline,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"reason": reason,
+		},
+	})
+}
@@ -0,0 +1,150 @@
+package notifications
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// EventMessageSilenced is raised instead of EventMessageReceived for a message in a
+// group with keyword subscriptions when it matches none of them. The message is
+// still stored; applications should update unread counts without alerting the user.
+const EventMessageSilenced NotificationEvent = "message_silenced"
+
+// MaxKeywordLength is the longest keyword that can be subscribed to
+const MaxKeywordLength = 64
+
+// NormalizeKeyword lowercases and trims a keyword and checks that it is a single
+// word or hashtag, e.g. "release" or "#release"
+func NormalizeKeyword(keyword string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(keyword))
+	word := strings.TrimPrefix(normalized, "#")
+	if word == "" {
+		return "", fmt.Errorf("keyword cannot be empty")
+	}
+	if len(normalized) > MaxKeywordLength {
+		return "", fmt.Errorf("keyword %q exceeds %d characters", keyword, MaxKeywordLength)
+	}
+	for _, r := range word {
+		if !isKeywordRune(r) {
+			return "", fmt.Errorf("keyword %q must be a single word or hashtag", keyword)
+		}
+	}
+	return normalized, nil
+}
+
+// SubscribeKeywords subscribes to keywords in a group. Once a group has
+// subscriptions, only its messages that contain one of them raise
+// EventMessageReceived; the rest raise EventMessageSilenced. A plain keyword
+// matches the word or its hashtag, while a hashtag matches only the hashtag.
+func (nm *NotificationManager) SubscribeKeywords(groupID string, keywords ...string) error {
+	if groupID == "" {
+		return fmt.Errorf("group ID cannot be empty")
+	}
+
+	normalized := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		k, err := NormalizeKeyword(keyword)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, k)
+	}
+
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	if nm.keywords[groupID] == nil {
+		nm.keywords[groupID] = make(map[string]bool)
+	}
+	for _, k := range normalized {
+		nm.keywords[groupID][k] = true
+	}
+	return nil
+}
+
+// UnsubscribeKeywords removes keywords from a group's subscriptions. The group's
+// messages notify normally again once none remain.
+func (nm *NotificationManager) UnsubscribeKeywords(groupID string, keywords ...string) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	for _, keyword := range keywords {
+		if k, err := NormalizeKeyword(keyword); err == nil {
+			delete(nm.keywords[groupID], k)
+		}
+	}
+	if len(nm.keywords[groupID]) == 0 {
+		delete(nm.keywords, groupID)
+	}
+}
+
+// ClearKeywords removes all keyword subscriptions for a group
+func (nm *NotificationManager) ClearKeywords(groupID string) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	delete(nm.keywords, groupID)
+}
+
+// Keywords returns a group's subscribed keywords in sorted order
+func (nm *NotificationManager) Keywords(groupID string) []string {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+
+	keywords := make([]string, 0, len(nm.keywords[groupID]))
+	for k := range nm.keywords[groupID] {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// matchKeywords returns the subscribed keywords a group message contains and
+// whether the message is subject to keyword filtering at all. System messages and
+// encrypted bodies, which cannot be searched, are never filtered.
+func (nm *NotificationManager) matchKeywords(msg *message.Message) ([]string, bool) {
+	if msg.GroupID == "" || msg.IsSystemMessage() || msg.IsEncrypted() {
+		return nil, false
+	}
+
+	nm.mutex.RLock()
+	subscribed := nm.keywords[msg.GroupID]
+	if len(subscribed) == 0 {
+		nm.mutex.RUnlock()
+		return nil, false
+	}
+	keywords := make([]string, 0, len(subscribed))
+	for k := range subscribed {
+		keywords = append(keywords, k)
+	}
+	nm.mutex.RUnlock()
+
+	tokens := make(map[string]bool)
+	for _, text := range []string{msg.Subject, msg.Body} {
+		for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return r != '#' && !isKeywordRune(r)
+		}) {
+			tokens[token] = true
+			// "#release" also counts as the plain word for plain keywords
+			tokens[strings.TrimLeft(token, "#")] = true
+		}
+	}
+
+	var matched []string
+	for _, k := range keywords {
+		if tokens[k] {
+			matched = append(matched, k)
+		}
+	}
+	sort.Strings(matched)
+	return matched, true
+}
+
+// isKeywordRune reports whether r can appear in a keyword
+func isKeywordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
@@ -0,0 +1,19 @@
+package notifications
+
+import "time"
+
+// EventLabelsChanged reports a message's labels after they changed on the server (user, message_id, labels)
+const EventLabelsChanged NotificationEvent = "labels_changed"
+
+// NotifyLabelsChanged is a convenience method for label change notifications
+func (nm *NotificationManager) NotifyLabelsChanged(userAddress, messageID string, labels []string) error {
+	return nm.Notify(&Notification{
+		Event:     EventLabelsChanged,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"user":       userAddress,
+			"message_id": messageID,
+			"labels":     labels,
+		},
+	})
+}
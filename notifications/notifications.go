@@ -3,11 +3,12 @@ package notifications
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 // NotificationEvent represents different types of notification events
@@ -20,6 +21,12 @@ const (
 	EventUserLeft        NotificationEvent = "user_left"
 	EventTyping          NotificationEvent = "typing"
 	EventDeliveryReceipt NotificationEvent = "delivery_receipt"
+	// A previously undecryptable message became readable after a key change
+	EventMessageDecrypted NotificationEvent = "message_decrypted"
+	// A guest message is waiting for moderator approval
+	EventModerationRequested NotificationEvent = "moderation_requested"
+	// Transport selection started or stopped avoiding a path because of its failure rate
+	EventTransportDiagnostics NotificationEvent = "transport_diagnostics"
 )
 
 // Notification represents a notification with metadata
@@ -36,14 +43,29 @@ type NotificationHandler func(notification *Notification) error
 // AsyncNotificationHandler is a function that handles notifications asynchronously
 type AsyncNotificationHandler func(notification *Notification)
 
+// ContextNotificationHandler handles notifications with the context of the
+// operation that raised them, so it can respect cancellation and read trace values
+type ContextNotificationHandler func(ctx context.Context, notification *Notification) error
+
+// ContextAsyncNotificationHandler handles notifications asynchronously. Its context
+// carries the raising operation's values but is not cancelled when that operation ends.
+type ContextAsyncNotificationHandler func(ctx context.Context, notification *Notification)
+
 // NotificationManager manages notification hooks and delivery
 type NotificationManager struct {
-	handlers      map[NotificationEvent][]NotificationHandler
-	asyncHandlers map[NotificationEvent][]AsyncNotificationHandler
+	handlers      map[NotificationEvent][]ContextNotificationHandler
+	asyncHandlers map[NotificationEvent][]ContextAsyncNotificationHandler
+	batchers      map[NotificationEvent][]*batcher
+	digesters     map[NotificationEvent]*digester
+	keywords      map[string]map[string]bool // Subscribed keywords by group ID
 	mutex         sync.RWMutex
 	ctx           context.Context
 	cancel        context.CancelFunc
 	workerPool    chan struct{} // Limits concurrent async handlers
+	maxQueued     int           // Limits queued async calls and buffered batch and digest notifications (0 = unlimited)
+	queued        atomic.Int64  // Async handler calls waiting for or holding a worker
+	logger        utils.Logger
+	panicHandler  utils.PanicHandler
 }
 
 // NewNotificationManager creates a new notification manager
@@ -51,27 +73,92 @@ func NewNotificationManager(maxConcurrentHandlers int) *NotificationManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	return &NotificationManager{
-		handlers:      make(map[NotificationEvent][]NotificationHandler),
-		asyncHandlers: make(map[NotificationEvent][]AsyncNotificationHandler),
+		handlers:      make(map[NotificationEvent][]ContextNotificationHandler),
+		asyncHandlers: make(map[NotificationEvent][]ContextAsyncNotificationHandler),
+		batchers:      make(map[NotificationEvent][]*batcher),
+		digesters:     make(map[NotificationEvent]*digester),
+		keywords:      make(map[string]map[string]bool),
 		ctx:           ctx,
 		cancel:        cancel,
 		workerPool:    make(chan struct{}, maxConcurrentHandlers),
+		logger:        utils.NopLogger{},
 	}
 }
 
+// SetLogger sets the logger for handler failures (nil discards them)
+func (nm *NotificationManager) SetLogger(logger utils.Logger) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	nm.logger = utils.LoggerOrNop(logger)
+}
+
+// SetPanicHandler sets the hook that receives panics recovered from async and batch handlers
+func (nm *NotificationManager) SetPanicHandler(handler utils.PanicHandler) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	nm.panicHandler = handler
+}
+
+// SetMaxQueued bounds the notifications held in memory: async handler calls
+// waiting for a worker, and notifications buffered per batch handler or digest.
+// Async calls beyond the limit are dropped and logged, while batches and digests
+// that reach it are delivered early. Zero removes the limit.
+func (nm *NotificationManager) SetMaxQueued(maxQueued int) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	nm.maxQueued = maxQueued
+}
+
+// getMaxQueued returns the current queue limit
+func (nm *NotificationManager) getMaxQueued() int {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+	return nm.maxQueued
+}
+
+// getPanicHandler returns the current panic hook
+func (nm *NotificationManager) getPanicHandler() utils.PanicHandler {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+	return nm.panicHandler
+}
+
+// getLogger returns the current logger
+func (nm *NotificationManager) getLogger() utils.Logger {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+	return nm.logger
+}
+
 // RegisterHandler registers a synchronous notification handler
 func (nm *NotificationManager) RegisterHandler(event NotificationEvent, handler NotificationHandler) {
+	nm.RegisterContextHandler(event, func(ctx context.Context, notification *Notification) error {
+		return handler(notification)
+	})
+}
+
+// RegisterContextHandler registers a synchronous notification handler that receives
+// the raising operation's context
+func (nm *NotificationManager) RegisterContextHandler(event NotificationEvent, handler ContextNotificationHandler) {
 	nm.mutex.Lock()
 	defer nm.mutex.Unlock()
-	
+
 	nm.handlers[event] = append(nm.handlers[event], handler)
 }
 
 // RegisterAsyncHandler registers an asynchronous notification handler
 func (nm *NotificationManager) RegisterAsyncHandler(event NotificationEvent, handler AsyncNotificationHandler) {
+	nm.RegisterContextAsyncHandler(event, func(ctx context.Context, notification *Notification) {
+		handler(notification)
+	})
+}
+
+// RegisterContextAsyncHandler registers an asynchronous notification handler that
+// receives the raising operation's context values
+func (nm *NotificationManager) RegisterContextAsyncHandler(event NotificationEvent, handler ContextAsyncNotificationHandler) {
 	nm.mutex.Lock()
 	defer nm.mutex.Unlock()
-	
+
 	nm.asyncHandlers[event] = append(nm.asyncHandlers[event], handler)
 }
 
@@ -82,53 +169,101 @@ func (nm *NotificationManager) UnregisterHandlers(event NotificationEvent) {
 	
 	delete(nm.handlers, event)
 	delete(nm.asyncHandlers, event)
+	delete(nm.batchers, event)
 }
 
-// Notify sends a notification to all registered handlers
+// Notify sends a notification to all registered handlers. If a digest is
+// enabled for the event, delivery is deferred until the digest window closes.
 func (nm *NotificationManager) Notify(notification *Notification) error {
+	return nm.NotifyContext(context.Background(), notification)
+}
+
+// NotifyContext sends a notification to all registered handlers with ctx.
+// Synchronous handlers are skipped once ctx is done. Digested notifications
+// are delivered later without ctx.
+func (nm *NotificationManager) NotifyContext(ctx context.Context, notification *Notification) error {
+	nm.mutex.RLock()
+	d := nm.digesters[notification.Event]
+	nm.mutex.RUnlock()
+
+	if d != nil {
+		d.add(notification)
+		return nil
+	}
+
+	return nm.dispatch(ctx, notification)
+}
+
+// dispatch delivers a notification to its synchronous, asynchronous and batch handlers
+func (nm *NotificationManager) dispatch(ctx context.Context, notification *Notification) error {
 	nm.mutex.RLock()
 	syncHandlers := nm.handlers[notification.Event]
 	asyncHandlers := nm.asyncHandlers[notification.Event]
+	batchers := nm.batchers[notification.Event]
+	logger := nm.logger
+	maxQueued := nm.maxQueued
 	nm.mutex.RUnlock()
 
 	// Execute synchronous handlers first
 	for _, handler := range syncHandlers {
-		if err := handler(notification); err != nil {
-			log.Printf("Synchronous notification handler error: %v", err)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("notification cancelled: %w", err)
+		}
+		if err := handler(ctx, notification); err != nil {
+			logger.Warn("synchronous notification handler failed", "event", notification.Event, "error", err)
 			return fmt.Errorf("notification handler failed: %w", err)
 		}
 	}
 
-	// Execute asynchronous handlers
+	// Execute asynchronous handlers, which outlive the raising operation
+	asyncCtx := context.WithoutCancel(ctx)
 	for _, handler := range asyncHandlers {
-		go nm.executeAsyncHandler(handler, notification)
+		if n := nm.queued.Add(1); maxQueued > 0 && n > int64(maxQueued) {
+			nm.queued.Add(-1)
+			logger.Warn("notification queue full, dropping async handler call", "event", notification.Event, "limit", maxQueued)
+			continue
+		}
+		go nm.executeAsyncHandler(asyncCtx, handler, notification)
+	}
+
+	// Buffer for batch handlers
+	for _, b := range batchers {
+		b.add(notification)
 	}
 
 	return nil
 }
 
 // executeAsyncHandler executes an async handler with worker pool limiting
-func (nm *NotificationManager) executeAsyncHandler(handler AsyncNotificationHandler, notification *Notification) {
+func (nm *NotificationManager) executeAsyncHandler(ctx context.Context, handler ContextAsyncNotificationHandler, notification *Notification) {
+	defer nm.queued.Add(-1)
+
 	select {
 	case nm.workerPool <- struct{}{}: // Acquire worker slot
 		defer func() { <-nm.workerPool }() // Release worker slot
 		
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Async notification handler panicked: %v", r)
+				nm.getLogger().Error("async notification handler panicked", "event", notification.Event, "panic", r)
+				utils.ReportPanic(nm.getPanicHandler(), utils.PanicSubsystemNotificationHandler, r)
 			}
 		}()
 		
-		handler(notification)
+		handler(ctx, notification)
 		
 	case <-nm.ctx.Done():
-		log.Printf("Notification manager shutting down, skipping async handler")
+		nm.getLogger().Debug("notification manager shutting down, skipping async handler", "event", notification.Event)
 		return
 	}
 }
 
 // NotifyMessageReceived is a convenience method for message received notifications
 func (nm *NotificationManager) NotifyMessageReceived(msg *message.Message) error {
+	return nm.NotifyMessageReceivedContext(context.Background(), msg)
+}
+
+// NotifyMessageReceivedContext is NotifyMessageReceived with the receiving operation's context
+func (nm *NotificationManager) NotifyMessageReceivedContext(ctx context.Context, msg *message.Message) error {
 	notification := &Notification{
 		Event:     EventMessageReceived,
 		Message:   msg,
@@ -148,12 +283,26 @@ func (nm *NotificationManager) NotifyMessageReceived(msg *message.Message) error
 	if msg.IsEncrypted() {
 		notification.Metadata["is_encrypted"] = true
 	}
+
+	// Group messages matching none of the subscribed keywords arrive silently
+	if matched, filtered := nm.matchKeywords(msg); filtered {
+		if len(matched) == 0 {
+			notification.Event = EventMessageSilenced
+		} else {
+			notification.Metadata["matched_keywords"] = matched
+		}
+	}
 	
-	return nm.Notify(notification)
+	return nm.NotifyContext(ctx, notification)
 }
 
 // NotifyMessageSent is a convenience method for message sent notifications
 func (nm *NotificationManager) NotifyMessageSent(msg *message.Message) error {
+	return nm.NotifyMessageSentContext(context.Background(), msg)
+}
+
+// NotifyMessageSentContext is NotifyMessageSent with the sending operation's context
+func (nm *NotificationManager) NotifyMessageSentContext(ctx context.Context, msg *message.Message) error {
 	notification := &Notification{
 		Event:     EventMessageSent,
 		Message:   msg,
@@ -163,7 +312,7 @@ func (nm *NotificationManager) NotifyMessageSent(msg *message.Message) error {
 		},
 	}
 	
-	return nm.Notify(notification)
+	return nm.NotifyContext(ctx, notification)
 }
 
 // NotifyUserJoined is a convenience method for user joined notifications
@@ -224,8 +373,54 @@ func (nm *NotificationManager) NotifyDeliveryReceipt(messageID, recipientAddress
 	return nm.Notify(notification)
 }
 
-// Shutdown gracefully shuts down the notification manager
+// NotifyMessageDecrypted is a convenience method for notifications about messages
+// that could be decrypted after previously failing
+func (nm *NotificationManager) NotifyMessageDecrypted(msg *message.Message, body string, attempts int) error {
+	notification := &Notification{
+		Event:     EventMessageDecrypted,
+		Message:   msg,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"decrypted_body": body,
+			"attempts":       attempts,
+		},
+	}
+
+	return nm.Notify(notification)
+}
+
+// NotifyModerationRequested is a convenience method for notifications about guest
+// messages held for moderator approval
+func (nm *NotificationManager) NotifyModerationRequested(msg *message.Message, groupID string, moderators []string) error {
+	notification := &Notification{
+		Event:     EventModerationRequested,
+		Message:   msg,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"group_id":   groupID,
+			"author":     msg.From,
+			"moderators": moderators,
+		},
+	}
+
+	return nm.Notify(notification)
+}
+
+// NotifyTransportDiagnostics reports a change in transport selection along with
+// the failure rates that caused it
+func (nm *NotificationManager) NotifyTransportDiagnostics(metadata map[string]any) error {
+	notification := &Notification{
+		Event:     EventTransportDiagnostics,
+		Timestamp: time.Now().Unix(),
+		Metadata:  metadata,
+	}
+
+	return nm.Notify(notification)
+}
+
+// Shutdown gracefully shuts down the notification manager, delivering any pending digests and batches
 func (nm *NotificationManager) Shutdown() {
+	nm.Flush()
 	nm.cancel()
 }
 
@@ -234,7 +429,7 @@ func (nm *NotificationManager) GetHandlerCount(event NotificationEvent) int {
 	nm.mutex.RLock()
 	defer nm.mutex.RUnlock()
 	
-	return len(nm.handlers[event]) + len(nm.asyncHandlers[event])
+	return len(nm.handlers[event]) + len(nm.asyncHandlers[event]) + len(nm.batchers[event])
 }
 
 // MessagePoller polls for new messages and triggers notifications
@@ -243,9 +438,14 @@ type MessagePoller struct {
 	notificationManager *NotificationManager
 	pollInterval        time.Duration
 	lastPollTime        time.Time
-	ctx                 context.Context
+	clock               utils.Clock
 	cancel              context.CancelFunc
+	done                chan struct{}
+	wake                chan struct{} // Triggers a poll without waiting for the next tick
+	wg                  sync.WaitGroup
 	running             bool
+	userAddress         string // Address polled by the current or last run
+	err                 error  // Why the last run ended, or nil if it was stopped
 	mutex               sync.Mutex
 }
 
@@ -254,44 +454,141 @@ type MessageClient interface {
 	GetMessages(address string) ([]*message.Message, error)
 }
 
+// contextMessageClient is implemented by clients whose fetches can be cancelled
+// when the poller stops
+type contextMessageClient interface {
+	GetMessagesContext(ctx context.Context, address string) ([]*message.Message, error)
+}
+
 // NewMessagePoller creates a new message poller
 func NewMessagePoller(client MessageClient, notificationManager *NotificationManager, pollInterval time.Duration) *MessagePoller {
-	ctx, cancel := context.WithCancel(context.Background())
-	
+	done := make(chan struct{})
+	close(done) // Not running yet
+
 	return &MessagePoller{
 		client:              client,
 		notificationManager: notificationManager,
 		pollInterval:        pollInterval,
+		clock:               utils.RealClock{},
 		lastPollTime:        time.Now(),
-		ctx:                 ctx,
-		cancel:              cancel,
+		done:                done,
+		wake:                make(chan struct{}, 1),
 	}
 }
 
+// SetClock replaces the clock used for ticking and timestamp filtering.
+// It must be called before Start.
+func (mp *MessagePoller) SetClock(clock utils.Clock) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.clock = clock
+	mp.lastPollTime = clock.Now()
+}
+
 // Start starts the message polling
 func (mp *MessagePoller) Start(userAddress string) error {
 	mp.mutex.Lock()
 	defer mp.mutex.Unlock()
-	
+
 	if mp.running {
 		return fmt.Errorf("message poller is already running")
 	}
-	
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mp.cancel = cancel
+	mp.done = make(chan struct{})
 	mp.running = true
-	go mp.pollLoop(userAddress)
-	
+	mp.userAddress = userAddress
+	mp.err = nil
+
+	// Create the ticker before returning so clock advances made right after
+	// Start are never missed
+	ticker := mp.clock.NewTicker(mp.pollInterval)
+
+	mp.wg.Add(1)
+	go mp.pollLoop(ctx, ticker, userAddress)
+
+	done := mp.done
+	go func() {
+		mp.wg.Wait()
+		close(done)
+	}()
+
 	return nil
 }
 
-// Stop stops the message polling
+// Stop stops the message polling and blocks until the poll loop has exited
 func (mp *MessagePoller) Stop() {
+	mp.mutex.Lock()
+	if !mp.running {
+		mp.mutex.Unlock()
+		return
+	}
+	mp.cancel()
+	mp.running = false
+	done := mp.done
+	mp.mutex.Unlock()
+
+	<-done
+}
+
+// Restart starts polling again for the address of the last run, e.g. after the
+// poll loop failed
+func (mp *MessagePoller) Restart() error {
+	mp.mutex.Lock()
+	userAddress := mp.userAddress
+	mp.mutex.Unlock()
+
+	if userAddress == "" {
+		return fmt.Errorf("message poller was never started")
+	}
+	return mp.Start(userAddress)
+}
+
+// PollNow makes a running poller fetch messages without waiting for the next
+// tick, e.g. after the system woke from sleep and ticks were missed
+func (mp *MessagePoller) PollNow() {
+	select {
+	case mp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Address returns the address polled by the current or last run, or "" if the
+// poller was never started
+func (mp *MessagePoller) Address() string {
 	mp.mutex.Lock()
 	defer mp.mutex.Unlock()
-	
-	if mp.running {
-		mp.cancel()
-		mp.running = false
+	return mp.userAddress
+}
+
+// Err returns the error that ended the last run, or nil if the poller is running
+// or was stopped with Stop
+func (mp *MessagePoller) Err() error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return mp.err
+}
+
+// fail stops the current run after an unrecoverable error in the poll loop
+func (mp *MessagePoller) fail(err error) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	if !mp.running {
+		return
 	}
+	mp.cancel()
+	mp.running = false
+	mp.err = err
+}
+
+// Done returns a channel that is closed once the poll loop has fully exited
+func (mp *MessagePoller) Done() <-chan struct{} {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return mp.done
 }
 
 // IsRunning returns true if the poller is running
@@ -302,43 +599,63 @@ func (mp *MessagePoller) IsRunning() bool {
 }
 
 // pollLoop is the main polling loop
-func (mp *MessagePoller) pollLoop(userAddress string) {
-	ticker := time.NewTicker(mp.pollInterval)
+func (mp *MessagePoller) pollLoop(ctx context.Context, ticker utils.Ticker, userAddress string) {
+	defer mp.wg.Done()
 	defer ticker.Stop()
-	
+	defer func() {
+		if r := recover(); r != nil {
+			mp.notificationManager.getLogger().Error("message poll loop panicked", "address", userAddress, "panic", r)
+			utils.ReportPanic(mp.notificationManager.getPanicHandler(), utils.PanicSubsystemMessagePoller, r)
+			mp.fail(fmt.Errorf("poll loop panicked: %v", r))
+		}
+	}()
+
 	for {
 		select {
-		case <-ticker.C:
-			mp.pollMessages(userAddress)
-		case <-mp.ctx.Done():
+		case <-ticker.C():
+			mp.pollMessages(ctx, userAddress)
+		case <-mp.wake:
+			mp.pollMessages(ctx, userAddress)
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
 // pollMessages polls for new messages and triggers notifications
-func (mp *MessagePoller) pollMessages(userAddress string) {
-	messages, err := mp.client.GetMessages(userAddress)
+func (mp *MessagePoller) pollMessages(ctx context.Context, userAddress string) {
+	var messages []*message.Message
+	var err error
+	if client, ok := mp.client.(contextMessageClient); ok {
+		messages, err = client.GetMessagesContext(ctx, userAddress)
+	} else {
+		messages, err = mp.client.GetMessages(userAddress)
+	}
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to poll messages: %v", err)
+		mp.notificationManager.getLogger().Warn("failed to poll messages", "address", userAddress, "error", err)
 		return
 	}
-	
+
+	mp.mutex.Lock()
+	since := mp.lastPollTime
+	mp.lastPollTime = mp.clock.Now()
+	mp.mutex.Unlock()
+
 	// Filter messages received since last poll
 	newMessages := make([]*message.Message, 0)
 	for _, msg := range messages {
-		if msg.Timestamp > mp.lastPollTime.Unix() {
+		if msg.Timestamp > since.Unix() {
 			newMessages = append(newMessages, msg)
 		}
 	}
-	
-	// Update last poll time
-	mp.lastPollTime = time.Now()
-	
+
 	// Notify about new messages
 	for _, msg := range newMessages {
-		if err := mp.notificationManager.NotifyMessageReceived(msg); err != nil {
-			log.Printf("Failed to notify message received: %v", err)
+		if err := mp.notificationManager.NotifyMessageReceivedContext(ctx, msg); err != nil {
+			mp.notificationManager.getLogger().Warn("failed to notify message received", "message_id", msg.MessageID, "error", err)
 		}
 	}
 }
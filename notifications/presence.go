@@ -0,0 +1,18 @@
+package notifications
+
+import "time"
+
+// EventPresence reports a contact's presence status change (user, status)
+const EventPresence NotificationEvent = "presence"
+
+// NotifyPresence is a convenience method for presence notifications
+func (nm *NotificationManager) NotifyPresence(userAddress, status string) error {
+	return nm.Notify(&Notification{
+		Event:     EventPresence,
+		Timestamp: time.Now().Unix(),
+		Metadata: map[string]any{
+			"user":   userAddress,
+			"status": status,
+		},
+	})
+}
@@ -0,0 +1,204 @@
+package notifications
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+)
+
+// PreviewPolicy controls how much message content is exposed in push notifications
+type PreviewPolicy string
+
+const (
+	PreviewFull       PreviewPolicy = "full"        // Sender, subject and body preview
+	PreviewSenderOnly PreviewPolicy = "sender_only" // Sender only, no content
+	PreviewSilent     PreviewPolicy = "silent"      // No visible content, wake-up only
+)
+
+// DefaultPreviewLength is the maximum number of body characters included in a full preview
+const DefaultPreviewLength = 140
+
+// PushPayload represents the content handed to a mobile push notification service
+type PushPayload struct {
+	Event      NotificationEvent `json:"event"`
+	MessageID  string            `json:"message_id,omitempty"`
+	GroupID    string            `json:"group_id,omitempty"`
+	Sender     string            `json:"sender,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	Silent     bool              `json:"silent,omitempty"`
+	Encrypted  bool              `json:"encrypted,omitempty"`
+	Ciphertext string            `json:"ciphertext,omitempty"` // Base64 JSON of the encrypted preview
+}
+
+// PushConfig holds configuration for push payload generation
+type PushConfig struct {
+	Policy        PreviewPolicy
+	PreviewLength int
+	Encryptor     *PushEncryptor // Optional; when set, previews are only readable on the device
+}
+
+// DefaultPushConfig returns a default push configuration
+func DefaultPushConfig() *PushConfig {
+	return &PushConfig{
+		Policy:        PreviewSenderOnly,
+		PreviewLength: DefaultPreviewLength,
+	}
+}
+
+// PushFormatter converts notifications into push payloads according to a policy
+type PushFormatter struct {
+	config PushConfig
+}
+
+// NewPushFormatter creates a new push formatter. The config is copied; later
+// changes to it do not affect the formatter.
+func NewPushFormatter(config *PushConfig) *PushFormatter {
+	if config == nil {
+		config = DefaultPushConfig()
+	}
+
+	pf := &PushFormatter{config: *config}
+	if pf.config.PreviewLength <= 0 {
+		pf.config.PreviewLength = DefaultPreviewLength
+	}
+	return pf
+}
+
+// Format builds a push payload for a notification. Silenced messages always get a
+// silent payload, whatever the policy.
+func (pf *PushFormatter) Format(notification *Notification) (*PushPayload, error) {
+	if notification == nil {
+		return nil, fmt.Errorf("notification cannot be nil")
+	}
+
+	preview := pf.buildPreview(notification)
+
+	if pf.config.Policy == PreviewSilent || notification.Event == EventMessageSilenced {
+		return &PushPayload{
+			Event:     notification.Event,
+			MessageID: preview.MessageID,
+			Silent:    true,
+		}, nil
+	}
+
+	if pf.config.Encryptor != nil {
+		return pf.config.Encryptor.Seal(preview)
+	}
+
+	return preview, nil
+}
+
+// buildPreview creates the plaintext preview permitted by the policy
+func (pf *PushFormatter) buildPreview(notification *Notification) *PushPayload {
+	payload := &PushPayload{Event: notification.Event}
+
+	msg := notification.Message
+	if msg == nil {
+		return payload
+	}
+
+	payload.MessageID = msg.MessageID
+	payload.GroupID = msg.GroupID
+	payload.Sender = msg.From
+
+	if pf.config.Policy == PreviewFull {
+		payload.Title = msg.Subject
+		// Encrypted bodies are ciphertext and must never be shown as a preview
+		if !msg.IsEncrypted() {
+			payload.Body = truncatePreview(msg.Body, pf.config.PreviewLength)
+		}
+	}
+
+	return payload
+}
+
+// truncatePreview shortens a body to at most maxLen runes
+func truncatePreview(body string, maxLen int) string {
+	runes := []rune(body)
+	if len(runes) <= maxLen {
+		return body
+	}
+	return string(runes[:maxLen]) + "…"
+}
+
+// PushEncryptor seals push previews for a single device's encryption key
+type PushEncryptor struct {
+	keyPair         *encryption.EncryptionKeyPair
+	devicePublicKey [32]byte
+}
+
+// NewPushEncryptor creates a push encryptor for the given device public key
+func NewPushEncryptor(keyPair *encryption.EncryptionKeyPair, devicePublicKeyBase64 string) (*PushEncryptor, error) {
+	if keyPair == nil {
+		return nil, fmt.Errorf("key pair cannot be nil")
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(devicePublicKeyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device public key format: %w", err)
+	}
+	if len(keyBytes) != 32 {
+		return nil, fmt.Errorf("invalid device public key length: expected 32 bytes, got %d", len(keyBytes))
+	}
+
+	encryptor := &PushEncryptor{keyPair: keyPair}
+	copy(encryptor.devicePublicKey[:], keyBytes)
+	return encryptor, nil
+}
+
+// Seal encrypts the preview and returns an opaque payload exposing only routing fields
+func (pe *PushEncryptor) Seal(preview *PushPayload) (*PushPayload, error) {
+	plaintext, err := json.Marshal(preview)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal push preview: %w", err)
+	}
+
+	encryptedMsg, err := pe.keyPair.Encrypt(plaintext, pe.devicePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt push preview: %w", err)
+	}
+
+	encryptedData, err := json.Marshal(encryptedMsg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize encrypted push preview: %w", err)
+	}
+
+	return &PushPayload{
+		Event:      preview.Event,
+		MessageID:  preview.MessageID,
+		Encrypted:  true,
+		Ciphertext: base64.StdEncoding.EncodeToString(encryptedData),
+	}, nil
+}
+
+// OpenPushPayload decrypts a sealed push payload on the device
+func OpenPushPayload(payload *PushPayload, deviceKeyPair *encryption.EncryptionKeyPair) (*PushPayload, error) {
+	if !payload.Encrypted {
+		return payload, nil
+	}
+
+	encryptedData, err := base64.StdEncoding.DecodeString(payload.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push ciphertext: %w", err)
+	}
+
+	var encryptedMsg encryption.EncryptedMessage
+	if err := json.Unmarshal(encryptedData, &encryptedMsg); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted push preview: %w", err)
+	}
+
+	plaintext, err := deviceKeyPair.Decrypt(&encryptedMsg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt push preview: %w", err)
+	}
+
+	var preview PushPayload
+	if err := json.Unmarshal(plaintext, &preview); err != nil {
+		return nil, fmt.Errorf("failed to parse push preview: %w", err)
+	}
+
+	return &preview, nil
+}
@@ -0,0 +1,213 @@
+package pagination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page is a single page of results from a list API
+type Page[T any] struct {
+	Items      []T
+	NextCursor string // Cursor for the following page; empty when this is the last page
+}
+
+// PageFetcher fetches the page that starts at cursor. The first page has an empty cursor.
+type PageFetcher[T any] func(ctx context.Context, cursor string) (*Page[T], error)
+
+// Options controls how an Iterator fetches pages
+type Options struct {
+	MaxRateLimitRetries int           // Retries of a rate-limited page fetch before giving up
+	RateLimitDelay      time.Duration // Wait before retrying when the server gives no retry hint
+	MaxRateLimitDelay   time.Duration // Upper bound on a single wait, whatever the server asks for
+}
+
+// DefaultOptions returns default iterator options
+func DefaultOptions() *Options {
+	return &Options{
+		MaxRateLimitRetries: 3,
+		RateLimitDelay:      time.Second,
+		MaxRateLimitDelay:   time.Minute,
+	}
+}
+
+// retryAfterError is implemented by errors that carry a server-provided wait time
+type retryAfterError interface {
+	RetryAfterDelay() time.Duration
+}
+
+// httpStatusError is implemented by errors that carry an HTTP status code
+type httpStatusError interface {
+	HTTPStatusCode() int
+}
+
+// Iterator walks every item of a paginated list, fetching pages as needed:
+//
+//	it := client.GroupMembersIterator(groupID, nil, 50)
+//	for it.Next(ctx) {
+//		member := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator[T any] struct {
+	fetch   PageFetcher[T]
+	options Options
+	items   []T
+	index   int
+	cursor  string
+	started bool
+	done    bool
+	err     error
+	current T
+}
+
+// New creates an iterator over the pages returned by fetch. A nil options uses DefaultOptions.
+func New[T any](fetch PageFetcher[T], options *Options) *Iterator[T] {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	return &Iterator[T]{fetch: fetch, options: *options}
+}
+
+// Next advances to the next item, fetching the next page when the current one is used up.
+// It returns false when the list is exhausted or an error occurred; check Err afterwards.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for it.index >= len(it.items) {
+		if it.err != nil || it.done {
+			return false
+		}
+		if it.started && it.cursor == "" {
+			it.done = true
+			return false
+		}
+
+		page, err := it.fetchPage(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.started = true
+		it.items = page.Items
+		it.index = 0
+		it.cursor = page.NextCursor
+	}
+
+	it.current = it.items[it.index]
+	it.index++
+	return true
+}
+
+// Item returns the item Next advanced to
+func (it *Iterator[T]) Item() T {
+	return it.current
+}
+
+// Err returns the error that stopped iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Collect drains the iterator into a slice
+func (it *Iterator[T]) Collect(ctx context.Context) ([]T, error) {
+	var items []T
+	for it.Next(ctx) {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
+}
+
+// fetchPage fetches the page at the current cursor, waiting out rate limits
+func (it *Iterator[T]) fetchPage(ctx context.Context) (*Page[T], error) {
+	for attempt := 0; ; attempt++ {
+		page, err := it.fetch(ctx, it.cursor)
+		if err == nil {
+			if page == nil {
+				page = &Page[T]{}
+			}
+			return page, nil
+		}
+
+		delay, limited := it.rateLimitDelay(err)
+		if !limited || attempt >= it.options.MaxRateLimitRetries {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// rateLimitDelay reports whether err is a rate limit and how long to wait before retrying
+func (it *Iterator[T]) rateLimitDelay(err error) (time.Duration, bool) {
+	delay := it.options.RateLimitDelay
+
+	var retryErr retryAfterError
+	var statusErr httpStatusError
+	switch {
+	case errors.As(err, &retryErr):
+		if hint := retryErr.RetryAfterDelay(); hint > 0 {
+			delay = hint
+		}
+	case errors.As(err, &statusErr) && statusErr.HTTPStatusCode() == http.StatusTooManyRequests:
+	default:
+		return 0, false
+	}
+
+	if it.options.MaxRateLimitDelay > 0 && delay > it.options.MaxRateLimitDelay {
+		delay = it.options.MaxRateLimitDelay
+	}
+	return delay, true
+}
+
+// SliceFetcher pages through an in-memory list. The list is re-read for every page so
+// items added or removed between pages are reflected, as with a server-side list.
+func SliceFetcher[T any](list func() []T, pageSize int) PageFetcher[T] {
+	return func(ctx context.Context, cursor string) (*Page[T], error) {
+		offset, err := ParseOffsetCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		items := list()
+		if offset >= len(items) {
+			return &Page[T]{}, nil
+		}
+
+		end := len(items)
+		if pageSize > 0 && offset+pageSize < end {
+			end = offset + pageSize
+		}
+
+		page := &Page[T]{Items: items[offset:end]}
+		if end < len(items) {
+			page.NextCursor = OffsetCursor(end)
+		}
+		return page, nil
+	}
+}
+
+// OffsetCursor encodes a numeric offset as a cursor
+func OffsetCursor(offset int) string {
+	return strconv.Itoa(offset)
+}
+
+// ParseOffsetCursor decodes a cursor created by OffsetCursor. An empty cursor is offset 0.
+func ParseOffsetCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(cursor)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return offset, nil
+}
@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Marshal encodes a wire type in the SDK's JSON format. The output is also
+// valid proto3 JSON for the published definitions.
+func Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON produced by this SDK or by a proto3 JSON encoder for
+// the published definitions into v. It accepts lowerCamelCase field names,
+// quoted 64-bit integers and URL-safe or unpadded base64, as other languages'
+// protobuf libraries emit by default. Unknown fields are ignored.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer, got %T", v)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	normalized, err := normalize(rv.Type().Elem(), raw, "$")
+	if err != nil {
+		return err
+	}
+
+	canonical, err := json.Marshal(normalized)
+	if err != nil {
+		return fmt.Errorf("failed to re-encode JSON: %w", err)
+	}
+	return json.Unmarshal(canonical, v)
+}
+
+// normalize rewrites a decoded proto3 JSON value into the SDK's JSON form for type t
+func normalize(t reflect.Type, v any, path string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected object, got %T", path, v)
+		}
+		out := make(map[string]any, len(obj))
+		for _, f := range wireFields(t) {
+			value, exists := obj[f.Name]
+			if !exists {
+				value, exists = obj[lowerCamel(f.Name)]
+			}
+			if !exists {
+				continue
+			}
+			normalized, err := normalize(f.Type, value, path+"."+f.Name)
+			if err != nil {
+				return nil, err
+			}
+			out[f.Name] = normalized
+		}
+		return out, nil
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return normalizeBytes(v, path)
+		}
+		list, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected array, got %T", path, v)
+		}
+		out := make([]any, len(list))
+		for i, item := range list {
+			normalized, err := normalize(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = normalized
+		}
+		return out, nil
+
+	case reflect.Map:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected object, got %T", path, v)
+		}
+		out := make(map[string]any, len(obj))
+		for key, value := range obj {
+			normalized, err := normalize(t.Elem(), value, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = normalized
+		}
+		return out, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		// proto3 JSON quotes 64-bit integers
+		if s, ok := v.(string); ok {
+			return json.Number(s), nil
+		}
+	}
+
+	return v, nil
+}
+
+// normalizeBytes re-encodes base64 in any proto3-accepted variant as standard padded base64
+func normalizeBytes(v any, path string) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected base64 string, got %T", path, v)
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if data, err := encoding.DecodeString(s); err == nil {
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+	}
+	return nil, fmt.Errorf("%s: invalid base64", path)
+}
+
+// lowerCamel converts a snake_case JSON name to the proto3 JSON name
+func lowerCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
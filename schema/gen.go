@@ -0,0 +1,25 @@
+//go:build ignore
+
+// gen writes emsg.proto and emsg.schema.json from the Go wire types
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/emsg-protocol/emsg-client-sdk/schema"
+)
+
+func main() {
+	if err := os.WriteFile("emsg.proto", []byte(schema.Proto()), 0644); err != nil {
+		log.Fatalf("failed to write emsg.proto: %v", err)
+	}
+
+	data, err := schema.MarshalJSONSchema()
+	if err != nil {
+		log.Fatalf("failed to encode JSON Schema: %v", err)
+	}
+	if err := os.WriteFile("emsg.schema.json", data, 0644); err != nil {
+		log.Fatalf("failed to write emsg.schema.json: %v", err)
+	}
+}
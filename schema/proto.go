@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ProtoPackage is the protobuf package of the generated definitions
+const ProtoPackage = "emsg"
+
+// Proto returns proto3 definitions of the published types, as written to emsg.proto.
+// Field names match the JSON names, so this SDK's JSON is valid proto3 JSON for them.
+func Proto() string {
+	types := structTypes()
+
+	var body strings.Builder
+	usesStruct := false
+	for i, t := range types {
+		if i > 0 {
+			body.WriteString("\n")
+		}
+		fmt.Fprintf(&body, "message %s {\n", t.Name)
+		for _, f := range wireFields(t.Type) {
+			fieldType := protoType(f.Type)
+			if fieldType == "google.protobuf.Struct" || fieldType == "google.protobuf.Value" {
+				usesStruct = true
+			}
+			fmt.Fprintf(&body, "  %s %s = %d;\n", fieldType, f.Name, f.Number)
+		}
+		body.WriteString("}\n")
+	}
+
+	var out strings.Builder
+	out.WriteString("// Code generated by go generate in package schema; DO NOT EDIT.\n\n")
+	out.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&out, "package %s;\n\n", ProtoPackage)
+	if usesStruct {
+		out.WriteString("import \"google/protobuf/struct.proto\";\n\n")
+	}
+	out.WriteString(body.String())
+	return out.String()
+}
+
+// protoType returns the proto3 type of a field, including any repeated or map qualifier
+func protoType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "repeated " + protoType(t.Elem())
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return "google.protobuf.Struct"
+		}
+		return fmt.Sprintf("map<%s, %s>", protoType(t.Key()), protoType(t.Elem()))
+	case reflect.Struct:
+		return t.Name()
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return "int32"
+	case reflect.Int, reflect.Int64:
+		return "int64"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "uint32"
+	case reflect.Uint, reflect.Uint64:
+		return "uint64"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	}
+	return "google.protobuf.Value"
+}
@@ -0,0 +1,185 @@
+// Package schema publishes language-neutral definitions of the EMSG wire types
+// so services written in other languages can stay compatible with this SDK.
+//
+// The protobuf and JSON Schema definitions are generated from the Go types by
+// reflection over their json tags. Run go generate after changing a wire type
+// to refresh emsg.proto and emsg.schema.json. Protobuf field numbers follow
+// field declaration order, so new fields must be appended to their struct.
+package schema
+
+//go:generate go run gen.go
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// JSONSchemaDraft is the JSON Schema dialect of the generated definitions
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// WireType is a top-level type published in the schema
+type WireType struct {
+	Name string
+	Type reflect.Type
+}
+
+// WireTypes returns the published top-level types. Types they reference, such
+// as KeyBundle and AttachmentChunk, are published alongside them.
+func WireTypes() []WireType {
+	return []WireType{
+		{Name: "Message", Type: reflect.TypeOf(message.Message{})},
+		{Name: "Attachment", Type: reflect.TypeOf(attachments.Attachment{})},
+		{Name: "SystemMessage", Type: reflect.TypeOf(message.SystemMessage{})},
+		{Name: "DeliveryReceipt", Type: reflect.TypeOf(delivery.DeliveryReceipt{})},
+	}
+}
+
+// Schema is a JSON Schema definition
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// JSONSchema returns a schema document defining every published type under $defs
+func JSONSchema() *Schema {
+	doc := &Schema{
+		Schema: JSONSchemaDraft,
+		Defs:   make(map[string]*Schema),
+	}
+	for _, t := range structTypes() {
+		doc.Defs[t.Name] = structSchema(t.Type)
+	}
+	return doc
+}
+
+// MarshalJSONSchema returns the indented JSON Schema document, as written to emsg.schema.json
+func MarshalJSONSchema() ([]byte, error) {
+	data, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// structSchema describes a struct as a JSON object
+func structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, f := range wireFields(t) {
+		s.Properties[f.Name] = typeSchema(f.Type)
+		if !f.OmitEmpty {
+			s.Required = append(s.Required, f.Name)
+		}
+	}
+	return s
+}
+
+// typeSchema describes a field type, referring to structs by name
+func typeSchema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		s := &Schema{Type: "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			s.AdditionalProperties = typeSchema(t.Elem())
+		}
+		return s
+	case reflect.Struct:
+		return &Schema{Ref: "#/$defs/" + t.Name()}
+	}
+	// Interfaces accept any JSON value
+	return &Schema{}
+}
+
+// wireField is an exported struct field as it appears on the wire
+type wireField struct {
+	Name      string // JSON name
+	Number    int    // Protobuf field number
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// wireFields returns a struct's serialized fields in declaration order
+func wireFields(t reflect.Type) []wireField {
+	var fields []wireField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, wireField{
+			Name:      name,
+			Number:    len(fields) + 1,
+			Type:      sf.Type,
+			OmitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	return fields
+}
+
+// structTypes returns the published types followed by the struct types they
+// reference, in first-use order
+func structTypes() []WireType {
+	var types []WireType
+	seen := make(map[reflect.Type]bool)
+
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || seen[t] {
+			return
+		}
+		seen[t] = true
+		types = append(types, WireType{Name: t.Name(), Type: t})
+	}
+
+	for _, t := range WireTypes() {
+		visit(t.Type)
+	}
+	// types grows while it is walked, so nested references are visited breadth first
+	for i := 0; i < len(types); i++ {
+		for _, f := range wireFields(types[i].Type) {
+			visit(f.Type)
+		}
+	}
+	return types
+}
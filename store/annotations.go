@@ -0,0 +1,210 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Annotation is app-specific metadata a user attaches to a message locally,
+// e.g. a star or tags. It is never sent, and the message itself is unchanged,
+// so its signature still verifies.
+type Annotation struct {
+	Starred   bool              `json:"starred,omitempty"`
+	Archived  bool              `json:"archived,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`   // Sorted, without duplicates
+	Values    map[string]string `json:"values,omitempty"` // Metadata keyed by the app, e.g. "reminder"
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// HasTag returns true if the annotation carries tag
+func (a *Annotation) HasTag(tag string) bool {
+	_, found := slices.BinarySearch(a.Tags, tag)
+	return found
+}
+
+// IsEmpty returns true if the annotation records nothing, so it need not be kept
+func (a *Annotation) IsEmpty() bool {
+	return !a.Starred && !a.Archived && len(a.Tags) == 0 && len(a.Values) == 0
+}
+
+// Clone returns a deep copy of the annotation
+func (a *Annotation) Clone() *Annotation {
+	clone := *a
+	clone.Tags = slices.Clone(a.Tags)
+	clone.Values = maps.Clone(a.Values)
+	return &clone
+}
+
+// AnnotationStore keeps annotations keyed by message ID. The message stores in
+// this package implement it, dropping a message's annotation when the message
+// is deleted or wiped.
+type AnnotationStore interface {
+	// GetAnnotation returns ErrNotFound if the message has no annotation
+	GetAnnotation(messageID string) (*Annotation, error)
+	PutAnnotation(messageID string, annotation *Annotation) error
+	DeleteAnnotation(messageID string) error
+	AnnotatedIDs() ([]string, error)
+}
+
+// FindAnnotated returns the IDs of annotated messages matching match, in sorted order
+func FindAnnotated(s AnnotationStore, match func(*Annotation) bool) ([]string, error) {
+	ids, err := s.AnnotatedIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list annotations: %w", err)
+	}
+
+	var matched []string
+	for _, id := range ids {
+		annotation, err := s.GetAnnotation(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read annotation of %s: %w", id, err)
+		}
+		if match(annotation) {
+			matched = append(matched, id)
+		}
+	}
+	return matched, nil
+}
+
+// GetAnnotation returns a message's annotation
+func (m *MemoryMessageStore) GetAnnotation(messageID string) (*Annotation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	annotation, exists := m.annotations[messageID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return annotation.Clone(), nil
+}
+
+// PutAnnotation stores a message's annotation, replacing any previous one
+func (m *MemoryMessageStore) PutAnnotation(messageID string, annotation *Annotation) error {
+	if messageID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.annotations[messageID] = annotation.Clone()
+	return nil
+}
+
+// DeleteAnnotation removes a message's annotation
+func (m *MemoryMessageStore) DeleteAnnotation(messageID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.annotations[messageID]; !exists {
+		return ErrNotFound
+	}
+	delete(m.annotations, messageID)
+	return nil
+}
+
+// AnnotatedIDs returns the IDs of annotated messages in sorted order
+func (m *MemoryMessageStore) AnnotatedIDs() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ids := make([]string, 0, len(m.annotations))
+	for id := range m.annotations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GetAnnotation returns a message's annotation
+func (f *FileMessageStore) GetAnnotation(messageID string) (*Annotation, error) {
+	path, err := f.annotationPath(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.RLock()
+	data, err := os.ReadFile(path)
+	f.mutex.RUnlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotation: %w", err)
+	}
+	var annotation Annotation
+	if err := json.Unmarshal(data, &annotation); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation: %w", err)
+	}
+	return &annotation, nil
+}
+
+// PutAnnotation stores a message's annotation, replacing any previous one
+func (f *FileMessageStore) PutAnnotation(messageID string, annotation *Annotation) error {
+	path, err := f.annotationPath(messageID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return fmt.Errorf("failed to serialize annotation: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write annotation: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write annotation: %w", err)
+	}
+	return nil
+}
+
+// DeleteAnnotation removes a message's annotation
+func (f *FileMessageStore) DeleteAnnotation(messageID string) error {
+	path, err := f.annotationPath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	return nil
+}
+
+// AnnotatedIDs returns the IDs of annotated messages in sorted order
+func (f *FileMessageStore) AnnotatedIDs() ([]string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return listIDs(f.annotationDir)
+}
+
+// annotationPath returns the file path for a message's annotation
+func (f *FileMessageStore) annotationPath(messageID string) (string, error) {
+	path, err := f.messagePath(messageID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(f.annotationDir, filepath.Base(path)), nil
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// IssueKind classifies an integrity problem found in a stored message
+type IssueKind string
+
+const (
+	IssueUnreadable        IssueKind = "unreadable"         // Stored data could not be parsed
+	IssueInvalidSignature  IssueKind = "invalid_signature"  // Signature does not verify against the sender's key
+	IssueMissingSignature  IssueKind = "missing_signature"  // Message carries no signature
+	IssueAttachmentCorrupt IssueKind = "attachment_corrupt" // Attachment size or checksum mismatch
+	IssueUndecryptable     IssueKind = "undecryptable"      // Encrypted body cannot be decrypted with current keys
+)
+
+// SigningKeyLookup returns the base64 Ed25519 public key for a sender address
+type SigningKeyLookup func(address string) (string, error)
+
+// VerifyOptions controls what VerifyStore checks and whether it quarantines bad entries
+type VerifyOptions struct {
+	SigningKeys       SigningKeyLookup              // Sender key lookup (nil = only keys from embedded key bundles)
+	EncryptionManager *encryption.EncryptionManager // Used to check decryptability (nil = skip)
+	SelfAddress       string                        // Messages we sent are encrypted for others and are not decryption-checked
+	Quarantine        bool                          // Move messages with issues into quarantine
+}
+
+// IntegrityIssue describes a single problem found in a stored message
+type IntegrityIssue struct {
+	MessageID string
+	Kind      IssueKind
+	Detail    string
+}
+
+// IntegrityReport summarizes a VerifyStore run
+type IntegrityReport struct {
+	Checked     int
+	Healthy     int
+	Unverified  int // Signed messages whose sender key was unavailable
+	Issues      []IntegrityIssue
+	Quarantined []string
+}
+
+// HasIssues returns true if any stored message failed verification
+func (r *IntegrityReport) HasIssues() bool {
+	return len(r.Issues) > 0
+}
+
+// IssuesFor returns the issues recorded for a message
+func (r *IntegrityReport) IssuesFor(messageID string) []IntegrityIssue {
+	var issues []IntegrityIssue
+	for _, issue := range r.Issues {
+		if issue.MessageID == messageID {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// VerifyStore re-verifies signatures, attachment checksums and decryptability of every
+// message in the store and reports corrupted or tampered entries
+func VerifyStore(s MessageStore, opts *VerifyOptions) (*IntegrityReport, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored messages: %w", err)
+	}
+
+	report := &IntegrityReport{}
+	for _, id := range ids {
+		report.Checked++
+
+		issues, verified := verifyEntry(s, id, opts)
+		if !verified && len(issues) == 0 {
+			report.Unverified++
+		}
+		if len(issues) == 0 {
+			report.Healthy++
+			continue
+		}
+
+		report.Issues = append(report.Issues, issues...)
+		if opts.Quarantine {
+			if err := s.Quarantine(id, issues[0].Detail); err != nil {
+				return report, fmt.Errorf("failed to quarantine message %s: %w", id, err)
+			}
+			report.Quarantined = append(report.Quarantined, id)
+		}
+	}
+
+	return report, nil
+}
+
+// verifyEntry checks a single stored message. The boolean reports whether the signature was checked.
+func verifyEntry(s MessageStore, id string, opts *VerifyOptions) ([]IntegrityIssue, bool) {
+	msg, err := s.Get(id)
+	if err != nil {
+		return []IntegrityIssue{{MessageID: id, Kind: IssueUnreadable, Detail: err.Error()}}, false
+	}
+
+	var issues []IntegrityIssue
+	addIssue := func(kind IssueKind, format string, args ...any) {
+		issues = append(issues, IntegrityIssue{MessageID: id, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if msg.MessageID != id {
+		addIssue(IssueUnreadable, "stored under %s but message ID is %s", id, msg.MessageID)
+	}
+
+	verified := false
+	if !msg.IsSigned() {
+		addIssue(IssueMissingSignature, "message is not signed")
+	} else if key := senderSigningKey(msg, opts); key != "" {
+		verified = true
+		if err := msg.Verify(key); err != nil {
+			addIssue(IssueInvalidSignature, "%v", err)
+		}
+	}
+
+	for _, att := range msg.Attachments {
+		// Attachments referenced by URL have no local data to check
+		if len(att.Data) == 0 && len(att.Chunks) == 0 {
+			continue
+		}
+		if err := att.Verify(); err != nil {
+			addIssue(IssueAttachmentCorrupt, "attachment %s: %v", att.ID, err)
+		}
+	}
+
+	if msg.IsEncrypted() && opts.EncryptionManager != nil && !sameAddress(msg.From, opts.SelfAddress) {
+		if _, err := msg.DecryptBody(opts.EncryptionManager); err != nil {
+			addIssue(IssueUndecryptable, "%v", err)
+		}
+	}
+
+	return issues, verified
+}
+
+// senderSigningKey finds the sender's signing key, preferring the lookup over an embedded key bundle
+func senderSigningKey(msg *message.Message, opts *VerifyOptions) string {
+	if opts.SigningKeys != nil {
+		if key, err := opts.SigningKeys(msg.From); err == nil && key != "" {
+			return key
+		}
+	}
+	if msg.HasKeyBundle() && sameAddress(msg.KeyBundle.Address, msg.From) {
+		return msg.KeyBundle.SigningKey
+	}
+	return ""
+}
+
+func sameAddress(a, b string) bool {
+	return a != "" && b != "" && utils.NormalizeEMSGAddress(a) == utils.NormalizeEMSGAddress(b)
+}
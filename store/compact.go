@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compactor is implemented by stores that can reclaim space left behind by
+// deletes and interrupted writes
+type Compactor interface {
+	Compact(ctx context.Context) (*CompactionResult, error)
+}
+
+// CompactionResult reports what a compaction reclaimed
+type CompactionResult struct {
+	FilesRemoved   int   // Leftover temporary files and orphaned records removed
+	BytesReclaimed int64 // Size of the removed files
+}
+
+// Add accumulates another result into r
+func (r *CompactionResult) Add(other *CompactionResult) {
+	if other == nil {
+		return
+	}
+	r.FilesRemoved += other.FilesRemoved
+	r.BytesReclaimed += other.BytesReclaimed
+}
+
+// Compact removes temporary files left by interrupted writes, and quarantine
+// records and annotations whose message file is gone
+func (f *FileMessageStore) Compact(ctx context.Context) (*CompactionResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	result, err := compactDir(ctx, f.dir, isTempFile)
+	if err != nil {
+		return result, err
+	}
+	orphans, err := compactDir(ctx, f.quarantineDir, func(name string) bool {
+		if isTempFile(name) {
+			return true
+		}
+		if !strings.HasSuffix(name, ".reason") {
+			return false
+		}
+		_, err := os.Stat(filepath.Join(f.quarantineDir, strings.TrimSuffix(name, ".reason")+".json"))
+		return errors.Is(err, os.ErrNotExist)
+	})
+	result.Add(orphans)
+	if err != nil {
+		return result, err
+	}
+	annotations, err := compactDir(ctx, f.annotationDir, func(name string) bool {
+		if isTempFile(name) {
+			return true
+		}
+		for _, dir := range []string{f.dir, f.quarantineDir} {
+			if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+				return false
+			}
+		}
+		return true
+	})
+	result.Add(annotations)
+	return result, err
+}
+
+// Compact removes temporary files left by interrupted writes
+func (f *FileOutboxStore) Compact(ctx context.Context) (*CompactionResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return compactDir(ctx, f.dir, isTempFile)
+}
+
+// Compact removes temporary files left by interrupted writes
+func (f *FileDraftStore) Compact(ctx context.Context) (*CompactionResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return compactDir(ctx, f.dir, isTempFile)
+}
+
+// isTempFile returns true for the temporary files written before an atomic rename.
+// Writers hold the store lock until the rename, so any found under the lock are leftovers.
+func isTempFile(name string) bool {
+	return strings.HasSuffix(name, ".tmp")
+}
+
+// compactDir removes the files in dir selected by reclaim
+func compactDir(ctx context.Context, dir string, reclaim func(name string) bool) (*CompactionResult, error) {
+	result := &CompactionResult{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return result, fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if entry.IsDir() || !reclaim(entry.Name()) {
+			continue
+		}
+
+		var size int64
+		if info, err := entry.Info(); err == nil {
+			size = info.Size()
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+		result.FilesRemoved++
+		result.BytesReclaimed += size
+	}
+	return result, nil
+}
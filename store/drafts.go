@@ -0,0 +1,243 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// ErrDraftNotFound is returned when no draft is stored under a key
+var ErrDraftNotFound = errors.New("draft not found")
+
+// DraftStore persists sealed drafts. Drafts are encrypted before they reach the
+// store, so implementations only ever see opaque bytes under opaque keys.
+type DraftStore interface {
+	Put(key string, sealed []byte) error
+	Get(key string) ([]byte, error)
+	Keys() ([]string, error)
+	Delete(key string) error
+}
+
+// Wiper is implemented by stores that can erase everything they hold at once,
+// e.g. on logout. WipeMessages, WipeOutbox and WipeDrafts fall back to deleting
+// entries one by one for stores that do not implement it.
+type Wiper interface {
+	Wipe() error
+}
+
+// MemoryDraftStore is an in-memory implementation of DraftStore
+type MemoryDraftStore struct {
+	drafts map[string][]byte
+	mutex  sync.RWMutex
+}
+
+// NewMemoryDraftStore creates a new in-memory draft store
+func NewMemoryDraftStore() *MemoryDraftStore {
+	return &MemoryDraftStore{drafts: make(map[string][]byte)}
+}
+
+// Put stores a sealed draft, replacing any draft under the same key
+func (m *MemoryDraftStore) Put(key string, sealed []byte) error {
+	if key == "" {
+		return fmt.Errorf("draft key is required")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if old, exists := m.drafts[key]; exists {
+		utils.Wipe(old)
+	}
+	m.drafts[key] = append([]byte(nil), sealed...)
+	return nil
+}
+
+// Get retrieves a sealed draft
+func (m *MemoryDraftStore) Get(key string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sealed, exists := m.drafts[key]
+	if !exists {
+		return nil, ErrDraftNotFound
+	}
+	return append([]byte(nil), sealed...), nil
+}
+
+// Keys returns the keys of all stored drafts in sorted order
+func (m *MemoryDraftStore) Keys() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	keys := make([]string, 0, len(m.drafts))
+	for key := range m.drafts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes a draft
+func (m *MemoryDraftStore) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sealed, exists := m.drafts[key]
+	if !exists {
+		return ErrDraftNotFound
+	}
+	utils.Wipe(sealed)
+	delete(m.drafts, key)
+	return nil
+}
+
+// Wipe removes all drafts
+func (m *MemoryDraftStore) Wipe() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, sealed := range m.drafts {
+		utils.Wipe(sealed)
+		delete(m.drafts, key)
+	}
+	return nil
+}
+
+// FileDraftStore stores each sealed draft as a file in a directory
+type FileDraftStore struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+// NewFileDraftStore creates a file-backed draft store rooted at dir
+func NewFileDraftStore(dir string) (*FileDraftStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create draft directory: %w", err)
+	}
+	return &FileDraftStore{dir: dir}, nil
+}
+
+// Put stores a sealed draft, replacing any draft under the same key
+func (f *FileDraftStore) Put(key string, sealed []byte) error {
+	path, err := f.draftPath(key)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write draft: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write draft: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a sealed draft
+func (f *FileDraftStore) Get(key string) ([]byte, error) {
+	path, err := f.draftPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.RLock()
+	sealed, err := os.ReadFile(path)
+	f.mutex.RUnlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read draft: %w", err)
+	}
+	return sealed, nil
+}
+
+// Keys returns the keys of all stored drafts in sorted order
+func (f *FileDraftStore) Keys() ([]string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.keysLocked()
+}
+
+// Delete removes a draft
+func (f *FileDraftStore) Delete(key string) error {
+	path, err := f.draftPath(key)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrDraftNotFound
+		}
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+	return nil
+}
+
+// Wipe removes all drafts
+func (f *FileDraftStore) Wipe() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return removeFiles(f.dir, ".draft", ".tmp")
+}
+
+// keysLocked lists the draft files in the directory
+func (f *FileDraftStore) keysLocked() ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read draft directory: %w", err)
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".draft") {
+			continue
+		}
+		keys = append(keys, strings.TrimSuffix(name, ".draft"))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// draftPath returns the file path for a draft key, rejecting keys that would escape the store
+func (f *FileDraftStore) draftPath(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid draft key: %q", key)
+	}
+	return filepath.Join(f.dir, key+".draft"), nil
+}
+
+// WipeDrafts removes every draft from a store
+func WipeDrafts(s DraftStore) error {
+	if wiper, ok := s.(Wiper); ok {
+		return wiper.Wipe()
+	}
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, key := range keys {
+		if err := s.Delete(key); err != nil && !errors.Is(err, ErrDraftNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
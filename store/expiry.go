@@ -0,0 +1,31 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// PurgeExpired deletes the active messages in a store whose expiry is at or
+// before now, returning the IDs of those deleted
+func PurgeExpired(s MessageStore, now time.Time) ([]string, error) {
+	expired, err := LoadOrdered(s, func(msg *message.Message) bool {
+		return msg.IsExpired(now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []string
+	var errs []error
+	for _, msg := range expired {
+		if err := s.Delete(msg.MessageID); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, fmt.Errorf("failed to delete expired message %s: %w", msg.MessageID, err))
+			continue
+		}
+		purged = append(purged, msg.MessageID)
+	}
+	return purged, errors.Join(errs...)
+}
@@ -0,0 +1,282 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// DefaultImportBatchSize is how many messages Import writes per batch by default
+const DefaultImportBatchSize = 500
+
+// BatchSaver is implemented by stores that write many messages faster together
+// than with one Save each
+type BatchSaver interface {
+	// SaveBatch stores messages, replacing any with the same IDs. If it fails,
+	// none of the messages are stored.
+	SaveBatch(messages []*message.Message) error
+}
+
+// Indexer is implemented by stores that maintain indexes over their messages,
+// such as database-backed stores. Import suspends index maintenance while it
+// writes and rebuilds the indexes once at the end.
+type Indexer interface {
+	SuspendIndexing() error
+	RebuildIndexes() error
+}
+
+// ImportSource yields the messages to import one at a time, returning io.EOF
+// after the last. Import only asks for the next message once it is ready for it,
+// so a source reading an archive is never ahead of the store.
+type ImportSource func(ctx context.Context) (*message.Message, error)
+
+// SliceSource returns a source yielding messages in order
+func SliceSource(messages []*message.Message) ImportSource {
+	next := 0
+	return func(ctx context.Context) (*message.Message, error) {
+		if next >= len(messages) {
+			return nil, io.EOF
+		}
+		next++
+		return messages[next-1], nil
+	}
+}
+
+// ChannelSource returns a source yielding the messages sent on ch until it is
+// closed. Producers block while the store catches up.
+func ChannelSource(ch <-chan *message.Message) ImportSource {
+	return func(ctx context.Context) (*message.Message, error) {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil, io.EOF
+			}
+			return msg, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ImportOptions configures Import. Zero fields keep the defaults.
+type ImportOptions struct {
+	BatchSize  int                   // Messages written per batch (default DefaultImportBatchSize)
+	OnProgress func(*ImportProgress) // Called after each batch is written
+}
+
+// ImportProgress reports how far an import has got
+type ImportProgress struct {
+	Read     int // Messages taken from the source
+	Imported int // Messages written to the store
+	Skipped  int // Messages already in the store, quarantined, or repeated in the source
+	Rejected int // Messages without an ID
+}
+
+// ImportResult is the outcome of an import
+type ImportResult struct {
+	ImportProgress
+	Batches  int
+	Duration time.Duration
+}
+
+// Import writes messages from source into a store in batches, skipping messages
+// the store already holds or has quarantined and repeats within the source.
+// Stores implementing BatchSaver write each batch all-or-nothing, and stores
+// implementing Indexer rebuild their indexes once after the last batch. If the
+// source, a batch or ctx fails, Import stops and returns the result up to the
+// last written batch with the error; running it again resumes where it stopped,
+// since written messages are skipped.
+func Import(ctx context.Context, s MessageStore, source ImportSource, opts *ImportOptions) (*ImportResult, error) {
+	batchSize := DefaultImportBatchSize
+	var onProgress func(*ImportProgress)
+	if opts != nil {
+		if opts.BatchSize > 0 {
+			batchSize = opts.BatchSize
+		}
+		onProgress = opts.OnProgress
+	}
+
+	seen, err := storedIDs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if indexer, ok := s.(Indexer); ok {
+		if err := indexer.SuspendIndexing(); err != nil {
+			return nil, fmt.Errorf("failed to suspend indexing: %w", err)
+		}
+	}
+
+	result := &ImportResult{}
+	start := time.Now()
+	err = importBatches(ctx, s, source, batchSize, seen, result, onProgress)
+	if indexer, ok := s.(Indexer); ok {
+		if indexErr := indexer.RebuildIndexes(); indexErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to rebuild indexes: %w", indexErr))
+		}
+	}
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// importBatches reads the source and writes it to the store a batch at a time
+func importBatches(ctx context.Context, s MessageStore, source ImportSource, batchSize int, seen map[string]bool, result *ImportResult, onProgress func(*ImportProgress)) error {
+	batch := make([]*message.Message, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := saveBatch(s, batch); err != nil {
+			return fmt.Errorf("failed to write batch %d: %w", result.Batches+1, err)
+		}
+		result.Batches++
+		result.Imported += len(batch)
+		batch = batch[:0]
+		if onProgress != nil {
+			progress := result.ImportProgress
+			onProgress(&progress)
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		msg, err := source(ctx)
+		if errors.Is(err, io.EOF) {
+			return flush()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read message %d: %w", result.Read+1, err)
+		}
+		result.Read++
+
+		switch {
+		case msg == nil || msg.MessageID == "":
+			result.Rejected++
+			continue
+		case seen[msg.MessageID]:
+			result.Skipped++
+			continue
+		}
+		seen[msg.MessageID] = true
+
+		batch = append(batch, msg)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// storedIDs returns the IDs of the active and quarantined messages in a store
+func storedIDs(s MessageStore) (map[string]bool, error) {
+	active, err := s.IDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored messages: %w", err)
+	}
+	quarantined, err := s.QuarantinedIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined messages: %w", err)
+	}
+
+	ids := make(map[string]bool, len(active)+len(quarantined))
+	for _, id := range active {
+		ids[id] = true
+	}
+	for _, id := range quarantined {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// saveBatch writes a batch with SaveBatch when the store supports it, and one
+// message at a time otherwise
+func saveBatch(s MessageStore, batch []*message.Message) error {
+	if saver, ok := s.(BatchSaver); ok {
+		return saver.SaveBatch(batch)
+	}
+	for _, msg := range batch {
+		if err := s.Save(msg); err != nil {
+			return fmt.Errorf("failed to store message %s: %w", msg.MessageID, err)
+		}
+	}
+	return nil
+}
+
+// SaveBatch stores messages, replacing any with the same IDs. Every message is
+// serialized before any is stored, so an invalid message stores none of them.
+func (m *MemoryMessageStore) SaveBatch(messages []*message.Message) error {
+	encoded := make(map[string][]byte, len(messages))
+	for _, msg := range messages {
+		if msg.MessageID == "" {
+			return fmt.Errorf("message ID is required")
+		}
+		data, err := msg.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to serialize message %s: %w", msg.MessageID, err)
+		}
+		encoded[msg.MessageID] = data
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for id, data := range encoded {
+		m.messages[id] = data
+	}
+	return nil
+}
+
+// SaveBatch stores messages, replacing any with the same IDs. Every message is
+// written to a temporary file before any is renamed into place, so a failed
+// write, e.g. on a full disk, stores none of them.
+func (f *FileMessageStore) SaveBatch(messages []*message.Message) error {
+	paths := make([]string, len(messages))
+	encoded := make([][]byte, len(messages))
+	for i, msg := range messages {
+		if msg.MessageID == "" {
+			return fmt.Errorf("message ID is required")
+		}
+		path, err := f.messagePath(msg.MessageID)
+		if err != nil {
+			return err
+		}
+		data, err := msg.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to serialize message %s: %w", msg.MessageID, err)
+		}
+		paths[i], encoded[i] = path, data
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i, path := range paths {
+		if err := os.WriteFile(path+".tmp", encoded[i], 0600); err != nil {
+			removeTempFiles(paths[:i+1])
+			return fmt.Errorf("failed to write message %s: %w", messages[i].MessageID, err)
+		}
+	}
+	for i, path := range paths {
+		if err := os.Rename(path+".tmp", path); err != nil {
+			removeTempFiles(paths[i:])
+			return fmt.Errorf("failed to write message %s: %w", messages[i].MessageID, err)
+		}
+	}
+	return nil
+}
+
+// removeTempFiles deletes the temporary files of message paths
+func removeTempFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path + ".tmp")
+	}
+}
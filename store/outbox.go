@@ -0,0 +1,291 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// OutboxEntry is a message waiting to be sent, with its delivery attempt history
+type OutboxEntry struct {
+	Message     *message.Message `json:"message"`
+	EnqueuedAt  time.Time        `json:"enqueued_at"`
+	Attempts    int              `json:"attempts"`
+	NextAttempt time.Time        `json:"next_attempt"`
+	LastError   string           `json:"last_error,omitempty"`
+	// Encryption decision made when the message was built, kept so receipts of
+	// messages sent after a restart still record it
+	Encryption *message.EncryptionDecision `json:"encryption,omitempty"`
+	// Recipient domains still to deliver to after a partial delivery (empty = all)
+	Domains []string `json:"domains,omitempty"`
+}
+
+// OutboxStore persists queued outgoing messages, keyed by message ID
+type OutboxStore interface {
+	Put(entry *OutboxEntry) error
+	Get(messageID string) (*OutboxEntry, error)
+	// List returns all queued entries, oldest first
+	List() ([]*OutboxEntry, error)
+	Remove(messageID string) error
+}
+
+// MemoryOutboxStore is an in-memory implementation of OutboxStore. Queued
+// messages do not survive a restart.
+type MemoryOutboxStore struct {
+	entries map[string][]byte
+	mutex   sync.RWMutex
+}
+
+// NewMemoryOutboxStore creates a new in-memory outbox store
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		entries: make(map[string][]byte),
+	}
+}
+
+// Put stores an entry, replacing any entry for the same message
+func (m *MemoryOutboxStore) Put(entry *OutboxEntry) error {
+	data, err := marshalOutboxEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries[entry.Message.MessageID] = data
+	return nil
+}
+
+// Get retrieves an entry by message ID
+func (m *MemoryOutboxStore) Get(messageID string) (*OutboxEntry, error) {
+	m.mutex.RLock()
+	data, exists := m.entries[messageID]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return unmarshalOutboxEntry(data)
+}
+
+// List returns all queued entries, oldest first
+func (m *MemoryOutboxStore) List() ([]*OutboxEntry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	entries := make([]*OutboxEntry, 0, len(m.entries))
+	for _, data := range m.entries {
+		entry, err := unmarshalOutboxEntry(data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sortOutboxEntries(entries)
+	return entries, nil
+}
+
+// Remove deletes an entry
+func (m *MemoryOutboxStore) Remove(messageID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.entries[messageID]; !exists {
+		return ErrNotFound
+	}
+	delete(m.entries, messageID)
+	return nil
+}
+
+// Wipe removes all queued entries
+func (m *MemoryOutboxStore) Wipe() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, data := range m.entries {
+		utils.Wipe(data)
+		delete(m.entries, id)
+	}
+	return nil
+}
+
+// FileOutboxStore stores each queued message as a JSON file in a directory so
+// the queue survives process restarts
+type FileOutboxStore struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+// NewFileOutboxStore creates a file-backed outbox store rooted at dir
+func NewFileOutboxStore(dir string) (*FileOutboxStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return &FileOutboxStore{dir: dir}, nil
+}
+
+// Put stores an entry, replacing any entry for the same message
+func (f *FileOutboxStore) Put(entry *OutboxEntry) error {
+	data, err := marshalOutboxEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	path, err := f.entryPath(entry.Message.MessageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partial entry
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves an entry by message ID
+func (f *FileOutboxStore) Get(messageID string) (*OutboxEntry, error) {
+	path, err := f.entryPath(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.RLock()
+	data, err := os.ReadFile(path)
+	f.mutex.RUnlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox entry: %w", err)
+	}
+	return unmarshalOutboxEntry(data)
+}
+
+// List returns all queued entries, oldest first
+func (f *FileOutboxStore) List() ([]*OutboxEntry, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	ids, err := listIDs(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*OutboxEntry, 0, len(ids))
+	for _, id := range ids {
+		data, err := os.ReadFile(filepath.Join(f.dir, id+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox entry: %w", err)
+		}
+		entry, err := unmarshalOutboxEntry(data)
+		if err != nil {
+			return nil, fmt.Errorf("outbox entry %s: %w", id, err)
+		}
+		entries = append(entries, entry)
+	}
+	sortOutboxEntries(entries)
+	return entries, nil
+}
+
+// Remove deletes an entry
+func (f *FileOutboxStore) Remove(messageID string) error {
+	path, err := f.entryPath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to remove outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Wipe removes all queued entries
+func (f *FileOutboxStore) Wipe() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return removeFiles(f.dir, ".json", ".tmp")
+}
+
+// WipeOutbox removes every queued entry from an outbox store
+func WipeOutbox(s OutboxStore) error {
+	if wiper, ok := s.(Wiper); ok {
+		return wiper.Wipe()
+	}
+	entries, err := s.List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if err := s.Remove(entry.Message.MessageID); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// entryPath returns the file path for a message ID, rejecting IDs that would escape the outbox
+func (f *FileOutboxStore) entryPath(messageID string) (string, error) {
+	if messageID == "" || messageID != filepath.Base(messageID) || messageID[0] == '.' {
+		return "", fmt.Errorf("invalid message ID: %q", messageID)
+	}
+	return filepath.Join(f.dir, messageID+".json"), nil
+}
+
+func marshalOutboxEntry(entry *OutboxEntry) ([]byte, error) {
+	if entry == nil || entry.Message == nil || entry.Message.MessageID == "" {
+		return nil, fmt.Errorf("message ID is required")
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize outbox entry: %w", err)
+	}
+	return data, nil
+}
+
+func unmarshalOutboxEntry(data []byte) (*OutboxEntry, error) {
+	var entry OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox entry: %w", err)
+	}
+	if entry.Message == nil {
+		return nil, fmt.Errorf("outbox entry has no message")
+	}
+	return &entry, nil
+}
+
+// sortOutboxEntries orders entries by enqueue time, breaking ties by message ID
+func sortOutboxEntries(entries []*OutboxEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].EnqueuedAt.Equal(entries[j].EnqueuedAt) {
+			return entries[i].EnqueuedAt.Before(entries[j].EnqueuedAt)
+		}
+		return entries[i].Message.MessageID < entries[j].Message.MessageID
+	})
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// Query selects stored messages. Zero fields match every message.
+type Query struct {
+	From     string    // Sender address
+	Peer     string    // Address that sent or received the message
+	GroupID  string    // Group the message was sent to
+	ThreadID string    // Message ID of a thread's first message; matches it and every reply to it, direct or not
+	Text     string    // Case-insensitive text in the subject or body, rich bodies as plain text; encrypted bodies are not searched
+	Since    time.Time // Sent at or after this time
+	Until    time.Time // Sent before this time
+	Limit    int       // Keep only the most recent matches (0 = all)
+}
+
+// Searcher is a MessageStore that answers queries itself, e.g. from an index.
+// Search uses it instead of reading every stored message.
+type Searcher interface {
+	Search(query *Query) ([]*message.Message, error)
+}
+
+// Search returns the active messages in a store matching query, in
+// conversation order as defined by message.CompareOrder
+func Search(s MessageStore, query *Query) ([]*message.Message, error) {
+	if query == nil {
+		query = &Query{}
+	}
+	if searcher, ok := s.(Searcher); ok {
+		return searcher.Search(query)
+	}
+
+	messages, err := LoadOrdered(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	thread := query.thread(messages)
+
+	var matched []*message.Message
+	for _, msg := range messages {
+		if query.Matches(msg) && (thread == nil || thread[msg.MessageID]) {
+			matched = append(matched, msg)
+		}
+	}
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[len(matched)-query.Limit:]
+	}
+	return matched, nil
+}
+
+// Matches reports whether a message matches every field of the query except
+// ThreadID and Limit, which depend on the other messages in the store
+func (q *Query) Matches(msg *message.Message) bool {
+	if q.From != "" && utils.NormalizeEMSGAddress(msg.From) != utils.NormalizeEMSGAddress(q.From) {
+		return false
+	}
+	if q.Peer != "" && !involves(msg, utils.NormalizeEMSGAddress(q.Peer)) {
+		return false
+	}
+	if q.GroupID != "" && msg.GroupID != q.GroupID {
+		return false
+	}
+	if msg.IsExpired(time.Now()) {
+		return false
+	}
+	sentAt := msg.SentAt()
+	if !q.Since.IsZero() && sentAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !sentAt.Before(q.Until) {
+		return false
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		if !strings.Contains(strings.ToLower(msg.Subject), text) &&
+			(msg.Encrypted || !strings.Contains(strings.ToLower(msg.PlainText()), text)) {
+			return false
+		}
+	}
+	return true
+}
+
+// thread returns the IDs of the messages in the query's thread, or nil if it names none
+func (q *Query) thread(messages []*message.Message) map[string]bool {
+	if q.ThreadID == "" {
+		return nil
+	}
+
+	// Replies can be stored before what they answer, so repeat until no reply is added
+	thread := map[string]bool{q.ThreadID: true}
+	for added := true; added; {
+		added = false
+		for _, msg := range messages {
+			if msg.InReplyTo != "" && thread[msg.InReplyTo] && !thread[msg.MessageID] {
+				thread[msg.MessageID] = true
+				added = true
+			}
+		}
+	}
+	return thread
+}
+
+// involves reports whether address sent or received a message
+func involves(msg *message.Message, address string) bool {
+	if utils.NormalizeEMSGAddress(msg.From) == address {
+		return true
+	}
+	for _, recipient := range msg.GetRecipients() {
+		if utils.NormalizeEMSGAddress(recipient) == address {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,407 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// ErrNotFound is returned when a message is not in the store
+var ErrNotFound = errors.New("message not found")
+
+// MessageStore persists messages locally
+type MessageStore interface {
+	Save(msg *message.Message) error
+	Get(messageID string) (*message.Message, error)
+	IDs() ([]string, error)
+	Delete(messageID string) error
+	// Quarantine moves a message out of the active store, keeping it for inspection
+	Quarantine(messageID string, reason string) error
+	QuarantinedIDs() ([]string, error)
+}
+
+// LoadOrdered returns the active messages in a store that match filter (nil =
+// all), in conversation order as defined by message.CompareOrder
+func LoadOrdered(s MessageStore, filter func(*message.Message) bool) ([]*message.Message, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored messages: %w", err)
+	}
+
+	var messages []*message.Message
+	for _, id := range ids {
+		msg, err := s.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since the IDs were listed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored message %s: %w", id, err)
+		}
+		if filter == nil || filter(msg) {
+			messages = append(messages, msg)
+		}
+	}
+
+	message.SortMessages(messages)
+	return messages, nil
+}
+
+// WipeMessages removes every message from a store, including quarantined
+// messages when the store implements Wiper
+func WipeMessages(s MessageStore) error {
+	if wiper, ok := s.(Wiper); ok {
+		return wiper.Wipe()
+	}
+	ids, err := s.IDs()
+	if err != nil {
+		return fmt.Errorf("failed to list stored messages: %w", err)
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := s.Delete(id); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// QuarantineRecord describes why a message was quarantined
+type QuarantineRecord struct {
+	MessageID     string    `json:"message_id"`
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// MemoryMessageStore is an in-memory implementation of MessageStore
+type MemoryMessageStore struct {
+	messages    map[string][]byte
+	quarantined map[string]*QuarantineRecord
+	annotations map[string]*Annotation
+	mutex       sync.RWMutex
+}
+
+// NewMemoryMessageStore creates a new in-memory message store
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		messages:    make(map[string][]byte),
+		quarantined: make(map[string]*QuarantineRecord),
+		annotations: make(map[string]*Annotation),
+	}
+}
+
+// Save stores a message, replacing any message with the same ID
+func (m *MemoryMessageStore) Save(msg *message.Message) error {
+	if msg.MessageID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages[msg.MessageID] = data
+	return nil
+}
+
+// Get retrieves a message by ID
+func (m *MemoryMessageStore) Get(messageID string) (*message.Message, error) {
+	m.mutex.RLock()
+	data, exists := m.messages[messageID]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return message.FromJSON(data)
+}
+
+// IDs returns the IDs of all active messages in sorted order
+func (m *MemoryMessageStore) IDs() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ids := make([]string, 0, len(m.messages))
+	for id := range m.messages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete removes a message
+func (m *MemoryMessageStore) Delete(messageID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.messages[messageID]; !exists {
+		return ErrNotFound
+	}
+	delete(m.messages, messageID)
+	delete(m.annotations, messageID)
+	return nil
+}
+
+// Quarantine removes a message from the active set and records the reason
+func (m *MemoryMessageStore) Quarantine(messageID string, reason string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.messages[messageID]; !exists {
+		return ErrNotFound
+	}
+	delete(m.messages, messageID)
+	m.quarantined[messageID] = &QuarantineRecord{
+		MessageID:     messageID,
+		Reason:        reason,
+		QuarantinedAt: time.Now(),
+	}
+	return nil
+}
+
+// QuarantinedIDs returns the IDs of quarantined messages in sorted order
+func (m *MemoryMessageStore) QuarantinedIDs() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ids := make([]string, 0, len(m.quarantined))
+	for id := range m.quarantined {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Wipe removes all active and quarantined messages
+func (m *MemoryMessageStore) Wipe() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, data := range m.messages {
+		utils.Wipe(data)
+		delete(m.messages, id)
+	}
+	clear(m.quarantined)
+	clear(m.annotations)
+	return nil
+}
+
+// FileMessageStore stores each message as a JSON file in a directory
+type FileMessageStore struct {
+	dir           string
+	quarantineDir string
+	annotationDir string
+	mutex         sync.RWMutex
+}
+
+// NewFileMessageStore creates a file-backed message store rooted at dir
+func NewFileMessageStore(dir string) (*FileMessageStore, error) {
+	quarantineDir := filepath.Join(dir, "quarantine")
+	annotationDir := filepath.Join(dir, "annotations")
+	for _, path := range []string{quarantineDir, annotationDir} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create store directory: %w", err)
+		}
+	}
+
+	return &FileMessageStore{
+		dir:           dir,
+		quarantineDir: quarantineDir,
+		annotationDir: annotationDir,
+	}, nil
+}
+
+// Save stores a message, replacing any message with the same ID
+func (f *FileMessageStore) Save(msg *message.Message) error {
+	if msg.MessageID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+
+	path, err := f.messagePath(msg.MessageID)
+	if err != nil {
+		return err
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partial message
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a message by ID
+func (f *FileMessageStore) Get(messageID string) (*message.Message, error) {
+	path, err := f.messagePath(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.RLock()
+	data, err := os.ReadFile(path)
+	f.mutex.RUnlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return message.FromJSON(data)
+}
+
+// IDs returns the IDs of all active messages in sorted order
+func (f *FileMessageStore) IDs() ([]string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return listIDs(f.dir)
+}
+
+// Delete removes a message
+func (f *FileMessageStore) Delete(messageID string) error {
+	path, err := f.messagePath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	annotationPath := filepath.Join(f.annotationDir, filepath.Base(path))
+	if err := os.Remove(annotationPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	return nil
+}
+
+// Quarantine moves a message file into the quarantine directory alongside a record of the reason
+func (f *FileMessageStore) Quarantine(messageID string, reason string) error {
+	path, err := f.messagePath(messageID)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	target := filepath.Join(f.quarantineDir, filepath.Base(path))
+	if err := os.Rename(path, target); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to quarantine message: %w", err)
+	}
+
+	record, err := json.Marshal(&QuarantineRecord{
+		MessageID:     messageID,
+		Reason:        reason,
+		QuarantinedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine record: %w", err)
+	}
+	if err := os.WriteFile(strings.TrimSuffix(target, ".json")+".reason", record, 0600); err != nil {
+		return fmt.Errorf("failed to write quarantine record: %w", err)
+	}
+	return nil
+}
+
+// QuarantinedIDs returns the IDs of quarantined messages in sorted order
+func (f *FileMessageStore) QuarantinedIDs() ([]string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return listIDs(f.quarantineDir)
+}
+
+// Wipe removes all active and quarantined messages
+func (f *FileMessageStore) Wipe() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return errors.Join(
+		removeFiles(f.dir, ".json", ".tmp"),
+		removeFiles(f.quarantineDir, ".json", ".reason"),
+		removeFiles(f.annotationDir, ".json", ".tmp"),
+	)
+}
+
+// messagePath returns the file path for a message ID, rejecting IDs that would escape the store
+func (f *FileMessageStore) messagePath(messageID string) (string, error) {
+	if messageID == "" || messageID != filepath.Base(messageID) || strings.HasPrefix(messageID, ".") {
+		return "", fmt.Errorf("invalid message ID: %q", messageID)
+	}
+	return filepath.Join(f.dir, messageID+".json"), nil
+}
+
+// listIDs returns the message IDs of the JSON files in a directory
+func listIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// removeFiles deletes the files in a directory whose names end in one of suffixes
+func removeFiles(dir string, suffixes ...string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(entry.Name(), suffix) {
+				if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, fmt.Errorf("failed to remove %s: %w", entry.Name(), err))
+				}
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,9 +1,21 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/attachments"
 )
@@ -338,3 +350,921 @@ func TestAttachmentValidation(t *testing.T) {
 		t.Error("Valid attachment is nil")
 	}
 }
+
+func newRangeServer(t *testing.T, content []byte, requests *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		http.ServeContent(w, r, "media.mp3", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadAttachmentRange(t *testing.T) {
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	var requests int32
+	server := newRangeServer(t, content, &requests)
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024 * 1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment := &attachments.Attachment{
+		ID:       "att_remote",
+		Name:     "media.mp3",
+		MimeType: "audio/mpeg",
+		Size:     int64(len(content)),
+		URL:      server.URL + "/media.mp3",
+	}
+
+	data, err := manager.DownloadAttachment(attachment, 100, 50)
+	if err != nil {
+		t.Fatalf("Failed to download range: %v", err)
+	}
+	if !bytes.Equal(data, content[100:150]) {
+		t.Error("Downloaded range does not match content")
+	}
+
+	data, err = manager.DownloadAttachment(attachment, 4000, 0)
+	if err != nil {
+		t.Fatalf("Failed to download tail: %v", err)
+	}
+	if !bytes.Equal(data, content[4000:]) {
+		t.Errorf("Expected %d tail bytes, got %d", len(content)-4000, len(data))
+	}
+
+	data, err = manager.DownloadAttachment(attachment, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to download full attachment: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Full download does not match content")
+	}
+
+	if _, err := manager.DownloadAttachment(attachment, int64(len(content)), 10); err != io.EOF {
+		t.Errorf("Expected io.EOF past the end, got %v", err)
+	}
+
+	if _, err := manager.DownloadAttachment(&attachments.Attachment{}, 0, 0); err == nil {
+		t.Error("Expected error for attachment without URL")
+	}
+}
+
+func TestDownloadAttachmentRejectsWrongRanges(t *testing.T) {
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var contentRange string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentRange == "" {
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Range", contentRange)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	attachment := &attachments.Attachment{ID: "att_ranges", URL: server.URL + "/media"}
+
+	// A partial response holding another range than requested
+	contentRange, body = "bytes 0-49/4096", content[:50]
+	if _, err := manager.DownloadAttachment(attachment, 100, 50); err == nil {
+		t.Error("Expected a range starting elsewhere to be rejected")
+	}
+	for _, invalid := range []string{"items 100-149/4096", "bytes */4096", "bytes 100-90/4096"} {
+		contentRange = invalid
+		if _, err := manager.DownloadAttachment(attachment, 100, 50); err == nil {
+			t.Errorf("Expected Content-Range %q to be rejected", contentRange)
+		}
+	}
+
+	// A partial response running past the requested window
+	contentRange, body = "bytes 100-199/4096", content[100:200]
+	if _, err := manager.DownloadAttachment(attachment, 100, 50); err == nil {
+		t.Error("Expected a range longer than requested to be rejected")
+	}
+	contentRange, body = "bytes 100-149/4096", content[100:200]
+	if _, err := manager.DownloadAttachment(attachment, 100, 50); err == nil {
+		t.Error("Expected a body longer than requested to be rejected")
+	}
+	body = content[100:150]
+	if data, err := manager.DownloadAttachment(attachment, 100, 50); err != nil || !bytes.Equal(data, content[100:150]) {
+		t.Errorf("Expected the requested range, got %d bytes: %v", len(data), err)
+	}
+
+	// A body over the maximum file size is an error, not cut off
+	contentRange, body = "", content
+	if _, err := manager.DownloadAttachment(attachment, 0, 0); err == nil || !strings.Contains(err.Error(), "maximum size") {
+		t.Errorf("Expected an oversized download to fail, got %v", err)
+	}
+	body = content[:1024]
+	if data, err := manager.DownloadAttachment(attachment, 0, 0); err != nil || len(data) != 1024 {
+		t.Errorf("Expected a download of exactly the maximum size, got %d bytes: %v", len(data), err)
+	}
+
+	// A server ignoring the range still serves the requested window
+	body = content
+	if data, err := manager.DownloadAttachment(attachment, 100, 50); err != nil || !bytes.Equal(data, content[100:150]) {
+		t.Errorf("Expected the window of a full response, got %d bytes: %v", len(data), err)
+	}
+}
+
+func TestDownloadAttachmentVerifiesChecksum(t *testing.T) {
+	content := []byte("uploaded attachment content")
+	var requests int32
+	server := newRangeServer(t, content, &requests)
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024 * 1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment := &attachments.Attachment{
+		ID:       "att_uploaded",
+		Size:     int64(len(content)),
+		Checksum: attachments.Checksum(content),
+		URL:      server.URL + "/att_uploaded",
+	}
+	data, err := manager.DownloadAttachment(attachment, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to download attachment: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Downloaded data does not match content")
+	}
+
+	attachment.Checksum = attachments.Checksum([]byte("something else"))
+	if _, err := manager.DownloadAttachment(attachment, 0, 0); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+
+	// Ranges cannot be checked against the whole file's checksum
+	if _, err := manager.DownloadAttachment(attachment, 0, 8); err != nil {
+		t.Errorf("Expected unverified range download to succeed, got %v", err)
+	}
+}
+
+func TestRemoteReaderSeek(t *testing.T) {
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+
+	var requests int32
+	server := newRangeServer(t, content, &requests)
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024 * 1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	reader, err := manager.NewRemoteReader(&attachments.Attachment{
+		Size: int64(len(content)),
+		URL:  server.URL + "/media.mp3",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create remote reader: %v", err)
+	}
+	reader.SetReadAhead(1000)
+
+	var _ io.ReadSeeker = reader
+
+	if _, err := reader.Seek(-500, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read tail: %v", err)
+	}
+	if !bytes.Equal(tail, content[9500:]) {
+		t.Error("Tail read does not match content")
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected 1 range request for tail, got %d", requests)
+	}
+
+	if _, err := reader.Seek(2000, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("Failed to read after seek: %v", err)
+	}
+	if !bytes.Equal(buf, content[2000:2100]) {
+		t.Error("Read after seek does not match content")
+	}
+
+	if _, err := manager.NewRemoteReader(&attachments.Attachment{URL: server.URL}); err == nil {
+		t.Error("Expected error for attachment with unknown size")
+	}
+}
+
+func TestChunkSizer(t *testing.T) {
+	sizer := attachments.NewChunkSizer(64*1024, 4*1024*1024, 256*1024)
+
+	// A fast link grows the chunk size, at most doubling per step
+	sizer.RecordSuccess(256*1024, 10*time.Millisecond)
+	if size := sizer.Size(); size != 512*1024 {
+		t.Errorf("Expected chunk size to double to 512KB, got %d", size)
+	}
+	for i := 0; i < 10; i++ {
+		sizer.RecordSuccess(sizer.Size(), 10*time.Millisecond)
+	}
+	if size := sizer.Size(); size != 4*1024*1024 {
+		t.Errorf("Expected chunk size to reach the 4MB maximum, got %d", size)
+	}
+
+	// Failures halve the chunk size but never go below the minimum
+	for i := 0; i < 10; i++ {
+		sizer.RecordFailure()
+	}
+	if size := sizer.Size(); size != 64*1024 {
+		t.Errorf("Expected chunk size to shrink to the 64KB minimum, got %d", size)
+	}
+	if rate := sizer.ErrorRate(); rate != 1 {
+		t.Errorf("Expected error rate 1, got %v", rate)
+	}
+
+	// A high error rate holds the size even when throughput allows growth
+	sizer.RecordSuccess(64*1024, time.Millisecond)
+	if size := sizer.Size(); size != 64*1024 {
+		t.Errorf("Expected chunk size to hold on an unreliable link, got %d", size)
+	}
+
+	// A slow link shrinks towards what can be moved in about two seconds
+	slow := attachments.NewChunkSizer(16*1024, 1024*1024, 1024*1024)
+	slow.RecordSuccess(1024*1024, 64*time.Second) // 16KB/s
+	if size := slow.Size(); size != 32*1024 {
+		t.Errorf("Expected chunk size of 32KB on a 16KB/s link, got %d", size)
+	}
+
+	// Server bounds clamp the current size
+	slow.SetBounds(48*1024, 96*1024)
+	if size := slow.Size(); size != 48*1024 {
+		t.Errorf("Expected chunk size to be raised to the 48KB minimum, got %d", size)
+	}
+}
+
+func TestAdaptiveRemoteReader(t *testing.T) {
+	content := make([]byte, 300*1024)
+	for i := range content {
+		content[i] = byte(i % 241)
+	}
+
+	// Fail every third request to simulate a lossy link
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%3 == 0 {
+			http.Error(w, "connection reset", http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = ""
+	manager, err := attachments.NewAttachmentManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	reader, err := manager.NewRemoteReader(&attachments.Attachment{
+		Size: int64(len(content)),
+		URL:  server.URL + "/video.mp4",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create remote reader: %v", err)
+	}
+	sizer := reader.ChunkSizer()
+	if sizer == nil {
+		t.Fatal("Expected adaptive chunking to be enabled by default")
+	}
+	sizer.SetBounds(16*1024, 64*1024)
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Adaptive reader should recover from failed chunks: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Adaptive read does not match content")
+	}
+	if sizer.ErrorRate() == 0 {
+		t.Error("Expected failed chunks to be reflected in the error rate")
+	}
+	if size := sizer.Size(); size < 16*1024 || size > 64*1024 {
+		t.Errorf("Chunk size %d is outside the configured bounds", size)
+	}
+
+	// A fixed read-ahead disables adaptation
+	reader.SetReadAhead(1000)
+	if reader.ChunkSizer() != nil {
+		t.Error("Expected SetReadAhead to disable adaptive chunking")
+	}
+}
+
+func TestImageMetadataStripping(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	// Insert an APP1 EXIF segment carrying fake GPS data right after SOI
+	exifPayload := []byte("Exif\x00\x00GPS-LATITUDE-51.5")
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exifPayload)+2))
+	segment = append(segment, exifPayload...)
+
+	raw := encoded.Bytes()
+	withExif := append([]byte{}, raw[:2]...)
+	withExif = append(withExif, segment...)
+	withExif = append(withExif, raw[2:]...)
+
+	// Images are attached as they are unless stripping is asked for
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = t.TempDir()
+	defaultManager, _ := attachments.NewAttachmentManager(config)
+	untouched, err := defaultManager.CreateAttachmentFromData("photo.jpg", withExif, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	if !bytes.Equal(untouched.Data, withExif) {
+		t.Error("Expected the image to be attached unchanged by default")
+	}
+
+	config.StripImageMetadata = true
+	manager, err := attachments.NewAttachmentManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment, err := manager.CreateAttachmentFromData("photo.jpg", withExif, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+
+	data, _ := manager.GetAttachmentData(attachment)
+	if bytes.Contains(data, []byte("GPS-LATITUDE")) {
+		t.Error("EXIF data should have been stripped")
+	}
+	if attachment.Size != int64(len(data)) {
+		t.Errorf("Size %d should match stripped data length %d", attachment.Size, len(data))
+	}
+	if err := manager.ValidateAttachment(attachment); err != nil {
+		t.Errorf("Stripped attachment should validate: %v", err)
+	}
+
+	info := attachment.GetMediaInfo()
+	if info == nil {
+		t.Fatal("Expected media info")
+	}
+	if info.Width != 64 || info.Height != 32 {
+		t.Errorf("Expected 64x32, got %dx%d", info.Width, info.Height)
+	}
+	if !info.MetadataStripped {
+		t.Error("Expected MetadataStripped to be set")
+	}
+
+	// Processing can be disabled
+	config.StripImageMetadata = false
+	config.ExtractMediaInfo = false
+	rawManager, _ := attachments.NewAttachmentManager(config)
+	rawAttachment, _ := rawManager.CreateAttachmentFromData("photo.jpg", withExif, "image/jpeg")
+	if rawAttachment.GetMediaInfo() != nil {
+		t.Error("Expected no media info when processing is disabled")
+	}
+	if !bytes.Equal(rawAttachment.Data, withExif) {
+		t.Error("Data should be untouched when processing is disabled")
+	}
+}
+
+func TestAudioDurationExtraction(t *testing.T) {
+	// 8kHz mono 16-bit PCM, two seconds of silence
+	const sampleRate, channels, bitsPerSample = 8000, 1, 16
+	byteRate := sampleRate * channels * bitsPerSample / 8
+	pcm := make([]byte, byteRate*2)
+
+	var wav bytes.Buffer
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+len(pcm)))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, uint32(16))
+	binary.Write(&wav, binary.LittleEndian, uint16(1))
+	binary.Write(&wav, binary.LittleEndian, uint16(channels))
+	binary.Write(&wav, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&wav, binary.LittleEndian, uint32(byteRate))
+	binary.Write(&wav, binary.LittleEndian, uint16(channels*bitsPerSample/8))
+	binary.Write(&wav, binary.LittleEndian, uint16(bitsPerSample))
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(len(pcm)))
+	wav.Write(pcm)
+
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = t.TempDir()
+	config.EnableChunking = false
+	manager, _ := attachments.NewAttachmentManager(config)
+
+	attachment, err := manager.CreateAttachmentFromData("clip.wav", wav.Bytes(), "audio/wav")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+
+	info := attachment.GetMediaInfo()
+	if info == nil || info.Duration != 2*time.Second {
+		t.Errorf("Expected 2s duration, got %+v", info)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":          "report.pdf",
+		"../../etc/passwd":    "passwd",
+		"..\\..\\boot.ini":    "boot.ini",
+		"/abs/path/photo.jpg": "photo.jpg",
+		"..":                  "attachment",
+		"":                    "attachment",
+		".hidden":             "hidden",
+		"bad\x00name\n.txt":   "badname.txt",
+		"C:evil.exe":          "Cevil.exe",
+		"dir/":                "attachment",
+		"  spaced name.txt  ": "spaced name.txt",
+	}
+	for input, want := range tests {
+		if got := attachments.SanitizeFileName(input); got != want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	long := attachments.SanitizeFileName(string(bytes.Repeat([]byte("a"), 300)) + ".txt")
+	if len(long) > 255 || filepath.Ext(long) != ".txt" {
+		t.Errorf("long name not truncated with extension kept: %d bytes, %q", len(long), filepath.Ext(long))
+	}
+}
+
+func TestAttachmentIDTraversal(t *testing.T) {
+	storageDir := t.TempDir()
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize: 1024,
+		StorageDir:  storageDir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment, err := manager.CreateAttachmentFromData("a.txt", []byte("data"), "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+
+	for _, id := range []string{"../escape", "..", "sub/dir", "", "a\\b"} {
+		attachment.ID = id
+		if err := manager.SaveAttachment(attachment); err == nil {
+			t.Errorf("SaveAttachment accepted ID %q", id)
+		}
+		if _, err := manager.LoadAttachment(id); err == nil {
+			t.Errorf("LoadAttachment accepted ID %q", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(storageDir), "escape.meta")); err == nil {
+		t.Error("attachment written outside the storage directory")
+	}
+
+	// Dotted IDs could collide with another attachment's metadata or chunk files
+	for _, id := range []string{"att_1.meta", "att_1.chunk.0", string(bytes.Repeat([]byte("a"), attachments.MaxAttachmentIDLength+1))} {
+		if err := attachments.ValidateAttachmentID(id); err == nil {
+			t.Errorf("ValidateAttachmentID accepted %q", id)
+		}
+	}
+	if err := attachments.ValidateAttachmentID("att_1700000000-a"); err != nil {
+		t.Errorf("ValidateAttachmentID rejected a valid ID: %v", err)
+	}
+}
+
+func TestAttachmentHashedFileNames(t *testing.T) {
+	storageDir := t.TempDir()
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:     1024,
+		StorageDir:      storageDir,
+		HashedFileNames: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment, err := manager.CreateAttachmentFromData("quarterly report.pdf", []byte("report"), "application/pdf")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	// IDs from other clients need not be file-name safe when only their hash is used
+	attachment.ID = "../urn:uuid/1234"
+
+	if err := manager.SaveAttachment(attachment); err != nil {
+		t.Fatalf("Failed to save attachment: %v", err)
+	}
+
+	entries, err := os.ReadDir(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to read storage directory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected data and metadata files, got %d entries", len(entries))
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) < 64 || bytes.Contains([]byte(name), []byte("report")) || bytes.Contains([]byte(name), []byte("uuid")) {
+			t.Errorf("Stored file name %q is not a hash", name)
+		}
+	}
+
+	loaded, err := manager.LoadAttachment(attachment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load attachment: %v", err)
+	}
+	if loaded.ID != attachment.ID || loaded.Name != "quarterly report.pdf" || string(loaded.Data) != "report" {
+		t.Errorf("Loaded attachment doesn't match: %q %q %q", loaded.ID, loaded.Name, loaded.Data)
+	}
+
+	if _, err := manager.LoadAttachment(""); err == nil {
+		t.Error("Expected empty ID to be rejected")
+	}
+}
+
+func TestMaterializeAttachment(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "materialized")
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize: 1024,
+		TempDir:     tempDir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	defer manager.CloseTempFiles()
+
+	data := []byte("materialized attachment data")
+	attachment, err := manager.CreateAttachmentFromData("../../secret.txt", data, "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+
+	file, err := manager.MaterializeAttachment("msg-1", attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize attachment: %v", err)
+	}
+
+	if filepath.Base(file.Path) != "secret.txt" {
+		t.Errorf("Expected sanitized name secret.txt, got %s", filepath.Base(file.Path))
+	}
+	rel, err := filepath.Rel(tempDir, file.Path)
+	if err != nil || filepath.IsAbs(rel) || rel[:2] == ".." {
+		t.Errorf("Materialized file %s escapes %s", file.Path, tempDir)
+	}
+
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		t.Fatalf("Materialized file missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected file mode 0600, got %o", perm)
+	}
+	dirInfo, err := os.Stat(filepath.Dir(file.Path))
+	if err != nil {
+		t.Fatalf("Materialized directory missing: %v", err)
+	}
+	if perm := dirInfo.Mode().Perm(); perm != 0700 {
+		t.Errorf("Expected directory mode 0700, got %o", perm)
+	}
+	if content, _ := os.ReadFile(file.Path); !bytes.Equal(content, data) {
+		t.Error("Materialized content doesn't match attachment data")
+	}
+
+	// The same name twice must not collide
+	second, err := manager.MaterializeAttachment("msg-2", attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize attachment again: %v", err)
+	}
+	if second.Path == file.Path {
+		t.Error("Expected distinct paths for repeated materialization")
+	}
+
+	if err := manager.ReleaseTempFiles("msg-1"); err != nil {
+		t.Fatalf("Failed to release temp files: %v", err)
+	}
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		t.Error("Released file still exists")
+	}
+	if files := manager.TempFiles(""); len(files) != 1 || files[0].MessageID != "msg-2" {
+		t.Errorf("Expected only msg-2's file to remain, got %d files", len(files))
+	}
+
+	// Tampered data is never written to disk
+	attachment.Data = []byte("tampered")
+	if _, err := manager.MaterializeAttachment("msg-3", attachment); err == nil {
+		t.Error("Expected checksum mismatch to be rejected")
+	}
+
+	if err := manager.CloseTempFiles(); err != nil {
+		t.Fatalf("Failed to close temp files: %v", err)
+	}
+	if _, err := os.Stat(second.Path); !os.IsNotExist(err) {
+		t.Error("File still exists after close")
+	}
+	if _, err := manager.MaterializeAttachment("msg-4", attachment); err == nil {
+		t.Error("Expected materialize after close to fail")
+	}
+}
+
+func TestTempFileExpiry(t *testing.T) {
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize: 1024,
+		TempFileTTL: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment, err := manager.CreateAttachmentFromData("expiring.txt", []byte("short-lived"), "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	file, err := manager.MaterializeAttachment("msg-1", attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize attachment: %v", err)
+	}
+	if file.ExpiresAt.IsZero() {
+		t.Error("Expected expiry to be set")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if removed := manager.CleanupTempFiles(); removed != 1 {
+		t.Errorf("Expected 1 expired file removed, got %d", removed)
+	}
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		t.Error("Expired file still exists")
+	}
+
+	// The manager created the temp dir itself and removes it on close
+	root := filepath.Dir(filepath.Dir(file.Path))
+	if err := manager.CloseTempFiles(); err != nil {
+		t.Fatalf("Failed to close temp files: %v", err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Error("Temp directory still exists after close")
+	}
+}
+
+func TestAttachmentStreaming(t *testing.T) {
+	storageDir := t.TempDir()
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:        64 * 1024,
+		MaxChunkSize:       1024,
+		StorageDir:         storageDir,
+		EnableChunking:     true,
+		StripImageMetadata: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("streamed attachment data "), 2000) // 50KB
+	attachment, err := manager.CreateAttachmentFromReader("big.bin", bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatalf("Failed to create attachment from reader: %v", err)
+	}
+
+	if attachment.Size != int64(len(data)) || attachment.Checksum != attachments.Checksum(data) {
+		t.Errorf("Expected size %d and checksum of the data, got %d %s", len(data), attachment.Size, attachment.Checksum)
+	}
+	if len(attachment.Data) > 0 || len(attachment.Chunks) > 0 {
+		t.Error("Streamed attachment should not hold its data in memory")
+	}
+
+	var out bytes.Buffer
+	n, err := manager.StreamAttachmentData(&out, attachment)
+	if err != nil {
+		t.Fatalf("Failed to stream attachment data: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Error("Streamed data doesn't match original")
+	}
+
+	// Staged attachments can be loaded like saved ones
+	loaded, err := manager.LoadAttachment(attachment.ID)
+	if err != nil {
+		t.Fatalf("Failed to load streamed attachment: %v", err)
+	}
+	if !bytes.Equal(loaded.Data, data) {
+		t.Error("Loaded data doesn't match original")
+	}
+
+	// Tampering with the stored file is detected while streaming
+	entries, _ := os.ReadDir(storageDir)
+	for _, entry := range entries {
+		if entry.Name() == attachment.ID {
+			os.WriteFile(filepath.Join(storageDir, entry.Name()), []byte("tampered"), 0600)
+		}
+	}
+	if _, err := manager.StreamAttachmentData(io.Discard, attachment); err == nil {
+		t.Error("Expected tampered data to fail verification")
+	}
+
+	// Oversized input is rejected without leaving files behind
+	before, _ := os.ReadDir(storageDir)
+	if _, err := manager.CreateAttachmentFromReader("huge.bin", bytes.NewReader(make([]byte, 64*1024+1)), ""); err == nil {
+		t.Error("Expected oversized reader to be rejected")
+	}
+	after, _ := os.ReadDir(storageDir)
+	if len(after) != len(before) {
+		t.Errorf("Expected no leftover files, had %d entries and now %d", len(before), len(after))
+	}
+
+	// Inline and chunked attachments stream the same way
+	inline, _ := manager.CreateAttachmentFromData("small.txt", []byte("small"), "text/plain")
+	chunked, _ := manager.CreateAttachmentFromData("chunked.bin", data[:5000], "application/octet-stream")
+	for _, att := range []*attachments.Attachment{inline, chunked} {
+		out.Reset()
+		if _, err := manager.StreamAttachmentData(&out, att); err != nil {
+			t.Errorf("Failed to stream %s: %v", att.Name, err)
+		}
+	}
+}
+
+func TestOpenAttachmentURL(t *testing.T) {
+	content := bytes.Repeat([]byte("remote"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	attachment := &attachments.Attachment{
+		ID:       "att_remote",
+		URL:      server.URL + "/file",
+		Size:     int64(len(content)),
+		Checksum: attachments.Checksum(content),
+	}
+
+	var out bytes.Buffer
+	if _, err := manager.StreamAttachmentData(&out, attachment); err != nil {
+		t.Fatalf("Failed to stream URL attachment: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Error("Streamed URL data doesn't match")
+	}
+
+	// A materialized URL attachment is streamed to disk without buffering it first
+	file, err := manager.MaterializeAttachment("msg-1", attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize URL attachment: %v", err)
+	}
+	defer manager.CloseTempFiles()
+	if onDisk, _ := os.ReadFile(file.Path); !bytes.Equal(onDisk, content) {
+		t.Error("Materialized URL data doesn't match")
+	}
+}
+
+func TestAttachmentScannerQuarantine(t *testing.T) {
+	errMalware := errors.New("EICAR test signature")
+	var quarantined []string
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024 * 1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+		Scanner: func(attachment *attachments.Attachment, data []byte) error {
+			if bytes.Contains(data, []byte("EICAR")) {
+				return errMalware
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	manager.SetEventHooks(&attachments.EventHooks{
+		Quarantined: func(attachment *attachments.Attachment, reason error) {
+			quarantined = append(quarantined, attachment.ID)
+		},
+	})
+
+	clean, _ := manager.CreateAttachmentFromData("notes.txt", []byte("hello"), "text/plain")
+	if err := manager.ValidateAttachment(clean); err != nil {
+		t.Errorf("Expected clean attachment to validate, got %v", err)
+	}
+
+	infected, _ := manager.CreateAttachmentFromData("invoice.txt", []byte("X5O EICAR payload"), "text/plain")
+	err = manager.ValidateAttachment(infected)
+	var quarantineErr *attachments.QuarantineError
+	if !errors.As(err, &quarantineErr) || !errors.Is(err, attachments.ErrQuarantined) || !errors.Is(err, errMalware) {
+		t.Fatalf("Expected QuarantineError wrapping the scanner's reason, got %v", err)
+	}
+	if len(quarantined) != 1 || quarantined[0] != infected.ID || !manager.IsQuarantined(infected.ID) {
+		t.Errorf("Expected %s to be reported and recorded as quarantined, got %v", infected.ID, quarantined)
+	}
+
+	if _, err := manager.GetAttachmentData(infected); !errors.Is(err, attachments.ErrQuarantined) {
+		t.Errorf("Expected quarantined data to be refused, got %v", err)
+	}
+	if _, err := manager.OpenAttachment(infected); !errors.Is(err, attachments.ErrQuarantined) {
+		t.Errorf("Expected quarantined attachment not to open, got %v", err)
+	}
+
+	manager.ReleaseQuarantine(infected.ID)
+	if _, err := manager.GetAttachmentData(infected); err != nil {
+		t.Errorf("Expected released attachment to be readable, got %v", err)
+	}
+}
+
+func TestAttachmentDownloadEvents(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 30000)
+	var requests int32
+	server := newRangeServer(t, content, &requests)
+
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{
+		MaxFileSize:  1024 * 1024,
+		MaxChunkSize: 1024,
+		StorageDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	var progress [][2]int64
+	var validationErrors []error
+	manager.SetEventHooks(&attachments.EventHooks{
+		DownloadProgress: func(attachment *attachments.Attachment, received, total int64) {
+			progress = append(progress, [2]int64{received, total})
+		},
+		ValidationFailed: func(attachment *attachments.Attachment, err error) {
+			validationErrors = append(validationErrors, err)
+		},
+	})
+
+	attachment := &attachments.Attachment{
+		ID:       "att_progress",
+		Size:     int64(len(content)),
+		Checksum: attachments.Checksum(content),
+		URL:      server.URL + "/att_progress",
+	}
+	if _, err := manager.DownloadAttachment(attachment, 0, 0); err != nil {
+		t.Fatalf("Failed to download attachment: %v", err)
+	}
+	if len(progress) < 2 {
+		t.Fatalf("Expected several progress reports, got %v", progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i][0] <= progress[i-1][0] {
+			t.Errorf("Expected increasing progress, got %v", progress)
+		}
+	}
+	if last := progress[len(progress)-1]; last[0] != int64(len(content)) || last[1] != int64(len(content)) {
+		t.Errorf("Expected final report of %d/%d, got %v", len(content), len(content), last)
+	}
+	if len(validationErrors) != 0 {
+		t.Errorf("Expected no validation failures, got %v", validationErrors)
+	}
+
+	attachment.Checksum = attachments.Checksum([]byte("something else"))
+	if _, err := manager.DownloadAttachment(attachment, 0, 0); err == nil {
+		t.Fatal("Expected checksum mismatch")
+	}
+	if len(validationErrors) != 1 || !strings.Contains(validationErrors[0].Error(), "checksum mismatch") {
+		t.Errorf("Expected checksum mismatch to be reported, got %v", validationErrors)
+	}
+}
@@ -1,12 +1,17 @@
 package test
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/auth"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
 )
 
 func TestGenerateNonce(t *testing.T) {
@@ -283,3 +288,326 @@ func TestAuthHeaderRoundTrip(t *testing.T) {
 		t.Errorf("Failed to verify parsed auth header: %v", err)
 	}
 }
+
+func TestKeyRingAuthHeader(t *testing.T) {
+	oldKey, _ := keymgmt.GenerateKeyPair()
+	newKey, _ := keymgmt.GenerateKeyPair()
+	ring := keymgmt.NewKeyRing(oldKey)
+
+	oldHeader, err := auth.GenerateKeyRingAuthHeader(ring, "GET", "/api/v1/messages")
+	if err != nil {
+		t.Fatalf("GenerateKeyRingAuthHeader failed: %v", err)
+	}
+	ring.Rotate(newKey)
+
+	// The key ID survives the header round trip
+	parsed, err := auth.ParseAuthHeader(oldHeader.ToHeaderValue())
+	if err != nil {
+		t.Fatalf("ParseAuthHeader failed: %v", err)
+	}
+	if parsed.KeyID != oldKey.KeyID() {
+		t.Errorf("Expected key ID %s, got %s", oldKey.KeyID(), parsed.KeyID)
+	}
+
+	// A header from the previous key is still accepted by the ring
+	if err := auth.VerifyAuthHeaderWithKeyRing(parsed, ring, "GET", "/api/v1/messages"); err != nil {
+		t.Errorf("Expected previous key's header to verify: %v", err)
+	}
+
+	stranger, _ := keymgmt.GenerateKeyPair()
+	strangerHeader, _ := auth.GenerateAuthHeader(stranger, "GET", "/api/v1/messages")
+	if err := auth.VerifyAuthHeaderWithKeyRing(strangerHeader, ring, "GET", "/api/v1/messages"); err == nil {
+		t.Error("Expected header from unknown key to fail")
+	}
+
+	// A key ID that does not match the public key is rejected
+	parsed.KeyID = newKey.KeyID()
+	if err := auth.VerifyAuthHeader(parsed, "GET", "/api/v1/messages"); err == nil {
+		t.Error("Expected mismatched key ID to fail")
+	}
+
+	// Headers without a key ID keep the original format
+	if strings.Contains(strangerHeader.ToHeaderValue(), "keyid=") {
+		t.Error("Expected no key ID in a plain auth header")
+	}
+}
+
+func TestDelegationToken(t *testing.T) {
+	accountKey, _ := keymgmt.GenerateKeyPair()
+	delegateKey, _ := keymgmt.GenerateKeyPair()
+
+	token, err := auth.IssueDelegationToken(accountKey, delegateKey.PublicKeyBase64(), &auth.DelegationScope{
+		Address:   "alerts#example.com",
+		Domains:   []string{"Example.com"},
+		ExpiresIn: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to issue delegation token: %v", err)
+	}
+
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode token: %v", err)
+	}
+	parsed, err := auth.ParseDelegationToken(encoded)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if err := parsed.Verify(accountKey.PublicKeyBase64()); err != nil {
+		t.Errorf("Expected token to verify against the account key: %v", err)
+	}
+	if err := parsed.Verify(delegateKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected token to fail verification against another key")
+	}
+
+	if err := parsed.Permits("alerts#example.com", []string{"ops#example.com"}); err != nil {
+		t.Errorf("Expected in-scope send to be permitted: %v", err)
+	}
+	if err := parsed.Permits("ceo#example.com", []string{"ops#example.com"}); err == nil {
+		t.Error("Expected sending as another address to be denied")
+	}
+	if err := parsed.Permits("alerts#example.com", []string{"ops#other.org"}); err == nil {
+		t.Error("Expected sending to another domain to be denied")
+	}
+	if parsed.IsExpired() || parsed.ValidAt(time.Now().Add(2*time.Hour)) {
+		t.Error("Expected token to be valid for one hour")
+	}
+
+	// Widening the scope breaks the account's signature
+	parsed.Domains = nil
+	if err := parsed.Verify(accountKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected tampered token to fail verification")
+	}
+}
+
+func TestDelegatedAuthHeader(t *testing.T) {
+	accountKey, _ := keymgmt.GenerateKeyPair()
+	delegateKey, _ := keymgmt.GenerateKeyPair()
+	otherKey, _ := keymgmt.GenerateKeyPair()
+
+	token, err := auth.IssueDelegationToken(accountKey, delegateKey.PublicKeyBase64(), &auth.DelegationScope{Address: "alerts#example.com"})
+	if err != nil {
+		t.Fatalf("Failed to issue delegation token: %v", err)
+	}
+
+	if _, err := auth.GenerateDelegatedAuthHeader(otherKey, token, "POST", "/api/v1/messages"); err == nil {
+		t.Error("Expected header signed by a key the token was not issued to to fail")
+	}
+
+	authHeader, err := auth.GenerateDelegatedAuthHeader(delegateKey, token, "POST", "/api/v1/messages")
+	if err != nil {
+		t.Fatalf("Failed to generate delegated auth header: %v", err)
+	}
+	parsed, err := auth.ParseAuthHeader(authHeader.ToHeaderValue())
+	if err != nil {
+		t.Fatalf("Failed to parse delegated auth header: %v", err)
+	}
+	if parsed.Delegation != authHeader.Delegation {
+		t.Error("Expected delegation token to survive the header round trip")
+	}
+	if err := auth.VerifyAuthHeader(parsed, "POST", "/api/v1/messages"); err != nil {
+		t.Errorf("Expected delegated auth header to verify: %v", err)
+	}
+
+	// A token issued to another key cannot be attached to our header
+	plain, _ := auth.GenerateAuthHeader(otherKey, "POST", "/api/v1/messages")
+	plain.Delegation = authHeader.Delegation
+	if err := auth.VerifyAuthHeader(plain, "POST", "/api/v1/messages"); err == nil {
+		t.Error("Expected header with another key's delegation token to fail")
+	}
+}
+
+func TestDelegatedMessageVerification(t *testing.T) {
+	accountKey, _ := keymgmt.GenerateKeyPair()
+	delegateKey, _ := keymgmt.GenerateKeyPair()
+
+	token, err := auth.IssueDelegationToken(accountKey, delegateKey.PublicKeyBase64(), &auth.DelegationScope{
+		Address: "alerts#example.com",
+		Domains: []string{"example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to issue delegation token: %v", err)
+	}
+	encoded, _ := token.Encode()
+
+	build := func(to string) *message.Message {
+		msg, err := message.NewMessageBuilder().From("alerts#example.com").To(to).Body("disk full").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		msg.Delegation = encoded
+		if err := msg.Sign(delegateKey); err != nil {
+			t.Fatalf("Failed to sign message: %v", err)
+		}
+		return msg
+	}
+
+	if status := build("ops#example.com").VerifySender(accountKey.PublicKeyBase64(), nil); status != message.VerificationVerified {
+		t.Errorf("Expected delegated message to verify, got %s", status)
+	}
+	if status := build("ops#other.org").VerifySender(accountKey.PublicKeyBase64(), nil); status != message.VerificationInvalid {
+		t.Errorf("Expected out-of-scope delegated message to be invalid, got %s", status)
+	}
+
+	// Without the token the delegate's signature does not match the sender's key
+	msg := build("ops#example.com")
+	msg.Delegation = ""
+	if status := msg.VerifySender(accountKey.PublicKeyBase64(), nil); status != message.VerificationInvalid {
+		t.Errorf("Expected message without its delegation token to be invalid, got %s", status)
+	}
+
+	// A limited token is judged at a timestamp close to the verifier's clock
+	limited, err := auth.IssueDelegationToken(accountKey, delegateKey.PublicKeyBase64(), &auth.DelegationScope{
+		Address:   "alerts#example.com",
+		ExpiresIn: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to issue delegation token: %v", err)
+	}
+	encoded, _ = limited.Encode()
+	if status := build("ops#example.com").VerifySender(accountKey.PublicKeyBase64(), nil); status != message.VerificationVerified {
+		t.Errorf("Expected message under a limited token to verify, got %s", status)
+	}
+	msg = build("ops#example.com")
+	msg.Timestamp = time.Now().Add(30 * time.Minute).Unix()
+	msg.TimestampMs = msg.Timestamp * 1000
+	msg.Sign(delegateKey)
+	if status := msg.VerifySender(accountKey.PublicKeyBase64(), nil); status != message.VerificationInvalid {
+		t.Errorf("Expected a timestamp outside the clock skew to be invalid, got %s", status)
+	}
+}
+
+func TestAuthHeaderReplayAndExpiry(t *testing.T) {
+	keyPair, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	authHeader, err := auth.GenerateAuthHeaderWithOptions(keyPair, "GET", "/api/v1/messages", &auth.HeaderOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to generate auth header: %v", err)
+	}
+	if authHeader.Expires-authHeader.Timestamp != 60 {
+		t.Errorf("Expected the header to expire a minute after its timestamp, got %d", authHeader.Expires-authHeader.Timestamp)
+	}
+	parsed, err := auth.ParseAuthHeader(authHeader.ToHeaderValue())
+	if err != nil {
+		t.Fatalf("Failed to parse auth header: %v", err)
+	}
+	if parsed.Expires != authHeader.Expires {
+		t.Errorf("Expected expiry %d to round-trip, got %d", authHeader.Expires, parsed.Expires)
+	}
+
+	// Each nonce is accepted once
+	nonces := auth.NewMemoryNonceCache(0)
+	options := &auth.VerifyOptions{Nonces: nonces}
+	if err := auth.VerifyAuthHeaderWithOptions(parsed, "GET", "/api/v1/messages", options); err != nil {
+		t.Fatalf("Expected the header to verify: %v", err)
+	}
+	if err := auth.VerifyAuthHeaderWithOptions(parsed, "GET", "/api/v1/messages", options); !errors.Is(err, auth.ErrAuthHeaderReplayed) {
+		t.Errorf("Expected the replayed header to be rejected, got %v", err)
+	}
+	if nonces.Len() != 1 {
+		t.Errorf("Expected one nonce recorded, got %d", nonces.Len())
+	}
+
+	// The expiry is signed and enforced
+	tampered := *parsed
+	tampered.Expires += 3600
+	if err := auth.VerifyAuthHeader(&tampered, "GET", "/api/v1/messages"); err == nil {
+		t.Error("Expected a header with a changed expiry to fail verification")
+	}
+	later := func() time.Time { return time.Now().Add(3 * time.Minute) }
+	if err := auth.VerifyAuthHeaderWithOptions(parsed, "GET", "/api/v1/messages", &auth.VerifyOptions{Now: later, MaxClockSkew: time.Minute}); !errors.Is(err, auth.ErrAuthHeaderExpired) {
+		t.Errorf("Expected the header to have expired, got %v", err)
+	}
+	if err := auth.VerifyAuthHeaderWithOptions(parsed, "GET", "/api/v1/messages", &auth.VerifyOptions{MaxTTL: 30 * time.Second}); err == nil {
+		t.Error("Expected a header valid for longer than MaxTTL to be rejected")
+	}
+
+	// A client whose clock is an hour behind signs with the server's time
+	skewed, err := auth.GenerateAuthHeaderWithOptions(keyPair, "GET", "/api/v1/messages", &auth.HeaderOptions{ClockOffset: -time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to generate auth header: %v", err)
+	}
+	if err := auth.VerifyAuthHeader(skewed, "GET", "/api/v1/messages"); err == nil {
+		t.Error("Expected a header an hour off to be rejected")
+	}
+	serverNow := func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := auth.VerifyAuthHeaderWithOptions(skewed, "GET", "/api/v1/messages", &auth.VerifyOptions{Now: serverNow}); err != nil {
+		t.Errorf("Expected the header to verify against the server's clock: %v", err)
+	}
+}
+
+func TestAuthHeaderWithoutExpiry(t *testing.T) {
+	keyPair, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	authHeader, err := auth.GenerateAuthHeaderWithOptions(keyPair, "GET", "/api/v1/messages", &auth.HeaderOptions{OmitExpiry: true, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to generate auth header: %v", err)
+	}
+	if authHeader.Expires != 0 || strings.Contains(authHeader.ToHeaderValue(), "expires=") {
+		t.Fatalf("Expected no expiry, got %d in %q", authHeader.Expires, authHeader.ToHeaderValue())
+	}
+
+	// The signature covers the baseline METHOD:PATH:TIMESTAMP:NONCE payload
+	payload := fmt.Sprintf("GET:/api/v1/messages:%d:%s", authHeader.Timestamp, authHeader.Nonce)
+	signature, err := base64.StdEncoding.DecodeString(authHeader.Signature)
+	if err != nil {
+		t.Fatalf("Failed to decode signature: %v", err)
+	}
+	if !ed25519.Verify(keyPair.PublicKey, []byte(payload), signature) {
+		t.Error("Expected the signature to cover the baseline payload")
+	}
+	if err := auth.VerifyAuthHeader(authHeader, "GET", "/api/v1/messages"); err != nil {
+		t.Errorf("Expected the header to verify: %v", err)
+	}
+}
+
+func TestMemoryNonceCacheLimits(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+
+	// One key filling its share cannot lock out other keys
+	nonces := auth.NewMemoryNonceCacheWithKeyLimit(10, 3)
+	for i := 0; i < 3; i++ {
+		if !nonces.Use("mallory", fmt.Sprintf("n%d", i), expiresAt) {
+			t.Fatalf("Expected nonce %d to be accepted", i)
+		}
+	}
+	if nonces.Use("mallory", "n3", expiresAt) {
+		t.Error("Expected a key over its limit to be refused")
+	}
+	if !nonces.Use("alice", "n0", expiresAt) {
+		t.Error("Expected another key to be accepted")
+	}
+	if nonces.Use("alice", "n0", expiresAt) {
+		t.Error("Expected a replayed nonce to be refused")
+	}
+
+	// A full cache forgets the nonce expiring soonest rather than refuse new ones
+	full := auth.NewMemoryNonceCacheWithKeyLimit(2, 2)
+	full.Use("alice", "soon", time.Now().Add(time.Second))
+	full.Use("bob", "later", time.Now().Add(time.Hour))
+	if !full.Use("carol", "new", expiresAt) {
+		t.Fatal("Expected a new nonce to be accepted by a full cache")
+	}
+	if full.Len() != 2 {
+		t.Errorf("Expected the cache to stay at two nonces, got %d", full.Len())
+	}
+	if full.Use("bob", "later", time.Now().Add(time.Hour)) {
+		t.Error("Expected the nonce expiring latest to be kept")
+	}
+	if !full.Use("alice", "soon", time.Now().Add(time.Second)) {
+		t.Error("Expected the nonce expiring soonest to have been forgotten")
+	}
+
+	// Expired nonces no longer count against their key
+	expiring := auth.NewMemoryNonceCacheWithKeyLimit(10, 1)
+	expiring.Use("alice", "old", time.Now().Add(-time.Second))
+	if !expiring.Use("alice", "new", expiresAt) {
+		t.Error("Expected an expired nonce to free its key's slot")
+	}
+}
@@ -1,16 +1,41 @@
 package test
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	gorillaws "github.com/gorilla/websocket"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
 	"github.com/emsg-protocol/emsg-client-sdk/client"
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
 	"github.com/emsg-protocol/emsg-client-sdk/groups"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/metrics"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/tracing"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+	"github.com/emsg-protocol/emsg-client-sdk/websocket"
 )
 
 // TestRetryStrategy tests the retry strategy configuration
@@ -96,7 +121,10 @@ func TestClientConfiguration(t *testing.T) {
 	}
 
 	// Create client
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 	if emsgClient == nil {
 		t.Fatal("Failed to create client")
 	}
@@ -146,7 +174,10 @@ func TestClientConfiguration(t *testing.T) {
 // TestClientWithNilConfig tests client creation with nil config
 func TestClientWithNilConfig(t *testing.T) {
 	// Test that client can be created with nil config (should use defaults)
-	emsgClient := client.New(nil)
+	emsgClient, err := client.New(nil)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 	if emsgClient == nil {
 		t.Fatal("Failed to create client with nil config")
 	}
@@ -173,7 +204,10 @@ func TestSystemMessageComposer(t *testing.T) {
 	// Create client
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test system message composer
 	systemBuilder := emsgClient.ComposeSystemMessage()
@@ -218,7 +252,10 @@ func TestHookErrorHandling(t *testing.T) {
 		return fmt.Errorf("before send hook error")
 	}
 
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Create test message
 	msg, err := emsgClient.ComposeMessage().
@@ -330,7 +367,10 @@ func TestClientKeyPairManagement(t *testing.T) {
 	// Create client with first key pair
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair1
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test getting key pair
 	retrievedKeyPair := emsgClient.GetKeyPair()
@@ -363,7 +403,10 @@ func TestClientGroupManagement(t *testing.T) {
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
 	config.EnableGroupManagement = true
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test that group management is enabled
 	if !emsgClient.IsGroupManagementEnabled() {
@@ -504,7 +547,10 @@ func TestClientGroupManagementDisabled(t *testing.T) {
 	config := client.DefaultConfig()
 	config.KeyPair = keyPair
 	config.EnableGroupManagement = false
-	emsgClient := client.New(config)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
 
 	// Test that group management is disabled
 	if emsgClient.IsGroupManagementEnabled() {
@@ -527,3 +573,2885 @@ func TestClientGroupManagementDisabled(t *testing.T) {
 		t.Error("Expected error when group management is disabled")
 	}
 }
+
+// TestDomainOverrides tests that per-domain settings take precedence over defaults
+func TestDomainOverrides(t *testing.T) {
+	slowRetry := &client.RetryStrategy{
+		MaxRetries:    6,
+		InitialDelay:  2 * time.Second,
+		MaxDelay:      time.Minute,
+		BackoffFactor: 2.0,
+		RetryOn429:    true,
+	}
+
+	config := client.DefaultConfig()
+	config.Timeout = 10 * time.Second
+	config.DomainOverrides = map[string]*client.DomainOverride{
+		"*":                    {Timeout: 20 * time.Second},
+		"*.partner.org":        {Timeout: 2 * time.Minute, RetryStrategy: slowRetry},
+		"fast.partner.org":     {Timeout: 1 * time.Second},
+		"internal.example.com": {Timeout: 500 * time.Millisecond},
+	}
+
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	testCases := []struct {
+		domain  string
+		timeout time.Duration
+	}{
+		{"internal.example.com", 500 * time.Millisecond},
+		{"mail.partner.org", 2 * time.Minute},
+		{"fast.partner.org", 1 * time.Second},
+		{"other.net", 20 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if timeout := emsgClient.GetDomainTimeout(tc.domain); timeout != tc.timeout {
+			t.Errorf("Domain %s: expected timeout %v, got %v", tc.domain, tc.timeout, timeout)
+		}
+	}
+
+	if strategy := emsgClient.GetDomainRetryStrategy("mail.partner.org"); strategy != slowRetry {
+		t.Error("Expected partner override retry strategy")
+	}
+
+	if strategy := emsgClient.GetDomainRetryStrategy("fast.partner.org"); strategy.MaxRetries != config.RetryStrategy.MaxRetries {
+		t.Errorf("Expected default retry strategy for fast.partner.org, got MaxRetries %d", strategy.MaxRetries)
+	}
+
+	// Without overrides the client defaults apply
+	plainClient, err := client.New(client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if timeout := plainClient.GetDomainTimeout("example.com"); timeout != 30*time.Second {
+		t.Errorf("Expected default timeout 30s, got %v", timeout)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// doerFunc adapts a function to client.HTTPDoer
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestCustomHTTPClient tests injecting the HTTP client used for all client traffic
+func TestCustomHTTPClient(t *testing.T) {
+	var requested []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("remote data")),
+			Header:     make(http.Header),
+			Request:    req,
+		}, nil
+	})
+
+	config := client.DefaultConfig()
+	config.HTTPClient = &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	config.AttachmentConfig.StorageDir = ""
+	config.DomainOverrides = map[string]*client.DomainOverride{
+		"slow.example.com": {Timeout: time.Minute},
+	}
+
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Attachment downloads go through the injected transport, without a server
+	data, err := emsgClient.DownloadAttachment(&attachments.Attachment{
+		ID:  "att_remote",
+		URL: "https://files.example.com/report.pdf",
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to download attachment: %v", err)
+	}
+	if string(data) != "remote data" || len(requested) != 1 {
+		t.Errorf("Expected download through custom transport, got %q after %d requests", data, len(requested))
+	}
+
+	if timeout := emsgClient.GetDomainTimeout("example.com"); timeout != 10*time.Second {
+		t.Errorf("Expected custom client timeout 10s, got %v", timeout)
+	}
+	if timeout := emsgClient.GetDomainTimeout("slow.example.com"); timeout != time.Minute {
+		t.Errorf("Expected override timeout 1m, got %v", timeout)
+	}
+
+	// A non-*http.Client doer is accepted, but cannot have its timeout overridden
+	config = client.DefaultConfig()
+	config.HTTPClient = doerFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("offline")
+	})
+	if _, err := client.New(config); err != nil {
+		t.Fatalf("Expected custom doer to be accepted, got %v", err)
+	}
+
+	config.DomainOverrides = map[string]*client.DomainOverride{
+		"slow.example.com": {Timeout: time.Minute},
+	}
+	var configErrs client.ConfigErrors
+	if _, err := client.New(config); !errors.As(err, &configErrs) {
+		t.Errorf("Expected ConfigErrors for timeout override on custom doer, got %v", err)
+	}
+}
+
+// TestClientInfoAdvertisement tests the client-info envelope settings
+func TestClientInfoAdvertisement(t *testing.T) {
+	config := client.DefaultConfig()
+	config.AttachmentConfig.StorageDir = ""
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	info := emsgClient.GetClientInfo()
+	if info == nil || info.Name != client.SDKName || info.Version != client.SDKVersion {
+		t.Fatalf("Expected SDK client info, got %+v", info)
+	}
+	if !info.Supports(message.FeatureAttachments) || !info.Supports(message.FeatureGroups) {
+		t.Errorf("Expected attachments and groups to be advertised, got %v", info.Features)
+	}
+	if info.Supports(message.FeatureEncryption) {
+		t.Error("Encryption should not be advertised while disabled")
+	}
+
+	encKeys, _ := encryption.GenerateEncryptionKeyPair()
+	emsgClient.EnableEncryption(encKeys, encryption.NewMemoryKeyStore())
+	if !emsgClient.GetClientInfo().Supports(message.FeatureEncryption) {
+		t.Error("Expected encryption to be advertised once enabled")
+	}
+
+	if _, known := emsgClient.PeerSupports("bob#example.com", message.FeatureEncryption); known {
+		t.Error("Expected unknown support for a peer that never advertised")
+	}
+
+	// Advertised info can be replaced, e.g. to hide the version
+	config.ClientInfo = &message.ClientInfo{Name: "my-app"}
+	emsgClient, err = client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if info := emsgClient.GetClientInfo(); info.Name != "my-app" || info.Version != "" {
+		t.Errorf("Expected overridden client info, got %+v", info)
+	}
+
+	// Advertisement can be disabled entirely
+	config.AdvertiseClientInfo = false
+	emsgClient, err = client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if info := emsgClient.GetClientInfo(); info != nil {
+		t.Errorf("Expected no client info when disabled, got %+v", info)
+	}
+}
+
+// TestProcessKeyBundle tests capturing and pinning a sender key bundle from a first-contact message
+func TestProcessKeyBundle(t *testing.T) {
+	senderSigningKey, _ := keymgmt.GenerateKeyPair()
+	senderEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	senderManager := encryption.NewEncryptionManager(senderEncKey, encryption.NewMemoryKeyStore())
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#example.com").
+		Body("Hello Bob").
+		KeyBundle(senderManager.CreateKeyBundle("alice#example.com", senderSigningKey.PublicKeyBase64())).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := msg.Sign(senderSigningKey); err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+
+	recipientEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	config := client.DefaultConfig()
+	config.EncryptionConfig = &encryption.EncryptionConfig{
+		Enabled:  true,
+		KeyPair:  recipientEncKey,
+		KeyStore: encryption.NewMemoryKeyStore(),
+	}
+	// Alice's signing key as published on her domain; nobody else's resolves
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		if address == "alice#example.com" {
+			return senderSigningKey.PublicKeyBase64(), nil
+		}
+		return "", fmt.Errorf("no key published for %s", address)
+	})
+	recipient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// A bundle that vouches only for itself is not pinned: Mallory claims Alice's
+	// address and signs with a key of her own
+	mallorySigningKey, _ := keymgmt.GenerateKeyPair()
+	malloryEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	impostor, _ := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#example.com").
+		Body("Hello Bob").
+		KeyBundle(encryption.NewEncryptionManager(malloryEncKey, encryption.NewMemoryKeyStore()).CreateKeyBundle("alice#example.com", mallorySigningKey.PublicKeyBase64())).
+		Build()
+	impostor.Sign(mallorySigningKey)
+	if impostor.VerifyKeyBundle() != nil {
+		t.Fatal("Expected the impostor's bundle to be self-consistent")
+	}
+	if pinned, err := recipient.ProcessKeyBundle(impostor); err == nil || pinned {
+		t.Error("Expected a bundle not carrying the sender's published signing key to be rejected")
+	}
+
+	// A sender whose signing key cannot be resolved gets no bundle pinned
+	unknown := msg.Clone()
+	unknown.From = "carol#example.com"
+	unknown.KeyBundle = senderManager.CreateKeyBundle("carol#example.com", senderSigningKey.PublicKeyBase64())
+	unknown.Sign(senderSigningKey)
+	if _, err := recipient.ProcessKeyBundle(unknown); err == nil {
+		t.Error("Expected a bundle from a sender without a resolvable signing key to be rejected")
+	}
+
+	pinned, err := recipient.ProcessKeyBundle(msg)
+	if err != nil {
+		t.Fatalf("Failed to process key bundle: %v", err)
+	}
+	if !pinned {
+		t.Error("Expected sender key to be pinned")
+	}
+	if !recipient.CanEncryptFor("alice#example.com") {
+		t.Error("Recipient should be able to reply encrypted")
+	}
+
+	// A bundle claiming another sender's address is rejected
+	forged := msg.Clone()
+	forged.KeyBundle = &encryption.KeyBundle{
+		Address:       "mallory#example.com",
+		EncryptionKey: senderEncKey.PublicKeyBase64(),
+		SigningKey:    senderSigningKey.PublicKeyBase64(),
+	}
+	if _, err := recipient.ProcessKeyBundle(forged); err == nil {
+		t.Error("Expected error for bundle address mismatch")
+	}
+
+	// A bundle whose signing key did not sign the message is rejected
+	otherSigningKey, _ := keymgmt.GenerateKeyPair()
+	tampered := msg.Clone()
+	tampered.KeyBundle = &encryption.KeyBundle{
+		Address:       "alice#example.com",
+		EncryptionKey: senderEncKey.PublicKeyBase64(),
+		SigningKey:    otherSigningKey.PublicKeyBase64(),
+	}
+	if _, err := recipient.ProcessKeyBundle(tampered); err == nil {
+		t.Error("Expected error for bundle signed with a different key")
+	}
+
+	if recipient.HasContacted("alice#example.com") {
+		t.Error("No key bundle has been sent yet")
+	}
+}
+
+// TestKeyRotationValidation tests that a failed rotation leaves the current key in place
+func TestKeyRotationValidation(t *testing.T) {
+	oldKeyPair, _ := keymgmt.GenerateKeyPair()
+	newKeyPair, _ := keymgmt.GenerateKeyPair()
+
+	emsgClient, err := client.NewWithKeyPair(oldKeyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	hookCalled := false
+	emsgClient.RegisterKeyRotationHook(func(oldKP, newKP *keymgmt.KeyPair) error {
+		hookCalled = true
+		return nil
+	})
+
+	if err := emsgClient.RotateKeyPair("alice#example.com", nil); err == nil {
+		t.Error("Expected error when rotating to a nil key pair")
+	}
+
+	if err := emsgClient.RotateKeyPair("invalid-address", newKeyPair); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+
+	if emsgClient.GetKeyPair() != oldKeyPair {
+		t.Error("Key pair should be unchanged after failed rotation")
+	}
+
+	if hookCalled {
+		t.Error("Rotation hooks should not run when rotation fails before the swap")
+	}
+
+	noKeyClient, err := client.New(client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := noKeyClient.RotateKeyPair("alice#example.com", newKeyPair); err == nil {
+		t.Error("Expected error when no key pair is configured")
+	}
+}
+
+func TestTransportSelector(t *testing.T) {
+	config := client.DefaultTransportSelectionConfig()
+	config.ProbeInterval = 0
+	config.MinSamples = 1
+	selector := client.NewTransportSelector(config)
+
+	if got := selector.Select(100, false, 0, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP when WebSocket is disconnected, got %s", got)
+	}
+
+	if got := selector.Select(config.MaxWebSocketPayload+1, true, 0, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP for large payload, got %s", got)
+	}
+
+	if got := selector.Select(100, true, 90, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP when WebSocket queue is congested, got %s", got)
+	}
+
+	if got := selector.Select(100, true, 0, 100); got != client.TransportWebSocket {
+		t.Errorf("Expected WebSocket without latency samples, got %s", got)
+	}
+
+	selector.RecordLatency(client.TransportHTTP, 10*time.Millisecond)
+	selector.RecordLatency(client.TransportWebSocket, 100*time.Millisecond)
+	if got := selector.Select(100, true, 0, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP when WebSocket is much slower, got %s", got)
+	}
+
+	selector.RecordSend(client.TransportHTTP, nil)
+	selector.RecordSend(client.TransportHTTP, fmt.Errorf("boom"))
+	stats := selector.Stats()[client.TransportHTTP]
+	if stats.Sends != 2 || stats.Failures != 1 {
+		t.Errorf("Expected 2 sends and 1 failure, got %d and %d", stats.Sends, stats.Failures)
+	}
+	if stats.AverageLatency != 10*time.Millisecond {
+		t.Errorf("Expected average latency 10ms, got %v", stats.AverageLatency)
+	}
+}
+
+func TestTransportErrorBudget(t *testing.T) {
+	config := client.DefaultTransportSelectionConfig()
+	config.ProbeInterval = 0
+	config.MinErrorSamples = 4
+	config.ErrorRateThreshold = 0.5
+	selector := client.NewTransportSelector(config)
+
+	clock := utils.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	selector.SetClock(clock)
+
+	var reports []*client.TransportDiagnostics
+	selector.SetDiagnosticsHandler(func(d *client.TransportDiagnostics) {
+		reports = append(reports, d)
+	})
+
+	// Failures below the sample minimum do not bias selection
+	for i := 0; i < 3; i++ {
+		selector.RecordOutcome(client.TransportWebSocket, fmt.Errorf("read error"))
+	}
+	if got := selector.Select(100, true, 0, 100); got != client.TransportWebSocket {
+		t.Errorf("Expected WebSocket before enough samples, got %s", got)
+	}
+
+	selector.RecordSend(client.TransportWebSocket, fmt.Errorf("write error"))
+	for i := 0; i < 4; i++ {
+		selector.RecordOutcome(client.TransportHTTP, nil)
+	}
+	if got := selector.AvoidedTransport(); got != client.TransportWebSocket {
+		t.Fatalf("Expected WebSocket to be avoided, got %q", got)
+	}
+	if got := selector.Select(100, true, 0, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP while WebSocket exceeds its error budget, got %s", got)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("Expected one diagnostics report, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.Avoided != client.TransportWebSocket || report.Preferred() != client.TransportHTTP {
+		t.Errorf("Expected switch from WebSocket to HTTP, got %+v", report)
+	}
+	if ws := report.Windows[client.TransportWebSocket]; ws.Attempts != 4 || ws.ErrorRate() != 1 {
+		t.Errorf("Expected 4 failed WebSocket attempts in report, got %+v", ws)
+	}
+
+	// Payload limits still override the error budget
+	if got := selector.Select(config.MaxWebSocketPayload+1, true, 0, 100); got != client.TransportHTTP {
+		t.Errorf("Expected HTTP for large payload, got %s", got)
+	}
+
+	// A new window starts with a clean budget
+	clock.Advance(time.Hour)
+	if got := selector.Select(100, true, 0, 100); got != client.TransportWebSocket {
+		t.Errorf("Expected WebSocket after the error window rolled over, got %s", got)
+	}
+	if len(reports) != 2 || reports[1].Avoided != "" || reports[1].Previous != client.TransportWebSocket {
+		t.Errorf("Expected recovery report, got %d reports", len(reports))
+	}
+
+	windows := selector.ErrorWindows(client.TransportWebSocket)
+	if len(windows) != 1 || windows[0].Failures != 4 {
+		t.Errorf("Expected one recorded WebSocket window with 4 failures, got %+v", windows)
+	}
+
+	// A threshold of zero disables the error budget
+	config.ErrorRateThreshold = 0
+	disabled := client.NewTransportSelector(config)
+	for i := 0; i < 10; i++ {
+		disabled.RecordOutcome(client.TransportWebSocket, fmt.Errorf("read error"))
+	}
+	if got := disabled.AvoidedTransport(); got != "" {
+		t.Errorf("Expected no avoided transport when disabled, got %q", got)
+	}
+}
+
+func TestSendMessageViaWebSocket(t *testing.T) {
+	received := make(chan *message.Message, 4)
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			if frame.Type != "message" {
+				continue
+			}
+			received <- frame.Message
+			data, _ := json.Marshal(map[string]any{"message_id": frame.Message.MessageID})
+			conn.WriteJSON(&websocket.WebSocketMessage{Type: "ack", CorrelationID: frame.CorrelationID, Data: data})
+		}
+	}))
+	defer server.Close()
+
+	var hookCalls atomic.Int32
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.EnableDeliveryTracking = true
+	config.BeforeSendContext = func(ctx context.Context, msg *message.Message) error {
+		hookCalls.Add(1)
+		return nil
+	}
+	config.DNSConfig = &dns.ResolverConfig{Retries: 1, LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		return []string{server.URL}, nil
+	}}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+	if err := emsgClient.ConnectWebSocket("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+
+	// A message goes through the same preparation and signing as over HTTP
+	msg, _ := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body("over the socket").Build()
+	if err := emsgClient.SendMessageVia(msg, client.TransportWebSocket); err != nil {
+		t.Fatalf("Failed to send over WebSocket: %v", err)
+	}
+	select {
+	case sent := <-received:
+		if err := sent.Verify(config.KeyPair.PublicKeyBase64()); err != nil {
+			t.Errorf("Expected the message to arrive signed: %v", err)
+		}
+		if sent.Sequence == 0 || sent.ClientInfo == nil {
+			t.Errorf("Expected a sequence and client info, got %d and %v", sent.Sequence, sent.ClientInfo)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the message")
+	}
+	if hookCalls.Load() != 1 {
+		t.Errorf("Expected the before-send hook to run once, ran %d times", hookCalls.Load())
+	}
+	if receipt, err := emsgClient.GetDeliveryReceipt(msg.MessageID); err != nil || receipt.Status != delivery.StatusSent {
+		t.Errorf("Expected the acked message to be sent: %+v, %v", receipt, err)
+	}
+
+	// Invalid messages never reach the socket
+	invalid := &message.Message{From: "alice#example.com", To: []string{"not an address"}, Body: "hi", Timestamp: time.Now().Unix()}
+	if err := emsgClient.SendMessageVia(invalid, client.TransportWebSocket); err == nil {
+		t.Error("Expected a message to an invalid address to be rejected")
+	}
+	select {
+	case sent := <-received:
+		t.Errorf("Expected nothing sent for an invalid message, got %+v", sent)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLazyAttachmentInitialization(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	storageDir := filepath.Join(blocker, "attachments")
+
+	config := client.DefaultConfig()
+	config.AttachmentConfig = attachments.DefaultAttachmentConfig()
+	config.AttachmentConfig.StorageDir = storageDir
+
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if !c.IsAttachmentManagerEnabled() {
+		t.Error("Attachment support should be enabled when configured")
+	}
+
+	// A regular file appearing where the storage directory should go makes initialization fail
+	if err := os.WriteFile(blocker, []byte("x"), 0600); err != nil {
+		t.Fatalf("Failed to create blocker file: %v", err)
+	}
+
+	if err := c.Init(); err == nil {
+		t.Error("Expected Init to report attachment storage failure")
+	}
+
+	if _, err := c.CreateAttachmentFromData("a.txt", []byte("data"), "text/plain"); err == nil {
+		t.Error("Expected attachment use to report initialization failure")
+	}
+
+	// Storage is not touched until first use
+	goodDir := filepath.Join(t.TempDir(), "lazy")
+	config.AttachmentConfig = attachments.DefaultAttachmentConfig()
+	config.AttachmentConfig.StorageDir = goodDir
+	c, err = client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := os.Stat(goodDir); !os.IsNotExist(err) {
+		t.Error("Storage directory should not be created by New")
+	}
+	if err := c.InitAttachments(); err != nil {
+		t.Fatalf("InitAttachments failed: %v", err)
+	}
+	if _, err := os.Stat(goodDir); err != nil {
+		t.Errorf("Storage directory should exist after InitAttachments: %v", err)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	if _, err := client.New(client.DefaultConfig()); err != nil {
+		t.Fatalf("Default config should be valid: %v", err)
+	}
+
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, []byte("x"), 0600); err != nil {
+		t.Fatalf("Failed to create blocker file: %v", err)
+	}
+
+	config := client.DefaultConfig()
+	config.KeyPair = &keymgmt.KeyPair{PrivateKey: make([]byte, 10), PublicKey: make([]byte, 32)}
+	config.EncryptionConfig = &encryption.EncryptionConfig{Enabled: true}
+	config.EnableNotifications = true
+	config.PollInterval = 0
+	config.AttachmentConfig.StorageDir = filepath.Join(blocker, "attachments")
+	config.EnableKeyDiscovery = true
+	config.KeyDiscoveryTTL = 0
+	config.KeyDiscoveryNegativeTTL = -time.Minute
+	config.ProbeCapabilities = false
+	config.AutoUploadAttachments = true
+	config.RequestPollInterval = -time.Second
+	config.EnableGroupManagement = false
+	config.SyncGroups = true
+	config.RestartPolicies = map[client.Subsystem]*client.RestartPolicy{
+		client.SubsystemWebSocket: {Restart: true, InitialDelay: -time.Second},
+	}
+	config.MaintenanceSchedule = &client.MaintenanceSchedule{Interval: time.Hour, Windows: []client.MaintenanceWindow{{Start: 2 * time.Hour, End: 2 * time.Hour}}}
+
+	c, err := client.New(config)
+	if c != nil {
+		t.Error("Expected nil client for invalid config")
+	}
+
+	var configErrs client.ConfigErrors
+	if !errors.As(err, &configErrs) {
+		t.Fatalf("Expected ConfigErrors, got %T: %v", err, err)
+	}
+
+	fields := make(map[string]bool)
+	for _, configErr := range configErrs {
+		fields[configErr.Field] = true
+	}
+	for _, field := range []string{
+		"KeyPair",
+		"EncryptionConfig.KeyPair",
+		"EncryptionConfig.KeyStore",
+		"PollInterval",
+		"AttachmentConfig.StorageDir",
+		"KeyDiscoveryTTL",
+		"KeyDiscoveryNegativeTTL",
+		"AutoUploadAttachments",
+		"RequestPollInterval",
+		"SyncGroups",
+		"RestartPolicies[websocket]",
+		"MaintenanceSchedule",
+	} {
+		if !fields[field] {
+			t.Errorf("Expected error for %s, got %v", field, err)
+		}
+	}
+
+	var single *client.ConfigError
+	if !errors.As(err, &single) {
+		t.Error("Expected errors.As to find an individual ConfigError")
+	}
+}
+
+func TestMemoryProfile(t *testing.T) {
+	if client.MemoryProfileStandard.Limits() != nil {
+		t.Error("Expected no limits for the standard profile")
+	}
+
+	config := client.DefaultConfig()
+	config.MemoryProfile = "tiny"
+	var configErrs client.ConfigErrors
+	if _, err := client.New(config); !errors.As(err, &configErrs) || configErrs[0].Field != "MemoryProfile" {
+		t.Errorf("Expected MemoryProfile config error, got %v", err)
+	}
+
+	config = client.DefaultConfig()
+	config.MemoryProfile = client.MemoryProfileLow
+	config.AttachmentConfig.StorageDir = t.TempDir()
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create low-memory client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	limits := emsgClient.GetMemoryLimits()
+	if limits == nil || limits.DeliveryReceipts <= 0 {
+		t.Fatalf("Expected low-memory limits, got %+v", limits)
+	}
+
+	// Attachments above the profile's inline limit are chunked at its chunk size
+	attachment, err := emsgClient.CreateAttachmentFromData("log.txt", make([]byte, limits.AttachmentInlineLimit+1), "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	if len(attachment.Data) != 0 || len(attachment.Chunks) != 2 {
+		t.Errorf("Expected 2 chunks, got %d chunks and %d inline bytes", len(attachment.Chunks), len(attachment.Data))
+	}
+
+	// The default profile leaves a 1MB inline limit
+	config = client.DefaultConfig()
+	config.AttachmentConfig.StorageDir = t.TempDir()
+	standard, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer standard.Close()
+	if attachment, err := standard.CreateAttachmentFromData("log.txt", make([]byte, limits.AttachmentInlineLimit+1), "text/plain"); err != nil || len(attachment.Chunks) != 0 {
+		t.Errorf("Expected inline attachment with the standard profile, got %v", err)
+	}
+
+}
+
+func TestPlanAttachmentDelivery(t *testing.T) {
+	newAttachment := func(id string, size int) *attachments.Attachment {
+		data := make([]byte, size)
+		hash := sha256.Sum256(data)
+		return &attachments.Attachment{
+			ID:       id,
+			Size:     int64(size),
+			Data:     data,
+			Checksum: base64.StdEncoding.EncodeToString(hash[:]),
+		}
+	}
+
+	msg := &message.Message{
+		From: "alice#example.com",
+		To:   []string{"bob#a.org", "carol#b.org"},
+		Attachments: []*attachments.Attachment{
+			newAttachment("small", 100),
+			newAttachment("large", 5000),
+		},
+	}
+
+	capabilities := map[string]*client.ServerCapabilities{
+		"a.org": {MaxInlineSize: 1000, MaxChunkSize: 2048, Features: []string{client.FeatureChunkedAttachments}},
+		"b.org": {MaxInlineSize: 4096, Features: []string{client.FeatureChunkedAttachments, client.FeatureAttachmentURLs}},
+	}
+
+	plan, err := client.PlanAttachmentDelivery(msg, capabilities)
+	if err != nil {
+		t.Fatalf("PlanAttachmentDelivery failed: %v", err)
+	}
+	if plan["small"] != attachments.DeliveryInline {
+		t.Errorf("Expected small attachment inline, got %s", plan["small"])
+	}
+	if plan["large"] != attachments.DeliveryChunked {
+		t.Errorf("Expected large attachment chunked, got %s", plan["large"])
+	}
+
+	if err := client.ApplyAttachmentPlan(msg, plan, capabilities); err != nil {
+		t.Fatalf("ApplyAttachmentPlan failed: %v", err)
+	}
+	large := msg.GetAttachmentByID("large")
+	if len(large.Chunks) != 3 || large.Data != nil {
+		t.Errorf("Expected 3 chunks of at most 2048 bytes, got %d", len(large.Chunks))
+	}
+	if err := large.Verify(); err != nil {
+		t.Errorf("Chunked attachment failed verification: %v", err)
+	}
+
+	// No chunking or URL support: the large attachment cannot be delivered
+	capabilities["b.org"] = &client.ServerCapabilities{MaxInlineSize: 1000, MaxAttachmentSize: 2000}
+	_, err = client.PlanAttachmentDelivery(msg, capabilities)
+
+	var payloadErr *client.UnsupportedPayloadError
+	if !errors.As(err, &payloadErr) {
+		t.Fatalf("Expected UnsupportedPayloadError, got %v", err)
+	}
+	if payloadErr.Domain != "b.org" || payloadErr.AttachmentID != "large" || payloadErr.Limit != 2000 {
+		t.Errorf("Unexpected error details: %+v", payloadErr)
+	}
+	if payloadErr.Guidance == "" {
+		t.Error("Expected guidance in UnsupportedPayloadError")
+	}
+
+	// An attachment with a URL can be sent by reference when both servers accept URLs
+	large.URL = "https://files.example.com/large"
+	for _, caps := range capabilities {
+		caps.Features = []string{client.FeatureAttachmentURLs}
+	}
+	plan, err = client.PlanAttachmentDelivery(msg, capabilities)
+	if err != nil {
+		t.Fatalf("PlanAttachmentDelivery failed: %v", err)
+	}
+	if plan["large"] != attachments.DeliveryURL {
+		t.Errorf("Expected large attachment by URL, got %s", plan["large"])
+	}
+
+	// Message size limit is checked after the layout is applied
+	capabilities["a.org"].MaxMessageSize = 50
+	if err := client.ApplyAttachmentPlan(msg, plan, capabilities); !errors.As(err, &payloadErr) {
+		t.Errorf("Expected UnsupportedPayloadError for message size, got %v", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := emsgClient.ResolveDomainContext(ctx, "example.com"); err == nil {
+		t.Error("Expected error resolving with a cancelled context")
+	}
+
+	msg, _ := emsgClient.ComposeMessage().
+		From("alice#example.com").
+		To("bob#example.com").
+		Body("hello").
+		Build()
+	if err := emsgClient.SendMessageContext(ctx, msg); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from SendMessageContext, got %v", err)
+	}
+
+	if _, err := emsgClient.GetMessagesContext(ctx, "alice#example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from GetMessagesContext, got %v", err)
+	}
+
+	if err := emsgClient.RegisterUserContext(ctx, "alice#example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from RegisterUserContext, got %v", err)
+	}
+}
+
+func TestUndecryptableRetention(t *testing.T) {
+	aliceKeys, _ := encryption.GenerateEncryptionKeyPair()
+	bobKeys, _ := encryption.GenerateEncryptionKeyPair()
+	staleKeys, _ := encryption.GenerateEncryptionKeyPair()
+
+	senderStore := encryption.NewMemoryKeyStore()
+	sender := encryption.NewEncryptionManager(aliceKeys, senderStore)
+	if err := sender.RegisterPublicKey("bob#example.com", bobKeys.PublicKeyBase64()); err != nil {
+		t.Fatalf("Failed to register recipient key: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#example.com").
+		Body("secret").
+		MessageID("msg-1").
+		WithEncryption(sender).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build encrypted message: %v", err)
+	}
+	if !msg.IsEncrypted() {
+		t.Fatal("Expected message to be encrypted")
+	}
+
+	decrypted := make(chan *notifications.Notification, 1)
+	config := client.DefaultConfig()
+	config.EnableNotifications = true
+	config.NotificationHandlers[notifications.EventMessageDecrypted] = []notifications.NotificationHandler{
+		func(n *notifications.Notification) error {
+			decrypted <- n
+			return nil
+		},
+	}
+	config.EncryptionConfig = &encryption.EncryptionConfig{
+		Enabled:  true,
+		KeyPair:  staleKeys,
+		KeyStore: encryption.NewMemoryKeyStore(),
+	}
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Decrypting with the wrong key fails and retains the ciphertext
+	if _, err := c.DecryptMessage(msg); err == nil {
+		t.Fatal("Expected decryption with the wrong key to fail")
+	}
+	pending := c.GetUndecryptableMessages()
+	if len(pending) != 1 || pending[0].Message.MessageID != "msg-1" || pending[0].LastError == nil {
+		t.Fatalf("Expected msg-1 to be retained, got %+v", pending)
+	}
+	if recovered := c.RetryUndecryptable(); len(recovered) != 0 {
+		t.Errorf("Expected nothing recovered with unchanged keys, got %d", len(recovered))
+	}
+
+	// Installing the right key re-attempts decryption automatically
+	c.EnableEncryption(bobKeys, encryption.NewMemoryKeyStore())
+
+	select {
+	case n := <-decrypted:
+		if n.Message.MessageID != "msg-1" || n.Metadata["decrypted_body"] != "secret" {
+			t.Errorf("Unexpected decryption notification: %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a message_decrypted notification")
+	}
+	if pending := c.GetUndecryptableMessages(); len(pending) != 0 {
+		t.Errorf("Expected no retained messages after recovery, got %d", len(pending))
+	}
+
+	body, err := c.DecryptMessage(msg)
+	if err != nil || body != "secret" {
+		t.Errorf("Expected decrypted body %q, got %q (%v)", "secret", body, err)
+	}
+
+	// Retention can be disabled
+	config.RetainUndecryptable = false
+	config.NotificationHandlers = nil
+	config.EnableNotifications = false
+	c, err = client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	c.DecryptMessage(msg)
+	if pending := c.GetUndecryptableMessages(); len(pending) != 0 {
+		t.Errorf("Expected no retention when disabled, got %d", len(pending))
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	// Message validation
+	_, err := message.NewMessageBuilder().To("bob#example.com").Body("hi").Build()
+	if !errors.Is(err, client.ErrValidation) {
+		t.Errorf("Expected missing sender to match ErrValidation, got %v", err)
+	}
+	var validationErr *message.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "from" {
+		t.Errorf("Expected ValidationError for field from, got %v", err)
+	}
+
+	// Configuration validation
+	config := client.DefaultConfig()
+	config.MaxDeliveryProofs = -1
+	if _, err := client.New(config); !errors.Is(err, client.ErrValidation) {
+		t.Errorf("Expected invalid config to match ErrValidation, got %v", err)
+	}
+
+	c, err := client.New(client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Domain resolution
+	_, err = c.ResolveDomain("")
+	if !errors.Is(err, client.ErrDomainResolution) {
+		t.Errorf("Expected empty domain to match ErrDomainResolution, got %v", err)
+	}
+	var resolutionErr *dns.ResolutionError
+	if !errors.As(err, &resolutionErr) {
+		t.Errorf("Expected dns.ResolutionError, got %T", err)
+	}
+	wrapped := fmt.Errorf("send failed: %w", &client.ResolveError{Domain: "example.com", Err: errors.New("no such host")})
+	if !errors.Is(wrapped, client.ErrDomainResolution) {
+		t.Error("Expected ResolveError to match ErrDomainResolution")
+	}
+
+	// Encryption
+	if err := c.RegisterPublicKey("bob#example.com", "key"); !errors.Is(err, client.ErrEncryptionUnavailable) {
+		t.Errorf("Expected ErrEncryptionUnavailable, got %v", err)
+	}
+
+	// HTTP status mapping
+	throttled := fmt.Errorf("request failed: %w", &client.HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Second})
+	if !errors.Is(throttled, client.ErrRateLimited) {
+		t.Error("Expected 429 to match ErrRateLimited")
+	}
+	if errors.Is(throttled, client.ErrUnauthorized) {
+		t.Error("Did not expect 429 to match ErrUnauthorized")
+	}
+	if delay, ok := client.RetryAfter(throttled); !ok || delay != 30*time.Second {
+		t.Errorf("Expected RetryAfter of 30s, got %v (%v)", delay, ok)
+	}
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		if !errors.Is(&client.HTTPError{StatusCode: status}, client.ErrUnauthorized) {
+			t.Errorf("Expected %d to match ErrUnauthorized", status)
+		}
+	}
+	if _, ok := client.RetryAfter(&client.HTTPError{StatusCode: http.StatusBadGateway}); ok {
+		t.Error("Did not expect a RetryAfter delay without a header")
+	}
+
+	locked := &client.AccountLockedError{Address: "alice#example.com", RetryAfter: time.Minute}
+	if !errors.Is(locked, client.ErrRateLimited) {
+		t.Error("Expected AccountLockedError to match ErrRateLimited")
+	}
+	if delay, ok := client.RetryAfter(locked); !ok || delay != time.Minute {
+		t.Errorf("Expected RetryAfter of 1m for locked account, got %v", delay)
+	}
+}
+
+func TestIncomingVerification(t *testing.T) {
+	senderKeys, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	otherKeys, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	lookups := 0
+	config := client.DefaultConfig()
+	config.VerifyIncoming = client.VerifyReject
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		lookups++
+		if address == "alice#example.com" {
+			return senderKeys.PublicKeyBase64(), nil
+		}
+		return "", fmt.Errorf("no key published for %s", address)
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	newMessage := func(from string, signer *keymgmt.KeyPair) *message.Message {
+		msg, err := message.NewMessageBuilder().From(from).To("bob#example.com").Body("hello").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		if signer != nil {
+			if err := msg.Sign(signer); err != nil {
+				t.Fatalf("Failed to sign message: %v", err)
+			}
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name string
+		msg  *message.Message
+		want message.VerificationStatus
+	}{
+		{"valid signature", newMessage("alice#example.com", senderKeys), message.VerificationVerified},
+		{"forged signature", newMessage("alice#example.com", otherKeys), message.VerificationInvalid},
+		{"unsigned", newMessage("alice#example.com", nil), message.VerificationUnsigned},
+		{"unknown sender", newMessage("mallory#example.com", otherKeys), message.VerificationKeyUnavailable},
+	}
+	for _, tt := range tests {
+		status, err := emsgClient.VerifyMessage(tt.msg)
+		if err != nil {
+			t.Errorf("%s: VerifyMessage failed: %v", tt.name, err)
+		}
+		if status != tt.want || tt.msg.VerificationStatus != tt.want {
+			t.Errorf("%s: expected %q, got %q (message %q)", tt.name, tt.want, status, tt.msg.VerificationStatus)
+		}
+		if tt.msg.IsVerified() != (tt.want == message.VerificationVerified) {
+			t.Errorf("%s: IsVerified disagrees with status %q", tt.name, status)
+		}
+	}
+
+	// Keys, including failed lookups, are cached per sender
+	if lookups != 2 {
+		t.Errorf("Expected 2 key lookups, got %d", lookups)
+	}
+	emsgClient.ForgetSigningKey("alice#example.com")
+	emsgClient.VerifyMessage(newMessage("alice#example.com", senderKeys))
+	if lookups != 3 {
+		t.Errorf("Expected a fresh lookup after ForgetSigningKey, got %d lookups", lookups)
+	}
+
+	// The status is local and does not change the signed payload
+	msg := newMessage("alice#example.com", senderKeys)
+	msg.VerificationStatus = message.VerificationInvalid
+	if err := msg.Verify(senderKeys.PublicKeyBase64()); err != nil {
+		t.Errorf("Expected VerificationStatus to be excluded from the signature: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	emsgClient.ForgetSigningKey("alice#example.com")
+	if _, err := emsgClient.VerifyMessageContext(ctx, newMessage("alice#example.com", senderKeys)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	config = client.DefaultConfig()
+	config.VerifyIncoming = client.IncomingVerification(7)
+	if _, err := client.New(config); err == nil || !strings.Contains(err.Error(), "VerifyIncoming") {
+		t.Errorf("Expected invalid VerifyIncoming to be rejected, got %v", err)
+	}
+}
+
+func TestSigningKeyRevocation(t *testing.T) {
+	senderKeys, _ := keymgmt.GenerateKeyPair()
+	var lookups atomic.Int32
+
+	upgrader := gorillaws.Upgrader{}
+	push := make(chan *websocket.WebSocketMessage, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for frame := range push {
+			conn.WriteJSON(frame)
+		}
+	}))
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.SigningKeyTTL = time.Hour
+	config.DNSConfig = &dns.ResolverConfig{Retries: 1, LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		return []string{server.URL}, nil
+	}}
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		lookups.Add(1)
+		return senderKeys.PublicKeyBase64(), nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	verify := func() message.VerificationStatus {
+		msg, _ := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body("hello").Build()
+		msg.Sign(senderKeys)
+		status, err := emsgClient.VerifyMessage(msg)
+		if err != nil {
+			t.Fatalf("VerifyMessage failed: %v", err)
+		}
+		return status
+	}
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if status := verify(); status != message.VerificationVerified {
+		t.Fatalf("Expected verified, got %q", status)
+	}
+	verify()
+	if lookups.Load() != 1 {
+		t.Errorf("Expected the key to be cached, got %d lookups", lookups.Load())
+	}
+
+	// A pushed key change drops the cached key
+	if err := emsgClient.ConnectWebSocket("bob#example.com"); err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	defer emsgClient.DisconnectWebSocket()
+	push <- &websocket.WebSocketMessage{Type: "event", Event: "key_changed", Data: json.RawMessage(`{"address":"alice#Example.com"}`)}
+	waitFor("key change", func() bool {
+		verify()
+		return lookups.Load() == 2
+	})
+
+	// A pushed revocation refuses the key even though it is still published
+	keyID := keymgmt.KeyID(senderKeys.PublicKey)
+	push <- &websocket.WebSocketMessage{Type: "event", Event: "key_revoked", Data: json.RawMessage(`{"address":"alice#example.com","key_id":"` + keyID + `","reason":"compromised"}`)}
+	waitFor("key revocation", func() bool { return verify() == message.VerificationKeyRevoked })
+	close(push)
+
+	// Revocations are also applied directly, by public key or key ID
+	other, _ := keymgmt.GenerateKeyPair()
+	emsgClient.RevokeSigningKey("carol#example.com", other.PublicKeyBase64())
+	msg, _ := message.NewMessageBuilder().From("carol#example.com").To("bob#example.com").Body("hi").Build()
+	msg.Sign(senderKeys)
+	if status, _ := emsgClient.VerifyMessage(msg); status != message.VerificationVerified {
+		t.Errorf("Expected another key of a sender to stay trusted, got %q", status)
+	}
+
+	config = client.DefaultConfig()
+	config.SigningKeyTTL = -time.Second
+	if _, err := client.New(config); err == nil || !strings.Contains(err.Error(), "SigningKeyTTL") {
+		t.Errorf("Expected negative SigningKeyTTL to be rejected, got %v", err)
+	}
+}
+
+func TestClientKeyRing(t *testing.T) {
+	oldKey, _ := keymgmt.GenerateKeyPair()
+	newKey, _ := keymgmt.GenerateKeyPair()
+	ring := keymgmt.NewKeyRing(oldKey)
+
+	config := client.DefaultConfig()
+	config.KeyRing = ring
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if emsgClient.GetKeyPair() != oldKey || emsgClient.GetKeyRing() != ring {
+		t.Error("Expected the key ring's current key to be used")
+	}
+
+	// Replacing the key keeps the old one in the ring
+	emsgClient.SetKeyPair(newKey)
+	if ring.Current() != newKey {
+		t.Error("Expected SetKeyPair to rotate the key ring")
+	}
+	if _, ok := ring.Lookup(oldKey.KeyID()); !ok {
+		t.Error("Expected the replaced key to stay in the ring")
+	}
+
+	if err := emsgClient.RetireKey("alice#example.com", newKey.KeyID()); err == nil {
+		t.Error("Expected retiring the current key to fail")
+	}
+
+	config = client.DefaultConfig()
+	config.KeyRing = keymgmt.NewKeyRing(oldKey)
+	config.KeyPair = newKey
+	if _, err := client.New(config); err == nil || !strings.Contains(err.Error(), "KeyRing") {
+		t.Errorf("Expected conflicting KeyPair and KeyRing to be rejected, got %v", err)
+	}
+
+	plain, err := client.NewWithKeyPair(oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := plain.RetireKey("alice#example.com", oldKey.KeyID()); err == nil {
+		t.Error("Expected RetireKey without a key ring to fail")
+	}
+}
+
+// TestDeleteMessageReleasesAttachmentFiles tests that materialized attachments go away with their message
+func TestDeleteMessageReleasesAttachmentFiles(t *testing.T) {
+	config := client.DefaultConfig()
+	config.AttachmentConfig.StorageDir = ""
+	config.AttachmentConfig.TempDir = t.TempDir()
+	config.MessageStore = store.NewMemoryMessageStore()
+
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	msg := &message.Message{MessageID: "msg-1", From: "alice#example.com", To: []string{"bob#example.com"}}
+	if err := config.MessageStore.Save(msg); err != nil {
+		t.Fatalf("Failed to store message: %v", err)
+	}
+
+	attachment, err := emsgClient.CreateAttachmentFromData("notes.txt", []byte("attached notes"), "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	file, err := emsgClient.MaterializeAttachment(msg.MessageID, attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize attachment: %v", err)
+	}
+	other, err := emsgClient.MaterializeAttachment("msg-2", attachment)
+	if err != nil {
+		t.Fatalf("Failed to materialize attachment: %v", err)
+	}
+
+	if err := emsgClient.DeleteMessage(msg.MessageID); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		t.Error("Attachment file still exists after message deletion")
+	}
+	if _, err := os.Stat(other.Path); err != nil {
+		t.Errorf("Other message's attachment file was removed: %v", err)
+	}
+
+	if err := emsgClient.Close(); err != nil {
+		t.Fatalf("Failed to close client: %v", err)
+	}
+	if _, err := os.Stat(other.Path); !os.IsNotExist(err) {
+		t.Error("Attachment file still exists after Close")
+	}
+
+	config.AttachmentConfig.TempFileTTL = -time.Second
+	var configErrs client.ConfigErrors
+	if _, err := client.New(config); !errors.As(err, &configErrs) {
+		t.Errorf("Expected ConfigErrors for negative TempFileTTL, got %v", err)
+	}
+}
+
+func TestRequestResponseValidation(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	c, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	plain, err := c.ComposeMessage().From("alice#example.com").To("bot#example.com").Body("status").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+
+	var validationErr *message.ValidationError
+	if err := c.RespondTo(plain, "bot#example.com", "ok"); !errors.As(err, &validationErr) || validationErr.Field != "correlation_id" {
+		t.Errorf("Expected correlation_id validation error responding to a plain message, got %v", err)
+	}
+
+	plain.CorrelationID = "req-1"
+	reply, err := c.ComposeReply(plain).From("bot#example.com").Body("ok").Build()
+	if err != nil {
+		t.Fatalf("Failed to build reply: %v", err)
+	}
+	if _, err := c.SendRequest(reply, time.Second); !errors.As(err, &validationErr) || validationErr.Field != "in_reply_to" {
+		t.Errorf("Expected in_reply_to validation error sending a reply as a request, got %v", err)
+	}
+	if c.GetPendingRequestCount() != 0 {
+		t.Errorf("Expected no pending requests, got %d", c.GetPendingRequestCount())
+	}
+}
+
+func TestRequestReplyOverWebSocket(t *testing.T) {
+	requests := make(chan *message.Message, 1)
+	pushes := make(chan *message.Message, 4)
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for msg := range pushes {
+				if err := conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: msg}); err != nil {
+					return
+				}
+			}
+		case "/api/v1/messages":
+			body, _ := io.ReadAll(r.Body)
+			if msg, err := message.FromJSON(body); err == nil {
+				requests <- msg
+			}
+			w.Write([]byte(`{"status": "ok"}`))
+		}
+	}))
+	defer server.Close()
+	defer close(pushes)
+
+	bobKeys, _ := keymgmt.GenerateKeyPair()
+	malloryKeys, _ := keymgmt.GenerateKeyPair()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.VerifyIncoming = client.VerifyFlag
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		if address == "bob#example.com" {
+			return bobKeys.PublicKeyBase64(), nil
+		}
+		return "", fmt.Errorf("no key published for %s", address)
+	})
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+	if err := emsgClient.ConnectWebSocket("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+
+	type result struct {
+		reply *message.Message
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		msg, _ := emsgClient.ComposeMessage().From("alice#example.com").To("bob#example.com").Body("status?").Build()
+		reply, err := emsgClient.SendRequest(msg, 2*time.Second)
+		results <- result{reply, err}
+	}()
+
+	var request *message.Message
+	select {
+	case request = <-requests:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the request")
+	}
+
+	newReply := func(from, body string, signer *keymgmt.KeyPair) *message.Message {
+		reply, err := message.NewMessageBuilder().ReplyTo(request).From(from).Body(body).Build()
+		if err != nil {
+			t.Fatalf("Failed to build reply: %v", err)
+		}
+		if signer != nil {
+			if err := reply.Sign(signer); err != nil {
+				t.Fatalf("Failed to sign reply: %v", err)
+			}
+		}
+		return reply
+	}
+
+	// Forged and unsigned replies never answer the request, even with flagging on
+	pushes <- newReply("bob#example.com", "forged", malloryKeys)
+	pushes <- newReply("bob#example.com", "unsigned", nil)
+	select {
+	case r := <-results:
+		t.Fatalf("Expected unverified replies to be ignored, got %+v, %v", r.reply, r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The recipient's domain matches however its case is written
+	pushes <- newReply("bob#Example.COM", "all good", bobKeys)
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("SendRequest failed: %v", r.err)
+		}
+		if r.reply.Body != "all good" || !r.reply.IsVerified() {
+			t.Errorf("Expected the verified reply, got %q (%s)", r.reply.Body, r.reply.VerificationStatus)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the reply")
+	}
+	if emsgClient.GetPendingRequestCount() != 0 {
+		t.Errorf("Expected no pending requests, got %d", emsgClient.GetPendingRequestCount())
+	}
+}
+
+func TestDelegatedClient(t *testing.T) {
+	accountKey, _ := keymgmt.GenerateKeyPair()
+	delegateKey, _ := keymgmt.GenerateKeyPair()
+
+	account, err := client.NewWithKeyPair(accountKey)
+	if err != nil {
+		t.Fatalf("Failed to create account client: %v", err)
+	}
+	token, err := account.IssueDelegationToken(delegateKey.PublicKeyBase64(), &auth.DelegationScope{Address: "alerts#example.com"})
+	if err != nil {
+		t.Fatalf("Failed to issue delegation token: %v", err)
+	}
+	encoded, _ := token.Encode()
+
+	var configErrs client.ConfigErrors
+	if _, err := client.NewDelegated(accountKey, encoded); !errors.As(err, &configErrs) || configErrs[0].Field != "DelegationToken" {
+		t.Errorf("Expected DelegationToken config error for a key the token was not issued to, got %v", err)
+	}
+
+	delegate, err := client.NewDelegated(delegateKey, encoded)
+	if err != nil {
+		t.Fatalf("Failed to create delegate client: %v", err)
+	}
+	if delegate.GetDelegationToken() == nil {
+		t.Fatal("Expected delegate client to hold its token")
+	}
+	if _, err := delegate.IssueDelegationToken(accountKey.PublicKeyBase64(), &auth.DelegationScope{Address: "alerts#example.com"}); err == nil {
+		t.Error("Expected a delegate to be unable to issue tokens")
+	}
+
+	// Sends outside the token's scope fail before anything is resolved
+	msg, err := delegate.ComposeMessage().From("ceo#example.com").To("ops#example.com").Body("hi").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := delegate.SendMessage(msg); !errors.Is(err, client.ErrDelegationDenied) {
+		t.Errorf("Expected ErrDelegationDenied, got %v", err)
+	}
+
+	if err := account.LoadDelegationToken(encoded); err == nil {
+		t.Error("Expected loading a token issued to another key to fail")
+	}
+}
+
+// flakyTask is a subsystem whose runs end when the test fails them
+type flakyTask struct {
+	mutex  sync.Mutex
+	done   chan struct{}
+	err    error
+	starts int
+}
+
+func newFlakyTask() *flakyTask {
+	return &flakyTask{done: make(chan struct{})}
+}
+
+func (f *flakyTask) Done() <-chan struct{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.done
+}
+
+func (f *flakyTask) Err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+func (f *flakyTask) Restart(ctx context.Context) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.done = make(chan struct{})
+	f.err = nil
+	f.starts++
+	return nil
+}
+
+func (f *flakyTask) end(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.err = err
+	close(f.done)
+}
+
+func (f *flakyTask) startCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.starts
+}
+
+func (f *flakyTask) task() client.SupervisedTask {
+	return client.SupervisedTask{Done: f.Done, Err: f.Err, Restart: f.Restart}
+}
+
+func waitForState(t *testing.T, supervisor *client.Supervisor, state client.SubsystemState) client.SubsystemHealth {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		health := supervisor.Health()
+		if len(health) == 1 && health[0].State == state {
+			return health[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Subsystem did not reach state %s: %+v", state, health)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSupervisorRestartsFailedSubsystem(t *testing.T) {
+	failures := make(chan *client.SubsystemFailure, 1)
+	supervisor := client.NewSupervisor(nil, func(failure *client.SubsystemFailure) {
+		failures <- failure
+	})
+	defer supervisor.Stop()
+
+	policy := &client.RestartPolicy{
+		Restart:       true,
+		InitialDelay:  time.Millisecond,
+		MaxDelay:      10 * time.Millisecond,
+		BackoffFactor: 2,
+		MaxRestarts:   2,
+		Window:        time.Minute,
+	}
+	task := newFlakyTask()
+	supervisor.Watch(client.SubsystemMessagePoller, policy, task.task())
+
+	boom := errors.New("boom")
+	for restarts := 1; restarts <= 2; restarts++ {
+		task.end(boom)
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			health := supervisor.Health()[0]
+			if health.Restarts == restarts && health.State == client.SubsystemRunning {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Subsystem was not restarted (restart %d): %+v", restarts, health)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// A third failure within the window exceeds the cap
+	task.end(boom)
+	select {
+	case failure := <-failures:
+		if failure.Subsystem != client.SubsystemMessagePoller || failure.Restarts != 2 || !errors.Is(failure, boom) {
+			t.Errorf("Unexpected failure report: %v", failure)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Persistent failure was not reported")
+	}
+
+	health := waitForState(t, supervisor, client.SubsystemFailed)
+	if !errors.Is(health.LastError, boom) {
+		t.Errorf("Expected last error to be recorded, got %v", health.LastError)
+	}
+	if starts := task.startCount(); starts != 2 {
+		t.Errorf("Expected 2 restarts, got %d", starts)
+	}
+}
+
+func TestSupervisorIgnoresDeliberateStop(t *testing.T) {
+	supervisor := client.NewSupervisor(nil, nil)
+	defer supervisor.Stop()
+
+	task := newFlakyTask()
+	supervisor.Watch(client.SubsystemWebSocket, &client.RestartPolicy{Restart: true}, task.task())
+	task.end(nil)
+
+	waitForState(t, supervisor, client.SubsystemStopped)
+	if starts := task.startCount(); starts != 0 {
+		t.Errorf("Stopped subsystem should not be restarted, got %d restarts", starts)
+	}
+
+	supervisor.Unwatch(client.SubsystemWebSocket)
+	if health := supervisor.Health(); len(health) != 0 {
+		t.Errorf("Expected no supervised subsystems after Unwatch, got %+v", health)
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	policy := &client.RestartPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, BackoffFactor: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for recent, want := range expected {
+		if got := policy.Delay(recent); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", recent, got, want)
+		}
+	}
+}
+
+func TestResponseInfoFinal(t *testing.T) {
+	for disposition, final := range map[client.RequestDisposition]bool{
+		client.DispositionSucceeded: true,
+		client.DispositionRetrying:  false,
+		client.DispositionFailed:    true,
+		client.DispositionCancelled: true,
+	} {
+		info := &client.ResponseInfo{Disposition: disposition}
+		if info.Final() != final {
+			t.Errorf("Final() for %s = %v, want %v", disposition, info.Final(), final)
+		}
+	}
+}
+
+func TestRequestHooks(t *testing.T) {
+	var failures, received atomic.Int32
+	var block atomic.Bool
+	var cancelSend context.CancelFunc
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		received.Add(1)
+		if block.Load() {
+			cancelSend()
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	var mutex sync.Mutex
+	var requests []client.RequestInfo
+	var responses []client.ResponseInfo
+	var veto error
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.RetryStrategy = &client.RetryStrategy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, BackoffFactor: 2, RetryOn429: true}
+	config.OnRequest = func(ctx context.Context, info *client.RequestInfo) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.HasSuffix(info.URL, "/api/v1/messages") {
+			requests = append(requests, *info)
+		}
+		return veto
+	}
+	config.OnResponse = func(ctx context.Context, info *client.ResponseInfo) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.HasSuffix(info.Request.URL, "/api/v1/messages") {
+			responses = append(responses, *info)
+		}
+	}
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	// send delivers a message and returns the attempts and outcomes the hooks saw
+	send := func(ctx context.Context) (*message.Message, []client.RequestInfo, []client.ResponseInfo, error) {
+		t.Helper()
+		mutex.Lock()
+		requests, responses = nil, nil
+		mutex.Unlock()
+		received.Store(0)
+
+		msg, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#example.com").Body("audited").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		sendErr := emsgClient.SendMessageContext(ctx, msg)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return msg, append([]client.RequestInfo{}, requests...), append([]client.ResponseInfo{}, responses...), sendErr
+	}
+	checkAttempts := func(name string, msg *message.Message, requests []client.RequestInfo, responses []client.ResponseInfo, want []client.RequestDisposition) {
+		t.Helper()
+		if len(requests) != len(want) || len(responses) != len(want) {
+			t.Fatalf("%s: expected %d requests and responses, got %d and %d", name, len(want), len(requests), len(responses))
+		}
+		for i, disposition := range want {
+			request, response := requests[i], responses[i]
+			if request.Attempt != i+1 || request.MaxAttempts != 3 || response.Request.Attempt != i+1 {
+				t.Errorf("%s: attempt %d reported as %d of %d", name, i+1, request.Attempt, request.MaxAttempts)
+			}
+			if request.Domain != "example.com" || request.Method != "POST" || request.MessageID != msg.MessageID || request.PayloadSize == 0 {
+				t.Errorf("%s: unexpected request info %+v", name, request)
+			}
+			if request.PublicKey != config.KeyPair.PublicKeyBase64() {
+				t.Errorf("%s: expected the request to name the signing key", name)
+			}
+			if response.Disposition != disposition || response.Final() != (i == len(want)-1) {
+				t.Errorf("%s: attempt %d disposition %s (final %v), want %s", name, i+1, response.Disposition, response.Final(), disposition)
+			}
+		}
+	}
+
+	// A rate-limited attempt is retried and the next one succeeds
+	failures.Store(1)
+	msg, requests, responses, err := send(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the retried send to succeed: %v", err)
+	}
+	checkAttempts("retried", msg, requests, responses, []client.RequestDisposition{client.DispositionRetrying, client.DispositionSucceeded})
+	if responses[0].StatusCode != http.StatusTooManyRequests || responses[0].Err == nil || responses[1].StatusCode != http.StatusOK || responses[1].Err != nil {
+		t.Errorf("Unexpected outcomes %+v", responses)
+	}
+
+	// The last allowed attempt gives the request up
+	failures.Store(5)
+	msg, requests, responses, err = send(context.Background())
+	if err == nil {
+		t.Fatal("Expected the send to fail after its retries")
+	}
+	checkAttempts("failed", msg, requests, responses, []client.RequestDisposition{client.DispositionRetrying, client.DispositionRetrying, client.DispositionFailed})
+
+	// A context ending during an attempt cancels the request
+	block.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelSend = cancel
+	msg, requests, responses, err = send(ctx)
+	block.Store(false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	checkAttempts("cancelled", msg, requests, responses, []client.RequestDisposition{client.DispositionCancelled})
+
+	// An error from OnRequest aborts the request before it is sent
+	veto = errors.New("destination not approved")
+	_, requests, responses, err = send(context.Background())
+	if !errors.Is(err, veto) {
+		t.Errorf("Expected the hook's error, got %v", err)
+	}
+	if len(requests) != 1 || len(responses) != 0 || received.Load() != 0 {
+		t.Errorf("Expected one vetoed attempt and nothing sent, got %d requests, %d responses and %d received", len(requests), len(responses), received.Load())
+	}
+}
+
+func TestSigningKeyLookupsCoalesced(t *testing.T) {
+	senderKeys, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	config := client.DefaultConfig()
+	config.KeyDiscoveryNegativeTTL = 0
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		lookups.Add(1)
+		<-release
+		return senderKeys.PublicKeyBase64(), nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// A burst of messages from a new sender is verified concurrently
+	const burst = 5
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body("hello").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		if err := msg.Sign(senderKeys); err != nil {
+			t.Fatalf("Failed to sign message: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, err := emsgClient.VerifyMessage(msg); err != nil || status != message.VerificationVerified {
+				t.Errorf("Expected verified message, got %q: %v", status, err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("Expected one key lookup for the burst, got %d", n)
+	}
+}
+
+func TestClientAttachmentNotifications(t *testing.T) {
+	events := make(chan *notifications.Notification, 4)
+	handler := func(n *notifications.Notification) error {
+		events <- n
+		return nil
+	}
+
+	config := client.DefaultConfig()
+	config.EnableNotifications = true
+	config.NotificationHandlers[notifications.EventAttachmentQuarantined] = []notifications.NotificationHandler{handler}
+	config.NotificationHandlers[notifications.EventAttachmentValidationFailed] = []notifications.NotificationHandler{handler}
+	config.AttachmentConfig = attachments.DefaultAttachmentConfig()
+	config.AttachmentConfig.StorageDir = t.TempDir()
+	config.AttachmentConfig.Scanner = func(attachment *attachments.Attachment, data []byte) error {
+		if strings.HasSuffix(attachment.Name, ".exe") {
+			return errors.New("executable content")
+		}
+		return nil
+	}
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	tampered, _ := c.CreateAttachmentFromData("notes.txt", []byte("hello"), "text/plain")
+	tampered.Data = []byte("jello")
+	if err := c.ValidateAttachment(tampered); err == nil {
+		t.Fatal("Expected tampered attachment to fail validation")
+	}
+	n := <-events
+	if n.Event != notifications.EventAttachmentValidationFailed || n.Metadata["attachment_id"] != tampered.ID {
+		t.Errorf("Expected validation failure for %s, got %s %v", tampered.ID, n.Event, n.Metadata)
+	}
+
+	executable, _ := c.CreateAttachmentFromData("setup.exe", []byte("MZ"), "application/octet-stream")
+	if err := c.ValidateAttachment(executable); !errors.Is(err, attachments.ErrQuarantined) {
+		t.Fatalf("Expected quarantine, got %v", err)
+	}
+	n = <-events
+	if n.Event != notifications.EventAttachmentQuarantined || n.Metadata["name"] != "setup.exe" || n.Metadata["reason"] != "executable content" {
+		t.Errorf("Unexpected quarantine notification %s %v", n.Event, n.Metadata)
+	}
+	if quarantined, _ := c.IsAttachmentQuarantined(executable.ID); !quarantined {
+		t.Error("Expected attachment to be quarantined")
+	}
+	if err := c.ReleaseAttachmentQuarantine(executable.ID); err != nil {
+		t.Fatalf("Failed to release quarantine: %v", err)
+	}
+	if quarantined, _ := c.IsAttachmentQuarantined(executable.ID); quarantined {
+		t.Error("Expected quarantine to be released")
+	}
+}
+
+func TestClientTypingAndPresenceOffline(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	c, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Typing indicators are dropped without a WebSocket connection
+	if err := c.SendTyping("team#example.com", true); err != nil {
+		t.Errorf("Expected typing to be a no-op while disconnected, got %v", err)
+	}
+	if err := c.SetPresence("sleeping"); err == nil {
+		t.Error("Expected unknown presence status to be rejected")
+	}
+	if err := c.SetPresence(websocket.PresenceAway); err == nil {
+		t.Error("Expected error setting presence before the WebSocket was ever connected")
+	}
+}
+
+func TestClientIdentities(t *testing.T) {
+	config := client.DefaultConfig()
+	config.Identities = []string{"alice#example.com", "not-an-address"}
+	if _, err := client.New(config); err == nil {
+		t.Error("Expected invalid identity to be rejected")
+	}
+
+	config.Identities = []string{"alice#Example.com", "support#example.org"}
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := c.AddIdentity("alias"); err == nil {
+		t.Error("Expected invalid alias to be rejected")
+	}
+	if err := c.AddIdentity("ali#example.com"); err != nil {
+		t.Fatalf("Failed to add alias: %v", err)
+	}
+	c.RemoveIdentity("support#EXAMPLE.org")
+
+	if got := strings.Join(c.Identities(), " "); got != "ali#example.com alice#example.com" {
+		t.Errorf("Unexpected identities: %s", got)
+	}
+
+	msg := &message.Message{MessageID: "m1", From: "bob#example.net", To: []string{"alice#example.com"}}
+	if got := c.GetAddressedIdentities(msg); got != nil {
+		t.Errorf("Expected no identities for a message never received, got %v", got)
+	}
+}
+
+func TestRunConformance(t *testing.T) {
+	if _, err := client.RunConformance(context.Background(), "", nil); err == nil {
+		t.Error("Expected error for empty domain")
+	}
+	if _, err := client.RunConformance(context.Background(), "example.com", &client.ConformanceOptions{Scenarios: []client.ConformanceScenario{"federation"}}); err == nil {
+		t.Error("Expected error for unknown scenario")
+	}
+
+	// A run whose discovery fails records the failure and skips everything after it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := client.RunConformance(ctx, "example.com", &client.ConformanceOptions{
+		Scenarios: []client.ConformanceScenario{client.ConformanceFetch},
+	})
+	if err != nil {
+		t.Fatalf("RunConformance failed: %v", err)
+	}
+
+	want := []client.ConformanceScenario{client.ConformanceDiscovery, client.ConformanceRegistration, client.ConformanceSend, client.ConformanceFetch}
+	if len(report.Results) != len(want) {
+		t.Fatalf("Expected fetch and its dependencies to run, got %d results", len(report.Results))
+	}
+	for i, result := range report.Results {
+		if result.Scenario != want[i] {
+			t.Errorf("Expected result %d to be %s, got %s", i, want[i], result.Scenario)
+		}
+	}
+	if discovery := report.Result(client.ConformanceDiscovery); discovery.Status != client.ConformanceFail || discovery.Error == "" {
+		t.Errorf("Expected discovery to fail with an error, got %+v", discovery)
+	}
+	if fetch := report.Result(client.ConformanceFetch); fetch.Status != client.ConformanceSkip || fetch.Error != "requires send" {
+		t.Errorf("Expected fetch to be skipped, got %+v", fetch)
+	}
+	if report.Result(client.ConformanceGroups) != nil {
+		t.Error("Expected unselected scenarios not to run")
+	}
+
+	passed, failed, skipped := report.Counts()
+	if passed != 0 || failed != 1 || skipped != 3 || report.Passed() {
+		t.Errorf("Unexpected counts: %d passed, %d failed, %d skipped", passed, failed, skipped)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	config := client.DefaultConfig()
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := emsgClient.Stream(context.Background(), "alice#example.com"); err == nil {
+		t.Error("Expected error streaming without a key pair")
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	config.KeyPair = keyPair
+	config.PollInterval = 0
+	emsgClient, _ = client.New(config)
+	if _, err := emsgClient.Stream(context.Background(), "alice#example.com"); err == nil {
+		t.Error("Expected error streaming without a poll interval")
+	}
+
+	config.PollInterval = time.Minute
+	emsgClient, _ = client.New(config)
+	if _, err := emsgClient.Stream(context.Background(), "not-an-address"); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+
+	// The channel is closed once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events, err := emsgClient.Stream(ctx, "alice#example.com")
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	select {
+	case _, open := <-events:
+		if open {
+			t.Error("Expected no events from a cancelled stream")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the stream to close")
+	}
+	if emsgClient.IsWebSocketConnected() {
+		t.Error("Expected no WebSocket left connected")
+	}
+}
+
+func TestClientStreamVerifiesPushes(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	pushes := make(chan *message.Message, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ws" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for msg := range pushes {
+			if err := conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: msg}); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(pushes)
+
+	aliceKeys, _ := keymgmt.GenerateKeyPair()
+	malloryKeys, _ := keymgmt.GenerateKeyPair()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.PollInterval = time.Minute
+	config.VerifyIncoming = client.VerifyReject
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		return aliceKeys.PublicKeyBase64(), nil
+	})
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := emsgClient.Stream(ctx, "bob#example.com")
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+
+	newMessage := func(body string, signer *keymgmt.KeyPair) *message.Message {
+		msg, _ := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body(body).Build()
+		msg.MessageID = "m1"
+		msg.Sign(signer)
+		return msg
+	}
+
+	// A forged push is dropped and does not suppress the genuine message with its ID
+	pushes <- newMessage("forged", malloryKeys)
+	pushes <- newMessage("genuine", aliceKeys)
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != client.IncomingMessage {
+				continue
+			}
+			if event.Message.Body != "genuine" || !event.Message.IsVerified() {
+				t.Fatalf("Expected only the verified message, got %q (%s)", event.Message.Body, event.Message.VerificationStatus)
+			}
+			return
+		case <-deadline:
+			t.Fatal("Timed out waiting for the pushed message")
+		}
+	}
+}
+
+func TestClientResync(t *testing.T) {
+	var connections, posted atomic.Int32
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server's clock is an hour ahead
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		switch r.URL.Path {
+		case "/api/v1/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			connections.Add(1)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		case "/api/v1/messages":
+			posted.Add(1)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	outbox := store.NewMemoryOutboxStore()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Outbox = outbox
+	config.OutboxInterval = time.Hour
+	config.WakeCheckInterval = time.Minute
+	config.DNSConfig = &dns.ResolverConfig{Retries: 1, LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		return []string{server.URL}, nil
+	}}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	if _, measured := emsgClient.ClockOffset(); measured {
+		t.Error("Expected no clock offset before any response")
+	}
+	if err := emsgClient.ConnectWebSocket("bob#example.com"); err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+
+	// A queued message falls due while the sender waits for its next tick
+	msg, _ := message.NewMessageBuilder().From("bob#example.com").To("alice#example.com").Body("after sleep").Build()
+	now := time.Now().Round(0)
+	outbox.Put(&store.OutboxEntry{Message: msg, EnqueuedAt: now, NextAttempt: now.Add(50 * time.Millisecond)})
+	if err := emsgClient.StartOutboxSender(); err != nil {
+		t.Fatalf("Failed to start outbox sender: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if posted.Load() != 0 {
+		t.Fatalf("Expected the message to wait for the next tick")
+	}
+
+	if err := emsgClient.Resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	if offset, measured := emsgClient.ClockOffset(); !measured || offset < time.Hour-2*time.Second || offset > time.Hour+2*time.Second {
+		t.Errorf("Expected a clock offset of an hour, got %v (measured %v)", offset, measured)
+	}
+	if !emsgClient.IsWebSocketConnected() {
+		t.Error("Expected the WebSocket to be reconnected")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for posted.Load() == 0 || connections.Load() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a send and a new connection after resync, got %d sends and %d connections", posted.Load(), connections.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := emsgClient.StartWakeDetector(); err != nil || !emsgClient.IsWakeDetectorRunning() {
+		t.Errorf("Failed to start wake detector: %v", err)
+	}
+	emsgClient.StopWakeDetector()
+	if emsgClient.IsWakeDetectorRunning() {
+		t.Error("Expected the wake detector to stop")
+	}
+}
+
+func TestMessageLabels(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	push := make(chan *websocket.WebSocketMessage, 1)
+	var change map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/capabilities":
+			w.Write([]byte(`{"features":["labels"]}`))
+		case "/api/v1/users/bob#example.com/labels":
+			w.Write([]byte(`[{"name":"inbox","system":true,"messages":2,"unread":1},{"name":"work","messages":1}]`))
+		case "/api/v1/users/bob#example.com/messages/m1/labels":
+			json.NewDecoder(r.Body).Decode(&change)
+			w.Write([]byte(`{"labels":["inbox","work"]}`))
+		case "/api/v1/messages":
+			// Servers that ignore the filter return every message
+			w.Write([]byte(`[
+				{"from":"alice#example.com","to":["bob#example.com"],"body":"one","timestamp":1,"message_id":"m1","labels":["inbox"]},
+				{"from":"alice#example.com","to":["bob#example.com"],"body":"two","timestamp":2,"message_id":"m2","labels":["archive"]}]`))
+		case "/api/v1/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for frame := range push {
+				conn.WriteJSON(frame)
+			}
+			// Closing with client frames unread resets the connection and can drop the push
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.DNSConfig = &dns.ResolverConfig{Retries: 1, LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		return []string{server.URL}, nil
+	}}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+	emsgClient.SetMessageStore(store.NewMemoryMessageStore())
+
+	labels, err := emsgClient.ListLabels("bob#example.com")
+	if err != nil || len(labels) != 2 || !labels[0].System || labels[1].Name != "work" {
+		t.Fatalf("Unexpected labels %+v: %v", labels, err)
+	}
+
+	archived, err := emsgClient.GetMessagesWithLabel(context.Background(), "bob#example.com", message.LabelArchive)
+	if err != nil || len(archived) != 1 || archived[0].MessageID != "m2" {
+		t.Fatalf("Expected only the archived message, got %v: %v", archived, err)
+	}
+
+	// Changes are mirrored into the stored copy
+	if err := emsgClient.ApplyLabels("bob#example.com", "m1", "work"); err != nil {
+		t.Fatalf("Failed to apply label: %v", err)
+	}
+	if len(change["add"]) != 1 || change["add"][0] != "work" {
+		t.Errorf("Unexpected label change request %v", change)
+	}
+	work, err := emsgClient.GetStoredMessagesWithLabel("work")
+	if err != nil || len(work) != 1 || work[0].MessageID != "m1" {
+		t.Errorf("Expected the stored message to be labelled, got %v: %v", work, err)
+	}
+
+	// So are changes pushed by the server
+	if err := emsgClient.ConnectWebSocket("bob#example.com"); err != nil {
+		t.Fatalf("Failed to connect WebSocket: %v", err)
+	}
+	push <- &websocket.WebSocketMessage{Type: "event", Event: "labels_changed", Data: json.RawMessage(`{"address":"bob#example.com","message_id":"m1","labels":["spam"]}`)}
+	close(push)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stored, _ := emsgClient.GetMessageStore().Get("m1")
+		if stored.HasLabel(message.LabelSpam) && !stored.HasLabel("work") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the pushed labels to be mirrored, got %v", stored.Labels)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := emsgClient.ApplyLabels("bob#example.com", "m1", "two words"); !errors.Is(err, client.ErrValidation) {
+		t.Errorf("Expected an invalid label to be rejected, got %v", err)
+	}
+
+	// Labels are not covered by the sender's signature
+	msg, _ := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body("signed").Build()
+	msg.Sign(config.KeyPair)
+	msg.Labels = []string{message.LabelArchive}
+	if err := msg.Verify(config.KeyPair.PublicKeyBase64()); err != nil {
+		t.Errorf("Expected a labelled message to verify, got %v", err)
+	}
+}
+
+func TestMessageLabelsUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.DNSConfig = &dns.ResolverConfig{Retries: 1, LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		return []string{server.URL}, nil
+	}}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := emsgClient.ListLabels("bob#example.com"); !errors.Is(err, client.ErrFeatureUnsupported) {
+		t.Errorf("Expected labels to be unsupported, got %v", err)
+	}
+}
+
+func TestServerHealthAndInfo(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/health":
+			if !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/api/v1/capabilities":
+			w.Write([]byte(`{"max_message_size":1048576,"features":["websocket","groups"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL, Version: "1.0"}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := emsgClient.Ping("example.com"); err != nil {
+		t.Errorf("Expected server to be healthy: %v", err)
+	}
+	healthy.Store(false)
+	var httpErr *client.HTTPError
+	if _, err := emsgClient.Ping("example.com"); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected an unhealthy server to fail with 503, got %v", err)
+	}
+
+	info, err := emsgClient.GetServerInfo("example.com")
+	if err != nil {
+		t.Fatalf("Failed to get server info: %v", err)
+	}
+	if info.URL != server.URL || info.Version != "1.0" || info.MaxMessageSize != 1048576 {
+		t.Errorf("Unexpected server info: %+v", info)
+	}
+	if !info.HasFeature(client.FeatureWebSocket) || !info.HasFeature(client.FeatureGroups) || info.HasFeature(client.FeatureAttachments) {
+		t.Errorf("Unexpected features: %v", info.Features)
+	}
+}
+
+func TestLoopbackTransport(t *testing.T) {
+	network := client.NewLoopbackNetwork()
+	newClient := func(address string) *client.Client {
+		config := client.DefaultConfig()
+		config.KeyPair, _ = keymgmt.GenerateKeyPair()
+		config.Loopback = network
+		config.EnableDeliveryTracking = true
+		config.VerifyIncoming = client.VerifyReject
+		// Any DNS or HTTP use would fail the test
+		config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+			t.Errorf("Unexpected resolution of %s", domain)
+			return nil, fmt.Errorf("no DNS in loopback test")
+		})
+		emsgClient, err := client.New(config)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if err := emsgClient.AttachLoopback(address); err != nil {
+			t.Fatalf("Failed to attach %s: %v", address, err)
+		}
+		return emsgClient
+	}
+	app := newClient("alice#desktop.local")
+	bot := newClient("bot#desktop.local")
+	defer app.Close()
+	defer bot.Close()
+
+	if err := app.AttachLoopback("bot#desktop.local"); err == nil {
+		t.Error("Expected an address attached to another client to be refused")
+	}
+
+	msg, err := app.ComposeMessage().From("alice#desktop.local").To("bot#desktop.local").Subject("hi").Body("status?").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := app.SendMessage(msg); err != nil {
+		t.Fatalf("Failed to send over loopback: %v", err)
+	}
+	receipt, err := app.GetDeliveryReceipt(msg.MessageID)
+	if err != nil || receipt.Status != delivery.StatusSent {
+		t.Errorf("Expected the send to be tracked as sent: %+v, %v", receipt, err)
+	}
+
+	received, err := bot.GetMessages("bot#desktop.local")
+	if err != nil {
+		t.Fatalf("Failed to fetch over loopback: %v", err)
+	}
+	if len(received) != 1 || received[0].Body != "status?" || received[0].VerificationStatus != message.VerificationVerified {
+		t.Fatalf("Unexpected messages: %+v", received)
+	}
+	if received[0] == msg {
+		t.Error("Expected the recipient to get its own copy")
+	}
+
+	// The mailbox is drained by fetching
+	if received, _ := bot.GetMessages("bot#desktop.local"); len(received) != 0 {
+		t.Errorf("Expected no messages left, got %d", len(received))
+	}
+
+	// Closing a client detaches its addresses
+	bot.Close()
+	if err := app.AttachLoopback("bot#desktop.local"); err != nil {
+		t.Errorf("Expected a closed client's address to be free: %v", err)
+	}
+}
+
+func TestContentTypeNegotiation(t *testing.T) {
+	network := client.NewLoopbackNetwork()
+	newClient := func(address string, renders ...string) *client.Client {
+		config := client.DefaultConfig()
+		config.KeyPair, _ = keymgmt.GenerateKeyPair()
+		config.Loopback = network
+		config.RenderContentTypes = renders
+		emsgClient, err := client.New(config)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if err := emsgClient.AttachLoopback(address); err != nil {
+			t.Fatalf("Failed to attach %s: %v", address, err)
+		}
+		return emsgClient
+	}
+	app := newClient("alice#desktop.local", message.ContentTypeMarkdown)
+	bot := newClient("bot#desktop.local")
+	defer app.Close()
+	defer bot.Close()
+
+	if !app.GetClientInfo().Supports(message.FeatureMarkdown) || app.GetClientInfo().Supports(message.FeatureHTML) {
+		t.Errorf("Expected only Markdown rendering to be advertised, got %v", app.GetClientInfo().Features)
+	}
+
+	// Nothing is known about the app yet, so the preferred type is used with its fallback
+	if contentType := bot.NegotiateContentType("alice#desktop.local", message.ContentTypeHTML, message.ContentTypeMarkdown); contentType != message.ContentTypeHTML {
+		t.Errorf("Expected HTML for an unknown peer, got %s", contentType)
+	}
+
+	msg, err := app.ComposeMessage().From("alice#desktop.local").To("bot#desktop.local").Markdown("**ready**").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := app.SendMessage(msg); err != nil {
+		t.Fatalf("Failed to send over loopback: %v", err)
+	}
+	received, err := bot.GetMessages("bot#desktop.local")
+	if err != nil || len(received) != 1 {
+		t.Fatalf("Expected one message, got %d: %v", len(received), err)
+	}
+	if body, contentType := received[0].Render(); body != "ready" || contentType != message.ContentTypePlain {
+		t.Errorf("Expected the plain fallback for a plain text client, got %q (%s)", body, contentType)
+	}
+
+	if contentType := bot.NegotiateContentType("alice#desktop.local", message.ContentTypeHTML, message.ContentTypeMarkdown); contentType != message.ContentTypeMarkdown {
+		t.Errorf("Expected Markdown once the app advertised it, got %s", contentType)
+	}
+	if contentType := bot.NegotiateContentType("alice#desktop.local", message.ContentTypeHTML); contentType != message.ContentTypePlain {
+		t.Errorf("Expected plain text for a type the app does not render, got %s", contentType)
+	}
+
+	config := client.DefaultConfig()
+	config.RenderContentTypes = []string{"application/pdf"}
+	var configErrs client.ConfigErrors
+	if _, err := client.New(config); !errors.As(err, &configErrs) {
+		t.Errorf("Expected ConfigErrors for an unsupported content type, got %v", err)
+	}
+}
+
+func TestServerErrorEnvelope(t *testing.T) {
+	for _, tc := range []struct {
+		body    string
+		code    string
+		message string
+	}{
+		{`{"error": "user already exists", "code": "user_exists"}`, "user_exists", "user already exists"},
+		{`{"error": {"code": "quota_exceeded", "message": "mailbox full"}}`, "quota_exceeded", "mailbox full"},
+		{`{"error": "missing authorization header"}`, "", "missing authorization header"},
+	} {
+		response, err := client.ParseServerResponse([]byte(tc.body))
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tc.body, err)
+		}
+		if response.Error == nil || response.Error.Code != tc.code || response.Error.Message != tc.message {
+			t.Errorf("Unexpected error parsed from %s: %+v", tc.body, response.Error)
+		}
+	}
+
+	response, err := client.ParseServerResponse([]byte(`{"status": "message sent successfully"}`))
+	if err != nil || response.Error != nil || response.Status != "message sent successfully" {
+		t.Errorf("Unexpected success response: %+v, %v", response, err)
+	}
+	if _, err := client.ParseServerResponse([]byte("Bad Gateway")); err == nil {
+		t.Error("Expected a non-JSON body to fail")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "user already exists", "code": "user_exists"}`))
+	}))
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	err = emsgClient.RegisterUser("alice#example.com")
+	if code := client.ServerErrorCode(err); code != "user_exists" {
+		t.Errorf("Expected code user_exists, got %q from %v", code, err)
+	}
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected an HTTPError with status 409, got %v", err)
+	}
+	if strings.Contains(err.Error(), "{") {
+		t.Errorf("Expected the raw body to be kept out of the message: %v", err)
+	}
+}
+
+func TestAuthHeaderClockSkewCompensation(t *testing.T) {
+	// The server's clock is an hour ahead and it rejects stale or replayed headers
+	serverNow := func() time.Time { return time.Now().Add(time.Hour) }
+	nonces := auth.NewMemoryNonceCache(0)
+	var rejected atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverNow().UTC().Format(http.TimeFormat))
+		authHeader, err := auth.ParseAuthHeader(r.Header.Get("Authorization"))
+		if err == nil {
+			err = auth.VerifyAuthHeaderWithOptions(authHeader, r.Method, r.URL.Path, &auth.VerifyOptions{Now: serverNow, Nonces: nonces})
+		}
+		if err != nil {
+			rejected.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid authorization", "code": "unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "user registered successfully"}`))
+	}))
+	defer server.Close()
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.RetryStrategy.MaxRetries = 0
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	// The first header is signed by the local clock; its response tells the client the offset
+	if err := emsgClient.RegisterUser("alice#example.com"); client.ServerErrorCode(err) != "unauthorized" {
+		t.Fatalf("Expected the first request to be rejected, got %v", err)
+	}
+	if err := emsgClient.RegisterUser("alice#example.com"); err != nil {
+		t.Fatalf("Expected the request to be accepted once the clock offset was measured: %v", err)
+	}
+	if rejected.Load() != 1 {
+		t.Errorf("Expected one rejected request, got %d", rejected.Load())
+	}
+}
+
+func TestDeterministicFixtures(t *testing.T) {
+	// The same seed yields the same keys; another seed does not
+	first, err := keymgmt.GenerateKeyPairFrom(utils.NewDeterministicEntropy("alice"))
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	again, _ := keymgmt.GenerateKeyPairFrom(utils.NewDeterministicEntropy("alice"))
+	other, _ := keymgmt.GenerateKeyPairFrom(utils.NewDeterministicEntropy("bob"))
+	if first.PublicKeyBase64() != again.PublicKeyBase64() || first.PublicKeyBase64() == other.PublicKeyBase64() {
+		t.Error("Expected key pairs to depend only on the seed")
+	}
+	codes, _ := keymgmt.GenerateRecoveryCodesFrom(3, utils.NewDeterministicEntropy("codes"))
+	codesAgain, _ := keymgmt.GenerateRecoveryCodesFrom(3, utils.NewDeterministicEntropy("codes"))
+	if strings.Join(codes, ",") != strings.Join(codesAgain, ",") {
+		t.Errorf("Expected the same recovery codes, got %v and %v", codes, codesAgain)
+	}
+
+	// Ciphertexts are reproducible when keys and nonces come from seeded entropy
+	encrypt := func() string {
+		entropy := utils.NewDeterministicEntropy("encryption")
+		sender, _ := encryption.GenerateEncryptionKeyPairFrom(entropy)
+		recipient, _ := encryption.GenerateEncryptionKeyPairFrom(entropy)
+		manager := encryption.NewEncryptionManager(sender, encryption.NewMemoryKeyStore())
+		manager.SetEntropy(entropy)
+		manager.RegisterPublicKey("bob#example.com", recipient.PublicKeyBase64())
+		encrypted, err := manager.EncryptForRecipient([]byte("hello"), "bob#example.com")
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(encrypted.Ciphertext)
+	}
+	if encrypt() != encrypt() {
+		t.Error("Expected identical ciphertexts from the same seed")
+	}
+
+	attachmentConfig := attachments.DefaultAttachmentConfig()
+	attachmentConfig.StorageDir = ""
+	attachmentConfig.IDGenerator = utils.NewSequentialIDGenerator("att")
+	attManager, err := attachments.NewAttachmentManager(attachmentConfig)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	if attachment, _ := attManager.CreateAttachmentFromData("a.txt", []byte("a"), "text/plain"); attachment.ID != "att-1" {
+		t.Errorf("Expected attachment ID att-1, got %s", attachment.ID)
+	}
+
+	// Composed messages take their ID and timestamp from the injected generator and clock
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	config := client.DefaultConfig()
+	config.KeyPair = first
+	config.Entropy = utils.NewDeterministicEntropy("client")
+	config.IDGenerator = utils.NewSequentialIDGenerator("msg")
+	config.Clock = utils.NewFakeClock(start)
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	for _, want := range []string{"msg-1", "msg-2"} {
+		msg, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#example.com").Body("hi").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		if msg.MessageID != want || msg.TimestampMs != start.UnixMilli() {
+			t.Errorf("Expected %s at %v, got %s at %d", want, start, msg.MessageID, msg.TimestampMs)
+		}
+	}
+
+	random := utils.NewRandomIDGenerator(nil)
+	if id := random.NewID(); len(id) != 22 || id == random.NewID() {
+		t.Errorf("Expected distinct 16-byte random IDs, got %q", id)
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	var traced atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Trace-Id") == "trace-1" && r.Header.Get("Authorization") != "" {
+			traced.Add(1)
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	var order []string
+	var mutex sync.Mutex
+	record := func(name string) client.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mutex.Lock()
+				order = append(order, name+" request")
+				mutex.Unlock()
+				resp, err := next.RoundTrip(req)
+				mutex.Lock()
+				order = append(order, name+" response")
+				mutex.Unlock()
+				return resp, err
+			})
+		}
+	}
+	tracing := func(next http.RoundTripper) http.RoundTripper {
+		return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Trace-Id", "trace-1")
+			return next.RoundTrip(req)
+		})
+	}
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Middleware = []client.Middleware{record("outer"), tracing}
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+	emsgClient.Use(record("inner"))
+
+	if err := emsgClient.RegisterUser("alice#example.com"); err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+	if traced.Load() != 1 {
+		t.Error("Expected the signed request to carry the tracing header")
+	}
+	if got := strings.Join(order, ", "); got != "outer request, inner request, inner response, outer response" {
+		t.Errorf("Unexpected middleware order: %s", got)
+	}
+
+	// Middleware can answer without reaching the server
+	emsgClient.Use(func(next http.RoundTripper) http.RoundTripper {
+		return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusForbidden,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"error": "blocked by policy", "code": "policy"}`)),
+				Request:    req,
+			}, nil
+		})
+	})
+	if err := emsgClient.RegisterUser("alice#example.com"); client.ServerErrorCode(err) != "policy" {
+		t.Errorf("Expected the middleware's response, got %v", err)
+	}
+	if traced.Load() != 1 {
+		t.Error("Expected the blocked request not to reach the server")
+	}
+}
+
+// TestMetricsRecorder tests that the client records its metrics in a registry
+func TestMetricsRecorder(t *testing.T) {
+	var posts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`[]`))
+			return
+		}
+		if posts.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	registry := metrics.NewRegistry(nil)
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Metrics = registry
+	config.RetryStrategy = &client.RetryStrategy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, BackoffFactor: 1, RetryOn429: true}
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	msg, err := emsgClient.ComposeMessage().
+		From("alice#example.com").
+		To("bob#example.com").
+		Body("report attached").
+		Attachment(&attachments.Attachment{ID: "a1", Name: "report.txt", MimeType: "text/plain", Size: 5, Data: []byte("hello")}).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := emsgClient.SendMessage(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, err := emsgClient.GetMessages("alice#example.com"); err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+
+	domain := metrics.Labels{"domain": "example.com"}
+	if got := registry.Counter(metrics.MessagesSent, domain); got != 1 {
+		t.Errorf("Expected 1 message sent, got %v", got)
+	}
+	if got := registry.Counter(metrics.HTTPRetries, domain); got != 1 {
+		t.Errorf("Expected 1 retry, got %v", got)
+	}
+	if got := registry.Counter(metrics.AttachmentBytes, metrics.Labels{"direction": "sent"}); got != 5 {
+		t.Errorf("Expected 5 attachment bytes sent, got %v", got)
+	}
+	if got := registry.Counter(metrics.PollCycles, metrics.Labels{"result": "success"}); got != 1 {
+		t.Errorf("Expected 1 poll cycle, got %v", got)
+	}
+	if got := registry.HistogramCount(metrics.HTTPRequestDuration, metrics.Labels{"domain": "example.com", "method": "POST", "code": "429"}); got != 1 {
+		t.Errorf("Expected the failed attempt's latency to be observed, got %d", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	exposition := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE emsg_messages_sent_total counter",
+		`emsg_messages_sent_total{domain="example.com"} 1`,
+		"# TYPE emsg_http_request_duration_seconds histogram",
+		`emsg_http_request_duration_seconds_bucket{code="200",domain="example.com",le="+Inf",method="POST"} 1`,
+		`emsg_http_request_duration_seconds_count{code="200",domain="example.com",method="POST"} 1`,
+	} {
+		if !strings.Contains(exposition, line) {
+			t.Errorf("Expected exposition to contain %q, got:\n%s", line, exposition)
+		}
+	}
+}
+
+// recordingTracer records the spans started by the client
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]any
+	err        error
+	ended      bool
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, attributes ...tracing.Attribute) (context.Context, tracing.Span) {
+	span := &recordedSpan{name: name, attributes: make(map[string]any)}
+	span.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	span.SetAttributes(attributes...)
+	rt.mutex.Lock()
+	rt.spans = append(rt.spans, span)
+	rt.mutex.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (rt *recordingTracer) find(name string) *recordedSpan {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	for _, span := range rt.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func (rs *recordedSpan) SetAttributes(attributes ...tracing.Attribute) {
+	for _, attribute := range attributes {
+		rs.attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (rs *recordedSpan) RecordError(err error) { rs.err = err }
+func (rs *recordedSpan) End()                  { rs.ended = true }
+
+// TestTracing tests that sends and resolutions are traced and the span reaches hooks and middleware
+func TestTracing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	var hookSpan, middlewareSpan *recordedSpan
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.Tracer = tracer
+	config.OnRequest = func(ctx context.Context, info *client.RequestInfo) error {
+		hookSpan, _ = ctx.Value(spanKey{}).(*recordedSpan)
+		return nil
+	}
+	config.Middleware = []client.Middleware{func(next http.RoundTripper) http.RoundTripper {
+		return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			middlewareSpan, _ = req.Context().Value(spanKey{}).(*recordedSpan)
+			return next.RoundTrip(req)
+		})
+	}}
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		if domain == "unknown.org" {
+			return nil, fmt.Errorf("no such domain")
+		}
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	msg, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#example.com").Body("hi").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := emsgClient.SendMessage(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	send := tracer.find(tracing.SpanSendMessage)
+	if send == nil || !send.ended || send.err != nil {
+		t.Fatalf("Expected an ended, successful send span, got %+v", send)
+	}
+	if send.attributes[tracing.AttrMessageID] != msg.MessageID {
+		t.Errorf("Expected the send span to carry the message ID, got %v", send.attributes)
+	}
+	resolve := tracer.find(tracing.SpanResolveDomain)
+	if resolve == nil || resolve.parent != send || resolve.attributes[tracing.AttrServerURL] != server.URL {
+		t.Errorf("Expected a resolution span nested in the send span, got %+v", resolve)
+	}
+	if hookSpan != send || middlewareSpan != send {
+		t.Error("Expected hooks and middleware to see the send span in their context")
+	}
+
+	// Failed operations record their error
+	if err := emsgClient.ConnectWebSocket("alice#unknown.org"); err == nil {
+		t.Fatal("Expected connecting to an unknown domain to fail")
+	}
+	connect := tracer.find(tracing.SpanConnectWebSocket)
+	if connect == nil || !connect.ended || connect.err == nil {
+		t.Errorf("Expected an ended connect span with the error, got %+v", connect)
+	}
+}
@@ -1,13 +1,28 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/emsg-protocol/emsg-client-sdk/client"
 	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 func TestDeliveryTracker(t *testing.T) {
@@ -255,6 +270,103 @@ func TestDeliveryStats(t *testing.T) {
 	}
 }
 
+func TestDeliveryTrackerMaxReceipts(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+	track := func(id string) {
+		tracker.TrackMessage(&message.Message{MessageID: id, From: "alice#example.com", To: []string{"bob#example.com"}})
+	}
+
+	track("pending-1")
+	track("delivered-1")
+	track("pending-2")
+	tracker.UpdateDeliveryStatus("delivered-1", delivery.StatusDelivered, "")
+
+	tracker.SetMaxReceipts(3)
+	track("pending-3")
+
+	// The finished receipt goes first, even though pending-1 is as old
+	if _, err := tracker.GetDeliveryReceipt("delivered-1"); err == nil {
+		t.Error("Expected delivered receipt to be evicted first")
+	}
+	if len(tracker.GetAllReceipts()) != 3 {
+		t.Errorf("Expected 3 receipts, got %d", len(tracker.GetAllReceipts()))
+	}
+
+	// With nothing finished, the oldest pending receipt is dropped
+	track("pending-4")
+	if n := len(tracker.GetAllReceipts()); n != 3 {
+		t.Errorf("Expected 3 receipts, got %d", n)
+	}
+
+	// Lowering the limit trims existing receipts
+	tracker.SetMaxReceipts(1)
+	if n := len(tracker.GetAllReceipts()); n != 1 {
+		t.Errorf("Expected 1 receipt after lowering the limit, got %d", n)
+	}
+}
+
+func TestEncryptionDecisionReceipts(t *testing.T) {
+	senderKeys, _ := encryption.GenerateEncryptionKeyPair()
+	bobKeys, _ := encryption.GenerateEncryptionKeyPair()
+	carolKeys, _ := encryption.GenerateEncryptionKeyPair()
+	keyStore := encryption.NewMemoryKeyStore()
+	keyStore.StorePublicKey("bob#example.com", bobKeys.PublicKey)
+	keyStore.StorePublicKey("carol#example.com", carolKeys.PublicKey)
+	manager := encryption.NewEncryptionManager(senderKeys, keyStore)
+
+	build := func(id string, encrypt bool, to ...string) *message.Message {
+		builder := message.NewMessageBuilder().From("alice#example.com").To(to...).Body("hello").MessageID(id)
+		if encrypt {
+			builder.WithEncryption(manager)
+		}
+		msg, err := builder.Build()
+		if err != nil {
+			t.Fatalf("Failed to build message %s: %v", id, err)
+		}
+		return msg
+	}
+
+	encrypted := build("encrypted", true, "bob#example.com")
+	partial := build("partial", true, "bob#example.com", "carol#example.com")
+	fallback := build("fallback", true, "bob#example.com", "dave#example.com")
+	plain := build("plain", false, "bob#example.com")
+
+	if d := encrypted.EncryptionDecision; d == nil || d.Scheme != encryption.Scheme || d.FallbackReason != message.FallbackNone {
+		t.Errorf("Unexpected decision for fully encrypted message: %+v", d)
+	}
+	if d := partial.EncryptionDecision; d.FallbackReason != message.FallbackSingleRecipientKey || len(d.Covered) != 1 || len(d.Uncovered) != 1 {
+		t.Errorf("Unexpected decision for multi-recipient message: %+v", d)
+	}
+	if d := fallback.EncryptionDecision; fallback.Encrypted || d.Encrypted() || d.FallbackReason != message.FallbackMissingKeys {
+		t.Errorf("Expected plaintext fallback for missing key, got %+v", d)
+	}
+	if d := plain.EncryptionDecision; d.FallbackReason != message.FallbackNotRequested {
+		t.Errorf("Expected not_requested for plain message, got %+v", d)
+	}
+
+	tracker := delivery.NewDeliveryTracker(nil)
+	for _, msg := range []*message.Message{encrypted, partial, fallback, plain} {
+		tracker.TrackMessage(msg)
+	}
+
+	receipt, _ := tracker.GetDeliveryReceipt("fallback")
+	if receipt.Metadata[delivery.MetadataEncryptionFallback] != string(message.FallbackMissingKeys) {
+		t.Errorf("Expected fallback reason in receipt metadata, got %v", receipt.Metadata)
+	}
+	receipt, _ = tracker.GetDeliveryReceipt("encrypted")
+	if receipt.Metadata[delivery.MetadataEncryptionScheme] != encryption.Scheme {
+		t.Errorf("Expected scheme in receipt metadata, got %v", receipt.Metadata)
+	}
+
+	stats := tracker.GetEncryptionStats()
+	if stats.Encrypted != 1 || stats.Partial != 1 || stats.Plaintext != 2 {
+		t.Errorf("Unexpected encryption stats: %+v", stats)
+	}
+	if stats.FallbackReasons[message.FallbackMissingKeys] != 1 || stats.FallbackReasons[message.FallbackSingleRecipientKey] != 1 {
+		t.Errorf("Unexpected fallback reasons: %v", stats.FallbackReasons)
+	}
+}
+
 func TestPendingRetries(t *testing.T) {
 	strategy := &delivery.RetryStrategy{
 		MaxRetries:     3,
@@ -373,3 +485,870 @@ func TestDeliveryReceiptMethods(t *testing.T) {
 		t.Error("Delivered status should not be retryable")
 	}
 }
+
+func TestClassifyFailure(t *testing.T) {
+	statusCases := map[int]delivery.FailureReason{
+		401: delivery.FailureAuthRejected,
+		403: delivery.FailureAuthRejected,
+		404: delivery.FailureRecipientUnknown,
+		413: delivery.FailurePayloadTooLarge,
+		429: delivery.FailureRateLimited,
+		500: delivery.FailureServerError,
+		503: delivery.FailureServerError,
+		504: delivery.FailureTimeout,
+		400: delivery.FailureUnknown,
+	}
+	for status, expected := range statusCases {
+		if reason := delivery.ClassifyHTTPStatus(status); reason != expected {
+			t.Errorf("Status %d: expected %s, got %s", status, expected, reason)
+		}
+	}
+
+	httpErr := fmt.Errorf("send failed: %w", &client.HTTPError{StatusCode: 429, Body: "slow down"})
+	if reason := delivery.ClassifyError(httpErr); reason != delivery.FailureRateLimited {
+		t.Errorf("Expected rate_limited for wrapped HTTPError, got %s", reason)
+	}
+
+	resolveErr := &client.ResolveError{Domain: "example.com", Err: errors.New("no TXT records")}
+	if reason := delivery.ClassifyError(resolveErr); reason != delivery.FailureDNS {
+		t.Errorf("Expected dns_failure for ResolveError, got %s", reason)
+	}
+
+	dnsErr := &net.DNSError{Err: "no such host", Name: "_emsg.example.com", IsNotFound: true}
+	if reason := delivery.ClassifyError(dnsErr); reason != delivery.FailureDNS {
+		t.Errorf("Expected dns_failure for net.DNSError, got %s", reason)
+	}
+
+	if reason := delivery.ClassifyError(context.DeadlineExceeded); reason != delivery.FailureTimeout {
+		t.Errorf("Expected timeout, got %s", reason)
+	}
+
+	if reason := delivery.ClassifyError(errors.New("something odd")); reason != delivery.FailureUnknown {
+		t.Errorf("Expected unknown, got %s", reason)
+	}
+}
+
+func TestDeliveryFailureStats(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+
+	reasons := []delivery.FailureReason{
+		delivery.FailureRateLimited,
+		delivery.FailureRateLimited,
+		delivery.FailureDNS,
+	}
+	for i, reason := range reasons {
+		msg := &message.Message{
+			MessageID: fmt.Sprintf("fail-%d", i),
+			From:      "alice#example.com",
+			To:        []string{"bob#example.com"},
+		}
+		tracker.TrackMessage(msg)
+		tracker.UpdateDeliveryFailure(msg.MessageID, delivery.StatusFailed, reason, "failed")
+	}
+
+	// A later success clears the failure reason
+	recovered := &message.Message{MessageID: "recovered", From: "alice#example.com", To: []string{"bob#example.com"}}
+	tracker.TrackMessage(recovered)
+	tracker.UpdateDeliveryFailure(recovered.MessageID, delivery.StatusRetrying, delivery.FailureTimeout, "timeout")
+	tracker.UpdateDeliveryStatus(recovered.MessageID, delivery.StatusSent, "")
+
+	stats := tracker.GetFailureStats()
+	if stats[delivery.FailureRateLimited] != 2 {
+		t.Errorf("Expected 2 rate_limited failures, got %d", stats[delivery.FailureRateLimited])
+	}
+	if stats[delivery.FailureDNS] != 1 {
+		t.Errorf("Expected 1 dns_failure, got %d", stats[delivery.FailureDNS])
+	}
+	if stats[delivery.FailureTimeout] != 0 {
+		t.Errorf("Expected recovered delivery to be excluded, got %d timeouts", stats[delivery.FailureTimeout])
+	}
+
+	receipts := tracker.GetReceiptsByFailureReason(delivery.FailureDNS)
+	if len(receipts) != 1 || receipts[0].MessageID != "fail-2" {
+		t.Errorf("Expected fail-2 to be the only DNS failure, got %v", receipts)
+	}
+
+	receipt, _ := tracker.GetDeliveryReceipt("fail-0")
+	data, _ := receipt.ToJSON()
+	parsed, err := delivery.FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to parse receipt: %v", err)
+	}
+	if parsed.FailureReason != delivery.FailureRateLimited {
+		t.Errorf("Expected failure reason to round-trip, got %s", parsed.FailureReason)
+	}
+}
+
+func TestDeliveryProof(t *testing.T) {
+	sender, _ := keymgmt.GenerateKeyPair()
+	recipient, _ := keymgmt.GenerateKeyPair()
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Subject("Contract").
+		Body("Signed copy attached").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := msg.Sign(sender); err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+
+	hash, err := delivery.MessageHash(msg)
+	if err != nil {
+		t.Fatalf("Failed to hash message: %v", err)
+	}
+
+	// The recipient signs a receipt for the message it received
+	receipt, err := delivery.NewSignedReceipt(msg, "bob#test.org", recipient)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	if err := receipt.Verify(); err != nil {
+		t.Fatalf("Receipt should verify: %v", err)
+	}
+
+	newProof := func() *delivery.DeliveryProof {
+		copied := *receipt
+		return &delivery.DeliveryProof{
+			Version:     delivery.DeliveryProofVersion,
+			Message:     msg.Clone(),
+			SenderKey:   sender.PublicKeyBase64(),
+			MessageHash: hash,
+			Acceptances: []*delivery.ServerAcceptance{
+				{Domain: "test.org", StatusCode: 202, AcceptedAt: msg.Timestamp},
+			},
+			Receipts:    []*delivery.SignedReceipt{&copied},
+			GeneratedAt: time.Now().Unix(),
+		}
+	}
+
+	if err := delivery.VerifyDeliveryProof(newProof(), nil); err != nil {
+		t.Fatalf("Valid proof should verify: %v", err)
+	}
+
+	recipientKey := func(address string) (string, error) {
+		return recipient.PublicKeyBase64(), nil
+	}
+	if err := delivery.VerifyDeliveryProof(newProof(), &delivery.ProofVerifyOptions{RecipientKey: recipientKey}); err != nil {
+		t.Errorf("Proof should verify against the recipient's key: %v", err)
+	}
+
+	other, _ := keymgmt.GenerateKeyPair()
+	wrongKey := func(address string) (string, error) {
+		return other.PublicKeyBase64(), nil
+	}
+	if err := delivery.VerifyDeliveryProof(newProof(), &delivery.ProofVerifyOptions{RecipientKey: wrongKey}); err == nil {
+		t.Error("Expected proof to fail against a different recipient key")
+	}
+
+	tampered := newProof()
+	tampered.Message.Body = "Unsigned copy attached"
+	if err := delivery.VerifyDeliveryProof(tampered, nil); err == nil {
+		t.Error("Expected tampered message to fail verification")
+	}
+
+	tampered = newProof()
+	tampered.Receipts[0].Timestamp++
+	if err := delivery.VerifyDeliveryProof(tampered, nil); err == nil {
+		t.Error("Expected tampered receipt to fail verification")
+	}
+
+	tampered = newProof()
+	tampered.Acceptances[0].Domain = "elsewhere.net"
+	if err := delivery.VerifyDeliveryProof(tampered, nil); err == nil {
+		t.Error("Expected acceptance from a non-recipient domain to fail verification")
+	}
+
+	tampered = newProof()
+	tampered.Acceptances = nil
+	if err := delivery.VerifyDeliveryProof(tampered, nil); err == nil {
+		t.Error("Expected proof without acceptances to fail verification")
+	}
+
+	// Proofs survive serialization
+	data, err := json.Marshal(newProof())
+	if err != nil {
+		t.Fatalf("Failed to marshal proof: %v", err)
+	}
+	var parsed delivery.DeliveryProof
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Failed to unmarshal proof: %v", err)
+	}
+	if err := delivery.VerifyDeliveryProof(&parsed, nil); err != nil {
+		t.Errorf("Parsed proof should verify: %v", err)
+	}
+
+	// The client only generates proofs for messages it recorded
+	config := client.DefaultConfig()
+	config.KeyPair = sender
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := c.GenerateDeliveryProof(msg.MessageID); err == nil {
+		t.Error("Expected error when delivery proofs are disabled")
+	}
+
+	config.RecordDeliveryProofs = true
+	c, err = client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := c.GenerateDeliveryProof(msg.MessageID); err == nil {
+		t.Error("Expected error for an unrecorded message")
+	}
+	if err := c.AddDeliveryReceipt(receipt); err == nil {
+		t.Error("Expected error adding a receipt for an unrecorded message")
+	}
+}
+
+func TestClientOutbox(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := store.NewFileOutboxStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+
+	strategy := delivery.DefaultRetryStrategy()
+	strategy.MaxRetries = 2
+
+	// Without a key pair every send attempt fails before reaching the network
+	config := client.DefaultConfig()
+	config.Outbox = outbox
+	config.QueueOutgoing = true
+	config.EnableDeliveryTracking = true
+	config.DeliveryRetryStrategy = strategy
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("queued").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+
+	// SendMessage enqueues instead of sending
+	if err := c.SendMessage(msg); err != nil {
+		t.Fatalf("Expected message to be queued, got %v", err)
+	}
+	receipt, err := c.GetDeliveryReceipt(msg.MessageID)
+	if err != nil || receipt.Status != delivery.StatusPending {
+		t.Errorf("Expected pending receipt for queued message, got %v, %v", receipt, err)
+	}
+
+	// The queue survives a restart
+	restarted, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	entries, err := restarted.ListOutbox()
+	if err != nil || len(entries) != 1 || entries[0].Message.MessageID != msg.MessageID {
+		t.Fatalf("Expected queued message after restart, got %v, %v", entries, err)
+	}
+
+	sent, err := restarted.FlushOutbox()
+	if sent != 0 || err == nil {
+		t.Fatalf("Expected flush to fail without a key pair, got %d, %v", sent, err)
+	}
+	entries, _ = restarted.ListOutbox()
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError == "" {
+		t.Fatalf("Expected failed attempt to be recorded, got %+v", entries)
+	}
+	if !entries[0].NextAttempt.After(time.Now()) {
+		t.Error("Expected next attempt to be backed off")
+	}
+	if entries[0].Message.IsSigned() {
+		t.Error("Queued message should not be modified by send attempts")
+	}
+	receipt, err = restarted.GetDeliveryReceipt(msg.MessageID)
+	if err != nil || receipt.Status != delivery.StatusRetrying {
+		t.Errorf("Expected retrying receipt, got %v, %v", receipt, err)
+	}
+
+	// The retry strategy gives up after MaxRetries attempts
+	restarted.FlushOutbox()
+	if entries, _ := restarted.ListOutbox(); len(entries) != 0 {
+		t.Errorf("Expected message to be dropped after max retries, got %+v", entries)
+	}
+	receipt, _ = restarted.GetDeliveryReceipt(msg.MessageID)
+	if receipt == nil || receipt.Status != delivery.StatusFailed {
+		t.Errorf("Expected failed receipt, got %v", receipt)
+	}
+
+	if err := restarted.StartOutboxSender(); err != nil {
+		t.Fatalf("Failed to start outbox sender: %v", err)
+	}
+	if err := restarted.StartOutboxSender(); err == nil {
+		t.Error("Expected error starting the sender twice")
+	}
+	restarted.StopOutboxSender()
+	if restarted.IsOutboxSenderRunning() {
+		t.Error("Expected sender to be stopped")
+	}
+
+	plain, _ := client.New(nil)
+	if err := plain.EnqueueMessage(msg); err == nil {
+		t.Error("Expected error when the outbox is not configured")
+	}
+}
+
+type deliveryTraceKey struct{}
+
+func TestDeliveryCallbackContext(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+	tracker.TrackMessage(&message.Message{MessageID: "msg-ctx", From: "alice#example.com", To: []string{"bob#example.com"}})
+
+	traces := make(chan string, 2)
+	tracker.RegisterCallbackContext("msg-ctx", func(ctx context.Context, receipt *delivery.DeliveryReceipt) {
+		trace, _ := ctx.Value(deliveryTraceKey{}).(string)
+		traces <- trace
+	})
+	tracker.RegisterGlobalCallback(func(receipt *delivery.DeliveryReceipt) {
+		traces <- "legacy"
+	})
+
+	ctx := context.WithValue(context.Background(), deliveryTraceKey{}, "trace-1")
+	if err := tracker.UpdateDeliveryStatusContext(ctx, "msg-ctx", delivery.StatusSent, ""); err != nil {
+		t.Fatalf("UpdateDeliveryStatusContext failed: %v", err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case trace := <-traces:
+			got[trace] = true
+		case <-time.After(time.Second):
+			t.Fatal("Delivery callbacks were not called")
+		}
+	}
+	if !got["trace-1"] || !got["legacy"] {
+		t.Errorf("Expected context and legacy callbacks, got %v", got)
+	}
+}
+
+func TestDeliveryCallbackPanicHandler(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+
+	reports := make(chan *utils.PanicReport, 1)
+	tracker.SetPanicHandler(func(report *utils.PanicReport) {
+		reports <- report
+	})
+	tracker.RegisterGlobalCallback(func(receipt *delivery.DeliveryReceipt) {
+		panic("callback bug")
+	})
+
+	msg := &message.Message{MessageID: "panic-msg", From: "alice#example.com", To: []string{"bob#example.com"}}
+	tracker.TrackMessage(msg)
+	if err := tracker.UpdateDeliveryStatus(msg.MessageID, delivery.StatusSent, ""); err != nil {
+		t.Fatalf("Failed to update delivery status: %v", err)
+	}
+
+	select {
+	case report := <-reports:
+		if report.Subsystem != utils.PanicSubsystemDeliveryCallback {
+			t.Errorf("Expected subsystem %s, got %s", utils.PanicSubsystemDeliveryCallback, report.Subsystem)
+		}
+		if report.Value != "callback bug" {
+			t.Errorf("Expected panic value, got %v", report.Value)
+		}
+		if !strings.Contains(string(report.Stack), "TestDeliveryCallbackPanicHandler") {
+			t.Errorf("Expected stack trace to include the panicking callback, got %s", report.Stack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected panic to be reported")
+	}
+}
+
+func TestUndeliveredRecipients(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org", "carol#unreachable.example").
+		CC("dave#unreachable.example").
+		Body("partial").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	tracker.TrackMessage(msg)
+
+	missing := []string{"carol#unreachable.example", "dave#unreachable.example"}
+	if err := tracker.MarkRecipientsUndelivered(msg.MessageID, missing, "domain resolution failed"); err != nil {
+		t.Fatalf("Failed to mark recipients undelivered: %v", err)
+	}
+	before, _ := tracker.GetDeliveryReceipt(msg.MessageID)
+	if len(before.Undelivered) != 2 || before.Undelivered["carol#unreachable.example"] != "domain resolution failed" {
+		t.Fatalf("Expected two undelivered recipients, got %v", before.Undelivered)
+	}
+
+	// A later attempt that reaches one recipient clears only that one
+	tracker.MarkRecipientsDelivered(msg.MessageID, missing[:1])
+	receipt, _ := tracker.GetDeliveryReceipt(msg.MessageID)
+	if len(receipt.Undelivered) != 1 || receipt.Undelivered["dave#unreachable.example"] == "" {
+		t.Errorf("Expected dave to remain undelivered, got %v", receipt.Undelivered)
+	}
+	if len(before.Undelivered) != 2 {
+		t.Error("Earlier receipt copies should not change")
+	}
+
+	tracker.MarkRecipientsDelivered(msg.MessageID, missing[1:])
+	receipt, _ = tracker.GetDeliveryReceipt(msg.MessageID)
+	if receipt.Undelivered != nil {
+		t.Errorf("Expected no undelivered recipients, got %v", receipt.Undelivered)
+	}
+
+	if err := tracker.MarkRecipientsUndelivered("unknown", missing, "x"); err == nil {
+		t.Error("Expected error for an untracked message")
+	}
+}
+
+func TestSendMessageWithResult(t *testing.T) {
+	config := client.DefaultConfig()
+	config.PartialDelivery = true
+	if _, err := client.New(config); err == nil {
+		t.Error("Expected partial delivery to require an outbox")
+	}
+
+	config.Outbox = store.NewMemoryOutboxStore()
+	config.QueueOutgoing = true
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("queued").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+
+	result, err := c.SendMessageWithResult(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Expected message to be queued, got %v", err)
+	}
+	if !result.Queued || result.MessageID != msg.MessageID || result.Partial() {
+		t.Errorf("Expected a queued result, got %+v", result)
+	}
+	if entries, _ := c.ListOutbox(); len(entries) != 1 || len(entries[0].Domains) != 0 {
+		t.Errorf("Expected the whole message in the outbox, got %+v", entries)
+	}
+}
+
+func TestDeliveryReceiptPersistence(t *testing.T) {
+	dir := t.TempDir()
+	receipts, err := delivery.NewFileReceiptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create receipt store: %v", err)
+	}
+	strategy := delivery.DefaultRetryStrategy()
+	strategy.InitialDelay = 0
+
+	msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").Body("persisted").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	tracker := delivery.NewDeliveryTracker(strategy)
+	if err := tracker.SetReceiptStore(receipts); err != nil {
+		t.Fatalf("Failed to set receipt store: %v", err)
+	}
+	tracker.TrackMessage(msg)
+	if err := tracker.UpdateDeliveryFailure(msg.MessageID, delivery.StatusRetrying, delivery.FailureTimeout, "connection refused"); err != nil {
+		t.Fatalf("Failed to update delivery: %v", err)
+	}
+
+	// An expired receipt left from an earlier run is dropped on load
+	stale := &delivery.DeliveryReceipt{MessageID: "stale", Status: delivery.StatusRetrying, Timestamp: time.Now().Add(-48 * time.Hour).Unix()}
+	if err := receipts.Put(stale); err != nil {
+		t.Fatalf("Failed to store receipt: %v", err)
+	}
+
+	// A tracker in a new process recovers the pending retry
+	reopened, err := delivery.NewFileReceiptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen receipt store: %v", err)
+	}
+	restarted := delivery.NewDeliveryTracker(strategy)
+	if err := restarted.SetReceiptStore(reopened); err != nil {
+		t.Fatalf("Failed to restore receipts: %v", err)
+	}
+	pending := restarted.GetPendingRetries()
+	if len(pending) != 1 || pending[0].MessageID != msg.MessageID {
+		t.Fatalf("Expected the retry to survive a restart, got %v", pending)
+	}
+	if pending[0].AttemptCount != 1 || pending[0].FailureReason != delivery.FailureTimeout {
+		t.Errorf("Expected attempt count and failure reason to be restored, got %+v", pending[0])
+	}
+	if _, err := restarted.GetDeliveryReceipt("stale"); err == nil {
+		t.Error("Expected the expired receipt not to be restored")
+	}
+	stored, _ := reopened.List()
+	if len(stored) != 1 {
+		t.Errorf("Expected the expired receipt to be removed from the store, got %d receipts", len(stored))
+	}
+
+	// Cleaning up expired receipts compacts the store
+	expiring := delivery.DefaultRetryStrategy()
+	expiring.ExpirationTime = time.Nanosecond
+	short := delivery.NewDeliveryTracker(expiring)
+	memory := delivery.NewMemoryReceiptStore()
+	if err := short.SetReceiptStore(memory); err != nil {
+		t.Fatalf("Failed to set receipt store: %v", err)
+	}
+	short.TrackMessage(msg)
+	if stored, _ := memory.List(); len(stored) != 1 {
+		t.Fatalf("Expected the tracked receipt to be stored, got %d", len(stored))
+	}
+	time.Sleep(time.Millisecond)
+	if cleaned := short.CleanupExpiredReceipts(); cleaned != 1 {
+		t.Fatalf("Expected one receipt cleaned up, got %d", cleaned)
+	}
+	if stored, _ := memory.List(); len(stored) != 0 {
+		t.Errorf("Expected cleanup to remove the stored receipt, got %d", len(stored))
+	}
+
+	// Receipts follow the tracker's deletions, and IDs cannot escape the directory
+	if err := restarted.WipeReceipts(); err != nil {
+		t.Fatalf("Failed to wipe receipts: %v", err)
+	}
+	if stored, _ := reopened.List(); len(stored) != 0 {
+		t.Errorf("Expected wiped receipts to be deleted, got %d", len(stored))
+	}
+	if err := reopened.Put(&delivery.DeliveryReceipt{MessageID: "../escape"}); err == nil {
+		t.Error("Expected an invalid message ID to be rejected")
+	}
+}
+
+func TestRetryWorker(t *testing.T) {
+	dir := t.TempDir()
+	receipts, err := delivery.NewFileReceiptStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create receipt store: %v", err)
+	}
+	strategy := delivery.DefaultRetryStrategy()
+	strategy.InitialDelay = 0
+	strategy.MaxRetries = 3
+
+	build := func(body string) *message.Message {
+		msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").Body(body).Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		return msg
+	}
+
+	// An earlier run left one retry with its message and one without
+	retained, lost := build("retained"), build("lost")
+	earlier := delivery.NewDeliveryTracker(strategy)
+	if err := earlier.SetReceiptStore(receipts); err != nil {
+		t.Fatalf("Failed to set receipt store: %v", err)
+	}
+	for _, msg := range []*message.Message{retained, lost} {
+		earlier.TrackMessage(msg)
+	}
+	if err := earlier.RetainPayload(retained); err != nil {
+		t.Fatalf("Failed to retain message: %v", err)
+	}
+	for _, msg := range []*message.Message{retained, lost} {
+		earlier.UpdateDeliveryFailure(msg.MessageID, delivery.StatusRetrying, delivery.FailureServerError, "503")
+	}
+
+	// Without a key pair every resend fails before reaching the network
+	config := client.DefaultConfig()
+	config.EnableDeliveryTracking = true
+	config.EnableNotifications = true
+	config.DeliveryRetryStrategy = strategy
+	config.ReceiptStore = receipts
+	config.RetryInterval = time.Hour
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	givenUp := make(chan string, 2)
+	c.RegisterNotificationHandler(notifications.EventDeliveryReceipt, func(n *notifications.Notification) error {
+		if n.Metadata["delivered"] == false {
+			givenUp <- n.Metadata["message_id"].(string)
+		}
+		return nil
+	})
+
+	sent, err := c.RetryDeliveries(context.Background())
+	if sent != 0 || err == nil {
+		t.Fatalf("Expected the resend to fail, got %d, %v", sent, err)
+	}
+	receipt, _ := c.GetDeliveryReceipt(retained.MessageID)
+	if receipt == nil || receipt.Status != delivery.StatusRetrying || receipt.AttemptCount != 2 {
+		t.Fatalf("Expected a second retrying attempt, got %+v", receipt)
+	}
+	receipt, _ = c.GetDeliveryReceipt(lost.MessageID)
+	if receipt == nil || receipt.Status != delivery.StatusFailed {
+		t.Errorf("Expected a retry without its message to fail, got %+v", receipt)
+	}
+
+	// The retry strategy gives up after MaxRetries attempts and releases the message
+	c.RetryDeliveries(context.Background())
+	receipt, _ = c.GetDeliveryReceipt(retained.MessageID)
+	if receipt == nil || receipt.Status != delivery.StatusFailed {
+		t.Fatalf("Expected the delivery to fail after max retries, got %+v", receipt)
+	}
+	if _, err := receipts.Payload(retained.MessageID); !errors.Is(err, delivery.ErrReceiptNotFound) {
+		t.Errorf("Expected the retained message to be released, got %v", err)
+	}
+
+	notified := map[string]bool{}
+	for len(notified) < 2 {
+		select {
+		case id := <-givenUp:
+			notified[id] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected failed delivery notifications for both messages, got %v", notified)
+		}
+	}
+
+	if err := c.StartRetryWorker(); err != nil {
+		t.Fatalf("Failed to start retry worker: %v", err)
+	}
+	if err := c.StartRetryWorker(); err == nil {
+		t.Error("Expected error starting the retry worker twice")
+	}
+	c.StopRetryWorker()
+	if c.IsRetryWorkerRunning() {
+		t.Error("Expected retry worker to be stopped")
+	}
+
+	// The worker needs delivery tracking and is off without an interval
+	config = client.DefaultConfig()
+	config.RetryInterval = time.Minute
+	if _, err := client.New(config); err == nil {
+		t.Error("Expected RetryInterval without delivery tracking to be rejected")
+	}
+	config.EnableDeliveryTracking = true
+	config.RetryInterval = 0
+	disabled, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := disabled.StartRetryWorker(); err == nil {
+		t.Error("Expected error when the retry worker is not enabled")
+	}
+}
+
+func TestPerRecipientReceipts(t *testing.T) {
+	tracker := delivery.NewDeliveryTracker(nil)
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org", "carol#partner.org").
+		CC("dave#test.org").
+		Body("per recipient").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+
+	var callbacks atomic.Int32
+	tracker.RegisterCallback(msg.MessageID, func(r *delivery.DeliveryReceipt) {
+		callbacks.Add(1)
+	})
+
+	tracker.TrackMessage(msg)
+	receipt, _ := tracker.GetDeliveryReceipt(msg.MessageID)
+	if len(receipt.Recipients) != 3 || receipt.Recipients["dave#test.org"].Domain != "test.org" {
+		t.Fatalf("Expected To and CC recipients to be tracked, got %+v", receipt.Recipients)
+	}
+
+	// One domain accepting the message leaves the overall status pending
+	if err := tracker.UpdateDomainStatus(msg.MessageID, "test.org", delivery.StatusSent, ""); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+	receipt, _ = tracker.GetDeliveryReceipt(msg.MessageID)
+	if receipt.Status != delivery.StatusPending || receipt.Recipients["bob#test.org"].Status != delivery.StatusSent {
+		t.Errorf("Expected test.org recipients sent and the message pending, got %s %+v", receipt.Status, receipt.Recipients)
+	}
+	domains := receipt.DomainStatuses()
+	if domains["test.org"] != delivery.StatusSent || domains["partner.org"] != delivery.StatusPending {
+		t.Errorf("Unexpected domain breakdown: %v", domains)
+	}
+
+	// A whole-message failure only reaches recipients not yet sent to
+	if err := tracker.UpdateDeliveryFailure(msg.MessageID, delivery.StatusFailed, delivery.FailureServerError, "502"); err != nil {
+		t.Fatalf("Failed to update delivery: %v", err)
+	}
+	receipt, _ = tracker.GetDeliveryReceipt(msg.MessageID)
+	carol := receipt.Recipients["carol#partner.org"]
+	if carol.Status != delivery.StatusFailed || carol.FailureReason != delivery.FailureServerError || carol.ErrorMessage != "502" {
+		t.Errorf("Expected carol to have failed, got %+v", carol)
+	}
+	if receipt.Recipients["bob#test.org"].Status != delivery.StatusSent {
+		t.Errorf("Expected bob to stay sent, got %+v", receipt.Recipients["bob#test.org"])
+	}
+
+	// Recipient receipts aggregate into the overall status
+	tracker.UpdateRecipientStatus(msg.MessageID, "carol#partner.org", delivery.StatusSent, "")
+	receipt, _ = tracker.GetDeliveryReceipt(msg.MessageID)
+	if receipt.Status != delivery.StatusSent {
+		t.Errorf("Expected every recipient reached to aggregate to sent, got %s", receipt.Status)
+	}
+	before := receipt
+	for _, recipient := range []string{"bob#test.org", "carol#PARTNER.org", "dave#test.org"} {
+		if err := tracker.UpdateRecipientStatus(msg.MessageID, recipient, delivery.StatusDelivered, ""); err != nil {
+			t.Fatalf("Failed to update recipient %s: %v", recipient, err)
+		}
+	}
+	receipt, _ = tracker.GetDeliveryReceipt(msg.MessageID)
+	if receipt.Status != delivery.StatusDelivered {
+		t.Errorf("Expected all recipients delivered to aggregate to delivered, got %s", receipt.Status)
+	}
+	if before.Recipients["bob#test.org"].Status != delivery.StatusSent {
+		t.Error("Earlier receipt copies should not change")
+	}
+	if err := tracker.UpdateRecipientStatus(msg.MessageID, "erin#test.org", delivery.StatusDelivered, ""); err == nil {
+		t.Error("Expected error for a recipient the message was not sent to")
+	}
+
+	// Only changes of the overall status run callbacks: failed, sent and delivered
+	time.Sleep(50 * time.Millisecond)
+	if got := callbacks.Load(); got != 3 {
+		t.Errorf("Expected 3 callbacks, got %d", got)
+	}
+}
+
+func TestOfflineMode(t *testing.T) {
+	var received atomic.Int32
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/messages" {
+			received.Add(1)
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// Sends fail to connect until the server URL is switched to the live one
+	var serverURL atomic.Value
+	serverURL.Store(dead.URL)
+
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.RetryStrategy.MaxRetries = 0
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: serverURL.Load().(string)}, nil
+	})
+	config.Outbox = store.NewMemoryOutboxStore()
+	config.OfflineMode = true
+	config.ConnectivityInterval = 10 * time.Millisecond
+	config.EnableNotifications = true
+	var events []notifications.NotificationEvent
+	var eventsMutex sync.Mutex
+	record := func(n *notifications.Notification) error {
+		eventsMutex.Lock()
+		defer eventsMutex.Unlock()
+		events = append(events, n.Event)
+		return nil
+	}
+	config.NotificationHandlers[notifications.EventOnline] = []notifications.NotificationHandler{record}
+	config.NotificationHandlers[notifications.EventOffline] = []notifications.NotificationHandler{record}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	send := func(body string) *client.SendResult {
+		t.Helper()
+		msg, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#test.org").Body(body).Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		result, err := emsgClient.SendMessageWithResult(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Expected the send to be queued, got %v", err)
+		}
+		return result
+	}
+
+	// A network error takes the client offline and queues the message
+	if !emsgClient.IsOnline() {
+		t.Fatal("Expected the client to start online")
+	}
+	if result := send("first"); !result.Queued {
+		t.Errorf("Expected the message to be queued, got %+v", result)
+	}
+	if emsgClient.IsOnline() {
+		t.Fatal("Expected the client to go offline")
+	}
+
+	// Later sends are queued without trying the network
+	serverURL.Store(live.URL)
+	if result := send("second"); !result.Queued || received.Load() != 0 {
+		t.Errorf("Expected the message to be queued while offline, got %+v", result)
+	}
+	if entries, _ := emsgClient.ListOutbox(); len(entries) != 2 {
+		t.Fatalf("Expected 2 queued messages, got %d", len(entries))
+	}
+	if entries, _ := emsgClient.ListOutbox(); entries[0].Message.IsSigned() {
+		t.Error("Queued message should not be modified by the failed send")
+	}
+
+	// The monitor notices the server is reachable again and drains the queue
+	if err := emsgClient.StartConnectivityMonitor(); err != nil {
+		t.Fatalf("Failed to start connectivity monitor: %v", err)
+	}
+	if err := emsgClient.StartConnectivityMonitor(); err == nil {
+		t.Error("Expected error starting the monitor twice")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !emsgClient.IsOnline() || received.Load() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the client to go online and send 2 messages, sent %d", received.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entries, _ := emsgClient.ListOutbox(); len(entries) != 0 {
+		t.Errorf("Expected the outbox to be drained, got %d entries", len(entries))
+	}
+
+	// Operating system hints take the client offline and make the monitor check again
+	emsgClient.NetworkChanged(false)
+	if emsgClient.IsOnline() {
+		t.Error("Expected the client to go offline when the network is lost")
+	}
+	emsgClient.NetworkChanged(true)
+	deadline = time.Now().Add(2 * time.Second)
+	for !emsgClient.IsOnline() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client to go back online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	emsgClient.StopConnectivityMonitor()
+	if emsgClient.IsConnectivityMonitorRunning() {
+		t.Error("Expected the monitor to be stopped")
+	}
+
+	eventsMutex.Lock()
+	got := fmt.Sprint(events)
+	eventsMutex.Unlock()
+	if got != "[offline online offline online]" {
+		t.Errorf("Unexpected connectivity notifications %s", got)
+	}
+
+	// Offline mode needs an outbox to queue messages in
+	config = client.DefaultConfig()
+	config.OfflineMode = true
+	if _, err := client.New(config); err == nil {
+		t.Error("Expected an error enabling offline mode without an outbox")
+	}
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emsg-protocol/emsg-client-sdk/client"
+	"github.com/emsg-protocol/emsg-client-sdk/discovery"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+)
+
+func TestNormalizeIdentifier(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"  Alice@Example.COM ", "alice@example.com"},
+		{"+1 (555) 123-4567", "+15551234567"},
+		{"555.123.4567", "5551234567"},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if result := discovery.NormalizeIdentifier(tc.input); result != tc.expected {
+			t.Errorf("NormalizeIdentifier(%q) = %q, expected %q", tc.input, result, tc.expected)
+		}
+	}
+}
+
+func TestHashIdentifier(t *testing.T) {
+	salt := []byte("server-salt")
+
+	a := discovery.HashIdentifier("Alice@Example.com", salt)
+	b := discovery.HashIdentifier("alice@example.com ", salt)
+	if a != b {
+		t.Error("Equivalent identifiers should hash identically")
+	}
+
+	if a == discovery.HashIdentifier("alice@example.com", []byte("other-salt")) {
+		t.Error("Different salts should produce different hashes")
+	}
+
+	hashes := discovery.HashIdentifiers([]string{"alice@example.com", "+1 555 0100", "   "}, salt)
+	if len(hashes) != 2 {
+		t.Errorf("Expected 2 hashes (blank skipped), got %d", len(hashes))
+	}
+	if hashes[a] != "alice@example.com" {
+		t.Error("Hash map should point back to the original identifier")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	if _, err := discovery.NewBloomFilter(0, 3); err == nil {
+		t.Error("Expected error for zero-sized filter")
+	}
+
+	filter, err := discovery.NewBloomFilter(8192, 5)
+	if err != nil {
+		t.Fatalf("Failed to create bloom filter: %v", err)
+	}
+
+	registered := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		identifier := fmt.Sprintf("user%d@example.com", i)
+		hash := discovery.HashIdentifier(identifier, nil)
+		registered[hash] = identifier
+		filter.Add(hash)
+	}
+
+	for hash := range registered {
+		if !filter.MayContain(hash) {
+			t.Fatal("Bloom filter must not have false negatives")
+		}
+	}
+
+	addressBook := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		identifier := fmt.Sprintf("stranger%d@example.org", i)
+		addressBook[discovery.HashIdentifier(identifier, nil)] = identifier
+	}
+	for hash, identifier := range registered {
+		addressBook[hash] = identifier
+	}
+
+	candidates := filter.Candidates(addressBook)
+	if len(candidates) < len(registered) {
+		t.Errorf("Expected at least %d candidates, got %d", len(registered), len(candidates))
+	}
+	// With 8192 bits and 5 hashes for 100 entries the false positive rate is well under 1%
+	if len(candidates) > len(registered)+20 {
+		t.Errorf("Too many false positives: %d candidates for %d registered", len(candidates), len(registered))
+	}
+}
+
+func TestDiscoverContactsFromServer(t *testing.T) {
+	salt := []byte("server-salt")
+	aliceEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	malloryEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	filter, _ := discovery.NewBloomFilter(8192, 5)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/discovery/filter":
+			json.NewEncoder(w).Encode(filter)
+		case "/api/v1/discovery":
+			json.NewEncoder(w).Encode(&discovery.Response{Matches: []*discovery.Match{
+				{
+					Hash:      discovery.HashIdentifier("alice@mail.example", salt),
+					Address:   "alice#example.com",
+					KeyBundle: &encryption.KeyBundle{Address: "alice#example.com", EncryptionKey: aliceEncKey.PublicKeyBase64()},
+				},
+				// The server of example.com cannot speak for another domain's users
+				{
+					Hash:      discovery.HashIdentifier("bob@mail.example", salt),
+					Address:   "bob#other.org",
+					KeyBundle: &encryption.KeyBundle{Address: "bob#other.org", EncryptionKey: malloryEncKey.PublicKeyBase64()},
+				},
+			}})
+		}
+	}))
+	defer server.Close()
+
+	recipientEncKey, _ := encryption.GenerateEncryptionKeyPair()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.EncryptionConfig = &encryption.EncryptionConfig{Enabled: true, KeyPair: recipientEncKey, KeyStore: encryption.NewMemoryKeyStore()}
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	opts := discovery.DefaultOptions()
+	opts.Salt = salt
+	contacts, err := emsgClient.DiscoverContacts("example.com", []string{"alice@mail.example", "bob@mail.example"}, opts)
+	if err != nil {
+		t.Fatalf("Failed to discover contacts: %v", err)
+	}
+	if len(contacts) != 1 || contacts[0].Address != "alice#example.com" {
+		t.Fatalf("Expected only the contact on the queried domain, got %+v", contacts)
+	}
+	if !emsgClient.CanEncryptFor("alice#example.com") {
+		t.Error("Expected the key of a contact on the queried domain to be pinned")
+	}
+	if emsgClient.CanEncryptFor("bob#other.org") {
+		t.Error("Expected no key to be pinned for an address on another domain")
+	}
+
+	// A filter beyond the limits is refused before it is used
+	filter.NumHashes = 1 << 30
+	opts.Mode = discovery.ModeBloomFilter
+	if _, err := emsgClient.DiscoverContacts("example.com", []string{"alice@mail.example"}, opts); err == nil {
+		t.Error("Expected a filter with too many hash functions to be rejected")
+	}
+	if _, err := discovery.NewBloomFilter(discovery.MaxBloomFilterBits+1, 3); err == nil {
+		t.Error("Expected a filter beyond the size limit to be refused")
+	}
+}
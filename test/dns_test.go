@@ -0,0 +1,217 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emsg-protocol/emsg-client-sdk/client"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+)
+
+// txtAnswer builds a response to a DNS query answering with one TXT record made
+// of the given strings, or NXDOMAIN without strings
+func txtAnswer(query []byte, strs ...string) []byte {
+	// The question ends after the name's zero label, type and class
+	end := 12
+	for query[end] != 0 {
+		end += 1 + int(query[end])
+	}
+	end += 5
+
+	response := append([]byte{}, query[:end]...)
+	binary.BigEndian.PutUint16(response[2:], 0x8180)
+	if len(strs) == 0 {
+		binary.BigEndian.PutUint16(response[2:], 0x8183)
+		return response
+	}
+	binary.BigEndian.PutUint16(response[6:], 1)
+
+	var data []byte
+	for _, s := range strs {
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	response = append(response, 0xc0, 12) // Pointer to the question's name
+	response = binary.BigEndian.AppendUint16(response, 16)
+	response = binary.BigEndian.AppendUint16(response, 1)
+	response = binary.BigEndian.AppendUint32(response, 300)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(data)))
+	return append(response, data...)
+}
+
+func TestResolverDoH(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		if !bytes.Contains(query, []byte("\x07example\x03com\x00")) {
+			w.Write(txtAnswer(query))
+			return
+		}
+		// Long records arrive split into several strings
+		w.Write(txtAnswer(query, `{"url":"https://emsg.`, `example.com","version":"1.0"}`))
+	}))
+	defer server.Close()
+
+	config := dns.DefaultResolverConfig()
+	config.DoHURL = server.URL
+	config.HTTPClient = server.Client()
+	config.Retries = 1
+	resolver := dns.NewResolver(config)
+
+	info, err := resolver.ResolveDomain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to resolve over DoH: %v", err)
+	}
+	if info.URL != "https://emsg.example.com" || info.Version != "1.0" {
+		t.Errorf("Unexpected server info: %+v", info)
+	}
+
+	_, err = resolver.ResolveDomain("missing.org")
+	var dnsErr *net.DNSError
+	if !errors.Is(err, dns.ErrDomainResolution) || !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected a not found resolution error, got %v", err)
+	}
+}
+
+func TestResolverNameserver(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(txtAnswer(buf[:n], "url=https://emsg.example.com pubkey=abc"), addr)
+		}
+	}()
+
+	config := dns.DefaultResolverConfig()
+	config.Nameserver = conn.LocalAddr().String()
+	config.Retries = 1
+	info, err := dns.NewResolver(config).ResolveDomain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to resolve with nameserver: %v", err)
+	}
+	if info.URL != "https://emsg.example.com" || info.PublicKey != "abc" {
+		t.Errorf("Unexpected server info: %+v", info)
+	}
+}
+
+func TestResolverCustomLookup(t *testing.T) {
+	var queried string
+	config := dns.DefaultResolverConfig()
+	config.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		queried = name
+		return []string{"https://emsg.example.com"}, nil
+	}
+
+	info, err := dns.NewCachedResolver(config, 0).ResolveDomain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to resolve with custom lookup: %v", err)
+	}
+	if queried != "_emsg.example.com" || info.URL != "https://emsg.example.com" {
+		t.Errorf("Unexpected lookup of %s: %+v", queried, info)
+	}
+}
+
+func TestResolverWellKnownFallback(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != dns.WellKnownPath || r.Host != "example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"url":"https://emsg.example.com","pubkey":"abc"}`))
+	}))
+	defer server.Close()
+
+	// Send every host to the test server, whose certificate is valid for example.com
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	var txtLookups int
+	config := dns.DefaultResolverConfig()
+	config.Retries = 1
+	config.HTTPClient = &http.Client{Transport: transport}
+	config.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		txtLookups++
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	info, err := dns.NewResolver(config).ResolveDomain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to fall back to .well-known: %v", err)
+	}
+	if info.URL != "https://emsg.example.com" || info.PublicKey != "abc" || txtLookups != 1 {
+		t.Errorf("Unexpected server info %+v after %d TXT lookups", info, txtLookups)
+	}
+
+	// Both methods failing reports both
+	_, err = dns.NewResolver(config).ResolveDomain("other.example.com")
+	var dnsErr *net.DNSError
+	if !errors.Is(err, dns.ErrDomainResolution) || !errors.As(err, &dnsErr) || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("Expected the DNS and .well-known failures, got %v", err)
+	}
+
+	// The discovery order is configurable
+	config.DiscoveryOrder = []dns.DiscoveryMethod{dns.DiscoveryWellKnown}
+	txtLookups = 0
+	if _, err := dns.NewResolver(config).ResolveDomain("example.com"); err != nil || txtLookups != 0 {
+		t.Errorf("Expected .well-known only, got %v after %d TXT lookups", err, txtLookups)
+	}
+	config.DiscoveryOrder = []dns.DiscoveryMethod{dns.DiscoveryDNS}
+	if _, err := dns.NewResolver(config).ResolveDomain("example.com"); err == nil {
+		t.Error("Expected DNS-only discovery to fail without TXT records")
+	}
+}
+
+func TestResolverConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config dns.ResolverConfig
+		valid  bool
+	}{
+		{"system", dns.ResolverConfig{}, true},
+		{"nameserver", dns.ResolverConfig{Nameserver: "10.0.0.53:53"}, true},
+		{"IPv6 nameserver", dns.ResolverConfig{Nameserver: "[2001:db8::53]:53"}, true},
+		{"nameserver without port", dns.ResolverConfig{Nameserver: "10.0.0.53"}, false},
+		{"nameserver hostname", dns.ResolverConfig{Nameserver: "dns.example.com:53"}, false},
+		{"DoH", dns.ResolverConfig{DoHURL: "https://dns.example.com/dns-query"}, true},
+		{"plain HTTP DoH", dns.ResolverConfig{DoHURL: "http://dns.example.com/dns-query"}, false},
+		{"two backends", dns.ResolverConfig{Nameserver: "10.0.0.53:53", DoHURL: "https://dns.example.com/dns-query"}, false},
+		{"discovery order", dns.ResolverConfig{DiscoveryOrder: []dns.DiscoveryMethod{dns.DiscoveryWellKnown, dns.DiscoveryDNS}}, true},
+		{"unknown discovery method", dns.ResolverConfig{DiscoveryOrder: []dns.DiscoveryMethod{"srv"}}, false},
+		{"repeated discovery method", dns.ResolverConfig{DiscoveryOrder: []dns.DiscoveryMethod{dns.DiscoveryDNS, dns.DiscoveryDNS}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+	}
+
+	config := client.DefaultConfig()
+	config.DNSConfig = &dns.ResolverConfig{DoHURL: "http://dns.example.com/dns-query"}
+	var configErrs client.ConfigErrors
+	if err := config.Validate(); !errors.As(err, &configErrs) || configErrs[0].Field != "DNSConfig" {
+		t.Errorf("Expected a DNSConfig error, got %v", err)
+	}
+}
@@ -1,9 +1,14 @@
 package test
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/emsg-protocol/emsg-client-sdk/client"
 	"github.com/emsg-protocol/emsg-client-sdk/encryption"
 )
 
@@ -278,3 +283,280 @@ func TestRegisterPublicKey(t *testing.T) {
 		t.Error("Expected error with short key")
 	}
 }
+
+func TestPinKeyBundle(t *testing.T) {
+	ourKeyPair, _ := encryption.GenerateEncryptionKeyPair()
+	senderKeyPair, _ := encryption.GenerateEncryptionKeyPair()
+	otherKeyPair, _ := encryption.GenerateEncryptionKeyPair()
+
+	manager := encryption.NewEncryptionManager(ourKeyPair, encryption.NewMemoryKeyStore())
+
+	bundle := &encryption.KeyBundle{
+		Address:       "alice#example.com",
+		EncryptionKey: senderKeyPair.PublicKeyBase64(),
+	}
+
+	pinned, err := manager.PinKeyBundle(bundle)
+	if err != nil {
+		t.Fatalf("Failed to pin key bundle: %v", err)
+	}
+	if !pinned {
+		t.Error("Expected key to be pinned on first use")
+	}
+	if !manager.CanEncryptFor("alice#example.com") {
+		t.Error("Should be able to encrypt for alice after pinning")
+	}
+
+	// Same key again is a no-op
+	pinned, err = manager.PinKeyBundle(bundle)
+	if err != nil || pinned {
+		t.Errorf("Expected no-op for already pinned key, got pinned=%v err=%v", pinned, err)
+	}
+
+	// A different key must not replace the pinned one
+	_, err = manager.PinKeyBundle(&encryption.KeyBundle{
+		Address:       "alice#example.com",
+		EncryptionKey: otherKeyPair.PublicKeyBase64(),
+	})
+	if !errors.Is(err, encryption.ErrKeyBundleMismatch) {
+		t.Errorf("Expected ErrKeyBundleMismatch, got %v", err)
+	}
+
+	if _, err := manager.PinKeyBundle(&encryption.KeyBundle{Address: "bob#example.com", EncryptionKey: "invalid"}); err == nil {
+		t.Error("Expected error for invalid key")
+	}
+}
+
+func TestKeyDiscovery(t *testing.T) {
+	senderKeys, _ := encryption.GenerateEncryptionKeyPair()
+	bobKeys, _ := encryption.GenerateEncryptionKeyPair()
+	carolKeys, _ := encryption.GenerateEncryptionKeyPair()
+
+	published := map[string]string{
+		"bob#example.com": bobKeys.PublicKeyBase64(),
+	}
+	fetches := make(map[string]int)
+	fetcher := func(address string) (string, error) {
+		fetches[address]++
+		key, ok := published[address]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return key, nil
+	}
+
+	keyStore := encryption.NewMemoryKeyStore()
+	discovery := encryption.NewKeyDiscovery(fetcher, 50*time.Millisecond, time.Hour)
+	manager := encryption.NewEncryptionManager(senderKeys, keyStore)
+	manager.SetKeyDiscovery(discovery)
+
+	// Missing keys are fetched and cached in the key store
+	if !manager.CanEncryptFor("bob#example.com") {
+		t.Fatal("Expected bob's key to be discovered")
+	}
+	encrypted, err := manager.EncryptForRecipient([]byte("hi"), "bob#example.com")
+	if err != nil {
+		t.Fatalf("Failed to encrypt for discovered key: %v", err)
+	}
+	if plaintext, err := bobKeys.Decrypt(encrypted); err != nil || string(plaintext) != "hi" {
+		t.Errorf("Bob could not decrypt: %v", err)
+	}
+	if fetches["bob#example.com"] != 1 || !keyStore.HasPublicKey("bob#example.com") {
+		t.Errorf("Expected one fetch cached in the key store, got %d fetches", fetches["bob#example.com"])
+	}
+
+	// Failed lookups are not repeated within the negative TTL
+	if manager.CanEncryptFor("dave#example.com") || manager.CanEncryptFor("dave#example.com") {
+		t.Error("Expected no key for dave")
+	}
+	if fetches["dave#example.com"] != 1 {
+		t.Errorf("Expected a single failed fetch, got %d", fetches["dave#example.com"])
+	}
+
+	// Manually registered keys are never fetched
+	if err := manager.RegisterPublicKey("carol#example.com", carolKeys.PublicKeyBase64()); err != nil {
+		t.Fatalf("Failed to register key: %v", err)
+	}
+	if !manager.CanEncryptFor("carol#example.com") || fetches["carol#example.com"] != 0 {
+		t.Error("Expected registered key to be used without fetching")
+	}
+
+	// Discovered keys are refreshed after their TTL, picking up rotated keys
+	rotated, _ := encryption.GenerateEncryptionKeyPair()
+	published["bob#example.com"] = rotated.PublicKeyBase64()
+	time.Sleep(60 * time.Millisecond)
+	if !manager.CanEncryptFor("bob#example.com") {
+		t.Fatal("Expected bob's key to be refreshed")
+	}
+	if key, _ := keyStore.GetPublicKey("bob#example.com"); key != rotated.PublicKey {
+		t.Error("Expected the rotated key after refresh")
+	}
+	if fetches["bob#example.com"] != 2 {
+		t.Errorf("Expected a second fetch after expiry, got %d", fetches["bob#example.com"])
+	}
+}
+
+// flakyKeyStore is a batch key store whose writes can be made to fail
+type flakyKeyStore struct {
+	*encryption.MemoryKeyStore
+	mutex   sync.Mutex
+	fail    bool
+	batches []int
+}
+
+func (f *flakyKeyStore) setFailing(fail bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fail = fail
+}
+
+func (f *flakyKeyStore) StorePublicKeys(keys map[string][32]byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.fail {
+		return errors.New("disk full")
+	}
+	f.batches = append(f.batches, len(keys))
+	for address, key := range keys {
+		f.MemoryKeyStore.StorePublicKey(address, key)
+	}
+	return nil
+}
+
+func TestWriteBehindKeyStore(t *testing.T) {
+	backing := &flakyKeyStore{MemoryKeyStore: encryption.NewMemoryKeyStore()}
+	wb := encryption.NewWriteBehindKeyStore(backing, &encryption.WriteBehindConfig{
+		FlushInterval:     time.Hour,
+		MaxBatchSize:      2,
+		InitialRetryDelay: time.Hour,
+		MaxRetryDelay:     time.Hour,
+	})
+
+	key := [32]byte{1}
+	if err := wb.StorePublicKey("alice#example.com", key); err != nil {
+		t.Fatalf("StorePublicKey failed: %v", err)
+	}
+
+	// Pending writes are readable before they are persisted
+	if got, err := wb.GetPublicKey("alice#example.com"); err != nil || got != key || !wb.HasPublicKey("alice#example.com") {
+		t.Errorf("Expected pending key to be readable, got %v, %v", got, err)
+	}
+	if backing.HasPublicKey("alice#example.com") || wb.Pending() != 1 {
+		t.Errorf("Expected write to be deferred, pending=%d", wb.Pending())
+	}
+
+	// Reaching the batch size triggers a background flush
+	wb.StorePublicKey("bob#example.com", [32]byte{2})
+	deadline := time.Now().Add(time.Second)
+	for wb.Pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected full batch to be flushed, %d pending", wb.Pending())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !backing.HasPublicKey("alice#example.com") || !backing.HasPublicKey("bob#example.com") {
+		t.Error("Expected flushed keys in the backing store")
+	}
+
+	// Failed flushes keep the writes pending
+	backing.setFailing(true)
+	wb.StorePublicKey("carol#example.com", [32]byte{3})
+	if err := wb.Flush(context.Background()); err == nil {
+		t.Error("Expected flush to fail")
+	}
+	if wb.Pending() != 1 || !wb.HasPublicKey("carol#example.com") {
+		t.Errorf("Expected failed write to stay pending, pending=%d", wb.Pending())
+	}
+
+	// Close persists what is left once the store recovers
+	backing.setFailing(false)
+	if err := wb.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !backing.HasPublicKey("carol#example.com") || wb.Pending() != 0 {
+		t.Error("Expected Close to persist pending writes")
+	}
+	if err := wb.Flush(context.Background()); !errors.Is(err, encryption.ErrKeyStoreClosed) {
+		t.Errorf("Expected ErrKeyStoreClosed after Close, got %v", err)
+	}
+
+	// Writes after Close go straight to the backing store
+	wb.StorePublicKey("dave#example.com", [32]byte{4})
+	if !backing.HasPublicKey("dave#example.com") {
+		t.Error("Expected write after Close to be synchronous")
+	}
+	for _, size := range backing.batches {
+		if size > 2 {
+			t.Errorf("Expected batches of at most 2 keys, got %d", size)
+		}
+	}
+
+	// The client flushes its write-behind key store on Close
+	encKeyPair, err := encryption.GenerateEncryptionKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate encryption key pair: %v", err)
+	}
+	clientStore := encryption.NewMemoryKeyStore()
+	config := client.DefaultConfig()
+	config.EncryptionConfig = &encryption.EncryptionConfig{
+		Enabled:     true,
+		KeyPair:     encKeyPair,
+		KeyStore:    clientStore,
+		WriteBehind: &encryption.WriteBehindConfig{FlushInterval: time.Hour},
+	}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := emsgClient.RegisterPublicKey("erin#example.com", encKeyPair.PublicKeyBase64()); err != nil {
+		t.Fatalf("RegisterPublicKey failed: %v", err)
+	}
+	if emsgClient.PendingKeyStoreWrites() != 1 || !emsgClient.CanEncryptFor("erin#example.com") {
+		t.Errorf("Expected a pending but usable key, pending=%d", emsgClient.PendingKeyStoreWrites())
+	}
+	if err := emsgClient.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !clientStore.HasPublicKey("erin#example.com") {
+		t.Error("Expected Close to persist the registered key")
+	}
+}
+
+func TestKeyDiscoveryCoalescesConcurrentLookups(t *testing.T) {
+	bobKeys, _ := encryption.GenerateEncryptionKeyPair()
+
+	var mutex sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+	fetcher := func(address string) (string, error) {
+		mutex.Lock()
+		fetches++
+		mutex.Unlock()
+		<-release
+		return bobKeys.PublicKeyBase64(), nil
+	}
+
+	keyStore := encryption.NewMemoryKeyStore()
+	discovery := encryption.NewKeyDiscovery(fetcher, time.Hour, time.Minute)
+
+	const lookups = 5
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := discovery.Lookup(keyStore, "bob#example.com")
+			if err != nil || key != bobKeys.PublicKey {
+				t.Errorf("Lookup failed: %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("Expected concurrent lookups to share one fetch, got %d", fetches)
+	}
+}
@@ -1,13 +1,21 @@
 package test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/client"
 	"github.com/emsg-protocol/emsg-client-sdk/groups"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
 )
 
 // TestGroupManager tests the basic GroupManager functionality
@@ -716,3 +724,1225 @@ func TestGroupInvalidOperations(t *testing.T) {
 		t.Error("Expected error when getting non-existent member")
 	}
 }
+
+// TestGroupMembersPagination tests paginated and iterator member access
+func TestGroupMembersPagination(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner := "alice#example.com"
+
+	settings := groups.DefaultGroupSettings()
+	settings.MaxMembers = 1000
+
+	group, err := gm.CreateGroup("paged#example.com", "Paged Group", owner, settings)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+
+	for i := 0; i < 25; i++ {
+		role := groups.RoleMember
+		if i%5 == 0 {
+			role = groups.RoleModerator
+		}
+		if err := group.AddMember(fmt.Sprintf("user%02d#example.com", i), owner, role); err != nil {
+			t.Fatalf("Failed to add member %d: %v", i, err)
+		}
+	}
+
+	// Page through all members and make sure every member is seen exactly once
+	seen := make(map[string]bool)
+	offset := 0
+	for {
+		page, err := group.GetMembersPage(offset, 10, nil)
+		if err != nil {
+			t.Fatalf("Failed to get members page: %v", err)
+		}
+		if page.Total != 26 {
+			t.Errorf("Expected total 26, got %d", page.Total)
+		}
+		for _, member := range page.Members {
+			if seen[member.Address] {
+				t.Errorf("Member %s returned twice", member.Address)
+			}
+			seen[member.Address] = true
+		}
+		offset += len(page.Members)
+		if !page.HasMore {
+			break
+		}
+	}
+	if len(seen) != 26 {
+		t.Errorf("Expected 26 distinct members, got %d", len(seen))
+	}
+
+	// Filter by role
+	page, err := group.GetMembersPage(0, 100, &groups.MemberFilter{Role: groups.RoleModerator})
+	if err != nil {
+		t.Fatalf("Failed to get filtered page: %v", err)
+	}
+	if page.Total != 5 || len(page.Members) != 5 || page.HasMore {
+		t.Errorf("Expected 5 moderators in a single page, got total=%d len=%d hasMore=%v", page.Total, len(page.Members), page.HasMore)
+	}
+
+	if count := group.CountMembers(&groups.MemberFilter{Status: "active", Role: groups.RoleMember}); count != 20 {
+		t.Errorf("Expected 20 active members, got %d", count)
+	}
+
+	// Iterator stops early when the callback returns false
+	visited := 0
+	group.MembersIter(nil, func(member *groups.GroupMember) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("Expected iterator to stop after 3 members, visited %d", visited)
+	}
+
+	// Invalid arguments
+	if _, err := group.GetMembersPage(-1, 10, nil); err == nil {
+		t.Error("Expected error for negative offset")
+	}
+	if _, err := group.GetMembersPage(0, 0, nil); err == nil {
+		t.Error("Expected error for zero limit")
+	}
+
+	// The callback runs without the group's lock, so it may change the group;
+	// members are visited in order across chunks
+	for i := 25; i < 600; i++ {
+		if err := group.AddMember(fmt.Sprintf("user%03d#example.com", i), owner, groups.RoleMember); err != nil {
+			t.Fatalf("Failed to add member %d: %v", i, err)
+		}
+	}
+	total := group.CountMembers(nil)
+	var previous string
+	visited, removed := 0, 0
+	group.MembersIter(&groups.MemberFilter{Role: groups.RoleMember}, func(member *groups.GroupMember) bool {
+		if member.Address <= previous {
+			t.Errorf("Expected members in address order, got %s after %s", member.Address, previous)
+		}
+		previous = member.Address
+		visited++
+		if visited%2 == 0 {
+			if err := group.RemoveMember(member.Address, owner); err != nil {
+				t.Errorf("Failed to remove member from the callback: %v", err)
+			}
+			removed++
+		}
+		return true
+	})
+	if visited != 595 {
+		t.Errorf("Expected 595 members visited, got %d", visited)
+	}
+
+	// Pages follow the sorted index as members come and go
+	page, err = group.GetMembersPage(0, total, nil)
+	if err != nil {
+		t.Fatalf("Failed to get members page: %v", err)
+	}
+	if page.Total != total-removed || len(page.Members) != page.Total || page.HasMore {
+		t.Fatalf("Expected %d members on one page, got total=%d len=%d", total-removed, page.Total, len(page.Members))
+	}
+	for i := 1; i < len(page.Members); i++ {
+		if page.Members[i-1].Address >= page.Members[i].Address {
+			t.Fatalf("Expected the page in address order at %d", i)
+		}
+	}
+	page, err = group.GetMembersPage(page.Total-1, 10, nil)
+	if err != nil || len(page.Members) != 1 || page.HasMore {
+		t.Errorf("Expected the last member alone on the last page, got %+v: %v", page, err)
+	}
+}
+
+// TestGroupSlowMode tests per-member send intervals in slow-mode groups
+func TestGroupSlowMode(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner := "alice#example.com"
+	member := "bob#example.com"
+
+	group, err := gm.CreateGroup("slow#example.com", "Slow Group", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if err := group.AddMember(member, owner, groups.RoleMember); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+
+	if err := group.SetSlowMode(time.Hour, member); err == nil {
+		t.Error("Expected member to be unable to change slow mode")
+	}
+	if err := group.SetSlowMode(time.Hour, owner); err != nil {
+		t.Fatalf("Failed to set slow mode: %v", err)
+	}
+
+	if err := group.CheckSlowMode(member); err != nil {
+		t.Errorf("Expected first message to be allowed, got %v", err)
+	}
+	group.RecordMessageSent(member)
+
+	err = group.CheckSlowMode(member)
+	var slowErr *groups.SlowModeError
+	if !errors.As(err, &slowErr) {
+		t.Fatalf("Expected SlowModeError, got %v", err)
+	}
+	if slowErr.RetryAfter <= 0 || slowErr.RetryAfter > time.Hour || slowErr.Member != member {
+		t.Errorf("Unexpected slow mode error: %+v", slowErr)
+	}
+
+	// Owners and moderators are exempt
+	group.RecordMessageSent(owner)
+	if err := group.CheckSlowMode(owner); err != nil {
+		t.Errorf("Expected owner to be exempt, got %v", err)
+	}
+
+	// The setting travels with the group's settings
+	data, err := group.ToJSON()
+	if err != nil {
+		t.Fatalf("Failed to serialize group: %v", err)
+	}
+	restored, err := groups.FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize group: %v", err)
+	}
+	if restored.SlowModeInterval() != time.Hour {
+		t.Errorf("Expected slow mode interval 1h after sync, got %v", restored.SlowModeInterval())
+	}
+
+	if err := group.SetSlowMode(0, owner); err != nil {
+		t.Fatalf("Failed to disable slow mode: %v", err)
+	}
+	if err := group.CheckSlowMode(member); err != nil {
+		t.Errorf("Expected no throttling with slow mode off, got %v", err)
+	}
+}
+
+// TestClientGroupSlowMode tests that the client enforces slow mode before sending
+func TestClientGroupSlowMode(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	owner := "alice#example.com"
+	member := "bob#example.com"
+	groupID := "slow#example.com"
+	if _, err := emsgClient.CreateGroup(groupID, "Slow Group", owner, nil); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if err := emsgClient.AddGroupMember(groupID, member, owner, groups.RoleMember); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	if err := emsgClient.SetGroupSlowMode(groupID, owner, time.Minute); err != nil {
+		t.Fatalf("Failed to set slow mode: %v", err)
+	}
+
+	group, _ := emsgClient.GetGroup(groupID)
+	group.RecordMessageSent(member)
+
+	// The send is rejected locally, before any network access
+	err = emsgClient.SendGroupMessage(groupID, member, "too soon")
+	var slowErr *groups.SlowModeError
+	if !errors.As(err, &slowErr) {
+		t.Fatalf("Expected SlowModeError from send, got %v", err)
+	}
+	if err := emsgClient.CheckGroupSlowMode(groupID, member); !errors.As(err, &slowErr) {
+		t.Errorf("Expected CheckGroupSlowMode to report the wait, got %v", err)
+	}
+}
+
+func TestGroupGuestModeration(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner := "alice#example.com"
+	moderator := "carol#example.com"
+	member := "bob#example.com"
+	guest := "dave#example.com"
+	groupID := "moderated#example.com"
+
+	group, err := gm.CreateGroup(groupID, "Moderated Group", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(moderator, owner, groups.RoleModerator)
+	group.AddMember(member, owner, groups.RoleMember)
+	group.AddMember(guest, owner, groups.RoleGuest)
+
+	if group.RequiresModeration(guest) {
+		t.Error("Guest moderation should be off by default")
+	}
+	if err := group.SetGuestModeration(true, member); err == nil {
+		t.Error("Expected error when a member enables guest moderation")
+	}
+	if err := group.SetGuestModeration(true, owner); err != nil {
+		t.Fatalf("Failed to enable guest moderation: %v", err)
+	}
+	if !group.RequiresModeration(guest) || group.RequiresModeration(member) {
+		t.Error("Only guests should require moderation")
+	}
+
+	msg := &message.Message{From: guest, To: []string{groupID}, GroupID: groupID, Body: "hi", MessageID: "guest-1"}
+	if err := group.SubmitForModeration(msg); err != nil {
+		t.Fatalf("Failed to submit guest message: %v", err)
+	}
+	if err := group.SubmitForModeration(&message.Message{From: member, MessageID: "member-1"}); err == nil {
+		t.Error("Expected error submitting a member's message for moderation")
+	}
+	if pending := group.PendingMessages(); len(pending) != 1 || pending[0].Author != guest {
+		t.Fatalf("Expected one pending guest message, got %v", pending)
+	}
+
+	moderators := group.Moderators()
+	if len(moderators) != 2 || moderators[0] != owner || moderators[1] != moderator {
+		t.Errorf("Expected owner and moderator as moderators, got %v", moderators)
+	}
+
+	if _, err := group.ApproveMessage("guest-1", member); err == nil {
+		t.Error("Expected error when a member approves a message")
+	}
+	approved, err := group.ApproveMessage("guest-1", moderator)
+	if err != nil || approved.Message.MessageID != "guest-1" {
+		t.Fatalf("Failed to approve message: %v", err)
+	}
+	if _, err := group.RejectMessage("guest-1", moderator); err == nil {
+		t.Error("Expected error resolving a message twice")
+	}
+
+	// Allowing guest messages outright bypasses moderation
+	group.Settings.AllowGuestMessages = true
+	if group.RequiresModeration(guest) {
+		t.Error("Guests should not be moderated when guest messages are allowed")
+	}
+}
+
+func TestClientGuestModeration(t *testing.T) {
+	var requested []*notifications.Notification
+	var mutex sync.Mutex
+
+	// Without a key pair nothing can reach the network
+	config := client.DefaultConfig()
+	config.EnableNotifications = true
+	config.NotificationHandlers[notifications.EventModerationRequested] = []notifications.NotificationHandler{
+		func(n *notifications.Notification) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requested = append(requested, n)
+			return nil
+		},
+	}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	owner := "alice#example.com"
+	guest := "dave#example.com"
+	groupID := "moderated#example.com"
+	emsgClient.CreateGroup(groupID, "Moderated Group", owner, nil)
+	emsgClient.AddGroupMember(groupID, guest, owner, groups.RoleGuest)
+	if err := emsgClient.SetGroupGuestModeration(groupID, owner, true); err != nil {
+		t.Fatalf("Failed to enable guest moderation: %v", err)
+	}
+
+	msg, _ := message.NewMessageBuilder().From(guest).To(groupID).GroupID(groupID).Body("hello").Build()
+	if err := emsgClient.SendMessage(msg); err != nil {
+		t.Fatalf("Expected guest message to be held, got %v", err)
+	}
+
+	pending, err := emsgClient.GetPendingGuestMessages(groupID)
+	if err != nil || len(pending) != 1 || pending[0].Message.MessageID != msg.MessageID {
+		t.Fatalf("Expected held guest message, got %v, %v", pending, err)
+	}
+
+	mutex.Lock()
+	if len(requested) != 1 || requested[0].Metadata["group_id"] != groupID {
+		t.Errorf("Expected one moderation request notification, got %v", requested)
+	}
+	mutex.Unlock()
+
+	// A failed fan-out returns the message to the queue
+	if err := emsgClient.ApproveGuestMessage(groupID, msg.MessageID, owner); err == nil {
+		t.Error("Expected approval to fail without a key pair")
+	}
+	if pending, _ := emsgClient.GetPendingGuestMessages(groupID); len(pending) != 1 {
+		t.Errorf("Expected message to be requeued after a failed send, got %d", len(pending))
+	}
+
+	if err := emsgClient.RejectGuestMessage(groupID, msg.MessageID, owner, "off topic"); err != nil {
+		t.Fatalf("Failed to reject message: %v", err)
+	}
+	if pending, _ := emsgClient.GetPendingGuestMessages(groupID); len(pending) != 0 {
+		t.Errorf("Expected empty queue after rejection, got %d", len(pending))
+	}
+}
+
+func TestGroupImportAndReplay(t *testing.T) {
+	gm := groups.NewGroupManager()
+	local, err := gm.CreateGroup("eng#example.com", "Engineering", "alice#example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if err := local.SetSlowMode(time.Minute, "alice#example.com"); err != nil {
+		t.Fatalf("Failed to set slow mode: %v", err)
+	}
+	if err := local.AddMember("dave#example.com", "alice#example.com", groups.RoleMember); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	local.RecordMessageSent("dave#example.com")
+
+	// Another client added bob and made him an admin on the server
+	remote, err := groups.FromJSON(mustGroupJSON(t, local))
+	if err != nil {
+		t.Fatalf("Failed to copy group: %v", err)
+	}
+	remote.Members["bob#example.com"] = &groups.GroupMember{Address: "bob#example.com", Role: groups.RoleAdmin}
+	remote.Version = 7
+
+	imported, err := gm.ImportGroup(remote)
+	if err != nil {
+		t.Fatalf("Failed to import group: %v", err)
+	}
+	if imported != local {
+		t.Error("Expected import to update the existing group in place")
+	}
+	if local.GetVersion() != 7 {
+		t.Errorf("Expected version 7, got %d", local.GetVersion())
+	}
+	if _, err := local.GetMember("bob#example.com"); err != nil {
+		t.Errorf("Expected remote member to be imported: %v", err)
+	}
+	if err := local.CheckSlowMode("dave#example.com"); err == nil {
+		t.Error("Expected local slow mode state to survive the import")
+	}
+
+	// A local change replays on the server's state
+	op := groups.NewGroupOperation(groups.OpAddMember, "eng#example.com", "bob#example.com")
+	op.Member, op.Role = "carol#example.com", groups.RoleMember
+	if err := local.Apply(op); err != nil {
+		t.Errorf("Expected admin's add to replay: %v", err)
+	}
+	if err := local.Apply(op); err == nil {
+		t.Error("Expected replaying an add for an existing member to fail")
+	}
+
+	// Unknown groups are added
+	other := &groups.Group{ID: "ops#example.com", Name: "Ops", Members: map[string]*groups.GroupMember{}}
+	if _, err := gm.ImportGroup(other); err != nil {
+		t.Fatalf("Failed to import new group: %v", err)
+	}
+	if _, err := gm.GetGroup("ops#example.com"); err != nil {
+		t.Errorf("Expected imported group to be added: %v", err)
+	}
+
+	if domain, err := groups.GroupDomain("eng#Example.com"); err != nil || domain != "example.com" {
+		t.Errorf("Expected domain example.com, got %q (%v)", domain, err)
+	}
+	if _, err := groups.GroupDomain("eng"); err == nil {
+		t.Error("Expected group ID without a domain to be rejected")
+	}
+}
+
+func mustGroupJSON(t *testing.T, group *groups.Group) []byte {
+	t.Helper()
+	data, err := group.ToJSON()
+	if err != nil {
+		t.Fatalf("Failed to serialize group: %v", err)
+	}
+	return data
+}
+
+func TestGroupOwnershipTransfer(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, admin, member := "alice#example.com", "bob#example.com", "carol#example.com"
+	group, err := gm.CreateGroup("team", "Team", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(admin, owner, groups.RoleAdmin)
+	group.AddMember(member, owner, groups.RoleMember)
+
+	if err := group.TransferOwnership(member, admin); err == nil {
+		t.Error("Expected non-owner transfer to fail")
+	}
+	if err := group.TransferOwnership(owner, owner); err == nil {
+		t.Error("Expected transfer to self to fail")
+	}
+	if err := group.TransferOwnership("dave#example.com", owner); err == nil {
+		t.Error("Expected transfer to non-member to fail")
+	}
+
+	if err := group.TransferOwnership(admin, owner); err != nil {
+		t.Fatalf("Failed to transfer ownership: %v", err)
+	}
+	if m, _ := group.GetMember(admin); m.Role != groups.RoleOwner {
+		t.Errorf("Expected new owner, got %s", m.Role)
+	}
+	if m, _ := group.GetMember(owner); m.Role != groups.RoleAdmin {
+		t.Errorf("Expected previous owner to be demoted to admin, got %s", m.Role)
+	}
+	if owners := group.GetOwners(); len(owners) != 1 {
+		t.Errorf("Expected 1 owner, got %d", len(owners))
+	}
+
+	// The previous owner can now leave
+	if err := group.RemoveMember(owner, admin); err != nil {
+		t.Errorf("Expected new owner to remove previous owner: %v", err)
+	}
+
+	// Replayed through sync operations
+	op := groups.NewGroupOperation(groups.OpTransferOwnership, "team", admin)
+	op.Member = member
+	if err := group.Apply(op); err != nil {
+		t.Errorf("Expected transfer to replay: %v", err)
+	}
+	if m, _ := group.GetMember(member); m.Role != groups.RoleOwner {
+		t.Errorf("Expected replayed transfer to make %s owner", member)
+	}
+}
+
+func TestGroupMultipleOwners(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, admin, member := "alice#example.com", "bob#example.com", "carol#example.com"
+	settings := groups.DefaultGroupSettings()
+	settings.AllowMultipleOwners = true
+	group, err := gm.CreateGroup("team", "Team", owner, settings)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(admin, owner, groups.RoleAdmin)
+	group.AddMember(member, owner, groups.RoleMember)
+
+	if err := group.ChangeRole(member, admin, groups.RoleOwner); err == nil {
+		t.Error("Expected admin promotion to owner to fail")
+	}
+	if err := group.ChangeRole(owner, owner, groups.RoleAdmin); err == nil {
+		t.Error("Expected demoting the last owner to fail")
+	}
+	if err := group.RemoveMember(owner, owner); err == nil {
+		t.Error("Expected removing the last owner to fail")
+	}
+
+	if err := group.ChangeRole(admin, owner, groups.RoleOwner); err != nil {
+		t.Fatalf("Failed to promote co-owner: %v", err)
+	}
+	if owners := group.GetOwners(); len(owners) != 2 {
+		t.Fatalf("Expected 2 owners, got %d", len(owners))
+	}
+
+	// With a co-owner present, an owner can step down or leave
+	if err := group.ChangeRole(admin, owner, groups.RoleAdmin); err != nil {
+		t.Errorf("Failed to demote co-owner: %v", err)
+	}
+	group.ChangeRole(admin, owner, groups.RoleOwner)
+	if err := group.RemoveMember(owner, owner); err != nil {
+		t.Errorf("Expected owner to leave when a co-owner remains: %v", err)
+	}
+	if err := group.RemoveMember(admin, admin); err == nil {
+		t.Error("Expected the remaining owner not to be removable")
+	}
+}
+
+func TestGroupMembershipProof(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, member := "alice#example.com", "bob#partner.org"
+	group, err := gm.CreateGroup("eng#example.com", "Engineering", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(member, owner, groups.RoleMember)
+	ownerKey, _ := keymgmt.GenerateKeyPair()
+
+	if _, err := groups.IssueMembershipProof(ownerKey, member, group, member, time.Hour); err == nil {
+		t.Error("Expected a non-owner on another domain not to issue proofs")
+	}
+	if _, err := groups.IssueMembershipProof(ownerKey, owner, group, "eve#example.com", time.Hour); err == nil {
+		t.Error("Expected no proof for a non-member")
+	}
+	if _, err := groups.IssueMembershipProof(ownerKey, "mallory#example.com", group, member, time.Hour); err == nil {
+		t.Error("Expected other accounts on the group's domain not to issue proofs")
+	}
+	if _, err := groups.IssueMembershipProof(ownerKey, "eng#Example.com", group, member, time.Hour); err != nil {
+		t.Errorf("Expected the group's server address to issue proofs: %v", err)
+	}
+
+	proof, err := groups.IssueMembershipProof(ownerKey, owner, group, member, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to issue proof: %v", err)
+	}
+	encoded, err := proof.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode proof: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().From(member).To("carol#other.net").GroupID("eng#example.com").Body("hi").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if _, err := groups.VerifyMessageMembership(msg, ownerKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected a message without a proof to fail")
+	}
+
+	msg.MembershipProof = encoded
+	verified, err := groups.VerifyMessageMembership(msg, ownerKey.PublicKeyBase64())
+	if err != nil {
+		t.Fatalf("Failed to verify membership: %v", err)
+	}
+	if verified.Role != groups.RoleMember || verified.IssuerAddress != owner {
+		t.Errorf("Unexpected proof: %+v", verified)
+	}
+
+	// The sender sets the send time, so it must be close to the receipt
+	if _, err := groups.VerifyMessageMembershipAt(msg, ownerKey.PublicKeyBase64(), msg.SentAt().Add(time.Minute)); err != nil {
+		t.Errorf("Expected a message received within the clock skew to verify: %v", err)
+	}
+	if _, err := groups.VerifyMessageMembershipAt(msg, ownerKey.PublicKeyBase64(), msg.SentAt().Add(2*time.Hour)); err == nil {
+		t.Error("Expected a message received long after its send time to fail")
+	}
+
+	otherKey, _ := keymgmt.GenerateKeyPair()
+	if _, err := groups.VerifyMessageMembership(msg, otherKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected verification against another key to fail")
+	}
+
+	// The proof only covers its member and group
+	msg.From = "mallory#partner.org"
+	if _, err := groups.VerifyMessageMembership(msg, ownerKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected a proof for another member to fail")
+	}
+
+	// Tampered proofs fail signature verification
+	proof.Role = groups.RoleOwner
+	if err := proof.Verify(ownerKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected tampered proof to fail")
+	}
+}
+
+func TestClientVerifyGroupMembership(t *testing.T) {
+	owner, member := "alice#example.com", "bob#partner.org"
+	ownerKey, _ := keymgmt.GenerateKeyPair()
+
+	config := client.DefaultConfig()
+	config.KeyPair = ownerKey
+	config.KeyResolver = client.KeyResolverFunc(func(ctx context.Context, address string) (string, error) {
+		if address == owner {
+			return ownerKey.PublicKeyBase64(), nil
+		}
+		return "", fmt.Errorf("unknown address %s", address)
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := emsgClient.CreateGroup("eng", "Engineering", owner, nil); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if err := emsgClient.AddGroupMember("eng", member, owner, groups.RoleMember); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+
+	proof, err := emsgClient.IssueMembershipProof("eng", owner, member, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to issue proof: %v", err)
+	}
+	encoded, _ := proof.Encode()
+	msg := &message.Message{From: member, To: []string{"carol#other.net"}, GroupID: "eng", Body: "hi", Timestamp: time.Now().Unix(), MembershipProof: encoded}
+
+	if _, err := emsgClient.VerifyGroupMembership(msg); err != nil {
+		t.Errorf("Expected membership to verify: %v", err)
+	}
+
+	// An owner no longer in the local group cannot vouch for members
+	if err := emsgClient.TransferGroupOwnership("eng", member, owner); err != nil {
+		t.Fatalf("Failed to transfer ownership: %v", err)
+	}
+	if _, err := emsgClient.VerifyGroupMembership(msg); !errors.Is(err, client.ErrMembershipUnproven) {
+		t.Errorf("Expected ErrMembershipUnproven, got %v", err)
+	}
+
+	if err := emsgClient.SetMembershipProof(proof); err != nil {
+		t.Fatalf("Failed to set proof: %v", err)
+	}
+	if emsgClient.GetMembershipProof("eng") != proof {
+		t.Error("Expected stored proof")
+	}
+	emsgClient.RemoveMembershipProof("eng")
+	if emsgClient.GetMembershipProof("eng") != nil {
+		t.Error("Expected proof to be removed")
+	}
+}
+
+func TestGroupBanAndMute(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, moderator, member := "alice#example.com", "bob#example.com", "carol#example.com"
+	group, err := gm.CreateGroup("team", "Team", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(moderator, owner, groups.RoleModerator)
+	group.AddMember(member, owner, groups.RoleMember)
+
+	// Moderators can mute but not ban
+	if err := group.BanMember(member, moderator, "spam"); err == nil {
+		t.Error("Expected moderator ban to fail")
+	}
+	if err := group.MuteMember(member, moderator, time.Hour); err != nil {
+		t.Fatalf("Failed to mute member: %v", err)
+	}
+	if err := group.MuteMember(moderator, member, time.Hour); err == nil {
+		t.Error("Expected member to be unable to mute")
+	}
+	if !group.IsMuted(member) || group.HasPermission(member, groups.PermissionSendMessage) {
+		t.Error("Expected muted member to lose send permission")
+	}
+	if !group.HasPermission(member, groups.PermissionViewHistory) {
+		t.Error("Expected muted member to keep other permissions")
+	}
+	var muted *groups.MutedError
+	if err := group.CheckCanSend(member); !errors.As(err, &muted) {
+		t.Errorf("Expected MutedError, got %v", err)
+	}
+	if err := group.UnmuteMember(member, moderator); err != nil {
+		t.Fatalf("Failed to unmute member: %v", err)
+	}
+	if group.CheckCanSend(member) != nil {
+		t.Error("Expected unmuted member to send")
+	}
+
+	// Bans survive serialization and block re-adding
+	if err := group.BanMember(owner, owner, ""); err == nil {
+		t.Error("Expected owner ban to fail")
+	}
+	if err := group.BanMember(member, owner, "spam"); err != nil {
+		t.Fatalf("Failed to ban member: %v", err)
+	}
+	if group.HasPermission(member, groups.PermissionViewHistory) {
+		t.Error("Expected banned member to lose all permissions")
+	}
+	if err := group.CheckCanSend(member); !errors.Is(err, groups.ErrMemberBanned) {
+		t.Errorf("Expected ErrMemberBanned, got %v", err)
+	}
+	group.RemoveMember(member, owner)
+	if err := group.AddMember(member, owner, groups.RoleMember); !errors.Is(err, groups.ErrMemberBanned) {
+		t.Errorf("Expected banned address not to be re-added, got %v", err)
+	}
+
+	restored, err := groups.FromJSON(mustGroupJSON(t, group))
+	if err != nil {
+		t.Fatalf("Failed to restore group: %v", err)
+	}
+	bans := restored.GetBans()
+	if len(bans) != 1 || bans[0].Address != member || bans[0].Reason != "spam" || bans[0].BannedBy != owner {
+		t.Errorf("Expected persisted ban, got %+v", bans)
+	}
+
+	if err := restored.UnbanMember(member, owner); err != nil {
+		t.Fatalf("Failed to unban: %v", err)
+	}
+	if err := restored.AddMember(member, owner, groups.RoleMember); err != nil {
+		t.Errorf("Expected unbanned address to be re-added: %v", err)
+	}
+}
+
+func TestClientGroupBanEnforcement(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	owner, member := "alice#example.com", "carol#example.com"
+	emsgClient.CreateGroup("team#example.com", "Team", owner, nil)
+	emsgClient.AddGroupMember("team#example.com", member, owner, groups.RoleMember)
+
+	if err := emsgClient.MuteGroupMember("team#example.com", member, owner, time.Minute); err != nil {
+		t.Fatalf("Failed to mute: %v", err)
+	}
+	var muted *groups.MutedError
+	if err := emsgClient.SendGroupMessage("team#example.com", member, "hi"); !errors.As(err, &muted) {
+		t.Errorf("Expected MutedError, got %v", err)
+	}
+
+	if err := emsgClient.BanGroupMember("team#example.com", member, owner, "abuse"); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+	if err := emsgClient.SendGroupMessage("team#example.com", member, "hi"); !errors.Is(err, groups.ErrMemberBanned) {
+		t.Errorf("Expected ErrMemberBanned, got %v", err)
+	}
+	if bans, _ := emsgClient.GetGroupBans("team#example.com"); len(bans) != 1 {
+		t.Errorf("Expected 1 ban, got %d", len(bans))
+	}
+}
+
+func TestGroupCustomRoles(t *testing.T) {
+	settings := groups.DefaultGroupSettings()
+	if err := settings.DefineRole(&groups.RoleDefinition{Name: groups.RoleAdmin, Rank: 10}); err == nil {
+		t.Error("Expected redefining a built-in role to fail")
+	}
+	if err := settings.DefineRole(&groups.RoleDefinition{Name: "superowner", Rank: groups.RankOwner}); err == nil {
+		t.Error("Expected a role ranked with the owner to fail")
+	}
+	triager := &groups.RoleDefinition{
+		Name:        "triager",
+		Rank:        groups.RankMember + 10, // Between member and moderator
+		Permissions: []groups.Permission{groups.PermissionSendMessage, groups.PermissionDeleteMessage, groups.PermissionChangeRole},
+	}
+	if err := settings.DefineRole(triager); err != nil {
+		t.Fatalf("Failed to define role: %v", err)
+	}
+
+	gm := groups.NewGroupManager()
+	owner, moderator, member, guest := "alice#example.com", "bob#example.com", "carol#example.com", "dave#example.com"
+	group, err := gm.CreateGroup("team", "Team", owner, settings)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(moderator, owner, groups.RoleModerator)
+	group.AddMember(guest, owner, groups.RoleGuest)
+
+	if err := group.AddMember(member, owner, "janitor"); err == nil {
+		t.Error("Expected an unknown role to be rejected")
+	}
+	if err := group.AddMember(member, owner, "triager"); err != nil {
+		t.Fatalf("Failed to add member with custom role: %v", err)
+	}
+	if !group.HasPermission(member, groups.PermissionDeleteMessage) || group.HasPermission(member, groups.PermissionAddMember) {
+		t.Error("Expected custom role permissions to apply")
+	}
+
+	// Ranks order custom roles among the built-in ones
+	if err := group.ChangeRole(guest, member, groups.RoleMember); err != nil {
+		t.Errorf("Expected triager to promote a guest to member: %v", err)
+	}
+	if err := group.ChangeRole(member, moderator, groups.RoleGuest); err == nil {
+		t.Error("Expected moderator without change_role to fail")
+	}
+	if err := group.ChangeRole(member, owner, "janitor"); err == nil {
+		t.Error("Expected change to an unknown role to fail")
+	}
+
+	if err := group.DefineRole(&groups.RoleDefinition{Name: "helper", Rank: groups.RankOwner - 1}, moderator); err == nil {
+		t.Error("Expected moderator without manage_group to fail to define roles")
+	}
+	if err := group.RemoveRole("triager", owner); err == nil {
+		t.Error("Expected removing a held role to fail")
+	}
+	group.ChangeRole(member, owner, groups.RoleMember)
+	if err := group.RemoveRole("triager", owner); err != nil {
+		t.Errorf("Failed to remove role: %v", err)
+	}
+	if len(group.GetRoles()) != 0 {
+		t.Error("Expected no custom roles")
+	}
+
+	// Custom roles survive serialization
+	if err := group.DefineRole(&groups.RoleDefinition{Name: "helper", Rank: groups.RankGuest + 5}, owner); err != nil {
+		t.Fatalf("Failed to define role: %v", err)
+	}
+	restored, err := groups.FromJSON(mustGroupJSON(t, group))
+	if err != nil {
+		t.Fatalf("Failed to restore group: %v", err)
+	}
+	if roles := restored.GetRoles(); len(roles) != 1 || roles[0].Name != "helper" {
+		t.Errorf("Expected persisted custom role, got %v", roles)
+	}
+}
+
+func TestGroupCanSendMessage(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, member, guest := "alice#example.com", "bob#example.com", "carol#example.com"
+	group, _ := gm.CreateGroup("team#example.com", "Team", owner, nil)
+	group.AddMember(member, owner, groups.RoleMember)
+	group.AddMember(guest, owner, groups.RoleGuest)
+
+	if !group.CanSendMessage(member) {
+		t.Error("Expected member to be allowed to send")
+	}
+	if group.CanSendMessage(guest) {
+		t.Error("Expected guest to be refused while guest messages are off")
+	}
+	if group.CanSendMessage("dave#example.com") {
+		t.Error("Expected non-member to be refused")
+	}
+
+	group.Settings.AllowGuestMessages = true
+	if !group.CanSendMessage(guest) {
+		t.Error("Expected guest to be allowed once guest messages are on")
+	}
+	if err := group.MuteMember(guest, owner, time.Minute); err != nil {
+		t.Fatalf("Failed to mute guest: %v", err)
+	}
+	if group.CanSendMessage(guest) {
+		t.Error("Expected muted guest to be refused")
+	}
+}
+
+func TestClientSendGroupMessageChecks(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	owner, guest := "alice#example.com", "carol#example.com"
+	emsgClient.CreateGroup("solo#example.com", "Solo", owner, nil)
+	if _, err := emsgClient.SendGroupMessageContext(context.Background(), "solo#example.com", owner, "hi"); err == nil {
+		t.Error("Expected error sending to a group without other members")
+	}
+
+	emsgClient.CreateGroup("team#example.com", "Team", owner, nil)
+	emsgClient.AddGroupMember("team#example.com", guest, owner, groups.RoleGuest)
+	result, err := emsgClient.SendGroupMessageContext(context.Background(), "team#example.com", guest, "hi")
+	if err == nil || result != nil {
+		t.Errorf("Expected guest to be refused before sending, got %v, %v", result, err)
+	}
+	if err := emsgClient.SendGroupMessage("team#example.com", "dave#example.com", "hi"); err == nil {
+		t.Error("Expected non-member to be refused")
+	}
+}
+
+func TestClientGroupHistory(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	emsgClient, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := emsgClient.GetGroupHistory("team#example.com", nil); err == nil {
+		t.Error("Expected error without a message store")
+	}
+
+	messageStore := store.NewMemoryMessageStore()
+	emsgClient.SetMessageStore(messageStore)
+	base := time.Now().Add(-time.Hour).Unix()
+	save := func(id, groupID, from string, offset int64) {
+		msg := &message.Message{MessageID: id, From: from, To: []string{"alice#example.com"}, GroupID: groupID, Body: id, Timestamp: base + offset}
+		if err := messageStore.Save(msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	save("m1", "team#example.com", "bob#example.com", 0)
+	save("m2", "team#example.com", "carol#example.com", 10)
+	save("m3", "other#example.com", "bob#example.com", 20)
+	save("m4", "team#example.com", "bob#example.com", 30)
+	save("d1", "", "bob#example.com", 40)
+
+	ids := func(messages []*message.Message) []string {
+		var result []string
+		for _, msg := range messages {
+			result = append(result, msg.MessageID)
+		}
+		return result
+	}
+
+	history, err := emsgClient.GetGroupHistory("team#example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if got := fmt.Sprint(ids(history)); got != "[m1 m2 m4]" {
+		t.Errorf("Expected [m1 m2 m4], got %s", got)
+	}
+
+	history, _ = emsgClient.GetGroupHistory("team#example.com", &client.GroupHistoryOptions{Sender: "bob#Example.com"})
+	if got := fmt.Sprint(ids(history)); got != "[m1 m4]" {
+		t.Errorf("Expected sender filter [m1 m4], got %s", got)
+	}
+
+	history, _ = emsgClient.GetGroupHistory("team#example.com", &client.GroupHistoryOptions{Since: time.Unix(base+5, 0), Limit: 1})
+	if got := fmt.Sprint(ids(history)); got != "[m4]" {
+		t.Errorf("Expected most recent message after since [m4], got %s", got)
+	}
+
+	history, _ = emsgClient.GetGroupHistory("team#example.com", &client.GroupHistoryOptions{Until: time.Unix(base+10, 0)})
+	if got := fmt.Sprint(ids(history)); got != "[m1]" {
+		t.Errorf("Expected messages before until [m1], got %s", got)
+	}
+
+	emsgClient.CreateGroup("team#example.com", "Team", "alice#example.com", nil)
+	if _, err := emsgClient.GetGroupHistory("team#example.com", &client.GroupHistoryOptions{Address: "dave#example.com"}); err == nil {
+		t.Error("Expected non-member to be refused the history")
+	}
+}
+
+func TestGroupCustomEmoji(t *testing.T) {
+	manager, err := attachments.NewAttachmentManager(&attachments.AttachmentConfig{MaxFileSize: 1024 * 1024, EnableInline: true, InlineLimit: 1024 * 1024, StorageDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	asset, _ := manager.CreateAttachmentFromData("party.png", []byte("fake png"), "image/png")
+	document, _ := manager.CreateAttachmentFromData("notes.txt", []byte("notes"), "text/plain")
+
+	gm := groups.NewGroupManager()
+	owner, member := "alice#example.com", "bob#example.com"
+	group, _ := gm.CreateGroup("team#example.com", "Team", owner, nil)
+	group.AddMember(member, owner, groups.RoleMember)
+
+	if _, err := group.AddCustomEmoji("party", asset, false, member); err == nil {
+		t.Error("Expected members without manage permission to be refused")
+	}
+	if _, err := group.AddCustomEmoji("Party!", asset, false, owner); err == nil {
+		t.Error("Expected invalid shortcode to be rejected")
+	}
+	if _, err := group.AddCustomEmoji("notes", document, false, owner); err == nil {
+		t.Error("Expected non-image asset to be rejected")
+	}
+
+	if _, err := group.AddCustomEmoji("party", asset, false, owner); err != nil {
+		t.Fatalf("Failed to add emoji: %v", err)
+	}
+	if _, err := group.AddCustomEmoji("wave", asset, true, owner); err != nil {
+		t.Fatalf("Failed to add sticker: %v", err)
+	}
+	if emoji := group.ListCustomEmoji(); len(emoji) != 2 || emoji[0].Shortcode != "party" || !emoji[1].Sticker {
+		t.Errorf("Unexpected registry: %+v", emoji)
+	}
+
+	// The registry lives in the group's metadata and survives a JSON round trip
+	data, err := group.ToJSON()
+	if err != nil {
+		t.Fatalf("Failed to serialize group: %v", err)
+	}
+	restored, err := groups.FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize group: %v", err)
+	}
+	emoji, ok := restored.GetCustomEmoji("wave")
+	if !ok || !emoji.Sticker || emoji.Asset.Checksum != asset.Checksum || emoji.AddedBy != owner {
+		t.Errorf("Expected sticker after round trip, got %+v", emoji)
+	}
+
+	if err := restored.RemoveCustomEmoji("party", owner); err != nil {
+		t.Fatalf("Failed to remove emoji: %v", err)
+	}
+	if _, ok := restored.GetCustomEmoji("party"); ok {
+		t.Error("Expected emoji to be removed")
+	}
+	if _, ok := group.GetCustomEmoji("party"); !ok {
+		t.Error("Removing from a copy should not change the original group")
+	}
+	if err := restored.RemoveCustomEmoji("party", owner); err == nil {
+		t.Error("Expected error removing an unknown emoji")
+	}
+}
+
+func TestClientGroupEmoji(t *testing.T) {
+	config := client.DefaultConfig()
+	config.AttachmentConfig.StorageDir = t.TempDir()
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	asset, err := emsgClient.CreateAttachmentFromData("party.png", []byte("fake png"), "image/png")
+	if err != nil {
+		t.Fatalf("Failed to create asset: %v", err)
+	}
+
+	owner := "alice#example.com"
+	emsgClient.CreateGroup("team#example.com", "Team", owner, nil)
+	emsgClient.AddGroupMember("team#example.com", "bob#example.com", owner, groups.RoleMember)
+	if _, err := emsgClient.AddGroupEmoji("team#example.com", owner, "party", asset, false); err != nil {
+		t.Fatalf("Failed to add emoji: %v", err)
+	}
+	if _, err := emsgClient.AddGroupEmoji("team#example.com", owner, "wave", asset, true); err != nil {
+		t.Fatalf("Failed to add sticker: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().From("bob#example.com").To(owner).GroupID("team#example.com").Sticker("wave").Build()
+	if err != nil {
+		t.Fatalf("Failed to build sticker: %v", err)
+	}
+	resolved, err := emsgClient.ResolveMessageEmoji(msg)
+	if err != nil || len(resolved) != 1 || string(resolved["wave"].Data) != "fake png" {
+		t.Errorf("Expected sticker to resolve, got %v, %v", resolved, err)
+	}
+
+	msg.Sticker = ""
+	msg.Body = "Ship it :party: at 10:30:00 :unknown:"
+	resolved, err = emsgClient.ResolveMessageEmoji(msg)
+	if err != nil || len(resolved) != 1 || resolved["party"] == nil {
+		t.Errorf("Expected only :party: to resolve, got %v, %v", resolved, err)
+	}
+
+	if _, err := emsgClient.SendGroupSticker(context.Background(), "team#example.com", owner, "party"); err == nil {
+		t.Error("Expected error sending an emoji that is not a sticker")
+	}
+	if err := emsgClient.RemoveGroupEmoji("team#example.com", owner, "party"); err != nil {
+		t.Fatalf("Failed to remove emoji: %v", err)
+	}
+	if _, err := emsgClient.ResolveGroupEmoji("team#example.com", "party"); err == nil {
+		t.Error("Expected removed emoji not to resolve")
+	}
+	if emoji, _ := emsgClient.ListGroupEmoji("team#example.com"); len(emoji) != 1 {
+		t.Errorf("Expected one remaining sticker, got %d", len(emoji))
+	}
+}
+
+func TestGroupAttachmentPolicy(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, member, guest := "alice#example.com", "bob#example.com", "carol#example.com"
+	group, err := gm.CreateGroup("files#example.com", "Files", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(member, owner, groups.RoleMember)
+	group.AddMember(guest, owner, groups.RoleGuest)
+
+	photo := &attachments.Attachment{Name: "photo.jpg", MimeType: "image/jpeg", Size: 2048}
+	archive := &attachments.Attachment{Name: "backup.zip", MimeType: "application/zip", Size: 512}
+
+	// Members may send attachments by default; guests need the permission granted
+	if err := group.CheckAttachments(member, []*attachments.Attachment{photo, archive}); err != nil {
+		t.Errorf("Expected member to send attachments, got %v", err)
+	}
+	var blocked *groups.AttachmentBlockedError
+	if err := group.CheckAttachments(guest, []*attachments.Attachment{photo}); !errors.As(err, &blocked) || blocked.Reason != groups.AttachmentBlockedPermission {
+		t.Errorf("Expected guest attachment to be blocked for permission, got %v", err)
+	}
+	if !group.HasPermission(member, groups.PermissionSendAttachment) || group.HasPermission(guest, groups.PermissionSendAttachment) {
+		t.Error("Expected send attachment permission for members only")
+	}
+
+	if err := group.SetAttachmentLimits(1024, []string{"image/*"}, member); err == nil {
+		t.Error("Expected member to be unable to change attachment limits")
+	}
+	if err := group.SetAttachmentLimits(1024, []string{"image"}, owner); err == nil {
+		t.Error("Expected an invalid attachment type to be rejected")
+	}
+	if err := group.SetAttachmentLimits(4096, []string{"image/*", "application/pdf"}, owner); err != nil {
+		t.Fatalf("Failed to set attachment limits: %v", err)
+	}
+	if err := group.CheckAttachments(member, []*attachments.Attachment{photo}); err != nil {
+		t.Errorf("Expected image within the limits to be allowed, got %v", err)
+	}
+	if err := group.CheckAttachments(member, []*attachments.Attachment{photo, archive}); !errors.As(err, &blocked) || blocked.Reason != groups.AttachmentBlockedType || blocked.Attachment != "backup.zip" {
+		t.Errorf("Expected archive to be blocked by type, got %v", err)
+	}
+	large := &attachments.Attachment{Name: "poster.png", MimeType: "image/png", Size: 8192}
+	if err := group.CheckAttachments(member, []*attachments.Attachment{large}); !errors.As(err, &blocked) || blocked.Reason != groups.AttachmentBlockedSize {
+		t.Errorf("Expected large attachment to be blocked by size, got %v", err)
+	}
+
+	// Muted members lose the attachment permission along with the send permission
+	group.MuteMember(member, owner, time.Hour)
+	if group.HasPermission(member, groups.PermissionSendAttachment) {
+		t.Error("Expected muted member to lose the send attachment permission")
+	}
+}
+
+func TestClientSendGroupAttachment(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	config := client.DefaultConfig()
+	config.KeyPair = keyPair
+	config.EnableGroupManagement = true
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	owner, member := "alice#example.com", "bob#example.com"
+	emsgClient.CreateGroup("files#example.com", "Files", owner, nil)
+	emsgClient.AddGroupMember("files#example.com", member, owner, groups.RoleMember)
+
+	if err := emsgClient.SetGroupAttachmentLimits("files#example.com", owner, 0, []string{"image/*"}); err != nil {
+		t.Fatalf("Failed to set attachment limits: %v", err)
+	}
+
+	// Policy is checked before anything is sent
+	archive := &attachments.Attachment{Name: "backup.zip", MimeType: "application/zip", Size: 512}
+	var blocked *groups.AttachmentBlockedError
+	if err := emsgClient.SendGroupAttachment("files#example.com", member, "files", archive); !errors.As(err, &blocked) {
+		t.Errorf("Expected the archive to be blocked, got %v", err)
+	}
+
+	// Messages addressed to the group directly are held to the same policy
+	msg, err := message.NewMessageBuilder().From(member).To(owner).GroupID("files#example.com").Body("files").Attachment(archive).Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := emsgClient.SendMessage(msg); !errors.As(err, &blocked) || blocked.Reason != groups.AttachmentBlockedType {
+		t.Errorf("Expected a direct send of the archive to be blocked, got %v", err)
+	}
+
+	if err := emsgClient.SetGroupAttachmentLimits("unknown#example.com", owner, 0, nil); err == nil {
+		t.Error("Expected error for an unknown group")
+	}
+}
+
+func TestGroupAdminActions(t *testing.T) {
+	gm := groups.NewGroupManager()
+	owner, admin := "alice#example.com", "bob#example.com"
+	group, err := gm.CreateGroup("eng#example.com", "Engineering", owner, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group.AddMember(admin, owner, groups.RoleAdmin)
+	group.AddMember("carol#example.com", owner, groups.RoleMember)
+	group.AddMember("dave#example.com", owner, groups.RoleMember)
+	adminKey, _ := keymgmt.GenerateKeyPair()
+
+	later := time.Now().Add(time.Hour).Unix()
+	actions := []*groups.AdminAction{
+		{GroupOperation: groups.GroupOperation{Type: groups.OpRemoveMember, Member: "carol#example.com"}},
+		{GroupOperation: groups.GroupOperation{Type: groups.OpRemoveMember, Member: "dave#example.com"}, NotBefore: later},
+	}
+	if _, err := groups.SignAdminActions(adminKey, admin, group.ID, []*groups.AdminAction{{GroupOperation: groups.GroupOperation{Type: groups.OpTransferOwnership, Member: admin}}}, time.Hour); err == nil {
+		t.Error("Expected ownership transfers not to be pre-signable")
+	}
+	batch, err := groups.SignAdminActions(adminKey, admin, group.ID, actions, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign admin actions: %v", err)
+	}
+
+	encoded, err := batch.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode admin actions: %v", err)
+	}
+	batch, err = groups.ParseSignedAdminActions(encoded)
+	if err != nil {
+		t.Fatalf("Failed to parse admin actions: %v", err)
+	}
+
+	otherKey, _ := keymgmt.GenerateKeyPair()
+	if _, err := group.ExecuteAdminActions(batch, otherKey.PublicKeyBase64(), "server#example.com", time.Now()); err == nil {
+		t.Error("Expected a batch checked against another key to fail")
+	}
+
+	result, err := group.ExecuteAdminActions(batch, adminKey.PublicKeyBase64(), "server#example.com", time.Now())
+	if err != nil {
+		t.Fatalf("Failed to execute admin actions: %v", err)
+	}
+	if len(result.Executed) != 1 || result.Pending != 1 || result.Executed[0].Error != "" {
+		t.Fatalf("Unexpected execution: %+v", result)
+	}
+	if _, err := group.GetMember("carol#example.com"); err == nil {
+		t.Error("Expected carol to be removed")
+	}
+
+	// Actions run once
+	result, _ = group.ExecuteAdminActions(batch, adminKey.PublicKeyBase64(), "server#example.com", time.Now())
+	if len(result.Executed) != 0 || result.Pending != 1 {
+		t.Errorf("Expected nothing to run again: %+v", result)
+	}
+
+	// Permissions are checked when the action runs, not when it was signed
+	if err := group.ChangeRole(admin, owner, groups.RoleMember); err != nil {
+		t.Fatalf("Failed to demote admin: %v", err)
+	}
+	result, err = group.ExecuteAdminActions(batch, adminKey.PublicKeyBase64(), "server#example.com", time.Unix(later, 0))
+	if err != nil {
+		t.Fatalf("Failed to execute admin actions: %v", err)
+	}
+	if len(result.Executed) != 1 || result.Executed[0].Error == "" {
+		t.Errorf("Expected the demoted admin's action to be refused: %+v", result)
+	}
+	if _, err := group.GetMember("dave#example.com"); err != nil {
+		t.Error("Expected dave to stay a member")
+	}
+
+	log := group.GetAuditLog()
+	if len(log) != 2 || log[0].Operation.Actor != admin || log[0].Executor != "server#example.com" || log[1].Error == "" {
+		t.Errorf("Unexpected audit log: %+v", log)
+	}
+
+	if _, err := group.ExecuteAdminActions(batch, adminKey.PublicKeyBase64(), "server#example.com", time.Now().Add(3*time.Hour)); err == nil {
+		t.Error("Expected an expired batch to fail")
+	}
+
+	// Tampered batches fail signature verification
+	batch.Actions[0].Member = owner
+	if err := batch.Verify(adminKey.PublicKeyBase64()); err == nil {
+		t.Error("Expected tampered admin actions to fail")
+	}
+}
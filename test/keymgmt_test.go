@@ -2,8 +2,10 @@ package test
 
 import (
 	"crypto/ed25519"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
@@ -189,3 +191,194 @@ func TestKeyPairConsistency(t *testing.T) {
 		t.Error("Public key is not consistent with private key")
 	}
 }
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := keymgmt.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, got %d", len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != keymgmt.RecoveryCodeLength+1 || code[5] != '-' {
+			t.Errorf("Unexpected code format: %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+
+	if _, err := keymgmt.GenerateRecoveryCodes(0); err == nil {
+		t.Error("Expected error for zero codes")
+	}
+
+	// Input is normalized before hashing
+	normalized, err := keymgmt.NormalizeRecoveryCode(" abcde-fghok ")
+	if err != nil {
+		t.Fatalf("Failed to normalize code: %v", err)
+	}
+	if normalized != "ABCDEFGH0K" {
+		t.Errorf("Expected ABCDEFGH0K, got %s", normalized)
+	}
+	if _, err := keymgmt.NormalizeRecoveryCode("ABCDE-FGHU!"); err == nil {
+		t.Error("Expected error for invalid characters")
+	}
+	if _, err := keymgmt.NormalizeRecoveryCode("ABC"); err == nil {
+		t.Error("Expected error for short code")
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	otherKeyPair, _ := keymgmt.GenerateKeyPair()
+	code := codes[0]
+
+	hash, err := keymgmt.HashRecoveryCode(code, "alice#example.com", keyPair.PublicKeyBase64())
+	if err != nil {
+		t.Fatalf("Failed to hash code: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$scrypt$") || strings.Contains(hash, ",") {
+		t.Errorf("Expected an scrypt hash without commas, got %q", hash)
+	}
+	if ok, err := keymgmt.VerifyRecoveryCode(strings.ToLower(code), "alice#Example.COM", keyPair.PublicKeyBase64(), hash); !ok || err != nil {
+		t.Errorf("Expected verification to ignore code case and domain case: %v", err)
+	}
+
+	// Each hash has its own salt
+	again, _ := keymgmt.HashRecoveryCode(code, "alice#example.com", keyPair.PublicKeyBase64())
+	if again == hash {
+		t.Error("Expected hashes of the same code to be salted apart")
+	}
+
+	// Hashes are bound to the code, address and identity key
+	for _, attempt := range []struct{ code, address, publicKey string }{
+		{codes[1], "alice#example.com", keyPair.PublicKeyBase64()},
+		{code, "bob#example.com", keyPair.PublicKeyBase64()},
+		{code, "alice#example.com", otherKeyPair.PublicKeyBase64()},
+	} {
+		if ok, _ := keymgmt.VerifyRecoveryCode(attempt.code, attempt.address, attempt.publicKey, hash); ok {
+			t.Errorf("Expected %+v not to verify", attempt)
+		}
+	}
+
+	// Malformed hashes and excessive costs are rejected without hashing
+	for _, invalid := range []string{"", "deadbeef", strings.Replace(hash, "$15$", "$40$", 1), strings.Replace(hash, "$scrypt$", "$md5$", 1)} {
+		if _, err := keymgmt.VerifyRecoveryCode(code, "alice#example.com", keyPair.PublicKeyBase64(), invalid); err == nil {
+			t.Errorf("Expected hash %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	first, _ := keymgmt.GenerateKeyPair()
+	second, _ := keymgmt.GenerateKeyPair()
+	third, _ := keymgmt.GenerateKeyPair()
+
+	if id := first.KeyID(); id == "" || id != keymgmt.KeyID(first.PublicKey) || id == second.KeyID() {
+		t.Errorf("Expected stable, distinct key IDs, got %q and %q", id, second.KeyID())
+	}
+
+	ring := keymgmt.NewKeyRing(first)
+	payload := []byte("hello")
+	firstID, firstSig := ring.Sign(payload)
+	if firstID != first.KeyID() {
+		t.Errorf("Expected signature by current key %s, got %s", first.KeyID(), firstID)
+	}
+
+	old, err := ring.Rotate(second)
+	if err != nil || old != first {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if ring.Current() != second || ring.CurrentKeyID() != second.KeyID() {
+		t.Error("Expected second key to be current")
+	}
+	if _, err := ring.Rotate(second); err == nil {
+		t.Error("Expected rotating to the current key to fail")
+	}
+
+	// Signatures by the previous key still verify, by ID or by trying every key
+	if !ring.Verify(firstID, payload, firstSig) || !ring.Verify("", payload, firstSig) {
+		t.Error("Expected previous key's signature to verify")
+	}
+	if ring.Verify(second.KeyID(), payload, firstSig) {
+		t.Error("Expected signature to fail against the wrong key ID")
+	}
+	if _, sig := ring.Sign(payload); !second.Verify(payload, sig) {
+		t.Error("Expected new signatures to use the current key")
+	}
+
+	// Only a bounded number of previous keys are kept
+	ring.SetMaxPrevious(1)
+	ring.Rotate(third)
+	if previous := ring.Previous(); len(previous) != 1 || previous[0] != second {
+		t.Errorf("Expected only the second key to be retained, got %d keys", len(previous))
+	}
+	if ring.Verify(firstID, payload, firstSig) {
+		t.Error("Expected dropped key's signature to fail")
+	}
+
+	if err := ring.Retire(third.KeyID()); err == nil {
+		t.Error("Expected retiring the current key to fail")
+	}
+	if err := ring.Retire(second.KeyID()); err != nil {
+		t.Errorf("Retire failed: %v", err)
+	}
+	if _, ok := ring.Lookup(second.KeyID()); ok {
+		t.Error("Expected retired key to be gone")
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	store := keymgmt.NewMemoryKeyStore()
+
+	if _, err := store.Load("alice#example.com"); !errors.Is(err, keymgmt.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for missing key, got %v", err)
+	}
+
+	generated, err := keymgmt.LoadOrGenerateKeyPair(store, "alice#example.com")
+	if err != nil {
+		t.Fatalf("Failed to load or generate key pair: %v", err)
+	}
+	loaded, err := keymgmt.LoadOrGenerateKeyPair(store, "alice#example.com")
+	if err != nil {
+		t.Fatalf("Failed to load key pair: %v", err)
+	}
+	if !generated.PrivateKey.Equal(loaded.PrivateKey) {
+		t.Error("Expected the stored key pair on the second call")
+	}
+
+	replacement, _ := keymgmt.GenerateKeyPair()
+	if err := store.Save("alice#example.com", replacement); err != nil {
+		t.Fatalf("Failed to replace key pair: %v", err)
+	}
+	if loaded, _ := store.Load("alice#example.com"); !replacement.PrivateKey.Equal(loaded.PrivateKey) {
+		t.Error("Expected Save to replace the stored key pair")
+	}
+
+	if err := store.Delete("alice#example.com"); err != nil {
+		t.Fatalf("Failed to delete key pair: %v", err)
+	}
+	if err := store.Delete("alice#example.com"); !errors.Is(err, keymgmt.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound deleting twice, got %v", err)
+	}
+
+	for _, name := range []string{"", "bad name", "quote\"", "../key", strings.Repeat("a", 129)} {
+		if err := store.Save(name, replacement); err == nil {
+			t.Errorf("Expected invalid key name %q to be rejected", name)
+		}
+	}
+
+	// Only check availability: a round trip would write to the developer's real keychain
+	systemStore, err := keymgmt.NewSystemKeyStore("")
+	if err != nil && !errors.Is(err, keymgmt.ErrKeyStoreUnavailable) {
+		t.Errorf("Expected ErrKeyStoreUnavailable when no system store exists, got %v", err)
+	}
+	if err == nil && systemStore == nil {
+		t.Error("Expected a system key store when no error is returned")
+	}
+	if _, err := keymgmt.NewSystemKeyStore("bad service"); err == nil {
+		t.Error("Expected invalid service name to be rejected")
+	}
+}
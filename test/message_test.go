@@ -1,10 +1,19 @@
 package test
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 func TestMessageBuilder(t *testing.T) {
@@ -414,3 +423,541 @@ func TestMessageClone(t *testing.T) {
 		t.Error("Modifying clone should not affect original")
 	}
 }
+
+func TestMessageClientInfo(t *testing.T) {
+	keyPair, err := keymgmt.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("Hello").
+		ClientInfo(&message.ClientInfo{
+			Name:     "emsg-client-sdk",
+			Version:  "1.0",
+			Features: []string{message.FeatureEncryption},
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := msg.Sign(keyPair); err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		t.Fatalf("Failed to serialize message: %v", err)
+	}
+	received, err := message.FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+
+	if !received.HasClientInfo() || received.ClientInfo.Version != "1.0" {
+		t.Fatalf("Expected client info to survive serialization, got %+v", received.ClientInfo)
+	}
+	if supported, known := received.SenderSupports(message.FeatureEncryption); !supported || !known {
+		t.Error("Expected sender to support encryption")
+	}
+	if supported, known := received.SenderSupports(message.FeatureGroups); supported || !known {
+		t.Error("Expected sender to be known not to support groups")
+	}
+
+	// Client info is covered by the signature
+	received.ClientInfo.Features = append(received.ClientInfo.Features, message.FeatureGroups)
+	if err := received.Verify(keyPair.PublicKeyBase64()); err == nil {
+		t.Error("Expected tampered client info to fail verification")
+	}
+
+	// Messages without client info report the sender's support as unknown
+	msg.ClientInfo = nil
+	if _, known := msg.SenderSupports(message.FeatureEncryption); known {
+		t.Error("Expected unknown support without client info")
+	}
+}
+
+func TestMessageSignWithKeyRing(t *testing.T) {
+	oldKey, _ := keymgmt.GenerateKeyPair()
+	newKey, _ := keymgmt.GenerateKeyPair()
+	ring := keymgmt.NewKeyRing(oldKey)
+
+	msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Body("hello").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := msg.SignWithKeyRing(ring); err != nil {
+		t.Fatalf("SignWithKeyRing failed: %v", err)
+	}
+	if msg.KeyID != oldKey.KeyID() {
+		t.Errorf("Expected key ID %s, got %s", oldKey.KeyID(), msg.KeyID)
+	}
+
+	ring.Rotate(newKey)
+	if err := msg.VerifyWithKeyRing(ring); err != nil {
+		t.Errorf("Expected message signed by previous key to verify: %v", err)
+	}
+	if err := msg.Verify(oldKey.PublicKeyBase64()); err != nil {
+		t.Errorf("Expected plain verification with the signing key to work: %v", err)
+	}
+
+	// The key ID is covered by the signature
+	msg.KeyID = newKey.KeyID()
+	if err := msg.VerifyWithKeyRing(ring); err == nil {
+		t.Error("Expected tampered key ID to fail verification")
+	}
+}
+
+func TestMessageReplyTo(t *testing.T) {
+	request, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bot#example.com").
+		Body("status").
+		CorrelationID("req-1").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if !request.IsRequest() || request.IsReply() {
+		t.Error("Expected message with a correlation ID to be a request")
+	}
+
+	reply, err := message.NewMessageBuilder().
+		From("bot#example.com").
+		ReplyTo(request).
+		Body("ok").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build reply: %v", err)
+	}
+	if len(reply.To) != 1 || reply.To[0] != request.From {
+		t.Errorf("Expected reply addressed to %s, got %v", request.From, reply.To)
+	}
+	if reply.InReplyTo != request.MessageID {
+		t.Errorf("Expected in_reply_to %s, got %s", request.MessageID, reply.InReplyTo)
+	}
+	if reply.CorrelationID != "req-1" {
+		t.Errorf("Expected correlation ID req-1, got %s", reply.CorrelationID)
+	}
+	if !reply.IsReply() || reply.IsRequest() {
+		t.Error("Expected message answering another to be a reply")
+	}
+
+	// Correlation fields are covered by the signature
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	if err := reply.Sign(keyPair); err != nil {
+		t.Fatalf("Failed to sign reply: %v", err)
+	}
+	reply.CorrelationID = "req-2"
+	if err := reply.Verify(keyPair.PublicKeyBase64()); err == nil {
+		t.Error("Expected tampered correlation ID to fail verification")
+	}
+}
+
+func TestMessageBuilderAttachmentErrors(t *testing.T) {
+	// Without an attachment manager the attachment cannot be created
+	_, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("see attached").
+		AttachData("note.txt", []byte("hello"), "text/plain").
+		Build()
+	var validationErr *message.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "attachments" {
+		t.Fatalf("Expected attachments validation error without a manager, got %v", err)
+	}
+
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = t.TempDir()
+	manager, err := attachments.NewAttachmentManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	_, err = message.NewMessageBuilder().
+		WithAttachmentManager(manager).
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("see attached").
+		AttachData("note.txt", []byte("hello"), "text/plain").
+		AttachFile(missing).
+		Build()
+	if !errors.As(err, &validationErr) || validationErr.Field != "attachments" {
+		t.Fatalf("Expected attachments validation error for a missing file, got %v", err)
+	}
+	if !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected error to name the missing file, got %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		WithAttachmentManager(manager).
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("see attached").
+		AttachData("note.txt", []byte("hello"), "text/plain").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if msg.GetAttachmentCount() != 1 {
+		t.Errorf("Expected 1 attachment, got %d", msg.GetAttachmentCount())
+	}
+
+	// By default every failure is collected and reported together by Build
+	alsoMissing := filepath.Join(t.TempDir(), "also-missing.txt")
+	_, err = message.NewMessageBuilder().
+		WithAttachmentManager(manager).
+		From("alice#example.com").
+		To("bob#test.org").
+		AttachFile(missing).
+		AttachFile(alsoMissing).
+		Build()
+	if err == nil || !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), alsoMissing) {
+		t.Errorf("Expected Build to report both missing files, got %v", err)
+	}
+
+	// MustAttach fails at the first attachment that cannot be attached
+	mustAttach := func(attach func(mb *message.MessageBuilder)) (failure any) {
+		defer func() { failure = recover() }()
+		attach(message.NewMessageBuilder().WithAttachmentManager(manager).MustAttach())
+		return nil
+	}
+	reachedSecond := false
+	failure := mustAttach(func(mb *message.MessageBuilder) {
+		mb.AttachData("note.txt", []byte("hello"), "text/plain").AttachFile(missing)
+		reachedSecond = true
+	})
+	if failureErr, ok := failure.(error); !ok || !strings.Contains(failureErr.Error(), missing) || reachedSecond {
+		t.Errorf("Expected MustAttach to panic at the missing file, got %v", failure)
+	}
+	if failure := mustAttach(func(mb *message.MessageBuilder) {
+		mb.WithAttachmentManager(nil).AttachData("note.txt", []byte("hello"), "text/plain")
+	}); failure == nil {
+		t.Error("Expected MustAttach to panic without an attachment manager")
+	}
+	if failure := mustAttach(func(mb *message.MessageBuilder) {
+		mb.AttachData("note.txt", []byte("hello"), "text/plain")
+	}); failure != nil {
+		t.Errorf("Expected MustAttach to accept a valid attachment, got %v", failure)
+	}
+}
+
+func TestMessageOrdering(t *testing.T) {
+	msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").Body("hi").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if msg.TimestampMs/1000 != msg.Timestamp {
+		t.Errorf("Expected millisecond timestamp %d to agree with %d", msg.TimestampMs, msg.Timestamp)
+	}
+
+	msg.TimestampMs = (msg.Timestamp + 5) * 1000
+	var validationErr *message.ValidationError
+	if err := msg.Validate(); !errors.As(err, &validationErr) || validationErr.Field != "timestamp_ms" {
+		t.Errorf("Expected timestamp_ms validation error, got %v", err)
+	}
+
+	// Within one second, milliseconds and then sequence numbers decide the order
+	legacy := &message.Message{MessageID: "a", From: "carol#example.com", Timestamp: 1700000000}
+	early := &message.Message{MessageID: "b", From: "alice#example.com", Timestamp: 1700000000, TimestampMs: 1700000000100}
+	late := &message.Message{MessageID: "c", From: "alice#example.com", Timestamp: 1700000000, TimestampMs: 1700000000900}
+	// A reply from a device whose clock runs behind still sorts after the message it saw
+	reply := &message.Message{MessageID: "d", From: "bob#test.org", Timestamp: 1700000000, TimestampMs: 1700000000500, Sequence: 1700000000901}
+
+	messages := []*message.Message{reply, late, early, legacy}
+	message.SortMessages(messages)
+	var order []string
+	for _, m := range messages {
+		order = append(order, m.MessageID)
+	}
+	if got := strings.Join(order, ""); got != "abcd" {
+		t.Errorf("Expected order abcd, got %s", got)
+	}
+	if message.CompareOrder(early, early) != 0 {
+		t.Error("Expected a message to compare equal to itself")
+	}
+}
+
+func TestSequenceClock(t *testing.T) {
+	clock := message.NewSequenceClock()
+
+	first := clock.Next()
+	second := clock.Next()
+	if second <= first {
+		t.Errorf("Expected increasing sequence numbers, got %d then %d", first, second)
+	}
+	if first < time.Now().Add(-time.Minute).UnixMilli() {
+		t.Errorf("Expected sequence numbers to track the current time, got %d", first)
+	}
+
+	// A message from a device whose clock runs ahead pushes the clock forward
+	ahead := &message.Message{Timestamp: time.Now().Unix() + 3600, Sequence: time.Now().Add(time.Hour).UnixMilli()}
+	clock.Observe(ahead)
+	if next := clock.Next(); next <= ahead.Sequence {
+		t.Errorf("Expected sequence after observed %d, got %d", ahead.Sequence, next)
+	}
+	if clock.Last() <= ahead.Sequence {
+		t.Errorf("Expected last sequence past %d, got %d", ahead.Sequence, clock.Last())
+	}
+}
+
+func TestMessageBuilderAddresses(t *testing.T) {
+	alice := utils.MustParseAddress("alice#example.com")
+	bob := utils.MustParseAddress("bob#example.com")
+	carol := utils.MustParseAddress("carol#test.org")
+
+	msg, err := message.NewMessageBuilder().
+		FromAddress(alice).
+		ToAddresses(bob).
+		CCAddresses(carol).
+		Body("hi").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if msg.From != "alice#example.com" || msg.To[0] != "bob#example.com" || msg.CC[0] != "carol#test.org" {
+		t.Errorf("Unexpected addresses %s %v %v", msg.From, msg.To, msg.CC)
+	}
+
+	sender, err := msg.SenderAddress()
+	if err != nil || sender != alice {
+		t.Errorf("Expected sender %s, got %s (%v)", alice, sender, err)
+	}
+	recipients, err := msg.RecipientAddresses()
+	if err != nil || len(recipients) != 2 || recipients[0] != bob || recipients[1] != carol {
+		t.Errorf("Unexpected recipients %v (%v)", recipients, err)
+	}
+
+	msg.To = append(msg.To, "bad")
+	if _, err := msg.RecipientAddresses(); err == nil {
+		t.Error("Expected error for invalid recipient")
+	}
+}
+
+func TestMessageShortcodes(t *testing.T) {
+	msg, err := message.NewMessageBuilder().From("alice#example.com").To("bob#example.com").Sticker("wave").Build()
+	if err != nil {
+		t.Fatalf("Failed to build sticker: %v", err)
+	}
+	if !msg.IsSticker() || msg.Body != ":wave:" {
+		t.Errorf("Expected sticker with fallback body, got %q", msg.Body)
+	}
+
+	msg.Body = ":party: :wave: :party: 12:30:45 :x: :Caps:"
+	if got := strings.Join(msg.Shortcodes(), " "); got != "wave party" {
+		t.Errorf("Expected wave party, got %s", got)
+	}
+	if got := strings.Join(message.ExtractShortcodes(":a_b::c_d::a_b:"), " "); got != "a_b c_d a_b" {
+		t.Errorf("Expected adjacent shortcodes with repeats, got %s", got)
+	}
+}
+
+func TestMessageBuilderDrafts(t *testing.T) {
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = t.TempDir()
+	manager, err := attachments.NewAttachmentManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+	notes := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(notes, []byte("first version"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	keyPair, _ := encryption.GenerateEncryptionKeyPair()
+	encManager := encryption.NewEncryptionManager(keyPair, encryption.NewMemoryKeyStore())
+
+	drafts := store.NewMemoryDraftStore()
+	err = message.NewMessageBuilder().
+		WithAttachmentManager(manager).
+		WithEncryption(encManager).
+		From("alice#example.com").
+		To("bob#test.org").
+		Subject("Plans").
+		Body("half-written").
+		InReplyTo("msg-1").
+		AttachFile(notes).
+		AttachData("inline.txt", []byte("inline"), "text/plain").
+		SaveDraft(drafts, "compose-1")
+	if err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	// Files are referenced by path and attached again when loaded
+	if err := os.WriteFile(notes, []byte("second version"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	restored := message.NewMessageBuilder().WithAttachmentManager(manager)
+	if err := restored.LoadDraft(drafts, "compose-1"); err != nil {
+		t.Fatalf("Failed to load draft: %v", err)
+	}
+	draft := restored.Draft()
+	if draft.Subject != "Plans" || draft.Body != "half-written" || draft.InReplyTo != "msg-1" || len(draft.To) != 1 {
+		t.Errorf("Unexpected restored draft: %+v", draft)
+	}
+	if len(draft.Attachments) != 2 || draft.Attachments[0].Path != notes || draft.Attachments[1].Attachment.Name != "inline.txt" {
+		t.Fatalf("Unexpected restored attachments: %+v", draft.Attachments)
+	}
+
+	// The encryption intent is kept, so the draft never silently builds in plaintext
+	if !draft.Encrypt {
+		t.Error("Expected the draft to keep the encryption intent")
+	}
+	var validationErr *message.ValidationError
+	if _, err := restored.Build(); !errors.As(err, &validationErr) || validationErr.Field != "encrypted" {
+		t.Fatalf("Expected a validation error without an encryption manager, got %v", err)
+	}
+	msg, err := restored.WithEncryption(encManager).Build()
+	if err != nil {
+		t.Fatalf("Failed to build restored draft: %v", err)
+	}
+	if msg.Attachments[0].Size != int64(len("second version")) {
+		t.Errorf("Expected the file to be read again, got %d bytes", msg.Attachments[0].Size)
+	}
+
+	if err := message.NewMessageBuilder().LoadDraft(drafts, "missing"); !errors.Is(err, store.ErrDraftNotFound) {
+		t.Errorf("Expected ErrDraftNotFound, got %v", err)
+	}
+}
+
+func TestMessageExpiry(t *testing.T) {
+	clock := utils.NewFakeClock(time.Unix(1700000000, 500*int64(time.Millisecond)))
+	builder := message.NewMessageBuilder().
+		WithClock(clock).
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("burn after reading").
+		ExpiresIn(90 * time.Second)
+
+	// The TTL survives a draft round trip
+	drafts := store.NewMemoryDraftStore()
+	if err := builder.SaveDraft(drafts, "ttl"); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+	restored := message.NewMessageBuilder().WithClock(clock)
+	if err := restored.LoadDraft(drafts, "ttl"); err != nil || restored.Draft().ExpiresIn != 90*time.Second {
+		t.Fatalf("Expected the TTL to be restored, got %v: %v", restored.Draft().ExpiresIn, err)
+	}
+
+	// The expiry is rounded up so the message never expires early
+	msg, err := restored.Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if msg.ExpiresAt != 1700000091 {
+		t.Errorf("Expected expiry 1700000091, got %d", msg.ExpiresAt)
+	}
+	if ttl, ok := msg.TTL(clock.Now()); !ok || ttl != 90500*time.Millisecond {
+		t.Errorf("Expected 90.5s left, got %v, %v", ttl, ok)
+	}
+	if msg.IsExpired(clock.Now()) || msg.CheckExpiry(clock.Now()) != nil {
+		t.Error("Expected a fresh message not to be expired")
+	}
+
+	later := msg.ExpiryTime()
+	if !msg.IsExpired(later) {
+		t.Error("Expected the message to expire at its expiry time")
+	}
+	err = msg.CheckExpiry(later)
+	if !errors.Is(err, message.ErrMessageExpired) || !errors.Is(err, message.ErrValidation) {
+		t.Errorf("Expected an expired validation error, got %v", err)
+	}
+
+	if _, ok := (&message.Message{}).TTL(later); ok {
+		t.Error("Expected messages without an expiry to have no TTL")
+	}
+	msg.ExpiresAt = msg.Timestamp
+	if err := msg.Validate(); err == nil {
+		t.Error("Expected an expiry before the timestamp to be invalid")
+	}
+	if _, err := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").Body("x").ExpiresIn(-time.Second).Build(); err == nil {
+		t.Error("Expected a negative TTL to be rejected")
+	}
+}
+
+func TestMessageContentTypes(t *testing.T) {
+	msg, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		HTML(`<p onclick="steal()">Hi <b>Bob</b>, see <a href="https://example.com/plan">the plan</a><script>alert(1)</script></p><a href="javascript:alert(1)">x</a><i>unclosed`).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	expected := `<p>Hi <b>Bob</b>, see <a href="https://example.com/plan" rel="noopener noreferrer nofollow">the plan</a></p><a>x</a><i>unclosed</i>`
+	if msg.Body != expected {
+		t.Errorf("Expected sanitized body\n%s\ngot\n%s", expected, msg.Body)
+	}
+	if msg.ContentType != message.ContentTypeHTML || !msg.IsRichText() {
+		t.Errorf("Expected an HTML message, got %q", msg.ContentType)
+	}
+	if msg.PlainBody != "Hi Bob, see the plan (https://example.com/plan)\nxunclosed" {
+		t.Errorf("Expected a derived plain body, got %q", msg.PlainBody)
+	}
+
+	// Clients that do not render HTML get the plain body
+	if body, contentType := msg.Render(message.ContentTypeMarkdown); body != msg.PlainBody || contentType != message.ContentTypePlain {
+		t.Errorf("Expected the plain fallback, got %q (%s)", body, contentType)
+	}
+	if body, contentType := msg.Render(message.ContentTypeHTML); body != msg.Body || contentType != message.ContentTypeHTML {
+		t.Errorf("Expected the HTML body, got %q (%s)", body, contentType)
+	}
+	// Received HTML is sanitized again on rendering
+	if body, _ := message.RenderContent("text/html; charset=utf-8", `<img src="https://tracker.example/x.png">ok`, "", message.ContentTypeHTML); body != "ok" {
+		t.Errorf("Expected received HTML to be sanitized, got %q", body)
+	}
+
+	md, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Markdown("# Release\n\n* **bold** and _em_ in snake_case_name\n* `code` and [docs](https://example.com/docs)").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if want := "Release\n\n- bold and em in snake_case_name\n- code and docs (https://example.com/docs)"; md.PlainText() != want {
+		t.Errorf("Expected markdown plain text\n%s\ngot\n%s", want, md.PlainText())
+	}
+
+	// An explicit fallback is kept and survives a draft round trip
+	builder := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").Markdown("*hi*").PlainBody("hello")
+	drafts := store.NewMemoryDraftStore()
+	if err := builder.SaveDraft(drafts, "rich"); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+	restored := message.NewMessageBuilder()
+	if err := restored.LoadDraft(drafts, "rich"); err != nil {
+		t.Fatalf("Failed to load draft: %v", err)
+	}
+	if msg, err := restored.Build(); err != nil || msg.PlainBody != "hello" || msg.ContentType != message.ContentTypeMarkdown {
+		t.Errorf("Expected the content type and fallback to be restored, got %+v: %v", msg, err)
+	}
+
+	// Plain messages carry neither field, and encrypted ones leave out the fallback
+	plain, _ := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").ContentType(message.ContentTypePlain).Body("hi").Build()
+	if plain.ContentType != "" || plain.PlainBody != "" || plain.PlainText() != "hi" {
+		t.Errorf("Expected a plain message, got %+v", plain)
+	}
+	aliceKeys, _ := encryption.GenerateEncryptionKeyPair()
+	bobKeys, _ := encryption.GenerateEncryptionKeyPair()
+	keyStore := encryption.NewMemoryKeyStore()
+	keyStore.StorePublicKey("bob#test.org", bobKeys.PublicKey)
+	encrypted, err := message.NewMessageBuilder().
+		From("alice#example.com").
+		To("bob#test.org").
+		Markdown("**secret**").
+		WithEncryption(encryption.NewEncryptionManager(aliceKeys, keyStore)).
+		Build()
+	if err != nil || !encrypted.Encrypted || encrypted.PlainBody != "" {
+		t.Errorf("Expected an encrypted message without a plain body, got %q: %v", encrypted.PlainBody, err)
+	}
+
+	if _, err := message.NewMessageBuilder().From("alice#example.com").To("bob#test.org").ContentType("application/pdf").Body("x").Build(); !errors.Is(err, message.ErrValidation) {
+		t.Errorf("Expected an unsupported content type to be rejected, got %v", err)
+	}
+	if feature, ok := message.ContentTypeFeature(message.ContentTypeHTML); !ok || feature != message.FeatureHTML {
+		t.Errorf("Expected the HTML feature, got %q", feature)
+	}
+}
@@ -1,12 +1,20 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/emsg-protocol/emsg-client-sdk/client"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
 	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 func TestNotificationManager(t *testing.T) {
@@ -331,3 +339,568 @@ func TestNotifyDeliveryReceipt(t *testing.T) {
 		t.Error("Expected delivered to be true")
 	}
 }
+
+type stubMessageClient struct {
+	messages []*message.Message
+}
+
+func (c *stubMessageClient) GetMessages(address string) ([]*message.Message, error) {
+	return c.messages, nil
+}
+
+func TestMessagePollerWithFakeClock(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	received := make(chan *notifications.Notification, 1)
+	nm.RegisterHandler(notifications.EventMessageReceived, func(notification *notifications.Notification) error {
+		received <- notification
+		return nil
+	})
+
+	start := time.Unix(1700000000, 0)
+	clock := utils.NewFakeClock(start)
+	client := &stubMessageClient{
+		messages: []*message.Message{
+			{From: "alice#example.com", To: []string{"bob#example.com"}, Body: "old", Timestamp: start.Unix() - 10},
+			{From: "alice#example.com", To: []string{"bob#example.com"}, Body: "new", Timestamp: start.Unix() + 10},
+		},
+	}
+
+	poller := notifications.NewMessagePoller(client, nm, time.Minute)
+	poller.SetClock(clock)
+
+	if err := poller.Start("bob#example.com"); err != nil {
+		t.Fatalf("Failed to start poller: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+
+	select {
+	case notification := <-received:
+		if notification.Message.Body != "new" {
+			t.Errorf("Expected only the new message, got %q", notification.Message.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Poller did not notify after clock advance")
+	}
+
+	poller.Stop()
+	if poller.IsRunning() {
+		t.Error("Poller should not be running after Stop")
+	}
+}
+
+func TestMessagePollerStopWaitsForLoop(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	poller := notifications.NewMessagePoller(&stubMessageClient{}, nm, time.Minute)
+	poller.SetClock(utils.NewFakeClock(time.Now()))
+
+	select {
+	case <-poller.Done():
+	default:
+		t.Error("Done should be closed before Start")
+	}
+
+	if err := poller.Start("bob#example.com"); err != nil {
+		t.Fatalf("Failed to start poller: %v", err)
+	}
+
+	done := poller.Done()
+	select {
+	case <-done:
+		t.Fatal("Done should not be closed while running")
+	default:
+	}
+
+	poller.Stop()
+
+	select {
+	case <-done:
+	default:
+		t.Error("Done should be closed once Stop returns")
+	}
+
+	// Restarting after Stop must work
+	if err := poller.Start("bob#example.com"); err != nil {
+		t.Fatalf("Failed to restart poller: %v", err)
+	}
+	poller.Stop()
+}
+
+func TestBatchHandlerMaxSize(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	batches := make(chan []*notifications.Notification, 10)
+	nm.RegisterBatchHandler(notifications.EventUserJoined, &notifications.BatchConfig{
+		MaxSize: 3,
+		MaxWait: time.Hour,
+	}, func(batch []*notifications.Notification) {
+		batches <- batch
+	})
+
+	for i := 0; i < 7; i++ {
+		nm.NotifyUserJoined(fmt.Sprintf("user%d#example.com", i), "group1")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case batch := <-batches:
+			if len(batch) != 3 {
+				t.Errorf("Expected batch of 3, got %d", len(batch))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected full batch to be delivered")
+		}
+	}
+
+	// The remaining notification is delivered on flush
+	nm.Flush()
+	select {
+	case batch := <-batches:
+		if len(batch) != 1 {
+			t.Errorf("Expected remaining batch of 1, got %d", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected flush to deliver remaining notification")
+	}
+}
+
+func TestBatchHandlerMaxWait(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	batches := make(chan []*notifications.Notification, 10)
+	nm.RegisterBatchHandler(notifications.EventTyping, &notifications.BatchConfig{
+		MaxSize: 100,
+		MaxWait: 20 * time.Millisecond,
+	}, func(batch []*notifications.Notification) {
+		batches <- batch
+	})
+
+	nm.NotifyTyping("alice#example.com", "group1", true)
+	nm.NotifyTyping("bob#example.com", "group1", true)
+
+	select {
+	case batch := <-batches:
+		if len(batch) != 2 {
+			t.Errorf("Expected batch of 2, got %d", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected batch after MaxWait")
+	}
+}
+
+func TestNotificationDigest(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	var mutex sync.Mutex
+	var digests []*notifications.Notification
+	var joins []*notifications.Notification
+
+	nm.RegisterHandler(notifications.EventDigest, func(notification *notifications.Notification) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		digests = append(digests, notification)
+		return nil
+	})
+	nm.RegisterHandler(notifications.EventUserJoined, func(notification *notifications.Notification) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		joins = append(joins, notification)
+		return nil
+	})
+
+	nm.EnableDigest(notifications.EventUserJoined, &notifications.DigestConfig{Window: time.Hour})
+
+	for i := 0; i < 50; i++ {
+		nm.NotifyUserJoined(fmt.Sprintf("user%d#example.com", i), "group1")
+	}
+	nm.NotifyUserJoined("solo#example.com", "group2")
+
+	mutex.Lock()
+	if len(digests) != 0 || len(joins) != 0 {
+		t.Error("Notifications should be held until the digest window closes")
+	}
+	mutex.Unlock()
+
+	nm.Flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(digests) != 1 {
+		t.Fatalf("Expected 1 digest, got %d", len(digests))
+	}
+	if digests[0].Metadata["count"] != 50 {
+		t.Errorf("Expected digest count 50, got %v", digests[0].Metadata["count"])
+	}
+	if digests[0].Metadata["digest_event"] != notifications.EventUserJoined {
+		t.Errorf("Expected digest of user_joined, got %v", digests[0].Metadata["digest_event"])
+	}
+
+	// A single event in its group is delivered unchanged
+	if len(joins) != 1 || joins[0].Metadata["user"] != "solo#example.com" {
+		t.Errorf("Expected lone join to be delivered as-is, got %d joins", len(joins))
+	}
+}
+
+// recordingLogger captures log entries for assertions
+type recordingLogger struct {
+	entries []string
+	mutex   sync.Mutex
+}
+
+func (l *recordingLogger) record(level, msg string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, level+" "+msg)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("INFO", msg) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record("WARN", msg) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record("ERROR", msg) }
+
+func (l *recordingLogger) has(entry string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, e := range l.entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNotificationLogger(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	// Nothing is logged, and nothing breaks, without a logger
+	nm.RegisterHandler(notifications.EventTyping, func(n *notifications.Notification) error {
+		return fmt.Errorf("handler failed")
+	})
+	if err := nm.NotifyTyping("alice#example.com", "group-1", true); err == nil {
+		t.Error("Expected handler error")
+	}
+
+	logger := &recordingLogger{}
+	nm.SetLogger(logger)
+	nm.NotifyTyping("alice#example.com", "group-1", true)
+	if !logger.has("WARN synchronous notification handler failed") {
+		t.Errorf("Expected handler failure warning, got %v", logger.entries)
+	}
+
+	done := make(chan struct{})
+	nm.RegisterAsyncHandler(notifications.EventUserJoined, func(n *notifications.Notification) {
+		defer close(done)
+		panic("boom")
+	})
+	nm.NotifyUserJoined("bob#example.com", "group-1")
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for !logger.has("ERROR async notification handler panicked") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected async panic to be logged as an error, got %v", logger.entries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A *slog.Logger can be used directly
+	var buf bytes.Buffer
+	var slogLogger utils.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	nm.SetLogger(slogLogger)
+	nm.NotifyTyping("alice#example.com", "group-1", false)
+	if out := buf.String(); !strings.Contains(out, "level=WARN") || !strings.Contains(out, "event=typing") {
+		t.Errorf("Expected structured slog output, got %q", out)
+	}
+}
+
+type traceKey struct{}
+
+func TestContextHandlers(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+
+	var syncTrace string
+	nm.RegisterContextHandler(notifications.EventTyping, func(ctx context.Context, n *notifications.Notification) error {
+		syncTrace, _ = ctx.Value(traceKey{}).(string)
+		return nil
+	})
+
+	asyncDone := make(chan error, 1)
+	nm.RegisterContextAsyncHandler(notifications.EventTyping, func(ctx context.Context, n *notifications.Notification) {
+		if trace, _ := ctx.Value(traceKey{}).(string); trace != "trace-1" {
+			asyncDone <- fmt.Errorf("expected trace-1 in async handler, got %q", trace)
+			return
+		}
+		asyncDone <- ctx.Err()
+	})
+
+	legacyCalls := 0
+	nm.RegisterHandler(notifications.EventTyping, func(n *notifications.Notification) error {
+		legacyCalls++
+		return nil
+	})
+
+	callCtx, cancel := context.WithCancel(ctx)
+	notification := &notifications.Notification{Event: notifications.EventTyping, Timestamp: time.Now().Unix()}
+	if err := nm.NotifyContext(callCtx, notification); err != nil {
+		t.Fatalf("NotifyContext failed: %v", err)
+	}
+	// Async handlers keep the values but must not be cancelled with the caller
+	cancel()
+
+	if syncTrace != "trace-1" {
+		t.Errorf("Expected trace-1 in sync handler, got %q", syncTrace)
+	}
+	if legacyCalls != 1 {
+		t.Errorf("Expected legacy handler to be called once, got %d", legacyCalls)
+	}
+	select {
+	case err := <-asyncDone:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Async handler was not called")
+	}
+
+	// A cancelled context stops synchronous dispatch
+	if err := nm.NotifyContext(callCtx, notification); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if legacyCalls != 1 {
+		t.Errorf("Expected no handler calls after cancellation, got %d", legacyCalls)
+	}
+
+	// The deprecated and context-aware send hooks cannot both be set
+	config := client.DefaultConfig()
+	config.BeforeSend = func(*message.Message) error { return nil }
+	config.BeforeSendContext = func(context.Context, *message.Message) error { return nil }
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "BeforeSend") {
+		t.Errorf("Expected BeforeSend conflict, got %v", err)
+	}
+	config.BeforeSend = nil
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected BeforeSendContext alone to be valid, got %v", err)
+	}
+}
+
+func TestNotificationPanicHandler(t *testing.T) {
+	nm := notifications.NewNotificationManager(2)
+	defer nm.Shutdown()
+
+	reports := make(chan *utils.PanicReport, 2)
+	nm.SetPanicHandler(func(report *utils.PanicReport) {
+		reports <- report
+		// A failing hook must not take the handler goroutine down with it
+		panic("hook bug")
+	})
+
+	nm.RegisterAsyncHandler(notifications.EventUserJoined, func(n *notifications.Notification) {
+		panic(fmt.Errorf("handler bug"))
+	})
+	nm.NotifyUserJoined("bob#example.com", "group-1")
+
+	select {
+	case report := <-reports:
+		if report.Subsystem != utils.PanicSubsystemNotificationHandler {
+			t.Errorf("Expected subsystem %s, got %s", utils.PanicSubsystemNotificationHandler, report.Subsystem)
+		}
+		if err, ok := report.Value.(error); !ok || err.Error() != "handler bug" {
+			t.Errorf("Expected the panic value, got %v", report.Value)
+		}
+		if len(report.Stack) == 0 || report.Time.IsZero() {
+			t.Error("Expected stack trace and time in report")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected panic to be reported")
+	}
+
+	// Without a hook, reporting is a no-op
+	utils.ReportPanic(nil, utils.PanicSubsystemNotificationHandler, "ignored")
+}
+
+func TestKeywordSubscriptions(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	var received, silenced []*notifications.Notification
+	nm.RegisterHandler(notifications.EventMessageReceived, func(n *notifications.Notification) error {
+		received = append(received, n)
+		return nil
+	})
+	nm.RegisterHandler(notifications.EventMessageSilenced, func(n *notifications.Notification) error {
+		silenced = append(silenced, n)
+		return nil
+	})
+
+	if err := nm.SubscribeKeywords("eng", "Deploy", "#outage"); err != nil {
+		t.Fatalf("Failed to subscribe keywords: %v", err)
+	}
+	for _, invalid := range []string{"", "#", "two words", strings.Repeat("x", notifications.MaxKeywordLength+1)} {
+		if err := nm.SubscribeKeywords("eng", invalid); err == nil {
+			t.Errorf("Expected error for keyword %q", invalid)
+		}
+	}
+	if keywords := nm.Keywords("eng"); len(keywords) != 2 || keywords[0] != "#outage" || keywords[1] != "deploy" {
+		t.Errorf("Unexpected keywords: %v", keywords)
+	}
+
+	groupMessage := func(body string) *message.Message {
+		return &message.Message{From: "alice#example.com", To: []string{"bob#example.com"}, GroupID: "eng", Body: body}
+	}
+	nm.NotifyMessageReceived(groupMessage("Starting the #deploy now"))                    // Plain keyword matches the hashtag
+	nm.NotifyMessageReceived(groupMessage("Lunch anyone?"))                               // No match
+	nm.NotifyMessageReceived(groupMessage("Is this an outage?"))                          // Hashtag keyword needs the hashtag
+	nm.NotifyMessageReceived(groupMessage("#OUTAGE in eu-west"))                          // Matching is case-insensitive
+	nm.NotifyMessageReceived(&message.Message{From: "carol#example.com", Body: "deploy"}) // Direct message, not filtered
+
+	if len(received) != 3 || len(silenced) != 2 {
+		t.Fatalf("Expected 3 received and 2 silenced, got %d and %d", len(received), len(silenced))
+	}
+	if matched, _ := received[0].Metadata["matched_keywords"].([]string); len(matched) != 1 || matched[0] != "deploy" {
+		t.Errorf("Expected matched keyword deploy, got %v", received[0].Metadata["matched_keywords"])
+	}
+
+	payload, err := notifications.NewPushFormatter(&notifications.PushConfig{Policy: notifications.PreviewFull}).Format(silenced[0])
+	if err != nil {
+		t.Fatalf("Failed to format silenced notification: %v", err)
+	}
+	if !payload.Silent || payload.Body != "" {
+		t.Errorf("Expected silent push payload for silenced message, got %+v", payload)
+	}
+
+	// Without subscriptions the group notifies normally again
+	nm.UnsubscribeKeywords("eng", "deploy", "#outage")
+	nm.NotifyMessageReceived(groupMessage("Lunch anyone?"))
+	if len(received) != 4 {
+		t.Errorf("Expected unfiltered delivery after unsubscribing, got %d received", len(received))
+	}
+}
+
+func TestNotificationMaxQueued(t *testing.T) {
+	nm := notifications.NewNotificationManager(1)
+	defer nm.Shutdown()
+
+	var logs bytes.Buffer
+	nm.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	nm.SetMaxQueued(2)
+
+	// One call holds the only worker and one waits for it; the third is dropped
+	release := make(chan struct{})
+	var calls sync.WaitGroup
+	calls.Add(2)
+	nm.RegisterAsyncHandler(notifications.EventTyping, func(n *notifications.Notification) {
+		<-release
+		calls.Done()
+	})
+	for i := 0; i < 3; i++ {
+		nm.NotifyTyping("alice#example.com", "eng", true)
+	}
+	close(release)
+	calls.Wait()
+	if !strings.Contains(logs.String(), "notification queue full") {
+		t.Errorf("Expected dropped call to be logged, got %q", logs.String())
+	}
+
+	// Batches are delivered once they reach the limit, whatever their MaxSize
+	batches := make(chan int, 1)
+	nm.RegisterBatchHandler(notifications.EventUserJoined, &notifications.BatchConfig{MaxSize: 100, MaxWait: time.Hour}, func(batch []*notifications.Notification) {
+		batches <- len(batch)
+	})
+	nm.NotifyUserJoined("alice#example.com", "eng")
+	nm.NotifyUserJoined("bob#example.com", "eng")
+	select {
+	case size := <-batches:
+		if size != 2 {
+			t.Errorf("Expected batch of 2, got %d", size)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected batch to be delivered at the queue limit")
+	}
+
+	// Digests are delivered early too
+	var digests []*notifications.Notification
+	nm.RegisterHandler(notifications.EventDigest, func(n *notifications.Notification) error {
+		digests = append(digests, n)
+		return nil
+	})
+	nm.EnableDigest(notifications.EventUserLeft, &notifications.DigestConfig{Window: time.Hour})
+	nm.NotifyUserLeft("alice#example.com", "eng")
+	nm.NotifyUserLeft("bob#example.com", "eng")
+	if len(digests) != 1 || digests[0].Metadata["count"] != 2 {
+		t.Errorf("Expected one digest of 2 at the queue limit, got %v", digests)
+	}
+}
+
+type panickingMessageClient struct{}
+
+func (panickingMessageClient) GetMessages(address string) ([]*message.Message, error) {
+	panic("corrupt state")
+}
+
+func TestMessagePollerFailureEndsRun(t *testing.T) {
+	nm := notifications.NewNotificationManager(5)
+	defer nm.Shutdown()
+
+	reports := make(chan *utils.PanicReport, 1)
+	nm.SetPanicHandler(func(report *utils.PanicReport) {
+		reports <- report
+	})
+
+	clock := utils.NewFakeClock(time.Now())
+	poller := notifications.NewMessagePoller(panickingMessageClient{}, nm, time.Minute)
+	poller.SetClock(clock)
+
+	if err := poller.Restart(); err == nil {
+		t.Error("Restart should fail before the poller was started")
+	}
+	if err := poller.Start("bob#example.com"); err != nil {
+		t.Fatalf("Failed to start poller: %v", err)
+	}
+
+	done := poller.Done()
+	clock.Advance(time.Minute)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Poll loop did not exit after panicking")
+	}
+	if poller.IsRunning() {
+		t.Error("Poller should not be running after its loop failed")
+	}
+	if poller.Err() == nil {
+		t.Error("Expected the failure to be reported by Err")
+	}
+
+	select {
+	case report := <-reports:
+		if report.Subsystem != utils.PanicSubsystemMessagePoller {
+			t.Errorf("Unexpected panic subsystem %s", report.Subsystem)
+		}
+	default:
+		t.Error("Expected the panic to reach the panic handler")
+	}
+
+	if err := poller.Restart(); err != nil {
+		t.Fatalf("Failed to restart poller: %v", err)
+	}
+	if poller.Err() != nil {
+		t.Error("Err should be cleared by a new run")
+	}
+	poller.Stop()
+	if poller.Err() != nil {
+		t.Error("Stop should not be reported as a failure")
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/client"
+	"github.com/emsg-protocol/emsg-client-sdk/groups"
+	"github.com/emsg-protocol/emsg-client-sdk/pagination"
+)
+
+func TestIterator(t *testing.T) {
+	pages := map[string]*pagination.Page[int]{
+		"":  {Items: []int{1, 2}, NextCursor: "a"},
+		"a": {Items: []int{}, NextCursor: "b"},
+		"b": {Items: []int{3}},
+	}
+	fetches := 0
+	it := pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[int], error) {
+		fetches++
+		return pages[cursor], nil
+	}, nil)
+
+	items, err := it.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Iteration failed: %v", err)
+	}
+	if fmt.Sprint(items) != "[1 2 3]" {
+		t.Errorf("Expected [1 2 3], got %v", items)
+	}
+	if fetches != 3 {
+		t.Errorf("Expected 3 page fetches, got %d", fetches)
+	}
+	if it.Next(context.Background()) {
+		t.Error("Expected exhausted iterator to stay exhausted")
+	}
+}
+
+func TestIteratorRateLimit(t *testing.T) {
+	attempts := 0
+	fetch := func(ctx context.Context, cursor string) (*pagination.Page[string], error) {
+		attempts++
+		if attempts < 3 {
+			return nil, &client.HTTPError{StatusCode: 429, RetryAfter: time.Millisecond}
+		}
+		return &pagination.Page[string]{Items: []string{"ok"}}, nil
+	}
+
+	items, err := pagination.New(fetch, nil).Collect(context.Background())
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Expected rate-limited fetch to succeed after retries, got %v (%v)", items, err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Retries are bounded
+	attempts = 0
+	options := &pagination.Options{MaxRateLimitRetries: 1, RateLimitDelay: time.Millisecond}
+	it := pagination.New(fetch, options)
+	if it.Next(context.Background()) {
+		t.Error("Expected iteration to stop after exhausting retries")
+	}
+	var httpErr *client.HTTPError
+	if !errors.As(it.Err(), &httpErr) || httpErr.StatusCode != 429 {
+		t.Errorf("Expected the rate limit error, got %v", it.Err())
+	}
+
+	// Other errors are not retried
+	attempts = 0
+	failing := pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[string], error) {
+		attempts++
+		return nil, errors.New("boom")
+	}, nil)
+	if _, err := failing.Collect(context.Background()); err == nil || attempts != 1 {
+		t.Errorf("Expected one failed attempt, got %d (%v)", attempts, err)
+	}
+
+	// Waiting for a rate limit honours cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := pagination.New(func(ctx context.Context, cursor string) (*pagination.Page[string], error) {
+		return nil, &client.HTTPError{StatusCode: 429, RetryAfter: time.Hour}
+	}, nil)
+	if _, err := slow.Collect(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestClientIterators(t *testing.T) {
+	emsgClient, err := client.New(client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	owner := "owner#example.com"
+	if _, err := emsgClient.CreateGroup("team#example.com", "Team", owner, nil); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		member := fmt.Sprintf("user%d#example.com", i)
+		if err := emsgClient.AddGroupMember("team#example.com", member, owner, groups.RoleMember); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+
+	members, err := emsgClient.GroupMembersIterator("team#example.com", &groups.MemberFilter{Role: groups.RoleMember}, 3).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Member iteration failed: %v", err)
+	}
+	if len(members) != 7 || members[0].Address != "user0#example.com" || members[6].Address != "user6#example.com" {
+		t.Errorf("Expected 7 members in address order, got %d", len(members))
+	}
+
+	if _, err := emsgClient.CreateGroup("alpha#example.com", "Alpha", owner, nil); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	groupList, err := emsgClient.GroupsIterator(1).Collect(context.Background())
+	if err != nil || len(groupList) != 2 || groupList[0].ID != "alpha#example.com" {
+		t.Errorf("Expected 2 groups ordered by ID, got %d (%v)", len(groupList), err)
+	}
+
+	// Iterators over disabled subsystems report the error
+	it := emsgClient.DeliveryReceiptsIterator(10)
+	if it.Next(context.Background()) || it.Err() == nil {
+		t.Error("Expected error when delivery tracking is disabled")
+	}
+}
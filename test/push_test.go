@@ -0,0 +1,108 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emsg-protocol/emsg-client-sdk/encryption"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+)
+
+func newPushTestNotification() *notifications.Notification {
+	return &notifications.Notification{
+		Event: notifications.EventMessageReceived,
+		Message: &message.Message{
+			From:      "alice#example.com",
+			To:        []string{"bob#example.com"},
+			Subject:   "Dinner",
+			Body:      strings.Repeat("secret ", 40),
+			MessageID: "push-msg-1",
+		},
+	}
+}
+
+func TestPushPreviewPolicies(t *testing.T) {
+	notification := newPushTestNotification()
+
+	full, err := notifications.NewPushFormatter(&notifications.PushConfig{Policy: notifications.PreviewFull, PreviewLength: 10}).Format(notification)
+	if err != nil {
+		t.Fatalf("Failed to format full preview: %v", err)
+	}
+	if full.Sender != "alice#example.com" || full.Title != "Dinner" {
+		t.Errorf("Unexpected full preview: %+v", full)
+	}
+	if len([]rune(full.Body)) != 11 {
+		t.Errorf("Expected truncated body of 10 runes plus ellipsis, got %q", full.Body)
+	}
+
+	senderOnly, err := notifications.NewPushFormatter(nil).Format(notification)
+	if err != nil {
+		t.Fatalf("Failed to format sender-only preview: %v", err)
+	}
+	if senderOnly.Sender != "alice#example.com" || senderOnly.Body != "" || senderOnly.Title != "" {
+		t.Errorf("Sender-only preview leaked content: %+v", senderOnly)
+	}
+
+	silent, err := notifications.NewPushFormatter(&notifications.PushConfig{Policy: notifications.PreviewSilent}).Format(notification)
+	if err != nil {
+		t.Fatalf("Failed to format silent preview: %v", err)
+	}
+	if !silent.Silent || silent.Sender != "" || silent.Body != "" {
+		t.Errorf("Silent preview leaked content: %+v", silent)
+	}
+
+	// Defaults are filled in on the formatter's copy, not the caller's config
+	config := &notifications.PushConfig{Policy: notifications.PreviewFull}
+	defaulted, err := notifications.NewPushFormatter(config).Format(notification)
+	if err != nil {
+		t.Fatalf("Failed to format preview: %v", err)
+	}
+	if config.PreviewLength != 0 {
+		t.Errorf("Expected the caller's config to be left alone, got PreviewLength %d", config.PreviewLength)
+	}
+	if len([]rune(defaulted.Body)) != notifications.DefaultPreviewLength+1 {
+		t.Errorf("Expected a body truncated to the default length, got %d runes", len([]rune(defaulted.Body)))
+	}
+}
+
+func TestPushEncryptor(t *testing.T) {
+	senderKeys, err := encryption.GenerateEncryptionKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate sender keys: %v", err)
+	}
+	deviceKeys, err := encryption.GenerateEncryptionKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate device keys: %v", err)
+	}
+
+	encryptor, err := notifications.NewPushEncryptor(senderKeys, deviceKeys.PublicKeyBase64())
+	if err != nil {
+		t.Fatalf("Failed to create push encryptor: %v", err)
+	}
+
+	formatter := notifications.NewPushFormatter(&notifications.PushConfig{
+		Policy:    notifications.PreviewFull,
+		Encryptor: encryptor,
+	})
+
+	sealed, err := formatter.Format(newPushTestNotification())
+	if err != nil {
+		t.Fatalf("Failed to format sealed preview: %v", err)
+	}
+	if !sealed.Encrypted || sealed.Sender != "" || sealed.Body != "" {
+		t.Errorf("Sealed payload exposes plaintext: %+v", sealed)
+	}
+
+	opened, err := notifications.OpenPushPayload(sealed, deviceKeys)
+	if err != nil {
+		t.Fatalf("Failed to open sealed payload: %v", err)
+	}
+	if opened.Sender != "alice#example.com" || opened.Title != "Dinner" {
+		t.Errorf("Unexpected opened preview: %+v", opened)
+	}
+
+	if _, err := notifications.NewPushEncryptor(senderKeys, "not-base64!"); err == nil {
+		t.Error("Expected error for invalid device key")
+	}
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/schema"
+)
+
+func TestSchemaDefinitions(t *testing.T) {
+	// The published files must match the Go types
+	proto, err := os.ReadFile("../schema/emsg.proto")
+	if err != nil {
+		t.Fatalf("Failed to read emsg.proto: %v", err)
+	}
+	if string(proto) != schema.Proto() {
+		t.Error("emsg.proto is out of date; run go generate ./schema")
+	}
+
+	jsonSchema, err := os.ReadFile("../schema/emsg.schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read emsg.schema.json: %v", err)
+	}
+	generated, err := schema.MarshalJSONSchema()
+	if err != nil {
+		t.Fatalf("MarshalJSONSchema failed: %v", err)
+	}
+	if string(jsonSchema) != string(generated) {
+		t.Error("emsg.schema.json is out of date; run go generate ./schema")
+	}
+
+	for _, want := range []string{"message Message {", "repeated string to = 2;", "KeyBundle key_bundle = 13;", "message DeliveryReceipt {", "google.protobuf.Struct metadata"} {
+		if !strings.Contains(schema.Proto(), want) {
+			t.Errorf("Expected proto definitions to contain %q", want)
+		}
+	}
+
+	doc := schema.JSONSchema()
+	for _, name := range []string{"Message", "Attachment", "SystemMessage", "DeliveryReceipt", "AttachmentChunk", "MediaInfo"} {
+		if doc.Defs[name] == nil {
+			t.Errorf("Expected JSON Schema definition for %s", name)
+		}
+	}
+
+	msgSchema := doc.Defs["Message"]
+	if !slices.Contains(msgSchema.Required, "from") || slices.Contains(msgSchema.Required, "subject") {
+		t.Errorf("Expected required fields to follow omitempty, got %v", msgSchema.Required)
+	}
+	if ref := msgSchema.Properties["attachments"].Items.Ref; ref != "#/$defs/Attachment" {
+		t.Errorf("Expected attachments to reference Attachment, got %q", ref)
+	}
+}
+
+func TestSchemaConversion(t *testing.T) {
+	// Output of a protobuf JSON encoder in another language
+	protoJSON := `{
+		"from": "alice#example.com",
+		"to": ["bob#example.com"],
+		"body": "hello",
+		"groupId": "group-1",
+		"timestamp": "1700000000",
+		"messageId": "msg-1",
+		"keyBundle": {"address": "alice#example.com", "createdAt": "1700000000"},
+		"attachments": [{"id": "att-1", "mimeType": "text/plain", "size": "3", "data": "aGk_", "metadata": {"source": "upload"}}],
+		"futureField": true
+	}`
+
+	var msg message.Message
+	if err := schema.Unmarshal([]byte(protoJSON), &msg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if msg.GroupID != "group-1" || msg.MessageID != "msg-1" || msg.Timestamp != 1700000000 {
+		t.Errorf("Unexpected message fields: %+v", msg)
+	}
+	if msg.KeyBundle == nil || msg.KeyBundle.CreatedAt != 1700000000 {
+		t.Errorf("Expected nested key bundle, got %+v", msg.KeyBundle)
+	}
+	if len(msg.Attachments) != 1 || string(msg.Attachments[0].Data) != "hi?" || msg.Attachments[0].MimeType != "text/plain" {
+		t.Errorf("Expected URL-safe base64 attachment data to decode, got %+v", msg.Attachments)
+	}
+
+	// SDK JSON round-trips unchanged
+	data, err := schema.Marshal(&msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var roundTrip message.Message
+	if err := schema.Unmarshal(data, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal of SDK JSON failed: %v", err)
+	}
+	if roundTrip.MessageID != msg.MessageID || roundTrip.Attachments[0].Metadata["source"] != "upload" {
+		t.Errorf("Round trip lost fields: %+v", roundTrip)
+	}
+
+	var receipt delivery.DeliveryReceipt
+	if err := schema.Unmarshal([]byte(`{"messageId": "msg-1", "status": "sent", "attemptCount": 2}`), &receipt); err != nil {
+		t.Fatalf("Unmarshal receipt failed: %v", err)
+	}
+	if receipt.Status != delivery.StatusSent || receipt.AttemptCount != 2 {
+		t.Errorf("Unexpected receipt: %+v", receipt)
+	}
+
+	if err := schema.Unmarshal([]byte(`{"to": "bob#example.com"}`), &msg); err == nil || !strings.Contains(err.Error(), "$.to") {
+		t.Errorf("Expected type error naming the field, got %v", err)
+	}
+	if err := schema.Unmarshal([]byte(`{}`), msg); err == nil {
+		t.Error("Expected error for non-pointer target")
+	}
+}
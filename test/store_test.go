@@ -0,0 +1,998 @@
+package test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/emsg-protocol/emsg-client-sdk/attachments"
+	"github.com/emsg-protocol/emsg-client-sdk/client"
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/dns"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/store"
+)
+
+func newSignedTestMessage(t *testing.T, keyPair *keymgmt.KeyPair, id string) *message.Message {
+	t.Helper()
+
+	config := attachments.DefaultAttachmentConfig()
+	config.StorageDir = ""
+	attManager, err := attachments.NewAttachmentManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create attachment manager: %v", err)
+	}
+
+	msg, err := message.NewMessageBuilder().
+		WithAttachmentManager(attManager).
+		From("alice#example.com").
+		To("bob#test.org").
+		Body("hello").
+		MessageID(id).
+		AttachData("note.txt", []byte("attachment data"), "text/plain").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := msg.Sign(keyPair); err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+	return msg
+}
+
+func TestFileMessageStore(t *testing.T) {
+	s, err := store.NewFileMessageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	msg := newSignedTestMessage(t, keyPair, "msg-1")
+	if err := s.Save(msg); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	loaded, err := s.Get("msg-1")
+	if err != nil {
+		t.Fatalf("Failed to load message: %v", err)
+	}
+	if loaded.Body != "hello" || loaded.Signature != msg.Signature {
+		t.Error("Loaded message does not match saved message")
+	}
+
+	if _, err := s.Get("missing"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := s.Get("../escape"); err == nil {
+		t.Error("Expected error for message ID containing a path")
+	}
+
+	if err := s.Delete("msg-1"); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	if ids, _ := s.IDs(); len(ids) != 0 {
+		t.Errorf("Expected empty store, got %v", ids)
+	}
+}
+
+func TestVerifyStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewFileMessageStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	lookup := func(address string) (string, error) {
+		return keyPair.PublicKeyBase64(), nil
+	}
+
+	// Healthy message
+	s.Save(newSignedTestMessage(t, keyPair, "good"))
+
+	// Body changed after signing
+	tampered := newSignedTestMessage(t, keyPair, "tampered")
+	tampered.Body = "altered"
+	s.Save(tampered)
+
+	// Attachment data changed after signing
+	badAttachment := newSignedTestMessage(t, keyPair, "bad-attachment")
+	badAttachment.Attachments[0].Data = []byte("attachment dat4")
+	s.Save(badAttachment)
+
+	// Truncated file on disk
+	os.WriteFile(filepath.Join(dir, "truncated.json"), []byte(`{"from":"alice#exa`), 0600)
+
+	report, err := store.VerifyStore(s, &store.VerifyOptions{SigningKeys: lookup})
+	if err != nil {
+		t.Fatalf("VerifyStore failed: %v", err)
+	}
+
+	if report.Checked != 4 || report.Healthy != 1 {
+		t.Errorf("Expected 4 checked and 1 healthy, got %d and %d", report.Checked, report.Healthy)
+	}
+
+	expected := map[string]store.IssueKind{
+		"tampered":       store.IssueInvalidSignature,
+		"truncated":      store.IssueUnreadable,
+		"bad-attachment": store.IssueAttachmentCorrupt,
+	}
+	for id, kind := range expected {
+		found := false
+		for _, issue := range report.IssuesFor(id) {
+			if issue.Kind == kind {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s issue for %s, got %v", kind, id, report.IssuesFor(id))
+		}
+	}
+
+	if len(report.Quarantined) != 0 {
+		t.Error("Nothing should be quarantined unless requested")
+	}
+
+	// Without a key lookup, signatures cannot be checked
+	report, _ = store.VerifyStore(s, nil)
+	if report.Unverified != 2 {
+		t.Errorf("Expected 2 unverified messages, got %d", report.Unverified)
+	}
+
+	// Quarantine bad entries
+	report, err = store.VerifyStore(s, &store.VerifyOptions{SigningKeys: lookup, Quarantine: true})
+	if err != nil {
+		t.Fatalf("VerifyStore failed: %v", err)
+	}
+	if len(report.Quarantined) != 3 {
+		t.Errorf("Expected 3 quarantined messages, got %v", report.Quarantined)
+	}
+
+	ids, _ := s.IDs()
+	if len(ids) != 1 || ids[0] != "good" {
+		t.Errorf("Expected only the healthy message to remain, got %v", ids)
+	}
+
+	quarantined, _ := s.QuarantinedIDs()
+	if len(quarantined) != 3 {
+		t.Errorf("Expected 3 quarantined IDs, got %v", quarantined)
+	}
+}
+
+func TestFileOutboxStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewFileOutboxStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	now := time.Now()
+	second := &store.OutboxEntry{Message: newSignedTestMessage(t, keyPair, "msg-2"), EnqueuedAt: now.Add(time.Second)}
+	first := &store.OutboxEntry{Message: newSignedTestMessage(t, keyPair, "msg-1"), EnqueuedAt: now}
+	for _, entry := range []*store.OutboxEntry{second, first} {
+		if err := s.Put(entry); err != nil {
+			t.Fatalf("Failed to queue %s: %v", entry.Message.MessageID, err)
+		}
+	}
+
+	// A second store on the same directory sees the queue, as after a restart
+	reopened, err := store.NewFileOutboxStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen outbox: %v", err)
+	}
+	entries, err := reopened.List()
+	if err != nil {
+		t.Fatalf("Failed to list outbox: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Message.MessageID != "msg-1" || entries[1].Message.MessageID != "msg-2" {
+		t.Fatalf("Expected msg-1 then msg-2, got %v", entries)
+	}
+
+	first.Attempts = 2
+	first.LastError = "server unavailable"
+	if err := reopened.Put(first); err != nil {
+		t.Fatalf("Failed to update entry: %v", err)
+	}
+	loaded, err := s.Get("msg-1")
+	if err != nil {
+		t.Fatalf("Failed to load entry: %v", err)
+	}
+	if loaded.Attempts != 2 || loaded.LastError != "server unavailable" {
+		t.Errorf("Expected updated attempt history, got %+v", loaded)
+	}
+
+	if err := s.Remove("msg-1"); err != nil {
+		t.Fatalf("Failed to remove entry: %v", err)
+	}
+	if err := s.Remove("msg-1"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Get("../escape"); err == nil {
+		t.Error("Expected error for message ID containing a path")
+	}
+}
+
+func TestNotesToSelf(t *testing.T) {
+	note := &message.Message{From: "alice#example.com", To: []string{"alice#Example.com"}, Body: "buy milk"}
+	if !note.IsNoteToSelf() {
+		t.Error("Expected message to own address to be a note to self")
+	}
+	for _, msg := range []*message.Message{
+		{From: "alice#example.com", To: []string{"alice#example.com", "bob#test.org"}},
+		{From: "alice#example.com", To: []string{"alice#example.com"}, CC: []string{"bob#test.org"}},
+		{From: "alice#example.com", To: []string{"alice#example.com"}, GroupID: "group-1"},
+		{From: "alice#example.com"},
+	} {
+		if msg.IsNoteToSelf() {
+			t.Errorf("Did not expect %v to be a note to self", msg)
+		}
+	}
+
+	config := client.DefaultConfig()
+	config.EnableDeliveryTracking = true
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := c.SendNoteToSelf("alice#example.com", "hello"); err == nil {
+		t.Error("Expected error without a message store")
+	}
+	if _, err := c.GetNotesToSelf("alice#example.com"); err == nil {
+		t.Error("Expected error listing notes without a message store")
+	}
+
+	messageStore := store.NewMemoryMessageStore()
+	c.SetMessageStore(messageStore)
+
+	for i, body := range []string{"second", "first"} {
+		msg := &message.Message{
+			From:      "alice#example.com",
+			To:        []string{"alice#example.com"},
+			Body:      body,
+			Timestamp: int64(200 - i*100),
+			MessageID: "note-" + body,
+		}
+		if err := messageStore.Save(msg); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+	}
+	other := &message.Message{From: "bob#test.org", To: []string{"alice#example.com"}, Body: "hi", MessageID: "msg-1"}
+	if err := messageStore.Save(other); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	notes, err := c.GetNotesToSelf(" alice#EXAMPLE.com")
+	if err != nil {
+		t.Fatalf("Failed to list notes: %v", err)
+	}
+	if len(notes) != 2 || notes[0].Body != "first" || notes[1].Body != "second" {
+		t.Fatalf("Expected two notes oldest first, got %v", notes)
+	}
+
+	// Sending still requires a key pair, and a failed note never creates a delivery receipt
+	if _, err := c.SendNoteToSelf("alice#example.com", "third"); err == nil {
+		t.Error("Expected error without a key pair")
+	}
+	if stats := c.GetDeliveryStats(); len(stats) != 0 {
+		t.Errorf("Expected no tracked deliveries, got %v", stats)
+	}
+}
+
+func TestLoadOrdered(t *testing.T) {
+	messageStore := store.NewMemoryMessageStore()
+	for _, msg := range []*message.Message{
+		{MessageID: "z", From: "bob#test.org", To: []string{"alice#example.com"}, Body: "second", Timestamp: 1700000000, Sequence: 1700000000500},
+		{MessageID: "a", From: "alice#example.com", To: []string{"bob#test.org"}, Body: "third", Timestamp: 1700000000, Sequence: 1700000000501},
+		{MessageID: "m", From: "alice#example.com", To: []string{"bob#test.org"}, Body: "first", Timestamp: 1700000000, TimestampMs: 1700000000200},
+		{MessageID: "g", From: "alice#example.com", To: []string{"carol#test.org"}, Body: "elsewhere", Timestamp: 1700000000},
+	} {
+		if err := messageStore.Save(msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	c, err := client.NewWithKeyPair(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	c.SetMessageStore(messageStore)
+
+	conversation, err := c.GetConversation("alice#example.com", "bob#test.org")
+	if err != nil {
+		t.Fatalf("Failed to load conversation: %v", err)
+	}
+	var bodies []string
+	for _, msg := range conversation {
+		bodies = append(bodies, msg.Body)
+	}
+	if len(bodies) != 3 || bodies[0] != "first" || bodies[1] != "second" || bodies[2] != "third" {
+		t.Errorf("Expected first, second, third, got %v", bodies)
+	}
+
+	all, err := store.LoadOrdered(messageStore, nil)
+	if err != nil {
+		t.Fatalf("Failed to load messages: %v", err)
+	}
+	if len(all) != 4 || all[0].MessageID != "g" {
+		t.Errorf("Expected 4 messages starting with the oldest, got %d", len(all))
+	}
+}
+
+func TestEncryptedDrafts(t *testing.T) {
+	dir := t.TempDir()
+	drafts, err := store.NewFileDraftStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create draft store: %v", err)
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	config := client.DefaultConfig()
+	config.KeyPair = keyPair
+	config.DraftStore = drafts
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	draft := &client.Draft{Conversation: "bob#test.org", To: []string{"bob#test.org"}, Body: "secret plans"}
+	if err := emsgClient.SaveDraft(draft); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	// Neither the body nor the conversation is visible on disk, beside the wrapped draft key
+	draftFiles := func() []os.DirEntry {
+		entries, _ := os.ReadDir(dir)
+		return slices.DeleteFunc(entries, func(entry os.DirEntry) bool { return entry.Name() == "draft-key.draft" })
+	}
+	entries := draftFiles()
+	if len(entries) != 1 {
+		t.Fatalf("Expected one draft file, got %d", len(entries))
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if strings.Contains(string(data), "secret plans") || strings.Contains(entries[0].Name()+string(data), "bob") {
+		t.Error("Draft stored in plaintext")
+	}
+
+	loaded, err := emsgClient.LoadDraft("bob#test.org")
+	if err != nil {
+		t.Fatalf("Failed to load draft: %v", err)
+	}
+	if loaded.Body != "secret plans" || loaded.UpdatedAt.IsZero() {
+		t.Errorf("Unexpected draft: %+v", loaded)
+	}
+
+	// A client with a different key cannot read the drafts
+	otherKey, _ := keymgmt.GenerateKeyPair()
+	config.KeyPair = otherKey
+	other, _ := client.New(config)
+	if _, err := other.LoadDraft("bob#test.org"); err == nil {
+		t.Error("Expected draft sealed under another key to fail to open")
+	}
+	if listed, _ := other.ListDrafts(); len(listed) != 0 {
+		t.Errorf("Expected unreadable drafts to be skipped, got %d", len(listed))
+	}
+
+	if err := emsgClient.DeleteDraft("bob#test.org"); err != nil {
+		t.Fatalf("Failed to delete draft: %v", err)
+	}
+	if _, err := emsgClient.LoadDraft("bob#test.org"); err != store.ErrDraftNotFound {
+		t.Errorf("Expected ErrDraftNotFound, got %v", err)
+	}
+
+	// Builder drafts are sealed the same way and kept out of ListDrafts
+	builderDrafts, err := emsgClient.BuilderDraftStore()
+	if err != nil {
+		t.Fatalf("Failed to get builder draft store: %v", err)
+	}
+	if err := emsgClient.ComposeMessage().To("bob#test.org").Body("secret plans").SaveDraft(builderDrafts, "compose-1"); err != nil {
+		t.Fatalf("Failed to save builder draft: %v", err)
+	}
+	entries = draftFiles()
+	data, _ = os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if len(entries) != 1 || strings.Contains(string(data), "secret plans") {
+		t.Error("Builder draft stored in plaintext")
+	}
+	restored := emsgClient.ComposeMessage()
+	if err := restored.LoadDraft(builderDrafts, "compose-1"); err != nil || restored.Draft().Body != "secret plans" {
+		t.Errorf("Failed to load builder draft: %v", err)
+	}
+	if listed, _ := emsgClient.ListDrafts(); len(listed) != 0 {
+		t.Errorf("Expected builder drafts to be left out of ListDrafts, got %d", len(listed))
+	}
+
+	withoutDrafts, _ := client.New(client.DefaultConfig())
+	if err := withoutDrafts.SaveDraft(draft); err == nil {
+		t.Error("Expected error when drafts are not enabled")
+	}
+	if _, err := withoutDrafts.BuilderDraftStore(); err == nil {
+		t.Error("Expected error getting a builder draft store when drafts are not enabled")
+	}
+}
+
+func TestDraftsSurviveKeyRotation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	drafts := store.NewMemoryDraftStore()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.DraftStore = drafts
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := emsgClient.SaveDraft(&client.Draft{Conversation: "bob#test.org", Body: "kept"}); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	newKeyPair, err := emsgClient.RotateKey("alice#example.com")
+	if err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+
+	// After a restart with only the new key, the draft still opens
+	config.KeyPair = newKeyPair
+	restarted, _ := client.New(config)
+	loaded, err := restarted.LoadDraft("bob#test.org")
+	if err != nil || loaded.Body != "kept" {
+		t.Fatalf("Expected the draft to survive rotation, got %+v: %v", loaded, err)
+	}
+	if listed, _ := restarted.ListDrafts(); len(listed) != 1 {
+		t.Errorf("Expected the wrapped draft key to be left out of ListDrafts, got %d drafts", len(listed))
+	}
+}
+
+func TestDraftsSavedBeforeStoredDraftKey(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+
+	// Drafts used to be sealed with a key derived from the signing key seed
+	var legacyKey [32]byte
+	io.ReadFull(hkdf.New(sha256.New, keyPair.PrivateKey.Seed(), nil, []byte("emsg drafts v1")), legacyKey[:])
+	plaintext, _ := json.Marshal(&client.Draft{Conversation: "bob#test.org", Body: "from before"})
+	var nonce [24]byte
+	sum := sha256.Sum256([]byte("bob#test.org"))
+	drafts := store.NewMemoryDraftStore()
+	drafts.Put(hex.EncodeToString(sum[:]), secretbox.Seal(nonce[:], plaintext, &nonce, &legacyKey))
+
+	config := client.DefaultConfig()
+	config.KeyPair = keyPair
+	config.DraftStore = drafts
+	emsgClient, _ := client.New(config)
+	loaded, err := emsgClient.LoadDraft("bob#test.org")
+	if err != nil || loaded.Body != "from before" {
+		t.Fatalf("Expected a draft saved before draft keys were stored to open, got %+v: %v", loaded, err)
+	}
+}
+
+func TestWipeAll(t *testing.T) {
+	messages, err := store.NewFileMessageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	for _, id := range []string{"msg-1", "msg-2"} {
+		if err := messages.Save(newSignedTestMessage(t, keyPair, id)); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	if err := messages.Quarantine("msg-2", "bad signature"); err != nil {
+		t.Fatalf("Failed to quarantine message: %v", err)
+	}
+	outbox := store.NewMemoryOutboxStore()
+	if err := outbox.Put(&store.OutboxEntry{Message: newSignedTestMessage(t, keyPair, "msg-3"), EnqueuedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to queue message: %v", err)
+	}
+
+	config := client.DefaultConfig()
+	config.KeyPair = keyPair
+	config.MessageStore = messages
+	config.Outbox = outbox
+	config.DraftStore = store.NewMemoryDraftStore()
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := emsgClient.SaveDraft(&client.Draft{Conversation: "bob#test.org", Body: "draft"}); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	if err := emsgClient.WipeAll(); err != nil {
+		t.Fatalf("WipeAll failed: %v", err)
+	}
+
+	if ids, _ := messages.IDs(); len(ids) != 0 {
+		t.Errorf("Expected no stored messages, got %v", ids)
+	}
+	if ids, _ := messages.QuarantinedIDs(); len(ids) != 0 {
+		t.Errorf("Expected no quarantined messages, got %v", ids)
+	}
+	if entries, _ := outbox.List(); len(entries) != 0 {
+		t.Errorf("Expected empty outbox, got %d entries", len(entries))
+	}
+	if keys, _ := config.DraftStore.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no drafts, got %v", keys)
+	}
+	for _, b := range keyPair.PrivateKey {
+		if b != 0 {
+			t.Fatal("Expected private key to be zeroed")
+		}
+	}
+}
+
+func TestStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	messages, err := store.NewFileMessageStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	for _, id := range []string{"msg-1", "msg-2"} {
+		if err := messages.Save(newSignedTestMessage(t, keyPair, id)); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	if err := messages.Quarantine("msg-2", "bad signature"); err != nil {
+		t.Fatalf("Failed to quarantine message: %v", err)
+	}
+
+	// Leftovers from interrupted writes and a quarantine record without its message
+	os.WriteFile(filepath.Join(dir, "msg-3.json.tmp"), []byte("partial"), 0600)
+	os.WriteFile(filepath.Join(dir, "quarantine", "msg-4.reason"), []byte("{}"), 0600)
+
+	outboxDir := t.TempDir()
+	outbox, err := store.NewFileOutboxStore(outboxDir)
+	if err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+	os.WriteFile(filepath.Join(outboxDir, "msg-5.json.tmp"), []byte("partial"), 0600)
+
+	config := client.DefaultConfig()
+	config.KeyPair = keyPair
+	config.MessageStore = messages
+	config.Outbox = outbox
+	config.MaintenanceSchedule = &client.MaintenanceSchedule{Interval: 10 * time.Millisecond}
+	reports := make(chan *client.MaintenanceReport, 10)
+	config.OnMaintenance = func(report *client.MaintenanceReport) {
+		select {
+		case reports <- report:
+		default:
+		}
+	}
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	report, err := emsgClient.Maintain(context.Background())
+	if err != nil {
+		t.Fatalf("Maintain failed: %v", err)
+	}
+	if report.Total.FilesRemoved != 3 || report.Total.BytesReclaimed != int64(len("partial")*2+len("{}")) {
+		t.Errorf("Unexpected totals: %+v", report.Total)
+	}
+	if report.Stores["messages"].FilesRemoved != 2 || report.Stores["outbox"].FilesRemoved != 1 {
+		t.Errorf("Unexpected per-store results: %+v", report.Stores)
+	}
+	if <-reports != report {
+		t.Error("Expected OnMaintenance to receive the report")
+	}
+
+	// Live data is untouched
+	if _, err := messages.Get("msg-1"); err != nil {
+		t.Errorf("Expected message to survive compaction: %v", err)
+	}
+	if ids, _ := messages.QuarantinedIDs(); len(ids) != 1 {
+		t.Errorf("Expected quarantined message to survive compaction, got %v", ids)
+	}
+
+	if err := emsgClient.StartMaintenance(); err != nil {
+		t.Fatalf("Failed to start maintenance: %v", err)
+	}
+	select {
+	case report := <-reports:
+		if !report.Scheduled || report.Total.FilesRemoved != 0 {
+			t.Errorf("Unexpected scheduled report: %+v", report)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected scheduled maintenance to run")
+	}
+	emsgClient.Close()
+	if emsgClient.IsMaintenanceRunning() {
+		t.Error("Expected Close to stop maintenance")
+	}
+}
+
+// indexedStore records how Import drives a store with indexes and fails a batch on demand
+type indexedStore struct {
+	*store.MemoryMessageStore
+	suspended, rebuilt int
+	batches            []int
+	failBatch          int
+}
+
+func (s *indexedStore) SuspendIndexing() error { s.suspended++; return nil }
+func (s *indexedStore) RebuildIndexes() error  { s.rebuilt++; return nil }
+
+func (s *indexedStore) SaveBatch(messages []*message.Message) error {
+	if len(s.batches)+1 == s.failBatch {
+		return os.ErrPermission
+	}
+	s.batches = append(s.batches, len(messages))
+	return s.MemoryMessageStore.SaveBatch(messages)
+}
+
+func TestImportMessages(t *testing.T) {
+	newMessages := func(ids ...string) []*message.Message {
+		messages := make([]*message.Message, len(ids))
+		for i, id := range ids {
+			messages[i] = &message.Message{MessageID: id, From: "alice#example.com", To: []string{"bob#example.com"}, Body: id}
+		}
+		return messages
+	}
+
+	target := &indexedStore{MemoryMessageStore: store.NewMemoryMessageStore()}
+	target.Save(newMessages("m0")[0])
+	target.Save(newMessages("q0")[0])
+	target.Quarantine("q0", "tampered")
+
+	// Stored, quarantined and repeated messages are skipped; messages without an ID rejected
+	source := newMessages("m0", "m1", "m2", "q0", "m3", "m1", "m4", "m5", "")
+	var progress []store.ImportProgress
+	result, err := store.Import(context.Background(), target, store.SliceSource(source), &store.ImportOptions{
+		BatchSize:  2,
+		OnProgress: func(p *store.ImportProgress) { progress = append(progress, *p) },
+	})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Read != 9 || result.Imported != 5 || result.Skipped != 3 || result.Rejected != 1 || result.Batches != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(target.batches) != 3 || target.batches[2] != 1 {
+		t.Errorf("Expected batches of 2, 2 and 1, got %v", target.batches)
+	}
+	if len(progress) != 3 || progress[1].Imported != 4 {
+		t.Errorf("Unexpected progress reports: %+v", progress)
+	}
+	if target.suspended != 1 || target.rebuilt != 1 {
+		t.Errorf("Expected indexing suspended and rebuilt once, got %d and %d", target.suspended, target.rebuilt)
+	}
+	if ids, _ := target.IDs(); len(ids) != 6 {
+		t.Errorf("Expected 6 stored messages, got %v", ids)
+	}
+
+	// A failed batch stops the import; running it again resumes after the written batches
+	target = &indexedStore{MemoryMessageStore: store.NewMemoryMessageStore(), failBatch: 2}
+	source = newMessages("a", "b", "c", "d", "e")
+	result, err = store.Import(context.Background(), target, store.SliceSource(source), &store.ImportOptions{BatchSize: 2})
+	if err == nil || result.Imported != 2 {
+		t.Fatalf("Expected the second batch to fail after 2 imported, got %+v, %v", result, err)
+	}
+	if target.rebuilt != 1 {
+		t.Error("Expected indexes rebuilt after a failed import")
+	}
+	target.failBatch = 0
+	result, err = store.Import(context.Background(), target, store.SliceSource(source), &store.ImportOptions{BatchSize: 2})
+	if err != nil || result.Imported != 3 || result.Skipped != 2 {
+		t.Errorf("Expected resumed import of 3, got %+v, %v", result, err)
+	}
+
+	// Producers feeding a channel block until the store catches up
+	ch := make(chan *message.Message)
+	go func() {
+		defer close(ch)
+		for _, msg := range newMessages("c1", "c2", "c3") {
+			ch <- msg
+		}
+	}()
+	fileStore, err := store.NewFileMessageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	config := client.DefaultConfig()
+	config.MessageStore = fileStore
+	emsgClient, _ := client.New(config)
+	result, err = emsgClient.ImportMessages(context.Background(), store.ChannelSource(ch), nil)
+	if err != nil || result.Imported != 3 || result.Batches != 1 {
+		t.Errorf("Unexpected channel import: %+v, %v", result, err)
+	}
+	if msg, err := fileStore.Get("c2"); err != nil || msg.Body != "c2" {
+		t.Errorf("Expected imported message in file store, got %v, %v", msg, err)
+	}
+
+	emsgClient, _ = client.New(client.DefaultConfig())
+	if _, err := emsgClient.ImportMessages(context.Background(), store.SliceSource(nil), nil); err == nil {
+		t.Error("Expected error importing without a message store")
+	}
+}
+
+func TestMessageAnnotations(t *testing.T) {
+	messageStore, err := store.NewFileMessageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	config := client.DefaultConfig()
+	config.MessageStore = messageStore
+	c, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	first := newSignedTestMessage(t, keyPair, "msg-1")
+	second := newSignedTestMessage(t, keyPair, "msg-2")
+	for _, msg := range []*message.Message{first, second} {
+		if err := messageStore.Save(msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	if err := c.StarMessage("missing", true); err == nil {
+		t.Error("Expected error annotating a message that is not stored")
+	}
+	if err := c.StarMessage("msg-2", true); err != nil {
+		t.Fatalf("Failed to star message: %v", err)
+	}
+	if err := c.StarMessage("msg-1", true); err != nil {
+		t.Fatalf("Failed to star message: %v", err)
+	}
+	for _, tag := range []string{"travel", " receipts ", "travel"} {
+		if err := c.TagMessage("msg-1", tag); err != nil {
+			t.Fatalf("Failed to tag message: %v", err)
+		}
+	}
+	if err := c.SetAnnotationValue("msg-1", "reminder", "2026-11-01"); err != nil {
+		t.Fatalf("Failed to set annotation value: %v", err)
+	}
+	if err := c.ArchiveMessage("msg-2", true); err != nil {
+		t.Fatalf("Failed to archive message: %v", err)
+	}
+
+	annotation, err := c.GetAnnotation("msg-1")
+	if err != nil {
+		t.Fatalf("Failed to get annotation: %v", err)
+	}
+	if !annotation.Starred || strings.Join(annotation.Tags, ",") != "receipts,travel" || annotation.Values["reminder"] != "2026-11-01" {
+		t.Errorf("Unexpected annotation: %+v", annotation)
+	}
+	starred, err := c.GetStarredMessages()
+	if err != nil || len(starred) != 2 {
+		t.Fatalf("Expected both messages starred, got %v, %v", starred, err)
+	}
+	if tagged, _ := c.GetTaggedMessages("travel"); len(tagged) != 1 || tagged[0].MessageID != "msg-1" {
+		t.Errorf("Expected msg-1 tagged travel, got %v", tagged)
+	}
+	if archived, _ := c.GetArchivedMessages(); len(archived) != 1 || archived[0].MessageID != "msg-2" {
+		t.Errorf("Expected msg-2 archived, got %v", archived)
+	}
+
+	// Annotations never touch the signed message
+	stored, _ := messageStore.Get("msg-1")
+	if stored.Signature != first.Signature || stored.Verify(keyPair.PublicKeyBase64()) != nil {
+		t.Error("Expected the annotated message to still verify")
+	}
+
+	// Export and import carry annotations along
+	var exported strings.Builder
+	if err := c.ExportConversation("bob#test.org", "alice#example.com", &exported); err != nil {
+		t.Fatalf("Failed to export conversation: %v", err)
+	}
+	otherStore := store.NewMemoryMessageStore()
+	otherConfig := client.DefaultConfig()
+	otherConfig.MessageStore = otherStore
+	other, err := client.New(otherConfig)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	result, err := other.ImportConversation(context.Background(), strings.NewReader(exported.String()))
+	if err != nil || result.Imported != 2 {
+		t.Fatalf("Expected two messages imported, got %+v, %v", result, err)
+	}
+	if imported, _ := other.GetAnnotation("msg-1"); !imported.HasTag("travel") || imported.Values["reminder"] != "2026-11-01" {
+		t.Errorf("Expected the annotation to be imported, got %+v", imported)
+	}
+
+	// Clearing everything removes the annotation, as does deleting the message
+	if err := c.StarMessage("msg-2", false); err != nil {
+		t.Fatalf("Failed to unstar message: %v", err)
+	}
+	if err := c.ArchiveMessage("msg-2", false); err != nil {
+		t.Fatalf("Failed to unarchive message: %v", err)
+	}
+	if err := c.DeleteMessage("msg-1"); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	if ids, _ := messageStore.AnnotatedIDs(); len(ids) != 0 {
+		t.Errorf("Expected no annotations left, got %v", ids)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	messageStore := store.NewMemoryMessageStore()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.MessageStore = messageStore
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	// Sent messages are stored automatically
+	question, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#test.org").Subject("Lunch").Body("Pizza on Friday?").Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := emsgClient.SendMessage(question); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, err := messageStore.Get(question.MessageID); err != nil {
+		t.Fatalf("Expected the sent message to be stored: %v", err)
+	}
+
+	base := question.SentAt().Add(time.Minute)
+	received := []*message.Message{
+		{MessageID: "r1", From: "bob#test.org", To: []string{"alice#example.com"}, Body: "Sure, pizza it is", InReplyTo: question.MessageID, TimestampMs: base.UnixMilli()},
+		{MessageID: "r2", From: "carol#test.org", To: []string{"alice#example.com"}, Body: "Count me in", InReplyTo: "r1", TimestampMs: base.Add(time.Hour).UnixMilli()},
+		{MessageID: "g1", From: "bob#test.org", To: []string{"eng#example.com"}, GroupID: "eng#example.com", Body: "Deploy at noon", TimestampMs: base.Add(24 * time.Hour).UnixMilli()},
+		{MessageID: "e1", From: "bob#test.org", To: []string{"alice#example.com"}, Body: "cGl6emE=", Encrypted: true, TimestampMs: base.Add(48 * time.Hour).UnixMilli()},
+	}
+	for _, msg := range received {
+		if err := messageStore.Save(msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	ids := func(query *store.Query) string {
+		t.Helper()
+		messages, err := emsgClient.SearchMessages(query)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		var found []string
+		for _, msg := range messages {
+			if msg.MessageID == question.MessageID {
+				found = append(found, "question")
+			} else {
+				found = append(found, msg.MessageID)
+			}
+		}
+		return strings.Join(found, ",")
+	}
+
+	tests := []struct {
+		name  string
+		query *store.Query
+		want  string
+	}{
+		{"sender", &store.Query{From: "bob#Test.ORG"}, "r1,g1,e1"},
+		{"group", &store.Query{GroupID: "eng#example.com"}, "g1"},
+		{"thread", &store.Query{ThreadID: question.MessageID}, "question,r1,r2"},
+		{"text", &store.Query{Text: "PIZZA"}, "question,r1"},
+		{"date range", &store.Query{Since: base, Until: base.Add(24 * time.Hour)}, "r1,r2"},
+		{"peer and limit", &store.Query{Peer: "bob#test.org", Limit: 2}, "g1,e1"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.query); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestExpiredMessages(t *testing.T) {
+	now := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v1/messages" {
+			fmt.Fprintf(w, `[
+				{"from":"bob#test.org","to":["alice#example.com"],"body":"gone","timestamp":%d,"expires_at":%d,"message_id":"expired"},
+				{"from":"bob#test.org","to":["alice#example.com"],"body":"still here","timestamp":%d,"expires_at":%d,"message_id":"live"}]`,
+				now.Add(-2*time.Hour).Unix(), now.Add(-time.Hour).Unix(), now.Unix(), now.Add(time.Hour).Unix())
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	messageStore := store.NewMemoryMessageStore()
+	config := client.DefaultConfig()
+	config.KeyPair, _ = keymgmt.GenerateKeyPair()
+	config.MessageStore = messageStore
+	config.EnableDeliveryTracking = true
+	config.Resolver = client.ResolverFunc(func(domain string) (*dns.EMSGServerInfo, error) {
+		return &dns.EMSGServerInfo{URL: server.URL}, nil
+	})
+	emsgClient, err := client.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer emsgClient.Close()
+
+	// Expired messages are rejected on receipt and never stored
+	messages, err := emsgClient.GetMessages("alice#example.com")
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].MessageID != "live" {
+		t.Fatalf("Expected only the live message, got %v", messages)
+	}
+	if _, err := messageStore.Get("expired"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the expired message not to be stored, got %v", err)
+	}
+
+	// Stored messages are purged once they expire
+	stale := &message.Message{MessageID: "stale", From: "bob#test.org", To: []string{"alice#example.com"}, Body: "old",
+		Timestamp: now.Add(-time.Hour).Unix(), ExpiresAt: now.Add(-time.Minute).Unix()}
+	if err := messageStore.Save(stale); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+	if found, _ := emsgClient.SearchMessages(&store.Query{}); len(found) != 1 {
+		t.Errorf("Expected search to skip expired messages, got %d", len(found))
+	}
+	report, err := emsgClient.Maintain(context.Background())
+	if err != nil {
+		t.Fatalf("Maintenance failed: %v", err)
+	}
+	if report.Expired != 1 {
+		t.Errorf("Expected 1 expired message purged, got %d", report.Expired)
+	}
+	if ids, _ := messageStore.IDs(); len(ids) != 1 || ids[0] != "live" {
+		t.Errorf("Expected only the live message left, got %v", ids)
+	}
+
+	// Delivery receipts carry the TTL
+	msg, err := emsgClient.ComposeMessage().From("alice#example.com").To("bob#test.org").Body("brief").ExpiresIn(time.Hour).Build()
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := emsgClient.SendMessage(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	receipt, err := emsgClient.GetDeliveryReceipt(msg.MessageID)
+	if err != nil {
+		t.Fatalf("Failed to get receipt: %v", err)
+	}
+	if ttl, ok := receipt.TTL(time.Now()); !ok || ttl <= 59*time.Minute || receipt.ExpiresAt != msg.ExpiresAt {
+		t.Errorf("Expected the receipt to carry the TTL, got %v, %v", ttl, ok)
+	}
+
+	// Messages that expired before sending are not sent
+	stale.MessageID = "stale-send"
+	if err := emsgClient.SendMessage(stale); !errors.Is(err, message.ErrMessageExpired) {
+		t.Errorf("Expected sending an expired message to fail, got %v", err)
+	}
+	if receipt, _ := emsgClient.GetDeliveryReceipt(stale.MessageID); receipt == nil || receipt.Status != delivery.StatusExpired {
+		t.Errorf("Expected an expired receipt, got %+v", receipt)
+	}
+}
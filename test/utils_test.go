@@ -1,7 +1,13 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
@@ -336,3 +342,194 @@ func TestParseEMSGAddressList(t *testing.T) {
 		t.Error("Expected error for invalid address in list")
 	}
 }
+
+func TestMatchDomainPattern(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		domain   string
+		expected bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "mail.example.com", false},
+		{"*.example.com", "mail.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.org", true},
+		{"", "example.com", false},
+	}
+
+	for _, tc := range testCases {
+		result := utils.MatchDomainPattern(tc.pattern, tc.domain)
+		if result != tc.expected {
+			t.Errorf("MatchDomainPattern(%q, %q) = %v, expected %v", tc.pattern, tc.domain, result, tc.expected)
+		}
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	var group utils.FlightGroup[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err, _ := group.Do("alice#example.com", func() (string, error) {
+				calls.Add(1)
+				<-release
+				return "key", nil
+			})
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			results <- val
+		}()
+	}
+
+	// Let every caller join the call in flight before it completes
+	deadline := time.Now().Add(2 * time.Second)
+	for group.InFlight() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for val := range results {
+		if val != "key" {
+			t.Errorf("Expected shared result, got %q", val)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected concurrent calls to collapse into one, got %d", n)
+	}
+	if group.InFlight() != 0 {
+		t.Error("Completed call should not stay in flight")
+	}
+
+	// Later calls run again
+	if _, err, shared := group.Do("alice#example.com", func() (string, error) { return "", errors.New("gone") }); err == nil || shared {
+		t.Errorf("Expected a fresh unshared call, got err=%v shared=%v", err, shared)
+	}
+}
+
+func TestFlightGroupDoContext(t *testing.T) {
+	var group utils.FlightGroup[int]
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err, _ := group.DoContext(ctx, "key", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 42, ctx.Err()
+		})
+		leaderErr <- err
+	}()
+	<-started
+
+	follower := make(chan int, 1)
+	go func() {
+		val, err, _ := group.DoContext(context.Background(), "key", func(ctx context.Context) (int, error) {
+			return 0, errors.New("should have joined the call in flight")
+		})
+		if err != nil {
+			t.Errorf("Follower failed: %v", err)
+		}
+		follower <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The first caller giving up does not cancel the call for the others
+	cancel()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled caller to get its context error, got %v", err)
+	}
+	close(release)
+
+	select {
+	case val := <-follower:
+		if val != 42 {
+			t.Errorf("Expected follower to receive 42, got %d", val)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Follower did not receive the result")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	addr, err := utils.ParseAddress("alice#Example.COM")
+	if err != nil {
+		t.Fatalf("Failed to parse address: %v", err)
+	}
+	if addr.User() != "alice" || addr.Domain() != "example.com" || addr.String() != "alice#example.com" {
+		t.Errorf("Expected normalized alice#example.com, got %q / %q / %q", addr.User(), addr.Domain(), addr)
+	}
+	if addr != utils.MustParseAddress("alice#example.com") {
+		t.Error("Expected addresses differing only in domain case to be equal")
+	}
+	if addr.DNSName() != "_emsg.example.com" {
+		t.Errorf("Unexpected DNS name %s", addr.DNSName())
+	}
+
+	if _, err := utils.ParseAddress("alice@example.com"); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+	if _, err := utils.ParseAddresses([]string{"alice#example.com", "bad"}); err == nil {
+		t.Error("Expected error for invalid address in list")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected MustParseAddress to panic on an invalid address")
+			}
+		}()
+		utils.MustParseAddress("bad")
+	}()
+
+	legacy, _ := utils.ParseEMSGAddress("bob#Test.org")
+	if legacy.Address().String() != "bob#test.org" {
+		t.Errorf("Expected EMSGAddress conversion, got %s", legacy.Address())
+	}
+}
+
+func TestAddressJSON(t *testing.T) {
+	type envelope struct {
+		From  utils.Address         `json:"from"`
+		Reply utils.Address         `json:"reply"`
+		Seen  map[utils.Address]int `json:"seen"`
+	}
+
+	in := envelope{
+		From: utils.MustParseAddress("alice#example.com"),
+		Seen: map[utils.Address]int{utils.MustParseAddress("bob#example.com"): 2},
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if string(data) != `{"from":"alice#example.com","reply":"","seen":{"bob#example.com":2}}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var out envelope
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if out.From != in.From || !out.Reply.IsZero() || out.Seen[utils.MustParseAddress("bob#example.com")] != 2 {
+		t.Errorf("Round trip mismatch: %+v", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"from":"not an address"}`), &out); err == nil {
+		t.Error("Expected invalid address to fail to unmarshal")
+	}
+}
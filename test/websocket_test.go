@@ -1,9 +1,21 @@
 package test
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	gorillaws "github.com/gorilla/websocket"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
 	"github.com/emsg-protocol/emsg-client-sdk/notifications"
@@ -263,3 +275,821 @@ func TestWebSocketMessageSending(t *testing.T) {
 		t.Error("SendMessage should fail when not connected")
 	}
 }
+
+func TestWebSocketDoneBeforeConnect(t *testing.T) {
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	notificationManager := notifications.NewNotificationManager(5)
+	defer notificationManager.Shutdown()
+
+	wsClient := websocket.NewWebSocketClient("ws://localhost:8080", keyPair, notificationManager)
+
+	select {
+	case <-wsClient.Done():
+	default:
+		t.Error("Done should be closed when never connected")
+	}
+}
+
+func TestWebSocketConnectContextCancelled(t *testing.T) {
+	// A server that accepts TCP connections but never completes the handshake
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := client.ConnectContext(ctx, "alice#example.com"); err == nil {
+		t.Fatal("Expected connect to fail when the context expires")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Connect should stop at the context deadline, took %v", elapsed)
+	}
+	if client.IsConnected() {
+		t.Error("Client should not be connected")
+	}
+}
+
+func TestValidateCustomEventType(t *testing.T) {
+	valid := []string{"editor.cursor", "docs.presence.update", "app_1.ping"}
+	for _, eventType := range valid {
+		if err := websocket.ValidateCustomEventType(eventType); err != nil {
+			t.Errorf("Expected %q to be valid: %v", eventType, err)
+		}
+	}
+
+	invalid := []string{"", "cursor", "Editor.Cursor", "editor.", "emsg.message", "system.ping"}
+	for _, eventType := range invalid {
+		if err := websocket.ValidateCustomEventType(eventType); err == nil {
+			t.Errorf("Expected %q to be invalid", eventType)
+		}
+	}
+}
+
+func TestWebSocketCustomEvents(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Echo custom events back as if relayed from another participant
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			if frame.Type == "custom" {
+				frame.From = "bob#example.com"
+				conn.WriteJSON(&frame)
+			}
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+
+	type cursor struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	}
+
+	received := make(chan cursor, 1)
+	err := websocket.HandleCustomEvent(client, "editor.cursor", func(payload cursor, event *websocket.CustomEvent) {
+		if event.From != "bob#example.com" {
+			t.Errorf("Expected sender bob#example.com, got %q", event.From)
+		}
+		received <- payload
+	})
+	if err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+
+	namespaceEvents := make(chan string, 2)
+	if err := client.RegisterCustomEventHandler("editor.*", func(event *websocket.CustomEvent) {
+		namespaceEvents <- event.Type
+	}); err != nil {
+		t.Fatalf("Failed to register wildcard handler: %v", err)
+	}
+
+	if err := client.RegisterCustomEventHandler("emsg.*", func(*websocket.CustomEvent) {}); err == nil {
+		t.Error("Expected error registering handler for reserved namespace")
+	}
+
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	if err := client.SendCustomEvent("editor.cursor", cursor{Line: 3, Column: 14}); err != nil {
+		t.Fatalf("Failed to send custom event: %v", err)
+	}
+	if err := client.SendCustomEvent("cursor", nil); err == nil {
+		t.Error("Expected error sending un-namespaced event")
+	}
+
+	select {
+	case got := <-received:
+		if got.Line != 3 || got.Column != 14 {
+			t.Errorf("Unexpected payload: %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for custom event")
+	}
+
+	select {
+	case eventType := <-namespaceEvents:
+		if eventType != "editor.cursor" {
+			t.Errorf("Expected editor.cursor, got %s", eventType)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for wildcard handler")
+	}
+}
+
+func TestWebSocketBufferLimits(t *testing.T) {
+	wsClient := websocket.NewWebSocketClient("ws://localhost:8080", nil, nil)
+
+	if _, capacity := wsClient.QueueDepth(); capacity != 100 {
+		t.Errorf("Expected default queue capacity 100, got %d", capacity)
+	}
+
+	wsClient.SetBufferLimits(&websocket.BufferLimits{QueueSize: 8, MaxMessageSize: 64})
+	if _, capacity := wsClient.QueueDepth(); capacity != 8 {
+		t.Errorf("Expected queue capacity 8, got %d", capacity)
+	}
+}
+
+func TestWebSocketConnectionLost(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	var connections atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Drop the first connection without a close frame, keep later ones open
+		if connections.Add(1) == 1 {
+			conn.Close()
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+
+	if err := client.Reconnect(); err == nil {
+		t.Error("Reconnect should fail before the first connect")
+	}
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection goroutines did not exit after the connection was lost")
+	}
+	if client.IsConnected() {
+		t.Error("Client should not be connected after the connection was lost")
+	}
+	if client.Err() == nil {
+		t.Error("Expected the lost connection to be reported by Err")
+	}
+
+	if err := client.Reconnect(); err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	if client.Err() != nil {
+		t.Error("Err should be cleared by a new connection")
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if client.Err() != nil {
+		t.Error("Disconnect should not be reported as a failure")
+	}
+}
+
+func TestWebSocketMessageAcks(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Ack by correlation ID, reject messages to nobody#example.com and ignore
+		// messages with the subject "drop"
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			if frame.Type != "message" || frame.Message.Subject == "drop" {
+				continue
+			}
+			ack := map[string]any{"message_id": frame.Message.MessageID}
+			if frame.Message.To[0] == "nobody#example.com" {
+				ack["error"] = "unknown recipient"
+				ack["code"] = http.StatusNotFound
+			}
+			data, _ := json.Marshal(ack)
+			conn.WriteJSON(&websocket.WebSocketMessage{Type: "ack", CorrelationID: frame.CorrelationID, Data: data})
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	tracker := delivery.NewDeliveryTracker(nil)
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	client.SetDeliveryTracker(tracker)
+	client.SetAckTimeout(200 * time.Millisecond)
+
+	acks := make(chan *websocket.AckInfo, 1)
+	client.RegisterEventHandler(websocket.EventAck, func(data interface{}) {
+		acks <- data.(*websocket.AckInfo)
+	})
+
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	send := func(to, subject string) (*message.Message, error) {
+		msg, err := message.NewMessageBuilder().From("alice#example.com").To(to).Subject(subject).Body("hi").Build()
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		tracker.TrackMessage(msg)
+		return msg, client.SendMessage(msg)
+	}
+
+	msg, err := send("bob#example.com", "hello")
+	if err != nil {
+		t.Fatalf("Expected message to be acked, got %v", err)
+	}
+	if receipt, _ := tracker.GetDeliveryReceipt(msg.MessageID); receipt.Status != delivery.StatusSent {
+		t.Errorf("Expected acked message to be sent, got %s", receipt.Status)
+	}
+	select {
+	case ack := <-acks:
+		if ack.MessageID != msg.MessageID || ack.CorrelationID == "" {
+			t.Errorf("Unexpected ack: %+v", ack)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for ack event")
+	}
+
+	msg, err = send("nobody#example.com", "hello")
+	if !errors.Is(err, websocket.ErrMessageRejected) {
+		t.Fatalf("Expected rejection, got %v", err)
+	}
+	if receipt, _ := tracker.GetDeliveryReceipt(msg.MessageID); receipt.Status != delivery.StatusFailed || receipt.FailureReason != delivery.FailureRecipientUnknown {
+		t.Errorf("Expected rejected message to fail as recipient unknown, got %s (%s)", receipt.Status, receipt.FailureReason)
+	}
+
+	msg, err = send("bob#example.com", "drop")
+	if !errors.Is(err, websocket.ErrAckTimeout) {
+		t.Fatalf("Expected ack timeout, got %v", err)
+	}
+	if receipt, _ := tracker.GetDeliveryReceipt(msg.MessageID); receipt.FailureReason != delivery.FailureTimeout {
+		t.Errorf("Expected unacked message to fail with a timeout, got %s", receipt.FailureReason)
+	}
+	if pending := client.PendingAcks(); pending != 0 {
+		t.Errorf("Expected no pending acks, got %d", pending)
+	}
+
+	// Messages still awaiting an ack fail when the connection closes
+	client.SetAckTimeout(5 * time.Second)
+	result := make(chan error, 1)
+	go func() {
+		_, err := send("bob#example.com", "drop")
+		result <- err
+	}()
+	for client.PendingAcks() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.Disconnect()
+	select {
+	case err := <-result:
+		if !errors.Is(err, websocket.ErrConnectionClosed) {
+			t.Errorf("Expected connection closed error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send did not return after disconnect")
+	}
+}
+
+func TestWebSocketTypingAndPresence(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	frames := make(chan websocket.WebSocketMessage, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Record event frames and relay presence back as if from a contact
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			frames <- frame
+			if frame.Event == "presence" {
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "event", Event: "presence", Data: json.RawMessage(`{"user":"bob#example.com","status":"away"}`)})
+			}
+		}
+	}))
+	defer server.Close()
+
+	notificationManager := notifications.NewNotificationManager(5)
+	defer notificationManager.Shutdown()
+	presence := make(chan map[string]any, 1)
+	notificationManager.RegisterHandler(notifications.EventPresence, func(n *notifications.Notification) error {
+		presence <- n.Metadata
+		return nil
+	})
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, notificationManager)
+	if err := client.SendTyping("alice#example.com", "team#example.com", true); err == nil {
+		t.Error("Expected error sending typing while not connected")
+	}
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	if err := client.SendTyping("alice#example.com", "team#example.com", true); err != nil {
+		t.Fatalf("Failed to send typing: %v", err)
+	}
+	if err := client.SendPresence("alice#example.com", "sleeping"); err == nil {
+		t.Error("Expected unknown presence status to be rejected")
+	}
+	if err := client.SendPresence("alice#example.com", websocket.PresenceBusy); err != nil {
+		t.Fatalf("Failed to send presence: %v", err)
+	}
+
+	var data map[string]any
+	for _, event := range []string{"typing", "presence"} {
+		select {
+		case frame := <-frames:
+			json.Unmarshal(frame.Data, &data)
+			if frame.Type != "event" || frame.Event != event || data["user"] != "alice#example.com" {
+				t.Errorf("Unexpected %s frame: %+v", event, frame)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s frame", event)
+		}
+	}
+	if data["status"] != "busy" {
+		t.Errorf("Expected busy status, got %v", data["status"])
+	}
+
+	select {
+	case metadata := <-presence:
+		if metadata["user"] != "bob#example.com" || metadata["status"] != "away" {
+			t.Errorf("Unexpected presence notification: %v", metadata)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for presence notification")
+	}
+}
+
+func TestWebSocketRemoveEventHandler(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Push a message for every frame the client sends
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: &message.Message{MessageID: frame.Event, From: "bob#example.com"}})
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	kept := make(chan string, 4)
+	removed := make(chan string, 4)
+	client.AddEventHandler(websocket.EventMessage, func(data interface{}) {
+		kept <- data.(*message.Message).MessageID
+	})
+	remove := client.AddEventHandler(websocket.EventMessage, func(data interface{}) {
+		removed <- data.(*message.Message).MessageID
+	})
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	expect := func(handler chan string, name string) {
+		t.Helper()
+		select {
+		case <-handler:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for the %s handler", name)
+		}
+	}
+
+	client.SendTyping("alice#example.com", "team#example.com", true)
+	expect(kept, "kept")
+	expect(removed, "removed")
+
+	// Removing twice is harmless and leaves the other handler in place
+	remove()
+	remove()
+	client.SendTyping("alice#example.com", "team#example.com", false)
+	expect(kept, "kept")
+	select {
+	case id := <-removed:
+		t.Errorf("Expected the removed handler not to run, got %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	frames := make(chan websocket.WebSocketMessage, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Push traffic from several groups regardless of subscriptions once subscribed
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			frames <- frame
+			if frame.Type == "subscribe" {
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: &message.Message{MessageID: "other", From: "bob#example.com", GroupID: "other#example.com"}})
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "event", Event: "typing", Data: json.RawMessage(`{"user":"bob#example.com","group_id":"team#example.com","is_typing":true}`)})
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: &message.Message{MessageID: "team", From: "bob#Example.com", GroupID: "team#example.com"}})
+			}
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	received := make(chan string, 4)
+	client.RegisterEventHandler(websocket.EventMessage, func(data interface{}) {
+		received <- data.(*message.Message).MessageID
+	})
+
+	if _, err := client.Subscribe(websocket.SubscriptionFilter{Events: []string{"reactions"}}); err == nil {
+		t.Error("Expected unknown subscription event to be rejected")
+	}
+	sub, err := client.Subscribe(websocket.SubscriptionFilter{GroupID: "team#example.com", Sender: "bob#example.com", Events: []string{"message"}})
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	// Subscriptions made before connecting are sent on connect
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	var data map[string]any
+	select {
+	case frame := <-frames:
+		json.Unmarshal(frame.Data, &data)
+		if frame.Type != "subscribe" || data["id"] != sub.ID || data["group_id"] != "team#example.com" {
+			t.Errorf("Unexpected subscribe frame: %+v", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for subscribe frame")
+	}
+
+	select {
+	case id := <-received:
+		if id != "team" {
+			t.Errorf("Expected only the subscribed group's message, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for subscribed message")
+	}
+	select {
+	case id := <-received:
+		t.Errorf("Unexpected unsubscribed message %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := client.Unsubscribe(sub.ID); err != nil {
+		t.Fatalf("Failed to unsubscribe: %v", err)
+	}
+	if len(client.Subscriptions()) != 0 {
+		t.Error("Expected no subscriptions after unsubscribing")
+	}
+	if err := client.Unsubscribe(sub.ID); err == nil {
+		t.Error("Expected error unsubscribing twice")
+	}
+	select {
+	case frame := <-frames:
+		if frame.Type != "unsubscribe" {
+			t.Errorf("Expected unsubscribe frame, got %+v", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for unsubscribe frame")
+	}
+}
+
+func TestWebSocketStreams(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	frames := make(chan websocket.WebSocketMessage, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var frame websocket.WebSocketMessage
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			frames <- frame
+			if frame.Type != "stream_open" {
+				continue
+			}
+
+			// Each stream authenticates on its own; carol's key is not accepted
+			var open struct {
+				Address       string `json:"address"`
+				Authorization string `json:"authorization"`
+				Window        int    `json:"window"`
+			}
+			json.Unmarshal(frame.Data, &open)
+			header, err := auth.ParseAuthHeader(open.Authorization)
+			if err != nil || auth.VerifyAuthHeader(header, "OPEN", "/api/v1/ws/streams/"+frame.Stream) != nil || open.Address != "bob#example.com" {
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "stream_close", Stream: frame.Stream, Data: json.RawMessage(`{"reason":"unauthorized"}`)})
+				continue
+			}
+			conn.WriteJSON(&websocket.WebSocketMessage{Type: "stream_window", Stream: frame.Stream, Data: json.RawMessage(`{"credit":2}`)})
+			conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Message: &message.Message{MessageID: "connection"}})
+			for i := 0; i < open.Window; i++ {
+				conn.WriteJSON(&websocket.WebSocketMessage{Type: "message", Stream: frame.Stream, Message: &message.Message{MessageID: "bob"}})
+			}
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	client := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	received := make(chan string, 4)
+	client.RegisterEventHandler(websocket.EventMessage, func(data interface{}) {
+		received <- data.(*message.Message).MessageID
+	})
+
+	if _, err := client.OpenStream(websocket.StreamOptions{Address: "bob", KeyPair: keyPair}); err == nil {
+		t.Error("Expected invalid stream address to be rejected")
+	}
+	bob, err := client.OpenStream(websocket.StreamOptions{Address: "bob#example.com", KeyPair: keyPair, Window: 2})
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	carol, err := client.OpenStream(websocket.StreamOptions{Address: "carol#example.com", KeyPair: keyPair})
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if len(client.Streams()) != 2 {
+		t.Errorf("Expected 2 streams, got %d", len(client.Streams()))
+	}
+
+	// Streams opened before connecting are opened on connect
+	if err := client.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		frame, err := bob.Receive(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive stream frame: %v", err)
+		}
+		if frame.Message == nil || frame.Message.MessageID != "bob" {
+			t.Errorf("Unexpected stream frame: %+v", frame)
+		}
+	}
+	select {
+	case id := <-received:
+		if id != "connection" {
+			t.Errorf("Expected only the connection's own message, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for connection message")
+	}
+
+	if _, err := carol.Receive(ctx); !errors.Is(err, websocket.ErrStreamClosed) {
+		t.Errorf("Expected rejected stream to be closed, got %v", err)
+	}
+	if len(client.Streams()) != 1 {
+		t.Errorf("Expected the rejected stream to be removed, got %d streams", len(client.Streams()))
+	}
+
+	// Sends stop once the server's credit runs out
+	for i := 0; i < 2; i++ {
+		if err := bob.SendCustomEventContext(ctx, "bot.ping", map[string]int{"n": i}); err != nil {
+			t.Fatalf("Failed to send on stream: %v", err)
+		}
+	}
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if err := bob.SendCustomEventContext(short, "bot.ping", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected send without credit to wait, got %v", err)
+	}
+
+	if err := bob.Close(); err != nil {
+		t.Fatalf("Failed to close stream: %v", err)
+	}
+	if err := bob.Close(); !errors.Is(err, websocket.ErrStreamClosed) {
+		t.Errorf("Expected closing twice to fail, got %v", err)
+	}
+
+	seen := map[string]int{}
+	timeout := time.After(2 * time.Second)
+	for seen["stream_close"] == 0 {
+		select {
+		case frame := <-frames:
+			if frame.Stream == "" {
+				continue
+			}
+			seen[frame.Type]++
+			if frame.Stream != bob.ID && frame.Type != "stream_open" {
+				t.Errorf("Unexpected frame for stream %s: %+v", frame.Stream, frame)
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for stream frames, saw %v", seen)
+		}
+	}
+	if seen["stream_open"] != 2 || seen["stream_window"] != 2 || seen["custom"] != 2 {
+		t.Errorf("Expected 2 opens, 2 window grants and 2 events, saw %v", seen)
+	}
+}
+
+func TestWebSocketFrameCompressionAndEncryption(t *testing.T) {
+	upgrader := gorillaws.Upgrader{}
+	serverIdentity, _ := keymgmt.GenerateKeyPair()
+	var binaryFrames atomic.Int32
+	var frameChecks sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers, codec, err := websocket.AcceptFrameOptions(r.Header, []websocket.FrameCompressor{websocket.DeflateCompressor{}}, serverIdentity, 1<<20)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, headers)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Echo custom events back through the negotiated codec
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType == gorillaws.BinaryMessage {
+				binaryFrames.Add(1)
+			}
+			received := data
+			data, err = codec.Decode(messageType, data)
+			if err != nil {
+				t.Errorf("Server failed to decode frame: %v", err)
+				return
+			}
+			frameChecks.Do(func() {
+				// A replayed frame, or one reflected back to its sender, is rejected
+				if _, err := codec.Decode(messageType, received); !errors.Is(err, websocket.ErrFrameReplayed) {
+					t.Errorf("Expected a replayed frame to be rejected, got %v", err)
+				}
+				reflectedType, reflected, _ := codec.Encode([]byte(`{"type":"ping"}`))
+				if _, err := codec.Decode(reflectedType, reflected); err == nil {
+					t.Error("Expected a frame reflected back to its sender to be rejected")
+				}
+			})
+			var frame websocket.WebSocketMessage
+			if err := json.Unmarshal(data, &frame); err != nil || frame.Type != "custom" {
+				continue
+			}
+			frame.From = "bob#example.com"
+			data, _ = json.Marshal(&frame)
+			messageType, data, _ = codec.Encode(data)
+			conn.WriteMessage(messageType, data)
+		}
+	}))
+	defer server.Close()
+
+	keyPair, _ := keymgmt.GenerateKeyPair()
+	wsClient := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	wsClient.SetFrameOptions(&websocket.FrameOptions{
+		Compressors:       []websocket.FrameCompressor{websocket.DeflateCompressor{}},
+		Encrypt:           true,
+		ServerKey:         serverIdentity.PublicKey,
+		RequireEncryption: true,
+	})
+
+	received := make(chan string, 1)
+	err := websocket.HandleCustomEvent(wsClient, "app.image", func(payload string, event *websocket.CustomEvent) {
+		received <- payload
+	})
+	if err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+
+	if err := wsClient.Connect("alice#example.com"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer wsClient.Disconnect()
+
+	codec := wsClient.FrameCodec()
+	if codec.Compression() != "deflate" || !codec.Encrypted() {
+		t.Fatalf("Expected deflate and encryption to be negotiated, got %q, %v", codec.Compression(), codec.Encrypted())
+	}
+
+	image := string(make([]byte, 8192))
+	if err := wsClient.SendCustomEvent("app.image", image); err != nil {
+		t.Fatalf("Failed to send custom event: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != image {
+			t.Errorf("Expected the payload to survive the round trip, got %d bytes", len(got))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for custom event")
+	}
+	if binaryFrames.Load() == 0 {
+		t.Error("Expected frames to be sent encoded")
+	}
+
+	// Servers that do not negotiate fail a client requiring encryption
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	defer plain.Close()
+
+	strict := websocket.NewWebSocketClient(plain.URL, keyPair, nil)
+	strict.SetFrameOptions(&websocket.FrameOptions{Encrypt: true, ServerKey: serverIdentity.PublicKey, RequireEncryption: true})
+	if err := strict.Connect("alice#example.com"); err == nil {
+		strict.Disconnect()
+		t.Error("Expected connecting without encryption to fail")
+	}
+
+	// A session key not signed by the expected server identity is refused
+	impostor, _ := keymgmt.GenerateKeyPair()
+	misled := websocket.NewWebSocketClient(server.URL, keyPair, nil)
+	misled.SetFrameOptions(&websocket.FrameOptions{Encrypt: true, ServerKey: impostor.PublicKey})
+	if err := misled.Connect("alice#example.com"); err == nil {
+		misled.Disconnect()
+		t.Error("Expected a session key signed by another server to be refused")
+	}
+
+	// The server refuses a client session key swapped in after signing
+	request := http.Header{}
+	authHeader, _ := auth.GenerateAuthHeader(keyPair, "GET", "/api/v1/ws")
+	request.Set("Authorization", authHeader.ToHeaderValue())
+	request.Set(websocket.HeaderSessionKey, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	request.Set(websocket.HeaderSessionSignature, base64.StdEncoding.EncodeToString(impostor.Sign([]byte("session"))))
+	if _, _, err := websocket.AcceptFrameOptions(request, nil, serverIdentity, 1<<20); err == nil {
+		t.Error("Expected an unsigned client session key to be refused")
+	}
+}
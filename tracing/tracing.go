@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+)
+
+// Tracer starts spans around the SDK's operations. It is small enough to adapt
+// OpenTelemetry's trace.Tracer, or any other tracing system, in a few lines.
+// The context Start returns carries the span: the client passes it to hooks,
+// middleware and nested operations, so their spans and outgoing requests join
+// the caller's trace.
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation in progress. End is called exactly once, and
+// RecordError only with errors that failed the operation.
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute describes a span, e.g. the domain a message is sent to
+type Attribute struct {
+	Key   string
+	Value any // string, int64 or bool
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Spans started by the client
+const (
+	SpanSendMessage      = "emsg.send_message"      // Sending a message to all its recipient domains
+	SpanResolveDomain    = "emsg.resolve_domain"    // Finding a domain's EMSG server
+	SpanUploadAttachment = "emsg.upload_attachment" // Uploading an attachment to a domain's server
+	SpanConnectWebSocket = "emsg.connect_websocket" // Resolving and connecting the WebSocket
+)
+
+// Attributes set by the client
+const (
+	AttrMessageID      = "emsg.message_id"
+	AttrRecipients     = "emsg.recipients"
+	AttrDomain         = "emsg.domain"
+	AttrServerURL      = "emsg.server_url"
+	AttrAttachmentID   = "emsg.attachment_id"
+	AttrAttachmentSize = "emsg.attachment_size"
+	AttrAddress        = "emsg.address"
+)
+
+// Start starts a span with tracer, or returns ctx and a span that does nothing if tracer is nil
+func Start(tracer Tracer, ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return tracer.Start(ctx, name, attributes...)
+}
+
+// End records err on span if it is not nil and ends it
+func End(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// nopSpan is the span of operations that are not traced
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Address is a parsed and validated EMSG address. Domains are case-insensitive and
+// kept in lowercase, so equal addresses compare equal with ==. The zero value is the
+// empty address, used for optional fields.
+type Address struct {
+	user   string
+	domain string
+}
+
+// ParseAddress parses and validates an address in the format user#domain.com
+func ParseAddress(address string) (Address, error) {
+	parsed, err := ParseEMSGAddress(address)
+	if err != nil {
+		return Address{}, err
+	}
+	return parsed.Address(), nil
+}
+
+// MustParseAddress parses an address known to be valid, panicking if it is not
+func MustParseAddress(address string) Address {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+// ParseAddresses parses a list of addresses, failing on the first invalid one
+func ParseAddresses(addresses []string) ([]Address, error) {
+	result := make([]Address, len(addresses))
+	for i, address := range addresses {
+		addr, err := ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address at index %d: %w", i, err)
+		}
+		result[i] = addr
+	}
+	return result, nil
+}
+
+// AddressStrings converts addresses to their string form, for APIs and wire fields
+// that still take strings
+func AddressStrings(addresses []Address) []string {
+	result := make([]string, len(addresses))
+	for i, addr := range addresses {
+		result[i] = addr.String()
+	}
+	return result
+}
+
+// Address converts a parsed EMSGAddress to an Address
+func (addr *EMSGAddress) Address() Address {
+	return Address{user: addr.User, domain: strings.ToLower(addr.Domain)}
+}
+
+// User returns the user part of the address
+func (a Address) User() string {
+	return a.user
+}
+
+// Domain returns the lowercase domain part of the address
+func (a Address) Domain() string {
+	return a.domain
+}
+
+// IsZero returns true for the empty address
+func (a Address) IsZero() bool {
+	return a.user == "" && a.domain == ""
+}
+
+// String returns the address as user#domain, or "" for the empty address
+func (a Address) String() string {
+	if a.IsZero() {
+		return ""
+	}
+	return a.user + "#" + a.domain
+}
+
+// DNSName returns the DNS name for EMSG TXT record lookup
+func (a Address) DNSName() string {
+	return fmt.Sprintf("_emsg.%s", a.domain)
+}
+
+// MarshalText encodes the address as user#domain, so it marshals as a JSON string
+// and can be used as a JSON object key
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText parses and validates an encoded address. Empty text decodes to
+// the empty address.
+func (a *Address) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*a = Address{}
+		return nil
+	}
+	addr, err := ParseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = addr
+	return nil
+}
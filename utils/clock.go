@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so that background loops can be driven deterministically in tests
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker abstracts time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is a Clock backed by the time package
+type RealClock struct{}
+
+// Now returns the current time
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a ticker backed by time.NewTicker
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (rt *realTicker) C() <-chan time.Time {
+	return rt.ticker.C
+}
+
+func (rt *realTicker) Stop() {
+	rt.ticker.Stop()
+}
+
+// FakeClock is a manually advanced Clock for tests
+type FakeClock struct {
+	now     time.Time
+	tickers []*fakeTicker
+	mutex   sync.Mutex
+}
+
+// NewFakeClock creates a fake clock starting at the given time
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the fake clock's current time
+func (fc *FakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+// NewTicker returns a ticker that fires when the clock is advanced past its period
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	ticker := &fakeTicker{
+		clock:  fc,
+		period: d,
+		next:   fc.now.Add(d),
+		ch:     make(chan time.Time, 1),
+	}
+	fc.tickers = append(fc.tickers, ticker)
+	return ticker
+}
+
+// Advance moves the clock forward and fires any tickers that became due.
+// Like time.Ticker, ticks are dropped if the receiver is not keeping up.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.now = fc.now.Add(d)
+	for _, ticker := range fc.tickers {
+		if ticker.stopped {
+			continue
+		}
+		for !ticker.next.After(fc.now) {
+			select {
+			case ticker.ch <- ticker.next:
+			default:
+			}
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock   *FakeClock
+	period  time.Duration
+	next    time.Time
+	ch      chan time.Time
+	stopped bool
+}
+
+func (ft *fakeTicker) C() <-chan time.Time {
+	return ft.ch
+}
+
+func (ft *fakeTicker) Stop() {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+	ft.stopped = true
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Entropy returns r, or crypto/rand.Reader if r is nil. Constructors taking an
+// entropy source use it so tests can inject a deterministic one.
+func Entropy(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
+
+// DeterministicEntropy is a reproducible stream of pseudo-random bytes derived
+// from a seed, for fixtures and simulations. Anyone knowing the seed can
+// predict every key and nonce drawn from it; never use it outside tests.
+type DeterministicEntropy struct {
+	seed    [32]byte
+	counter uint64
+	block   []byte
+	mutex   sync.Mutex
+}
+
+// NewDeterministicEntropy creates an entropy source that yields the same bytes for the same seed
+func NewDeterministicEntropy(seed string) *DeterministicEntropy {
+	return &DeterministicEntropy{seed: sha256.Sum256([]byte(seed))}
+}
+
+// Read fills p with the next bytes of the stream. It never fails.
+func (de *DeterministicEntropy) Read(p []byte) (int, error) {
+	de.mutex.Lock()
+	defer de.mutex.Unlock()
+
+	for n := 0; n < len(p); {
+		if len(de.block) == 0 {
+			var input [40]byte
+			copy(input[:], de.seed[:])
+			binary.BigEndian.PutUint64(input[32:], de.counter)
+			de.counter++
+			block := sha256.Sum256(input[:])
+			de.block = block[:]
+		}
+		copied := copy(p[n:], de.block)
+		de.block = de.block[copied:]
+		n += copied
+	}
+	return len(p), nil
+}
+
+// IDGenerator generates unique identifiers, e.g. for messages and attachments
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a function to IDGenerator
+type IDGeneratorFunc func() string
+
+// NewID returns f()
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// NewRandomIDGenerator returns a generator of URL-safe IDs of 16 bytes read from
+// entropy (nil = crypto/rand). Like crypto/rand, it panics if entropy fails.
+func NewRandomIDGenerator(entropy io.Reader) IDGenerator {
+	entropy = Entropy(entropy)
+	return IDGeneratorFunc(func() string {
+		id := make([]byte, 16)
+		if _, err := io.ReadFull(entropy, id); err != nil {
+			panic(fmt.Sprintf("failed to generate ID: %v", err))
+		}
+		return base64.RawURLEncoding.EncodeToString(id)
+	})
+}
+
+// NewSequentialIDGenerator returns a generator of the IDs prefix-1, prefix-2 and so on, for fixtures
+func NewSequentialIDGenerator(prefix string) IDGenerator {
+	var next atomic.Uint64
+	return IDGeneratorFunc(func() string {
+		return fmt.Sprintf("%s-%d", prefix, next.Add(1))
+	})
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is returned to waiters of a call whose function panicked
+var errFlightPanicked = errors.New("coalesced call panicked")
+
+// FlightGroup coalesces concurrent calls for the same key into one, so a burst
+// of lookups for the same address costs a single network request. The zero
+// value is ready to use.
+type FlightGroup[T any] struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// flightCall is a call in progress or completed
+type flightCall[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	callers int
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which case it
+// waits for that call and returns its result. shared is true if the result was
+// delivered to more than one caller.
+func (g *FlightGroup[T]) Do(key string, fn func() (T, error)) (val T, err error, shared bool) {
+	call, leader := g.join(key)
+	if leader {
+		g.run(key, call, fn)
+	} else {
+		<-call.done
+	}
+	return call.val, call.err, g.shared(call)
+}
+
+// DoContext is like Do but stops waiting when ctx is done. fn gets a context with
+// ctx's values that is not cancelled with it, so one caller giving up does not
+// fail the call for the others.
+func (g *FlightGroup[T]) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (val T, err error, shared bool) {
+	call, leader := g.join(key)
+	if leader {
+		detached := context.WithoutCancel(ctx)
+		go g.run(key, call, func() (T, error) { return fn(detached) })
+	}
+
+	select {
+	case <-call.done:
+		return call.val, call.err, g.shared(call)
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err(), false
+	}
+}
+
+// InFlight returns the number of keys with a call in progress
+func (g *FlightGroup[T]) InFlight() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.calls)
+}
+
+// join registers a caller for key and reports whether it must run the call
+func (g *FlightGroup[T]) join(key string) (*flightCall[T], bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		call.callers++
+		return call, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	call := &flightCall[T]{done: make(chan struct{}), callers: 1}
+	g.calls[key] = call
+	return call, true
+}
+
+// run executes the call and releases its waiters. A panic in fn is re-raised
+// after waiters are released with an error.
+func (g *FlightGroup[T]) run(key string, call *flightCall[T], fn func() (T, error)) {
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(call.done)
+	}()
+
+	call.err = errFlightPanicked
+	call.val, call.err = fn()
+}
+
+// shared reports whether a completed call had more than one caller
+func (g *FlightGroup[T]) shared(call *flightCall[T]) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return call.callers > 1
+}
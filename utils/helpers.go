@@ -7,6 +7,11 @@ import (
 	"strings"
 )
 
+var (
+	userRegex   = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	domainRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+)
+
 // EMSGAddress represents a parsed EMSG address
 type EMSGAddress struct {
 	User   string
@@ -40,7 +45,6 @@ func ParseEMSGAddress(address string) (*EMSGAddress, error) {
 	}
 
 	// Validate user format (alphanumeric, dots, hyphens, underscores)
-	userRegex := regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
 	if !userRegex.MatchString(user) {
 		return nil, fmt.Errorf("invalid user format: %s", user)
 	}
@@ -78,7 +82,6 @@ func IsValidDomain(domain string) bool {
 	}
 
 	// Basic domain validation
-	domainRegex := regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
 	if !domainRegex.MatchString(domain) {
 		return false
 	}
@@ -174,3 +177,25 @@ func ParseEMSGAddressList(addresses []string) ([]*EMSGAddress, error) {
 	}
 	return result, nil
 }
+
+// MatchDomainPattern reports whether a domain matches a pattern. Patterns may be
+// an exact domain ("example.com"), a wildcard suffix ("*.example.com", which
+// matches subdomains but not the apex) or "*" to match any domain.
+func MatchDomainPattern(pattern, domain string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	if pattern == "" || domain == "" {
+		return false
+	}
+
+	if pattern == "*" {
+		return true
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+
+	return pattern == domain
+}
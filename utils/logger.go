@@ -0,0 +1,33 @@
+package utils
+
+// Logger receives diagnostic output from the SDK. Arguments after the message
+// are alternating key/value pairs, so a *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NopLogger is a Logger that discards everything
+type NopLogger struct{}
+
+// Debug discards the message
+func (NopLogger) Debug(msg string, args ...any) {}
+
+// Info discards the message
+func (NopLogger) Info(msg string, args ...any) {}
+
+// Warn discards the message
+func (NopLogger) Warn(msg string, args ...any) {}
+
+// Error discards the message
+func (NopLogger) Error(msg string, args ...any) {}
+
+// LoggerOrNop returns logger, or a NopLogger if logger is nil
+func LoggerOrNop(logger Logger) Logger {
+	if logger == nil {
+		return NopLogger{}
+	}
+	return logger
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// Subsystems reported in PanicReport.Subsystem
+const (
+	PanicSubsystemDeliveryCallback       = "delivery_callback"
+	PanicSubsystemNotificationHandler    = "notification_handler"
+	PanicSubsystemNotificationBatch      = "notification_batch_handler"
+	PanicSubsystemWebSocketEventHandler  = "websocket_event_handler"
+	PanicSubsystemWebSocketCustomHandler = "websocket_custom_handler"
+	PanicSubsystemMessagePoller          = "message_poller"
+)
+
+// PanicReport describes a panic the SDK recovered from in application code
+type PanicReport struct {
+	Subsystem string    // Which kind of handler panicked, one of the PanicSubsystem constants
+	Value     any       // The value passed to panic
+	Stack     []byte    // Stack trace of the panicking goroutine
+	Time      time.Time // When the panic was recovered
+}
+
+// PanicHandler receives recovered panics, e.g. to forward them to a crash
+// reporting service. It is called on the goroutine that panicked.
+type PanicHandler func(report *PanicReport)
+
+// ReportPanic passes a recovered panic to handler. It must be called from the
+// deferred function that recovered so the stack trace includes the panic site.
+// A nil handler does nothing, and a handler that panics itself is ignored.
+func ReportPanic(handler PanicHandler, subsystem string, value any) {
+	if handler == nil {
+		return
+	}
+
+	report := &PanicReport{
+		Subsystem: subsystem,
+		Value:     value,
+		Stack:     debug.Stack(),
+		Time:      time.Now(),
+	}
+
+	defer func() {
+		recover()
+	}()
+	handler(report)
+}
@@ -0,0 +1,11 @@
+package utils
+
+import "runtime"
+
+// Wipe overwrites b with zeros so sensitive data such as decrypted plaintext or
+// key material does not linger in memory after use. Strings cannot be wiped;
+// keep secrets in byte slices where possible.
+func Wipe(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,288 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+)
+
+// DefaultAckTimeout is how long SendMessage waits for the server's ack by default
+const DefaultAckTimeout = 10 * time.Second
+
+var (
+	// ErrAckTimeout is returned when the server does not ack a message in time
+	ErrAckTimeout = errors.New("timed out waiting for server ack")
+	// ErrMessageRejected is matched by errors for messages the server refused
+	ErrMessageRejected = errors.New("message rejected by server")
+	// ErrConnectionClosed is returned for messages still awaiting an ack when the connection closes
+	ErrConnectionClosed = errors.New("connection closed before server ack")
+)
+
+// pendingAck is a sent message awaiting the server's ack
+type pendingAck struct {
+	messageID string
+	sentAt    time.Time
+	result    chan error // Receives nil on ack, or why the message was not accepted
+}
+
+// ackFrame is the data of an ack frame. Servers echo the correlation ID of the
+// message frame; a non-empty error rejects the message, with an HTTP-style code
+// classifying why.
+type ackFrame struct {
+	MessageID     string `json:"message_id"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Code          int    `json:"code,omitempty"`
+}
+
+// SetAckTimeout sets how long SendMessage waits for the server's ack. A timeout
+// of 0 sends without waiting; acks are then only reported through EventAck.
+func (ws *WebSocketClient) SetAckTimeout(timeout time.Duration) {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+	ws.ackTimeout = timeout
+}
+
+// SetDeliveryTracker sets the tracker that records acked messages as sent and
+// messages the server rejected or never acked as failed (nil disables tracking)
+func (ws *WebSocketClient) SetDeliveryTracker(tracker *delivery.DeliveryTracker) {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+	ws.deliveryTracker = tracker
+}
+
+// SendMessage sends a message over the WebSocket and waits for the server's ack.
+// See SendMessageContext.
+func (ws *WebSocketClient) SendMessage(msg *message.Message) error {
+	return ws.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessageContext sends a message over the WebSocket and waits up to the ack
+// timeout for the server to ack it. The frame carries a correlation ID that the
+// ack echoes. A rejection, a missing ack or a connection closing first fails the
+// message in the delivery tracker; cancelling ctx stops waiting without doing so,
+// since the server may still have received the message.
+func (ws *WebSocketClient) SendMessageContext(ctx context.Context, msg *message.Message) error {
+	if !ws.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return err
+	}
+
+	wsMsg := &WebSocketMessage{
+		Type:          "message",
+		Message:       msg,
+		CorrelationID: correlationID,
+		Timestamp:     time.Now().Unix(),
+	}
+
+	data, err := json.Marshal(wsMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	pending := &pendingAck{messageID: msg.MessageID, sentAt: time.Now(), result: make(chan error, 1)}
+	ws.ackMutex.Lock()
+	ws.pendingAcks[correlationID] = pending
+	timeout := ws.ackTimeout
+	ws.ackMutex.Unlock()
+
+	if err := ws.enqueue(data); err != nil {
+		ws.removePendingAck(correlationID)
+		return err
+	}
+	if timeout <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-pending.result:
+		return err
+	case <-timer.C:
+		if ws.removePendingAck(correlationID) == nil {
+			// The ack arrived as the timer fired
+			return <-pending.result
+		}
+		err := fmt.Errorf("message %s: %w after %v", msg.MessageID, ErrAckTimeout, timeout)
+		ws.recordAckFailure(msg.MessageID, delivery.FailureTimeout, err)
+		return err
+	case <-ctx.Done():
+		ws.removePendingAck(correlationID)
+		return ctx.Err()
+	}
+}
+
+// PendingAcks returns the number of sent messages awaiting the server's ack
+func (ws *WebSocketClient) PendingAcks() int {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+	return len(ws.pendingAcks)
+}
+
+// processAck resolves the pending ack for a sent message, reporting its latency
+// on success. Acks from servers that do not echo correlation IDs are matched by
+// message ID.
+func (ws *WebSocketClient) processAck(wsMsg *WebSocketMessage) {
+	var ack ackFrame
+	if len(wsMsg.Data) > 0 {
+		if err := json.Unmarshal(wsMsg.Data, &ack); err != nil {
+			ws.logger.Warn("invalid WebSocket ack", "data", string(wsMsg.Data))
+			return
+		}
+	}
+	if ack.CorrelationID == "" {
+		ack.CorrelationID = wsMsg.CorrelationID
+	}
+	if ack.CorrelationID == "" && ack.MessageID == "" {
+		ws.logger.Warn("invalid WebSocket ack", "data", string(wsMsg.Data))
+		return
+	}
+
+	pending := ws.takePendingAck(ack.CorrelationID, ack.MessageID)
+	if pending == nil {
+		return
+	}
+
+	if ack.Error != "" {
+		reason := delivery.FailureUnknown
+		if ack.Code != 0 {
+			reason = delivery.ClassifyHTTPStatus(ack.Code)
+		}
+		err := fmt.Errorf("message %s: %w: %s", pending.messageID, ErrMessageRejected, ack.Error)
+		ws.recordAckFailure(pending.messageID, reason, err)
+		pending.result <- err
+		return
+	}
+
+	if tracker := ws.getDeliveryTracker(); tracker != nil && pending.messageID != "" {
+		tracker.UpdateDeliveryStatus(pending.messageID, delivery.StatusSent, "")
+	}
+	pending.result <- nil
+
+	ws.triggerEvent(EventAck, &AckInfo{
+		MessageID:     pending.messageID,
+		CorrelationID: ack.CorrelationID,
+		Latency:       time.Since(pending.sentAt),
+	})
+}
+
+// takePendingAck removes and returns the pending ack with the correlation ID, or
+// failing that the one for the message ID
+func (ws *WebSocketClient) takePendingAck(correlationID, messageID string) *pendingAck {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+
+	if pending, ok := ws.pendingAcks[correlationID]; ok {
+		delete(ws.pendingAcks, correlationID)
+		return pending
+	}
+	if messageID == "" {
+		return nil
+	}
+	for id, pending := range ws.pendingAcks {
+		if pending.messageID == messageID {
+			delete(ws.pendingAcks, id)
+			return pending
+		}
+	}
+	return nil
+}
+
+// removePendingAck stops waiting for an ack, returning the pending ack or nil if
+// it was already resolved
+func (ws *WebSocketClient) removePendingAck(correlationID string) *pendingAck {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+
+	pending, ok := ws.pendingAcks[correlationID]
+	if !ok {
+		return nil
+	}
+	delete(ws.pendingAcks, correlationID)
+	return pending
+}
+
+// failPendingAcks fails every message still awaiting an ack when a connection closes
+func (ws *WebSocketClient) failPendingAcks(cause error) {
+	ws.ackMutex.Lock()
+	pending := ws.pendingAcks
+	ws.pendingAcks = make(map[string]*pendingAck)
+	ws.ackMutex.Unlock()
+
+	for _, p := range pending {
+		err := fmt.Errorf("message %s: %w", p.messageID, ErrConnectionClosed)
+		if cause != nil {
+			err = fmt.Errorf("message %s: %w: %v", p.messageID, ErrConnectionClosed, cause)
+		}
+		ws.recordAckFailure(p.messageID, delivery.ClassifyError(cause), err)
+		p.result <- err
+	}
+}
+
+// recordAckFailure marks a message the server did not accept as failed
+func (ws *WebSocketClient) recordAckFailure(messageID string, reason delivery.FailureReason, err error) {
+	ws.logger.Warn("WebSocket message not acknowledged", "message_id", messageID, "error", err)
+
+	tracker := ws.getDeliveryTracker()
+	if tracker == nil || messageID == "" {
+		return
+	}
+	if reason == "" {
+		reason = delivery.FailureUnknown
+	}
+	tracker.UpdateDeliveryFailure(messageID, delivery.StatusFailed, reason, err.Error())
+}
+
+// recordRecipientReceipt records a recipient's delivery receipt in the delivery tracker
+func (ws *WebSocketClient) recordRecipientReceipt(wsMsg *WebSocketMessage) {
+	tracker := ws.getDeliveryTracker()
+	if tracker == nil {
+		return
+	}
+
+	var receipt struct {
+		MessageID string `json:"message_id"`
+		Recipient string `json:"recipient"`
+		Delivered *bool  `json:"delivered"`
+	}
+	if err := json.Unmarshal(wsMsg.Data, &receipt); err != nil || receipt.MessageID == "" || receipt.Recipient == "" || receipt.Delivered == nil {
+		return
+	}
+
+	status, errorMsg := delivery.StatusDelivered, ""
+	if !*receipt.Delivered {
+		status, errorMsg = delivery.StatusFailed, "recipient server reported the message undelivered"
+	}
+	if err := tracker.UpdateRecipientStatus(receipt.MessageID, receipt.Recipient, status, errorMsg); err != nil {
+		ws.logger.Debug("ignoring delivery receipt for untracked recipient", "message_id", receipt.MessageID, "recipient", receipt.Recipient)
+	}
+}
+
+// getDeliveryTracker returns the delivery tracker, or nil
+func (ws *WebSocketClient) getDeliveryTracker() *delivery.DeliveryTracker {
+	ws.ackMutex.Lock()
+	defer ws.ackMutex.Unlock()
+	return ws.deliveryTracker
+}
+
+// newCorrelationID returns a random correlation ID for a message frame
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
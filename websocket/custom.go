@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// EventCustom is triggered for every custom event frame received, in addition to
+// any handlers registered for the event's type
+const EventCustom WebSocketEvent = "custom"
+
+// customEventPattern matches namespaced event types such as "editor.cursor" or "docs.presence.update"
+var customEventPattern = regexp.MustCompile(`^[a-z0-9_-]+(\.[a-z0-9_-]+)+$`)
+
+// reservedNamespaces cannot be used for custom events
+var reservedNamespaces = map[string]bool{
+	"emsg":   true,
+	"system": true,
+}
+
+// CustomEvent is an application-defined event frame carried over the WebSocket
+type CustomEvent struct {
+	Type      string          // Namespaced event type, e.g. "editor.cursor"
+	Data      json.RawMessage // JSON payload
+	From      string          // Sender address, if the server supplied one
+	Timestamp int64
+}
+
+// Decode unmarshals the event payload into v
+func (e *CustomEvent) Decode(v any) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// CustomEventHandler handles a received custom event
+type CustomEventHandler func(event *CustomEvent)
+
+// ValidateCustomEventType checks that an event type is namespaced and not reserved
+func ValidateCustomEventType(eventType string) error {
+	if !customEventPattern.MatchString(eventType) {
+		return fmt.Errorf("invalid custom event type %q: must be namespaced like \"app.event\"", eventType)
+	}
+	namespace := strings.SplitN(eventType, ".", 2)[0]
+	if reservedNamespaces[namespace] {
+		return fmt.Errorf("custom event namespace %q is reserved", namespace)
+	}
+	return nil
+}
+
+// SendCustomEvent sends an application event with a JSON payload over the established connection
+func (ws *WebSocketClient) SendCustomEvent(eventType string, payload any) error {
+	if err := ValidateCustomEventType(eventType); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+
+	frame, err := json.Marshal(&WebSocketMessage{
+		Type:      "custom",
+		Event:     eventType,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal custom event: %w", err)
+	}
+
+	if int64(len(frame)) > ws.maxMessageSize {
+		return fmt.Errorf("custom event %s is %d bytes, exceeds limit of %d", eventType, len(frame), ws.maxMessageSize)
+	}
+
+	return ws.enqueue(frame)
+}
+
+// RegisterCustomEventHandler registers a handler for a custom event type. A pattern
+// ending in ".*" matches every event in that namespace, e.g. "editor.*".
+// Handlers run in order of arrival on the receive goroutine and should not block.
+func (ws *WebSocketClient) RegisterCustomEventHandler(pattern string, handler CustomEventHandler) error {
+	if err := validateCustomEventPattern(pattern); err != nil {
+		return err
+	}
+
+	ws.eventMutex.Lock()
+	defer ws.eventMutex.Unlock()
+
+	ws.customHandlers[pattern] = append(ws.customHandlers[pattern], handler)
+	return nil
+}
+
+// UnregisterCustomEventHandlers removes all handlers registered for a pattern
+func (ws *WebSocketClient) UnregisterCustomEventHandlers(pattern string) {
+	ws.eventMutex.Lock()
+	defer ws.eventMutex.Unlock()
+
+	delete(ws.customHandlers, pattern)
+}
+
+// HandleCustomEvent registers a handler that receives the event payload decoded as T.
+// Frames whose payload does not decode into T are logged and skipped.
+func HandleCustomEvent[T any](ws *WebSocketClient, pattern string, handler func(payload T, event *CustomEvent)) error {
+	return ws.RegisterCustomEventHandler(pattern, func(event *CustomEvent) {
+		var payload T
+		if err := event.Decode(&payload); err != nil {
+			ws.logger.Warn("skipping custom event", "event", event.Type, "error", err)
+			return
+		}
+		handler(payload, event)
+	})
+}
+
+// validateCustomEventPattern accepts an event type or a "namespace.*" wildcard
+func validateCustomEventPattern(pattern string) error {
+	if prefix, ok := strings.CutSuffix(pattern, ".*"); ok {
+		if reservedNamespaces[strings.SplitN(prefix, ".", 2)[0]] {
+			return fmt.Errorf("custom event namespace %q is reserved", prefix)
+		}
+		return ValidateCustomEventType(prefix + ".x")
+	}
+	return ValidateCustomEventType(pattern)
+}
+
+// processCustomEvent dispatches a received custom event frame to matching handlers
+func (ws *WebSocketClient) processCustomEvent(wsMsg *WebSocketMessage) {
+	if err := ValidateCustomEventType(wsMsg.Event); err != nil {
+		ws.logger.Debug("ignoring custom event", "event", wsMsg.Event, "error", err)
+		return
+	}
+
+	event := &CustomEvent{
+		Type:      wsMsg.Event,
+		Data:      wsMsg.Data,
+		From:      wsMsg.From,
+		Timestamp: wsMsg.Timestamp,
+	}
+
+	ws.eventMutex.RLock()
+	var handlers []CustomEventHandler
+	for pattern, registered := range ws.customHandlers {
+		if matchCustomEvent(pattern, event.Type) {
+			handlers = append(handlers, registered...)
+		}
+	}
+	ws.eventMutex.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					ws.logger.Error("custom event handler panicked", "event", event.Type, "panic", r)
+					utils.ReportPanic(ws.panicHandler, utils.PanicSubsystemWebSocketCustomHandler, r)
+				}
+			}()
+			handler(event)
+		}()
+	}
+
+	ws.triggerEvent(EventCustom, event)
+}
+
+// matchCustomEvent reports whether an event type matches a registered pattern
+func matchCustomEvent(pattern, eventType string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(eventType, prefix)
+	}
+	return pattern == eventType
+}
@@ -0,0 +1,460 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Handshake headers negotiating frame compression and encryption. The client
+// offers compressors by name and an ephemeral session key signed with its
+// identity key; the server answers with the compressor it picked and its own
+// session key, signed with the server's identity key.
+const (
+	HeaderFrameCompression = "EMSG-Frame-Compression"
+	HeaderSessionKey       = "EMSG-Session-Key"
+	HeaderSessionSignature = "EMSG-Session-Signature"
+)
+
+// HKDF info of the frame keys of each direction
+const (
+	clientFrameKeyInfo = "emsg-frames-v1 client to server"
+	serverFrameKeyInfo = "emsg-frames-v1 server to client"
+)
+
+// frameHeaderSize is the flags byte and frame counter authenticated with each encrypted frame
+const frameHeaderSize = 9
+
+// DefaultMinCompressSize is the smallest frame compressed when FrameOptions sets none
+const DefaultMinCompressSize = 1024
+
+// Flags in the first byte of an encoded binary frame
+const (
+	frameCompressed byte = 1 << iota
+	frameEncrypted
+)
+
+// ErrFrameNotEncrypted is returned for frames received in the clear on a
+// connection that negotiated encryption
+var ErrFrameNotEncrypted = errors.New("frame not encrypted")
+
+// ErrFrameReplayed is returned for encrypted frames whose counter does not
+// increase, i.e. frames replayed or reordered on the connection
+var ErrFrameReplayed = errors.New("frame replayed or out of order")
+
+// FrameCompressor compresses frame payloads. Its name identifies it during negotiation.
+type FrameCompressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails once the output exceeds limit bytes
+	Decompress(data []byte, limit int64) ([]byte, error)
+}
+
+// DeflateCompressor compresses frames with DEFLATE
+type DeflateCompressor struct {
+	Level int // flate compression level (0 = flate.DefaultCompression)
+}
+
+// Name returns "deflate"
+func (dc DeflateCompressor) Name() string {
+	return "deflate"
+}
+
+// Compress deflates data
+func (dc DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	level := dc.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress inflates data, failing past limit bytes
+func (dc DeflateCompressor) Decompress(data []byte, limit int64) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+
+	out, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("decompressed frame exceeds %d bytes", limit)
+	}
+	return out, nil
+}
+
+// FrameOptions selects optional compression and application-layer encryption
+// of frames, on top of TLS. Both are negotiated with the server when
+// connecting; frames are sent plain if the server agrees to neither.
+type FrameOptions struct {
+	Compressors     []FrameCompressor // Offered in order of preference (none = no compression)
+	MinCompressSize int               // Frames smaller than this many bytes are not compressed (0 = DefaultMinCompressSize)
+	Encrypt         bool              // Encrypt frames with a session key agreed with the server for each connection (requires ServerKey)
+	// Identity key the server signs its session key with. The client signs its
+	// own with the key it authenticates with, so a party rewriting the
+	// handshake cannot substitute either.
+	ServerKey ed25519.PublicKey
+	// Fail to connect instead of falling back to plain frames when the server
+	// does not agree to encrypt
+	RequireEncryption bool
+}
+
+// FrameCodec encodes and decodes the frames of one connection as negotiated.
+// Frames that end up neither compressed nor encrypted are sent as text; others
+// are sent as binary frames holding a flags byte, then with encryption a frame
+// counter and the sealed payload. Each direction has its own key and counter,
+// and received counters must increase, so frames cannot be replayed, reordered
+// or reflected back to their sender.
+type FrameCodec struct {
+	compressor  FrameCompressor
+	minCompress int
+	seal        cipher.AEAD // Encrypts sent frames
+	open        cipher.AEAD // Decrypts received frames
+	sent        uint64      // Counter of the last frame sent
+	received    uint64      // Counter of the last frame received
+	maxSize     int64
+	mutex       sync.Mutex
+}
+
+// Compression returns the name of the negotiated compressor, or "" if frames are not compressed
+func (fc *FrameCodec) Compression() string {
+	if fc == nil || fc.compressor == nil {
+		return ""
+	}
+	return fc.compressor.Name()
+}
+
+// Encrypted returns true if frames are encrypted with a session key
+func (fc *FrameCodec) Encrypted() bool {
+	return fc != nil && fc.seal != nil
+}
+
+// Encode returns the WebSocket message type and payload to send for a frame
+func (fc *FrameCodec) Encode(data []byte) (int, []byte, error) {
+	if fc == nil || (fc.compressor == nil && fc.seal == nil) {
+		return websocket.TextMessage, data, nil
+	}
+
+	var flags byte
+	payload := data
+	if fc.compressor != nil && len(data) >= fc.minCompress {
+		compressed, err := fc.compressor.Compress(data)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to compress frame: %w", err)
+		}
+		// Incompressible frames are cheaper to send as they are
+		if len(compressed) < len(data) {
+			payload = compressed
+			flags |= frameCompressed
+		}
+	}
+
+	if fc.seal == nil {
+		if flags == 0 {
+			return websocket.TextMessage, data, nil
+		}
+		return websocket.BinaryMessage, append([]byte{flags}, payload...), nil
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if fc.sent == ^uint64(0) {
+		return 0, nil, fmt.Errorf("frame counter exhausted")
+	}
+	fc.sent++
+	header := make([]byte, frameHeaderSize, frameHeaderSize+len(payload)+fc.seal.Overhead())
+	header[0] = flags | frameEncrypted
+	binary.BigEndian.PutUint64(header[1:], fc.sent)
+	return websocket.BinaryMessage, fc.seal.Seal(header, frameNonce(fc.sent), payload, header), nil
+}
+
+// Decode returns the JSON payload of a received frame
+func (fc *FrameCodec) Decode(messageType int, data []byte) ([]byte, error) {
+	if messageType != websocket.BinaryMessage {
+		if fc.Encrypted() {
+			return nil, ErrFrameNotEncrypted
+		}
+		return data, nil
+	}
+	if fc == nil || len(data) == 0 {
+		return nil, fmt.Errorf("unexpected binary frame")
+	}
+
+	flags, payload := data[0], data[1:]
+	if flags&frameEncrypted != 0 {
+		if fc.open == nil {
+			return nil, fmt.Errorf("encrypted frame without a session key")
+		}
+		if len(data) < frameHeaderSize {
+			return nil, fmt.Errorf("encrypted frame too short")
+		}
+		counter := binary.BigEndian.Uint64(data[1:frameHeaderSize])
+		opened, err := fc.open.Open(nil, frameNonce(counter), data[frameHeaderSize:], data[:frameHeaderSize])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt frame")
+		}
+		fc.mutex.Lock()
+		if counter <= fc.received {
+			fc.mutex.Unlock()
+			return nil, ErrFrameReplayed
+		}
+		fc.received = counter
+		fc.mutex.Unlock()
+		payload = opened
+	} else if fc.Encrypted() {
+		return nil, ErrFrameNotEncrypted
+	}
+
+	if flags&frameCompressed != 0 {
+		if fc.compressor == nil {
+			return nil, fmt.Errorf("compressed frame without a negotiated compressor")
+		}
+		decompressed, err := fc.compressor.Decompress(payload, fc.maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress frame: %w", err)
+		}
+		payload = decompressed
+	}
+	return payload, nil
+}
+
+// frameNonce returns the AEAD nonce of the frame with the given counter. Each
+// key is used in one direction only, so counters never repeat under a key.
+func frameNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[chacha20poly1305.NonceSize-8:], counter)
+	return nonce
+}
+
+// frameOffer is the client's side of a frame negotiation in progress
+type frameOffer struct {
+	options    *FrameOptions
+	nonce      string // Nonce of the handshake's auth header, binding the session keys to it
+	publicKey  []byte
+	privateKey []byte
+}
+
+// offerFrameOptions adds the handshake headers offering the options and
+// returns the offer to complete with the server's answer. The session key is
+// signed with keyPair, the key authHeader was signed with.
+func offerFrameOptions(options *FrameOptions, keyPair *keymgmt.KeyPair, authHeader *auth.AuthHeader, headers http.Header) (*frameOffer, error) {
+	offer := &frameOffer{options: options, nonce: authHeader.Nonce}
+	if options == nil {
+		return offer, nil
+	}
+
+	if len(options.Compressors) > 0 {
+		names := make([]string, len(options.Compressors))
+		for i, compressor := range options.Compressors {
+			names[i] = compressor.Name()
+		}
+		headers.Set(HeaderFrameCompression, strings.Join(names, ", "))
+	}
+	if options.Encrypt {
+		if len(options.ServerKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("frame encryption requires the server's identity key")
+		}
+		publicKey, privateKey, err := generateSessionKey()
+		if err != nil {
+			return nil, err
+		}
+		offer.publicKey, offer.privateKey = publicKey, privateKey
+		signature := keyPair.Sign(sessionTranscript("client", offer.nonce, publicKey, nil))
+		headers.Set(HeaderSessionKey, base64.StdEncoding.EncodeToString(publicKey))
+		headers.Set(HeaderSessionSignature, base64.StdEncoding.EncodeToString(signature))
+	}
+	return offer, nil
+}
+
+// complete builds the connection's codec from the server's handshake response
+func (offer *frameOffer) complete(response http.Header, maxSize int64) (*FrameCodec, error) {
+	options := offer.options
+	if options == nil {
+		return nil, nil
+	}
+	codec := &FrameCodec{minCompress: options.MinCompressSize, maxSize: maxSize}
+	if codec.minCompress == 0 {
+		codec.minCompress = DefaultMinCompressSize
+	}
+
+	if name := strings.TrimSpace(response.Get(HeaderFrameCompression)); name != "" {
+		for _, compressor := range options.Compressors {
+			if compressor.Name() == name {
+				codec.compressor = compressor
+				break
+			}
+		}
+		if codec.compressor == nil {
+			return nil, fmt.Errorf("server chose compressor %q, which was not offered", name)
+		}
+	}
+
+	if offer.privateKey != nil {
+		if serverKey := response.Get(HeaderSessionKey); serverKey != "" {
+			peerKey, err := decodeSessionKey(serverKey)
+			if err != nil {
+				return nil, err
+			}
+			// A session key the server did not sign may have been substituted
+			signature, err := base64.StdEncoding.DecodeString(response.Get(HeaderSessionSignature))
+			transcript := sessionTranscript("server", offer.nonce, offer.publicKey, peerKey)
+			if err != nil || !ed25519.Verify(options.ServerKey, transcript, signature) {
+				return nil, fmt.Errorf("server session key is not signed by the server's identity key")
+			}
+			codec.seal, codec.open, err = deriveFrameKeys(offer.privateKey, peerKey, offer.publicKey, peerKey, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if options.RequireEncryption && !codec.Encrypted() {
+		return nil, fmt.Errorf("server did not agree to encrypt frames")
+	}
+	return codec, nil
+}
+
+// AcceptFrameOptions answers a client's frame negotiation on the server side,
+// e.g. in a Go EMSG server or a test. It picks the first compressor the client
+// offered that the server supports and, given the server's identity key, agrees
+// a session key if the client sent one signed with the key of its Authorization
+// header; verifying that header is left to the caller. Clients check the
+// answer against the identity key's public half. Pass the returned headers to
+// the upgrader and use the codec for the connection's frames.
+func AcceptFrameOptions(request http.Header, compressors []FrameCompressor, identity *keymgmt.KeyPair, maxSize int64) (http.Header, *FrameCodec, error) {
+	response := http.Header{}
+	codec := &FrameCodec{minCompress: DefaultMinCompressSize, maxSize: maxSize}
+
+	for _, name := range strings.Split(request.Get(HeaderFrameCompression), ",") {
+		name = strings.TrimSpace(name)
+		for _, compressor := range compressors {
+			if codec.compressor == nil && name != "" && compressor.Name() == name {
+				codec.compressor = compressor
+			}
+		}
+	}
+	if codec.compressor != nil {
+		response.Set(HeaderFrameCompression, codec.compressor.Name())
+	}
+
+	if clientKey := request.Get(HeaderSessionKey); identity != nil && clientKey != "" {
+		peerKey, err := decodeSessionKey(clientKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		authHeader, err := auth.ParseAuthHeader(request.Get("Authorization"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("session key without a valid auth header: %w", err)
+		}
+		clientIdentity, err := keymgmt.LoadPublicKeyFromBase64(authHeader.PublicKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load client key: %w", err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(request.Get(HeaderSessionSignature))
+		if err != nil || !ed25519.Verify(clientIdentity, sessionTranscript("client", authHeader.Nonce, peerKey, nil), signature) {
+			return nil, nil, fmt.Errorf("client session key is not signed by the client's key")
+		}
+
+		publicKey, privateKey, err := generateSessionKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		codec.seal, codec.open, err = deriveFrameKeys(privateKey, peerKey, peerKey, publicKey, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		signature = identity.Sign(sessionTranscript("server", authHeader.Nonce, peerKey, publicKey))
+		response.Set(HeaderSessionKey, base64.StdEncoding.EncodeToString(publicKey))
+		response.Set(HeaderSessionSignature, base64.StdEncoding.EncodeToString(signature))
+	}
+	return response, codec, nil
+}
+
+// generateSessionKey creates an ephemeral X25519 key pair
+func generateSessionKey() (publicKey, privateKey []byte, err error) {
+	privateKey = make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, privateKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate session key: %w", err)
+	}
+	publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate session key: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+// sessionTranscript is what each side signs: its role, the nonce of the
+// handshake's auth header and the session keys exchanged so far
+func sessionTranscript(role, nonce string, clientKey, serverKey []byte) []byte {
+	return []byte("emsg-session-v1:" + role + ":" + nonce + ":" +
+		base64.StdEncoding.EncodeToString(clientKey) + ":" + base64.StdEncoding.EncodeToString(serverKey))
+}
+
+// deriveFrameKeys agrees the shared secret and derives a key for each
+// direction, returning the ones to seal and open frames with on this side
+func deriveFrameKeys(privateKey, peerKey, clientKey, serverKey []byte, isClient bool) (cipher.AEAD, cipher.AEAD, error) {
+	shared, err := curve25519.X25519(privateKey, peerKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid session key: %w", err)
+	}
+	salt := append(append([]byte{}, clientKey...), serverKey...)
+	derive := func(info string) (cipher.AEAD, error) {
+		key := make([]byte, chacha20poly1305.KeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(info)), key); err != nil {
+			return nil, fmt.Errorf("failed to derive frame key: %w", err)
+		}
+		return chacha20poly1305.New(key)
+	}
+
+	toServer, err := derive(clientFrameKeyInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	toClient, err := derive(serverFrameKeyInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isClient {
+		return toServer, toClient, nil
+	}
+	return toClient, toServer, nil
+}
+
+// decodeSessionKey parses a base64 X25519 session key from a handshake header
+func decodeSessionKey(value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid session key")
+	}
+	return decoded, nil
+}
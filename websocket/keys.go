@@ -0,0 +1,43 @@
+package websocket
+
+import "encoding/json"
+
+const (
+	// EventKeyChanged carries a *KeyEvent when a user published a new key
+	EventKeyChanged WebSocketEvent = "key_changed"
+	// EventKeyRevoked carries a *KeyEvent when one of a user's keys was revoked
+	EventKeyRevoked WebSocketEvent = "key_revoked"
+)
+
+// KeyEvent is a server-pushed notice that a user's published keys changed
+type KeyEvent struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key,omitempty"` // Base64 key concerned, if the server sent it
+	KeyID     string `json:"key_id,omitempty"`     // ID of the key concerned, if the server sent it
+	Reason    string `json:"reason,omitempty"`     // Why a key was revoked, e.g. "compromised"
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// processKeyEvent surfaces key_changed and key_revoked event frames. Revocations
+// that do not say which key was revoked are ignored.
+func (ws *WebSocketClient) processKeyEvent(wsMsg *WebSocketMessage) {
+	var event KeyEvent
+	if err := json.Unmarshal(wsMsg.Data, &event); err != nil || event.Address == "" {
+		ws.logger.Warn("ignoring invalid key event", "event", wsMsg.Event, "error", err)
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = wsMsg.Timestamp
+	}
+
+	switch wsMsg.Event {
+	case "key_changed":
+		ws.triggerEvent(EventKeyChanged, &event)
+	case "key_revoked":
+		if event.PublicKey == "" && event.KeyID == "" {
+			ws.logger.Warn("ignoring key revocation without a key", "address", event.Address)
+			return
+		}
+		ws.triggerEvent(EventKeyRevoked, &event)
+	}
+}
@@ -0,0 +1,34 @@
+package websocket
+
+import "encoding/json"
+
+// EventLabelsChanged carries a *LabelEvent when a message's labels changed on
+// the server, e.g. because another device archived it
+const EventLabelsChanged WebSocketEvent = "labels_changed"
+
+// LabelEvent is a server-pushed notice of a message's labels after a change
+type LabelEvent struct {
+	Address   string   `json:"address"` // Mailbox the message is in
+	MessageID string   `json:"message_id"`
+	Labels    []string `json:"labels"` // Every label the message now has
+	Timestamp int64    `json:"timestamp,omitempty"`
+}
+
+// processLabelEvent surfaces labels_changed event frames
+func (ws *WebSocketClient) processLabelEvent(wsMsg *WebSocketMessage) {
+	var event LabelEvent
+	if err := json.Unmarshal(wsMsg.Data, &event); err != nil || event.MessageID == "" {
+		ws.logger.Warn("ignoring invalid label event", "error", err)
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = wsMsg.Timestamp
+	}
+
+	if ws.notificationManager != nil {
+		if err := ws.notificationManager.NotifyLabelsChanged(event.Address, event.MessageID, event.Labels); err != nil {
+			ws.logger.Warn("failed to notify label change", "message_id", event.MessageID, "error", err)
+		}
+	}
+	ws.triggerEvent(EventLabelsChanged, &event)
+}
@@ -0,0 +1,447 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
+	"github.com/emsg-protocol/emsg-client-sdk/message"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// DefaultStreamWindow is how many frames each side of a stream may have in
+// flight before the other grants more by default
+const DefaultStreamWindow = 64
+
+// ErrStreamClosed is matched by errors for streams closed locally or by the server
+var ErrStreamClosed = errors.New("stream closed")
+
+// StreamOptions configures a logical stream multiplexed over a WebSocket connection
+type StreamOptions struct {
+	Address    string                // Account the stream authenticates as
+	KeyPair    *keymgmt.KeyPair      // Key the stream's auth frame is signed with
+	Delegation *auth.DelegationToken // Presented when KeyPair is a delegate key (optional)
+	GroupID    string                // Only carry this group's traffic (optional)
+	Window     int                   // Frames the server may push before the stream grants more (default DefaultStreamWindow)
+}
+
+// Stream is one account's or group's traffic carried over a shared WebSocket
+// connection. Each stream authenticates on its own and has its own flow control,
+// so a slow stream never holds up the others.
+type Stream struct {
+	ID      string
+	Address string
+	GroupID string
+
+	ws         *WebSocketClient
+	keyPair    *keymgmt.KeyPair
+	delegation *auth.DelegationToken
+	window     int
+
+	frames chan *WebSocketMessage // Capacity window, which the server may not exceed
+	credit chan struct{}          // Signalled when the server grants send credit
+
+	mutex      sync.Mutex
+	sendCredit int   // Frames the stream may still send
+	consumed   int   // Frames received since the last grant to the server
+	err        error // Why the stream closed, or nil
+	done       chan struct{}
+}
+
+// streamOpenFrame is the data of a stream_open control frame
+type streamOpenFrame struct {
+	Address       string `json:"address"`
+	GroupID       string `json:"group_id,omitempty"`
+	Authorization string `json:"authorization"`
+	Window        int    `json:"window"`
+}
+
+// streamWindowFrame is the data of a stream_window frame granting send credit
+type streamWindowFrame struct {
+	Credit int `json:"credit"`
+}
+
+// streamCloseFrame is the data of a stream_close frame
+type streamCloseFrame struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// OpenStream opens a logical stream on the connection, letting several accounts
+// or groups share one WebSocket. The stream authenticates with its own key in a
+// stream_open frame; it may send once the server grants credit, and pushed frames
+// are read with Receive. Streams opened while disconnected are opened on connect,
+// and all streams are opened again after reconnecting.
+func (ws *WebSocketClient) OpenStream(opts StreamOptions) (*Stream, error) {
+	if _, err := utils.ParseEMSGAddress(opts.Address); err != nil {
+		return nil, fmt.Errorf("invalid stream address: %w", err)
+	}
+	if opts.KeyPair == nil {
+		return nil, fmt.Errorf("stream key pair is required")
+	}
+	if opts.Window < 0 {
+		return nil, fmt.Errorf("stream window cannot be negative")
+	}
+	if opts.Window == 0 {
+		opts.Window = DefaultStreamWindow
+	}
+
+	id, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{
+		ID:         id,
+		Address:    utils.NormalizeEMSGAddress(opts.Address),
+		GroupID:    opts.GroupID,
+		ws:         ws,
+		keyPair:    opts.KeyPair,
+		delegation: opts.Delegation,
+		window:     opts.Window,
+		frames:     make(chan *WebSocketMessage, opts.Window),
+		credit:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	ws.streamMutex.Lock()
+	ws.streams[id] = s
+	ws.streamMutex.Unlock()
+
+	if !ws.IsConnected() {
+		return s, nil
+	}
+	frame, err := s.openFrame()
+	if err == nil {
+		err = ws.enqueue(frame)
+	}
+	if err != nil {
+		ws.removeStream(id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Streams returns the open streams, ordered by ID
+func (ws *WebSocketClient) Streams() []*Stream {
+	ws.streamMutex.RLock()
+	defer ws.streamMutex.RUnlock()
+
+	streams := make([]*Stream, 0, len(ws.streams))
+	for _, s := range ws.streams {
+		streams = append(streams, s)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].ID < streams[j].ID })
+	return streams
+}
+
+// SendMessage sends a message on the stream. See SendMessageContext.
+func (s *Stream) SendMessage(msg *message.Message) error {
+	return s.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessageContext sends a message on the stream, waiting until ctx is done
+// for send credit. The server's ack arrives through Receive.
+func (s *Stream) SendMessageContext(ctx context.Context, msg *message.Message) error {
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, &WebSocketMessage{Type: "message", Message: msg, CorrelationID: correlationID})
+}
+
+// SendCustomEvent sends an application event on the stream. See SendCustomEventContext.
+func (s *Stream) SendCustomEvent(eventType string, payload any) error {
+	return s.SendCustomEventContext(context.Background(), eventType, payload)
+}
+
+// SendCustomEventContext sends an application event with a JSON payload on the
+// stream, waiting until ctx is done for send credit
+func (s *Stream) SendCustomEventContext(ctx context.Context, eventType string, payload any) error {
+	if err := ValidateCustomEventType(eventType); err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+	return s.send(ctx, &WebSocketMessage{Type: "custom", Event: eventType, Data: data})
+}
+
+// Receive returns the next frame pushed on the stream, waiting until ctx is done.
+// Reading frames grants the server credit to push more. Once the stream is closed
+// and its frames are read, the error matches ErrStreamClosed.
+func (s *Stream) Receive(ctx context.Context) (*WebSocketMessage, error) {
+	select {
+	case frame := <-s.frames:
+		s.consume()
+		return frame, nil
+	default:
+	}
+
+	select {
+	case frame := <-s.frames:
+		s.consume()
+		return frame, nil
+	case <-s.done:
+		return nil, s.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Credit returns how many frames the stream may send before the server grants more
+func (s *Stream) Credit() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sendCredit
+}
+
+// Close closes the stream, telling the server if connected
+func (s *Stream) Close() error {
+	if !s.ws.removeStream(s.ID) {
+		return fmt.Errorf("stream %s: %w", s.ID, ErrStreamClosed)
+	}
+	s.finish(fmt.Errorf("stream %s: %w", s.ID, ErrStreamClosed))
+
+	if !s.ws.IsConnected() {
+		return nil
+	}
+	frame, err := encodeStreamFrame("stream_close", s.ID, &streamCloseFrame{})
+	if err != nil {
+		return err
+	}
+	return s.ws.enqueue(frame)
+}
+
+// Done returns a channel that is closed once the stream is closed
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream closed, or nil while it is open
+func (s *Stream) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// send queues a frame on the stream once it has send credit
+func (s *Stream) send(ctx context.Context, wsMsg *WebSocketMessage) error {
+	wsMsg.Stream = s.ID
+	wsMsg.Timestamp = time.Now().Unix()
+	frame, err := json.Marshal(wsMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s frame: %w", wsMsg.Type, err)
+	}
+	if int64(len(frame)) > s.ws.maxMessageSize {
+		return fmt.Errorf("%s frame is %d bytes, exceeds limit of %d", wsMsg.Type, len(frame), s.ws.maxMessageSize)
+	}
+
+	if err := s.acquireCredit(ctx); err != nil {
+		return err
+	}
+	if err := s.ws.enqueue(frame); err != nil {
+		// The server never saw the frame, so the credit is still ours
+		s.grant(1)
+		return err
+	}
+	return nil
+}
+
+// acquireCredit takes one frame of send credit, waiting for the server to grant some
+func (s *Stream) acquireCredit(ctx context.Context) error {
+	for {
+		s.mutex.Lock()
+		switch {
+		case s.err != nil:
+			err := s.err
+			s.mutex.Unlock()
+			return err
+		case s.sendCredit > 0:
+			s.sendCredit--
+			s.mutex.Unlock()
+			return nil
+		}
+		s.mutex.Unlock()
+
+		select {
+		case <-s.credit:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// grant adds send credit and wakes a sender waiting for it
+func (s *Stream) grant(credit int) {
+	s.mutex.Lock()
+	s.sendCredit += credit
+	s.mutex.Unlock()
+
+	select {
+	case s.credit <- struct{}{}:
+	default:
+	}
+}
+
+// consume counts a frame read by the application, granting the server more
+// credit once half the window has been read
+func (s *Stream) consume() {
+	s.mutex.Lock()
+	s.consumed++
+	credit := s.consumed
+	if credit < max(s.window/2, 1) || s.err != nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.consumed = 0
+	s.mutex.Unlock()
+
+	frame, err := encodeStreamFrame("stream_window", s.ID, &streamWindowFrame{Credit: credit})
+	if err == nil {
+		err = s.ws.enqueue(frame)
+	}
+	if err != nil {
+		// Grant the frames with the next one read instead
+		s.mutex.Lock()
+		s.consumed += credit
+		s.mutex.Unlock()
+	}
+}
+
+// deliver hands a pushed frame to the stream. Frames beyond the window the
+// stream granted are dropped.
+func (s *Stream) deliver(wsMsg *WebSocketMessage) {
+	select {
+	case s.frames <- wsMsg:
+	default:
+		s.ws.logger.Warn("dropping frame beyond stream window", "stream", s.ID, "type", wsMsg.Type)
+	}
+}
+
+// finish closes the stream with err, keeping the first reason
+func (s *Stream) finish(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+}
+
+// openFrame encodes a stream_open frame with fresh authentication. The server
+// starts with the window less the frames still waiting to be read, and the
+// stream with no send credit until the server grants some.
+func (s *Stream) openFrame() ([]byte, error) {
+	path := "/api/v1/ws/streams/" + s.ID
+	var authHeader *auth.AuthHeader
+	var err error
+	if s.delegation != nil {
+		authHeader, err = auth.GenerateDelegatedAuthHeader(s.keyPair, s.delegation, "OPEN", path)
+	} else {
+		authHeader, err = auth.GenerateAuthHeader(s.keyPair, "OPEN", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate stream auth header: %w", err)
+	}
+
+	s.mutex.Lock()
+	s.sendCredit = 0
+	s.consumed = 0
+	s.mutex.Unlock()
+
+	return encodeStreamFrame("stream_open", s.ID, &streamOpenFrame{
+		Address:       s.Address,
+		GroupID:       s.GroupID,
+		Authorization: authHeader.ToHeaderValue(),
+		Window:        s.window - len(s.frames),
+	})
+}
+
+// removeStream forgets a stream, reporting whether it was open
+func (ws *WebSocketClient) removeStream(id string) bool {
+	ws.streamMutex.Lock()
+	defer ws.streamMutex.Unlock()
+
+	if _, exists := ws.streams[id]; !exists {
+		return false
+	}
+	delete(ws.streams, id)
+	return true
+}
+
+// reopenStreamsInternal queues a stream_open frame for every stream on a new
+// connection (internal method, called with the connection mutex held)
+func (ws *WebSocketClient) reopenStreamsInternal() {
+	for _, s := range ws.Streams() {
+		frame, err := s.openFrame()
+		if err != nil {
+			ws.logger.Warn("failed to reopen stream", "stream", s.ID, "error", err)
+			continue
+		}
+		select {
+		case ws.sendChan <- frame:
+		default:
+			ws.logger.Warn("send buffer full, stream not reopened", "stream", s.ID)
+		}
+	}
+}
+
+// processStreamFrame routes a frame tagged with a stream ID to its stream
+func (ws *WebSocketClient) processStreamFrame(wsMsg *WebSocketMessage) {
+	ws.streamMutex.RLock()
+	s, exists := ws.streams[wsMsg.Stream]
+	ws.streamMutex.RUnlock()
+
+	if !exists {
+		ws.logger.Debug("dropping frame for unknown stream", "stream", wsMsg.Stream, "type", wsMsg.Type)
+		return
+	}
+
+	switch wsMsg.Type {
+	case "stream_window":
+		var data streamWindowFrame
+		if err := json.Unmarshal(wsMsg.Data, &data); err != nil || data.Credit <= 0 {
+			ws.logger.Warn("ignoring invalid stream window", "stream", s.ID)
+			return
+		}
+		s.grant(data.Credit)
+
+	case "stream_close":
+		var data streamCloseFrame
+		json.Unmarshal(wsMsg.Data, &data) // The reason is optional
+		ws.removeStream(s.ID)
+		if data.Reason == "" {
+			data.Reason = "closed by server"
+		}
+		s.finish(fmt.Errorf("stream %s: %w: %s", s.ID, ErrStreamClosed, data.Reason))
+
+	default:
+		s.deliver(wsMsg)
+	}
+}
+
+// encodeStreamFrame encodes a stream control frame
+func encodeStreamFrame(frameType, streamID string, data any) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", frameType, err)
+	}
+	frame, err := json.Marshal(&WebSocketMessage{
+		Type:      frameType,
+		Stream:    streamID,
+		Data:      payload,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s frame: %w", frameType, err)
+	}
+	return frame, nil
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PresenceStatus is a user's availability as announced to their contacts
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceBusy    PresenceStatus = "busy"
+	PresenceOffline PresenceStatus = "offline" // Also used to appear offline while connected
+)
+
+// Validate checks that the status is one of the known presence statuses
+func (s PresenceStatus) Validate() error {
+	switch s {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceOffline:
+		return nil
+	default:
+		return fmt.Errorf("unknown presence status %q", s)
+	}
+}
+
+// SendTyping tells a group's members whether user is typing, in the same event
+// frame the client receives typing indicators in
+func (ws *WebSocketClient) SendTyping(user, groupID string, isTyping bool) error {
+	if groupID == "" {
+		return fmt.Errorf("group ID cannot be empty")
+	}
+	return ws.sendEvent("typing", map[string]any{
+		"user":      user,
+		"group_id":  groupID,
+		"is_typing": isTyping,
+	})
+}
+
+// SendPresence announces user's presence status
+func (ws *WebSocketClient) SendPresence(user string, status PresenceStatus) error {
+	if err := status.Validate(); err != nil {
+		return err
+	}
+	return ws.sendEvent("presence", map[string]any{
+		"user":   user,
+		"status": status,
+	})
+}
+
+// sendEvent queues a protocol event frame over the established connection
+func (ws *WebSocketClient) sendEvent(event string, data map[string]any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+
+	frame, err := json.Marshal(&WebSocketMessage{
+		Type:      "event",
+		Event:     event,
+		Data:      payload,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+	return ws.enqueue(frame)
+}
@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
+)
+
+// subscribableEvents are the built-in frame events a subscription can select.
+// Custom events are selected by type or "namespace.*" pattern.
+var subscribableEvents = map[string]bool{
+	"message":          true,
+	"typing":           true,
+	"presence":         true,
+	"user_joined":      true,
+	"user_left":        true,
+	"delivery_receipt": true,
+	"key_changed":      true,
+	"key_revoked":      true,
+	"labels_changed":   true,
+}
+
+// SubscriptionFilter selects the traffic a subscription receives. Empty fields
+// match everything, so the zero filter subscribes to all traffic.
+type SubscriptionFilter struct {
+	GroupID string   `json:"group_id,omitempty"` // Only frames for this group
+	Sender  string   `json:"sender,omitempty"`   // Only frames from this address
+	Events  []string `json:"events,omitempty"`   // Only these events, e.g. "message", "typing" or "editor.*"
+}
+
+// Validate checks that the sender is an address and the events are known event
+// names or custom event patterns
+func (f *SubscriptionFilter) Validate() error {
+	if f.Sender != "" {
+		if _, err := utils.ParseEMSGAddress(f.Sender); err != nil {
+			return fmt.Errorf("invalid subscription sender: %w", err)
+		}
+	}
+	for _, event := range f.Events {
+		if subscribableEvents[event] {
+			continue
+		}
+		if err := validateCustomEventPattern(event); err != nil {
+			return fmt.Errorf("invalid subscription event %q: not a built-in event or custom event pattern", event)
+		}
+	}
+	return nil
+}
+
+// Subscription is traffic the client asked the server to push
+type Subscription struct {
+	ID        string
+	Filter    SubscriptionFilter
+	CreatedAt time.Time
+}
+
+// subscriptionFrame is the data of a subscribe or unsubscribe control frame
+type subscriptionFrame struct {
+	ID string `json:"id"`
+	SubscriptionFilter
+}
+
+// Subscribe asks the server to push only traffic matching the filter. Once any
+// subscription exists, frames matching none of them are also dropped locally,
+// for servers that push everything. Subscriptions made while disconnected are
+// sent on connect, and all subscriptions are sent again after reconnecting.
+func (ws *WebSocketClient) Subscribe(filter SubscriptionFilter) (*Subscription, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if filter.Sender != "" {
+		filter.Sender = utils.NormalizeEMSGAddress(filter.Sender)
+	}
+	filter.Events = slices.Clone(filter.Events)
+
+	id, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	sub := &Subscription{ID: id, Filter: filter, CreatedAt: time.Now()}
+
+	ws.subscriptionMutex.Lock()
+	ws.subscriptions[id] = sub
+	ws.subscriptionMutex.Unlock()
+
+	if !ws.IsConnected() {
+		return sub, nil
+	}
+	if err := ws.sendSubscriptionFrame("subscribe", sub); err != nil {
+		ws.subscriptionMutex.Lock()
+		delete(ws.subscriptions, id)
+		ws.subscriptionMutex.Unlock()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe cancels a subscription. Removing the last subscription goes back to
+// receiving all traffic.
+func (ws *WebSocketClient) Unsubscribe(id string) error {
+	ws.subscriptionMutex.Lock()
+	sub, exists := ws.subscriptions[id]
+	delete(ws.subscriptions, id)
+	ws.subscriptionMutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("subscription %s not found", id)
+	}
+	if !ws.IsConnected() {
+		return nil
+	}
+	return ws.sendSubscriptionFrame("unsubscribe", &Subscription{ID: sub.ID})
+}
+
+// Subscriptions returns the active subscriptions, oldest first
+func (ws *WebSocketClient) Subscriptions() []*Subscription {
+	ws.subscriptionMutex.RLock()
+	defer ws.subscriptionMutex.RUnlock()
+
+	subs := make([]*Subscription, 0, len(ws.subscriptions))
+	for _, sub := range ws.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+// sendSubscriptionFrame queues a subscribe or unsubscribe control frame
+func (ws *WebSocketClient) sendSubscriptionFrame(frameType string, sub *Subscription) error {
+	frame, err := encodeSubscriptionFrame(frameType, sub)
+	if err != nil {
+		return err
+	}
+	return ws.enqueue(frame)
+}
+
+// resubscribeInternal queues a subscribe frame for every subscription on a new
+// connection (internal method, called with the connection mutex held)
+func (ws *WebSocketClient) resubscribeInternal() {
+	for _, sub := range ws.Subscriptions() {
+		frame, err := encodeSubscriptionFrame("subscribe", sub)
+		if err != nil {
+			ws.logger.Warn("failed to encode subscription", "subscription", sub.ID, "error", err)
+			continue
+		}
+		select {
+		case ws.sendChan <- frame:
+		default:
+			ws.logger.Warn("send buffer full, subscription not restored", "subscription", sub.ID)
+		}
+	}
+}
+
+// encodeSubscriptionFrame encodes a subscription control frame
+func encodeSubscriptionFrame(frameType string, sub *Subscription) ([]byte, error) {
+	data, err := json.Marshal(&subscriptionFrame{ID: sub.ID, SubscriptionFilter: sub.Filter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subscription: %w", err)
+	}
+	frame, err := json.Marshal(&WebSocketMessage{
+		Type:      frameType,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s frame: %w", frameType, err)
+	}
+	return frame, nil
+}
+
+// subscribed reports whether a received frame matches a subscription. Without
+// subscriptions every frame does; acks and other control frames always do.
+func (ws *WebSocketClient) subscribed(wsMsg *WebSocketMessage) bool {
+	ws.subscriptionMutex.RLock()
+	defer ws.subscriptionMutex.RUnlock()
+	if len(ws.subscriptions) == 0 {
+		return true
+	}
+
+	event, groupID, sender, filterable := frameSubscriptionFields(wsMsg)
+	if !filterable {
+		return true
+	}
+	for _, sub := range ws.subscriptions {
+		if sub.Filter.matches(event, groupID, sender) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a frame's event, group and sender pass the filter
+func (f *SubscriptionFilter) matches(event, groupID, sender string) bool {
+	if f.GroupID != "" && f.GroupID != groupID {
+		return false
+	}
+	if f.Sender != "" && f.Sender != sender {
+		return false
+	}
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, pattern := range f.Events {
+		if matchCustomEvent(pattern, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// frameSubscriptionFields returns the event, group and normalized sender of a
+// message, event or custom frame, and false for frames subscriptions do not filter
+func frameSubscriptionFields(wsMsg *WebSocketMessage) (event, groupID, sender string, filterable bool) {
+	switch wsMsg.Type {
+	case "message":
+		if wsMsg.Message == nil {
+			return "", "", "", false
+		}
+		return "message", wsMsg.Message.GroupID, utils.NormalizeEMSGAddress(wsMsg.Message.From), true
+
+	case "event", "custom":
+		var data struct {
+			GroupID string `json:"group_id"`
+			User    string `json:"user"`
+		}
+		json.Unmarshal(wsMsg.Data, &data) // Payloads without these fields match only filters that do not need them
+		sender := wsMsg.From
+		if sender == "" {
+			sender = data.User
+		}
+		if sender != "" {
+			sender = utils.NormalizeEMSGAddress(sender)
+		}
+		return wsMsg.Event, data.GroupID, sender, true
+	}
+	return "", "", "", false
+}
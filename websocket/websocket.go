@@ -3,9 +3,8 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math"
 	"net/http"
 	"net/url"
 	"sync"
@@ -14,9 +13,11 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/emsg-protocol/emsg-client-sdk/auth"
+	"github.com/emsg-protocol/emsg-client-sdk/delivery"
 	"github.com/emsg-protocol/emsg-client-sdk/keymgmt"
 	"github.com/emsg-protocol/emsg-client-sdk/message"
 	"github.com/emsg-protocol/emsg-client-sdk/notifications"
+	"github.com/emsg-protocol/emsg-client-sdk/utils"
 )
 
 // WebSocketEvent represents different types of WebSocket events
@@ -28,21 +29,36 @@ const (
 	EventMessage      WebSocketEvent = "message"
 	EventError        WebSocketEvent = "error"
 	EventReconnecting WebSocketEvent = "reconnecting"
+	EventAck          WebSocketEvent = "ack"
+	// EventSignedReceipt carries a *delivery.SignedReceipt when a recipient's receipt includes a signature
+	EventSignedReceipt WebSocketEvent = "signed_receipt"
 )
 
+// AckInfo is passed to EventAck handlers when the server acknowledges a sent message
+type AckInfo struct {
+	MessageID     string
+	CorrelationID string        // Correlation ID of the acked message frame
+	Latency       time.Duration // Time between queueing the message and receiving the ack
+}
+
 // WebSocketMessage represents a message received over WebSocket
 type WebSocketMessage struct {
-	Type      string           `json:"type"`
-	Message   *message.Message `json:"message,omitempty"`
-	Event     string           `json:"event,omitempty"`
-	Data      json.RawMessage  `json:"data,omitempty"`
-	Timestamp int64            `json:"timestamp"`
+	Type          string           `json:"type"`
+	Message       *message.Message `json:"message,omitempty"`
+	Event         string           `json:"event,omitempty"`
+	Data          json.RawMessage  `json:"data,omitempty"`
+	From          string           `json:"from,omitempty"`           // Sender of a custom event
+	CorrelationID string           `json:"correlation_id,omitempty"` // Pairs a sent message frame with its ack
+	Stream        string           `json:"stream,omitempty"`         // Multiplexed stream the frame belongs to
+	Timestamp     int64            `json:"timestamp"`
 }
 
 // WebSocketClient manages WebSocket connections for real-time updates
 type WebSocketClient struct {
 	serverURL           string
 	keyPair             *keymgmt.KeyPair
+	delegation          *auth.DelegationToken // Presented with the auth header when keyPair is a delegate key
+	authOptions         *auth.HeaderOptions   // Timestamp and expiry of the auth header (nil = defaults)
 	conn                *websocket.Conn
 	notificationManager *notifications.NotificationManager
 
@@ -52,11 +68,35 @@ type WebSocketClient struct {
 	reconnectStrategy *ReconnectStrategy
 	connected         bool
 	connecting        bool
+	userAddress       string // Address of the current or last connection
+	err               error  // Why the last connection was lost, or nil
 	mutex             sync.RWMutex
+	writeMutex        sync.Mutex // gorilla connections support one concurrent writer
+	wg                sync.WaitGroup
+	done              chan struct{}
+	clock             utils.Clock
+	logger            utils.Logger
+	panicHandler      utils.PanicHandler
+
+	// Messages awaiting a server ack, keyed by correlation ID
+	pendingAcks     map[string]*pendingAck
+	ackTimeout      time.Duration
+	deliveryTracker *delivery.DeliveryTracker // Records acks and failed sends (nil = not tracked)
+	ackMutex        sync.Mutex
 
 	// Event handlers
-	eventHandlers map[WebSocketEvent][]func(data interface{})
-	eventMutex    sync.RWMutex
+	eventHandlers  map[WebSocketEvent][]eventHandler
+	customHandlers map[string][]CustomEventHandler
+	nextHandlerID  uint64
+	eventMutex     sync.RWMutex
+
+	// Subscriptions filtering the traffic pushed to this client, keyed by ID
+	subscriptions     map[string]*Subscription
+	subscriptionMutex sync.RWMutex
+
+	// Logical streams multiplexed over the connection, keyed by ID
+	streams     map[string]*Stream
+	streamMutex sync.RWMutex
 
 	// Channels
 	sendChan    chan []byte
@@ -67,6 +107,9 @@ type WebSocketClient struct {
 	writeTimeout   time.Duration
 	pingInterval   time.Duration
 	maxMessageSize int64
+	ioBufferSize   int           // Connection read and write buffer size (0 = gorilla default)
+	frameOptions   *FrameOptions // Compression and encryption offered when connecting (nil = plain frames)
+	codec          *FrameCodec   // Frame encoding negotiated for the current connection
 }
 
 // ReconnectStrategy defines reconnection behavior
@@ -92,6 +135,8 @@ func DefaultReconnectStrategy() *ReconnectStrategy {
 // NewWebSocketClient creates a new WebSocket client
 func NewWebSocketClient(serverURL string, keyPair *keymgmt.KeyPair, notificationManager *notifications.NotificationManager) *WebSocketClient {
 	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	close(done) // Not connected yet
 
 	return &WebSocketClient{
 		serverURL:           serverURL,
@@ -100,7 +145,15 @@ func NewWebSocketClient(serverURL string, keyPair *keymgmt.KeyPair, notification
 		ctx:                 ctx,
 		cancel:              cancel,
 		reconnectStrategy:   DefaultReconnectStrategy(),
-		eventHandlers:       make(map[WebSocketEvent][]func(data interface{})),
+		done:                done,
+		clock:               utils.RealClock{},
+		logger:              utils.NopLogger{},
+		pendingAcks:         make(map[string]*pendingAck),
+		ackTimeout:          DefaultAckTimeout,
+		eventHandlers:       make(map[WebSocketEvent][]eventHandler),
+		customHandlers:      make(map[string][]CustomEventHandler),
+		subscriptions:       make(map[string]*Subscription),
+		streams:             make(map[string]*Stream),
 		sendChan:            make(chan []byte, 100),
 		receiveChan:         make(chan *WebSocketMessage, 100),
 		readTimeout:         60 * time.Second,
@@ -112,6 +165,12 @@ func NewWebSocketClient(serverURL string, keyPair *keymgmt.KeyPair, notification
 
 // Connect establishes a WebSocket connection
 func (ws *WebSocketClient) Connect(userAddress string) error {
+	return ws.ConnectContext(context.Background(), userAddress)
+}
+
+// ConnectContext establishes a WebSocket connection, aborting the handshake if ctx is done.
+// ctx only bounds dialing; use Disconnect to close an established connection.
+func (ws *WebSocketClient) ConnectContext(ctx context.Context, userAddress string) error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 
@@ -144,7 +203,12 @@ func (ws *WebSocketClient) Connect(userAddress string) error {
 
 	// Create request headers with authentication
 	headers := http.Header{}
-	authHeader, err := auth.GenerateAuthHeader(ws.keyPair, "GET", u.Path)
+	var authHeader *auth.AuthHeader
+	if ws.delegation != nil {
+		authHeader, err = auth.GenerateDelegatedAuthHeaderWithOptions(ws.keyPair, ws.delegation, "GET", u.Path, ws.authOptions)
+	} else {
+		authHeader, err = auth.GenerateAuthHeaderWithOptions(ws.keyPair, "GET", u.Path, ws.authOptions)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to generate auth header: %w", err)
 	}
@@ -153,29 +217,62 @@ func (ws *WebSocketClient) Connect(userAddress string) error {
 	// Establish WebSocket connection
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
+		ReadBufferSize:   ws.ioBufferSize,
+		WriteBufferSize:  ws.ioBufferSize,
+	}
+
+	// Offer frame compression and encryption
+	offer, err := offerFrameOptions(ws.frameOptions, ws.keyPair, authHeader, headers)
+	if err != nil {
+		return err
 	}
 
-	conn, _, err := dialer.Dial(u.String(), headers)
+	conn, resp, err := dialer.DialContext(ctx, u.String(), headers)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 
+	codec, err := offer.complete(resp.Header, ws.maxMessageSize)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to negotiate frame options: %w", err)
+	}
+
 	ws.conn = conn
+	ws.codec = codec
 	ws.connected = true
+	ws.userAddress = userAddress
+	ws.err = nil
+
+	// Each connection gets a fresh context so a previous Disconnect does not
+	// prevent reconnecting
+	ws.ctx, ws.cancel = context.WithCancel(context.Background())
+	ws.done = make(chan struct{})
 
 	// Configure connection
-	ws.conn.SetReadLimit(ws.maxMessageSize)
-	ws.conn.SetReadDeadline(time.Now().Add(ws.readTimeout))
-	ws.conn.SetPongHandler(func(string) error {
-		ws.conn.SetReadDeadline(time.Now().Add(ws.readTimeout))
+	conn.SetReadLimit(ws.maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(ws.readTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(ws.readTimeout))
 		return nil
 	})
 
 	// Start goroutines for handling connection
-	go ws.readLoop()
-	go ws.writeLoop()
-	go ws.pingLoop()
-	go ws.messageProcessor()
+	ws.wg.Add(4)
+	go ws.readLoop(ws.ctx, conn, codec)
+	go ws.writeLoop(ws.ctx, conn, codec)
+	go ws.pingLoop(ws.ctx, conn, ws.clock.NewTicker(ws.pingInterval))
+	go ws.messageProcessor(ws.ctx)
+
+	done := ws.done
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+
+	// The server forgets subscriptions and streams with the connection
+	ws.resubscribeInternal()
+	ws.reopenStreamsInternal()
 
 	// Trigger connected event
 	ws.triggerEvent(EventConnected, nil)
@@ -183,25 +280,31 @@ func (ws *WebSocketClient) Connect(userAddress string) error {
 	return nil
 }
 
-// Disconnect closes the WebSocket connection
+// Disconnect closes the WebSocket connection and blocks until all connection
+// goroutines have exited
 func (ws *WebSocketClient) Disconnect() error {
 	ws.mutex.Lock()
-	defer ws.mutex.Unlock()
-
 	if !ws.connected {
+		ws.mutex.Unlock()
 		return fmt.Errorf("not connected")
 	}
 
 	ws.cancel() // Cancel context to stop all goroutines
 
-	if ws.conn != nil {
-		// Send close message
-		ws.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		ws.conn.Close()
-		ws.conn = nil
+	conn := ws.conn
+	ws.conn = nil
+	ws.connected = false
+	done := ws.done
+	ws.mutex.Unlock()
+
+	if conn != nil {
+		// Send close message; closing the connection unblocks readLoop
+		ws.writeFrame(conn, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.Close()
 	}
 
-	ws.connected = false
+	<-done
+	ws.failPendingAcks(nil)
 
 	// Trigger disconnected event
 	ws.triggerEvent(EventDisconnected, nil)
@@ -209,6 +312,103 @@ func (ws *WebSocketClient) Disconnect() error {
 	return nil
 }
 
+// Done returns a channel that is closed once all goroutines of the current
+// connection have exited, after Disconnect or when the connection is lost
+func (ws *WebSocketClient) Done() <-chan struct{} {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return ws.done
+}
+
+// SetKeyPair replaces the key pair used to authenticate future connections.
+// An established connection keeps its original authentication until it is
+// re-established.
+func (ws *WebSocketClient) SetKeyPair(keyPair *keymgmt.KeyPair) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.keyPair = keyPair
+}
+
+// SetDelegationToken authenticates with a delegation token issued to the client's
+// key pair on future connections (nil = authenticate as the account itself)
+func (ws *WebSocketClient) SetDelegationToken(token *auth.DelegationToken) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.delegation = token
+}
+
+// SetAuthHeaderOptions sets the timestamp and expiry of the auth header on future
+// connections, e.g. to correct for the local clock being off
+func (ws *WebSocketClient) SetAuthHeaderOptions(options *auth.HeaderOptions) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.authOptions = options
+}
+
+// SetClock replaces the clock used for ping scheduling.
+// It must be called before Connect.
+func (ws *WebSocketClient) SetClock(clock utils.Clock) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.clock = clock
+}
+
+// SetLogger sets the logger for connection errors and reconnects (nil discards them).
+// It must be called before Connect.
+func (ws *WebSocketClient) SetLogger(logger utils.Logger) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.logger = utils.LoggerOrNop(logger)
+}
+
+// SetPanicHandler sets the hook that receives panics recovered from event handlers.
+// It must be called before Connect.
+func (ws *WebSocketClient) SetPanicHandler(handler utils.PanicHandler) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.panicHandler = handler
+}
+
+// BufferLimits bounds the memory a WebSocket connection holds. Zero fields keep the defaults.
+type BufferLimits struct {
+	QueueSize      int   // Frames buffered in each direction (default 100)
+	MaxMessageSize int64 // Largest frame received or custom event sent (default 1MB)
+	IOBufferSize   int   // Connection read and write buffer size (default 4KB)
+}
+
+// SetBufferLimits replaces the frame queues and buffer sizes. It must be called before Connect.
+func (ws *WebSocketClient) SetBufferLimits(limits *BufferLimits) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if limits.QueueSize > 0 {
+		ws.sendChan = make(chan []byte, limits.QueueSize)
+		ws.receiveChan = make(chan *WebSocketMessage, limits.QueueSize)
+	}
+	if limits.MaxMessageSize > 0 {
+		ws.maxMessageSize = limits.MaxMessageSize
+	}
+	if limits.IOBufferSize > 0 {
+		ws.ioBufferSize = limits.IOBufferSize
+	}
+}
+
+// SetFrameOptions sets the frame compression and encryption offered to the
+// server on each connect. It must be called before Connect.
+func (ws *WebSocketClient) SetFrameOptions(options *FrameOptions) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.frameOptions = options
+}
+
+// FrameCodec returns the frame encoding negotiated for the current or last
+// connection, or nil if frame options were not set
+func (ws *WebSocketClient) FrameCodec() *FrameCodec {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return ws.codec
+}
+
 // IsConnected returns true if the WebSocket is connected
 func (ws *WebSocketClient) IsConnected() bool {
 	ws.mutex.RLock()
@@ -216,39 +416,67 @@ func (ws *WebSocketClient) IsConnected() bool {
 	return ws.connected
 }
 
-// SendMessage sends a message over the WebSocket
-func (ws *WebSocketClient) SendMessage(msg *message.Message) error {
+// enqueue queues an encoded frame for the write loop without blocking
+func (ws *WebSocketClient) enqueue(frame []byte) error {
 	if !ws.IsConnected() {
 		return fmt.Errorf("not connected")
 	}
 
-	wsMsg := &WebSocketMessage{
-		Type:      "message",
-		Message:   msg,
-		Timestamp: time.Now().Unix(),
-	}
-
-	data, err := json.Marshal(wsMsg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
+	ws.mutex.RLock()
+	ctx := ws.ctx
+	ws.mutex.RUnlock()
 
 	select {
-	case ws.sendChan <- data:
+	case ws.sendChan <- frame:
 		return nil
-	case <-ws.ctx.Done():
+	case <-ctx.Done():
 		return fmt.Errorf("connection closed")
 	default:
 		return fmt.Errorf("send buffer full")
 	}
 }
 
+// QueueDepth returns the number of queued outgoing frames and the queue capacity
+func (ws *WebSocketClient) QueueDepth() (int, int) {
+	return len(ws.sendChan), cap(ws.sendChan)
+}
+
+// eventHandler is a registered event handler, identified so it can be removed
+type eventHandler struct {
+	id     uint64
+	handle func(data interface{})
+}
+
 // RegisterEventHandler registers an event handler
 func (ws *WebSocketClient) RegisterEventHandler(event WebSocketEvent, handler func(data interface{})) {
+	ws.AddEventHandler(event, handler)
+}
+
+// AddEventHandler registers an event handler and returns a function that
+// removes it again. Calling the function more than once has no effect.
+func (ws *WebSocketClient) AddEventHandler(event WebSocketEvent, handler func(data interface{})) (remove func()) {
+	ws.eventMutex.Lock()
+	defer ws.eventMutex.Unlock()
+
+	ws.nextHandlerID++
+	id := ws.nextHandlerID
+	ws.eventHandlers[event] = append(ws.eventHandlers[event], eventHandler{id: id, handle: handler})
+	return func() { ws.removeEventHandler(event, id) }
+}
+
+// removeEventHandler removes the handler registered under id
+func (ws *WebSocketClient) removeEventHandler(event WebSocketEvent, id uint64) {
 	ws.eventMutex.Lock()
 	defer ws.eventMutex.Unlock()
 
-	ws.eventHandlers[event] = append(ws.eventHandlers[event], handler)
+	handlers := ws.eventHandlers[event]
+	for i, handler := range handlers {
+		if handler.id == id {
+			// Copy so a trigger iterating the old slice is not affected
+			ws.eventHandlers[event] = append(append([]eventHandler{}, handlers[:i]...), handlers[i+1:]...)
+			return
+		}
+	}
 }
 
 // triggerEvent triggers an event with optional data
@@ -261,94 +489,117 @@ func (ws *WebSocketClient) triggerEvent(event WebSocketEvent, data interface{})
 		go func(h func(data interface{})) {
 			defer func() {
 				if r := recover(); r != nil {
-					log.Printf("WebSocket event handler panicked: %v", r)
+					ws.logger.Error("WebSocket event handler panicked", "event", event, "panic", r)
+					utils.ReportPanic(ws.panicHandler, utils.PanicSubsystemWebSocketEventHandler, r)
 				}
 			}()
 			h(data)
-		}(handler)
+		}(handler.handle)
 	}
 }
 
 // readLoop handles reading messages from the WebSocket
-func (ws *WebSocketClient) readLoop() {
-	defer func() {
-		if ws.reconnectStrategy.EnableReconnect {
-			go ws.reconnect()
-		}
-	}()
+func (ws *WebSocketClient) readLoop(ctx context.Context, conn *websocket.Conn, codec *FrameCodec) {
+	defer ws.wg.Done()
 
 	for {
 		select {
-		case <-ws.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 		}
 
-		_, data, err := ws.conn.ReadMessage()
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket read error: %v", err)
+				ws.logger.Warn("WebSocket read failed", "error", err)
 				ws.triggerEvent(EventError, err)
 			}
+			// Only a loss when the connection was not closed with Disconnect
+			if ctx.Err() == nil {
+				ws.connectionLost(conn, err)
+			}
 			return
 		}
 
+		data, err = codec.Decode(messageType, data)
+		if err != nil {
+			ws.logger.Warn("failed to decode WebSocket frame", "error", err)
+			continue
+		}
+
 		var wsMsg WebSocketMessage
 		if err := json.Unmarshal(data, &wsMsg); err != nil {
-			log.Printf("Failed to unmarshal WebSocket message: %v", err)
+			ws.logger.Warn("failed to unmarshal WebSocket message", "error", err)
 			continue
 		}
 
 		select {
 		case ws.receiveChan <- &wsMsg:
-		case <-ws.ctx.Done():
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
 // writeLoop handles writing messages to the WebSocket
-func (ws *WebSocketClient) writeLoop() {
+func (ws *WebSocketClient) writeLoop(ctx context.Context, conn *websocket.Conn, codec *FrameCodec) {
+	defer ws.wg.Done()
+
 	for {
 		select {
 		case data := <-ws.sendChan:
-			ws.conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout))
-			if err := ws.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Printf("WebSocket write error: %v", err)
+			messageType, frame, err := codec.Encode(data)
+			if err != nil {
+				ws.logger.Warn("failed to encode WebSocket frame", "error", err)
+				continue
+			}
+			if err := ws.writeFrame(conn, messageType, frame); err != nil {
+				ws.logger.Warn("WebSocket write failed", "error", err)
 				return
 			}
-		case <-ws.ctx.Done():
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// writeFrame writes a single frame, serializing writers on the connection
+func (ws *WebSocketClient) writeFrame(conn *websocket.Conn, messageType int, data []byte) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout))
+	return conn.WriteMessage(messageType, data)
+}
+
 // pingLoop sends periodic ping messages
-func (ws *WebSocketClient) pingLoop() {
-	ticker := time.NewTicker(ws.pingInterval)
+func (ws *WebSocketClient) pingLoop(ctx context.Context, conn *websocket.Conn, ticker utils.Ticker) {
+	defer ws.wg.Done()
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			ws.conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout))
-			if err := ws.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("WebSocket ping error: %v", err)
+		case <-ticker.C():
+			if err := ws.writeFrame(conn, websocket.PingMessage, nil); err != nil {
+				ws.logger.Warn("WebSocket ping failed", "error", err)
 				return
 			}
-		case <-ws.ctx.Done():
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
 // messageProcessor processes received messages
-func (ws *WebSocketClient) messageProcessor() {
+func (ws *WebSocketClient) messageProcessor(ctx context.Context) {
+	defer ws.wg.Done()
+
 	for {
 		select {
 		case wsMsg := <-ws.receiveChan:
 			ws.processMessage(wsMsg)
-		case <-ws.ctx.Done():
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -356,23 +607,61 @@ func (ws *WebSocketClient) messageProcessor() {
 
 // processMessage processes a received WebSocket message
 func (ws *WebSocketClient) processMessage(wsMsg *WebSocketMessage) {
+	if wsMsg.Stream != "" {
+		ws.processStreamFrame(wsMsg)
+		return
+	}
+	if !ws.subscribed(wsMsg) {
+		ws.logger.Debug("dropping unsubscribed WebSocket frame", "type", wsMsg.Type, "event", wsMsg.Event)
+		return
+	}
+
 	switch wsMsg.Type {
 	case "message":
+		if wsMsg.Message != nil && wsMsg.Message.IsExpired(time.Now()) {
+			ws.logger.Debug("dropping expired message", "message_id", wsMsg.Message.MessageID)
+			return
+		}
 		if wsMsg.Message != nil && ws.notificationManager != nil {
 			// Trigger message received notification
 			if err := ws.notificationManager.NotifyMessageReceived(wsMsg.Message); err != nil {
-				log.Printf("Failed to notify message received: %v", err)
+				ws.logger.Warn("failed to notify message received", "message_id", wsMsg.Message.MessageID, "error", err)
 			}
 		}
 		ws.triggerEvent(EventMessage, wsMsg.Message)
 
 	case "event":
 		// Handle other events (typing, user joined/left, etc.)
+		if wsMsg.Event == "delivery_receipt" {
+			ws.processSignedReceipt(wsMsg)
+			ws.recordRecipientReceipt(wsMsg)
+		}
+		if wsMsg.Event == "key_changed" || wsMsg.Event == "key_revoked" {
+			ws.processKeyEvent(wsMsg)
+		}
+		if wsMsg.Event == "labels_changed" {
+			ws.processLabelEvent(wsMsg)
+		}
 		ws.processEventMessage(wsMsg)
 
+	case "ack":
+		ws.processAck(wsMsg)
+
+	case "custom":
+		ws.processCustomEvent(wsMsg)
+
 	default:
-		log.Printf("Unknown WebSocket message type: %s", wsMsg.Type)
+		ws.logger.Debug("ignoring unknown WebSocket message type", "type", wsMsg.Type)
+	}
+}
+
+// processSignedReceipt surfaces delivery receipts that carry a recipient signature
+func (ws *WebSocketClient) processSignedReceipt(wsMsg *WebSocketMessage) {
+	var receipt delivery.SignedReceipt
+	if err := json.Unmarshal(wsMsg.Data, &receipt); err != nil || receipt.Signature == "" {
+		return
 	}
+	ws.triggerEvent(EventSignedReceipt, &receipt)
 }
 
 // processEventMessage processes event-type messages
@@ -383,7 +672,7 @@ func (ws *WebSocketClient) processEventMessage(wsMsg *WebSocketMessage) {
 
 	var eventData map[string]interface{}
 	if err := json.Unmarshal(wsMsg.Data, &eventData); err != nil {
-		log.Printf("Failed to unmarshal event data: %v", err)
+		ws.logger.Warn("failed to unmarshal WebSocket event data", "event", wsMsg.Event, "error", err)
 		return
 	}
 
@@ -411,6 +700,13 @@ func (ws *WebSocketClient) processEventMessage(wsMsg *WebSocketMessage) {
 			}
 		}
 
+	case "presence":
+		if user, ok := eventData["user"].(string); ok {
+			if status, ok := eventData["status"].(string); ok {
+				ws.notificationManager.NotifyPresence(user, status)
+			}
+		}
+
 	case "delivery_receipt":
 		if messageID, ok := eventData["message_id"].(string); ok {
 			if recipient, ok := eventData["recipient"].(string); ok {
@@ -422,39 +718,81 @@ func (ws *WebSocketClient) processEventMessage(wsMsg *WebSocketMessage) {
 	}
 }
 
-// reconnect attempts to reconnect with exponential backoff
-func (ws *WebSocketClient) reconnect() {
-	if !ws.reconnectStrategy.EnableReconnect {
+// connectionLost tears down a connection whose read loop failed so that its
+// other goroutines exit and Done is closed. Err reports the cause until the next
+// successful connect.
+func (ws *WebSocketClient) connectionLost(conn *websocket.Conn, err error) {
+	ws.mutex.Lock()
+	if ws.conn != conn {
+		ws.mutex.Unlock()
 		return
 	}
-
-	ws.mutex.Lock()
+	ws.cancel()
+	ws.conn = nil
 	ws.connected = false
+	ws.err = fmt.Errorf("connection lost: %w", err)
 	ws.mutex.Unlock()
 
-	ws.triggerEvent(EventReconnecting, nil)
+	conn.Close()
+	ws.failPendingAcks(err)
+	ws.triggerEvent(EventDisconnected, err)
+}
 
-	for attempt := 0; attempt < ws.reconnectStrategy.MaxRetries; attempt++ {
-		delay := time.Duration(float64(ws.reconnectStrategy.InitialDelay) *
-			math.Pow(ws.reconnectStrategy.BackoffFactor, float64(attempt))) // Exponential backoff
-		if delay > ws.reconnectStrategy.MaxDelay {
-			delay = ws.reconnectStrategy.MaxDelay
-		}
+// Err returns the error that ended the last connection, or nil if the client is
+// connected, was never connected or was closed with Disconnect
+func (ws *WebSocketClient) Err() error {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return ws.err
+}
+
+// Reconnect connects again to the address of the last connection. See ReconnectContext.
+func (ws *WebSocketClient) Reconnect() error {
+	return ws.ReconnectContext(context.Background())
+}
+
+// ReconnectContext connects again to the address of the last connection, e.g.
+// after it was lost, keeping registered event handlers. Backoff between
+// attempts is left to the caller; the client's supervisor applies the
+// ReconnectStrategy.
+func (ws *WebSocketClient) ReconnectContext(ctx context.Context) error {
+	ws.mutex.RLock()
+	userAddress := ws.userAddress
+	ws.mutex.RUnlock()
+
+	if userAddress == "" {
+		return fmt.Errorf("never connected")
+	}
 
-		log.Printf("Reconnecting in %v (attempt %d/%d)", delay, attempt+1, ws.reconnectStrategy.MaxRetries)
-		time.Sleep(delay)
+	ws.triggerEvent(EventReconnecting, nil)
+	return ws.ConnectContext(ctx, userAddress)
+}
 
-		// Try to reconnect (this would need the user address, which we'd need to store)
-		// For now, we'll just trigger an event that the client can handle
-		ws.triggerEvent(EventReconnecting, map[string]interface{}{
-			"attempt":      attempt + 1,
-			"max_attempts": ws.reconnectStrategy.MaxRetries,
-		})
+// errRefreshed ends a connection replaced by Refresh
+var errRefreshed = errors.New("connection refreshed")
+
+// Refresh replaces the current or lost connection with a new one, restoring
+// subscriptions and streams, e.g. after the system slept and the connection may
+// be silently dead. If reconnecting fails, Err reports the dropped connection as
+// lost so a supervisor keeps retrying.
+func (ws *WebSocketClient) Refresh(ctx context.Context) error {
+	ws.mutex.RLock()
+	conn, lost := ws.conn, ws.err != nil
+	ws.mutex.RUnlock()
 
-		// In a real implementation, we'd attempt to reconnect here
-		// For now, we'll break to avoid infinite loops in testing
-		break
+	if conn == nil && !lost {
+		return fmt.Errorf("not connected")
+	}
+	if conn != nil {
+		ws.connectionLost(conn, errRefreshed)
+		<-ws.Done()
 	}
+	return ws.ReconnectContext(ctx)
+}
+
+// GetReconnectStrategy returns the reconnection strategy
+func (ws *WebSocketClient) GetReconnectStrategy() *ReconnectStrategy {
+	return ws.reconnectStrategy
 }
 
 // SetReconnectStrategy sets the reconnection strategy